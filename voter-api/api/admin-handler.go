@@ -0,0 +1,278 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/adllev/Voter-Container/voter-api/db"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// implementation for GET /admin/notifications/dead-letter
+// returns every notification that ran out of delivery attempts
+func (va *VoterAPI) ListNotificationDeadLetters(c *fiber.Ctx) error {
+	deadLetterList, err := va.store(c).GetNotificationDeadLetters()
+	if err != nil {
+		requestLogger(c).Error("Error Getting Dead Letters", "error", err)
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+
+	return c.JSON(emptyIfNil(deadLetterList))
+}
+
+// implementation for POST /admin/notifications/dead-letter/:id/requeue
+// puts a dead-lettered notification back on the retry queue
+func (va *VoterAPI) RequeueNotificationDeadLetter(c *fiber.Ctx) error {
+	item, err := va.store(c).RequeueNotificationDeadLetter(c.Params("id"))
+	if err != nil {
+		requestLogger(c).Error("Error requeueing dead letter", "error", err)
+		return dbError(err)
+	}
+
+	return c.JSON(item)
+}
+
+// implementation for GET /admin/anomalies
+// returns the most recent anomalies, ?limit= defaults to 100
+func (va *VoterAPI) ListAnomalies(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 100)
+	if limit <= 0 {
+		return fiber.NewError(http.StatusBadRequest, "limit must be positive")
+	}
+
+	anomalyList, err := va.store(c).GetAnomalies(limit)
+	if err != nil {
+		requestLogger(c).Error("Error Getting Anomalies", "error", err)
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+
+	return c.JSON(emptyIfNil(anomalyList))
+}
+
+// actor returns who is making an administrative request, for the audit
+// log.  The subject of the bearer token wins over the X-Actor header
+func actor(c *fiber.Ctx) string {
+	if claims := claims(c); claims != nil && claims.Subject != "" {
+		return claims.Subject
+	}
+	if name := c.Get("X-Actor"); name != "" {
+		return name
+	}
+	return c.IP()
+}
+
+// audit appends an administrative action to the audit log.  A failure to
+// write it is logged but does not fail the request, the action is done
+func (va *VoterAPI) audit(c *fiber.Ctx, action string, voterId int, detail string) {
+	entry := db.AuditEntry{
+		Action:  action,
+		VoterId: voterId,
+		Actor:   actor(c),
+		Detail:  detail,
+	}
+	if err := va.store(c).AppendAudit(entry); err != nil {
+		requestLogger(c).Error("Error writing audit log", "error", err)
+	}
+}
+
+// implementation for POST /admin/voters/:id/freeze and
+// POST /admin/voters/:id/unfreeze.  A frozen voter can still be read but
+// every write to it returns 423 until it is unfrozen
+func (va *VoterAPI) freezeVoter(c *fiber.Ctx, frozen bool) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	voter, err := va.store(c).SetVoterFrozen(id, frozen)
+	if err != nil {
+		requestLogger(c).Error("Error freezing voter", "error", err)
+		return dbError(err)
+	}
+
+	action := "voter.frozen"
+	if !frozen {
+		action = "voter.unfrozen"
+	}
+	va.audit(c, action, id, c.Query("reason"))
+
+	return c.JSON(voter)
+}
+
+func (va *VoterAPI) FreezeVoter(c *fiber.Ctx) error {
+	return va.freezeVoter(c, true)
+}
+
+func (va *VoterAPI) UnfreezeVoter(c *fiber.Ctx) error {
+	return va.freezeVoter(c, false)
+}
+
+// implementation for GET /admin/audit
+// returns the most recent audit log entries, ?limit= defaults to 100
+func (va *VoterAPI) ListAuditLog(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 100)
+	if limit <= 0 {
+		return fiber.NewError(http.StatusBadRequest, "limit must be positive")
+	}
+
+	auditLog, err := va.store(c).GetAuditLog(limit)
+	if err != nil {
+		requestLogger(c).Error("Error Getting Audit Log", "error", err)
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+
+	return c.JSON(emptyIfNil(auditLog))
+}
+
+// implementation for GET /admin/stats
+// returns the stats of the redis server the voters are stored in
+func (va *VoterAPI) GetStoreStats(c *fiber.Ctx) error {
+	stats, err := va.store(c).GetStoreStats()
+	if err != nil {
+		requestLogger(c).Error("Error Getting Store Stats", "error", err)
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+
+	return c.JSON(stats)
+}
+
+// implementation for GET /admin/keys
+// returns how many keys there are of every kind, by key prefix, to spot
+// what is taking up the space
+func (va *VoterAPI) GetKeyCounts(c *fiber.Ctx) error {
+	counts, err := va.store(c).CountKeysByPrefix()
+	if err != nil {
+		requestLogger(c).Error("Error Counting Keys", "error", err)
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+
+	return c.JSON(counts)
+}
+
+// implementation for POST /admin/indexes/cleanup
+// removes index entries that point at deleted voters, without the
+// downtime of a full reindex
+func (va *VoterAPI) CleanupIndexes(c *fiber.Ctx) error {
+	cleanup, err := va.store(c).CleanupOrphanedIndexes()
+	if err != nil {
+		requestLogger(c).Error("Error cleaning up indexes", "error", err)
+		return dbError(err)
+	}
+
+	va.audit(c, "indexes.cleaned", 0,
+		fmt.Sprintf("voterIndex=%d emailIndex=%d", cleanup.VoterIndex, cleanup.EmailIndex))
+	return c.JSON(cleanup)
+}
+
+// implementation for POST /admin/reindex
+// rebuilds every index from the stored voters
+func (va *VoterAPI) Reindex(c *fiber.Ctx) error {
+	count, err := va.store(c).RebuildIndexes()
+	if err != nil {
+		requestLogger(c).Error("Error rebuilding indexes", "error", err)
+		return dbError(err)
+	}
+
+	va.audit(c, "indexes.rebuilt", 0, fmt.Sprintf("voters=%d", count))
+	return c.JSON(fiber.Map{"voters": count})
+}
+
+// implementation for POST /admin/snapshot
+// starts a background snapshot of redis, 202 since it is written after
+// the response.  409 if a snapshot is already being written
+func (va *VoterAPI) PostSnapshot(c *fiber.Ctx) error {
+	if err := va.store(c).TriggerSnapshot(); err != nil {
+		requestLogger(c).Error("Error triggering snapshot", "error", err)
+		return dbError(err)
+	}
+
+	va.audit(c, "snapshot.triggered", 0, "")
+	return c.SendStatus(http.StatusAccepted)
+}
+
+// implementation for GET /admin/maintenance
+// returns whether maintenance mode is on, why and since when
+func (va *VoterAPI) GetMaintenance(c *fiber.Ctx) error {
+	maintenance, err := va.store(c).GetMaintenance()
+	if err != nil {
+		requestLogger(c).Error("Error Getting Maintenance Mode", "error", err)
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+
+	return c.JSON(maintenance)
+}
+
+// implementation for PUT /admin/maintenance
+// switches maintenance mode on or off for every replica, for example
+// {"enabled": true, "reason": "redis migration"}.  While it is on every
+// write returns 503
+func (va *VoterAPI) PutMaintenance(c *fiber.Ctx) error {
+	var req struct {
+		Enabled *bool  `json:"enabled"`
+		Reason  string `json:"reason"`
+	}
+	if err := parseBody(c, &req); err != nil {
+		return err
+	}
+	if req.Enabled == nil {
+		return validationError([]fieldError{{Field: "enabled", Reason: "is required"}})
+	}
+
+	maintenance := db.Maintenance{Enabled: *req.Enabled}
+	if maintenance.Enabled {
+		maintenance.Reason = req.Reason
+		maintenance.Since = time.Now().UTC()
+		maintenance.Actor = actor(c)
+	}
+	if err := va.store(c).SetMaintenance(maintenance); err != nil {
+		requestLogger(c).Error("Error setting maintenance mode", "error", err)
+		return dbError(err)
+	}
+
+	action := "maintenance.started"
+	if !maintenance.Enabled {
+		action = "maintenance.ended"
+	}
+	va.audit(c, action, 0, req.Reason)
+
+	return c.JSON(maintenance)
+}
+
+// implementation for GET /admin/voters/:id/history/verify
+// recomputes the hash chain of the vote history of a voter, valid is false
+// when it was changed in redis behind the API, breaks says where
+func (va *VoterAPI) VerifyVoterHistory(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	result, err := va.store(c).VerifyHistory(id)
+	if err != nil {
+		requestLogger(c).Info("Error verifying voter history", "voterId", id, "error", err)
+		return dbError(err)
+	}
+	if !result.Valid {
+		requestLogger(c).Warn("Voter history does not verify", "voterId", id, "breaks", len(result.Breaks))
+	}
+
+	return c.JSON(result)
+}
+
+// implementation for GET /admin/history/verify
+// checks the hash chain of every voter, returns the ones that do not
+// verify and how many were checked
+func (va *VoterAPI) VerifyAllHistories(c *fiber.Ctx) error {
+	broken, checked, err := va.store(c).VerifyAllHistories()
+	if err != nil {
+		requestLogger(c).Error("Error verifying voter histories", "error", err)
+		return dbError(err)
+	}
+	if len(broken) > 0 {
+		requestLogger(c).Warn("Voter histories do not verify", "voters", len(broken))
+	}
+
+	return c.JSON(fiber.Map{"checked": checked, "broken": broken})
+}
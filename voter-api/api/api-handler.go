@@ -1,26 +1,336 @@
 package api
 
 import (
-	"log"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
 
+	"github.com/adllev/Voter-Container/voter-api/auth"
+	"github.com/adllev/Voter-Container/voter-api/cards"
+	"github.com/adllev/Voter-Container/voter-api/config"
 	"github.com/adllev/Voter-Container/voter-api/db"
+	"github.com/adllev/Voter-Container/voter-api/jobs"
+	"github.com/adllev/Voter-Container/voter-api/notifications"
+	"github.com/adllev/Voter-Container/voter-api/reminders"
+	"github.com/adllev/Voter-Container/voter-api/upstream"
+	"github.com/adllev/Voter-Container/voter-api/webhooks"
 	"github.com/gofiber/fiber/v2"
 )
 
+// Version is the build version reported by the health check, it can be
+// set at build time with -ldflags "-X .../voter-api/api.Version=1.2.3"
+var Version = "1.0.0"
+
+// DegradedPingLatency is how slow a redis PING can be before the health
+// check reports the service as degraded
+const DegradedPingLatency = 250 * time.Millisecond
+
+// startTime is used to report the process uptime
+var startTime = time.Now()
+
 // The api package creates and maintains a reference to the data handler
 // this is a good design practice
 type VoterAPI struct {
-	db *db.Voter
+	log      *slog.Logger
+	db       *db.Voter
+	notify   *notifications.Dispatcher
+	webhooks *webhooks.Dispatcher
+	cards    *cards.Signer
+	kiosks   *cards.Signer
+	bounces  *cards.Signer
+	verify   *cards.Signer
+	receipts *cards.Signer
+	ballots  *cards.Signer
+
+	//verificationTTL is how long an email verification link works
+	verificationTTL time.Duration
+
+	//undoWindow is how long a voter can withdraw a vote, 0 to not let them
+	undoWindow time.Duration
+
+	//Configured capacity limits by cardinality series, see GetCapacity
+	capacityLimits map[string]int64
+
+	//adminToken gates admin only features such as replay capture
+	adminToken string
+
+	//jwt verifies bearer tokens, it is nil when authentication is off.
+	//publicReads lets GET requests through without a token
+	jwt         *auth.JWTVerifier
+	apiKeys     *auth.APIKeys
+	publicReads bool
+
+	//oidc logs admins in with the identity provider, nil if not configured
+	oidc *auth.OIDC
+
+	//Per client rate limits, see RateLimiter
+	rateLimit config.RateLimitConfig
+	voteLimit config.RateLimitConfig
+
+	//tenantDomain is the domain whose subdomains name tenants, tenants
+	//are the accepted tenant ids, any when empty.  See Tenant
+	tenantDomain string
+	tenants      map[string]bool
+
+	//Share of successful reads that are access logged, see RequestLogger
+	accessLogSample float64
+
+	//pollAPI is where votes are checked for an existing poll, nil to use
+	//the polls stored here.  See checkPollRef
+	pollAPI *pollAPI
+
+	//freezeResults keeps the results of closed polls, see GetPollResults
+	freezeResults bool
+
+	//analyticsMinGroup is the smallest group GetTurnoutBreakdown reports
+	analyticsMinGroup int
+
+	//sync pulls the voters from the registration system, nil when no
+	//upstream is configured
+	sync *upstream.Syncer
+
+	//jobs runs the background jobs on one replica at a time, checking
+	//for due ones every jobsInterval
+	jobs         *jobs.Scheduler
+	jobsInterval time.Duration
+
+	//Configured defaults of the feature flags, see Feature
+	features map[string]bool
+
+	//When readOnly is set every mutating request is rejected, see ReadOnlyGuard
+	readOnly       atomic.Bool
+	readOnlyReason atomic.Value
+}
+
+// New is a constructor function that returns a pointer to a new VoterAPI,
+// everything it and the db layer log goes to logger.  It is configured
+// from the environment with config.FromEnv
+func New(logger *slog.Logger) (*VoterAPI, error) {
+	cfg, err := config.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return NewWithConfig(cfg, logger)
 }
 
-func New() (*VoterAPI, error) {
-	dbHandler, err := db.New()
+// NewWithConfig returns a VoterAPI using the settings of cfg
+func NewWithConfig(cfg config.Config, logger *slog.Logger) (*VoterAPI, error) {
+	logger.Debug("Using redis", "addr", cfg.Redis.Addr)
+	dbHandler, err := db.NewWithConfig(cfg.Redis, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	//Messages go out on the channels their event is routed to, email
+	//through SMTP when it is configured and to the log otherwise.  Every
+	//notification goes through the dispatcher so the suppression list is
+	//always checked before anything is sent
+	channels, err := notifications.NewChannels(cfg.Notifications.Channels)
+	if err != nil {
+		return nil, err
+	}
+	notifier, err := notifications.NewRouter(channels, cfg.Notifications.Routes, cfg.Notifications.Default)
+	if err != nil {
+		return nil, err
+	}
+	notify := notifications.NewDispatcher(notifier, dbHandler, dbHandler)
+
+	cardSigner, err := cards.NewSigner("CARD_SIGNING_KEY", cfg.SigningKeys.Card)
+	if err != nil {
+		return nil, err
+	}
+
+	//Offline kiosks sign the batches they upload with their own key, so
+	//a kiosk can not forge voter cards
+	kioskSigner, err := cards.NewSigner("KIOSK_SIGNING_KEY", cfg.SigningKeys.Kiosk)
+	if err != nil {
+		return nil, err
+	}
+
+	//The mail provider signs the bounces it reports, anyone could
+	//suppress any address otherwise
+	bounceSigner, err := cards.NewSigner("BOUNCE_SIGNING_KEY", cfg.SigningKeys.Bounce)
+	if err != nil {
+		return nil, err
+	}
+
+	//Email verification links are signed with a key of their own, a
+	//voter card is not a proof of owning the email address
+	verifySigner, err := cards.NewSigner("VERIFICATION_SIGNING_KEY", cfg.SigningKeys.Verification)
+	if err != nil {
+		return nil, err
+	}
+
+	//Vote receipts are signed with yet another key, they are shown to
+	//voters and must not help forging anything else
+	receiptSigner, err := cards.NewSigner("RECEIPT_SIGNING_KEY", cfg.SigningKeys.Receipt)
+	if err != nil {
+		return nil, err
+	}
+
+	//Secret ballots carry a token derived from the voter with this key,
+	//without it nobody with access to redis can tell whose ballot it is
+	ballotSigner, err := cards.NewSigner("BALLOT_SIGNING_KEY", cfg.SigningKeys.Ballot)
+	if err != nil {
+		return nil, err
+	}
+
+	//Static API keys come from the config, the ones issued with
+	//POST /admin/apikeys are looked up in redis
+	apiKeys, err := auth.NewAPIKeys(cfg.Auth.APIKeys, dbHandler)
+	if err != nil {
+		return nil, err
+	}
+
+	//Logging in with the identity provider issues a session token signed
+	//with JWT_SIGNING_KEY, so login needs it too
+	jwtVerifier := auth.NewJWTVerifier(cfg.Auth.JWTSigningKey, cfg.Auth.JWTIssuer)
+	var oidcLogin *auth.OIDC
+	if cfg.Auth.OIDC.Enabled() {
+		if jwtVerifier == nil {
+			logger.Warn("OIDC is configured but JWT_SIGNING_KEY is not, login is disabled")
+		}
+		oidcLogin = auth.NewOIDC(cfg.Auth.OIDC)
+	}
+
+	if cfg.Auth.Mode == config.AuthModeRequired && jwtVerifier == nil && !apiKeys.HasStatic() {
+		return nil, errors.New("AUTH_MODE is required but neither JWT_SIGNING_KEY nor API_KEYS is set")
+	}
+
+	tenants := make(map[string]bool)
+	for _, tenantID := range cfg.Tenants.Allowed {
+		tenants[tenantID] = true
+	}
+
+	syncer := upstream.NewSyncer(dbHandler, cfg.Sync.URL)
+	scheduler, err := newJobScheduler(cfg, dbHandler, syncer, reminders.NewScheduler(dbHandler, notify, cfg.Reminders.Lead))
 	if err != nil {
 		return nil, err
 	}
 
-	return &VoterAPI{db: dbHandler}, nil
+	return &VoterAPI{
+		log:               logger,
+		db:                dbHandler,
+		notify:            notify,
+		webhooks:          webhooks.NewDispatcher(dbHandler),
+		cards:             cardSigner,
+		kiosks:            kioskSigner,
+		bounces:           bounceSigner,
+		verify:            verifySigner,
+		receipts:          receiptSigner,
+		ballots:           ballotSigner,
+		verificationTTL:   cfg.VerificationTTL,
+		undoWindow:        cfg.UndoWindow,
+		capacityLimits:    cfg.Capacity.Limits(),
+		adminToken:        cfg.Auth.AdminToken,
+		jwt:               jwtVerifier,
+		apiKeys:           apiKeys,
+		oidc:              oidcLogin,
+		rateLimit:         cfg.RateLimits.Requests,
+		voteLimit:         cfg.RateLimits.Votes,
+		publicReads:       cfg.Auth.PublicReads,
+		features:          cfg.Features,
+		accessLogSample:   cfg.AccessLogSample,
+		tenantDomain:      cfg.Tenants.Domain,
+		tenants:           tenants,
+		pollAPI:           newPollAPI(cfg.PollAPIURL),
+		freezeResults:     cfg.FreezeResults,
+		analyticsMinGroup: cfg.AnalyticsMinGroup,
+		sync:              syncer,
+		jobs:              scheduler,
+		jobsInterval:      cfg.Jobs.Interval,
+	}, nil
+}
+
+// store returns the db handler for the tenant of the request, bound to
+// the context of the request so its redis calls are abandoned when the
+// request times out, see Timeout.  Stream writers run after the handler
+// returned, they use tenantStore
+func (va *VoterAPI) store(c *fiber.Ctx) *db.Voter {
+	return va.db.WithContext(c.UserContext()).WithTenant(tenant(c))
+}
+
+// tenantStore returns the db handler for the tenant of the request that
+// is not tied to the request context
+func (va *VoterAPI) tenantStore(c *fiber.Ctx) *db.Voter {
+	return va.db.WithTenant(tenant(c))
+}
+
+// CheckSchemaVersion makes sure the data in redis was not written by a newer
+// release than this one, see db.CheckSchemaVersion
+func (va *VoterAPI) CheckSchemaVersion() error {
+	_, err := va.db.CheckSchemaVersion()
+	return err
+}
+
+// SetReadOnly turns read-only mode on or off, the reason is returned to
+// clients whose writes are rejected
+func (va *VoterAPI) SetReadOnly(readOnly bool, reason string) {
+	va.readOnlyReason.Store(reason)
+	va.readOnly.Store(readOnly)
+}
+
+// maintenanceRoute is how maintenance mode is switched off again, so it
+// is the one write ReadOnlyGuard lets through
+const maintenanceRoute = "PUT /admin/maintenance"
+
+// ReadOnlyGuard is a middleware that rejects every request that could
+// change data with a 503 while read-only mode or maintenance mode is on.
+// Read-only mode is set by this replica at startup, maintenance mode by
+// an admin for all of them.  Reads keep working
+func (va *VoterAPI) ReadOnlyGuard(c *fiber.Ctx) error {
+	if isRead(c) || routeKey(c) == maintenanceRoute {
+		return c.Next()
+	}
+
+	if va.readOnly.Load() {
+		reason, _ := va.readOnlyReason.Load().(string)
+		return newAPIError(http.StatusServiceUnavailable, "read_only", "Service is read-only: "+reason, nil)
+	}
+
+	//If redis can not be read the write is not going to work either, let
+	//it fail on its own
+	maintenance, err := va.store(c).GetMaintenance()
+	if err != nil {
+		requestLogger(c).Warn("Could not read maintenance mode", "error", err)
+		return c.Next()
+	}
+	if maintenance.Enabled {
+		return newAPIError(http.StatusServiceUnavailable, "maintenance",
+			"Down for maintenance, only reads are served: "+maintenance.Reason, nil)
+	}
+
+	return c.Next()
+}
+
+// EnsureIndexes builds any missing db indexes, it is called at startup
+func (va *VoterAPI) EnsureIndexes() error {
+	return va.db.EnsureIndexes()
+}
+
+// WaitForRedis blocks until the datastore answers or the timeout expires
+func (va *VoterAPI) WaitForRedis(ctx context.Context, timeout time.Duration) error {
+	return va.db.WaitForRedis(ctx, timeout)
+}
+
+// StartBackground starts the background workers the API relies on, they
+// run until the context is cancelled
+func (va *VoterAPI) StartBackground(ctx context.Context) {
+	//Failed notifications are redelivered
+	go va.notify.RunRetries(ctx, 10*time.Second)
+
+	//Changes are delivered to the registered webhooks
+	go va.webhooks.Run(ctx, 10*time.Second)
+
+	//Snapshots, the capacity samples, the sync, the reminders and the
+	//index maintenance run on their schedules, see newJobScheduler
+	go va.jobs.Run(ctx, va.jobsInterval)
 }
 
 //Below we implement the API functions.  Some of the framework
@@ -34,25 +344,147 @@ func New() (*VoterAPI, error) {
 //   4) How to return an error code and abort the request.  This is
 //	  done using the c.AbortWithStatus() function
 
+// emptyIfNil converts a nil slice into an empty one.  The database returns
+// nil when there is nothing to return, and we always want the json to be
+// [] rather than null for collections
+func emptyIfNil[T any](list []T) []T {
+	if list == nil {
+		return make([]T, 0)
+	}
+	return list
+}
+
+// parentVoter loads the voter named by the :id path parameter for the
+// sub-resource handlers (polls etc).  A bad id is a 400 and a missing
+// voter is a 404 that says it was the voter that could not be found, so
+// clients can tell it apart from an empty or missing sub-resource
+func (va *VoterAPI) parentVoter(c *fiber.Ctx) (db.VoterItem, error) {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return db.VoterItem{}, fiber.NewError(http.StatusBadRequest)
+	}
+
+	voter, err := va.store(c).GetVoter(id)
+	if errors.Is(err, db.ErrNotFound) {
+		return db.VoterItem{}, fiber.NewError(http.StatusNotFound, "Voter not found")
+	}
+	if err != nil {
+		requestLogger(c).Error("Error getting voter", "error", err)
+		return db.VoterItem{}, dbError(err)
+	}
+
+	return voter, nil
+}
+
+// Page sizes for GET /voters?limit=
+const (
+	DefaultPageLimit = 50
+	MaxPageLimit     = 1000
+)
+
+// voterPage is the response body of a paginated GET /voters.  NextCursor
+// is empty on the last page
+type voterPage struct {
+	Voters     []db.VoterItem `json:"voters"`
+	NextCursor string         `json:"nextCursor,omitempty"`
+}
+
 // implementation for GET /todo
-// returns all todos
+// returns all todos.  If limit or cursor is passed, for example
+// GET /voters?limit=50&cursor=..., one page is returned instead along with
+// the cursor of the next page (also sent as a Link header).  The list can
+// be filtered and sorted with ?email=, ?name_contains=, ?group=<groupId>,
+// ?sort=id|name|email and ?order=asc|desc, these can not be combined with
+// paging yet.  With Accept: text/csv the (filtered) list is exported as
+// CSV instead
 func (va *VoterAPI) ListAllVoters(c *fiber.Ctx) error {
+	query := db.VoterQuery{
+		Email:        c.Query("email"),
+		NameContains: c.Query("name_contains"),
+		GroupId:      c.QueryInt("group"),
+		Sort:         c.Query("sort"),
+		Order:        c.Query("order"),
+	}
+	filtered := query != db.VoterQuery{}
 
-	voterList, err := va.db.GetAllVoters()
+	//The list is offered as JSON or as a CSV export, caches have to keep
+	//the two apart
+	c.Vary(fiber.HeaderAccept)
+	if c.Accepts(fiber.MIMEApplicationJSON, MIMETextCSV) == MIMETextCSV {
+		return va.exportVotersCSV(c, query)
+	}
+
+	if c.Query("limit") != "" || c.Query("cursor") != "" {
+		if filtered {
+			return fiber.NewError(http.StatusBadRequest,
+				"Filtering and sorting can not be combined with limit or cursor")
+		}
+		return va.listVotersPage(c)
+	}
+
+	if filtered {
+		voterList, err := va.store(c).GetVoters(query)
+		if err != nil {
+			requestLogger(c).Error("Error Getting Voters", "error", err)
+			return dbError(err)
+		}
+		setTotalCount(c, len(voterList))
+		return sendResource(c, emptyIfNil(voterList))
+	}
+
+	voterList, err := va.store(c).GetAllVoters()
 	if err != nil {
-		log.Println("Error Getting All Voters: ", err)
-		return fiber.NewError(http.StatusNotFound,
-			"Error Getting All Voters")
+		requestLogger(c).Error("Error Getting All Voters", "error", err)
+		return dbError(err)
 	}
 	//Note that the database returns a nil slice if there are no items
 	//in the database.  We need to convert this to an empty slice
 	//so that the JSON marshalling works correctly.  We want to return
 	//an empty slice, not a nil slice. This will result in the json being []
-	if voterList == nil {
-		voterList = make([]db.VoterItem, 0)
+	setTotalCount(c, len(voterList))
+	return sendResource(c, emptyIfNil(voterList))
+}
+
+// listVotersPage returns one page of voters, see ListAllVoters
+func (va *VoterAPI) listVotersPage(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", DefaultPageLimit)
+	if limit <= 0 || limit > MaxPageLimit {
+		return fiber.NewError(http.StatusBadRequest,
+			fmt.Sprintf("limit must be between 1 and %d", MaxPageLimit))
+	}
+
+	//The cursor is opaque to clients, today it is the last voter id of
+	//the previous page
+	afterID := 0
+	if cursor := c.Query("cursor"); cursor != "" {
+		var err error
+		if afterID, err = strconv.Atoi(cursor); err != nil || afterID < 0 {
+			return fiber.NewError(http.StatusBadRequest, "invalid cursor")
+		}
+	}
+
+	voterList, nextCursor, err := va.store(c).GetVotersPage(afterID, limit)
+	if err != nil {
+		requestLogger(c).Error("Error Getting Voters Page", "error", err)
+		return dbError(err)
 	}
 
-	return c.JSON(voterList)
+	//The total is all voters, not just the ones on this page
+	total, err := va.store(c).CountVoters()
+	if err != nil {
+		requestLogger(c).Error("Error Counting Voters", "error", err)
+		return dbError(err)
+	}
+	setTotalCount(c, total)
+
+	page := voterPage{Voters: emptyIfNil(voterList)}
+	if nextCursor != 0 {
+		page.NextCursor = strconv.Itoa(nextCursor)
+		next := fmt.Sprintf("<%s?limit=%d&cursor=%s>; rel=\"next\"", c.Path(), limit, page.NextCursor)
+		c.Set(fiber.HeaderLink, next)
+	}
+
+	return sendResource(c, page)
 }
 
 // implementation for GET /todo/:id
@@ -69,15 +501,15 @@ func (va *VoterAPI) GetVoter(c *fiber.Ctx) error {
 
 	//Note that ParseInt always returns an int64, so we have to
 	//convert it to an int before we can use it.
-	voter, err := va.db.GetVoter(id)
+	voter, err := va.store(c).GetVoter(id)
 	if err != nil {
-		log.Println("Voter not found: ", err)
-		return fiber.NewError(http.StatusNotFound)
+		requestLogger(c).Info("Voter not found", "error", err)
+		return dbError(err)
 	}
 
 	//Git will automatically convert the struct to JSON
 	//and set the content-type header to application/json
-	return c.JSON(voter)
+	return sendResource(c, voter)
 }
 
 // implementation for POST /todo
@@ -95,35 +527,125 @@ func (va *VoterAPI) PostVoter(c *fiber.Ctx) error {
 	//that will extract the body, convert it to JSON and
 	//bind it to a struct for us.  It will also report an error
 	//if the body is not JSON or if the JSON does not match
-	//the struct we are binding to.
-	if err := c.BodyParser(&voterItem); err != nil {
-		log.Println("Error binding JSON: ", err)
-		return fiber.NewError(http.StatusBadRequest)
+	//the struct we are binding to, parseBody wraps it to turn
+	//that error into one the client can act on.
+	if err := parseBody(c, &voterItem); err != nil {
+		return err
+	}
+	if ok, err := validateBody(voterItem); !ok {
+		return err
 	}
+	if voterItem.Weight != 0 && !mayWeigh(c) {
+		return newAPIError(http.StatusForbidden, "weight_requires_admin", "Requires the "+auth.RoleAdmin+" role to set the weight of a voter", nil)
+	}
+	if err := va.checkHistoryRefs(c, nil, voterItem.VoteHistory); err != nil {
+		return err
+	}
+	//Only the link or an admin verify the email
+	voterItem.Verified = false
 
-	if err := va.db.AddVoter(voterItem); err != nil {
-		log.Println("Error adding item: ", err)
-		return fiber.NewError(http.StatusInternalServerError)
+	if err := va.store(c).AddVoter(voterItem); err != nil {
+		requestLogger(c).Error("Error adding item", "error", err)
+		return dbError(err)
 	}
-	log.Println("Added Voter: ", voterItem)
-	return c.JSON(voterItem)
+	requestLogger(c).Info("Added voter", "voterId", voterItem.VoterId)
+
+	//Like a voter that registered itself they vote once their email is
+	//verified, by the link or by an admin activating them
+	va.verifyNewEmail(c, db.VoterItem{}, voterItem)
+	return sendResource(c, voterItem)
 }
 
-// implementation for PUT /todo
-// Web api standards use PUT for Updates
+// implementation for PUT /voters/:id
+// Web api standards use PUT for Updates.  The path says which voter is
+// replaced, the voterId in the body may be left out but if it is there it
+// has to match, a typo must not overwrite a different voter
 func (va *VoterAPI) UpdateVoter(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
 	var voterItem db.VoterItem
-	if err := c.BodyParser(&voterItem); err != nil {
-		log.Println("Error binding JSON: ", err)
+	if err := parseBody(c, &voterItem); err != nil {
+		return err
+	}
+	if voterItem.VoterId != 0 && voterItem.VoterId != id {
+		return newAPIError(http.StatusConflict, "id_mismatch",
+			fmt.Sprintf("Body voterId %d does not match the path voter %d", voterItem.VoterId, id), nil)
+	}
+	voterItem.VoterId = id
+	if ok, err := validateBody(voterItem); !ok {
+		return err
+	}
+
+	//The voter as it was tells whether the email changed, see
+	//verifyNewEmail
+	previous, err := va.store(c).GetVoter(id)
+	if err != nil {
+		return dbError(err)
+	}
+	if err := va.checkHistoryRefs(c, previous.VoteHistory, voterItem.VoteHistory); err != nil {
+		return err
+	}
+	if err := va.store(c).UpdateVoter(voterItem); err != nil {
+		requestLogger(c).Error("Error updating voter", "error", err)
+		return dbError(err)
+	}
+	va.verifyNewEmail(c, previous, voterItem)
+
+	return sendResource(c, voterItem)
+}
+
+// implementation for PATCH /voters/:id
+// applies a JSON merge patch (application/merge-patch+json), only the
+// fields in the body are changed
+func (va *VoterAPI) PatchVoter(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	//A merge patch has to be an object, BodyParser only knows about
+	//application/json so decode it ourselves
+	var patch map[string]json.RawMessage
+	if err := json.Unmarshal(c.Body(), &patch); err != nil || patch == nil {
+		requestLogger(c).Warn("Error binding JSON", "error", err)
 		return fiber.NewError(http.StatusBadRequest)
 	}
 
-	if err := va.db.UpdateVoter(voterItem); err != nil {
-		log.Println("Error updating voter: ", err)
-		return fiber.NewError(http.StatusInternalServerError)
+	previous, err := va.store(c).GetVoter(id)
+	if err != nil {
+		return dbError(err)
+	}
+	//A history that is not a list of entries is left for PatchVoter to
+	//reject
+	var history []db.VoterHistory
+	if value, found := patch["voteHistory"]; found && json.Unmarshal(value, &history) == nil {
+		if err := va.checkHistoryRefs(c, previous.VoteHistory, history); err != nil {
+			return err
+		}
+	}
+
+	//The patched voter has to pass the checks of a PUT, a patch can not
+	//store an invalid email or null a required field
+	voterItem, err := va.store(c).PatchVoter(id, patch, func(patched db.VoterItem) error {
+		if ok, err := validateBody(patched); !ok {
+			return err
+		}
+		return nil
+	})
+	var apiError *APIError
+	if errors.As(err, &apiError) {
+		return err
 	}
+	if err != nil {
+		requestLogger(c).Error("Error patching voter", "error", err)
+		return dbError(err)
+	}
+	va.verifyNewEmail(c, previous, voterItem)
 
-	return c.JSON(voterItem)
+	return sendResource(c, voterItem)
 }
 
 // implementation for DELETE /todo/:id
@@ -134,9 +656,9 @@ func (va *VoterAPI) DeleteVoter(c *fiber.Ctx) error {
 		return fiber.NewError(http.StatusBadRequest)
 	}
 
-	if err := va.db.DeleteVoter(id); err != nil {
-		log.Println("Error deleting voter: ", err)
-		return fiber.NewError(http.StatusInternalServerError)
+	if err := va.store(c).DeleteVoter(id); err != nil {
+		requestLogger(c).Error("Error deleting voter", "error", err)
+		return dbError(err)
 	}
 
 	return c.Status(http.StatusOK).SendString("Delete OK")
@@ -146,88 +668,144 @@ func (va *VoterAPI) DeleteVoter(c *fiber.Ctx) error {
 // deletes all todos
 func (va *VoterAPI) DeleteAllVoters(c *fiber.Ctx) error {
 
-	if _, err := va.db.DeleteAll(); err != nil {
-		log.Println("Error deleting all voters: ", err)
-		return fiber.NewError(http.StatusInternalServerError)
+	if _, err := va.store(c).DeleteAll(); err != nil {
+		requestLogger(c).Error("Error deleting all voters", "error", err)
+		return dbError(err)
 	}
 
 	return c.Status(http.StatusOK).SendString("Delete All OK")
 }
 
-// implementation for GET /voters/:id/polls
+// implementation for GET /voters/:id/polls?category=finance&tag=budget
+// A missing voter is a 404, but a voter that simply has not voted yet
+// is a 200 with an empty list.  With a category or tag only the votes in
+// the polls that have them are listed
 func (va *VoterAPI) GetVoterPolls(c *fiber.Ctx) error {
-	id, err := c.ParamsInt("id")
+	voter, err := va.parentVoter(c)
 	if err != nil {
-		return fiber.NewError(http.StatusBadRequest)
+		return err
 	}
 
-	voter, err := va.db.GetVoter(id)
-	if err != nil {
-		log.Println("Voter Poll not found: ", err)
-		return fiber.NewError(http.StatusNotFound)
+	history := voter.VoteHistory
+	if filterByPoll(c) {
+		if history, err = va.filterHistory(c, history); err != nil {
+			return err
+		}
 	}
 
-	return c.JSON(voter.VoteHistory)
+	setTotalCount(c, len(history))
+	return sendResource(c, emptyIfNil(history))
 }
 
-// implementation for GET /voters/:id/polls/:pollid
-func (va *VoterAPI) GetVoterPoll(c *fiber.Ctx) error {
-	voterID, err := c.ParamsInt("id")
-	if err != nil {
-		return fiber.NewError(http.StatusBadRequest)
+// filterHistory keeps the history entries whose poll has the ?tag= and is
+// in the ?category= of the request.  The entries of polls that are gone
+// are left out, there is nothing to match them against
+func (va *VoterAPI) filterHistory(c *fiber.Ctx, history []db.VoterHistory) ([]db.VoterHistory, error) {
+	matches := make(map[int]bool)
+	filtered := []db.VoterHistory{}
+	for _, entry := range history {
+		match, found := matches[entry.PollId]
+		if !found {
+			poll, err := va.lookupPoll(c, entry.PollId)
+			switch {
+			case errors.Is(err, db.ErrNotFound):
+			case errors.Is(err, errPollLookup):
+				requestLogger(c).Error("Error looking up poll", "pollId", entry.PollId, "error", err)
+				return nil, newAPIError(http.StatusBadGateway, "poll_lookup_failed", "Could not check the polls, try again later", nil)
+			case err != nil:
+				requestLogger(c).Error("Error looking up poll", "pollId", entry.PollId, "error", err)
+				return nil, dbError(err)
+			default:
+				match = pollMatches(c, poll)
+			}
+			matches[entry.PollId] = match
+		}
+		if match {
+			filtered = append(filtered, entry)
+		}
 	}
+	return filtered, nil
+}
 
+// implementation for GET /voters/:id/polls/:pollid
+func (va *VoterAPI) GetVoterPoll(c *fiber.Ctx) error {
 	pollID, err := c.ParamsInt("pollid")
 	if err != nil {
 		return fiber.NewError(http.StatusBadRequest)
 	}
 
-	voter, err := va.db.GetVoter(voterID)
+	voter, err := va.parentVoter(c)
 	if err != nil {
-		log.Println("Voter not found: ", err)
-		return fiber.NewError(http.StatusNotFound)
+		return err
 	}
 
 	for _, history := range voter.VoteHistory {
 		if history.PollId == pollID {
-			return c.JSON(history)
+			return sendResource(c, history)
 		}
 	}
 
-	return fiber.NewError(http.StatusNotFound)
+	return fiber.NewError(http.StatusNotFound, "Poll not found for this voter")
 }
 
 // implementation for POST /voters/:id/polls/:pollid
+// records a vote in the poll named by the path, the pollId in the body may
+// be left out but has to match if it is there.  A second vote in the same
+// poll is a 409, a poll that does not exist a 404 and one the voter is not
+// eligible for, see checkEligibility, a 403.  The receipt of the vote is
+// sent in VoteReceiptHeader
 func (va *VoterAPI) PostVoterPoll(c *fiber.Ctx) error {
 	voterID, err := c.ParamsInt("id")
 	if err != nil {
 		return fiber.NewError(http.StatusBadRequest)
 	}
 
-	var voterHistory db.VoterHistory
-
-	if err := c.BodyParser(&voterHistory); err != nil {
-		log.Println("Error binding JSON: ", err)
+	pollID, err := c.ParamsInt("pollid")
+	if err != nil {
 		return fiber.NewError(http.StatusBadRequest)
 	}
 
-	voter, err := va.db.GetVoter(voterID)
+	var voterHistory db.VoterHistory
+	if err := parseBody(c, &voterHistory); err != nil {
+		return err
+	}
+	if voterHistory.PollId != 0 && voterHistory.PollId != pollID {
+		return newAPIError(http.StatusConflict, "id_mismatch",
+			fmt.Sprintf("Body pollId %d does not match the path poll %d", voterHistory.PollId, pollID), nil)
+	}
+	voterHistory.PollId = pollID
+	if ok, err := validateBody(voterHistory); !ok {
+		return err
+	}
+	poll, err := va.checkPollRef(c, pollID, true, "optionId", voterHistory.OptionId)
 	if err != nil {
-		log.Println("Voter not found: ", err)
-		return fiber.NewError(http.StatusNotFound)
+		return err
+	}
+	if voterHistory.Provisional {
+		return validationError([]fieldError{{Field: "provisional", Reason: "is only allowed on votes recorded with POST /votes"}})
+	}
+	if poll.Anonymous || voterHistory.Anonymous {
+		return newAPIError(http.StatusConflict, "secret_ballot",
+			fmt.Sprintf("Poll %d takes secret ballots, record them with POST /votes", pollID), nil)
+	}
+	if err := va.checkEligibility(c, poll, voterID); err != nil {
+		return err
 	}
 
-	voter.VoteHistory = append(voter.VoteHistory, voterHistory)
-
-	if err := va.db.UpdateVoter(voter); err != nil {
-		log.Println("Error Adding Voter Poll: ", err)
-		return fiber.NewError(http.StatusInternalServerError)
+	if err := va.store(c).AddVoterPoll(voterHistory, voterID); err != nil {
+		requestLogger(c).Error("Error Adding Voter Poll", "error", err)
+		return dbError(err)
 	}
+	va.issueReceipt(c, voterID, pollID)
 
-	return c.JSON(voterHistory)
+	return sendResource(c, voterHistory)
 }
 
 // implementation for PUT /voters/:id/polls/:pollid
+// returns the updated record, a change to the vote it recorded is listed
+// in its amendments with who made it.  Like a new vote the change has to
+// pick an option of the poll while it is open.  The voteDate and whether
+// the vote is provisional stay what was recorded
 func (va *VoterAPI) UpdateVoterPoll(c *fiber.Ctx) error {
 	voterID, err := c.ParamsInt("id")
 	if err != nil {
@@ -240,21 +818,42 @@ func (va *VoterAPI) UpdateVoterPoll(c *fiber.Ctx) error {
 	}
 
 	var voterHistory db.VoterHistory
-	if err := c.BodyParser(&voterHistory); err != nil {
-		log.Println("Error binding JSON: ", err)
-		return fiber.NewError(http.StatusBadRequest)
+	if err := parseBody(c, &voterHistory); err != nil {
+		return err
+	}
+	if voterHistory.PollId != 0 && voterHistory.PollId != pollID {
+		return newAPIError(http.StatusBadRequest, "id_mismatch",
+			fmt.Sprintf("Body pollId %d does not match the path poll %d", voterHistory.PollId, pollID), nil)
+	}
+	voterHistory.PollId = pollID
+	//The store keeps the recorded date, it need not be sent
+	if voterHistory.VoteDate.IsZero() {
+		voterHistory.VoteDate = time.Now()
+	}
+	if ok, err := validateBody(voterHistory); !ok {
+		return err
+	}
+	poll, err := va.checkPollRef(c, pollID, true, "optionId", voterHistory.OptionId)
+	if err != nil {
+		return err
+	}
+	if poll.Anonymous || voterHistory.Anonymous {
+		return newAPIError(http.StatusConflict, "secret_ballot",
+			fmt.Sprintf("Poll %d takes secret ballots, they can not be changed", pollID), nil)
 	}
 
 	// Call the UpdateVoterPoll method from the database handler
-	if err := va.db.UpdateVoterPoll(voterHistory, voterID, pollID); err != nil {
-		log.Println("Error updating voter poll: ", err)
-		return fiber.NewError(http.StatusInternalServerError)
+	updated, err := va.store(c).UpdateVoterPoll(voterHistory, voterID, pollID, actor(c))
+	if err != nil {
+		requestLogger(c).Error("Error updating voter poll", "error", err)
+		return dbError(err)
 	}
 
-	return c.JSON(voterHistory)
+	return sendResource(c, updated)
 }
 
-// implementation for DELETE /voters/:id/history/:pollid
+// implementation for DELETE /voters/:id/polls/:pollid
+// with ?undo=true the voter withdraws a vote cast by mistake, see undoVote
 func (va *VoterAPI) DeleteVoterPoll(c *fiber.Ctx) error {
 	voterID, err := c.ParamsInt("id")
 	if err != nil {
@@ -266,25 +865,101 @@ func (va *VoterAPI) DeleteVoterPoll(c *fiber.Ctx) error {
 		return fiber.NewError(http.StatusBadRequest)
 	}
 
-	if err := va.db.DeleteVoterPoll(voterID, pollID); err != nil {
-		log.Println("Error deleting Voter Poll: ", err)
-		return fiber.NewError(http.StatusInternalServerError)
+	if c.QueryBool("undo") {
+		return va.undoVote(c, voterID, pollID)
+	}
+
+	if err := va.store(c).DeleteVoterPoll(voterID, pollID); err != nil {
+		requestLogger(c).Error("Error deleting Voter Poll", "error", err)
+		return dbError(err)
 	}
 
 	return c.Status(http.StatusOK).SendString("Voter history deleted successfully")
 }
 
+// undoVote withdraws a vote within the undo window of when it was cast,
+// the vote no longer counts and the voter can vote again.  Only while the
+// poll is open, a closed poll keeps its votes.  409 undo_window_passed
+// once the window is over, undo_disabled when there is no window
+func (va *VoterAPI) undoVote(c *fiber.Ctx, voterID int, pollID int) error {
+	if va.undoWindow <= 0 {
+		return newAPIError(http.StatusConflict, "undo_disabled", "Votes can not be withdrawn", nil)
+	}
+
+	poll, err := va.pathPoll(c, pollID)
+	if err != nil {
+		return err
+	}
+	if err := va.checkPollWindow(c, poll); err != nil {
+		return err
+	}
+
+	withdrawn, err := va.store(c).UndoVote(voterID, pollID, va.undoWindow, time.Now())
+	if errors.Is(err, db.ErrUndoExpired) {
+		details := fiber.Map{"undoWindow": va.undoWindow.String()}
+		return newAPIError(http.StatusConflict, "undo_window_passed",
+			fmt.Sprintf("Votes can only be withdrawn within %s of casting them", va.undoWindow), details)
+	}
+	if err != nil {
+		requestLogger(c).Error("Error withdrawing vote", "error", err)
+		return dbError(err)
+	}
+
+	va.audit(c, "vote.withdrawn", voterID, fmt.Sprintf("pollId=%d voteId=%d", pollID, withdrawn.VoteId))
+	return c.JSON(withdrawn)
+}
+
 // implementation of GET /voters/health. It is a good practice to build in a
-// health check for your API.  Below the results are just hard coded
-// but in a real API you can provide detailed information about the
-// health of your API with a Health Check
+// health check for your API.  The check actually pings redis, so the
+// container is reported unhealthy (503) when the datastore is unreachable
+// and degraded when redis is answering slowly
 func (va *VoterAPI) HealthCheck(c *fiber.Ctx) error {
-	return c.Status(http.StatusOK).
-		JSON(fiber.Map{
-			"status":             "ok",
-			"version":            "1.0.0",
-			"uptime":             100,
-			"users_processed":    1000,
-			"errors_encountered": 10,
-		})
+	status := "ok"
+	statusCode := http.StatusOK
+
+	redisHealth := fiber.Map{}
+	latency, err := va.store(c).Ping()
+	if err != nil {
+		requestLogger(c).Error("Health check could not reach redis", "error", err)
+		status = "unhealthy"
+		statusCode = http.StatusServiceUnavailable
+		redisHealth["error"] = err.Error()
+	} else {
+		redisHealth["pingLatencyMs"] = float64(latency.Microseconds()) / 1000
+		if latency > DegradedPingLatency {
+			status = "degraded"
+		}
+	}
+
+	pool := va.store(c).PoolStats()
+	redisHealth["pool"] = fiber.Map{
+		"hits":       pool.Hits,
+		"misses":     pool.Misses,
+		"timeouts":   pool.Timeouts,
+		"totalConns": pool.TotalConns,
+		"idleConns":  pool.IdleConns,
+		"staleConns": pool.StaleConns,
+	}
+
+	health := fiber.Map{
+		"status":        status,
+		"version":       Version,
+		"uptimeSeconds": int(time.Since(startTime).Seconds()),
+		"redis":         redisHealth,
+	}
+
+	//Only count voters if redis is up, otherwise we would just wait on
+	//a second timeout for no reason
+	if err == nil {
+		voterCount, err := va.store(c).CountVoters()
+		if err != nil {
+			requestLogger(c).Error("Health check could not count voters", "error", err)
+			status = "degraded"
+			health["status"] = status
+		} else {
+			health["voterCount"] = voterCount
+		}
+	}
+
+	return c.Status(statusCode).JSON(health)
 }
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/adllev/Voter-Container/voter-api/auth"
+	"github.com/adllev/Voter-Container/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// apiKeyRequest is the body of POST /admin/apikeys
+type apiKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// issuedAPIKey is returned once when a key is created, the key itself is
+// not stored so it can not be shown again
+type issuedAPIKey struct {
+	db.APIKey
+	Key string `json:"key"`
+}
+
+var validScopes = map[string]bool{
+	auth.ScopeVotersRead:  true,
+	auth.ScopeVotersWrite: true,
+	auth.ScopeVotesWrite:  true,
+	auth.ScopePollsWrite:  true,
+	auth.ScopeAdmin:       true,
+}
+
+// implementation for POST /admin/apikeys
+// issues a new API key for another service.  Without JWT_SIGNING_KEY or
+// API_KEYS authentication is off and nothing checks the keys, issuing one
+// would only suggest the API is protected, so that is a 409
+func (va *VoterAPI) PostAPIKey(c *fiber.Ctx) error {
+	if !va.authEnabled() {
+		return newAPIError(http.StatusConflict, "auth_disabled",
+			"Authentication is off, configure JWT_SIGNING_KEY or API_KEYS before issuing API keys", nil)
+	}
+
+	var req apiKeyRequest
+	if err := parseBody(c, &req); err != nil {
+		return err
+	}
+	if req.Name == "" || len(req.Scopes) == 0 {
+		return fiber.NewError(http.StatusBadRequest, "name and scopes are required")
+	}
+	for _, scope := range req.Scopes {
+		if !validScopes[scope] {
+			return fiber.NewError(http.StatusBadRequest, "unknown scope "+scope)
+		}
+	}
+
+	key, err := auth.GenerateAPIKey()
+	if err != nil {
+		requestLogger(c).Error("Error generating api key", "error", err)
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+
+	//A key only works in the tenant it was issued in
+	apiKey := db.APIKey{
+		Id:        utils.UUIDv4(),
+		Name:      req.Name,
+		Hash:      auth.HashAPIKey(key),
+		Scopes:    req.Scopes,
+		Tenant:    tenant(c),
+		CreatedAt: time.Now(),
+	}
+	if err := va.store(c).AddAPIKey(apiKey); err != nil {
+		requestLogger(c).Error("Error adding api key", "error", err)
+		return dbError(err)
+	}
+
+	va.audit(c, "apikey.created", 0, apiKey.Name)
+	return c.Status(http.StatusCreated).JSON(issuedAPIKey{APIKey: apiKey, Key: key})
+}
+
+// implementation for GET /admin/apikeys
+// lists the API keys issued in the tenant, without the keys
+func (va *VoterAPI) ListAPIKeys(c *fiber.Ctx) error {
+	apiKeyList, err := va.store(c).GetAllAPIKeys()
+	if err != nil {
+		requestLogger(c).Error("Error Getting API Keys", "error", err)
+		return dbError(err)
+	}
+
+	tenantKeys := make([]db.APIKey, 0, len(apiKeyList))
+	for _, apiKey := range apiKeyList {
+		if apiKey.Tenant == tenant(c) {
+			tenantKeys = append(tenantKeys, apiKey)
+		}
+	}
+	return c.JSON(tenantKeys)
+}
+
+// implementation for DELETE /admin/apikeys/:id
+// revokes an API key issued in the tenant
+func (va *VoterAPI) DeleteAPIKey(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if err := va.store(c).DeleteAPIKey(id, tenant(c)); err != nil {
+		requestLogger(c).Error("Error deleting api key", "error", err)
+		return dbError(err)
+	}
+
+	va.audit(c, "apikey.revoked", 0, id)
+	return c.Status(http.StatusOK).SendString("Delete OK")
+}
@@ -0,0 +1,244 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/adllev/Voter-Container/voter-api/auth"
+	"github.com/gofiber/fiber/v2"
+)
+
+// APIPrefix is where the versioned routes are mounted
+const APIPrefix = "/api/v1"
+
+// claimsKey is where Authenticate stores the verified claims in c.Locals
+const claimsKey = "claims"
+
+// unauthenticatedRoutes are authenticated some other way, or not at all.
+// Kiosk batches carry their own signature and so do the bounces the mail
+// provider reports, it can not get one of our tokens.  Registered
+// voters are pending until they verified their email, so anyone may sign
+// up, and the verification link carries its own signature
+var unauthenticatedRoutes = map[string]bool{
+	"GET /voters/health":          true,
+	"POST /voters/register":       true,
+	"GET /voters/verify":          true,
+	"POST /checkin/batch":         true,
+	"POST /notifications/bounces": true,
+	"GET /auth/login":             true,
+	"GET /auth/callback":          true,
+	"POST /auth/logout":           true,
+	"GET /metrics":                true,
+}
+
+// apiPath is the path of a request without the version prefix, so the
+// legacy and the /api/v1 routes are treated the same.  The router matches
+// paths ignoring case, so it is lowercased for the checks to match them
+// the same way, /ADMIN is an admin path too
+func apiPath(c *fiber.Ctx) string {
+	return strings.TrimPrefix(strings.ToLower(c.Path()), APIPrefix)
+}
+
+// routeKey is the method and path of a request, see apiPath
+func routeKey(c *fiber.Ctx) string {
+	return c.Method() + " " + apiPath(c)
+}
+
+// isRead reports whether a request can not change anything
+func isRead(c *fiber.Ctx) bool {
+	switch c.Method() {
+	case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// isAdminPath reports whether a request is for an admin only route, reads
+// included.  Webhooks send voter data to another system, so even listing
+// them shows where it goes
+func isAdminPath(c *fiber.Ctx) bool {
+	path := apiPath(c)
+	return strings.HasPrefix(path, "/admin") || strings.HasPrefix(path, "/webhooks")
+}
+
+// isDeploymentPath reports whether a request is for a route that is about
+// the whole deployment rather than one tenant: the webhooks, which get
+// the events of every tenant they are registered for, maintenance mode,
+// the feature flags and the background jobs
+func isDeploymentPath(c *fiber.Ctx) bool {
+	path := apiPath(c)
+	for _, root := range []string{"/webhooks", "/admin/webhooks", "/admin/maintenance", "/admin/features", "/admin/jobs"} {
+		if underPath(path, root) {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeyHeader is the header services send their API key in
+const APIKeyHeader = "X-API-Key"
+
+// authEnabled reports whether requests have to be authenticated at all,
+// that is whether JWT_SIGNING_KEY or API_KEYS is configured.  Keys issued
+// through /admin/apikeys do not count, PostAPIKey refuses to issue them
+// while this is off
+func (va *VoterAPI) authEnabled() bool {
+	return va.jwt != nil || va.apiKeys.HasStatic()
+}
+
+// Authenticate is a middleware that requires a valid bearer token or API
+// key for every request that can change data.  Reads are public unless
+// AUTH_PUBLIC_READS is false, except for the admin paths which always
+// need credentials.  With neither JWT_SIGNING_KEY nor API_KEYS configured
+// it lets everything through
+func (va *VoterAPI) Authenticate(c *fiber.Ctx) error {
+	if !va.authEnabled() || c.Method() == fiber.MethodOptions || unauthenticatedRoutes[routeKey(c)] {
+		return c.Next()
+	}
+
+	if key := c.Get(APIKeyHeader); key != "" {
+		return va.authenticateAPIKey(c, key)
+	}
+
+	if isRead(c) && va.publicReads && !isAdminPath(c) {
+		return c.Next()
+	}
+
+	if va.jwt == nil {
+		return fiber.NewError(http.StatusUnauthorized, "API key required")
+	}
+
+	//Browsers that logged in with /auth/login send the session cookie
+	//instead of an Authorization header
+	scheme, token, found := strings.Cut(c.Get(fiber.HeaderAuthorization), " ")
+	if !found {
+		scheme, token, found = "Bearer", c.Cookies(SessionCookie), c.Cookies(SessionCookie) != ""
+	}
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		c.Set(fiber.HeaderWWWAuthenticate, "Bearer")
+		return fiber.NewError(http.StatusUnauthorized, "Bearer token required")
+	}
+
+	claims, err := va.jwt.Verify(strings.TrimSpace(token))
+	if err != nil {
+		requestLogger(c).Warn("Rejected bearer token", "error", err)
+		c.Set(fiber.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+		return fiber.NewError(http.StatusUnauthorized, "Invalid bearer token")
+	}
+	if err := checkTenant(c, claims); err != nil {
+		return err
+	}
+
+	c.Locals(claimsKey, claims)
+	return c.Next()
+}
+
+// checkTenant rejects credentials used in a tenant they were not issued
+// for, a key of one election must not read or change another
+func checkTenant(c *fiber.Ctx, claims *auth.Claims) error {
+	if claims.AllowsTenant(tenant(c)) {
+		return nil
+	}
+	requestLogger(c).Warn("Rejected credentials of another tenant", "subject", claims.Subject, "tenant", tenant(c))
+	return newAPIError(http.StatusForbidden, "tenant_mismatch", "The credentials are not valid for this tenant", nil)
+}
+
+// authenticateAPIKey checks an API key and that it was granted the scope
+// the request needs.  The key name becomes the subject, for the audit log
+func (va *VoterAPI) authenticateAPIKey(c *fiber.Ctx, key string) error {
+	apiKey, err := va.apiKeys.Lookup(key)
+	if err != nil {
+		requestLogger(c).Warn("Rejected api key", "error", err)
+		return fiber.NewError(http.StatusUnauthorized, "Invalid API key")
+	}
+
+	scope := requiredScope(c)
+	if !auth.HasScope(apiKey, scope) {
+		return fiber.NewError(http.StatusForbidden, "API key is missing scope "+scope)
+	}
+
+	claims := &auth.Claims{Roles: auth.RolesForScopes(apiKey.Scopes), Tenant: apiKey.Tenant}
+	claims.Subject = "apikey:" + apiKey.Name
+	if err := checkTenant(c, claims); err != nil {
+		return err
+	}
+	c.Locals(claimsKey, claims)
+	return c.Next()
+}
+
+// requiredScope is the API key scope a request needs.  Recording votes,
+// check-ins and delegations of votes is votes:write, setting up polls and
+// who may vote in them, precincts and groups, is polls:write and the
+// voters themselves voters:write
+func requiredScope(c *fiber.Ctx) string {
+	path := apiPath(c)
+	switch {
+	case isAdminPath(c):
+		return auth.ScopeAdmin
+	case isRead(c):
+		return auth.ScopeVotersRead
+	case underPath(path, "/voters") && strings.Contains(path, "/polls/"):
+		return auth.ScopeVotesWrite
+	case underPath(path, "/polls") && strings.HasSuffix(path, "/votes/batch"):
+		return auth.ScopeVotesWrite
+	case underPath(path, "/votes"), underPath(path, "/checkin"), underPath(path, "/delegations"):
+		return auth.ScopeVotesWrite
+	case underPath(path, "/polls"), underPath(path, "/precincts"), underPath(path, "/groups"):
+		return auth.ScopePollsWrite
+	}
+	return auth.ScopeVotersWrite
+}
+
+// underPath reports whether path is root or below it, /polls/1 is under
+// /polls but /pollster is not
+func underPath(path string, root string) bool {
+	return path == root || strings.HasPrefix(path, root+"/")
+}
+
+// Authorize is a middleware, after Authenticate, that checks the roles of
+// the caller allow the request.  Requests Authenticate let through without
+// credentials (public reads and the exempt routes) are not checked again.
+// The deployment wide routes also need credentials for every tenant, an
+// admin of one election must not change the others
+func (va *VoterAPI) Authorize(c *fiber.Ctx) error {
+	claims := claims(c)
+	if claims == nil {
+		return c.Next()
+	}
+
+	role := requiredRole(c)
+	if !claims.HasRole(role) {
+		return fiber.NewError(http.StatusForbidden, "Requires the "+role+" role")
+	}
+	if isDeploymentPath(c) && claims.Tenant != auth.AnyTenant {
+		return fiber.NewError(http.StatusForbidden, "Requires credentials for every tenant")
+	}
+	return c.Next()
+}
+
+// requiredRole is the lowest role that may make a request.  Deleting
+// voters, one or all of them, polls, precincts or groups and the admin
+// paths are for admins
+func requiredRole(c *fiber.Ctx) string {
+	path := apiPath(c)
+	switch {
+	case isAdminPath(c):
+		return auth.RoleAdmin
+	case isRead(c):
+		return auth.RoleReader
+	case c.Method() == fiber.MethodDelete && strings.HasPrefix(path, "/voters") && !strings.Contains(path, "/polls/"):
+		return auth.RoleAdmin
+	case c.Method() == fiber.MethodDelete && (strings.HasPrefix(path, "/polls") || strings.HasPrefix(path, "/precincts")):
+		return auth.RoleAdmin
+	case c.Method() == fiber.MethodDelete && strings.HasPrefix(path, "/groups") && !strings.Contains(path, "/members/"):
+		return auth.RoleAdmin
+	}
+	return auth.RoleOperator
+}
+
+// claims returns the verified claims of the request, or nil if it was
+// not authenticated
+func claims(c *fiber.Ctx) *auth.Claims {
+	claims, _ := c.Locals(claimsKey).(*auth.Claims)
+	return claims
+}
@@ -0,0 +1,320 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/adllev/Voter-Container/voter-api/auth"
+	"github.com/adllev/Voter-Container/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// MaxVoterBatch is the most voters a single batch request may carry, larger
+// imports are split by the client
+const MaxVoterBatch = 1000
+
+// Statuses reported for each voter of a batch
+const (
+	voterBatchCreated  = "created"
+	voterBatchConflict = "conflict"
+	voterBatchInvalid  = "invalid"
+	voterBatchFailed   = "error"
+)
+
+// voterBatchResult is what happened to one voter of a batch, Index is its
+// position in the request array
+type voterBatchResult struct {
+	Index   int               `json:"index"`
+	VoterId int               `json:"voterId,omitempty"`
+	Status  string            `json:"status"`
+	Errors  map[string]string `json:"errors,omitempty"`
+}
+
+// implementation for POST /voters/batch
+// adds an array of voters, for bulk onboarding from a registration system.
+// The voters are checked one by one and the valid ones written in a single
+// pipeline, so one bad record does not fail the batch.  The response has a
+// result per voter, in request order: created, conflict or invalid
+func (va *VoterAPI) PostVoterBatch(c *fiber.Ctx) error {
+	var rawItems []json.RawMessage
+	if err := json.Unmarshal(c.Body(), &rawItems); err != nil {
+		requestLogger(c).Warn("Error binding JSON", "error", err)
+		return fiber.NewError(http.StatusBadRequest, "Body must be a JSON array of voters")
+	}
+	if len(rawItems) > MaxVoterBatch {
+		return fiber.NewError(http.StatusRequestEntityTooLarge, "A batch is limited to "+strconv.Itoa(MaxVoterBatch)+" voters")
+	}
+
+	results := make([]voterBatchResult, len(rawItems))
+	var voterItems []db.VoterItem
+	var positions []int
+	for i, raw := range rawItems {
+		results[i] = voterBatchResult{Index: i, Status: voterBatchInvalid}
+
+		var voterItem db.VoterItem
+		if err := json.Unmarshal(raw, &voterItem); err != nil {
+			results[i].Errors = map[string]string{"": "is not a valid voter"}
+			continue
+		}
+		results[i].VoterId = voterItem.VoterId
+
+		fields, err := validateFields(voterItem)
+		if err != nil {
+			return err
+		}
+		if fields != nil {
+			results[i].Errors = fields
+			continue
+		}
+		if voterItem.Weight != 0 && !mayWeigh(c) {
+			results[i].Errors = map[string]string{"weight": "requires the " + auth.RoleAdmin + " role"}
+			continue
+		}
+
+		voterItems = append(voterItems, voterItem)
+		positions = append(positions, i)
+	}
+
+	added, err := va.store(c).AddVoters(voterItems)
+	if err != nil {
+		requestLogger(c).Error("Error adding voter batch", "error", err)
+		return dbError(err)
+	}
+
+	created := 0
+	for j, err := range added {
+		result := &results[positions[j]]
+		switch {
+		case err == nil:
+			result.Status = voterBatchCreated
+			created++
+		case errors.Is(err, db.ErrAlreadyExists):
+			result.Status = voterBatchConflict
+		case errors.Is(err, db.ErrConflict):
+			result.Errors = map[string]string{"voteHistory": "must not have the same poll twice"}
+		default:
+			requestLogger(c).Error("Error adding voter", "voterId", result.VoterId, "error", err)
+			result.Status = voterBatchFailed
+		}
+	}
+
+	requestLogger(c).Info("Added voter batch", "voters", len(rawItems), "created", created)
+	return c.JSON(results)
+}
+
+// voterBatchDeleteResponse counts what a batch delete did, the ids that
+// were not deleted are listed so the client can look into them
+type voterBatchDeleteResponse struct {
+	Deleted     int   `json:"deleted"`
+	NotFound    int   `json:"notFound"`
+	Frozen      int   `json:"frozen"`
+	NotFoundIds []int `json:"notFoundIds"`
+	FrozenIds   []int `json:"frozenIds"`
+}
+
+// implementation for DELETE /voters/batch
+// deletes the voters whose ids are in the JSON array body, a safer
+// alternative to deleting every voter.  Ids that do not exist are counted
+// as not found and frozen voters are kept
+func (va *VoterAPI) DeleteVoterBatch(c *fiber.Ctx) error {
+	var ids []int
+	if err := json.Unmarshal(c.Body(), &ids); err != nil {
+		requestLogger(c).Warn("Error binding JSON", "error", err)
+		return fiber.NewError(http.StatusBadRequest, "Body must be a JSON array of voter ids")
+	}
+	if len(ids) > MaxVoterBatch {
+		return fiber.NewError(http.StatusRequestEntityTooLarge, "A batch is limited to "+strconv.Itoa(MaxVoterBatch)+" voters")
+	}
+
+	result, err := va.store(c).DeleteVoters(ids)
+	if err != nil {
+		requestLogger(c).Error("Error deleting voter batch", "error", err)
+		return dbError(err)
+	}
+
+	requestLogger(c).Info("Deleted voter batch", "voters", len(ids), "deleted", len(result.Deleted))
+	return c.JSON(voterBatchDeleteResponse{
+		Deleted:     len(result.Deleted),
+		NotFound:    len(result.NotFound),
+		Frozen:      len(result.Frozen),
+		NotFoundIds: result.NotFound,
+		FrozenIds:   result.Frozen,
+	})
+}
+
+// MaxVoteBatch is the most votes a single batch request may carry
+const MaxVoteBatch = 1000
+
+// Statuses reported for each vote of a batch, on top of the ones of a
+// voter batch.  Rejected votes came from voters that may not vote in the
+// poll
+const (
+	voteBatchRecorded = "recorded"
+	voteBatchRejected = "rejected"
+)
+
+// voteBatchResult is what happened to one vote of a batch, Index is its
+// position in the request array.  Receipt is the receipt of a recorded
+// vote, see VoteReceiptHeader
+type voteBatchResult struct {
+	Index   int               `json:"index"`
+	VoteId  int               `json:"voteId,omitempty"`
+	VoterId int               `json:"voterId,omitempty"`
+	Status  string            `json:"status"`
+	Receipt string            `json:"receipt,omitempty"`
+	Errors  map[string]string `json:"errors,omitempty"`
+}
+
+// voteBatchResponse counts the results of a vote batch by status
+type voteBatchResponse struct {
+	PollId   int               `json:"pollId"`
+	Recorded int               `json:"recorded"`
+	Conflict int               `json:"conflict"`
+	Invalid  int               `json:"invalid"`
+	Rejected int               `json:"rejected"`
+	Failed   int               `json:"failed"`
+	Results  []voteBatchResult `json:"results"`
+}
+
+// implementation for POST /polls/:pollid/votes/batch
+// records an array of votes for one poll, for digitizing paper ballots.
+// Every vote is checked and written on its own like a POST /votes, so one
+// bad ballot does not fail the batch and a ballot is either recorded with
+// its history entry or not at all.  The pollId of the votes can be left
+// out, a different one is invalid.  The poll itself has to exist and be
+// open, or the whole batch is refused.  The response has a result per
+// vote in request order, recorded, conflict, invalid, rejected or error,
+// and how many there are of each
+func (va *VoterAPI) PostVoteBatch(c *fiber.Ctx) error {
+	pollId, err := c.ParamsInt("pollid")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	var rawItems []json.RawMessage
+	if err := json.Unmarshal(c.Body(), &rawItems); err != nil {
+		requestLogger(c).Warn("Error binding JSON", "error", err)
+		return fiber.NewError(http.StatusBadRequest, "Body must be a JSON array of votes")
+	}
+	if len(rawItems) > MaxVoteBatch {
+		return fiber.NewError(http.StatusRequestEntityTooLarge, "A batch is limited to "+strconv.Itoa(MaxVoteBatch)+" votes")
+	}
+
+	poll, err := va.checkPollRef(c, pollId, true, "", 0)
+	if err != nil {
+		return err
+	}
+
+	response := voteBatchResponse{PollId: pollId, Results: make([]voteBatchResult, len(rawItems))}
+	for i, raw := range rawItems {
+		result := va.recordBatchVote(c, poll, raw)
+		result.Index = i
+		switch result.Status {
+		case voteBatchRecorded:
+			response.Recorded++
+		case voterBatchConflict:
+			response.Conflict++
+		case voterBatchInvalid:
+			response.Invalid++
+		case voteBatchRejected:
+			response.Rejected++
+		default:
+			response.Failed++
+		}
+		response.Results[i] = result
+	}
+
+	requestLogger(c).Info("Recorded vote batch", "pollId", pollId, "votes", len(rawItems), "recorded", response.Recorded)
+	return c.JSON(response)
+}
+
+// recordBatchVote checks and records one vote of a batch the way PostVote
+// does, with the outcome as a result instead of an error response
+func (va *VoterAPI) recordBatchVote(c *fiber.Ctx, poll db.Poll, raw json.RawMessage) voteBatchResult {
+	result := voteBatchResult{Status: voterBatchInvalid}
+
+	var vote db.Vote
+	if err := json.Unmarshal(raw, &vote); err != nil {
+		result.Errors = map[string]string{"": "is not a valid vote"}
+		return result
+	}
+	result.VoterId = vote.VoterId
+	if !poll.Anonymous {
+		//The id of a secret ballot is not paired with the voter either
+		result.VoteId = vote.VoteId
+	}
+
+	if vote.PollId != 0 && vote.PollId != poll.PollId {
+		result.Errors = map[string]string{"pollId": "must be the poll of the batch"}
+		return result
+	}
+	vote.PollId = poll.PollId
+	fields, err := validateFields(vote)
+	if err != nil {
+		requestLogger(c).Error("Error validating vote", "voterId", vote.VoterId, "error", err)
+		result.Status = voterBatchFailed
+		return result
+	}
+	if fields != nil {
+		result.Errors = fields
+		return result
+	}
+	if vote.VoteValue > 0 && !poll.HasOption(vote.VoteValue) {
+		result.Errors = map[string]string{"voteValue": "must be an option of the poll"}
+		return result
+	}
+	if poll.Anonymous && vote.Provisional {
+		result.Errors = map[string]string{"provisional": "is not possible for a secret ballot"}
+		return result
+	}
+	if err := checkBallot(poll, &vote); err != nil {
+		return batchErrorResult(c, result, err)
+	}
+	if err := va.checkEligibility(c, poll, vote.VoterId); err != nil {
+		return batchErrorResult(c, result, err)
+	}
+
+	if poll.Anonymous {
+		err = va.store(c).AddAnonymousVote(vote, va.ballotToken(vote.VoterId, vote.PollId))
+	} else {
+		err = va.store(c).AddVote(vote)
+	}
+	if err != nil {
+		return batchErrorResult(c, result, err)
+	}
+
+	result.Status = voteBatchRecorded
+	result.Receipt = va.storeReceipt(c, vote.VoterId, vote.PollId)
+	return result
+}
+
+// batchErrorResult fills in the status and errors of a batch result from
+// the error a check or the write returned
+func batchErrorResult(c *fiber.Ctx, result voteBatchResult, err error) voteBatchResult {
+	var apiError *APIError
+	switch {
+	case errors.As(err, &apiError) && apiError.Status == http.StatusForbidden:
+		result.Status = voteBatchRejected
+		result.Errors = map[string]string{"voterId": apiError.Message}
+	case errors.As(err, &apiError):
+		result.Status = voterBatchInvalid
+		result.Errors = make(map[string]string, len(apiError.Fields))
+		for _, field := range apiError.Fields {
+			result.Errors[field.Field] = field.Reason
+		}
+	case errors.Is(err, db.ErrAlreadyExists), errors.Is(err, db.ErrConflict):
+		result.Status = voterBatchConflict
+	case errors.Is(err, db.ErrNotActive), errors.Is(err, db.ErrNotVerified), errors.Is(err, db.ErrFrozen):
+		result.Status = voteBatchRejected
+		result.Errors = map[string]string{"voterId": err.Error()}
+	case errors.Is(err, db.ErrNotFound):
+		result.Status = voterBatchInvalid
+		result.Errors = map[string]string{"voterId": "must be an existing voter"}
+	default:
+		requestLogger(c).Error("Error adding vote", "voterId", result.VoterId, "error", err)
+		result.Status = voterBatchFailed
+	}
+	return result
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/adllev/Voter-Container/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// capacityProjection is the growth report for one cardinality series
+type capacityProjection struct {
+	Series        string                 `json:"series"`
+	Current       int64                  `json:"current"`
+	GrowthPerDay  float64                `json:"growthPerDay"`
+	Limit         int64                  `json:"limit,omitempty"`
+	DaysUntilFull *float64               `json:"daysUntilFull,omitempty"`
+	ProjectedFull *time.Time             `json:"projectedFull,omitempty"`
+	Samples       []db.CardinalitySample `json:"samples"`
+}
+
+// projectCapacity works out the average daily growth of a series from its
+// first and last sample and, if a limit is configured and the series is
+// growing, when the limit will be reached
+func projectCapacity(series string, samples []db.CardinalitySample, limit int64) capacityProjection {
+	projection := capacityProjection{
+		Series:  series,
+		Limit:   limit,
+		Samples: emptyIfNil(samples),
+	}
+	if len(samples) == 0 {
+		return projection
+	}
+
+	first, last := samples[0], samples[len(samples)-1]
+	projection.Current = last.Value
+
+	days := last.Time.Sub(first.Time).Hours() / 24
+	if days <= 0 {
+		return projection
+	}
+	projection.GrowthPerDay = float64(last.Value-first.Value) / days
+
+	if limit > 0 && projection.GrowthPerDay > 0 {
+		remaining := math.Max(float64(limit-last.Value), 0) / projection.GrowthPerDay
+		full := last.Time.Add(time.Duration(remaining * 24 * float64(time.Hour)))
+		projection.DaysUntilFull = &remaining
+		projection.ProjectedFull = &full
+	}
+
+	return projection
+}
+
+// implementation for GET /admin/capacity
+// reports the growth of voters, history entries and index entries over
+// the last ?days= (default 30) and projects when each configured capacity
+// limit, see config.CapacityConfig, will be hit
+func (va *VoterAPI) GetCapacity(c *fiber.Ctx) error {
+	days := c.QueryInt("days", 30)
+	if days <= 0 {
+		return fiber.NewError(http.StatusBadRequest, "days must be positive")
+	}
+	since := time.Now().AddDate(0, 0, -days)
+
+	projections := make([]capacityProjection, 0, len(db.CardinalitySeries))
+	for _, series := range db.CardinalitySeries {
+		samples, err := va.store(c).GetCardinalitySeries(series, since)
+		if err != nil {
+			requestLogger(c).Error("Error Getting Cardinality Series", "error", err)
+			return fiber.NewError(http.StatusInternalServerError)
+		}
+		projections = append(projections, projectCapacity(series, samples, va.capacityLimits[series]))
+	}
+
+	return c.JSON(projections)
+}
+
+// implementation for POST /admin/capacity/sample
+// takes a cardinality sample right now instead of waiting for the daily one
+func (va *VoterAPI) PostCapacitySample(c *fiber.Ctx) error {
+	counts, err := va.store(c).SampleCardinality()
+	if err != nil {
+		requestLogger(c).Error("Error sampling cardinality", "error", err)
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+
+	return c.JSON(counts)
+}
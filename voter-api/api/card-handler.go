@@ -0,0 +1,37 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/adllev/Voter-Container/voter-api/cards"
+	"github.com/gofiber/fiber/v2"
+)
+
+// implementation for GET /voters/:id/card.pdf
+// renders a printable voter card for the registration desk, the QR code
+// on the card holds a signed payload that is scanned at check-in
+func (va *VoterAPI) GetVoterCard(c *fiber.Ctx) error {
+	voter, err := va.parentVoter(c)
+	if err != nil {
+		return err
+	}
+
+	signedPayload, err := va.cards.Sign(cards.Payload{
+		VoterId:  voter.VoterId,
+		IssuedAt: time.Now().Unix(),
+	})
+	if err != nil {
+		requestLogger(c).Error("Error signing voter card", "error", err)
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+
+	pdf, err := cards.RenderPDF(voter.VoterId, voter.Name, signedPayload)
+	if err != nil {
+		requestLogger(c).Error("Error rendering voter card", "error", err)
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+
+	c.Set(fiber.HeaderContentType, "application/pdf")
+	return c.Send(pdf)
+}
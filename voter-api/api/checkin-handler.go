@@ -0,0 +1,206 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/adllev/Voter-Container/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// checkInRequest is the body accepted by POST /checkin, payload is the
+// string scanned from the QR code on the voter card
+type checkInRequest struct {
+	Payload string `json:"payload"`
+	PollId  int    `json:"pollId"`
+}
+
+// kioskCheckIn is one line of the NDJSON batch uploaded by an offline
+// kiosk, ScannedAt is when the card was scanned at the kiosk
+type kioskCheckIn struct {
+	Payload   string    `json:"payload"`
+	PollId    int       `json:"pollId"`
+	ScannedAt time.Time `json:"scannedAt"`
+}
+
+// Statuses reported for each record of a kiosk batch
+const (
+	batchStatusCheckedIn = "checked_in"
+	batchStatusDuplicate = "duplicate"
+	batchStatusConflict  = "conflict"
+	batchStatusInvalid   = "invalid"
+)
+
+// implementation for POST /checkin
+// validates the signed QR payload from a voter card and checks the voter
+// in for a poll.  Scanning the same card twice for a poll returns 409
+func (va *VoterAPI) PostCheckIn(c *fiber.Ctx) error {
+	var req checkInRequest
+	if err := parseBody(c, &req); err != nil {
+		return err
+	}
+	if req.PollId <= 0 {
+		return fiber.NewError(http.StatusBadRequest, "pollId is required")
+	}
+
+	card, err := va.cards.Verify(req.Payload)
+	if err != nil {
+		requestLogger(c).Warn("Rejected voter card", "error", err)
+		return fiber.NewError(http.StatusUnauthorized, "Invalid voter card")
+	}
+
+	checkIn, err := va.store(c).CheckInVoter(card.VoterId, req.PollId)
+	if err != nil {
+		requestLogger(c).Error("Error checking in voter", "error", err)
+		return dbError(err)
+	}
+
+	return c.JSON(checkIn)
+}
+
+// implementation for POST /checkin/batch
+// accepts a NDJSON file of check-ins recorded by an offline kiosk.  The
+// whole body must be signed with the kiosk key (X-Kiosk-Signature), each
+// line is then reconciled on its own: voters that already voted online
+// for the poll are flagged as anomalies instead of being checked in
+func (va *VoterAPI) PostCheckInBatch(c *fiber.Ctx) error {
+	kioskID := c.Get("X-Kiosk-Id")
+	if kioskID == "" {
+		return fiber.NewError(http.StatusBadRequest, "X-Kiosk-Id header is required")
+	}
+
+	body := c.Body()
+	if !va.kiosks.VerifyBytes(body, c.Get("X-Kiosk-Signature")) {
+		requestLogger(c).Warn("Rejected kiosk batch with bad signature", "kioskId", kioskID)
+		return fiber.NewError(http.StatusUnauthorized, "Invalid batch signature")
+	}
+
+	report := db.CheckInBatchReport{
+		BatchId:    utils.UUIDv4(),
+		KioskId:    kioskID,
+		ReceivedAt: time.Now(),
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		result := va.reconcileKioskCheckIn(va.tenantStore(c), kioskID, line, scanner.Bytes())
+		switch result.Status {
+		case batchStatusCheckedIn:
+			report.CheckedIn++
+		case batchStatusDuplicate:
+			report.Duplicates++
+		case batchStatusConflict:
+			report.Conflicts++
+		default:
+			report.Invalid++
+		}
+		report.Results = append(report.Results, result)
+	}
+	if err := scanner.Err(); err != nil {
+		requestLogger(c).Error("Error reading kiosk batch", "error", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+	report.Total = len(report.Results)
+
+	if err := va.store(c).SaveCheckInBatchReport(report); err != nil {
+		requestLogger(c).Error("Error saving kiosk batch report", "error", err)
+	}
+
+	return c.JSON(report)
+}
+
+// reconcileKioskCheckIn processes one line of a kiosk batch
+func (va *VoterAPI) reconcileKioskCheckIn(store *db.Voter, kioskID string, line int, raw []byte) db.CheckInBatchResult {
+	result := db.CheckInBatchResult{Line: line, Status: batchStatusInvalid}
+
+	var record kioskCheckIn
+	if err := json.Unmarshal(raw, &record); err != nil {
+		result.Detail = "unreadable record"
+		return result
+	}
+	result.PollId = record.PollId
+
+	card, err := va.cards.Verify(record.Payload)
+	if err != nil {
+		result.Detail = "invalid voter card"
+		return result
+	}
+	result.VoterId = card.VoterId
+
+	if record.PollId <= 0 {
+		result.Detail = "pollId is required"
+		return result
+	}
+
+	voter, err := store.GetVoter(card.VoterId)
+	if err != nil {
+		result.Detail = "voter not found"
+		return result
+	}
+
+	//A vote already recorded online for this poll means the voter is
+	//trying to vote twice, that goes to the anomaly list for review
+	for _, history := range voter.VoteHistory {
+		if history.PollId == record.PollId {
+			result.Status = batchStatusConflict
+			result.Detail = "voter already voted online"
+			anomaly := db.Anomaly{
+				Type:    db.AnomalyVotedOnlineAndInPerson,
+				VoterId: card.VoterId,
+				PollId:  record.PollId,
+				Source:  "kiosk:" + kioskID,
+				Detail:  fmt.Sprintf("checked in at kiosk %s at %s after voting online", kioskID, record.ScannedAt.Format(time.RFC3339)),
+			}
+			if err := store.AddAnomaly(anomaly); err != nil {
+				va.log.Error("Error recording anomaly", "voterId", card.VoterId, "error", err)
+			}
+			return result
+		}
+	}
+
+	if record.ScannedAt.IsZero() {
+		record.ScannedAt = time.Now()
+	}
+
+	_, err = store.RecordCheckIn(db.CheckIn{
+		VoterId:     card.VoterId,
+		PollId:      record.PollId,
+		Source:      "kiosk:" + kioskID,
+		CheckedInAt: record.ScannedAt,
+	})
+	if errors.Is(err, db.ErrAlreadyCheckedIn) {
+		result.Status = batchStatusDuplicate
+		return result
+	}
+	if err != nil {
+		result.Detail = err.Error()
+		return result
+	}
+
+	result.Status = batchStatusCheckedIn
+	return result
+}
+
+// implementation for GET /checkin/batch/:batchid
+// returns the report of a previously uploaded kiosk batch
+func (va *VoterAPI) GetCheckInBatch(c *fiber.Ctx) error {
+	report, err := va.store(c).GetCheckInBatchReport(c.Params("batchid"))
+	if err != nil {
+		requestLogger(c).Info("Kiosk batch not found", "error", err)
+		return dbError(err)
+	}
+
+	return c.JSON(report)
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/adllev/Voter-Container/voter-api/config"
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// DefaultCompressMinBytes is the smallest response worth compressing,
+// see config.DefaultCompressMinBytes
+const DefaultCompressMinBytes = config.DefaultCompressMinBytes
+
+// compressibleTypes are the content types that shrink when compressed,
+// PDFs and images already are compressed
+var compressibleTypes = []string{
+	"application/json",
+	"application/xml",
+	"application/x-ndjson",
+	"text/",
+}
+
+// Compress is a middleware that gzip or brotli compresses responses of at
+// least minBytes, whichever the client prefers in Accept-Encoding.  A
+// negative minBytes turns compression off
+func Compress(minBytes int) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if minBytes < 0 {
+			return c.Next()
+		}
+
+		if err := c.Next(); err != nil {
+			return err
+		}
+
+		rsp := c.Response()
+		if rsp.IsBodyStream() || len(rsp.Body()) < minBytes || len(rsp.Header.Peek(fiber.HeaderContentEncoding)) > 0 {
+			return nil
+		}
+		if !compressible(string(rsp.Header.ContentType())) {
+			return nil
+		}
+
+		//Whatever we decide, caches have to keep the variants apart
+		c.Vary(fiber.HeaderAcceptEncoding)
+
+		var compressed []byte
+		encoding := negotiateEncoding(c.Get(fiber.HeaderAcceptEncoding))
+		switch encoding {
+		case "br":
+			compressed = fasthttp.AppendBrotliBytesLevel(nil, rsp.Body(), fasthttp.CompressBrotliDefaultCompression)
+		case "gzip":
+			compressed = fasthttp.AppendGzipBytesLevel(nil, rsp.Body(), fasthttp.CompressDefaultCompression)
+		default:
+			return nil
+		}
+
+		rsp.SetBodyRaw(compressed)
+		rsp.Header.Set(fiber.HeaderContentEncoding, encoding)
+		return nil
+	}
+}
+
+func compressible(contentType string) bool {
+	for _, prefix := range compressibleTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateEncoding picks br or gzip from an Accept-Encoding header, by
+// q-value and preferring br on a tie.  It returns "" when the client
+// accepts neither
+func negotiateEncoding(acceptEncoding string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "*" {
+			name = "br"
+		}
+		if name != "br" && name != "gzip" {
+			continue
+		}
+
+		q := 1.0
+		if value, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		if q > bestQ || (q == bestQ && name == "br") {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
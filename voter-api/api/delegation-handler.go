@@ -0,0 +1,135 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/adllev/Voter-Container/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// implementation for GET /delegations
+// returns the delegations ordered by id.  ?voterId= keeps the ones a
+// voter made, ?delegateId= the ones handed to a voter and ?pollId= the
+// ones for a poll
+func (va *VoterAPI) ListDelegations(c *fiber.Ctx) error {
+	voterId := c.QueryInt("voterId")
+	delegateId := c.QueryInt("delegateId")
+	pollId := c.QueryInt("pollId")
+
+	delegationList, err := va.store(c).GetAllDelegations()
+	if err != nil {
+		requestLogger(c).Error("Error getting delegations", "error", err)
+		return dbError(err)
+	}
+
+	filtered := make([]db.Delegation, 0, len(delegationList))
+	for _, delegation := range delegationList {
+		if (voterId == 0 || delegation.VoterId == voterId) && (delegateId == 0 || delegation.DelegateId == delegateId) &&
+			(pollId == 0 || delegation.PollId == pollId) {
+			filtered = append(filtered, delegation)
+		}
+	}
+
+	setTotalCount(c, len(filtered))
+	return c.JSON(filtered)
+}
+
+// implementation for GET /delegations/:delegationid
+// returns a single delegation
+func (va *VoterAPI) GetDelegation(c *fiber.Ctx) error {
+	delegationId, err := c.ParamsInt("delegationid")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	delegation, err := va.store(c).GetDelegation(delegationId)
+	if err != nil {
+		requestLogger(c).Info("Delegation not found", "delegationId", delegationId, "error", err)
+		return dbError(err)
+	}
+
+	return c.JSON(delegation)
+}
+
+// implementation for POST /delegations
+// hands the vote of a voter to a delegate for a poll or a category, 409
+// if the delegationId is taken or the voter delegated for it already.
+// Delegations that loop are accepted, the tally leaves them out
+func (va *VoterAPI) PostDelegation(c *fiber.Ctx) error {
+	var delegation db.Delegation
+	if err := parseBody(c, &delegation); err != nil {
+		return err
+	}
+	if ok, err := validateBody(delegation); !ok {
+		return err
+	}
+
+	added, err := va.store(c).AddDelegation(delegation)
+	if err != nil {
+		requestLogger(c).Error("Error adding delegation", "error", err)
+		return dbError(err)
+	}
+	va.audit(c, "delegation.added", added.VoterId, delegationDetail(added))
+
+	return c.JSON(added)
+}
+
+// implementation for PUT /delegations/:delegationid
+// changes the delegate or the poll or category of a delegation, the
+// delegationId in the body may be left out but has to match the path when
+// it is there.  The voter that delegated stays the same
+func (va *VoterAPI) UpdateDelegation(c *fiber.Ctx) error {
+	delegationId, err := c.ParamsInt("delegationid")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	var delegation db.Delegation
+	if err := parseBody(c, &delegation); err != nil {
+		return err
+	}
+	if delegation.DelegationId != 0 && delegation.DelegationId != delegationId {
+		return newAPIError(http.StatusConflict, "id_mismatch",
+			fmt.Sprintf("Body delegationId %d does not match the path delegation %d", delegation.DelegationId, delegationId), nil)
+	}
+	delegation.DelegationId = delegationId
+	if ok, err := validateBody(delegation); !ok {
+		return err
+	}
+
+	updated, err := va.store(c).UpdateDelegation(delegation)
+	if err != nil {
+		requestLogger(c).Error("Error updating delegation", "error", err)
+		return dbError(err)
+	}
+	va.audit(c, "delegation.updated", updated.VoterId, delegationDetail(updated))
+
+	return c.JSON(updated)
+}
+
+// implementation for DELETE /delegations/:delegationid
+// takes a delegation back, the voter votes for themselves again
+func (va *VoterAPI) DeleteDelegation(c *fiber.Ctx) error {
+	delegationId, err := c.ParamsInt("delegationid")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	deleted, err := va.store(c).DeleteDelegation(delegationId)
+	if err != nil {
+		requestLogger(c).Error("Error deleting delegation", "error", err)
+		return dbError(err)
+	}
+	va.audit(c, "delegation.deleted", deleted.VoterId, delegationDetail(deleted))
+
+	return c.Status(http.StatusOK).SendString("Delete OK")
+}
+
+// delegationDetail is what the audit log keeps of a delegation
+func delegationDetail(delegation db.Delegation) string {
+	if delegation.PollId != 0 {
+		return fmt.Sprintf("delegationId=%d delegateId=%d pollId=%d", delegation.DelegationId, delegation.DelegateId, delegation.PollId)
+	}
+	return fmt.Sprintf("delegationId=%d delegateId=%d category=%s", delegation.DelegationId, delegation.DelegateId, delegation.Category)
+}
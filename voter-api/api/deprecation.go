@@ -0,0 +1,121 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Deprecation describes an endpoint scheduled for removal.  Clients are
+// told with the Deprecation (RFC 9745) and Sunset (RFC 8594) headers, and
+// every use is logged and counted so we know who still has to move
+// before the endpoint is cut off
+type Deprecation struct {
+	//Since is when the endpoint was deprecated, zero if not recorded
+	Since time.Time
+	//Sunset is when the endpoint goes away, zero if not decided yet
+	Sunset time.Time
+	//Successor is the path prefix the endpoint moved under, the same
+	//path below it is the replacement.  Empty when there is none
+	Successor string
+	//Link is the documentation of the deprecation, optional
+	Link string
+}
+
+var httpDeprecatedRequestsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "voter",
+		Subsystem: "http",
+		Name:      "deprecated_requests_total",
+		Help:      "Number of requests to deprecated routes, by method and route.",
+	},
+	[]string{"method", "route"},
+)
+
+func init() {
+	prometheus.MustRegister(httpDeprecatedRequestsTotal)
+}
+
+// Deprecated is a route level handler that marks a route as deprecated,
+// put it in front of the handlers of the route
+func Deprecated(deprecation Deprecation) fiber.Handler {
+	deprecationHeader := "true"
+	if !deprecation.Since.IsZero() {
+		deprecationHeader = "@" + strconv.FormatInt(deprecation.Since.Unix(), 10)
+	}
+
+	return func(c *fiber.Ctx) error {
+		method, route := c.Method(), c.Route().Path
+		httpDeprecatedRequestsTotal.WithLabelValues(method, route).Inc()
+
+		//User-Agent and the caller are what tells us who to chase
+		attrs := []any{"route", route, "userAgent", c.Get(fiber.HeaderUserAgent), "ip", c.IP()}
+		if claims := claims(c); claims != nil {
+			attrs = append(attrs, "caller", claims.Subject)
+		}
+		if !deprecation.Sunset.IsZero() {
+			attrs = append(attrs, "sunset", deprecation.Sunset)
+		}
+		requestLogger(c).Warn("Deprecated route used", attrs...)
+
+		//Set before the handler runs so error responses carry them too,
+		//Link is added to rather than set, handlers set their own Link
+		c.Set("Deprecation", deprecationHeader)
+		if !deprecation.Sunset.IsZero() {
+			c.Set("Sunset", deprecation.Sunset.UTC().Format(http.TimeFormat))
+		}
+		err := c.Next()
+
+		if deprecation.Successor != "" {
+			c.Response().Header.Add(fiber.HeaderLink, `<`+deprecation.Successor+c.Path()+`>; rel="successor-version"`)
+		}
+		if deprecation.Link != "" {
+			c.Response().Header.Add(fiber.HeaderLink, `<`+deprecation.Link+`>; rel="deprecation"`)
+		}
+		return err
+	}
+}
+
+// deprecatedRouter puts Deprecated in front of the handlers of every route
+// registered through it
+type deprecatedRouter struct {
+	fiber.Router
+	deprecated fiber.Handler
+}
+
+// Deprecate wraps a router so every route registered on it is deprecated,
+// for a whole tree of routes such as the legacy unversioned ones
+func Deprecate(router fiber.Router, deprecation Deprecation) fiber.Router {
+	return deprecatedRouter{Router: router, deprecated: Deprecated(deprecation)}
+}
+
+func (r deprecatedRouter) Get(path string, handlers ...fiber.Handler) fiber.Router {
+	return r.Router.Get(path, append([]fiber.Handler{r.deprecated}, handlers...)...)
+}
+
+func (r deprecatedRouter) Head(path string, handlers ...fiber.Handler) fiber.Router {
+	return r.Router.Head(path, append([]fiber.Handler{r.deprecated}, handlers...)...)
+}
+
+func (r deprecatedRouter) Post(path string, handlers ...fiber.Handler) fiber.Router {
+	return r.Router.Post(path, append([]fiber.Handler{r.deprecated}, handlers...)...)
+}
+
+func (r deprecatedRouter) Put(path string, handlers ...fiber.Handler) fiber.Router {
+	return r.Router.Put(path, append([]fiber.Handler{r.deprecated}, handlers...)...)
+}
+
+func (r deprecatedRouter) Patch(path string, handlers ...fiber.Handler) fiber.Router {
+	return r.Router.Patch(path, append([]fiber.Handler{r.deprecated}, handlers...)...)
+}
+
+func (r deprecatedRouter) Delete(path string, handlers ...fiber.Handler) fiber.Router {
+	return r.Router.Delete(path, append([]fiber.Handler{r.deprecated}, handlers...)...)
+}
+
+func (r deprecatedRouter) Group(prefix string, handlers ...fiber.Handler) fiber.Router {
+	return deprecatedRouter{Router: r.Router.Group(prefix, handlers...), deprecated: r.deprecated}
+}
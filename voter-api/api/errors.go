@@ -0,0 +1,154 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/adllev/Voter-Container/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// statusForError maps the typed errors of the db package to an HTTP
+// status, and a request that ran out of time to a 504.  Anything that is
+// not one of them is a 500
+func statusForError(err error) int {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, db.ErrNotFound), errors.Is(err, db.ErrPollNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, db.ErrAlreadyExists), errors.Is(err, db.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, db.ErrFrozen):
+		return http.StatusLocked
+	case errors.Is(err, db.ErrNotActive), errors.Is(err, db.ErrNotVerified):
+		return http.StatusForbidden
+	case errors.Is(err, db.ErrInvalidQuery):
+		return http.StatusBadRequest
+	case errors.Is(err, db.ErrInvalid):
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+// errorCodeForError is the code of the error envelope for the typed
+// errors of the db package, more specific than the status alone, a 409
+// can be a duplicate voter or a conflicting write
+func errorCodeForError(err error) string {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, db.ErrPollNotFound):
+		return "poll_not_found"
+	case errors.Is(err, db.ErrNotFound):
+		return "not_found"
+	case errors.Is(err, db.ErrAlreadyExists):
+		return "already_exists"
+	case errors.Is(err, db.ErrConflict):
+		return "conflict"
+	case errors.Is(err, db.ErrFrozen):
+		return "frozen"
+	case errors.Is(err, db.ErrNotActive):
+		return "voter_not_active"
+	case errors.Is(err, db.ErrNotVerified):
+		return "voter_not_verified"
+	case errors.Is(err, db.ErrInvalidQuery):
+		return "invalid_query"
+	case errors.Is(err, db.ErrInvalid):
+		return "invalid"
+	}
+	return errorCode(http.StatusInternalServerError)
+}
+
+// errorCode is the code of the error envelope for a status that has no
+// more specific one, the status text in snake case, like not_found
+func errorCode(status int) string {
+	text := http.StatusText(status)
+	if text == "" {
+		return "error"
+	}
+	return strings.ReplaceAll(strings.ToLower(text), " ", "_")
+}
+
+// APIError is an error with everything ErrorHandler puts in the error
+// envelope.  Handlers only need it for a specific code, for details or
+// for invalid fields, a plain fiber.NewError gets the code from its status
+type APIError struct {
+	Status  int
+	Code    string
+	Message string
+	Details any
+	Fields  []fieldError
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// newAPIError is a constructor function that returns a pointer to a new
+// APIError.  An empty message is the status text
+func newAPIError(status int, code string, message string, details any) *APIError {
+	if message == "" {
+		message = http.StatusText(status)
+	}
+	return &APIError{Status: status, Code: code, Message: message, Details: details}
+}
+
+// errorResponse is the body of every error response, Code is stable and
+// meant for programs, Message is meant for people
+type errorResponse struct {
+	Code      string       `json:"code"`
+	Message   string       `json:"message"`
+	Details   any          `json:"details,omitempty"`
+	Fields    []fieldError `json:"fields,omitempty"`
+	RequestId string       `json:"requestId,omitempty"`
+}
+
+// dbError turns an error from the db package into an APIError with the
+// status from statusForError.  Client errors carry the error message, a
+// 500 does not so we never leak redis details to the caller
+func dbError(err error) error {
+	status := statusForError(err)
+	switch status {
+	case http.StatusInternalServerError:
+		return newAPIError(status, errorCode(status), "", nil)
+	case http.StatusGatewayTimeout:
+		return newAPIError(status, errorCodeForError(err), "Request timed out", nil)
+	}
+	return newAPIError(status, errorCodeForError(err), err.Error(), nil)
+}
+
+// ErrorHandler is the fiber error handler.  It logs server errors with
+// the request id, so a 500 a client reports can be found in the logs,
+// then responds with the error envelope.  Errors that are neither an
+// APIError nor a fiber.Error are 500s, their message is not sent
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	response := errorResponse{RequestId: requestID(c)}
+	status := http.StatusInternalServerError
+
+	var apiError *APIError
+	var fiberError *fiber.Error
+	switch {
+	case errors.As(err, &apiError):
+		status = apiError.Status
+		response.Code = apiError.Code
+		response.Message = apiError.Message
+		response.Details = apiError.Details
+		response.Fields = apiError.Fields
+	case errors.As(err, &fiberError):
+		status = fiberError.Code
+		response.Code = errorCode(status)
+		response.Message = fiberError.Message
+	default:
+		response.Code = errorCode(status)
+		response.Message = http.StatusText(status)
+	}
+
+	if status >= http.StatusInternalServerError {
+		requestLogger(c).Error("Request failed", "status", status, "error", err)
+	}
+
+	return c.Status(status).JSON(response)
+}
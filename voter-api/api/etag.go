@@ -0,0 +1,52 @@
+package api
+
+import (
+	"fmt"
+	"hash/crc32"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ETag tags voter reads with a hash of the response body and answers a
+// matching If-None-Match with an empty 304, so clients that poll a voter
+// or the list only download it again when it changed.  The tag is weak
+// because Compress may re-encode the body on its way out.  Streamed
+// responses (the CSV export) are not tagged, hashing them would mean
+// holding the whole stream in memory
+func ETag(c *fiber.Ctx) error {
+	if err := c.Next(); err != nil {
+		return err
+	}
+
+	rsp := c.Response()
+	if rsp.StatusCode() != fiber.StatusOK || rsp.IsBodyStream() || len(rsp.Body()) == 0 {
+		return nil
+	}
+
+	body := rsp.Body()
+	tag := fmt.Sprintf(`W/"%d-%08x"`, len(body), crc32.ChecksumIEEE(body))
+	c.Set(fiber.HeaderETag, tag)
+
+	if etagMatches(c.Get(fiber.HeaderIfNoneMatch), tag) {
+		c.Context().ResetBody()
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+	return nil
+}
+
+// etagMatches is the weak comparison If-None-Match uses, the W/ prefix of
+// either tag is ignored
+func etagMatches(ifNoneMatch string, tag string) bool {
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+
+	tag = strings.TrimPrefix(tag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// eventKeepAlive is how long an idle event stream waits before sending a
+// comment, so proxies do not close a quiet connection
+const eventKeepAlive = 15 * time.Second
+
+// eventIdPattern is the shape of a redis stream id, what Last-Event-ID
+// carries when a browser reconnects
+var eventIdPattern = regexp.MustCompile(`^\d+-\d+$`)
+
+// implementation for GET /voters/events
+// a Server-Sent Events stream of voter changes (voter.created,
+// voter.updated, voter.deleted, vote.recorded...) for live dashboards.
+// ?types=vote.recorded,voter.created limits the event types.  A browser
+// that reconnects sends Last-Event-ID and gets the events it missed
+func (va *VoterAPI) StreamVoterEvents(c *fiber.Ctx) error {
+	lastId := c.Get("Last-Event-ID")
+	if lastId != "" && !eventIdPattern.MatchString(lastId) {
+		return fiber.NewError(http.StatusBadRequest, "Invalid Last-Event-ID")
+	}
+	if lastId == "" {
+		var err error
+		if lastId, err = va.db.LatestEventId(); err != nil {
+			requestLogger(c).Error("Error reading event stream", "error", err)
+			return dbError(err)
+		}
+	}
+
+	types := make(map[string]bool)
+	for _, eventType := range strings.Split(c.Query("types"), ",") {
+		if eventType = strings.TrimSpace(eventType); eventType != "" {
+			types[eventType] = true
+		}
+	}
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	//Stop nginx from buffering the stream
+	c.Set("X-Accel-Buffering", "no")
+
+	//See exportVotersCSV, c can not be used inside the stream writer
+	logger := requestLogger(c)
+	tenantID := tenant(c)
+	c.Context().SetBodyStreamWriter(func(bw *bufio.Writer) {
+		//Tell the browser how soon to reconnect if the stream drops
+		fmt.Fprintf(bw, "retry: %d\n\n", (5 * time.Second).Milliseconds())
+
+		for {
+			entries, err := va.db.ReadEvents(lastId, eventKeepAlive)
+			if err != nil {
+				logger.Error("Error reading event stream", "error", err)
+				return
+			}
+			if len(entries) == 0 {
+				fmt.Fprint(bw, ": keep-alive\n\n")
+			}
+
+			for _, entry := range entries {
+				lastId = entry.Id
+				if entry.Tenant != tenantID || (len(types) > 0 && !types[entry.Type]) {
+					continue
+				}
+				data, err := json.Marshal(entry.Event)
+				if err != nil {
+					continue
+				}
+				fmt.Fprintf(bw, "id: %s\nevent: %s\ndata: %s\n\n", entry.Id, entry.Type, data)
+			}
+
+			//Flush fails once the client went away, that ends the stream
+			if err := bw.Flush(); err != nil {
+				return
+			}
+		}
+	})
+	return nil
+}
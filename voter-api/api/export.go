@@ -0,0 +1,133 @@
+package api
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/adllev/Voter-Container/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// MIMETextCSV is the content type of the voter roll export
+const MIMETextCSV = "text/csv"
+
+// csvHeader is the first row of the voter roll export
+var csvHeader = []string{"voterId", "name", "email", "voteCount"}
+
+// exportVotersCSV writes the voters as CSV, for GET /voters with Accept:
+// text/csv, so staff can open the roll in a spreadsheet.  The full roll is
+// streamed a page at a time so a large one is never held in memory, a
+// filtered list is small and written in one go.  Purged voters are off the
+// roll and not exported
+func (va *VoterAPI) exportVotersCSV(c *fiber.Ctx, query db.VoterQuery) error {
+	c.Set(fiber.HeaderContentType, MIMETextCSV+"; charset=utf-8")
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="voters.csv"`)
+
+	if query != (db.VoterQuery{}) {
+		voterList, err := va.store(c).GetVoters(query)
+		if err != nil {
+			requestLogger(c).Error("Error Getting Voters", "error", err)
+			return dbError(err)
+		}
+
+		w := csv.NewWriter(c)
+		_ = w.Write(csvHeader)
+		for _, voter := range voterList {
+			if !voter.Purged() {
+				_ = w.Write(csvRow(voter))
+			}
+		}
+		w.Flush()
+		return w.Error()
+	}
+
+	//The stream is written after the handler returned, when c may already
+	//be reused for another request, so take what is needed now
+	logger := requestLogger(c)
+	store := va.tenantStore(c)
+	c.Context().SetBodyStreamWriter(func(bw *bufio.Writer) {
+		w := csv.NewWriter(bw)
+		_ = w.Write(csvHeader)
+
+		afterID := 0
+		for {
+			voterList, nextCursor, err := store.GetVotersPage(afterID, MaxPageLimit)
+			if err != nil {
+				//The status is already sent, all we can do is stop
+				logger.Error("Error streaming voter export", "error", err)
+				break
+			}
+			for _, voter := range voterList {
+				if !voter.Purged() {
+					_ = w.Write(csvRow(voter))
+				}
+			}
+			w.Flush()
+			if err := bw.Flush(); err != nil || nextCursor == 0 {
+				break
+			}
+			afterID = nextCursor
+		}
+		w.Flush()
+	})
+	return nil
+}
+
+// csvRow is one voter of the export
+func csvRow(voter db.VoterItem) []string {
+	return []string{
+		strconv.Itoa(voter.VoterId),
+		csvSafe(voter.Name),
+		csvSafe(voter.Email),
+		strconv.Itoa(len(voter.VoteHistory)),
+	}
+}
+
+// csvSafe stops a spreadsheet from running a name like =HYPERLINK(...) as
+// a formula by prefixing it with a quote, the usual defense against CSV
+// injection
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// MIMEApplicationNDJSON is the content type of GET /voters/stream
+const MIMEApplicationNDJSON = "application/x-ndjson"
+
+// implementation for GET /voters/stream
+// writes every voter as newline delimited JSON, one voter per line, as it
+// is read from redis.  Unlike GET /voters nothing is buffered on either
+// side, a client can process millions of voters line by line.  Voters come
+// in no particular order, purged ones are left out like in the CSV export
+func (va *VoterAPI) StreamVoters(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, MIMEApplicationNDJSON)
+
+	//See exportVotersCSV, c can not be used inside the stream writer
+	logger := requestLogger(c)
+	store := va.tenantStore(c)
+	c.Context().SetBodyStreamWriter(func(bw *bufio.Writer) {
+		err := store.StreamVoters(func(voterItem db.VoterItem) error {
+			if voterItem.Purged() {
+				return nil
+			}
+			line, err := json.Marshal(voterItem)
+			if err != nil {
+				return err
+			}
+			//The buffered writer hands each full buffer to the client
+			_, err = bw.Write(append(line, '\n'))
+			return err
+		})
+		if err != nil {
+			//The status is already sent, all we can do is stop
+			logger.Error("Error streaming voters", "error", err)
+		}
+		_ = bw.Flush()
+	})
+	return nil
+}
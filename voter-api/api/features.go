@@ -0,0 +1,128 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/adllev/Voter-Container/voter-api/config"
+	"github.com/gofiber/fiber/v2"
+)
+
+// featureState is a feature flag as GET /admin/features reports it.
+// Overridden is set when an admin switched it at runtime, Default is what
+// it goes back to when the override is removed
+type featureState struct {
+	Name       string `json:"name"`
+	Enabled    bool   `json:"enabled"`
+	Default    bool   `json:"default"`
+	Overridden bool   `json:"overridden"`
+}
+
+// featureStates returns every feature flag with the runtime overrides
+// applied, flags missing from the configuration are on.  When redis can not be read the configured defaults are used,
+// losing the overrides is better than failing every gated request
+func (va *VoterAPI) featureStates(c *fiber.Ctx) []featureState {
+	overrides, err := va.store(c).GetFeatureFlags()
+	if err != nil {
+		requestLogger(c).Warn("Could not read feature flags, using the defaults", "error", err)
+	}
+
+	states := make([]featureState, 0, len(config.Features))
+	for name := range config.Features {
+		enabled, configured := va.features[name]
+		state := featureState{Name: name, Enabled: enabled || !configured, Default: enabled || !configured}
+		if enabled, found := overrides[name]; found {
+			state.Enabled = enabled
+			state.Overridden = true
+		}
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Name < states[j].Name })
+	return states
+}
+
+func (va *VoterAPI) featureState(c *fiber.Ctx, name string) featureState {
+	for _, state := range va.featureStates(c) {
+		if state.Name == name {
+			return state
+		}
+	}
+	return featureState{Name: name}
+}
+
+// Feature is a route middleware that answers 404 while the named feature
+// is switched off, as if the endpoint did not exist
+func (va *VoterAPI) Feature(name string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !va.featureState(c, name).Enabled {
+			return newAPIError(http.StatusNotFound, "feature_disabled", "This endpoint is disabled", nil)
+		}
+		return c.Next()
+	}
+}
+
+// implementation for GET /admin/features
+// returns every feature flag and whether it is on
+func (va *VoterAPI) ListFeatures(c *fiber.Ctx) error {
+	return c.JSON(va.featureStates(c))
+}
+
+// featureName returns the :name path parameter, a 404 if there is no
+// feature by that name
+func featureName(c *fiber.Ctx) (string, error) {
+	name := c.Params("name")
+	if _, known := config.Features[name]; !known {
+		return "", fiber.NewError(http.StatusNotFound, "Unknown feature "+name)
+	}
+	return name, nil
+}
+
+// implementation for PUT /admin/features/:name
+// switches a feature on or off for every replica, the body is
+// {"enabled": false}
+func (va *VoterAPI) PutFeature(c *fiber.Ctx) error {
+	name, err := featureName(c)
+	if err != nil {
+		return err
+	}
+
+	var req struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := parseBody(c, &req); err != nil {
+		return err
+	}
+	if req.Enabled == nil {
+		return validationError([]fieldError{{Field: "enabled", Reason: "is required"}})
+	}
+
+	if err := va.store(c).SetFeatureFlag(name, *req.Enabled); err != nil {
+		requestLogger(c).Error("Error setting feature flag", "error", err)
+		return dbError(err)
+	}
+
+	action := "feature.enabled"
+	if !*req.Enabled {
+		action = "feature.disabled"
+	}
+	va.audit(c, action, 0, name)
+
+	return c.JSON(va.featureState(c, name))
+}
+
+// implementation for DELETE /admin/features/:name
+// removes the runtime override, the feature goes back to its default
+func (va *VoterAPI) DeleteFeature(c *fiber.Ctx) error {
+	name, err := featureName(c)
+	if err != nil {
+		return err
+	}
+
+	if err := va.store(c).ClearFeatureFlag(name); err != nil {
+		requestLogger(c).Error("Error clearing feature flag", "error", err)
+		return dbError(err)
+	}
+
+	va.audit(c, "feature.reset", 0, name)
+	return c.JSON(va.featureState(c, name))
+}
@@ -0,0 +1,166 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/adllev/Voter-Container/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// implementation for GET /groups
+// returns every voter group ordered by id
+func (va *VoterAPI) ListGroups(c *fiber.Ctx) error {
+	groupList, err := va.store(c).GetAllGroups()
+	if err != nil {
+		requestLogger(c).Error("Error getting groups", "error", err)
+		return dbError(err)
+	}
+
+	return c.JSON(emptyIfNil(groupList))
+}
+
+// implementation for GET /groups/:groupid
+// returns a single voter group
+func (va *VoterAPI) GetGroup(c *fiber.Ctx) error {
+	groupId, err := c.ParamsInt("groupid")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	group, err := va.store(c).GetGroup(groupId)
+	if err != nil {
+		requestLogger(c).Info("Group not found", "groupId", groupId, "error", err)
+		return dbError(err)
+	}
+
+	return c.JSON(group)
+}
+
+// implementation for GET /groups/:groupid/voters
+// returns the voters in a group ordered by id, for a rule-based group the
+// ones matching its rule right now
+func (va *VoterAPI) ListGroupVoters(c *fiber.Ctx) error {
+	groupId, err := c.ParamsInt("groupid")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	voterList, err := va.store(c).GetGroupVoters(groupId)
+	if err != nil {
+		requestLogger(c).Info("Error getting group voters", "groupId", groupId, "error", err)
+		return dbError(err)
+	}
+
+	setTotalCount(c, len(voterList))
+	return sendResource(c, emptyIfNil(voterList))
+}
+
+// implementation for POST /groups
+// adds a new group, 409 if the groupId is taken.  A group lists either
+// its members or a rule, not both
+func (va *VoterAPI) PostGroup(c *fiber.Ctx) error {
+	var group db.VoterGroup
+	if err := parseBody(c, &group); err != nil {
+		return err
+	}
+	if ok, err := validateBody(group); !ok {
+		return err
+	}
+
+	added, err := va.store(c).AddGroup(group)
+	if err != nil {
+		requestLogger(c).Error("Error adding group", "error", err)
+		return dbError(err)
+	}
+	requestLogger(c).Info("Added group", "groupId", added.GroupId)
+	return c.JSON(added)
+}
+
+// implementation for PUT /groups/:groupid
+// replaces a group, the groupId in the body may be left out but has to
+// match the path when it is there
+func (va *VoterAPI) UpdateGroup(c *fiber.Ctx) error {
+	groupId, err := c.ParamsInt("groupid")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	var group db.VoterGroup
+	if err := parseBody(c, &group); err != nil {
+		return err
+	}
+	if group.GroupId != 0 && group.GroupId != groupId {
+		return newAPIError(http.StatusConflict, "id_mismatch",
+			fmt.Sprintf("Body groupId %d does not match the path group %d", group.GroupId, groupId), nil)
+	}
+	group.GroupId = groupId
+	if ok, err := validateBody(group); !ok {
+		return err
+	}
+
+	updated, err := va.store(c).UpdateGroup(group)
+	if err != nil {
+		requestLogger(c).Error("Error updating group", "error", err)
+		return dbError(err)
+	}
+
+	return c.JSON(updated)
+}
+
+// implementation for PUT /groups/:groupid/members/:id
+// adds a voter to a manual group, 409 for a rule-based one
+func (va *VoterAPI) PutGroupMember(c *fiber.Ctx) error {
+	return va.setGroupMember(c, true)
+}
+
+// implementation for DELETE /groups/:groupid/members/:id
+// takes a voter out of a manual group, 409 for a rule-based one
+func (va *VoterAPI) DeleteGroupMember(c *fiber.Ctx) error {
+	return va.setGroupMember(c, false)
+}
+
+// setGroupMember is PutGroupMember and DeleteGroupMember.  A voter can
+// only be added once it exists, removing one that was deleted is fine
+func (va *VoterAPI) setGroupMember(c *fiber.Ctx, member bool) error {
+	groupId, err := c.ParamsInt("groupid")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+	voterId, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	if member {
+		if _, err := va.store(c).GetVoter(voterId); err != nil {
+			requestLogger(c).Info("Voter not found", "voterId", voterId, "error", err)
+			return dbError(err)
+		}
+	}
+
+	group, err := va.store(c).SetGroupMember(groupId, voterId, member)
+	if err != nil {
+		requestLogger(c).Error("Error changing group members", "groupId", groupId, "error", err)
+		return dbError(err)
+	}
+
+	return c.JSON(group)
+}
+
+// implementation for DELETE /groups/:groupid
+// deletes a group, 409 while a poll is limited to it
+func (va *VoterAPI) DeleteGroup(c *fiber.Ctx) error {
+	groupId, err := c.ParamsInt("groupid")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	if err := va.store(c).DeleteGroup(groupId); err != nil {
+		requestLogger(c).Error("Error deleting group", "error", err)
+		return dbError(err)
+	}
+	va.audit(c, "group.deleted", 0, fmt.Sprintf("groupId=%d", groupId))
+
+	return c.Status(http.StatusOK).SendString("Delete OK")
+}
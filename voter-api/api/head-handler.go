@@ -0,0 +1,57 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// TotalCountHeader is set on list responses to the number of items in the
+// whole list, also when only a page of it was returned
+const TotalCountHeader = "X-Total-Count"
+
+func setTotalCount(c *fiber.Ctx, total int) {
+	c.Set(TotalCountHeader, strconv.Itoa(total))
+}
+
+// implementation for HEAD /voters
+// returns just the voter count in X-Total-Count, from the id index, so a
+// client can check it without anyone reading the voters.  Filtered lists
+// have to be read to be counted, those are answered like a GET
+func (va *VoterAPI) HeadVoters(c *fiber.Ctx) error {
+	if len(c.Request().URI().QueryString()) > 0 || c.Accepts(fiber.MIMEApplicationJSON, MIMETextCSV) == MIMETextCSV {
+		return va.ListAllVoters(c)
+	}
+
+	total, err := va.store(c).CountVoters()
+	if err != nil {
+		requestLogger(c).Error("Error Counting Voters", "error", err)
+		return dbError(err)
+	}
+
+	setTotalCount(c, total)
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	return c.SendStatus(http.StatusOK)
+}
+
+// implementation for HEAD /voters/:id
+// 200 if the voter exists and 404 if not, without reading it
+func (va *VoterAPI) HeadVoter(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	exists, err := va.store(c).VoterExists(id)
+	if err != nil {
+		requestLogger(c).Error("Error checking voter", "error", err)
+		return dbError(err)
+	}
+	if !exists {
+		return fiber.NewError(http.StatusNotFound)
+	}
+
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	return c.SendStatus(http.StatusOK)
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/adllev/Voter-Container/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// IdempotencyKeyHeader lets a client retry a POST safely, every retry
+	// with the same key gets the first response back
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader marks a response that was replayed
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLength = 255
+)
+
+// Idempotency is a route middleware for the POSTs that create things.  The
+// first response for an Idempotency-Key is stored in redis and replayed to
+// every retry, so a client that timed out can send the request again
+// without creating a second voter or vote.  Keys belong to the caller, two
+// clients can not see each other's responses by guessing keys
+func (va *VoterAPI) Idempotency(c *fiber.Ctx) error {
+	idempotencyKey := c.Get(IdempotencyKeyHeader)
+	if idempotencyKey == "" {
+		return c.Next()
+	}
+	if len(idempotencyKey) > maxIdempotencyKeyLength {
+		return fiber.NewError(http.StatusBadRequest, "Idempotency-Key is too long")
+	}
+
+	caller := "anonymous"
+	if claims := claims(c); claims != nil {
+		caller = claims.Subject
+	}
+	key := hashParts(caller, tenant(c), idempotencyKey)
+	requestHash := hashParts(c.Method(), c.Path(), string(c.Body()))
+
+	stored, reserved, err := va.store(c).ReserveIdempotencyKey(key, requestHash)
+	if err != nil {
+		//Without redis the request would fail anyway, let it say why
+		requestLogger(c).Error("Error reserving idempotency key", "error", err)
+		return c.Next()
+	}
+
+	if !reserved {
+		switch {
+		case stored.RequestHash != requestHash:
+			return fiber.NewError(http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request")
+		case !stored.Complete:
+			return fiber.NewError(http.StatusConflict, "A request with this Idempotency-Key is still in progress")
+		}
+
+		c.Set(IdempotentReplayedHeader, "true")
+		c.Set(fiber.HeaderContentType, stored.ContentType)
+		return c.Status(stored.Status).Send(stored.Body)
+	}
+
+	//Let the error handler write the response if the handler fails, so a
+	//retry gets the same error the first attempt did
+	if err := c.Next(); err != nil {
+		if handlerErr := c.App().ErrorHandler(c, err); handlerErr != nil {
+			return handlerErr
+		}
+	}
+
+	//Server errors may be gone on the next try, let the retry run again.
+	//A request that timed out has an expired context, so these writes do
+	//not use it
+	if c.Response().StatusCode() >= http.StatusInternalServerError {
+		if err := va.db.ReleaseIdempotencyKey(key); err != nil {
+			requestLogger(c).Error("Error releasing idempotency key", "error", err)
+		}
+		return nil
+	}
+
+	err = va.db.SaveIdempotentResponse(key, db.IdempotentResponse{
+		RequestHash: requestHash,
+		Status:      c.Response().StatusCode(),
+		ContentType: string(c.Response().Header.ContentType()),
+		Body:        append([]byte(nil), c.Response().Body()...),
+	})
+	if err != nil {
+		requestLogger(c).Error("Error saving idempotent response", "error", err)
+	}
+	return nil
+}
+
+// hashParts hashes values that are stored in redis but may be long or
+// sensitive, the parts are separated so "a"+"bc" and "ab"+"c" differ
+func hashParts(parts ...string) string {
+	hash := sha256.New()
+	for _, part := range parts {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,168 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/adllev/Voter-Container/voter-api/config"
+	"github.com/adllev/Voter-Container/voter-api/db"
+	"github.com/adllev/Voter-Container/voter-api/jobs"
+	"github.com/adllev/Voter-Container/voter-api/reminders"
+	"github.com/adllev/Voter-Container/voter-api/retention"
+	"github.com/adllev/Voter-Container/voter-api/upstream"
+	"github.com/gofiber/fiber/v2"
+)
+
+// newJobScheduler registers the background jobs on their configured
+// schedules.  The jobs that work on election data go through every
+// tenant.  sync, reminders and retention are only there when they are
+// configured
+func newJobScheduler(cfg config.Config, dbHandler *db.Voter, syncer *upstream.Syncer, reminder *reminders.Scheduler) (*jobs.Scheduler, error) {
+	scheduler := jobs.NewScheduler(dbHandler)
+	add := func(name string, run jobs.Func) error {
+		schedule, err := jobs.ParseSchedule(cfg.JobSchedule(name))
+		if err != nil {
+			return err
+		}
+		scheduler.Add(name, schedule, run)
+		return nil
+	}
+
+	err := errors.Join(
+		add(jobs.JobSnapshots, func(ctx context.Context) (string, error) {
+			polls := 0
+			err := dbHandler.WithContext(ctx).ForEachTenant(func(scoped *db.Voter) error {
+				count, err := scoped.SnapshotResults()
+				polls += count
+				return err
+			})
+			return fmt.Sprintf("polls=%d", polls), err
+		}),
+		add(jobs.JobCapacity, func(ctx context.Context) (string, error) {
+			counts, err := dbHandler.WithContext(ctx).SampleCardinality()
+			return fmt.Sprintf("voters=%d history=%d index=%d",
+				counts[db.CardinalityVoters], counts[db.CardinalityHistory], counts[db.CardinalityIndex]), err
+		}),
+		add(jobs.JobReindex, func(ctx context.Context) (string, error) {
+			voters := 0
+			err := dbHandler.WithContext(ctx).ForEachTenant(func(scoped *db.Voter) error {
+				count, err := scoped.RebuildIndexes()
+				voters += count
+				return err
+			})
+			return fmt.Sprintf("voters=%d", voters), err
+		}),
+		add(jobs.JobCleanup, func(ctx context.Context) (string, error) {
+			var total db.OrphanCleanup
+			err := dbHandler.WithContext(ctx).ForEachTenant(func(scoped *db.Voter) error {
+				cleanup, err := scoped.CleanupOrphanedIndexes()
+				total.VoterIndex += cleanup.VoterIndex
+				total.EmailIndex += cleanup.EmailIndex
+				return err
+			})
+			return fmt.Sprintf("voterIndex=%d emailIndex=%d", total.VoterIndex, total.EmailIndex), err
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if syncer != nil {
+		err := add(jobs.JobSync, func(ctx context.Context) (string, error) {
+			summary, err := syncer.Sync(ctx)
+			return fmt.Sprintf("created=%d updated=%d deactivated=%d reactivated=%d errors=%d",
+				len(summary.Created), len(summary.Updated), len(summary.Deactivated), len(summary.Reactivated), len(summary.Errors)), err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if policy := cfg.Retention.Policy(); policy.Enabled() {
+		var archive db.Archiver
+		if archiver := retention.NewFileArchiver(cfg.Retention.ArchiveFile); archiver != nil {
+			archive = archiver.Archive
+		}
+		err := add(jobs.JobRetention, func(ctx context.Context) (string, error) {
+			var total db.RetentionSummary
+			err := dbHandler.WithContext(ctx).ForEachTenant(func(scoped *db.Voter) error {
+				summary, err := scoped.ApplyRetention(policy, time.Now().UTC(), archive)
+				total.Voters += summary.Voters
+				total.Dropped += summary.Dropped
+				return err
+			})
+			return fmt.Sprintf("voters=%d dropped=%d", total.Voters, total.Dropped), err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if reminder != nil {
+		err := add(jobs.JobReminders, func(ctx context.Context) (string, error) {
+			sent, err := reminder.Remind(time.Now())
+			return fmt.Sprintf("sent=%d", sent), err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return scheduler, nil
+}
+
+// jobError maps the errors of the scheduler to API errors
+func jobError(c *fiber.Ctx, name string, err error) error {
+	switch {
+	case errors.Is(err, jobs.ErrUnknownJob):
+		return newAPIError(http.StatusNotFound, "job_not_found", fmt.Sprintf("There is no job %q", name), nil)
+	case errors.Is(err, jobs.ErrRunning):
+		return newAPIError(http.StatusConflict, "job_running", fmt.Sprintf("Job %q is already running", name), nil)
+	}
+	requestLogger(c).Error("Error reading job", "job", name, "error", err)
+	return dbError(err)
+}
+
+// implementation for GET /admin/jobs
+// lists the background jobs with their schedule, next and latest run and
+// the replica running them right now
+func (va *VoterAPI) ListJobs(c *fiber.Ctx) error {
+	statuses := []jobs.Status{}
+	for _, name := range va.jobs.Jobs() {
+		status, err := va.jobs.Status(name)
+		if err != nil {
+			return jobError(c, name, err)
+		}
+		status.Runs = nil
+		statuses = append(statuses, status)
+	}
+
+	return c.JSON(statuses)
+}
+
+// implementation for GET /admin/jobs/:name
+// returns one background job with its latest runs
+func (va *VoterAPI) GetJob(c *fiber.Ctx) error {
+	name := c.Params("name")
+	status, err := va.jobs.Status(name)
+	if err != nil {
+		return jobError(c, name, err)
+	}
+	status.Runs = emptyIfNil(status.Runs)
+
+	return c.JSON(status)
+}
+
+// implementation for POST /admin/jobs/:name/run
+// runs a job right away on this replica, 202 with the run that started
+// since it goes on after the response.  409 if a replica is running it
+func (va *VoterAPI) PostJobRun(c *fiber.Ctx) error {
+	name := c.Params("name")
+	run, err := va.jobs.Trigger(name)
+	if err != nil {
+		return jobError(c, name, err)
+	}
+
+	va.audit(c, "job.triggered", 0, "job="+name)
+	return c.Status(http.StatusAccepted).JSON(run)
+}
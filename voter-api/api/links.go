@@ -0,0 +1,138 @@
+package api
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/adllev/Voter-Container/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// link is one entry of a _links object.  Templated links hold RFC 6570
+// variables, like {voterId}, that the client fills in
+type link struct {
+	Href      string `json:"href"`
+	Templated bool   `json:"templated,omitempty"`
+}
+
+// links is the _links object of a resource, keyed by relation
+type links map[string]link
+
+// voterResource and pollResource are the JSON representations of voters
+// and vote history, the stored items with _links added.  VoteHistory
+// shadows the field of the embedded VoterItem so every poll gets links too
+type voterResource struct {
+	db.VoterItem
+	VoteHistory []pollResource `json:"voteHistory"`
+	Links       links          `json:"_links"`
+}
+
+type pollResource struct {
+	db.VoterHistory
+	Links links `json:"_links"`
+}
+
+type voterPageResource struct {
+	Voters     []voterResource `json:"voters"`
+	NextCursor string          `json:"nextCursor,omitempty"`
+}
+
+// linkBase is the prefix of the links in a response, links point at the
+// same routes the request came in on, versioned or legacy
+func linkBase(c *fiber.Ctx) string {
+	if strings.HasPrefix(c.Path(), APIPrefix) {
+		return APIPrefix
+	}
+	return ""
+}
+
+func voterLinks(base string, voterId int) links {
+	self := base + "/voters/" + strconv.Itoa(voterId)
+	return links{
+		"self":       {Href: self},
+		"polls":      {Href: self + "/polls"},
+		"collection": {Href: base + "/voters"},
+	}
+}
+
+func pollLinks(base string, voterId int, pollId int) links {
+	voter := base + "/voters/" + strconv.Itoa(voterId)
+	self := voter + "/polls/" + strconv.Itoa(pollId)
+	return links{
+		"self":       {Href: self},
+		"receipt":    {Href: self + "/receipt"},
+		"voter":      {Href: voter},
+		"collection": {Href: voter + "/polls"},
+	}
+}
+
+func newPollResources(base string, voterId int, history []db.VoterHistory) []pollResource {
+	resources := make([]pollResource, 0, len(history))
+	for _, poll := range history {
+		resources = append(resources, pollResource{VoterHistory: poll, Links: pollLinks(base, voterId, poll.PollId)})
+	}
+	return resources
+}
+
+func newVoterResource(base string, voterItem db.VoterItem) voterResource {
+	return voterResource{
+		VoterItem:   voterItem,
+		VoteHistory: newPollResources(base, voterItem.VoterId, voterItem.VoteHistory),
+		Links:       voterLinks(base, voterItem.VoterId),
+	}
+}
+
+func newVoterResources(base string, voterList []db.VoterItem) []voterResource {
+	resources := make([]voterResource, 0, len(voterList))
+	for _, voterItem := range voterList {
+		resources = append(resources, newVoterResource(base, voterItem))
+	}
+	return resources
+}
+
+// withLinks returns the JSON representation of a voter or vote history
+// resource.  Vote history routes are all under /voters/:id, which is
+// where the voter id of their links comes from
+func withLinks(c *fiber.Ctx, resource any) any {
+	base := linkBase(c)
+	switch r := resource.(type) {
+	case db.VoterItem:
+		return newVoterResource(base, r)
+	case []db.VoterItem:
+		return newVoterResources(base, r)
+	case voterPage:
+		return voterPageResource{Voters: newVoterResources(base, r.Voters), NextCursor: r.NextCursor}
+	case db.VoterHistory:
+		voterId, _ := c.ParamsInt("id")
+		return pollResource{VoterHistory: r, Links: pollLinks(base, voterId, r.PollId)}
+	case []db.VoterHistory:
+		voterId, _ := c.ParamsInt("id")
+		return newPollResources(base, voterId, r)
+	}
+	return resource
+}
+
+// implementation for GET /
+// the discovery document, links to the entry points of the API so
+// hypermedia clients can find their way without hardcoding paths
+func (va *VoterAPI) GetRoot(c *fiber.Ctx) error {
+	base := linkBase(c)
+	return c.JSON(fiber.Map{
+		"version": Version,
+		"_links": links{
+			"self":      {Href: base + "/"},
+			"voters":    {Href: base + "/voters"},
+			"voter":     {Href: base + "/voters/{voterId}", Templated: true},
+			"polls":     {Href: base + "/voters/{voterId}/polls", Templated: true},
+			"poll":      {Href: base + "/voters/{voterId}/polls/{pollId}", Templated: true},
+			"search":    {Href: base + "/voters/search{?q,field,limit,offset}", Templated: true},
+			"stream":    {Href: base + "/voters/stream"},
+			"events":    {Href: base + "/voters/events"},
+			"health":    {Href: base + "/voters/health"},
+			"precincts": {Href: base + "/precincts"},
+			"stats":     {Href: base + "/stats"},
+			"turnout":   {Href: base + "/analytics/turnout"},
+			"webhooks":  {Href: base + "/webhooks"},
+		},
+	})
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/adllev/Voter-Container/voter-api/auth"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// SessionCookie holds the session token issued after an OIDC login,
+	// Authenticate accepts it in place of an Authorization header
+	SessionCookie = "voter_session"
+	// SessionTTL is how long an admin session lasts before logging in again
+	SessionTTL = 8 * time.Hour
+
+	// loginCookie carries the state and nonce from /auth/login to the
+	// callback, it only has to live as long as the login takes
+	loginCookie    = "voter_login"
+	loginCookieTTL = 10 * time.Minute
+)
+
+// implementation for GET /auth/login
+// redirects the browser to the identity provider
+func (va *VoterAPI) Login(c *fiber.Ctx) error {
+	if va.oidc == nil || va.jwt == nil {
+		return fiber.NewError(http.StatusNotFound, "Login is not configured")
+	}
+
+	state, err := auth.RandomState()
+	if err != nil {
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+	nonce, err := auth.RandomState()
+	if err != nil {
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+
+	redirectURL, err := va.oidc.AuthCodeURL(c.Context(), state, nonce)
+	if err != nil {
+		requestLogger(c).Error("Error starting login", "error", err)
+		return fiber.NewError(http.StatusBadGateway, "Identity provider unavailable")
+	}
+
+	//The callback is a cross site navigation from the provider, so this
+	//cookie has to be Lax or the browser would not send it back
+	c.Cookie(&fiber.Cookie{
+		Name:     loginCookie,
+		Value:    state + "." + nonce,
+		Path:     "/",
+		Expires:  time.Now().Add(loginCookieTTL),
+		HTTPOnly: true,
+		Secure:   c.Protocol() == "https",
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+	return c.Redirect(redirectURL, http.StatusFound)
+}
+
+// implementation for GET /auth/callback
+// finishes the login and issues a session token, as a cookie for browsers
+// and in the body for tools
+func (va *VoterAPI) LoginCallback(c *fiber.Ctx) error {
+	if va.oidc == nil || va.jwt == nil {
+		return fiber.NewError(http.StatusNotFound, "Login is not configured")
+	}
+
+	if providerError := c.Query("error"); providerError != "" {
+		requestLogger(c).Warn("Identity provider refused login", "error", providerError, "description", c.Query("error_description"))
+		return fiber.NewError(http.StatusUnauthorized, "Login failed")
+	}
+
+	state, nonce, found := strings.Cut(c.Cookies(loginCookie), ".")
+	if !found || c.Query("state") != state {
+		return fiber.NewError(http.StatusBadRequest, "Invalid login state")
+	}
+	c.ClearCookie(loginCookie)
+
+	identity, err := va.oidc.Exchange(c.Context(), c.Query("code"), nonce)
+	if err != nil {
+		requestLogger(c).Error("Error finishing login", "error", err)
+		if errors.Is(err, auth.ErrLoginFailed) {
+			return fiber.NewError(http.StatusUnauthorized, "Login failed")
+		}
+		return fiber.NewError(http.StatusBadGateway, "Identity provider unavailable")
+	}
+
+	//The audit log should name a person, fall back to the opaque subject
+	subject := identity.Email
+	if subject == "" {
+		subject = identity.Subject
+	}
+
+	//Only pass on the roles we know about, anything else the identity
+	//provider has in the claim means nothing to us
+	var roles []string
+	for _, role := range identity.Roles {
+		if auth.ValidRole(role) {
+			roles = append(roles, role)
+		}
+	}
+
+	token, err := va.jwt.Sign(subject, roles, tenant(c), SessionTTL)
+	if err != nil {
+		requestLogger(c).Error("Error issuing session", "error", err)
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+
+	expires := time.Now().Add(SessionTTL)
+	c.Cookie(&fiber.Cookie{
+		Name:     SessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HTTPOnly: true,
+		Secure:   c.Protocol() == "https",
+		SameSite: fiber.CookieSameSiteStrictMode,
+	})
+	requestLogger(c).Info("Login", "subject", subject, "roles", roles)
+
+	return c.JSON(fiber.Map{
+		"token":     token,
+		"subject":   subject,
+		"roles":     roles,
+		"expiresAt": expires,
+	})
+}
+
+// implementation for POST /auth/logout
+func (va *VoterAPI) Logout(c *fiber.Ctx) error {
+	c.ClearCookie(SessionCookie)
+	return c.Status(http.StatusOK).SendString("Logged out")
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// implementation for POST /admin/voters/merge
+// merges {"sourceId": 7} into {"targetId": 3}, see db.MergeVoters.  The
+// source voter is gone afterwards, its votes belong to the target
+func (va *VoterAPI) MergeVoters(c *fiber.Ctx) error {
+	var req struct {
+		TargetId int `json:"targetId" validate:"gt=0"`
+		SourceId int `json:"sourceId" validate:"gt=0,nefield=TargetId"`
+	}
+	if err := parseBody(c, &req); err != nil {
+		return err
+	}
+	if ok, err := validateBody(req); !ok {
+		return err
+	}
+
+	result, err := va.store(c).MergeVoters(req.TargetId, req.SourceId)
+	if err != nil {
+		requestLogger(c).Error("Error merging voters", "targetId", req.TargetId, "sourceId", req.SourceId, "error", err)
+		return dbError(err)
+	}
+	va.audit(c, "voter.merged", req.TargetId,
+		fmt.Sprintf("sourceId=%d droppedVotes=%v", req.SourceId, result.DroppedVotes))
+
+	return c.JSON(result)
+}
+
+// implementation for GET /admin/voters/duplicates
+// lists the groups of voters that share a normalized email or name, they
+// are candidates for POST /admin/voters/merge
+func (va *VoterAPI) ListDuplicateVoters(c *fiber.Ctx) error {
+	groups, err := va.store(c).FindDuplicateVoters()
+	if err != nil {
+		requestLogger(c).Error("Error finding duplicate voters", "error", err)
+		return dbError(err)
+	}
+
+	return c.JSON(groups)
+}
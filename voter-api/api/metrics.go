@@ -0,0 +1,135 @@
+package api
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/gofiber/fiber/v2/middleware/basicauth"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// HTTP metrics, labeled by the route pattern (/voters/:id<int>, not
+// /voters/42) so the number of series does not grow with the data
+var (
+	httpRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "voter",
+			Subsystem: "http",
+			Name:      "requests_total",
+			Help:      "Number of HTTP requests, by method, route and status.",
+		},
+		[]string{"method", "route", "status"},
+	)
+
+	httpRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "voter",
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "Latency of HTTP requests, by method and route.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"method", "route"},
+	)
+
+	httpRequestsInFlight = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "voter",
+			Subsystem: "http",
+			Name:      "requests_in_flight",
+			Help:      "Number of HTTP requests being handled, by method and route.",
+		},
+		[]string{"method", "route"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(httpRequestsTotal, httpRequestDuration, httpRequestsInFlight)
+}
+
+// instrument is a route level handler, so unlike a middleware added with
+// app.Use it knows which route matched
+func instrument(c *fiber.Ctx) error {
+	method, route := c.Method(), c.Route().Path
+	start := time.Now()
+
+	inFlight := httpRequestsInFlight.WithLabelValues(method, route)
+	inFlight.Inc()
+	defer inFlight.Dec()
+
+	err := c.Next()
+
+	httpRequestsTotal.WithLabelValues(method, route, strconv.Itoa(responseStatus(c, err))).Inc()
+	httpRequestDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+	return err
+}
+
+// instrumentedRouter puts instrument in front of the handlers of every
+// route registered through it
+type instrumentedRouter struct {
+	fiber.Router
+}
+
+// Instrument wraps a router so every route registered on it is measured
+func Instrument(router fiber.Router) fiber.Router {
+	return instrumentedRouter{Router: router}
+}
+
+func (r instrumentedRouter) Get(path string, handlers ...fiber.Handler) fiber.Router {
+	return r.Router.Get(path, append([]fiber.Handler{instrument}, handlers...)...)
+}
+
+func (r instrumentedRouter) Head(path string, handlers ...fiber.Handler) fiber.Router {
+	return r.Router.Head(path, append([]fiber.Handler{instrument}, handlers...)...)
+}
+
+func (r instrumentedRouter) Post(path string, handlers ...fiber.Handler) fiber.Router {
+	return r.Router.Post(path, append([]fiber.Handler{instrument}, handlers...)...)
+}
+
+func (r instrumentedRouter) Put(path string, handlers ...fiber.Handler) fiber.Router {
+	return r.Router.Put(path, append([]fiber.Handler{instrument}, handlers...)...)
+}
+
+func (r instrumentedRouter) Patch(path string, handlers ...fiber.Handler) fiber.Router {
+	return r.Router.Patch(path, append([]fiber.Handler{instrument}, handlers...)...)
+}
+
+func (r instrumentedRouter) Delete(path string, handlers ...fiber.Handler) fiber.Router {
+	return r.Router.Delete(path, append([]fiber.Handler{instrument}, handlers...)...)
+}
+
+func (r instrumentedRouter) Group(prefix string, handlers ...fiber.Handler) fiber.Router {
+	return instrumentedRouter{Router: r.Router.Group(prefix, handlers...)}
+}
+
+// MetricsHandlers serves the prometheus metrics, the HTTP metrics above,
+// the db operation metrics and the go runtime ones.  When METRICS_USER
+// and METRICS_PASSWORD are set the scraper has to send them with basic
+// auth
+func MetricsHandlers() []fiber.Handler {
+	handlers := []fiber.Handler{}
+
+	user, password := os.Getenv("METRICS_USER"), os.Getenv("METRICS_PASSWORD")
+	if user != "" && password != "" {
+		handlers = append(handlers, basicauth.New(basicauth.Config{
+			Realm: "metrics",
+			Authorizer: func(u string, p string) bool {
+				//Compare both so a wrong user takes as long as a wrong password
+				userOK := subtle.ConstantTimeCompare([]byte(u), []byte(user)) == 1
+				passwordOK := subtle.ConstantTimeCompare([]byte(p), []byte(password)) == 1
+				return userOK && passwordOK
+			},
+		}))
+	} else {
+		slog.Warn("METRICS_USER and METRICS_PASSWORD not set, /metrics is public")
+	}
+
+	return append(handlers, adaptor.HTTPHandler(promhttp.Handler()))
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"encoding/xml"
+
+	"github.com/adllev/Voter-Container/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// voterXML, voterListXML, pollXML and pollListXML give the XML documents
+// root elements named like the resources, encoding/xml would otherwise use
+// the Go type names and can not encode a bare slice as one document
+type voterXML struct {
+	XMLName xml.Name `xml:"voter"`
+	db.VoterItem
+}
+
+type voterListXML struct {
+	XMLName    xml.Name       `xml:"voters"`
+	NextCursor string         `xml:"nextCursor,attr,omitempty"`
+	Voters     []db.VoterItem `xml:"voter"`
+}
+
+type pollXML struct {
+	XMLName xml.Name `xml:"poll"`
+	db.VoterHistory
+}
+
+type pollListXML struct {
+	XMLName xml.Name          `xml:"voteHistory"`
+	Polls   []db.VoterHistory `xml:"poll"`
+}
+
+// sendResource writes a voter or vote history resource as JSON with its
+// _links, or as XML when the client asks for application/xml, for the
+// upstream election systems that only consume XML.  Anything else is
+// always JSON
+func sendResource(c *fiber.Ctx, resource any) error {
+	c.Vary(fiber.HeaderAccept)
+	if c.Accepts(fiber.MIMEApplicationJSON, fiber.MIMEApplicationXML) != fiber.MIMEApplicationXML {
+		return c.JSON(withLinks(c, resource))
+	}
+
+	var document any
+	switch r := resource.(type) {
+	case db.VoterItem:
+		document = voterXML{VoterItem: r}
+	case []db.VoterItem:
+		document = voterListXML{Voters: r}
+	case voterPage:
+		document = voterListXML{Voters: r.Voters, NextCursor: r.NextCursor}
+	case db.VoterHistory:
+		document = pollXML{VoterHistory: r}
+	case []db.VoterHistory:
+		document = pollListXML{Polls: r}
+	default:
+		return c.JSON(resource)
+	}
+
+	body, err := xml.Marshal(document)
+	if err != nil {
+		return err
+	}
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationXMLCharsetUTF8)
+	return c.Send(append([]byte(xml.Header), body...))
+}
@@ -0,0 +1,217 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/adllev/Voter-Container/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// implementation for GET /polls?tag=budget&category=finance
+// returns every poll ordered by id, only those with the tag and in the
+// category when they are asked for
+func (va *VoterAPI) ListPolls(c *fiber.Ctx) error {
+	pollList, err := va.store(c).GetAllPolls()
+	if err != nil {
+		requestLogger(c).Error("Error getting polls", "error", err)
+		return dbError(err)
+	}
+
+	if filterByPoll(c) {
+		matching := []db.Poll{}
+		for _, poll := range pollList {
+			if pollMatches(c, poll) {
+				matching = append(matching, poll)
+			}
+		}
+		pollList = matching
+	}
+
+	return c.JSON(emptyIfNil(pollList))
+}
+
+// filterByPoll reports whether the request asks for the polls, or votes
+// in the polls, with a tag or in a category
+func filterByPoll(c *fiber.Ctx) bool {
+	return c.Query("tag") != "" || c.Query("category") != ""
+}
+
+// pollMatches reports whether the poll has the ?tag= and is in the
+// ?category= of the request
+func pollMatches(c *fiber.Ctx, poll db.Poll) bool {
+	if tag := c.Query("tag"); tag != "" && !poll.HasTag(tag) {
+		return false
+	}
+	if category := c.Query("category"); category != "" && !poll.InCategory(category) {
+		return false
+	}
+	return true
+}
+
+// normalizePoll tidies up the category and tags of a poll sent by a
+// client, see db.NormalizeTags
+func normalizePoll(poll *db.Poll) {
+	poll.Category = strings.TrimSpace(poll.Category)
+	poll.Tags = db.NormalizeTags(poll.Tags)
+}
+
+// implementation for GET /polls/:pollid
+// returns a single poll with its options
+func (va *VoterAPI) GetPoll(c *fiber.Ctx) error {
+	pollId, err := c.ParamsInt("pollid")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	poll, err := va.store(c).GetPoll(pollId)
+	if err != nil {
+		requestLogger(c).Info("Poll not found", "pollId", pollId, "error", err)
+		return dbError(err)
+	}
+
+	return c.JSON(poll)
+}
+
+// implementation for GET /polls/:pollid/results?method=irv
+// returns the votes per option of a poll, with method=irv also the rounds
+// of an instant-runoff over the rankings.  The tally is cached until the
+// next vote, once the poll closed it is frozen when freezeResults is on.
+// A poll with decision rules also gets its outcome, passed, failed or
+// inconclusive, see db.PollOutcome
+func (va *VoterAPI) GetPollResults(c *fiber.Ctx) error {
+	pollId, err := c.ParamsInt("pollid")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+	method := c.Query("method", db.MethodPlurality)
+	if !db.ValidMethod(method) {
+		return newAPIError(http.StatusBadRequest, "invalid_method",
+			fmt.Sprintf("method must be %s or %s", db.MethodPlurality, db.MethodIRV), nil)
+	}
+
+	poll, err := va.store(c).GetPoll(pollId)
+	if err != nil {
+		requestLogger(c).Info("Poll not found", "pollId", pollId, "error", err)
+		return dbError(err)
+	}
+
+	results, err := va.store(c).GetPollResults(poll, method, va.freezeResults)
+	if err != nil {
+		requestLogger(c).Error("Error tallying poll", "pollId", pollId, "error", err)
+		return dbError(err)
+	}
+
+	results.Outcome, err = va.store(c).EvaluatePoll(poll, results, time.Now())
+	if err != nil {
+		requestLogger(c).Error("Error evaluating poll", "pollId", pollId, "error", err)
+		return dbError(err)
+	}
+
+	return c.JSON(results)
+}
+
+// implementation for GET /polls/:pollid/results/history?from=&to=
+// returns the snapshots of the results of a poll, oldest first, see
+// db.SnapshotResults.  from and to are RFC 3339 times and both optional
+func (va *VoterAPI) GetResultsHistory(c *fiber.Ctx) error {
+	pollId, err := c.ParamsInt("pollid")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	var bounds [2]time.Time
+	for i, name := range []string{"from", "to"} {
+		value := c.Query(name)
+		if value == "" {
+			continue
+		}
+		if bounds[i], err = time.Parse(time.RFC3339, value); err != nil {
+			return fiber.NewError(http.StatusBadRequest, name+" must be an RFC 3339 time")
+		}
+	}
+
+	if _, err := va.store(c).GetPoll(pollId); err != nil {
+		requestLogger(c).Info("Poll not found", "pollId", pollId, "error", err)
+		return dbError(err)
+	}
+
+	snapshots, err := va.store(c).GetResultsHistory(pollId, bounds[0], bounds[1])
+	if err != nil {
+		requestLogger(c).Error("Error getting results history", "pollId", pollId, "error", err)
+		return dbError(err)
+	}
+
+	return c.JSON(fiber.Map{"pollId": pollId, "snapshots": snapshots})
+}
+
+// implementation for POST /polls
+// adds a new poll, 409 if the pollId is taken
+func (va *VoterAPI) PostPoll(c *fiber.Ctx) error {
+	var poll db.Poll
+	if err := parseBody(c, &poll); err != nil {
+		return err
+	}
+	normalizePoll(&poll)
+	if ok, err := validateBody(poll); !ok {
+		return err
+	}
+
+	if err := va.store(c).AddPoll(poll); err != nil {
+		requestLogger(c).Error("Error adding poll", "error", err)
+		return dbError(err)
+	}
+	requestLogger(c).Info("Added poll", "pollId", poll.PollId)
+	return c.JSON(poll)
+}
+
+// implementation for PUT /polls/:pollid
+// replaces a poll, like PUT /voters/:id the pollId in the body may be left
+// out but has to match the path when it is there
+func (va *VoterAPI) UpdatePoll(c *fiber.Ctx) error {
+	pollId, err := c.ParamsInt("pollid")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	var poll db.Poll
+	if err := parseBody(c, &poll); err != nil {
+		return err
+	}
+	if poll.PollId != 0 && poll.PollId != pollId {
+		return newAPIError(http.StatusConflict, "id_mismatch",
+			fmt.Sprintf("Body pollId %d does not match the path poll %d", poll.PollId, pollId), nil)
+	}
+	poll.PollId = pollId
+	normalizePoll(&poll)
+	if ok, err := validateBody(poll); !ok {
+		return err
+	}
+
+	if err := va.store(c).UpdatePoll(poll); err != nil {
+		requestLogger(c).Error("Error updating poll", "error", err)
+		return dbError(err)
+	}
+
+	return c.JSON(poll)
+}
+
+// implementation for DELETE /polls/:pollid
+// deletes a poll, the votes already recorded for it stay in the voter
+// histories
+func (va *VoterAPI) DeletePoll(c *fiber.Ctx) error {
+	pollId, err := c.ParamsInt("pollid")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	if err := va.store(c).DeletePoll(pollId); err != nil {
+		requestLogger(c).Error("Error deleting poll", "error", err)
+		return dbError(err)
+	}
+	va.audit(c, "poll.deleted", 0, fmt.Sprintf("pollId=%d", pollId))
+
+	return c.Status(http.StatusOK).SendString("Delete OK")
+}
@@ -0,0 +1,271 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adllev/Voter-Container/voter-api/auth"
+	"github.com/adllev/Voter-Container/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// PollAPITimeout bounds how long the poll-api may take to answer a lookup
+const PollAPITimeout = 5 * time.Second
+
+// OverrideWindowQuery is the query parameter with which an admin records a
+// vote outside the voting window of its poll, a late postal ballot say
+const OverrideWindowQuery = "overrideWindow"
+
+// errPollLookup is returned when the poll-api could not be asked, as
+// opposed to answering that there is no such poll
+var errPollLookup = errors.New("poll lookup failed")
+
+// pollAPI looks polls up in a separate poll-api, see config.PollAPIURL.
+// The tenant of the request is passed on in X-Tenant-ID
+type pollAPI struct {
+	baseURL string
+	client  *http.Client
+}
+
+func newPollAPI(baseURL string) *pollAPI {
+	if baseURL == "" {
+		return nil
+	}
+	return &pollAPI{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client:  &http.Client{Timeout: PollAPITimeout},
+	}
+}
+
+// GetPoll returns one poll from the poll-api, db.ErrNotFound when it
+// answers 404
+func (p *pollAPI) GetPoll(ctx context.Context, tenantID string, pollId int) (db.Poll, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.baseURL+"/polls/"+url.PathEscape(strconv.Itoa(pollId)), nil)
+	if err != nil {
+		return db.Poll{}, err
+	}
+	req.Header.Set(fiber.HeaderAccept, fiber.MIMEApplicationJSON)
+	if tenantID != "" {
+		req.Header.Set(TenantIDHeader, tenantID)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return db.Poll{}, fmt.Errorf("%w: %w", errPollLookup, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return db.Poll{}, db.ErrNotFound
+	case resp.StatusCode != http.StatusOK:
+		return db.Poll{}, fmt.Errorf("%w: poll-api answered %d", errPollLookup, resp.StatusCode)
+	}
+
+	var poll db.Poll
+	if err := json.NewDecoder(resp.Body).Decode(&poll); err != nil {
+		return db.Poll{}, fmt.Errorf("%w: %w", errPollLookup, err)
+	}
+	return poll, nil
+}
+
+// lookupPoll returns the poll a vote refers to, from the poll-api when one
+// is configured and from the polls of the tenant otherwise
+func (va *VoterAPI) lookupPoll(c *fiber.Ctx, pollId int) (db.Poll, error) {
+	if va.pollAPI != nil {
+		return va.pollAPI.GetPoll(c.UserContext(), tenant(c), pollId)
+	}
+	return va.store(c).GetPoll(pollId)
+}
+
+// pathPoll returns the poll named by the path of a request that reads
+// about it, a 404 when there is none
+func (va *VoterAPI) pathPoll(c *fiber.Ctx, pollId int) (db.Poll, error) {
+	poll, err := va.lookupPoll(c, pollId)
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		return db.Poll{}, newAPIError(http.StatusNotFound, "poll_not_found", fmt.Sprintf("Poll %d does not exist", pollId), nil)
+	case errors.Is(err, errPollLookup):
+		requestLogger(c).Error("Error looking up poll", "pollId", pollId, "error", err)
+		return db.Poll{}, newAPIError(http.StatusBadGateway, "poll_lookup_failed", "Could not check the poll, try again later", nil)
+	case err != nil:
+		requestLogger(c).Error("Error looking up poll", "pollId", pollId, "error", err)
+		return db.Poll{}, dbError(err)
+	}
+	return poll, nil
+}
+
+// checkPollRef makes sure a vote refers to a poll that exists, so voter
+// histories can not name phantom polls.  A poll named by the path is a
+// 404 when missing, one named in the body a 422 on the pollId field.  An
+// optionId above zero also has to be one of the options of the poll, a 422
+// on optionField otherwise, and the poll has to be open, see
+// checkPollWindow.  The poll is returned for further checks
+func (va *VoterAPI) checkPollRef(c *fiber.Ctx, pollId int, inPath bool, optionField string, optionId int) (db.Poll, error) {
+	poll, err := va.lookupPoll(c, pollId)
+	switch {
+	case errors.Is(err, db.ErrNotFound) && inPath:
+		return db.Poll{}, newAPIError(http.StatusNotFound, "poll_not_found", fmt.Sprintf("Poll %d does not exist", pollId), nil)
+	case errors.Is(err, db.ErrNotFound):
+		return db.Poll{}, validationError([]fieldError{{Field: "pollId", Reason: "must be an existing poll"}})
+	case errors.Is(err, errPollLookup):
+		requestLogger(c).Error("Error looking up poll", "pollId", pollId, "error", err)
+		return db.Poll{}, newAPIError(http.StatusBadGateway, "poll_lookup_failed", "Could not check the poll, try again later", nil)
+	case err != nil:
+		requestLogger(c).Error("Error looking up poll", "pollId", pollId, "error", err)
+		return db.Poll{}, dbError(err)
+	}
+
+	if err := va.checkPollWindow(c, poll); err != nil {
+		return db.Poll{}, err
+	}
+
+	if optionId <= 0 || poll.HasOption(optionId) {
+		return poll, nil
+	}
+	return db.Poll{}, validationError([]fieldError{{Field: optionField, Reason: "must be an option of the poll"}})
+}
+
+// checkHistoryRefs runs the entries a write of a whole voter adds to its
+// vote history, or changes in it, through checkPollRef.  The entries the
+// voter already had are left alone, a PUT sends them back as they are and
+// their poll may well have closed since
+func (va *VoterAPI) checkHistoryRefs(c *fiber.Ctx, previous []db.VoterHistory, history []db.VoterHistory) error {
+	recorded := make(map[int]db.VoterHistory, len(previous))
+	for _, vh := range previous {
+		recorded[vh.PollId] = vh
+	}
+	for i, vh := range history {
+		if old, found := recorded[vh.PollId]; found && old.VoteId == vh.VoteId && old.OptionId == vh.OptionId {
+			continue
+		}
+		if _, err := va.checkPollRef(c, vh.PollId, false, fmt.Sprintf("voteHistory[%d].optionId", i), vh.OptionId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkPollWindow rejects a vote for a poll that is not open yet or has
+// closed with a 403, poll_not_open or poll_closed.  Admins can record it
+// anyway with ?overrideWindow=true, which is audited
+func (va *VoterAPI) checkPollWindow(c *fiber.Ctx, poll db.Poll) error {
+	window := poll.Window(time.Now())
+	if window == db.PollOpen {
+		return nil
+	}
+
+	if c.QueryBool(OverrideWindowQuery) {
+		//Without authentication there is nobody to tell apart from an admin
+		if claims := claims(c); claims != nil && !claims.HasRole(auth.RoleAdmin) {
+			return fiber.NewError(http.StatusForbidden, "Requires the "+auth.RoleAdmin+" role to vote outside the poll window")
+		}
+		voterId, _ := c.ParamsInt("id")
+		va.audit(c, "vote.window_override", voterId, fmt.Sprintf("pollId=%d window=%s", poll.PollId, window))
+		return nil
+	}
+
+	details := fiber.Map{"opensAt": poll.OpensAt, "closesAt": poll.ClosesAt}
+	if window == db.PollNotOpen {
+		return newAPIError(http.StatusForbidden, "poll_not_open", fmt.Sprintf("Poll %d is not open for votes yet", poll.PollId), details)
+	}
+	return newAPIError(http.StatusForbidden, "poll_closed", fmt.Sprintf("Poll %d is closed", poll.PollId), details)
+}
+
+// checkEligibility rejects a vote in a poll restricted to precincts or
+// groups the voter is not in with a 403, voter_not_eligible.  A voter that
+// does not exist is left for the write to report
+func (va *VoterAPI) checkEligibility(c *fiber.Ctx, poll db.Poll, voterId int) error {
+	if len(poll.Precincts) == 0 && len(poll.Groups) == 0 {
+		return nil
+	}
+	voterItem, err := va.store(c).GetVoter(voterId)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		requestLogger(c).Error("Error looking up voter", "voterId", voterId, "error", err)
+		return dbError(err)
+	}
+	groups, err := va.store(c).GetGroupsById(poll.Groups)
+	if err != nil {
+		requestLogger(c).Error("Error looking up voter groups", "pollId", poll.PollId, "error", err)
+		return dbError(err)
+	}
+	if poll.Eligible(voterItem, groups) {
+		return nil
+	}
+
+	if len(poll.Groups) > 0 {
+		details := fiber.Map{"precincts": poll.Precincts, "precinctId": voterItem.PrecinctId, "groups": poll.Groups}
+		return newAPIError(http.StatusForbidden, "voter_not_eligible",
+			fmt.Sprintf("Voter %d is not in a precinct or group of poll %d", voterId, poll.PollId), details)
+	}
+	details := fiber.Map{"precincts": poll.Precincts, "precinctId": voterItem.PrecinctId}
+	return newAPIError(http.StatusForbidden, "voter_not_eligible",
+		fmt.Sprintf("Voter %d is not in a precinct of poll %d", voterId, poll.PollId), details)
+}
+
+// checkBallot makes sure a vote either picks an option or, in a poll that
+// allows them, writes in an answer of its own, and normalizes the write-in.
+// In a ranked poll it has to rank options instead, see checkRanking
+func checkBallot(poll db.Poll, vote *db.Vote) error {
+	if poll.Ranked {
+		return checkRanking(poll, vote)
+	}
+	if len(vote.Ranking) > 0 {
+		return validationError([]fieldError{{Field: "ranking", Reason: "is only allowed in a ranked poll"}})
+	}
+
+	vote.WriteIn = db.NormalizeWriteIn(vote.WriteIn)
+	switch {
+	case vote.WriteIn == "" && vote.VoteValue == 0:
+		return validationError([]fieldError{{Field: "voteValue", Reason: "is required without a writeIn"}})
+	case vote.WriteIn != "" && vote.VoteValue != 0:
+		return validationError([]fieldError{{Field: "writeIn", Reason: "can not be sent with a voteValue"}})
+	case vote.WriteIn != "" && !poll.AllowWriteIns:
+		return validationError([]fieldError{{Field: "writeIn", Reason: "is not allowed in this poll"}})
+	}
+	return nil
+}
+
+// checkRanking makes sure the ranking of a vote in a ranked poll lists
+// options of the poll, each at most once.  The first choice becomes the
+// voteValue, a voteValue that was sent has to be it
+func checkRanking(poll db.Poll, vote *db.Vote) error {
+	if len(vote.Ranking) == 0 {
+		return validationError([]fieldError{{Field: "ranking", Reason: "is required in a ranked poll"}})
+	}
+	if vote.WriteIn != "" {
+		return validationError([]fieldError{{Field: "writeIn", Reason: "is not allowed in this poll"}})
+	}
+
+	options := make(map[int]bool, len(poll.Options))
+	for _, option := range poll.Options {
+		options[option.OptionId] = true
+	}
+	ranked := make(map[int]bool, len(vote.Ranking))
+	for i, optionId := range vote.Ranking {
+		field := fmt.Sprintf("ranking[%d]", i)
+		switch {
+		case !options[optionId]:
+			return validationError([]fieldError{{Field: field, Reason: "must be an option of the poll"}})
+		case ranked[optionId]:
+			return validationError([]fieldError{{Field: field, Reason: "must not rank an option twice"}})
+		}
+		ranked[optionId] = true
+	}
+
+	if vote.VoteValue != 0 && vote.VoteValue != vote.Ranking[0] {
+		return validationError([]fieldError{{Field: "voteValue", Reason: "must be the first option of the ranking"}})
+	}
+	vote.VoteValue = vote.Ranking[0]
+	return nil
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/adllev/Voter-Container/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// implementation for GET /precincts
+// returns every precinct ordered by id
+func (va *VoterAPI) ListPrecincts(c *fiber.Ctx) error {
+	precinctList, err := va.store(c).GetAllPrecincts()
+	if err != nil {
+		requestLogger(c).Error("Error getting precincts", "error", err)
+		return dbError(err)
+	}
+
+	return c.JSON(emptyIfNil(precinctList))
+}
+
+// implementation for GET /precincts/:precinctid
+// returns a single precinct
+func (va *VoterAPI) GetPrecinct(c *fiber.Ctx) error {
+	precinctId, err := c.ParamsInt("precinctid")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	precinct, err := va.store(c).GetPrecinct(precinctId)
+	if err != nil {
+		requestLogger(c).Info("Precinct not found", "precinctId", precinctId, "error", err)
+		return dbError(err)
+	}
+
+	return c.JSON(precinct)
+}
+
+// implementation for GET /precincts/:precinctid/voters
+// returns the voters assigned to a precinct ordered by id
+func (va *VoterAPI) ListPrecinctVoters(c *fiber.Ctx) error {
+	precinctId, err := c.ParamsInt("precinctid")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	voterList, err := va.store(c).GetPrecinctVoters(precinctId)
+	if err != nil {
+		requestLogger(c).Info("Error getting precinct voters", "precinctId", precinctId, "error", err)
+		return dbError(err)
+	}
+
+	return sendResource(c, emptyIfNil(voterList))
+}
+
+// implementation for POST /precincts
+// adds a new precinct, 409 if the precinctId is taken
+func (va *VoterAPI) PostPrecinct(c *fiber.Ctx) error {
+	var precinct db.Precinct
+	if err := parseBody(c, &precinct); err != nil {
+		return err
+	}
+	if ok, err := validateBody(precinct); !ok {
+		return err
+	}
+
+	if err := va.store(c).AddPrecinct(precinct); err != nil {
+		requestLogger(c).Error("Error adding precinct", "error", err)
+		return dbError(err)
+	}
+	requestLogger(c).Info("Added precinct", "precinctId", precinct.PrecinctId)
+	return c.JSON(precinct)
+}
+
+// implementation for PUT /precincts/:precinctid
+// replaces a precinct, the precinctId in the body may be left out but has
+// to match the path when it is there
+func (va *VoterAPI) UpdatePrecinct(c *fiber.Ctx) error {
+	precinctId, err := c.ParamsInt("precinctid")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	var precinct db.Precinct
+	if err := parseBody(c, &precinct); err != nil {
+		return err
+	}
+	if precinct.PrecinctId != 0 && precinct.PrecinctId != precinctId {
+		return newAPIError(http.StatusConflict, "id_mismatch",
+			fmt.Sprintf("Body precinctId %d does not match the path precinct %d", precinct.PrecinctId, precinctId), nil)
+	}
+	precinct.PrecinctId = precinctId
+	if ok, err := validateBody(precinct); !ok {
+		return err
+	}
+
+	if err := va.store(c).UpdatePrecinct(precinct); err != nil {
+		requestLogger(c).Error("Error updating precinct", "error", err)
+		return dbError(err)
+	}
+
+	return c.JSON(precinct)
+}
+
+// implementation for DELETE /precincts/:precinctid
+// deletes a precinct, 409 while voters are still assigned to it
+func (va *VoterAPI) DeletePrecinct(c *fiber.Ctx) error {
+	precinctId, err := c.ParamsInt("precinctid")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	if err := va.store(c).DeletePrecinct(precinctId); err != nil {
+		requestLogger(c).Error("Error deleting precinct", "error", err)
+		return dbError(err)
+	}
+	va.audit(c, "precinct.deleted", 0, fmt.Sprintf("precinctId=%d", precinctId))
+
+	return c.Status(http.StatusOK).SendString("Delete OK")
+}
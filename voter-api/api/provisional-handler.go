@@ -0,0 +1,59 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/adllev/Voter-Container/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// implementation for GET /admin/votes/provisional?pollId=
+// returns the provisional votes waiting for a decision, of one poll with
+// pollId
+func (va *VoterAPI) ListProvisionalVotes(c *fiber.Ctx) error {
+	voteList, err := va.store(c).GetProvisionalVotes(c.QueryInt("pollId"))
+	if err != nil {
+		requestLogger(c).Error("Error getting provisional votes", "error", err)
+		return dbError(err)
+	}
+
+	return c.JSON(voteList)
+}
+
+// adjudicate accepts or rejects the provisional vote named by the path, a
+// vote that is not provisional is a 409
+func (va *VoterAPI) adjudicate(c *fiber.Ctx, accept bool) error {
+	voteId, err := c.ParamsInt("voteid")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	var vote db.Vote
+	action := "vote.accepted"
+	if accept {
+		vote, err = va.store(c).AcceptProvisionalVote(voteId)
+	} else {
+		action = "vote.rejected"
+		vote, err = va.store(c).RejectProvisionalVote(voteId)
+	}
+	if err != nil {
+		requestLogger(c).Error("Error adjudicating provisional vote", "voteId", voteId, "accept", accept, "error", err)
+		return dbError(err)
+	}
+	va.audit(c, action, vote.VoterId, fmt.Sprintf("voteId=%d pollId=%d", voteId, vote.PollId))
+
+	return c.JSON(vote)
+}
+
+// implementation for POST /admin/votes/:voteid/accept
+// counts a provisional vote, the results of its poll are tallied again
+func (va *VoterAPI) AcceptProvisionalVote(c *fiber.Ctx) error {
+	return va.adjudicate(c, true)
+}
+
+// implementation for POST /admin/votes/:voteid/reject
+// deletes a provisional vote and its history entry
+func (va *VoterAPI) RejectProvisionalVote(c *fiber.Ctx) error {
+	return va.adjudicate(c, false)
+}
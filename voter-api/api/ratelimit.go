@@ -0,0 +1,65 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/adllev/Voter-Container/voter-api/auth"
+	"github.com/gofiber/fiber/v2"
+)
+
+// isVoteRecording reports whether a request records a vote or a check-in
+func isVoteRecording(c *fiber.Ctx) bool {
+	if isRead(c) {
+		return false
+	}
+	path := apiPath(c)
+	switch {
+	case strings.HasPrefix(path, "/voters/") && strings.Contains(path, "/polls/"):
+		return true
+	case strings.HasPrefix(path, "/votes"), strings.HasPrefix(path, "/checkin"):
+		return true
+	case underPath(path, "/polls") && strings.HasSuffix(path, "/votes/batch"):
+		return true
+	}
+	return false
+}
+
+// RateLimiter is a middleware that limits how fast each client can call
+// us.  Callers with a valid API key are limited per key, everyone else per
+// IP.  A key that does not check out counts against the IP, a made up key
+// per request must not get a fresh bucket every time.  The buckets live in
+// redis so the limits hold across replicas.  If redis can not be reached
+// requests are let through, an outage of the limiter should not become an
+// outage of the api
+func (va *VoterAPI) RateLimiter(c *fiber.Ctx) error {
+	name, limit := "all", va.rateLimit
+	if isVoteRecording(c) {
+		name, limit = "votes", va.voteLimit
+	}
+	if limit.RPS == 0 {
+		return c.Next()
+	}
+
+	//Never put the key itself in redis, the hash identifies it just as well
+	client := "ip:" + c.IP()
+	if key := c.Get(APIKeyHeader); key != "" {
+		if _, err := va.apiKeys.Lookup(key); err == nil {
+			client = "key:" + auth.HashAPIKey(key)
+		}
+	}
+
+	allowed, retryAfter, err := va.store(c).TakeToken(name+":"+client, limit.RPS, limit.Burst)
+	if err != nil {
+		requestLogger(c).Error("Error checking rate limit", "error", err)
+		return c.Next()
+	}
+	if !allowed {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		return fiber.NewError(http.StatusTooManyRequests, "Rate limit exceeded")
+	}
+
+	return c.Next()
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/adllev/Voter-Container/voter-api/db"
+	"github.com/adllev/Voter-Container/voter-api/notifications"
+	"github.com/gofiber/fiber/v2"
+)
+
+// VoteReceiptHeader carries the receipt of a recorded vote as
+// <hash>.<signature>, see db.Receipt.  The full receipt, salt included, is
+// at GET /voters/:id/polls/:pollid/receipt
+const VoteReceiptHeader = "X-Vote-Receipt"
+
+// issueReceipt signs and stores the receipt for a vote that was just
+// recorded and sends it in VoteReceiptHeader.  The vote is in by now, so
+// a receipt that could not be stored is logged rather than failing it
+func (va *VoterAPI) issueReceipt(c *fiber.Ctx, voterId int, pollId int) {
+	if receipt := va.storeReceipt(c, voterId, pollId); receipt != "" {
+		c.Set(VoteReceiptHeader, receipt)
+	}
+}
+
+// storeReceipt signs, stores and emails the receipt for a vote and returns
+// it as hash.signature, empty when it could not be stored
+func (va *VoterAPI) storeReceipt(c *fiber.Ctx, voterId int, pollId int) string {
+	receipt, err := db.NewReceipt(voterId, pollId, time.Now())
+	if err == nil {
+		receipt.Signature = va.receipts.SignBytes([]byte(receipt.Hash))
+		err = va.store(c).SaveReceipt(receipt)
+	}
+	if err != nil {
+		requestLogger(c).Error("Error issuing vote receipt", "voterId", voterId, "pollId", pollId, "error", err)
+		return ""
+	}
+	value := receipt.Hash + "." + receipt.Signature
+	va.emailReceipt(c, voterId, pollId, value)
+	return value
+}
+
+// emailReceipt sends the receipt of a vote to the voter as well, unless
+// they opted out
+func (va *VoterAPI) emailReceipt(c *fiber.Ctx, voterId int, pollId int, receipt string) {
+	voterItem, err := va.store(c).GetVoter(voterId)
+	if err != nil {
+		requestLogger(c).Error("Error looking up voter for receipt email", "voterId", voterId, "error", err)
+		return
+	}
+	data := notifications.TemplateData{PollId: pollId, PollTitle: fmt.Sprintf("poll %d", pollId), Receipt: receipt}
+	if poll, err := va.lookupPoll(c, pollId); err == nil && poll.Title != "" {
+		data.PollTitle = poll.Title
+	}
+	va.notifyVoter(c, voterItem, notifications.EventReceipt, data)
+}
+
+// implementation for GET /voters/:id/polls/:pollid/receipt
+// returns the receipt issued for the vote of a voter in a poll, with
+// counted telling whether the vote is still recorded
+func (va *VoterAPI) GetVoterPollReceipt(c *fiber.Ctx) error {
+	voterId, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+	pollId, err := c.ParamsInt("pollid")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	receipt, err := va.store(c).GetReceipt(voterId, pollId)
+	if err != nil {
+		requestLogger(c).Info("Receipt not found", "voterId", voterId, "pollId", pollId, "error", err)
+		return dbError(err)
+	}
+
+	//A receipt changed in redis would still look fine to the voter
+	//comparing hashes, so the server checks its own signature first
+	if receipt.ComputeHash() != receipt.Hash || !va.receipts.VerifyBytes([]byte(receipt.Hash), receipt.Signature) {
+		requestLogger(c).Error("Stored vote receipt does not verify", "voterId", voterId, "pollId", pollId)
+		return newAPIError(http.StatusInternalServerError, "receipt_invalid", "The stored receipt does not verify", nil)
+	}
+
+	return c.JSON(receipt)
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// PanicReport is what Recover knows about a panic in a handler
+type PanicReport struct {
+	Err       error
+	Stack     []byte
+	RequestId string
+	Method    string
+	Path      string
+	Time      time.Time
+}
+
+// ErrorReporter receives the panics Recover catches.  It has the shape of
+// Sentry's CaptureException, an error plus the context it happened in, so
+// a Sentry hub (or any other error tracker) plugs in with a small adapter
+// that copies the request id and path into tags.  Report is called on the
+// request goroutine, slow reporters should queue the report
+type ErrorReporter interface {
+	Report(report PanicReport)
+}
+
+// LogReporter is an ErrorReporter that writes the panic and its stack to
+// the log.  It is the default until an error tracker is configured
+type LogReporter struct {
+	Logger *slog.Logger
+}
+
+func (lr LogReporter) Report(report PanicReport) {
+	logger := lr.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger.Error("Recovered from panic",
+		"requestId", report.RequestId,
+		"method", report.Method,
+		"path", report.Path,
+		"error", report.Err,
+		"stack", string(report.Stack),
+	)
+}
+
+// Recover is a middleware, after RequestID, that turns a panic in a
+// handler into a 500 with the request id instead of taking the container
+// down.  The panic and its stack go to reporter, the client only gets the
+// error envelope
+func Recover(reporter ErrorReporter) fiber.Handler {
+	if reporter == nil {
+		reporter = LogReporter{}
+	}
+
+	return func(c *fiber.Ctx) (err error) {
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+
+			panicErr, ok := r.(error)
+			if !ok {
+				panicErr = fmt.Errorf("%v", r)
+			}
+			reporter.Report(PanicReport{
+				Err:       panicErr,
+				Stack:     debug.Stack(),
+				RequestId: requestID(c),
+				Method:    c.Method(),
+				Path:      c.Path(),
+				Time:      time.Now(),
+			})
+
+			err = newAPIError(http.StatusInternalServerError, errorCode(http.StatusInternalServerError), "", nil)
+		}()
+
+		return c.Next()
+	}
+}
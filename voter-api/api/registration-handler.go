@@ -0,0 +1,256 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/adllev/Voter-Container/voter-api/auth"
+	"github.com/adllev/Voter-Container/voter-api/cards"
+	"github.com/adllev/Voter-Container/voter-api/db"
+	"github.com/adllev/Voter-Container/voter-api/notifications"
+	"github.com/gofiber/fiber/v2"
+)
+
+// implementation for POST /voters/register
+// self-service sign up.  The voter is stored as pending, whatever status
+// the body has, and is emailed a signed link to GET /voters/verify.  They
+// can not vote until they followed it or an admin activated them with
+// PUT /admin/voters/:id/status.  A voter signing up has not voted yet, is
+// not frozen and registers now, whatever the body says
+func (va *VoterAPI) RegisterVoter(c *fiber.Ctx) error {
+	var voterItem db.VoterItem
+	if err := parseBody(c, &voterItem); err != nil {
+		return err
+	}
+	voterItem.Status = db.VoterStatusPending
+	voterItem.Weight = 0
+	voterItem.VoteHistory = nil
+	voterItem.HistoryHash = ""
+	voterItem.Frozen = false
+	voterItem.Verified = false
+	voterItem.RegisteredAt = nil
+	if ok, err := validateBody(voterItem); !ok {
+		return err
+	}
+
+	if err := va.store(c).RegisterVoter(voterItem); err != nil {
+		requestLogger(c).Error("Error registering voter", "error", err)
+		return dbError(err)
+	}
+	requestLogger(c).Info("Registered voter", "voterId", voterItem.VoterId)
+
+	//The voter is registered either way, a link that did not go out can
+	//be replaced by an admin activating them
+	if err := va.sendVerification(c, voterItem); err != nil && !errors.Is(err, notifications.ErrSuppressed) {
+		requestLogger(c).Error("Error sending verification email", "voterId", voterItem.VoterId, "error", err)
+	}
+
+	return sendResource(c, voterItem)
+}
+
+// sendVerification emails a voter the link that verifies their address.
+// The token is only ever sent to the address, it is not in the response.
+// It names the tenant of the voter, the link does not go through the
+// tenant subdomain or header, and the address, a link sent before the
+// email changed does not verify the new one
+func (va *VoterAPI) sendVerification(c *fiber.Ctx, voterItem db.VoterItem) error {
+	token, err := va.verify.Sign(cards.Payload{
+		VoterId:  voterItem.VoterId,
+		IssuedAt: time.Now().Unix(),
+		Tenant:   tenant(c),
+		Email:    db.NormalizeEmail(voterItem.Email),
+	})
+	if err != nil {
+		return err
+	}
+
+	link := c.BaseURL() + linkBase(c) + "/voters/verify?token=" + url.QueryEscape(token)
+	msg, err := notifications.Render(notifications.EventVerification, voterItem.Email, notifications.TemplateData{
+		Name:     voterItem.Name,
+		Link:     link,
+		ValidFor: va.verificationTTL,
+	})
+	if err != nil {
+		return err
+	}
+	msg.Phone = voterItem.Phone
+	return va.notify.Send(msg)
+}
+
+// verifyNewEmail sends a new verification link to a voter whose email
+// changed, the store no longer has them as verified.  A new voter has no
+// previous email.  Like at sign up the change stands when the link does
+// not go out
+func (va *VoterAPI) verifyNewEmail(c *fiber.Ctx, previous db.VoterItem, voterItem db.VoterItem) {
+	if previous.HasEmail(voterItem.Email) {
+		return
+	}
+	if err := va.sendVerification(c, voterItem); err != nil && !errors.Is(err, notifications.ErrSuppressed) {
+		requestLogger(c).Error("Error sending verification email", "voterId", voterItem.VoterId, "error", err)
+	}
+}
+
+// notifyVoter emails a voter the message of event unless they opted out,
+// see db.VoterItem.EmailOptOut.  The email is a courtesy, a failure to
+// send it is logged rather than failing the request
+func (va *VoterAPI) notifyVoter(c *fiber.Ctx, voterItem db.VoterItem, event string, data notifications.TemplateData) {
+	if voterItem.EmailOptOut || voterItem.Email == "" {
+		return
+	}
+	data.Name = voterItem.Name
+	msg, err := notifications.Render(event, voterItem.Email, data)
+	if err == nil {
+		msg.Phone = voterItem.Phone
+		err = va.notify.Send(msg)
+	}
+	if err != nil && !errors.Is(err, notifications.ErrSuppressed) {
+		requestLogger(c).Error("Error sending email", "event", event, "voterId", voterItem.VoterId, "error", err)
+	}
+}
+
+// implementation for GET /voters/verify?token=
+// the link emailed by RegisterVoter.  A valid token marks the voter as
+// verified and activates them, in the tenant the token names whichever
+// the request is for.  A token that was not signed by us is a 400, one
+// older than the verification ttl a 410 and one for an address the voter
+// no longer has a 409
+func (va *VoterAPI) VerifyVoter(c *fiber.Ctx) error {
+	payload, err := va.verify.Verify(c.Query("token"))
+	if err != nil {
+		return newAPIError(http.StatusBadRequest, "invalid_token", "The verification link is not valid", nil)
+	}
+	if time.Since(time.Unix(payload.IssuedAt, 0)) > va.verificationTTL {
+		return newAPIError(http.StatusGone, "token_expired", "The verification link has expired, ask for a new one", nil)
+	}
+
+	voterItem, err := va.db.WithContext(c.UserContext()).WithTenant(payload.Tenant).VerifyVoter(payload.VoterId, payload.Email)
+	if err != nil {
+		requestLogger(c).Error("Error verifying voter", "voterId", payload.VoterId, "error", err)
+		return dbError(err)
+	}
+	requestLogger(c).Info("Verified voter", "voterId", voterItem.VoterId)
+	va.notifyVoter(c, voterItem, notifications.EventVerified, notifications.TemplateData{})
+
+	return sendResource(c, voterItem)
+}
+
+// implementation for PUT /admin/voters/:id/status
+// moves a voter to another status, {"status": "active"} activates a
+// pending voter and verifies the email of any voter, the admin vouches
+// for it.  A move that is not allowed from the current status is a 409
+func (va *VoterAPI) PutVoterStatus(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	var req struct {
+		Status string `json:"status"`
+	}
+	if err := parseBody(c, &req); err != nil {
+		return err
+	}
+	if !db.ValidVoterStatus(req.Status) {
+		return validationError([]fieldError{{Field: "status", Reason: "must be a known voter status"}})
+	}
+
+	voterItem, err := va.store(c).SetVoterStatus(id, req.Status)
+	if err != nil {
+		requestLogger(c).Error("Error setting voter status", "error", err)
+		return dbError(err)
+	}
+	va.audit(c, "voter.status", id, "status="+req.Status)
+
+	return sendResource(c, voterItem)
+}
+
+// mayWeigh reports whether the request may set the weight of a voter,
+// only admins can.  Without authentication there is nobody to tell apart
+// from an admin
+func mayWeigh(c *fiber.Ctx) bool {
+	claims := claims(c)
+	return claims == nil || claims.HasRole(auth.RoleAdmin)
+}
+
+// implementation for PUT /admin/voters/:id/weight
+// sets the weight the votes of a voter count with in weighted polls,
+// {"weight": 250} for 250 shares.  0 goes back to the default of 1, votes
+// cast already keep the weight they were cast with
+func (va *VoterAPI) PutVoterWeight(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	var req struct {
+		Weight *float64 `json:"weight"`
+	}
+	if err := parseBody(c, &req); err != nil {
+		return err
+	}
+	if req.Weight == nil || *req.Weight < 0 || *req.Weight > db.MaxVoterWeight {
+		return validationError([]fieldError{{Field: "weight", Reason: fmt.Sprintf("must be between 0 and %d", db.MaxVoterWeight)}})
+	}
+
+	voterItem, err := va.store(c).SetVoterWeight(id, *req.Weight)
+	if err != nil {
+		requestLogger(c).Error("Error setting voter weight", "error", err)
+		return dbError(err)
+	}
+	va.audit(c, "voter.weight", id, fmt.Sprintf("weight=%g", *req.Weight))
+
+	return sendResource(c, voterItem)
+}
+
+// transitionVoter moves the voter named by the path to status, for the
+// lifecycle endpoints below.  With from the voter has to have that status
+// now.  ?reason= goes into the audit log like for freezing, a move the
+// lifecycle does not allow is a 409
+func (va *VoterAPI) transitionVoter(c *fiber.Ctx, from string, status string, action string) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	if from != "" {
+		voterItem, err := va.store(c).GetVoter(id)
+		if err != nil {
+			requestLogger(c).Info("Voter not found", "voterId", id, "error", err)
+			return dbError(err)
+		}
+		if voterItem.Status != from {
+			return newAPIError(http.StatusConflict, "conflict", fmt.Sprintf("Voter %d is not %s", id, from), nil)
+		}
+	}
+
+	voterItem, err := va.store(c).SetVoterStatus(id, status)
+	if err != nil {
+		requestLogger(c).Error("Error setting voter status", "voterId", id, "status", status, "error", err)
+		return dbError(err)
+	}
+	va.audit(c, action, id, c.Query("reason"))
+
+	return sendResource(c, voterItem)
+}
+
+// implementation for POST /admin/voters/:id/suspend
+// bars an active voter from voting until they are reinstated
+func (va *VoterAPI) SuspendVoter(c *fiber.Ctx) error {
+	return va.transitionVoter(c, "", db.VoterStatusSuspended, "voter.suspended")
+}
+
+// implementation for POST /admin/voters/:id/reinstate
+// lets a suspended voter vote again
+func (va *VoterAPI) ReinstateVoter(c *fiber.Ctx) error {
+	return va.transitionVoter(c, db.VoterStatusSuspended, db.VoterStatusActive, "voter.reinstated")
+}
+
+// implementation for POST /admin/voters/:id/purge
+// takes a voter off the roll for good, the record stays for the audit
+// trail but is no longer exported and can not be reactivated
+func (va *VoterAPI) PurgeVoter(c *fiber.Ctx) error {
+	return va.transitionVoter(c, "", db.VoterStatusPurged, "voter.purged")
+}
@@ -0,0 +1,180 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/adllev/Voter-Container/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// ReplayCaptureHeader opts a single request in to replay capture
+const ReplayCaptureHeader = "X-Replay-Capture"
+
+// Headers whose values never end up in a capture
+var redactedHeaders = map[string]bool{
+	"authorization": true,
+	"cookie":        true,
+	"set-cookie":    true,
+	"x-api-key":     true,
+	"x-admin-token": true,
+}
+
+// JSON fields whose values are replaced before a body is stored
+var redactedFields = map[string]bool{
+	"email":    true,
+	"phone":    true,
+	"password": true,
+	"token":    true,
+	"payload":  true,
+}
+
+const redacted = "[REDACTED]"
+
+// isAdmin checks the X-Admin-Token header against the admin token of the
+// config, see config.AuthConfig.  If none is configured nobody is an admin
+func (va *VoterAPI) isAdmin(c *fiber.Ctx) bool {
+	if va.adminToken == "" {
+		return false
+	}
+	token := c.Get("X-Admin-Token")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(va.adminToken)) == 1
+}
+
+// redactHeaders copies headers, hiding the values of sensitive ones
+func redactHeaders(visit func(func(key, value []byte))) map[string]string {
+	headers := make(map[string]string)
+	visit(func(key, value []byte) {
+		name := string(key)
+		if redactedHeaders[strings.ToLower(name)] {
+			headers[name] = redacted
+			return
+		}
+		headers[name] = string(value)
+	})
+	return headers
+}
+
+// redactBody hides sensitive fields of a JSON body.  Bodies that are not
+// JSON are not stored at all, we can not tell what is in them
+func redactBody(body []byte) json.RawMessage {
+	if len(body) == 0 {
+		return nil
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		redactedBytes, _ := json.Marshal(redacted)
+		return redactedBytes
+	}
+
+	redactedBytes, err := json.Marshal(redactValue(value))
+	if err != nil {
+		return nil
+	}
+	return redactedBytes
+}
+
+func redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if redactedFields[strings.ToLower(key)] {
+				v[key] = redacted
+			} else {
+				v[key] = redactValue(field)
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactValue(item)
+		}
+	}
+	return value
+}
+
+// ReplayCapture is a middleware that records the full request/response
+// pair of a request sent with X-Replay-Capture: true by an admin, so a bug
+// can be reproduced later with the replay CLI (cmd/replay).  Sensitive
+// headers and fields are redacted before anything is stored
+func (va *VoterAPI) ReplayCapture(c *fiber.Ctx) error {
+	if c.Get(ReplayCaptureHeader) != "true" || !va.isAdmin(c) {
+		return c.Next()
+	}
+
+	capture := db.ReplayCapture{
+		Id:         utils.UUIDv4(),
+		CapturedAt: time.Now(),
+		Request: db.CapturedRequest{
+			Method:  c.Method(),
+			Path:    c.Path(),
+			Query:   string(c.Request().URI().QueryString()),
+			Headers: redactHeaders(c.Request().Header.VisitAll),
+			Body:    redactBody(c.Body()),
+		},
+	}
+	c.Set("X-Replay-Id", capture.Id)
+
+	//Let the error handler write the response if the handler fails, so
+	//the capture holds what the client actually received
+	if err := c.Next(); err != nil {
+		if handlerErr := c.App().ErrorHandler(c, err); handlerErr != nil {
+			return handlerErr
+		}
+	}
+
+	capture.DurationMs = float64(time.Since(capture.CapturedAt).Microseconds()) / 1000
+	capture.Response = db.CapturedResponse{
+		Status:  c.Response().StatusCode(),
+		Headers: redactHeaders(c.Response().Header.VisitAll),
+		Body:    redactBody(c.Response().Body()),
+	}
+
+	//Not the request context, timed out requests are worth capturing
+	if err := va.db.SaveReplayCapture(capture); err != nil {
+		requestLogger(c).Error("Error saving replay capture", "error", err)
+	}
+
+	return nil
+}
+
+// implementation for GET /admin/replays
+// returns the most recent captures, ?limit= defaults to 50
+func (va *VoterAPI) ListReplayCaptures(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 50)
+	if limit <= 0 {
+		return fiber.NewError(http.StatusBadRequest, "limit must be positive")
+	}
+
+	ids, err := va.store(c).GetReplayCaptureIds(limit)
+	if err != nil {
+		requestLogger(c).Error("Error Getting Replay Captures", "error", err)
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+
+	captureList := make([]db.ReplayCapture, 0, len(ids))
+	for _, id := range ids {
+		capture, err := va.store(c).GetReplayCapture(id)
+		if err != nil {
+			continue
+		}
+		captureList = append(captureList, capture)
+	}
+
+	return c.JSON(captureList)
+}
+
+// implementation for GET /admin/replays/:id
+func (va *VoterAPI) GetReplayCapture(c *fiber.Ctx) error {
+	capture, err := va.store(c).GetReplayCapture(c.Params("id"))
+	if err != nil {
+		requestLogger(c).Info("Replay capture not found", "error", err)
+		return dbError(err)
+	}
+
+	return c.JSON(capture)
+}
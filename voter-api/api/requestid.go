@@ -0,0 +1,144 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// RequestIDHeader carries the id of a request between containers.  A
+// caller that already has an id (the poll service, a load balancer) sends
+// it and we keep it, otherwise we make one up.  Either way it is returned
+// on the response and shows up in our log lines
+const RequestIDHeader = fiber.HeaderXRequestID
+
+// requestIDKey is where RequestID stores the id in c.Locals
+const requestIDKey = "requestId"
+
+// maxRequestIDLength keeps a caller from filling our logs through the header
+const maxRequestIDLength = 128
+
+// RequestID is a middleware that accepts or generates the request id
+func RequestID(c *fiber.Ctx) error {
+	id := c.Get(RequestIDHeader)
+	if !validRequestID(id) {
+		id = utils.UUIDv4()
+	}
+
+	c.Locals(requestIDKey, id)
+	c.Set(RequestIDHeader, id)
+	return c.Next()
+}
+
+// validRequestID only accepts printable ASCII without spaces, an id with
+// a newline in it could forge log lines
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// requestID returns the id of the request, see RequestID
+func requestID(c *fiber.Ctx) string {
+	id, _ := c.Locals(requestIDKey).(string)
+	return id
+}
+
+// loggerKey is where RequestLogger stores the request scoped logger
+const loggerKey = "logger"
+
+// RequestLogger is a middleware, after RequestID, that gives every request
+// a logger carrying its id, method and path, and writes the access log
+// line once it is done: status, bytes, latency, client IP and, for voter
+// routes, the voter id.  Successful reads are sampled, see sampleAccessLog
+func (va *VoterAPI) RequestLogger(c *fiber.Ctx) error {
+	start := time.Now()
+	logger := va.log.With(
+		"requestId", requestID(c),
+		"method", c.Method(),
+		"path", c.Path(),
+	)
+	c.Locals(loggerKey, logger)
+
+	err := c.Next()
+
+	status := responseStatus(c, err)
+	if !va.sampleAccessLog(c, status) {
+		return err
+	}
+	attrs := []any{
+		"status", status,
+		"bytes", responseBytes(c),
+		"latency", time.Since(start),
+		"ip", c.IP(),
+	}
+	if voterID, err := c.ParamsInt("id"); err == nil && strings.Contains(c.Route().Path, "/voters/") {
+		attrs = append(attrs, "voterId", voterID)
+	}
+
+	level := slog.LevelInfo
+	if status >= http.StatusInternalServerError {
+		level = slog.LevelError
+	}
+	logger.Log(c.Context(), level, "Request", attrs...)
+
+	return err
+}
+
+// sampleAccessLog decides if a request gets an access log line.  Reads
+// that succeeded are the bulk of the traffic and only a sample of them is
+// logged, everything that changed data or failed always is
+func (va *VoterAPI) sampleAccessLog(c *fiber.Ctx, status int) bool {
+	if va.accessLogSample >= 1 || status >= http.StatusBadRequest {
+		return true
+	}
+	if c.Method() != fiber.MethodGet && c.Method() != fiber.MethodHead {
+		return true
+	}
+	return rand.Float64() < va.accessLogSample
+}
+
+// responseBytes is the size of the response body.  A streamed body is not
+// written yet, reading it here would drain the stream, so its size is the
+// Content-Length or -1 when it is chunked
+func responseBytes(c *fiber.Ctx) int {
+	if c.Response().IsBodyStream() {
+		return c.Response().Header.ContentLength()
+	}
+	return len(c.Response().Body())
+}
+
+// responseStatus is the status a request ends with.  Middleware sees the
+// error before the error handler turns it into a response, so when there
+// is one the status comes from the error
+func responseStatus(c *fiber.Ctx, err error) int {
+	var fiberError *fiber.Error
+	switch {
+	case errors.As(err, &fiberError):
+		return fiberError.Code
+	case err != nil:
+		return http.StatusInternalServerError
+	}
+	return c.Response().StatusCode()
+}
+
+// requestLogger returns the logger of the request, see RequestLogger.
+// Use it for anything logged while handling a request
+func requestLogger(c *fiber.Ctx) *slog.Logger {
+	if logger, ok := c.Locals(loggerKey).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/adllev/Voter-Container/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// voterSearchResult is one page of search results.  NextOffset is the
+// offset of the next page, it is left out on the last page
+type voterSearchResult struct {
+	Total      int             `json:"total"`
+	Voters     []voterResource `json:"voters"`
+	NextOffset int             `json:"nextOffset,omitempty"`
+}
+
+// implementation for GET /voters/search
+// full text search over voter names and emails, best matches first, so
+// admins can find a voter without downloading the list.  ?q= is the text,
+// ?field=name|email limits the search to one field and ?limit= and
+// ?offset= page through the results
+func (va *VoterAPI) SearchVoters(c *fiber.Ctx) error {
+	search := db.VoterSearch{
+		Text:   c.Query("q"),
+		Field:  c.Query("field"),
+		Offset: c.QueryInt("offset", 0),
+		Limit:  c.QueryInt("limit", DefaultPageLimit),
+	}
+	if search.Text == "" {
+		return fiber.NewError(http.StatusBadRequest, "q is required")
+	}
+	if search.Limit <= 0 || search.Limit > MaxPageLimit {
+		return fiber.NewError(http.StatusBadRequest,
+			fmt.Sprintf("limit must be between 1 and %d", MaxPageLimit))
+	}
+	if search.Offset < 0 {
+		return fiber.NewError(http.StatusBadRequest, "offset can not be negative")
+	}
+
+	voterList, total, err := va.store(c).SearchVoters(search)
+	if err != nil {
+		requestLogger(c).Error("Error Searching Voters", "error", err)
+		return dbError(err)
+	}
+
+	setTotalCount(c, total)
+	result := voterSearchResult{Total: total, Voters: newVoterResources(linkBase(c), voterList)}
+	if next := search.Offset + search.Limit; next < total {
+		result.NextOffset = next
+		query := url.Values{
+			"q":      {search.Text},
+			"limit":  {fmt.Sprint(search.Limit)},
+			"offset": {fmt.Sprint(next)},
+		}
+		if search.Field != "" {
+			query.Set("field", search.Field)
+		}
+		c.Set(fiber.HeaderLink, fmt.Sprintf("<%s?%s>; rel=\"next\"", c.Path(), query.Encode()))
+	}
+
+	return c.JSON(result)
+}
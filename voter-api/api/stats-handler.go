@@ -0,0 +1,141 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/adllev/Voter-Container/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// implementation for GET /stats
+// returns the voter and vote totals of the tenant.  They come from
+// counters kept up to date on every write, so this is cheap no matter how
+// many voters there are
+func (va *VoterAPI) GetStats(c *fiber.Ctx) error {
+	stats, err := va.store(c).GetStats()
+	if err != nil {
+		requestLogger(c).Error("Error getting stats", "error", err)
+		return dbError(err)
+	}
+
+	return c.JSON(stats)
+}
+
+// implementation for GET /analytics/turnout?by=region&pollId=3
+// breaks the turnout down by ageBand, region or registered (month), in one
+// poll or in any poll without pollId.  Groups smaller than the configured
+// analytics minimum are suppressed, see config.AnalyticsMinGroup
+func (va *VoterAPI) GetTurnoutBreakdown(c *fiber.Ctx) error {
+	dimension := c.Query("by")
+	if !db.ValidDimension(dimension) {
+		return newAPIError(http.StatusBadRequest, "invalid_dimension",
+			fmt.Sprintf("by must be one of %s, %s or %s", db.DimensionAgeBand, db.DimensionRegion, db.DimensionRegistered), nil)
+	}
+	pollId := c.QueryInt("pollId", 0)
+	if pollId < 0 {
+		return fiber.NewError(http.StatusBadRequest, "pollId must be positive")
+	}
+
+	breakdown, err := va.store(c).GetTurnoutBreakdown(dimension, pollId, va.analyticsMinGroup)
+	if err != nil {
+		requestLogger(c).Error("Error getting turnout breakdown", "error", err)
+		return dbError(err)
+	}
+
+	return c.JSON(breakdown)
+}
+
+// implementation for GET /voters/leaderboard?limit=10
+// returns the voters that voted in the most polls, for engagement
+// dashboards.  It is read from a sorted set kept up to date on every vote,
+// not from the histories
+func (va *VoterAPI) GetLeaderboard(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 10)
+	if limit <= 0 || limit > MaxPageLimit {
+		return fiber.NewError(http.StatusBadRequest,
+			fmt.Sprintf("limit must be between 1 and %d", MaxPageLimit))
+	}
+
+	leaderboard, err := va.store(c).GetLeaderboard(limit)
+	if err != nil {
+		requestLogger(c).Error("Error getting leaderboard", "error", err)
+		return dbError(err)
+	}
+
+	return c.JSON(leaderboard)
+}
+
+// Intervals the turnout series of a poll can be added up in
+var turnoutIntervals = map[string]time.Duration{
+	"hour": time.Hour,
+	"day":  24 * time.Hour,
+}
+
+// implementation for GET /polls/:pollid/turnout?interval=day
+// returns how many eligible voters there are for a poll, how many voted
+// and the percentage, with the votes per hour or, with ?interval=day, per
+// day.  Like GET /stats it is read from counters, not the voters
+func (va *VoterAPI) GetPollTurnout(c *fiber.Ctx) error {
+	pollId, err := c.ParamsInt("pollid")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+	interval, ok := turnoutIntervals[c.Query("interval", "hour")]
+	if !ok {
+		return newAPIError(http.StatusBadRequest, "invalid_interval", "interval must be hour or day", nil)
+	}
+
+	poll, err := va.pathPoll(c, pollId)
+	if err != nil {
+		return err
+	}
+
+	turnout, err := va.store(c).GetPollTurnout(pollId, poll.Precincts, interval)
+	if err != nil {
+		requestLogger(c).Error("Error getting poll turnout", "pollId", pollId, "error", err)
+		return dbError(err)
+	}
+
+	return c.JSON(turnout)
+}
+
+// Intervals the vote rate of a poll can be read in
+var voteRateIntervals = map[string]time.Duration{
+	"minute": time.Minute,
+	"hour":   time.Hour,
+}
+
+// implementation for GET /polls/:pollid/rate?interval=minute&buckets=60
+// returns how many votes were recorded in a poll per minute or hour over
+// the last buckets intervals, with the busiest one, so monitoring can spot
+// a sudden spike during an election.  Votes are counted when they are
+// recorded, whatever date they carry
+func (va *VoterAPI) GetVoteRate(c *fiber.Ctx) error {
+	pollId, err := c.ParamsInt("pollid")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+	interval, ok := voteRateIntervals[c.Query("interval", "minute")]
+	if !ok {
+		return newAPIError(http.StatusBadRequest, "invalid_interval", "interval must be minute or hour", nil)
+	}
+	buckets := c.QueryInt("buckets", 60)
+	if buckets <= 0 || buckets > MaxPageLimit {
+		return fiber.NewError(http.StatusBadRequest,
+			fmt.Sprintf("buckets must be between 1 and %d", MaxPageLimit))
+	}
+
+	if _, err := va.pathPoll(c, pollId); err != nil {
+		return err
+	}
+
+	rate, err := va.store(c).GetVoteRate(pollId, interval, buckets, time.Now())
+	if err != nil {
+		requestLogger(c).Error("Error getting vote rate", "pollId", pollId, "error", err)
+		return dbError(err)
+	}
+
+	return c.JSON(rate)
+}
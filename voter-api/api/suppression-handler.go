@@ -0,0 +1,109 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/adllev/Voter-Container/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// suppressionRequest is the body accepted by POST /notifications/suppressions
+type suppressionRequest struct {
+	Email  string `json:"email"`
+	Reason string `json:"reason"`
+}
+
+// bounceEvent is the payload our email provider posts to us whenever a
+// delivery bounces or a recipient unsubscribes / complains
+type bounceEvent struct {
+	Email string `json:"email"`
+	Type  string `json:"type"`
+}
+
+// Provider event types that should permanently stop us from emailing an
+// address.  Soft bounces are temporary, so they are only logged
+var suppressingBounceTypes = map[string]string{
+	"hard_bounce": db.SuppressionReasonBounce,
+	"unsubscribe": db.SuppressionReasonUnsubscribe,
+	"complaint":   db.SuppressionReasonComplaint,
+}
+
+// implementation for GET /notifications/suppressions
+func (va *VoterAPI) ListSuppressions(c *fiber.Ctx) error {
+	suppressionList, err := va.store(c).GetAllSuppressions()
+	if err != nil {
+		requestLogger(c).Error("Error Getting Suppressions", "error", err)
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+
+	return c.JSON(emptyIfNil(suppressionList))
+}
+
+// implementation for POST /notifications/suppressions
+func (va *VoterAPI) PostSuppression(c *fiber.Ctx) error {
+	var req suppressionRequest
+	if err := parseBody(c, &req); err != nil {
+		return err
+	}
+	if req.Email == "" {
+		return fiber.NewError(http.StatusBadRequest, "email is required")
+	}
+
+	entry, err := va.store(c).AddSuppression(req.Email, req.Reason)
+	if err != nil {
+		requestLogger(c).Error("Error adding suppression", "error", err)
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+
+	return c.JSON(entry)
+}
+
+// implementation for DELETE /notifications/suppressions/:email
+func (va *VoterAPI) DeleteSuppression(c *fiber.Ctx) error {
+	//The email is part of the path, so it may arrive url encoded
+	email, err := url.PathUnescape(c.Params("email"))
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	if err := va.store(c).RemoveSuppression(email); err != nil {
+		requestLogger(c).Error("Error deleting suppression", "error", err)
+		return dbError(err)
+	}
+
+	return c.Status(http.StatusOK).SendString("Delete OK")
+}
+
+// implementation for POST /notifications/bounces.  This is the webhook our
+// email provider calls, hard bounces and unsubscribes are added to the
+// suppression list automatically.  The body must be signed with the
+// bounce key (X-Bounce-Signature) like a kiosk batch, the route takes no
+// other credentials
+func (va *VoterAPI) PostBounce(c *fiber.Ctx) error {
+	if !va.bounces.VerifyBytes(c.Body(), c.Get("X-Bounce-Signature")) {
+		requestLogger(c).Warn("Rejected bounce with bad signature")
+		return fiber.NewError(http.StatusUnauthorized, "Invalid bounce signature")
+	}
+
+	var event bounceEvent
+	if err := parseBody(c, &event); err != nil {
+		return err
+	}
+	if event.Email == "" {
+		return fiber.NewError(http.StatusBadRequest, "email is required")
+	}
+
+	reason, ok := suppressingBounceTypes[event.Type]
+	if !ok {
+		requestLogger(c).Info("Ignoring provider event", "type", event.Type)
+		return c.Status(http.StatusOK).SendString("Ignored")
+	}
+
+	if _, err := va.store(c).AddSuppression(event.Email, reason); err != nil {
+		requestLogger(c).Error("Error adding suppression", "error", err)
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+
+	return c.Status(http.StatusOK).SendString("Suppressed")
+}
@@ -0,0 +1,36 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// implementation for GET /admin/sync
+// returns the summary of the latest sync from the registration system,
+// 404 before the first one.  The sync feeds the default tenant
+func (va *VoterAPI) GetSyncSummary(c *fiber.Ctx) error {
+	summary, err := va.db.GetSyncSummary()
+	if err != nil {
+		return dbError(err)
+	}
+
+	return c.JSON(summary)
+}
+
+// implementation for POST /admin/sync
+// syncs from the registration system right away instead of waiting for
+// the next interval, 409 when no upstream is configured
+func (va *VoterAPI) PostSync(c *fiber.Ctx) error {
+	if va.sync == nil {
+		return newAPIError(http.StatusConflict, "sync_not_configured", "No upstream voter list is configured, see SYNC_URL", nil)
+	}
+
+	summary, err := va.sync.Sync(c.UserContext())
+	if err != nil {
+		requestLogger(c).Error("Error syncing voters", "error", err)
+		return newAPIError(http.StatusBadGateway, "sync_failed", "Could not sync from the upstream voter list", nil)
+	}
+
+	return c.JSON(summary)
+}
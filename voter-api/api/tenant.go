@@ -0,0 +1,65 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/adllev/Voter-Container/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// TenantIDHeader picks the tenant, the election, a request works on
+const TenantIDHeader = "X-Tenant-ID"
+
+// tenantKey is where Tenant stores the tenant id in c.Locals
+const tenantKey = "tenant"
+
+// Tenant is a middleware that finds the tenant of a request, from the
+// X-Tenant-ID header or else the subdomain in front of the tenant domain.
+// A request without either uses the default tenant.  va.store(c) then
+// only sees the data of that tenant.  Tokens and API keys are bound to a
+// tenant, Authenticate rejects them in any other, see checkTenant
+func (va *VoterAPI) Tenant(c *fiber.Ctx) error {
+	tenantID := c.Get(TenantIDHeader)
+	if tenantID == "" {
+		tenantID = tenantFromHost(c.Hostname(), va.tenantDomain)
+	}
+	if tenantID == "" {
+		return c.Next()
+	}
+
+	if !db.ValidTenantId(tenantID) {
+		return newAPIError(http.StatusBadRequest, "invalid_tenant", "Tenant ids are lowercase letters, digits and dashes", nil)
+	}
+	if len(va.tenants) > 0 && !va.tenants[tenantID] {
+		return newAPIError(http.StatusNotFound, "tenant_not_found", "Unknown tenant "+tenantID, nil)
+	}
+
+	c.Locals(tenantKey, tenantID)
+	return c.Next()
+}
+
+// tenantFromHost returns the subdomain of host in front of domain,
+// acme.elections.example.com is acme for elections.example.com.  It is ""
+// when host is not a subdomain of domain or there is no domain
+func tenantFromHost(host string, domain string) string {
+	if domain == "" {
+		return ""
+	}
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+
+	tenantID, found := strings.CutSuffix(strings.ToLower(host), "."+strings.ToLower(domain))
+	if !found {
+		return ""
+	}
+	return tenantID
+}
+
+// tenant returns the tenant of the request, "" for the default tenant
+func tenant(c *fiber.Ctx) string {
+	tenantID, _ := c.Locals(tenantKey).(string)
+	return tenantID
+}
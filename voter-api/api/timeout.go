@@ -0,0 +1,34 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Timeout is a middleware that gives every request a deadline.  The
+// request context is cancelled when it passes, which aborts the redis
+// call the handler is waiting on (see VoterAPI.store), and the request
+// fails with 504 instead of tying up a worker.  Code that does not look
+// at the context can still run past the deadline.  0 disables it
+func Timeout(timeout time.Duration) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if timeout <= 0 {
+			return c.Next()
+		}
+
+		ctx, cancel := context.WithTimeout(c.UserContext(), timeout)
+		defer cancel()
+		c.SetUserContext(ctx)
+
+		err := c.Next()
+		if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			requestLogger(c).Warn("Request timed out", "timeout", timeout, "error", err)
+			return newAPIError(http.StatusGatewayTimeout, "timeout", "Request timed out", nil)
+		}
+		return err
+	}
+}
@@ -0,0 +1,186 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxClockSkew is how far in the future a VoteDate may be, so a client
+// whose clock runs a little fast is not rejected
+const maxClockSkew = time.Minute
+
+// validate checks the validate struct tags of request bodies, see
+// db.VoterItem and db.VoterHistory.  It is safe for concurrent use and
+// caches what it learns about each struct, so there is only one
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New(validator.WithRequiredStructEnabled())
+
+	//Report fields by their JSON name, that is what the client sent
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+
+	v.RegisterValidation("notfuture", func(fl validator.FieldLevel) bool {
+		date, ok := fl.Field().Interface().(time.Time)
+		return ok && !date.After(time.Now().Add(maxClockSkew))
+	})
+
+	return v
+}
+
+// fieldError is one invalid field of a request body and why it was
+// rejected, Field is a path like "voteHistory[0].voteDate"
+type fieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// validationError is the 422 for a body with invalid fields
+func validationError(fields []fieldError) *APIError {
+	apiError := newAPIError(http.StatusUnprocessableEntity, "validation_failed", "Validation failed", nil)
+	apiError.Fields = fields
+	return apiError
+}
+
+// parseBody is c.BodyParser with errors a client can act on.  A field of
+// the wrong type is a 422 naming the field, a body that is not JSON at
+// all is a 400 and a body in a format we do not read is a 415
+func parseBody(c *fiber.Ctx, out any) error {
+	err := c.BodyParser(out)
+	if err == nil {
+		return nil
+	}
+	requestLogger(c).Warn("Error binding JSON", "error", err)
+
+	var typeError *json.UnmarshalTypeError
+	var timeError *time.ParseError
+	switch {
+	case errors.As(err, &typeError):
+		return validationError([]fieldError{{Field: typeError.Field, Reason: "must be " + jsonTypeName(typeError.Type)}})
+	case errors.As(err, &timeError):
+		return newAPIError(http.StatusUnprocessableEntity, "validation_failed", "Dates must be RFC 3339, like 2024-01-02T15:04:05Z", nil)
+	case errors.Is(err, fiber.ErrUnprocessableEntity):
+		//What fiber returns for a Content-Type it has no parser for
+		return newAPIError(http.StatusUnsupportedMediaType, "unsupported_media_type", "Body must be application/json", nil)
+	}
+	return newAPIError(http.StatusBadRequest, "malformed_body", "Body is not valid JSON", nil)
+}
+
+// jsonTypeName describes a Go type the way a JSON client thinks of it
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.String:
+		return "a string"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	}
+	return "an object"
+}
+
+// validateBody checks a parsed request body.  On failure it returns the
+// 422 listing every invalid field, so handlers just return it:
+//
+//	if ok, err := validateBody(voterItem); !ok {
+//		return err
+//	}
+func validateBody(body any) (bool, error) {
+	fields, err := validateFields(body)
+	if err != nil {
+		return false, err
+	}
+	if fields == nil {
+		return true, nil
+	}
+
+	fieldList := make([]fieldError, 0, len(fields))
+	for field, reason := range fields {
+		fieldList = append(fieldList, fieldError{Field: field, Reason: reason})
+	}
+	//Map order is random, the response should not be
+	sort.Slice(fieldList, func(i, j int) bool { return fieldList[i].Field < fieldList[j].Field })
+	return false, validationError(fieldList)
+}
+
+// validateFields checks a parsed body and returns why each invalid field
+// was rejected, or nil when the body is valid
+func validateFields(body any) (map[string]string, error) {
+	err := validate.Struct(body)
+	if err == nil {
+		return nil, nil
+	}
+
+	var fieldErrors validator.ValidationErrors
+	if !errors.As(err, &fieldErrors) {
+		return nil, err
+	}
+
+	fields := make(map[string]string)
+	for _, fieldError := range fieldErrors {
+		fields[fieldPath(fieldError)] = fieldReason(fieldError)
+	}
+	return fields, nil
+}
+
+// fieldPath drops the struct name from the namespace of a field error,
+// VoterItem.voteHistory[0].pollId becomes voteHistory[0].pollId
+func fieldPath(fieldError validator.FieldError) string {
+	_, path, found := strings.Cut(fieldError.Namespace(), ".")
+	if !found {
+		return fieldError.Field()
+	}
+	return path
+}
+
+// fieldReason turns a failed validation rule into a message for the client
+func fieldReason(fieldError validator.FieldError) string {
+	switch fieldError.Tag() {
+	case "required", "required_unless":
+		return "is required"
+	case "email":
+		return "must be a valid email address"
+	case "e164":
+		return "must be a phone number in E.164 format, like +15551234567"
+	case "gt":
+		return "must be greater than " + fieldError.Param()
+	case "gte":
+		return "must be at least " + fieldError.Param()
+	case "nefield":
+		return "must not be the same as " + strings.ToLower(fieldError.Param()[:1]) + fieldError.Param()[1:]
+	case "notfuture":
+		return "must not be in the future"
+	case "oneof":
+		return "must be one of " + strings.ReplaceAll(fieldError.Param(), " ", ", ")
+	case "min":
+		if fieldError.Kind() == reflect.Slice {
+			return "must have at least " + fieldError.Param() + " entries"
+		}
+		return "must be at least " + fieldError.Param() + " characters"
+	case "max":
+		if fieldError.Kind() == reflect.Slice {
+			return "must have at most " + fieldError.Param() + " entries"
+		}
+		return "must be at most " + fieldError.Param() + " characters"
+	}
+	return "is invalid"
+}
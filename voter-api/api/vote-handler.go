@@ -0,0 +1,172 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/adllev/Voter-Container/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// implementation for GET /votes
+// returns every vote ordered by id, ?voterId= and ?pollId= narrow it down
+func (va *VoterAPI) ListVotes(c *fiber.Ctx) error {
+	voterId := c.QueryInt("voterId")
+	pollId := c.QueryInt("pollId")
+
+	voteList, err := va.store(c).GetAllVotes()
+	if err != nil {
+		requestLogger(c).Error("Error getting votes", "error", err)
+		return dbError(err)
+	}
+
+	filtered := make([]db.Vote, 0, len(voteList))
+	for _, vote := range voteList {
+		if (voterId == 0 || vote.VoterId == voterId) && (pollId == 0 || vote.PollId == pollId) {
+			filtered = append(filtered, vote)
+		}
+	}
+
+	return c.JSON(filtered)
+}
+
+// implementation for GET /votes/:voteid
+// returns a single vote
+func (va *VoterAPI) GetVote(c *fiber.Ctx) error {
+	voteId, err := c.ParamsInt("voteid")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	vote, err := va.store(c).GetVote(voteId)
+	if err != nil {
+		requestLogger(c).Info("Vote not found", "voteId", voteId, "error", err)
+		return dbError(err)
+	}
+
+	return c.JSON(vote)
+}
+
+// implementation for POST /votes
+// records a vote, which also adds the poll to the history of the voter.
+// A voter that already voted in the poll gets a 409, a poll that does not
+// exist or a voteValue that is not one of its options a 422.  Instead of a
+// voteValue the vote can carry a writeIn when the poll allows write-ins,
+// in a ranked poll it carries the ranking of the options.  A poll limited
+// to precincts takes votes from their voters only, a 403 for the others.
+// The receipt of the vote is sent in VoteReceiptHeader.  In an anonymous
+// poll the vote is stored as a secret ballot, see db.AddAnonymousVote, and
+// what comes back carries the voter token instead of the voterId.  With
+// provisional the vote waits for an admin to accept it before it counts,
+// pending voters can only vote that way
+func (va *VoterAPI) PostVote(c *fiber.Ctx) error {
+	var vote db.Vote
+	if err := parseBody(c, &vote); err != nil {
+		return err
+	}
+	if ok, err := validateBody(vote); !ok {
+		return err
+	}
+	poll, err := va.checkPollRef(c, vote.PollId, false, "voteValue", vote.VoteValue)
+	if err != nil {
+		return err
+	}
+	if err := va.checkEligibility(c, poll, vote.VoterId); err != nil {
+		return err
+	}
+	if err := checkBallot(poll, &vote); err != nil {
+		return err
+	}
+	if poll.Anonymous && vote.Provisional {
+		return validationError([]fieldError{{Field: "provisional", Reason: "is not possible for a secret ballot"}})
+	}
+
+	if poll.Anonymous {
+		err = va.store(c).AddAnonymousVote(vote, va.ballotToken(vote.VoterId, vote.PollId))
+	} else {
+		err = va.store(c).AddVote(vote)
+	}
+	if err != nil {
+		requestLogger(c).Error("Error adding vote", "error", err)
+		return dbError(err)
+	}
+	if poll.Anonymous {
+		//The log must not pair the voter with the ballot either
+		requestLogger(c).Info("Recorded secret ballot", "voterId", vote.VoterId, "pollId", vote.PollId)
+	} else {
+		requestLogger(c).Info("Recorded vote", "voteId", vote.VoteId, "voterId", vote.VoterId, "pollId", vote.PollId)
+	}
+	va.issueReceipt(c, vote.VoterId, vote.PollId)
+
+	//AddVote fills in the date when the client left it out
+	vote, err = va.store(c).GetVote(vote.VoteId)
+	if err != nil {
+		return dbError(err)
+	}
+	return c.JSON(vote)
+}
+
+// implementation for PUT /votes/:voteid
+// changes the option or write-in a vote picked.  The voter and poll of a vote are
+// fixed, sending different ones is a 409
+func (va *VoterAPI) UpdateVote(c *fiber.Ctx) error {
+	voteId, err := c.ParamsInt("voteid")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	var vote db.Vote
+	if err := parseBody(c, &vote); err != nil {
+		return err
+	}
+	if vote.VoteId != 0 && vote.VoteId != voteId {
+		return newAPIError(http.StatusConflict, "id_mismatch",
+			fmt.Sprintf("Body voteId %d does not match the path vote %d", vote.VoteId, voteId), nil)
+	}
+	vote.VoteId = voteId
+	if ok, err := validateBody(vote); !ok {
+		return err
+	}
+	poll, err := va.checkPollRef(c, vote.PollId, false, "voteValue", vote.VoteValue)
+	if err != nil {
+		return err
+	}
+	if err := checkBallot(poll, &vote); err != nil {
+		return err
+	}
+
+	if err := va.store(c).UpdateVote(vote, actor(c)); err != nil {
+		requestLogger(c).Error("Error updating vote", "error", err)
+		return dbError(err)
+	}
+
+	vote, err = va.store(c).GetVote(voteId)
+	if err != nil {
+		return dbError(err)
+	}
+	return c.JSON(vote)
+}
+
+// implementation for DELETE /votes/:voteid
+// deletes a vote and its entry in the history of the voter
+func (va *VoterAPI) DeleteVote(c *fiber.Ctx) error {
+	voteId, err := c.ParamsInt("voteid")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	if err := va.store(c).DeleteVote(voteId); err != nil {
+		requestLogger(c).Error("Error deleting vote", "error", err)
+		return dbError(err)
+	}
+
+	return c.Status(http.StatusOK).SendString("Delete OK")
+}
+
+// ballotToken is the blinded token a secret ballot of voterId in pollId is
+// stored with.  It is the same for every attempt of the voter in the poll,
+// so a second ballot is caught, but can not be traced back without the
+// BALLOT_SIGNING_KEY
+func (va *VoterAPI) ballotToken(voterId int, pollId int) string {
+	return va.ballots.SignBytes([]byte(fmt.Sprintf("%d:%d", voterId, pollId)))
+}
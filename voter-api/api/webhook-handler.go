@@ -0,0 +1,143 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/adllev/Voter-Container/voter-api/db"
+	"github.com/adllev/Voter-Container/voter-api/webhooks"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// webhookRequest is the body of POST /webhooks, events defaults to every
+// event type
+type webhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+// webhookInfo is how a webhook is listed, the secret is only shown once
+// when it is created
+type webhookInfo struct {
+	Id        string    `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func newWebhookInfo(webhook db.Webhook) webhookInfo {
+	return webhookInfo{
+		Id:        webhook.Id,
+		URL:       webhook.URL,
+		Events:    emptyIfNil(webhook.Events),
+		CreatedAt: webhook.CreatedAt,
+	}
+}
+
+// createdWebhook is returned once when a webhook is registered, with the
+// secret the receiver checks the X-Webhook-Signature header with
+type createdWebhook struct {
+	webhookInfo
+	Secret string `json:"secret"`
+}
+
+// implementation for POST /webhooks
+// registers a URL that is sent a signed JSON payload for every event it
+// subscribed to, see the webhooks package for the payload and signature.
+// It gets the events of the tenant of the credentials registering it
+func (va *VoterAPI) PostWebhook(c *fiber.Ctx) error {
+	var req webhookRequest
+	if err := parseBody(c, &req); err != nil {
+		return err
+	}
+
+	target, err := url.Parse(req.URL)
+	if err != nil || (target.Scheme != "https" && target.Scheme != "http") || target.Host == "" {
+		return fiber.NewError(http.StatusBadRequest, "url must be an absolute http or https URL")
+	}
+	for _, event := range req.Events {
+		if !webhooks.ValidEvent(event) {
+			return fiber.NewError(http.StatusBadRequest, "unknown event "+event)
+		}
+	}
+
+	secret, err := webhooks.GenerateSecret()
+	if err != nil {
+		requestLogger(c).Error("Error generating webhook secret", "error", err)
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+
+	webhook := db.Webhook{
+		Id:        utils.UUIDv4(),
+		URL:       target.String(),
+		Events:    req.Events,
+		Secret:    secret,
+		Tenant:    tenant(c),
+		CreatedAt: time.Now(),
+	}
+	if claims := claims(c); claims != nil {
+		webhook.Tenant = claims.Tenant
+	}
+	if err := va.store(c).AddWebhook(webhook); err != nil {
+		requestLogger(c).Error("Error adding webhook", "error", err)
+		return dbError(err)
+	}
+
+	va.audit(c, "webhook.created", 0, webhook.URL)
+	return c.Status(http.StatusCreated).JSON(createdWebhook{webhookInfo: newWebhookInfo(webhook), Secret: secret})
+}
+
+// implementation for GET /webhooks
+// lists the registered webhooks, without their secrets
+func (va *VoterAPI) ListWebhooks(c *fiber.Ctx) error {
+	webhookList, err := va.store(c).GetAllWebhooks()
+	if err != nil {
+		requestLogger(c).Error("Error Getting Webhooks", "error", err)
+		return dbError(err)
+	}
+
+	infoList := make([]webhookInfo, 0, len(webhookList))
+	for _, webhook := range webhookList {
+		infoList = append(infoList, newWebhookInfo(webhook))
+	}
+	return c.JSON(infoList)
+}
+
+// implementation for DELETE /webhooks/:id
+// stops deliveries to a webhook
+func (va *VoterAPI) DeleteWebhook(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if err := va.store(c).DeleteWebhook(id); err != nil {
+		requestLogger(c).Error("Error deleting webhook", "error", err)
+		return dbError(err)
+	}
+
+	va.audit(c, "webhook.deleted", 0, id)
+	return c.Status(http.StatusOK).SendString("Delete OK")
+}
+
+// implementation for GET /admin/webhooks/dead-letter
+// returns every delivery that ran out of attempts
+func (va *VoterAPI) ListWebhookDeadLetters(c *fiber.Ctx) error {
+	deadLetterList, err := va.store(c).GetWebhookDeadLetters()
+	if err != nil {
+		requestLogger(c).Error("Error Getting Webhook Dead Letters", "error", err)
+		return dbError(err)
+	}
+
+	return c.JSON(emptyIfNil(deadLetterList))
+}
+
+// implementation for POST /admin/webhooks/dead-letter/:id/requeue
+// puts a dead-lettered delivery back on the retry queue
+func (va *VoterAPI) RequeueWebhookDeadLetter(c *fiber.Ctx) error {
+	delivery, err := va.store(c).RequeueWebhookDeadLetter(c.Params("id"))
+	if err != nil {
+		requestLogger(c).Error("Error requeueing webhook dead letter", "error", err)
+		return dbError(err)
+	}
+
+	return c.JSON(delivery)
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/adllev/Voter-Container/voter-api/db"
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+// wsWriteTimeout is how long a push to a websocket client may take before
+// the client is considered gone
+const wsWriteTimeout = 10 * time.Second
+
+// WebSocketUpgrade is the route middleware in front of VoteUpdates, plain
+// HTTP requests to the websocket endpoint get 426 Upgrade Required
+func WebSocketUpgrade(c *fiber.Ctx) error {
+	if !websocket.IsWebSocketUpgrade(c) {
+		return fiber.NewError(http.StatusUpgradeRequired, "Websocket upgrade required")
+	}
+	return c.Next()
+}
+
+// implementation for GET /ws
+// a websocket that pushes every vote.recorded event as a JSON message, so
+// a results page updates without polling GET /voters.  ?pollId= limits
+// the events to one poll.  Messages from the client are ignored
+func (va *VoterAPI) VoteUpdates() fiber.Handler {
+	return websocket.New(func(conn *websocket.Conn) {
+		logger := va.log.With("requestId", conn.Locals(requestIDKey))
+		tenantID, _ := conn.Locals(tenantKey).(string)
+
+		pollID := 0
+		if value := conn.Query("pollId"); value != "" {
+			var err error
+			if pollID, err = strconv.Atoi(value); err != nil || pollID <= 0 {
+				_ = conn.WriteMessage(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseUnsupportedData, "invalid pollId"))
+				return
+			}
+		}
+
+		lastId, err := va.db.LatestEventId()
+		if err != nil {
+			logger.Error("Error reading event stream", "error", err)
+			return
+		}
+
+		//Reading is the only way to notice the client closed the socket
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		for {
+			select {
+			case <-closed:
+				return
+			default:
+			}
+
+			entries, err := va.db.ReadEvents(lastId, eventKeepAlive)
+			if err != nil {
+				logger.Error("Error reading event stream", "error", err)
+				return
+			}
+
+			_ = conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if len(entries) == 0 {
+				if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+					return
+				}
+			}
+			for _, entry := range entries {
+				lastId = entry.Id
+				if entry.Tenant != tenantID || entry.Type != db.EventVoteRecorded || (pollID != 0 && entry.PollId != pollID) {
+					continue
+				}
+				if err := conn.WriteJSON(entry); err != nil {
+					return
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/adllev/Voter-Container/voter-api/db"
+)
+
+// ErrInvalidAPIKey is returned by Lookup for a key we did not issue
+var ErrInvalidAPIKey = errors.New("invalid api key")
+
+// Scopes an API key can be granted
+const (
+	ScopeVotersRead  = "voters:read"
+	ScopeVotersWrite = "voters:write"
+	ScopeVotesWrite  = "votes:write"
+	ScopePollsWrite  = "polls:write"
+	ScopeAdmin       = "admin"
+)
+
+// APIKeyStore is where keys issued at runtime are kept, *db.Voter
+// implements it
+type APIKeyStore interface {
+	GetAPIKey(hash string) (db.APIKey, error)
+}
+
+// APIKeys checks API keys against the static keys from the config and
+// then against the keys issued at runtime
+type APIKeys struct {
+	static map[string]db.APIKey
+	store  APIKeyStore
+}
+
+// HashAPIKey returns the hex SHA-256 of a key, which is what is configured
+// and stored instead of the key itself.  API keys are long and random, so
+// a plain hash is enough, unlike passwords they need no slow KDF
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateAPIKey returns a new random key
+func GenerateAPIKey() (string, error) {
+	keyBytes := make([]byte, 32)
+	if _, err := rand.Read(keyBytes); err != nil {
+		return "", err
+	}
+	return "vk_" + base64.RawURLEncoding.EncodeToString(keyBytes), nil
+}
+
+// NewAPIKeys loads the static keys, name:sha256:scopes entries where the
+// scopes are separated by "|", for example
+// polls:5e884898da...:voters:read|votes:write.  A key for a tenant other
+// than the default one names it after an @, polls@acme:..., and
+// polls@*:... works on every tenant
+func NewAPIKeys(entries []string, store APIKeyStore) (*APIKeys, error) {
+	apiKeys := &APIKeys{static: make(map[string]db.APIKey), store: store}
+
+	for _, entry := range entries {
+		name, rest, found := strings.Cut(strings.TrimSpace(entry), ":")
+		if !found {
+			return nil, fmt.Errorf("invalid API key entry %q", name)
+		}
+		hash, scopes, _ := strings.Cut(rest, ":")
+		hash = strings.ToLower(hash)
+		if len(hash) != sha256.Size*2 {
+			return nil, fmt.Errorf("API key entry %q is not a sha256 hash", name)
+		}
+
+		name, tenant, _ := strings.Cut(name, "@")
+		apiKeys.static[hash] = db.APIKey{
+			Id:     name,
+			Name:   name,
+			Hash:   hash,
+			Scopes: strings.Split(scopes, "|"),
+			Tenant: tenant,
+		}
+	}
+
+	return apiKeys, nil
+}
+
+// HasStatic reports whether any keys were configured
+func (k *APIKeys) HasStatic() bool {
+	return len(k.static) > 0
+}
+
+// Lookup returns the API key matching key
+func (k *APIKeys) Lookup(key string) (db.APIKey, error) {
+	hash := HashAPIKey(key)
+	if apiKey, ok := k.static[hash]; ok {
+		return apiKey, nil
+	}
+
+	apiKey, err := k.store.GetAPIKey(hash)
+	if errors.Is(err, db.ErrNotFound) {
+		return db.APIKey{}, ErrInvalidAPIKey
+	}
+	return apiKey, err
+}
+
+// HasScope reports whether an API key was granted scope, the admin scope
+// grants everything
+func HasScope(apiKey db.APIKey, scope string) bool {
+	for _, granted := range apiKey.Scopes {
+		if granted == scope || granted == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
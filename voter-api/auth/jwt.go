@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrInvalidToken is returned by Verify for a token that is malformed,
+// expired, signed with another key or issued by someone else
+var ErrInvalidToken = errors.New("invalid token")
+
+// Clients and servers rarely agree on the time exactly, exp and nbf are
+// checked with this much leeway
+const clockLeeway = 30 * time.Second
+
+// Claims are the claims we read from a bearer token.  The subject is who
+// made the request, it ends up in the audit log, and the roles decide
+// what they are allowed to do.  Tenant is the tenant they can do it in,
+// see AllowsTenant
+type Claims struct {
+	jwt.RegisteredClaims
+	Roles  []string `json:"roles,omitempty"`
+	Tenant string   `json:"tenant,omitempty"`
+}
+
+// JWTVerifier checks HS256 bearer tokens
+type JWTVerifier struct {
+	key    []byte
+	issuer string
+}
+
+// NewJWTVerifier returns a verifier for tokens signed with key, see
+// config.AuthConfig.  If issuer is set the iss claim has to match it.
+// Without a key it returns nil, which turns authentication off, so never
+// leave it unset outside of development
+func NewJWTVerifier(key string, issuer string) *JWTVerifier {
+	if key == "" {
+		slog.Warn("JWT_SIGNING_KEY not set, authentication is disabled")
+		return nil
+	}
+
+	return &JWTVerifier{
+		key:    []byte(key),
+		issuer: issuer,
+	}
+}
+
+// Verify parses and checks a token, returning its claims.  Tokens have to
+// carry an expiry, a token that never expires can not be revoked
+func (v *JWTVerifier) Verify(token string) (*Claims, error) {
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(clockLeeway),
+	}
+	if v.issuer != "" {
+		options = append(options, jwt.WithIssuer(v.issuer))
+	}
+
+	var claims Claims
+	_, err := jwt.ParseWithClaims(token, &claims, func(*jwt.Token) (any, error) {
+		return v.key, nil
+	}, options...)
+	if err != nil {
+		return nil, errors.Join(ErrInvalidToken, err)
+	}
+
+	return &claims, nil
+}
+
+// Sign issues a token for subject with roles in tenant that expires after
+// ttl, it is used for the sessions issued after an OIDC login
+func (v *JWTVerifier) Sign(subject string, roles []string, tenant string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims := Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   subject,
+			Issuer:    v.issuer,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+		Roles:  roles,
+		Tenant: tenant,
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(v.key)
+}
@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// ErrLoginFailed is returned by OIDC.Exchange when the identity provider
+// did not give us a valid, matching id token
+var ErrLoginFailed = errors.New("login failed")
+
+// OIDCConfig is how we reach the identity provider (Keycloak, Auth0...).
+// The yaml names are the ones used in the config file, see the config
+// package.  The client secret is only read from the environment
+type OIDCConfig struct {
+	IssuerURL    string `yaml:"issuerUrl"`
+	ClientID     string `yaml:"clientId"`
+	ClientSecret string `yaml:"-"`
+	RedirectURL  string `yaml:"redirectUrl"`
+}
+
+// Enabled reports whether login is configured
+func (cfg OIDCConfig) Enabled() bool {
+	return cfg.IssuerURL != "" && cfg.ClientID != ""
+}
+
+// OIDC runs the authorization code flow against an identity provider.
+// The provider is discovered on the first login rather than at startup,
+// so the api does not fail to start when the provider is not up yet
+type OIDC struct {
+	cfg OIDCConfig
+
+	mu       sync.Mutex
+	oauth    *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// NewOIDC is a constructor function that returns a pointer to a new OIDC
+func NewOIDC(cfg OIDCConfig) *OIDC {
+	return &OIDC{cfg: cfg}
+}
+
+// discover fetches the provider metadata once it is first needed
+func (o *OIDC) discover(ctx context.Context) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.oauth != nil {
+		return o.oauth, o.verifier, nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, o.cfg.IssuerURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("discovering %s: %w", o.cfg.IssuerURL, err)
+	}
+	slog.Info("Discovered OIDC provider", "issuer", o.cfg.IssuerURL)
+
+	o.oauth = &oauth2.Config{
+		ClientID:     o.cfg.ClientID,
+		ClientSecret: o.cfg.ClientSecret,
+		RedirectURL:  o.cfg.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       []string{oidc.ScopeOpenID, "profile", "email"},
+	}
+	o.verifier = provider.Verifier(&oidc.Config{ClientID: o.cfg.ClientID})
+	return o.oauth, o.verifier, nil
+}
+
+// RandomState returns a random value for the state and nonce parameters
+func RandomState() (string, error) {
+	stateBytes := make([]byte, 24)
+	if _, err := rand.Read(stateBytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(stateBytes), nil
+}
+
+// AuthCodeURL is where to send the browser to log in
+func (o *OIDC) AuthCodeURL(ctx context.Context, state string, nonce string) (string, error) {
+	oauthConfig, _, err := o.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	return oauthConfig.AuthCodeURL(state, oidc.Nonce(nonce)), nil
+}
+
+// Identity is who logged in, from the verified id token.  The identity
+// provider has to be set up to put the users roles in a roles claim
+type Identity struct {
+	Subject string   `json:"sub"`
+	Email   string   `json:"email"`
+	Name    string   `json:"name"`
+	Roles   []string `json:"roles"`
+}
+
+// Exchange trades the code from the callback for an id token, verifies
+// it, including that it carries the nonce we sent, and returns who it is
+func (o *OIDC) Exchange(ctx context.Context, code string, nonce string) (Identity, error) {
+	oauthConfig, verifier, err := o.discover(ctx)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	token, err := oauthConfig.Exchange(ctx, code)
+	if err != nil {
+		return Identity{}, errors.Join(ErrLoginFailed, err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return Identity{}, fmt.Errorf("%w: no id_token in token response", ErrLoginFailed)
+	}
+
+	idToken, err := verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return Identity{}, errors.Join(ErrLoginFailed, err)
+	}
+	if idToken.Nonce != nonce {
+		return Identity{}, fmt.Errorf("%w: nonce mismatch", ErrLoginFailed)
+	}
+
+	var identity Identity
+	if err := idToken.Claims(&identity); err != nil {
+		return Identity{}, errors.Join(ErrLoginFailed, err)
+	}
+	return identity, nil
+}
@@ -0,0 +1,53 @@
+package auth
+
+// Roles a caller can have.  Each role can do everything the roles below
+// it can: readers can read, operators can also register voters and record
+// votes and check-ins, admins can also delete voters and use /admin
+const (
+	RoleReader   = "reader"
+	RoleOperator = "operator"
+	RoleAdmin    = "admin"
+)
+
+var roleRank = map[string]int{
+	RoleReader:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+// ValidRole reports whether role is one of the known roles
+func ValidRole(role string) bool {
+	return roleRank[role] > 0
+}
+
+// HasRole reports whether the claims grant role, directly or through a
+// higher role
+func (c *Claims) HasRole(role string) bool {
+	for _, granted := range c.Roles {
+		if roleRank[granted] >= roleRank[role] {
+			return true
+		}
+	}
+	return false
+}
+
+// RolesForScopes maps the scopes of an API key to the matching role
+func RolesForScopes(scopes []string) []string {
+	role := ""
+	for _, scope := range scopes {
+		switch scope {
+		case ScopeAdmin:
+			return []string{RoleAdmin}
+		case ScopeVotersWrite, ScopeVotesWrite, ScopePollsWrite:
+			role = RoleOperator
+		case ScopeVotersRead:
+			if role == "" {
+				role = RoleReader
+			}
+		}
+	}
+	if role == "" {
+		return nil
+	}
+	return []string{role}
+}
@@ -0,0 +1,12 @@
+package auth
+
+// AnyTenant as the tenant of a token or API key lets it work on every
+// tenant, for the operators of a shared deployment
+const AnyTenant = "*"
+
+// AllowsTenant reports whether the claims may work on tenant, "" being
+// the default tenant.  Tokens and keys issued before tenants existed have
+// no tenant and only work on the default one
+func (c *Claims) AllowsTenant(tenant string) bool {
+	return c.Tenant == AnyTenant || c.Tenant == tenant
+}
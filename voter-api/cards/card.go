@@ -0,0 +1,144 @@
+package cards
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+	"github.com/skip2/go-qrcode"
+)
+
+// ErrInvalidSignature is returned by Verify when a QR payload was not
+// signed by us, or was changed after it was signed
+var ErrInvalidSignature = errors.New("invalid card signature")
+
+// Payload is what gets encoded in the QR code printed on a voter card.
+// Tenant is the tenant the voter is in, "" for the default tenant.  Email
+// is the address a verification link was sent to
+type Payload struct {
+	VoterId  int    `json:"voterId"`
+	IssuedAt int64  `json:"iat"`
+	Tenant   string `json:"tenant,omitempty"`
+	Email    string `json:"email,omitempty"`
+}
+
+// Signer signs and verifies voter card payloads with an HMAC key
+type Signer struct {
+	key []byte
+}
+
+// NewSigner is a constructor function that returns a pointer to a new
+// Signer signing with key.  If the key is empty a random one is generated,
+// which means anything signed before a restart will no longer verify, so
+// always set it outside of development.  name is what the key is called in
+// the warning logged then, for example CARD_SIGNING_KEY
+func NewSigner(name string, key string) (*Signer, error) {
+	keyBytes := []byte(key)
+	if len(keyBytes) == 0 {
+		slog.Warn("Signing key not set, using a random key", "key", name)
+		keyBytes = make([]byte, 32)
+		if _, err := rand.Read(keyBytes); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Signer{key: keyBytes}, nil
+}
+
+// Sign encodes the payload as <base64 json>.<base64 hmac>
+func (s *Signer) Sign(payload Payload) (string, error) {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+
+	body := base64.RawURLEncoding.EncodeToString(payloadBytes)
+	return body + "." + s.signature(body), nil
+}
+
+// Verify checks the signature of a QR payload and returns what was signed
+func (s *Signer) Verify(token string) (Payload, error) {
+	body, signature, found := strings.Cut(token, ".")
+	if !found {
+		return Payload{}, ErrInvalidSignature
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(s.signature(body))) {
+		return Payload{}, ErrInvalidSignature
+	}
+
+	payloadBytes, err := base64.RawURLEncoding.DecodeString(body)
+	if err != nil {
+		return Payload{}, ErrInvalidSignature
+	}
+
+	var payload Payload
+	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+		return Payload{}, ErrInvalidSignature
+	}
+
+	return payload, nil
+}
+
+// SignBytes returns the base64url HMAC signature of raw bytes, the
+// counterpart of VerifyBytes
+func (s *Signer) SignBytes(data []byte) string {
+	return s.signature(string(data))
+}
+
+// VerifyBytes checks a base64url HMAC signature over raw bytes, this is
+// how kiosks sign the batch files they upload
+func (s *Signer) VerifyBytes(data []byte, signature string) bool {
+	return hmac.Equal([]byte(signature), []byte(s.signature(string(data))))
+}
+
+func (s *Signer) signature(body string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(body))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// RenderPDF renders a printable voter card with the voters name and ID
+// and a QR code holding the signed payload, for the registration desk
+func RenderPDF(voterId int, name string, signedPayload string) ([]byte, error) {
+	qrPNG, err := qrcode.Encode(signedPayload, qrcode.Medium, 256)
+	if err != nil {
+		return nil, err
+	}
+
+	pdf := fpdf.New("L", "mm", "A6", "")
+	pdf.SetTitle(fmt.Sprintf("Voter Card %d", voterId), true)
+	pdf.AddPage()
+
+	pdf.SetFont("Helvetica", "B", 18)
+	pdf.Cell(0, 10, "Voter Card")
+	pdf.Ln(14)
+
+	pdf.SetFont("Helvetica", "", 12)
+	pdf.Cell(0, 8, "Name: "+name)
+	pdf.Ln(8)
+	pdf.Cell(0, 8, fmt.Sprintf("Voter ID: %d", voterId))
+	pdf.Ln(8)
+	pdf.SetFont("Helvetica", "", 8)
+	pdf.Cell(0, 6, "Issued: "+time.Now().Format("2006-01-02"))
+
+	imageOptions := fpdf.ImageOptions{ImageType: "PNG"}
+	pdf.RegisterImageOptionsReader("qr", imageOptions, bytes.NewReader(qrPNG))
+	pdf.ImageOptions("qr", 95, 30, 45, 45, false, imageOptions, 0, "")
+
+	var out bytes.Buffer
+	if err := pdf.Output(&out); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
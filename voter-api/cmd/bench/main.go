@@ -0,0 +1,168 @@
+// bench compares the throughput of the voter API with and without
+// prefork on the machine it runs on.  It starts the server binary once per
+// mode, hammers one endpoint with keep-alive connections for a while and
+// prints the requests per second and latency percentiles of each run.
+//
+//	go build -o /tmp/voter-api . && go run ./cmd/bench -server /tmp/voter-api
+//
+// Redis has to be running, the default path reads the voter list.  Use
+// -path /api/v1/voters/1 to measure single voter reads instead
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	serverFlag      string
+	portFlag        int
+	pathFlag        string
+	connectionsFlag int
+	durationFlag    time.Duration
+	serverArgsFlag  string
+)
+
+func processCmdLineFlags() {
+	flag.StringVar(&serverFlag, "server", "./voter-api", "Server binary to benchmark")
+	flag.IntVar(&portFlag, "p", 18080, "Port to start the server on")
+	flag.StringVar(&pathFlag, "path", "/api/v1/voters", "Path to request")
+	flag.IntVar(&connectionsFlag, "c", 64, "Concurrent connections")
+	flag.DurationVar(&durationFlag, "d", 10*time.Second, "How long to run each mode")
+	flag.StringVar(&serverArgsFlag, "server-args", "", "Extra argument passed to the server, e.g. -concurrency=1024")
+
+	flag.Parse()
+}
+
+// result is what one run measured
+type result struct {
+	mode      string
+	requests  int64
+	errors    int64
+	latencies []time.Duration
+}
+
+func (r result) percentile(p float64) time.Duration {
+	if len(r.latencies) == 0 {
+		return 0
+	}
+	return r.latencies[int(float64(len(r.latencies)-1)*p)]
+}
+
+// startServer starts the server binary and waits until it answers
+func startServer(prefork bool) (*exec.Cmd, error) {
+	args := []string{
+		"-p", strconv.Itoa(portFlag),
+		"-prefork=" + strconv.FormatBool(prefork),
+		"-log-level", "error",
+		"-legacy-routes=false",
+	}
+	if serverArgsFlag != "" {
+		args = append(args, serverArgsFlag)
+	}
+
+	cmd := exec.Command(serverFlag, args...)
+	cmd.Env = append(os.Environ(), "RATE_LIMIT_RPS=0", "RATE_LIMIT_VOTES_RPS=0")
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	healthURL := fmt.Sprintf("http://localhost:%d/api/v1/voters/health", portFlag)
+	for deadline := time.Now().Add(30 * time.Second); time.Now().Before(deadline); {
+		if rsp, err := http.Get(healthURL); err == nil {
+			rsp.Body.Close()
+			return cmd, nil
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+
+	cmd.Process.Kill()
+	return nil, fmt.Errorf("server did not start on port %d", portFlag)
+}
+
+// run sends requests over connectionsFlag connections until the
+// duration is up
+func run(mode string) result {
+	url := fmt.Sprintf("http://localhost:%d%s", portFlag, pathFlag)
+	client := &http.Client{Transport: &http.Transport{
+		MaxIdleConnsPerHost: connectionsFlag,
+	}}
+
+	var requests, errors atomic.Int64
+	var mu sync.Mutex
+	var latencies []time.Duration
+
+	var wg sync.WaitGroup
+	stop := time.Now().Add(durationFlag)
+	for i := 0; i < connectionsFlag; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			local := make([]time.Duration, 0, 1024)
+			for time.Now().Before(stop) {
+				start := time.Now()
+				rsp, err := client.Get(url)
+				if err != nil {
+					errors.Add(1)
+					continue
+				}
+				io.Copy(io.Discard, rsp.Body)
+				rsp.Body.Close()
+				if rsp.StatusCode != http.StatusOK {
+					errors.Add(1)
+				}
+				requests.Add(1)
+				local = append(local, time.Since(start))
+			}
+			mu.Lock()
+			latencies = append(latencies, local...)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	return result{mode: mode, requests: requests.Load(), errors: errors.Load(), latencies: latencies}
+}
+
+func main() {
+	processCmdLineFlags()
+
+	var results []result
+	for _, prefork := range []bool{false, true} {
+		mode := "single process"
+		if prefork {
+			mode = "prefork"
+		}
+
+		fmt.Printf("Running %s for %s...\n", mode, durationFlag)
+		cmd, err := startServer(prefork)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		results = append(results, run(mode))
+
+		//prefork children exit on their own once the parent is gone
+		cmd.Process.Kill()
+		cmd.Wait()
+		time.Sleep(time.Second)
+	}
+
+	fmt.Printf("\n%-16s %12s %8s %10s %10s %10s\n", "mode", "req/s", "errors", "p50", "p99", "max")
+	for _, r := range results {
+		fmt.Printf("%-16s %12.0f %8d %10s %10s %10s\n", r.mode,
+			float64(r.requests)/durationFlag.Seconds(), r.errors,
+			r.percentile(0.50), r.percentile(0.99), r.percentile(1))
+	}
+}
@@ -0,0 +1,147 @@
+// replay re-executes a request captured with X-Replay-Capture against a
+// dev instance of the voter API, and compares the response it gets with
+// the one that was captured.
+//
+// Fetch a capture straight from the instance that recorded it:
+//
+//	go run ./cmd/replay -source http://prod:1080 -admin-token $ADMIN_TOKEN \
+//		-id <capture id> -target http://localhost:1080
+//
+// or replay a capture saved from GET /admin/replays/:id:
+//
+//	go run ./cmd/replay -file capture.json -target http://localhost:1080
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/adllev/Voter-Container/voter-api/db"
+)
+
+var (
+	sourceFlag     string
+	adminTokenFlag string
+	idFlag         string
+	fileFlag       string
+	targetFlag     string
+)
+
+func processCmdLineFlags() {
+	flag.StringVar(&sourceFlag, "source", "", "Base URL of the instance the capture was recorded on")
+	flag.StringVar(&adminTokenFlag, "admin-token", "", "Admin token for the source instance")
+	flag.StringVar(&idFlag, "id", "", "Capture id to fetch from the source instance")
+	flag.StringVar(&fileFlag, "file", "", "Read the capture from a JSON file instead")
+	flag.StringVar(&targetFlag, "target", "http://localhost:1080", "Base URL of the instance to replay against")
+
+	flag.Parse()
+}
+
+// loadCapture reads the capture from a file or from the source instance
+func loadCapture() (db.ReplayCapture, error) {
+	var capture db.ReplayCapture
+	var captureBytes []byte
+	var err error
+
+	switch {
+	case fileFlag != "":
+		captureBytes, err = os.ReadFile(fileFlag)
+	case sourceFlag != "" && idFlag != "":
+		captureBytes, err = fetchCapture()
+	default:
+		return capture, fmt.Errorf("either -file or -source and -id are required")
+	}
+	if err != nil {
+		return capture, err
+	}
+
+	err = json.Unmarshal(captureBytes, &capture)
+	return capture, err
+}
+
+func fetchCapture() ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(sourceFlag, "/")+"/admin/replays/"+idFlag, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Admin-Token", adminTokenFlag)
+
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching capture %s: %s", idFlag, rsp.Status)
+	}
+	return io.ReadAll(rsp.Body)
+}
+
+// replay sends the captured request to the target instance.  Redacted
+// headers are not sent, they would only be rejected by the target
+func replay(capture db.ReplayCapture) (*http.Response, []byte, error) {
+	url := strings.TrimRight(targetFlag, "/") + capture.Request.Path
+	if capture.Request.Query != "" {
+		url += "?" + capture.Request.Query
+	}
+
+	req, err := http.NewRequest(capture.Request.Method, url, bytes.NewReader(capture.Request.Body))
+	if err != nil {
+		return nil, nil, err
+	}
+	for name, value := range capture.Request.Headers {
+		if value == "[REDACTED]" || strings.EqualFold(name, "Content-Length") || strings.EqualFold(name, "Host") {
+			continue
+		}
+		req.Header.Set(name, value)
+	}
+
+	//Tag the replay so its log lines on the target can be told apart from
+	//the original request, and found by the capture id
+	req.Header.Set("X-Request-ID", "replay-"+capture.Id)
+
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rsp.Body.Close()
+
+	body, err := io.ReadAll(rsp.Body)
+	return rsp, body, err
+}
+
+func main() {
+	processCmdLineFlags()
+
+	capture, err := loadCapture()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Replaying %s %s (captured %s)\n", capture.Request.Method,
+		capture.Request.Path, capture.CapturedAt.Format("2006-01-02 15:04:05"))
+
+	rsp, body, err := replay(capture)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Captured status: %d\n", capture.Response.Status)
+	fmt.Printf("Replayed status: %d\n", rsp.StatusCode)
+	fmt.Printf("Captured body:   %s\n", string(capture.Response.Body))
+	fmt.Printf("Replayed body:   %s\n", string(body))
+
+	if rsp.StatusCode != capture.Response.Status {
+		fmt.Println("Status differs from the capture")
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,1009 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adllev/Voter-Container/voter-api/auth"
+	"github.com/adllev/Voter-Container/voter-api/db"
+	"github.com/adllev/Voter-Container/voter-api/jobs"
+	"github.com/adllev/Voter-Container/voter-api/logging"
+	"github.com/adllev/Voter-Container/voter-api/notifications"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Auth modes.  In auto mode authentication is on when JWT_SIGNING_KEY or
+// API_KEYS is configured and off otherwise, which is handy on a laptop
+// but means a deployment that lost its secrets starts wide open.
+// required refuses to start without them
+const (
+	AuthModeAuto     = "auto"
+	AuthModeRequired = "required"
+)
+
+// What to do when the stored data was written by a newer release
+const (
+	SchemaGuardRefuse   = "refuse"
+	SchemaGuardReadOnly = "read-only"
+)
+
+// DefaultRequestTimeout is the deadline of a request, a voter read takes
+// a few milliseconds so only a hung redis gets anywhere near it
+const DefaultRequestTimeout = 10 * time.Second
+
+// DefaultCompressMinBytes is the smallest response worth compressing.
+// Below about a kilobyte the encoding headers and the CPU time cost more
+// than the bytes saved
+const DefaultCompressMinBytes = 1024
+
+// DefaultVerificationTTL is how long a voter has to click the link in the
+// verification email, long enough to survive a weekend
+const DefaultVerificationTTL = 72 * time.Hour
+
+// DefaultUndoWindow is how long a voter has to take back a vote cast by
+// mistake, a wrong tap is noticed within a minute or two
+const DefaultUndoWindow = 5 * time.Minute
+
+// DefaultResultsSnapshotInterval is how often the results of open polls
+// are recorded for GET /polls/:pollid/results/history
+const DefaultResultsSnapshotInterval = 15 * time.Minute
+
+// DefaultAnalyticsMinGroup is the smallest group of voters analytics
+// report on, smaller groups are suppressed so nobody can be picked out
+const DefaultAnalyticsMinGroup = 5
+
+// Default rate limits, per client.  Recording votes and check-ins gets a
+// much tighter limit than everything else, a person votes a handful of
+// times and a script hammering those endpoints is exactly what we want to
+// stop
+var (
+	DefaultRateLimit = RateLimitConfig{RPS: 20, Burst: 40}
+	DefaultVoteLimit = RateLimitConfig{RPS: 1, Burst: 5}
+)
+
+// Config is every setting of the server.  Default has the defaults, a
+// config file overrides them, the environment overrides the file and the
+// command line overrides everything, see Load
+type Config struct {
+	Host string `yaml:"host"`
+	Port uint   `yaml:"port"`
+
+	LogFormat string `yaml:"logFormat"`
+	LogLevel  string `yaml:"logLevel"`
+
+	//AccessLogSample is the share of successful reads that get an access
+	//log line, 1 logs every request.  Writes and errors are always logged
+	AccessLogSample float64 `yaml:"accessLogSample"`
+
+	//Redis is handed to the db package as is.  RedisWait is how long to
+	//wait for redis at startup, with FailFast we exit if it never came up
+	Redis     db.Config     `yaml:"redis"`
+	RedisWait time.Duration `yaml:"redisWait"`
+	FailFast  bool          `yaml:"failFast"`
+
+	SchemaGuard string `yaml:"schemaGuard"`
+
+	Auth      AuthConfig      `yaml:"auth"`
+	TLS       TLSConfig       `yaml:"tls"`
+	Tenants   TenantsConfig   `yaml:"tenants"`
+	Sync      SyncConfig      `yaml:"sync"`
+	Reminders RemindersConfig `yaml:"reminders"`
+
+	RateLimits RateLimitsConfig `yaml:"rateLimits"`
+	CORS       CORSConfig       `yaml:"cors"`
+
+	Notifications NotificationsConfig `yaml:"notifications"`
+	Jobs          JobsConfig          `yaml:"jobs"`
+	Retention     RetentionConfig     `yaml:"retention"`
+	Capacity      CapacityConfig      `yaml:"capacity"`
+
+	//SigningKeys are secrets, they are only read from the environment
+	SigningKeys SigningKeysConfig `yaml:"-"`
+
+	Prefork        bool `yaml:"prefork"`
+	Concurrency    int  `yaml:"concurrency"`
+	ReadBufferSize int  `yaml:"readBufferSize"`
+
+	//BodyLimit is the largest request body in bytes, larger ones are a 413
+	BodyLimit int `yaml:"bodyLimit"`
+
+	//RequestTimeout is the deadline of every request, 0 for none
+	RequestTimeout time.Duration `yaml:"requestTimeout"`
+
+	//CompressMinBytes is the smallest response to compress, -1 never
+	CompressMinBytes int `yaml:"compressMinBytes"`
+
+	//LegacyRoutes also serves the deprecated unversioned routes, they
+	//announce LegacySunset as the day they go away when it is set
+	LegacyRoutes bool      `yaml:"legacyRoutes"`
+	LegacySunset time.Time `yaml:"legacySunset"`
+
+	//PollAPIURL is the poll service votes are checked against, empty to
+	//check them against the polls stored here
+	PollAPIURL string `yaml:"pollApiUrl"`
+
+	//FreezeResults keeps the results of a poll as they were when it
+	//closed, later changes to its votes no longer show up in them
+	FreezeResults bool `yaml:"freezeResults"`
+
+	//VerificationTTL is how long the link in the email sent to a newly
+	//registered voter stays valid
+	VerificationTTL time.Duration `yaml:"verificationTtl"`
+
+	//UndoWindow is how long after casting it a voter can withdraw a vote,
+	//0 to not let them
+	UndoWindow time.Duration `yaml:"undoWindow"`
+
+	//AnalyticsMinGroup anonymizes the demographic analytics, groups with
+	//fewer voters are suppressed.  0 reports every group
+	AnalyticsMinGroup int `yaml:"analyticsMinGroup"`
+
+	//ResultsSnapshotInterval is how often the results of the open polls
+	//are recorded, 0 to not record them
+	ResultsSnapshotInterval time.Duration `yaml:"resultsSnapshotInterval"`
+
+	//Features are the defaults of the feature flags, see features.go
+	Features map[string]bool `yaml:"features"`
+}
+
+// AuthConfig is how requests are authenticated.  Bearer tokens are signed
+// with JWTSigningKey and, when JWTIssuer is set, issued by it.  APIKeys
+// are the static API keys, see auth.NewAPIKeys, and OIDC the identity
+// provider people log in with.  AdminToken gates the admin only features
+// such as replay capture.  The signing key and the admin token are
+// secrets, they are only read from the environment so the config file can
+// be committed
+type AuthConfig struct {
+	Mode        string `yaml:"mode"`
+	PublicReads bool   `yaml:"publicReads"`
+
+	JWTSigningKey string          `yaml:"-"`
+	AdminToken    string          `yaml:"-"`
+	JWTIssuer     string          `yaml:"jwtIssuer"`
+	APIKeys       []string        `yaml:"apiKeys"`
+	OIDC          auth.OIDCConfig `yaml:"oidc"`
+}
+
+// RateLimitConfig is a token bucket per client, RPS requests a second up
+// to Burst at once.  An RPS of 0 turns the limit off
+type RateLimitConfig struct {
+	RPS   float64 `yaml:"rps"`
+	Burst int     `yaml:"burst"`
+}
+
+// check reports what is wrong with a rate limit, nil when nothing is
+func (limit RateLimitConfig) check(name string) error {
+	switch {
+	case limit.RPS < 0:
+		return fmt.Errorf("the %s can not be negative", name)
+	case limit.RPS > 0 && limit.Burst <= 0:
+		return fmt.Errorf("the %s burst must be positive", name)
+	}
+	return nil
+}
+
+// RateLimitsConfig is how fast each client can call us, Votes applies to
+// recording votes and check-ins and Requests to everything else
+type RateLimitsConfig struct {
+	Requests RateLimitConfig `yaml:"requests"`
+	Votes    RateLimitConfig `yaml:"votes"`
+}
+
+// CORSConfig lets a browser based front-end on another origin call the
+// api directly.  An empty list keeps the fiber default, which allows
+// every origin.  MaxAge is how long, in seconds, a preflight can be cached
+type CORSConfig struct {
+	AllowOrigins     []string `yaml:"allowOrigins"`
+	AllowMethods     []string `yaml:"allowMethods"`
+	AllowHeaders     []string `yaml:"allowHeaders"`
+	AllowCredentials bool     `yaml:"allowCredentials"`
+	MaxAge           int      `yaml:"maxAge"`
+}
+
+// TenantsConfig is how a request picks its tenant, see api.Tenant.  The
+// X-Tenant-ID header always works, with a Domain the subdomain in front of
+// it does too: acme.elections.example.com is tenant acme.  When Allowed is
+// empty any well formed tenant id is accepted
+type TenantsConfig struct {
+	Domain  string   `yaml:"domain"`
+	Allowed []string `yaml:"allowed"`
+}
+
+// SyncConfig is the upstream registration system the voters are pulled
+// from every Interval, see upstream.Syncer.  An empty URL turns it off
+type SyncConfig struct {
+	URL      string        `yaml:"url"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+// RemindersConfig is when voters are reminded of a poll they have not
+// voted in: Lead before it closes, checked every Interval.  A Lead of 0
+// turns the reminders off
+type RemindersConfig struct {
+	Lead     time.Duration `yaml:"lead"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+// NotificationsConfig is which channels the messages to voters go out on,
+// see notifications.Router.  Routes maps an event, e.g. poll.reminder, to
+// its channels, an event without a route uses Default and an event routed
+// to no channel is not sent at all.  Channels is how to reach them, see
+// notifications.NewChannels
+type NotificationsConfig struct {
+	Default  []string                     `yaml:"default"`
+	Routes   map[string][]string          `yaml:"routes"`
+	Channels notifications.ChannelsConfig `yaml:"channels"`
+}
+
+// JobsConfig is when the background jobs run, see jobs.Scheduler.  Every
+// Interval each replica checks which jobs are due.  Schedules overrides
+// the schedule of a job by name, see jobs.ParseSchedule, off to only run
+// it when triggered through /admin/jobs.  Without one a job runs on the
+// interval of its own setting, see JobSchedule
+type JobsConfig struct {
+	Interval  time.Duration     `yaml:"interval"`
+	Schedules map[string]string `yaml:"schedules"`
+}
+
+// JobSchedule is the schedule of the job name: the one configured in
+// Jobs.Schedules, otherwise the default of the job
+func (cfg Config) JobSchedule(name string) string {
+	if schedule, found := cfg.Jobs.Schedules[name]; found {
+		return schedule
+	}
+	every := func(interval time.Duration) string {
+		if interval <= 0 {
+			return "off"
+		}
+		return "@every " + interval.String()
+	}
+	switch name {
+	case jobs.JobSnapshots:
+		return every(cfg.ResultsSnapshotInterval)
+	case jobs.JobSync:
+		return every(cfg.Sync.Interval)
+	case jobs.JobReminders:
+		return every(cfg.Reminders.Interval)
+	case jobs.JobCapacity, jobs.JobCleanup:
+		return "@daily"
+	case jobs.JobRetention:
+		if cfg.Retention.Policy().Enabled() {
+			return "@daily"
+		}
+	}
+	return "off"
+}
+
+// RetentionConfig is how much vote history voters keep, see
+// db.RetentionPolicy: entries older than MaxAgeDays and the oldest past
+// MaxEntries per voter are dropped by the retention job, 0 leaves a limit
+// off.  With an ArchiveFile the dropped entries are appended to it first
+type RetentionConfig struct {
+	MaxAgeDays  int    `yaml:"maxAgeDays"`
+	MaxEntries  int    `yaml:"maxEntries"`
+	ArchiveFile string `yaml:"archiveFile"`
+}
+
+// Policy is the retention policy to apply
+func (r RetentionConfig) Policy() db.RetentionPolicy {
+	return db.RetentionPolicy{
+		MaxAge:     time.Duration(r.MaxAgeDays) * 24 * time.Hour,
+		MaxEntries: r.MaxEntries,
+	}
+}
+
+// CapacityConfig is how many voters, vote history entries and index
+// entries redis is sized for, GET /admin/capacity projects when they are
+// reached.  0 leaves a limit off
+type CapacityConfig struct {
+	MaxVoters  int64 `yaml:"maxVoters"`
+	MaxHistory int64 `yaml:"maxHistory"`
+	MaxIndex   int64 `yaml:"maxIndex"`
+}
+
+// Limits are the configured limits by cardinality series, the series
+// without a limit are left out
+func (c CapacityConfig) Limits() map[string]int64 {
+	limits := make(map[string]int64)
+	for series, limit := range map[string]int64{
+		db.CardinalityVoters:  c.MaxVoters,
+		db.CardinalityHistory: c.MaxHistory,
+		db.CardinalityIndex:   c.MaxIndex,
+	} {
+		if limit > 0 {
+			limits[series] = limit
+		}
+	}
+	return limits
+}
+
+// SigningKeysConfig are the HMAC keys of the tokens we hand out, see
+// cards.Signer.  Each kind of token has its own key so one can not be
+// passed off as another, an empty key is a random one
+type SigningKeysConfig struct {
+	Card         string
+	Kiosk        string
+	Bounce       string
+	Verification string
+	Receipt      string
+	Ballot       string
+}
+
+// TLSConfig is the certificate to serve HTTPS with, when CertFile is empty
+// we serve plain HTTP behind a proxy that terminates TLS
+type TLSConfig struct {
+	CertFile string        `yaml:"certFile"`
+	KeyFile  string        `yaml:"keyFile"`
+	Reload   time.Duration `yaml:"reload"`
+}
+
+// Default returns the settings used when nothing is configured
+func Default() Config {
+	return Config{
+		Host:                    "0.0.0.0",
+		Port:                    1080,
+		LogFormat:               logging.FormatJSON,
+		LogLevel:                "info",
+		AccessLogSample:         1,
+		Redis:                   db.DefaultConfig(),
+		RedisWait:               30 * time.Second,
+		SchemaGuard:             SchemaGuardRefuse,
+		Auth:                    AuthConfig{Mode: AuthModeAuto, PublicReads: true},
+		RateLimits:              RateLimitsConfig{Requests: DefaultRateLimit, Votes: DefaultVoteLimit},
+		Concurrency:             fiber.DefaultConcurrency,
+		ReadBufferSize:          fiber.DefaultReadBufferSize,
+		BodyLimit:               fiber.DefaultBodyLimit,
+		RequestTimeout:          DefaultRequestTimeout,
+		CompressMinBytes:        DefaultCompressMinBytes,
+		LegacyRoutes:            true,
+		FreezeResults:           true,
+		VerificationTTL:         DefaultVerificationTTL,
+		UndoWindow:              DefaultUndoWindow,
+		Sync:                    SyncConfig{Interval: time.Hour},
+		Reminders:               RemindersConfig{Interval: 15 * time.Minute},
+		Notifications:           NotificationsConfig{Default: []string{notifications.ChannelEmail}},
+		Jobs:                    JobsConfig{Interval: time.Minute},
+		AnalyticsMinGroup:       DefaultAnalyticsMinGroup,
+		ResultsSnapshotInterval: DefaultResultsSnapshotInterval,
+		Features:                DefaultFeatures(),
+	}
+}
+
+// FromEnv returns Default overridden by the environment, see ApplyEnv
+func FromEnv() (Config, error) {
+	cfg := Default()
+	if err := cfg.ApplyEnv(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// ApplyEnv overrides the settings that are set in the environment:
+//
+//	LISTEN_HOST, LISTEN_PORT    interface and port to listen on
+//	LOG_FORMAT, LOG_LEVEL       json or text, debug, info, warn or error
+//	ACCESS_LOG_SAMPLE           e.g. 0.1 to log one in ten successful reads
+//	REDIS_URL, REDIS_...        see db.ConfigFromEnv
+//	REDIS_WAIT, FAIL_FAST       e.g. 30s, true
+//	SCHEMA_GUARD                refuse or read-only
+//	AUTH_MODE                   auto or required
+//	AUTH_PUBLIC_READS           true or false
+//	JWT_SIGNING_KEY, JWT_ISSUER key bearer tokens are signed with, their iss
+//	ADMIN_TOKEN                 X-Admin-Token of the admin only features
+//	CARD_SIGNING_KEY, KIOSK_SIGNING_KEY, BOUNCE_SIGNING_KEY,
+//	VERIFICATION_SIGNING_KEY, RECEIPT_SIGNING_KEY, BALLOT_SIGNING_KEY
+//	API_KEYS                    name:sha256:scopes,..., see auth.NewAPIKeys
+//	OIDC_ISSUER_URL, OIDC_CLIENT_ID, OIDC_CLIENT_SECRET, OIDC_REDIRECT_URL
+//	RATE_LIMIT_RPS, RATE_LIMIT_BURST              e.g. 20, 40, 0 rps for none
+//	RATE_LIMIT_VOTES_RPS, RATE_LIMIT_VOTES_BURST  e.g. 1, 5
+//	CORS_ALLOW_ORIGINS          e.g. https://polls.example.com,https://admin.example.com
+//	CORS_ALLOW_METHODS, CORS_ALLOW_HEADERS        comma separated
+//	CORS_ALLOW_CREDENTIALS      true to let the browser send the session cookie
+//	CORS_MAX_AGE                seconds a preflight can be cached
+//	TLS_CERT_FILE, TLS_KEY_FILE serve HTTPS when set
+//	TLS_RELOAD                  e.g. 1h
+//	TENANT_DOMAIN               e.g. elections.example.com
+//	TENANTS                     comma separated tenant ids, empty for any
+//	SYNC_URL, SYNC_INTERVAL     upstream voter list (JSON or CSV), e.g. 1h
+//	REQUEST_TIMEOUT             e.g. 10s, 0 for none
+//	BODY_LIMIT                  largest request body in bytes
+//	PREFORK, CONCURRENCY, READ_BUFFER_SIZE, COMPRESS_MIN_BYTES, LEGACY_ROUTES
+//	LEGACY_SUNSET               e.g. 2027-01-31, when legacy routes go away
+//	POLL_API_URL                poll-api votes are checked against
+//	FREEZE_RESULTS              true or false, keep results from poll close
+//	VERIFICATION_TTL            e.g. 72h, how long verification links work
+//	UNDO_WINDOW                 e.g. 5m, 0 to not let voters withdraw votes
+//	ANALYTICS_MIN_GROUP         smallest group analytics report, 0 for all
+//	RESULTS_SNAPSHOT_INTERVAL   e.g. 15m, 0 to not record result history
+//	REMINDER_LEAD               e.g. 24h, 0 to send no reminders
+//	REMINDER_INTERVAL           e.g. 15m
+//	NOTIFICATION_DEFAULT        channels of unrouted events, e.g. email,slack
+//	NOTIFICATION_ROUTES         e.g. poll.reminder=email+sms,vote.receipt=email
+//	SMTP_HOST, SMTP_PORT        server emails go out through, port 587 by default
+//	SMTP_USERNAME, SMTP_PASSWORD  PLAIN auth, none without a username
+//	SMTP_FROM                   e.g. Elections <no-reply@example.com>
+//	TWILIO_ACCOUNT_SID, TWILIO_AUTH_TOKEN, TWILIO_FROM  the sms channel
+//	SLACK_WEBHOOK_URL           incoming webhook of the slack channel
+//	NOTIFICATION_WEBHOOK_URL, NOTIFICATION_WEBHOOK_SECRET  the webhook channel
+//	RETENTION_MAX_AGE_DAYS      e.g. 365, drop older vote history, 0 keeps it
+//	RETENTION_MAX_ENTRIES       e.g. 100, vote history kept per voter, 0 all
+//	RETENTION_ARCHIVE_FILE      JSON lines file dropped history is archived to
+//	CAPACITY_MAX_VOTERS, CAPACITY_MAX_HISTORY, CAPACITY_MAX_INDEX  0 for none
+//	JOBS_INTERVAL               e.g. 1m, how often replicas look for due jobs
+//	JOB_SCHEDULES               e.g. reindex=0 3 * * 0;cleanup=off
+//	FEATURE_DELETE_ALL, ...     see Features
+//
+// An invalid value is an error rather than silently falling back
+func (cfg *Config) ApplyEnv() error {
+	if err := cfg.Redis.ApplyEnv(); err != nil {
+		return err
+	}
+
+	env := envReader{}
+	env.string("LISTEN_HOST", &cfg.Host)
+	env.uint("LISTEN_PORT", &cfg.Port)
+	env.string("LOG_FORMAT", &cfg.LogFormat)
+	env.string("LOG_LEVEL", &cfg.LogLevel)
+	env.float("ACCESS_LOG_SAMPLE", &cfg.AccessLogSample)
+	env.duration("REDIS_WAIT", &cfg.RedisWait)
+	env.bool("FAIL_FAST", &cfg.FailFast)
+	env.string("SCHEMA_GUARD", &cfg.SchemaGuard)
+	env.string("AUTH_MODE", &cfg.Auth.Mode)
+	env.bool("AUTH_PUBLIC_READS", &cfg.Auth.PublicReads)
+	env.string("JWT_SIGNING_KEY", &cfg.Auth.JWTSigningKey)
+	env.string("JWT_ISSUER", &cfg.Auth.JWTIssuer)
+	env.string("ADMIN_TOKEN", &cfg.Auth.AdminToken)
+	env.string("CARD_SIGNING_KEY", &cfg.SigningKeys.Card)
+	env.string("KIOSK_SIGNING_KEY", &cfg.SigningKeys.Kiosk)
+	env.string("BOUNCE_SIGNING_KEY", &cfg.SigningKeys.Bounce)
+	env.string("VERIFICATION_SIGNING_KEY", &cfg.SigningKeys.Verification)
+	env.string("RECEIPT_SIGNING_KEY", &cfg.SigningKeys.Receipt)
+	env.string("BALLOT_SIGNING_KEY", &cfg.SigningKeys.Ballot)
+	env.list("API_KEYS", &cfg.Auth.APIKeys)
+	env.string("OIDC_ISSUER_URL", &cfg.Auth.OIDC.IssuerURL)
+	env.string("OIDC_CLIENT_ID", &cfg.Auth.OIDC.ClientID)
+	env.string("OIDC_CLIENT_SECRET", &cfg.Auth.OIDC.ClientSecret)
+	env.string("OIDC_REDIRECT_URL", &cfg.Auth.OIDC.RedirectURL)
+	env.float("RATE_LIMIT_RPS", &cfg.RateLimits.Requests.RPS)
+	env.int("RATE_LIMIT_BURST", &cfg.RateLimits.Requests.Burst)
+	env.float("RATE_LIMIT_VOTES_RPS", &cfg.RateLimits.Votes.RPS)
+	env.int("RATE_LIMIT_VOTES_BURST", &cfg.RateLimits.Votes.Burst)
+	env.list("CORS_ALLOW_ORIGINS", &cfg.CORS.AllowOrigins)
+	env.list("CORS_ALLOW_METHODS", &cfg.CORS.AllowMethods)
+	env.list("CORS_ALLOW_HEADERS", &cfg.CORS.AllowHeaders)
+	env.bool("CORS_ALLOW_CREDENTIALS", &cfg.CORS.AllowCredentials)
+	env.int("CORS_MAX_AGE", &cfg.CORS.MaxAge)
+	env.string("TLS_CERT_FILE", &cfg.TLS.CertFile)
+	env.string("TLS_KEY_FILE", &cfg.TLS.KeyFile)
+	env.duration("TLS_RELOAD", &cfg.TLS.Reload)
+	env.string("TENANT_DOMAIN", &cfg.Tenants.Domain)
+	env.list("TENANTS", &cfg.Tenants.Allowed)
+	env.string("SYNC_URL", &cfg.Sync.URL)
+	env.duration("SYNC_INTERVAL", &cfg.Sync.Interval)
+	env.duration("REMINDER_LEAD", &cfg.Reminders.Lead)
+	env.duration("REMINDER_INTERVAL", &cfg.Reminders.Interval)
+	env.list("NOTIFICATION_DEFAULT", &cfg.Notifications.Default)
+	env.parse("NOTIFICATION_ROUTES", func(v string) (err error) {
+		cfg.Notifications.Routes, err = parseRoutes(v)
+		return err
+	})
+	env.string("SMTP_HOST", &cfg.Notifications.Channels.SMTP.Host)
+	env.int("SMTP_PORT", &cfg.Notifications.Channels.SMTP.Port)
+	env.string("SMTP_USERNAME", &cfg.Notifications.Channels.SMTP.Username)
+	env.string("SMTP_PASSWORD", &cfg.Notifications.Channels.SMTP.Password)
+	env.string("SMTP_FROM", &cfg.Notifications.Channels.SMTP.From)
+	env.string("TWILIO_ACCOUNT_SID", &cfg.Notifications.Channels.Twilio.AccountSid)
+	env.string("TWILIO_AUTH_TOKEN", &cfg.Notifications.Channels.Twilio.AuthToken)
+	env.string("TWILIO_FROM", &cfg.Notifications.Channels.Twilio.From)
+	env.string("SLACK_WEBHOOK_URL", &cfg.Notifications.Channels.SlackWebhookURL)
+	env.string("NOTIFICATION_WEBHOOK_URL", &cfg.Notifications.Channels.Webhook.URL)
+	env.string("NOTIFICATION_WEBHOOK_SECRET", &cfg.Notifications.Channels.Webhook.Secret)
+	env.int64("CAPACITY_MAX_VOTERS", &cfg.Capacity.MaxVoters)
+	env.int64("CAPACITY_MAX_HISTORY", &cfg.Capacity.MaxHistory)
+	env.int64("CAPACITY_MAX_INDEX", &cfg.Capacity.MaxIndex)
+	env.int("RETENTION_MAX_AGE_DAYS", &cfg.Retention.MaxAgeDays)
+	env.int("RETENTION_MAX_ENTRIES", &cfg.Retention.MaxEntries)
+	env.string("RETENTION_ARCHIVE_FILE", &cfg.Retention.ArchiveFile)
+	env.duration("JOBS_INTERVAL", &cfg.Jobs.Interval)
+	env.parse("JOB_SCHEDULES", func(v string) (err error) {
+		cfg.Jobs.Schedules, err = parseJobSchedules(v)
+		return err
+	})
+	env.bool("PREFORK", &cfg.Prefork)
+	env.int("CONCURRENCY", &cfg.Concurrency)
+	env.int("READ_BUFFER_SIZE", &cfg.ReadBufferSize)
+	env.int("BODY_LIMIT", &cfg.BodyLimit)
+	env.duration("REQUEST_TIMEOUT", &cfg.RequestTimeout)
+	env.int("COMPRESS_MIN_BYTES", &cfg.CompressMinBytes)
+	env.bool("LEGACY_ROUTES", &cfg.LegacyRoutes)
+	env.date("LEGACY_SUNSET", &cfg.LegacySunset)
+	env.string("POLL_API_URL", &cfg.PollAPIURL)
+	env.bool("FREEZE_RESULTS", &cfg.FreezeResults)
+	env.duration("VERIFICATION_TTL", &cfg.VerificationTTL)
+	env.duration("UNDO_WINDOW", &cfg.UndoWindow)
+	env.int("ANALYTICS_MIN_GROUP", &cfg.AnalyticsMinGroup)
+	env.duration("RESULTS_SNAPSHOT_INTERVAL", &cfg.ResultsSnapshotInterval)
+	if cfg.Features == nil {
+		cfg.Features = DefaultFeatures()
+	}
+	for name, envName := range Features {
+		enabled, found := cfg.Features[name]
+		if !found {
+			enabled = true
+		}
+		env.bool(envName, &enabled)
+		cfg.Features[name] = enabled
+	}
+
+	return errors.Join(env.errs...)
+}
+
+// Load builds the Config from the config file, the environment and the
+// command line flags in args, usually os.Args[1:], and validates it.  The
+// file is named by -config or CONFIG_FILE, without either there is none
+func Load(args []string) (Config, error) {
+	cfg := Default()
+
+	if path := configFilePath(args); path != "" {
+		if err := cfg.ApplyFile(path); err != nil {
+			return Config{}, err
+		}
+	}
+
+	if err := cfg.ApplyEnv(); err != nil {
+		return Config{}, err
+	}
+
+	flags := flag.NewFlagSet("voter-api", flag.ContinueOnError)
+	cfg.registerFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	return cfg, cfg.Validate()
+}
+
+// registerFlags adds a flag for every setting, defaulting to its current
+// value so a flag only wins when it is actually passed
+func (cfg *Config) registerFlags(flags *flag.FlagSet) {
+	//Already read by configFilePath, it is only here to be accepted and
+	//show up in -help
+	flags.String("config", "", "YAML config file, see config.example.yaml")
+
+	//Note some networking lingo, some frameworks start the server on localhost
+	//this is a local-only interface and is fine for testing but its not accessible
+	//from other machines.  To make the server accessible from other machines, we
+	//need to listen on an interface, that could be an IP address, but modern
+	//cloud servers may have multiple network interfaces for scale.  With TCP/IP
+	//the address 0.0.0.0 instructs the network stack to listen on all interfaces
+	flags.StringVar(&cfg.Host, "h", cfg.Host, "Listen on all interfaces")
+	flags.UintVar(&cfg.Port, "p", cfg.Port, "Default Port")
+
+	flags.StringVar(&cfg.Redis.Addr, "redis-url", cfg.Redis.Addr, "host:port of the redis server")
+
+	//When the container starts before redis (docker compose does not wait
+	//for redis to be ready), we keep retrying for redis-wait.  With
+	//fail-fast we exit if redis never came up so the orchestrator can
+	//restart us, otherwise we start degraded and the health check says so
+	flags.DurationVar(&cfg.RedisWait, "redis-wait", cfg.RedisWait, "How long to wait for redis at startup")
+	flags.BoolVar(&cfg.FailFast, "fail-fast", cfg.FailFast, "Exit if redis is not reachable at startup")
+
+	//During a rolling deploy an old replica may start after a new one has
+	//already written newer records.  "refuse" exits, "read-only" serves
+	//reads but rejects every write
+	flags.StringVar(&cfg.SchemaGuard, "schema-guard", cfg.SchemaGuard, "What to do if stored data is newer than this release: refuse or read-only")
+
+	flags.BoolVar(&cfg.LegacyRoutes, "legacy-routes", cfg.LegacyRoutes, "Also serve the deprecated unversioned routes")
+	flags.Func("legacy-sunset", "Date the legacy routes go away, e.g. 2027-01-31", func(value string) (err error) {
+		cfg.LegacySunset, err = parseDate(value)
+		return err
+	})
+
+	//Recording a vote checks that its poll exists.  When the polls are
+	//run by a separate poll-api they are looked up there instead
+	flags.StringVar(&cfg.PollAPIURL, "poll-api-url", cfg.PollAPIURL, "Base URL of the poll-api votes are checked against, empty to use the local polls")
+
+	//Once a poll closed its results are the official ones, votes that are
+	//amended afterwards should not quietly change them
+	flags.BoolVar(&cfg.FreezeResults, "freeze-results", cfg.FreezeResults, "Keep the results of a poll as they were when it closed")
+
+	//Voters who register themselves get an email with a signed link,
+	//they can not vote until they clicked it
+	flags.DurationVar(&cfg.VerificationTTL, "verification-ttl", cfg.VerificationTTL, "How long email verification links stay valid")
+
+	//A vote cast by mistake can be withdrawn for a little while with
+	//DELETE /voters/:id/polls/:pollid?undo=true
+	flags.DurationVar(&cfg.UndoWindow, "undo-window", cfg.UndoWindow, "How long after casting it a vote can be withdrawn, 0 to never")
+
+	//Turnout by age band in a small region can come down to a handful of
+	//people, whose votes should not be deducible from it
+	flags.IntVar(&cfg.AnalyticsMinGroup, "analytics-min-group", cfg.AnalyticsMinGroup, "Smallest group of voters analytics report on, 0 for every group")
+
+	//Dashboards chart how the results moved while a poll was open, a
+	//snapshot per interval is plenty for that
+	flags.DurationVar(&cfg.ResultsSnapshotInterval, "results-snapshot-interval", cfg.ResultsSnapshotInterval, "How often the results of open polls are recorded, 0 to disable")
+
+	//Logs are JSON by default so they can be shipped as is, use text
+	//when reading them in a terminal
+	flags.StringVar(&cfg.LogFormat, "log-format", cfg.LogFormat, "Log format: json or text")
+	flags.StringVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "Log level: debug, info, warn or error")
+	flags.Float64Var(&cfg.AccessLogSample, "access-log-sample", cfg.AccessLogSample, "Share of successful reads that are access logged, 0 to 1")
+
+	flags.StringVar(&cfg.Auth.Mode, "auth-mode", cfg.Auth.Mode, "auto: authenticate when keys are configured, required: refuse to start without them")
+	flags.BoolVar(&cfg.Auth.PublicReads, "public-reads", cfg.Auth.PublicReads, "Let reads through without credentials")
+
+	//Every client gets a bucket of requests per second, per API key when
+	//it sends a valid one and per IP otherwise.  Votes have their own,
+	//much smaller bucket
+	flags.Float64Var(&cfg.RateLimits.Requests.RPS, "rate-limit-rps", cfg.RateLimits.Requests.RPS, "Requests a second per client, 0 for no limit")
+	flags.IntVar(&cfg.RateLimits.Requests.Burst, "rate-limit-burst", cfg.RateLimits.Requests.Burst, "Requests a client can make at once")
+	flags.Float64Var(&cfg.RateLimits.Votes.RPS, "rate-limit-votes-rps", cfg.RateLimits.Votes.RPS, "Votes and check-ins a second per client, 0 for no limit")
+	flags.IntVar(&cfg.RateLimits.Votes.Burst, "rate-limit-votes-burst", cfg.RateLimits.Votes.Burst, "Votes and check-ins a client can record at once")
+
+	//A front-end served from another origin needs to be listed here
+	//before browsers let it call us
+	flags.Func("cors-allow-origins", "Comma separated origins browsers may call the api from, empty for any", func(value string) error {
+		cfg.CORS.AllowOrigins = splitList(value)
+		return nil
+	})
+
+	//With a certificate and key we serve HTTPS ourselves instead of
+	//relying on a proxy in front of us to terminate TLS.  tls-reload
+	//checks the files for a rotated certificate every so often
+	flags.StringVar(&cfg.TLS.CertFile, "tls-cert", cfg.TLS.CertFile, "TLS certificate file, serve HTTPS when set")
+	flags.StringVar(&cfg.TLS.KeyFile, "tls-key", cfg.TLS.KeyFile, "TLS private key file")
+	flags.DurationVar(&cfg.TLS.Reload, "tls-reload", cfg.TLS.Reload, "How often to check for a rotated certificate, 0 to never")
+
+	//One container can serve several independent elections, each in its
+	//own tenant.  Without an X-Tenant-ID header or a tenant subdomain a
+	//request uses the default tenant, which is where the data stored
+	//before tenants existed lives
+	flags.StringVar(&cfg.Tenants.Domain, "tenant-domain", cfg.Tenants.Domain, "Domain whose subdomains select the tenant")
+	flags.Func("tenants", "Comma separated tenant ids to accept, empty for any", func(value string) error {
+		cfg.Tenants.Allowed = splitList(value)
+		return nil
+	})
+
+	//The registration office keeps the authoritative roll, we pull it
+	//and create, update or deactivate voters to match
+	flags.StringVar(&cfg.Sync.URL, "sync-url", cfg.Sync.URL, "Upstream voter list to sync from (JSON or CSV), empty to not sync")
+	flags.DurationVar(&cfg.Sync.Interval, "sync-interval", cfg.Sync.Interval, "How often to sync from the upstream voter list")
+
+	//Voters who have not voted yet are emailed once, reminder-lead before
+	//the poll closes
+	flags.DurationVar(&cfg.Reminders.Lead, "reminder-lead", cfg.Reminders.Lead, "How long before a poll closes voters who have not voted are reminded, 0 to disable")
+	flags.DurationVar(&cfg.Reminders.Interval, "reminder-interval", cfg.Reminders.Interval, "How often to look for voters to remind")
+
+	//Every message goes out by email unless it is routed elsewhere, e.g.
+	//reminders by SMS as well or every registration to a Slack channel
+	flags.Func("notification-default", "Comma separated channels of events without a route", func(value string) error {
+		cfg.Notifications.Default = splitList(value)
+		return nil
+	})
+	flags.Func("notification-routes", "Channels per event, e.g. poll.reminder=email+sms,vote.receipt=email", func(value string) (err error) {
+		cfg.Notifications.Routes, err = parseRoutes(value)
+		return err
+	})
+
+	//Old vote history is dropped by the retention job, polls can be
+	//exempt.  Without an archive file what is dropped is gone
+	flags.IntVar(&cfg.Retention.MaxAgeDays, "retention-max-age-days", cfg.Retention.MaxAgeDays, "Drop vote history older than this many days, 0 to keep it")
+	flags.IntVar(&cfg.Retention.MaxEntries, "retention-max-entries", cfg.Retention.MaxEntries, "Vote history entries kept per voter, 0 to keep them all")
+	flags.StringVar(&cfg.Retention.ArchiveFile, "retention-archive-file", cfg.Retention.ArchiveFile, "File the dropped vote history is appended to first, JSON lines")
+
+	//The background jobs run on one replica at a time.  A cron schedule
+	//contains commas, so the schedules are separated by semicolons
+	flags.DurationVar(&cfg.Jobs.Interval, "jobs-interval", cfg.Jobs.Interval, "How often to look for background jobs that are due")
+	flags.Func("job-schedules", "Schedules per job, e.g. reindex=0 3 * * 0;cleanup=off", func(value string) (err error) {
+		cfg.Jobs.Schedules, err = parseJobSchedules(value)
+		return err
+	})
+
+	//Prefork starts one process per CPU all listening on the same port
+	//(SO_REUSEPORT), which helps read heavy loads on many-core hosts.
+	//Concurrency caps the open connections per process and the read
+	//buffer bounds the request headers, raise it for very long tokens.
+	//See cmd/bench to compare the modes on your hardware
+	flags.BoolVar(&cfg.Prefork, "prefork", cfg.Prefork, "Run one server process per CPU")
+	flags.IntVar(&cfg.Concurrency, "concurrency", cfg.Concurrency, "Maximum concurrent connections per process")
+	flags.IntVar(&cfg.ReadBufferSize, "read-buffer-size", cfg.ReadBufferSize, "Per connection read buffer size in bytes, limits the header size")
+
+	//Bodies are read into memory before a handler sees them, this keeps
+	//one client from using it all up.  A batch of voters is the largest
+	//body we expect
+	flags.IntVar(&cfg.BodyLimit, "body-limit", cfg.BodyLimit, "Largest request body in bytes")
+
+	//A hung redis call fails the request with a 504 after this long
+	//instead of holding on to a worker
+	flags.DurationVar(&cfg.RequestTimeout, "request-timeout", cfg.RequestTimeout, "Deadline of every request, 0 for none")
+
+	//Large voter lists with long histories compress very well, tiny
+	//responses are not worth the CPU
+	flags.IntVar(&cfg.CompressMinBytes, "compress-min-bytes", cfg.CompressMinBytes, "Smallest response to gzip/brotli compress, -1 to never compress")
+}
+
+// Validate reports every invalid setting at once
+func (cfg Config) Validate() error {
+	var errs []error
+	if cfg.Port == 0 || cfg.Port > 65535 {
+		errs = append(errs, fmt.Errorf("port %d is not between 1 and 65535", cfg.Port))
+	}
+	if cfg.Redis.Addr == "" {
+		errs = append(errs, errors.New("the redis url can not be empty"))
+	}
+	if _, err := logging.New(nil, cfg.LogFormat, cfg.LogLevel); err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.AccessLogSample < 0 || cfg.AccessLogSample > 1 {
+		errs = append(errs, fmt.Errorf("access log sample %g is not between 0 and 1", cfg.AccessLogSample))
+	}
+	if cfg.SchemaGuard != SchemaGuardRefuse && cfg.SchemaGuard != SchemaGuardReadOnly {
+		errs = append(errs, fmt.Errorf("invalid schema guard %q, use %s or %s", cfg.SchemaGuard, SchemaGuardRefuse, SchemaGuardReadOnly))
+	}
+	if cfg.Auth.Mode != AuthModeAuto && cfg.Auth.Mode != AuthModeRequired {
+		errs = append(errs, fmt.Errorf("invalid auth mode %q, use %s or %s", cfg.Auth.Mode, AuthModeAuto, AuthModeRequired))
+	}
+	if _, err := auth.NewAPIKeys(cfg.Auth.APIKeys, nil); err != nil {
+		errs = append(errs, err)
+	}
+	errs = append(errs, cfg.RateLimits.Requests.check("rate limit"), cfg.RateLimits.Votes.check("vote rate limit"))
+	//Sending credentials to any origin at all would let every site on the
+	//internet act as a logged in admin, fiber panics on it
+	if cfg.CORS.AllowCredentials && (len(cfg.CORS.AllowOrigins) == 0 || slices.Contains(cfg.CORS.AllowOrigins, "*")) {
+		errs = append(errs, errors.New("cors allow credentials needs the allowed origins to be listed"))
+	}
+	if cfg.CORS.MaxAge < 0 {
+		errs = append(errs, errors.New("the cors max age can not be negative"))
+	}
+	if (cfg.TLS.CertFile == "") != (cfg.TLS.KeyFile == "") {
+		errs = append(errs, errors.New("both a TLS certificate and key are needed to serve HTTPS"))
+	}
+	for _, tenant := range cfg.Tenants.Allowed {
+		if !db.ValidTenantId(tenant) {
+			errs = append(errs, fmt.Errorf("invalid tenant id %q", tenant))
+		}
+	}
+	if cfg.PollAPIURL != "" {
+		if u, err := url.Parse(cfg.PollAPIURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("invalid poll api url %q", cfg.PollAPIURL))
+		}
+	}
+	if cfg.Concurrency <= 0 {
+		errs = append(errs, errors.New("concurrency must be positive"))
+	}
+	if cfg.ReadBufferSize <= 0 {
+		errs = append(errs, errors.New("the read buffer size must be positive"))
+	}
+	if cfg.BodyLimit <= 0 {
+		errs = append(errs, errors.New("the body limit must be positive"))
+	}
+	if cfg.RequestTimeout < 0 {
+		errs = append(errs, errors.New("the request timeout can not be negative"))
+	}
+	if cfg.VerificationTTL <= 0 {
+		errs = append(errs, errors.New("the verification ttl must be positive"))
+	}
+	if cfg.UndoWindow < 0 {
+		errs = append(errs, errors.New("the undo window can not be negative"))
+	}
+	if cfg.AnalyticsMinGroup < 0 {
+		errs = append(errs, errors.New("the analytics min group can not be negative"))
+	}
+	if cfg.Sync.URL != "" && cfg.Sync.Interval <= 0 {
+		errs = append(errs, errors.New("the sync interval must be positive"))
+	}
+	if cfg.Reminders.Lead < 0 {
+		errs = append(errs, errors.New("the reminder lead can not be negative"))
+	}
+	if cfg.Reminders.Lead > 0 && cfg.Reminders.Interval <= 0 {
+		errs = append(errs, errors.New("the reminder interval must be positive"))
+	}
+	for _, channel := range cfg.Notifications.Default {
+		if !notifications.ValidChannel(channel) {
+			errs = append(errs, fmt.Errorf("invalid default notification channel %q", channel))
+		}
+	}
+	for event, channels := range cfg.Notifications.Routes {
+		for _, channel := range channels {
+			if !notifications.ValidChannel(channel) {
+				errs = append(errs, fmt.Errorf("event %q is routed to invalid notification channel %q", event, channel))
+			}
+		}
+	}
+	if port := cfg.Notifications.Channels.SMTP.Port; port < 0 || port > 65535 {
+		errs = append(errs, fmt.Errorf("smtp port %d is not between 1 and 65535", port))
+	}
+	if cfg.Capacity.MaxVoters < 0 || cfg.Capacity.MaxHistory < 0 || cfg.Capacity.MaxIndex < 0 {
+		errs = append(errs, errors.New("the capacity limits can not be negative"))
+	}
+	if cfg.Retention.MaxAgeDays < 0 {
+		errs = append(errs, errors.New("the retention max age can not be negative"))
+	}
+	if cfg.Retention.MaxEntries < 0 {
+		errs = append(errs, errors.New("the retention max entries can not be negative"))
+	}
+	if cfg.Jobs.Interval <= 0 {
+		errs = append(errs, errors.New("the jobs interval must be positive"))
+	}
+	for name := range cfg.Jobs.Schedules {
+		if !jobs.ValidName(name) {
+			errs = append(errs, fmt.Errorf("unknown job %q, use one of %s", name, strings.Join(jobs.Names, ", ")))
+		}
+	}
+	for _, name := range jobs.Names {
+		if _, err := jobs.ParseSchedule(cfg.JobSchedule(name)); err != nil {
+			errs = append(errs, fmt.Errorf("job %s: %w", name, err))
+		}
+	}
+	if cfg.ResultsSnapshotInterval < 0 {
+		errs = append(errs, errors.New("the results snapshot interval can not be negative"))
+	}
+	if err := validateFeatures(cfg.Features); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// Addr is the address to listen on, host:port
+func (cfg Config) Addr() string {
+	return fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
+}
+
+// LogValue is what is logged for a Config at startup, the settings an
+// operator checks first when something is off
+func (cfg Config) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("addr", cfg.Addr()),
+		slog.String("redis", cfg.Redis.Addr),
+		slog.String("logLevel", cfg.LogLevel),
+		slog.String("authMode", cfg.Auth.Mode),
+		slog.Bool("publicReads", cfg.Auth.PublicReads),
+		slog.Bool("tls", cfg.TLS.CertFile != ""),
+		slog.Bool("prefork", cfg.Prefork),
+		slog.Bool("legacyRoutes", cfg.LegacyRoutes),
+	)
+}
+
+// envReader reads environment variables into settings, collecting the
+// invalid ones so they are all reported together
+type envReader struct {
+	errs []error
+}
+
+func (e *envReader) string(name string, value *string) {
+	if v := os.Getenv(name); v != "" {
+		*value = v
+	}
+}
+
+func (e *envReader) parse(name string, parse func(string) error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return
+	}
+	if err := parse(v); err != nil {
+		e.errs = append(e.errs, fmt.Errorf("invalid %s %q", name, v))
+	}
+}
+
+func (e *envReader) list(name string, value *[]string) {
+	if v := os.Getenv(name); v != "" {
+		*value = splitList(v)
+	}
+}
+
+// splitList splits a comma separated list, dropping empty entries
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func (e *envReader) int(name string, value *int) {
+	e.parse(name, func(v string) (err error) {
+		*value, err = strconv.Atoi(v)
+		return err
+	})
+}
+
+func (e *envReader) int64(name string, value *int64) {
+	e.parse(name, func(v string) (err error) {
+		*value, err = strconv.ParseInt(v, 10, 64)
+		return err
+	})
+}
+
+func (e *envReader) uint(name string, value *uint) {
+	e.parse(name, func(v string) error {
+		n, err := strconv.ParseUint(v, 10, 0)
+		*value = uint(n)
+		return err
+	})
+}
+
+func (e *envReader) float(name string, value *float64) {
+	e.parse(name, func(v string) (err error) {
+		*value, err = strconv.ParseFloat(v, 64)
+		return err
+	})
+}
+
+func (e *envReader) date(name string, value *time.Time) {
+	e.parse(name, func(v string) (err error) {
+		*value, err = parseDate(v)
+		return err
+	})
+}
+
+// parseRoutes parses notification routes, a comma separated list of
+// event=channel+channel.  An event with nothing after the = is routed to
+// no channel
+func parseRoutes(value string) (map[string][]string, error) {
+	routes := map[string][]string{}
+	for _, route := range splitList(value) {
+		event, channels, found := strings.Cut(route, "=")
+		event = strings.TrimSpace(event)
+		if !found || event == "" {
+			return nil, fmt.Errorf("invalid route %q, use event=channel+channel", route)
+		}
+		routes[event] = []string{}
+		for _, channel := range strings.Split(channels, "+") {
+			if channel = strings.TrimSpace(channel); channel != "" {
+				routes[event] = append(routes[event], channel)
+			}
+		}
+	}
+	return routes, nil
+}
+
+// parseJobSchedules parses job schedules, a semicolon separated list of
+// job=schedule
+func parseJobSchedules(value string) (map[string]string, error) {
+	schedules := map[string]string{}
+	for _, entry := range strings.Split(value, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		name, schedule, found := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if !found || name == "" {
+			return nil, fmt.Errorf("invalid job schedule %q, use job=schedule", entry)
+		}
+		schedules[name] = strings.TrimSpace(schedule)
+	}
+	return schedules, nil
+}
+
+// parseDate accepts a date, 2027-01-31, or a full RFC 3339 time
+func parseDate(value string) (time.Time, error) {
+	if date, err := time.Parse(time.DateOnly, value); err == nil {
+		return date, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func (e *envReader) bool(name string, value *bool) {
+	e.parse(name, func(v string) (err error) {
+		*value, err = strconv.ParseBool(v)
+		return err
+	})
+}
+
+func (e *envReader) duration(name string, value *time.Duration) {
+	e.parse(name, func(v string) (err error) {
+		*value, err = time.ParseDuration(v)
+		return err
+	})
+}
@@ -0,0 +1,42 @@
+package config
+
+import "fmt"
+
+// Feature flags switch endpoints on and off.  Everything is on unless the
+// config file or the environment says otherwise, and an admin can flip a
+// flag at runtime with PUT /admin/features/:name, which wins over both
+const (
+	// FeatureDeleteAll is DELETE /voters
+	FeatureDeleteAll = "deleteAll"
+	// FeatureBulkImport is POST and DELETE /voters/batch
+	FeatureBulkImport = "bulkImport"
+	// FeatureWebhooks is registering, listing and deleting webhooks
+	FeatureWebhooks = "webhooks"
+)
+
+// Features maps every feature flag to the environment variable that sets
+// its default
+var Features = map[string]string{
+	FeatureDeleteAll:  "FEATURE_DELETE_ALL",
+	FeatureBulkImport: "FEATURE_BULK_IMPORT",
+	FeatureWebhooks:   "FEATURE_WEBHOOKS",
+}
+
+// DefaultFeatures returns every feature flag switched on
+func DefaultFeatures() map[string]bool {
+	features := make(map[string]bool, len(Features))
+	for name := range Features {
+		features[name] = true
+	}
+	return features
+}
+
+// validateFeatures reports flags in the config file that do not exist
+func validateFeatures(features map[string]bool) error {
+	for name := range features {
+		if _, known := Features[name]; !known {
+			return fmt.Errorf("unknown feature %q", name)
+		}
+	}
+	return nil
+}
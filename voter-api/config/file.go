@@ -0,0 +1,63 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFileEnv names the config file when -config is not passed
+const ConfigFileEnv = "CONFIG_FILE"
+
+// configFilePath finds the config file before the flags are parsed, the
+// file has to be read first so the flags can override it.  -config wins
+// over CONFIG_FILE
+func configFilePath(args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || name != "config" {
+			continue
+		}
+		if hasValue {
+			return value
+		}
+		if i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return os.Getenv(ConfigFileEnv)
+}
+
+// ApplyFile overrides the settings that are set in a YAML config file,
+// the one mounted into the container in a full deployment.  The keys are
+// the yaml tags of Config, see config.example.yaml.  An unknown key is an
+// error, a typo should not be silently ignored.  Secrets like
+// JWT_SIGNING_KEY stay in the environment so the file can be committed
+func (cfg *Config) ApplyFile(path string) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+	default:
+		return fmt.Errorf("config file %s: only YAML config files (.yaml, .yml) are supported", path)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/adllev/Voter-Container/voter-api/config"
+	"github.com/gofiber/fiber/v2/middleware/cors"
+)
+
+// corsConfig builds the CORS settings so a browser based front-end on
+// another origin can call the api directly, see config.CORSConfig.
+// Anything unset keeps the fiber default, which allows every origin
+func corsConfig(cfg config.CORSConfig) cors.Config {
+	corsCfg := cors.ConfigDefault
+
+	if len(cfg.AllowOrigins) > 0 {
+		corsCfg.AllowOrigins = strings.Join(cfg.AllowOrigins, ",")
+	}
+	if len(cfg.AllowMethods) > 0 {
+		corsCfg.AllowMethods = strings.ToUpper(strings.Join(cfg.AllowMethods, ","))
+	}
+	if len(cfg.AllowHeaders) > 0 {
+		corsCfg.AllowHeaders = strings.Join(cfg.AllowHeaders, ",")
+	}
+
+	//Let browsers read the headers our clients need, by default only the
+	//CORS safelisted ones are visible to scripts
+	corsCfg.ExposeHeaders = "Deprecation,ETag,Idempotent-Replayed,Link,Retry-After,Sunset,X-Replay-Id,X-Total-Count,X-Vote-Receipt"
+
+	//Credentials with any origin at all are refused by config.Validate
+	corsCfg.AllowCredentials = cfg.AllowCredentials
+	corsCfg.MaxAge = cfg.MaxAge
+
+	return corsCfg
+}
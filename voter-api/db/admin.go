@@ -0,0 +1,156 @@
+package db
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StoreStats is a summary of the redis server the voters are stored in,
+// picked out of INFO
+type StoreStats struct {
+	RedisVersion     string `json:"redisVersion"`
+	UptimeSeconds    int64  `json:"uptimeSeconds"`
+	ConnectedClients int64  `json:"connectedClients"`
+	UsedMemory       int64  `json:"usedMemoryBytes"`
+	UsedMemoryPeak   int64  `json:"usedMemoryPeakBytes"`
+	Keys             int64  `json:"keys"`
+	LastSave         int64  `json:"lastSaveUnix"`
+	SaveInProgress   bool   `json:"saveInProgress"`
+}
+
+// OrphanCleanup counts the index entries CleanupOrphanedIndexes removed
+type OrphanCleanup struct {
+	VoterIndex int `json:"voterIndex"`
+	EmailIndex int `json:"emailIndex"`
+}
+
+// parseInfo turns the output of INFO into a map, skipping the # section
+// headers and blank lines
+func parseInfo(info string) map[string]string {
+	fields := map[string]string{}
+	for _, line := range strings.Split(info, "\r\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if name, value, found := strings.Cut(line, ":"); found {
+			fields[name] = value
+		}
+	}
+	return fields
+}
+
+// GetStoreStats returns the server, memory and persistence stats of redis
+func (vl *Voter) GetStoreStats() (stats StoreStats, err error) {
+	defer observe("GetStoreStats", time.Now(), &err)
+
+	info, err := vl.client.Info(vl.context, "server", "clients", "memory", "persistence").Result()
+	if err != nil {
+		return StoreStats{}, err
+	}
+	keys, err := vl.client.DBSize(vl.context).Result()
+	if err != nil {
+		return StoreStats{}, err
+	}
+
+	fields := parseInfo(info)
+	number := func(name string) int64 {
+		n, _ := strconv.ParseInt(fields[name], 10, 64)
+		return n
+	}
+
+	return StoreStats{
+		RedisVersion:     fields["redis_version"],
+		UptimeSeconds:    number("uptime_in_seconds"),
+		ConnectedClients: number("connected_clients"),
+		UsedMemory:       number("used_memory"),
+		UsedMemoryPeak:   number("used_memory_peak"),
+		Keys:             keys,
+		LastSave:         number("rdb_last_save_time"),
+		SaveInProgress:   fields["rdb_bgsave_in_progress"] == "1",
+	}, nil
+}
+
+// CountKeysByPrefix counts every key in redis by the part of its name
+// before the first colon, voter:1 counts as voter.  Keys without a colon
+// are counted under their whole name
+func (vl *Voter) CountKeysByPrefix() (counts map[string]int, err error) {
+	defer observe("CountKeysByPrefix", time.Now(), &err)
+
+	keyList, err := vl.scanKeys("*")
+	if err != nil {
+		return nil, err
+	}
+
+	counts = map[string]int{}
+	for _, key := range keyList {
+		prefix, _, _ := strings.Cut(key, ":")
+		counts[prefix]++
+	}
+	return counts, nil
+}
+
+// CleanupOrphanedIndexes removes index entries that point at voters that
+// no longer exist, or, for the email index, no longer have that email.
+// Unlike RebuildIndexes it never drops the indexes, so reads keep working
+// while it runs
+func (vl *Voter) CleanupOrphanedIndexes() (cleanup OrphanCleanup, err error) {
+	defer observe("CleanupOrphanedIndexes", time.Now(), &err)
+
+	ids, err := vl.client.ZRange(vl.context, vl.key(VoterIndexKey), 0, -1).Result()
+	if err != nil {
+		return OrphanCleanup{}, err
+	}
+	for _, id := range ids {
+		exists, err := vl.client.Exists(vl.context, vl.key(RedisKeyPrefix+id)).Result()
+		if err != nil {
+			return cleanup, err
+		}
+		if exists == 0 {
+			if err := vl.client.ZRem(vl.context, vl.key(VoterIndexKey), id).Err(); err != nil {
+				return cleanup, err
+			}
+			cleanup.VoterIndex++
+		}
+	}
+
+	emailKeys, err := vl.scanKeys(vl.key(EmailIndexKeyPrefix + "*"))
+	if err != nil {
+		return cleanup, err
+	}
+	for _, emailKey := range emailKeys {
+		members, err := vl.client.SMembers(vl.context, emailKey).Result()
+		if err != nil {
+			return cleanup, err
+		}
+		for _, id := range members {
+			var voterItem VoterItem
+			err := vl.getVoterFromRedis(vl.key(RedisKeyPrefix+id), &voterItem)
+			if err != nil && !errors.Is(err, ErrNotFound) {
+				return cleanup, err
+			}
+			if err == nil && vl.emailIndexKey(voterItem.Email) == emailKey {
+				continue
+			}
+			if err := vl.client.SRem(vl.context, emailKey, id).Err(); err != nil {
+				return cleanup, err
+			}
+			cleanup.EmailIndex++
+		}
+	}
+
+	return cleanup, nil
+}
+
+// TriggerSnapshot asks redis to write a snapshot to disk in the
+// background.  It returns ErrConflict if one is already being written
+func (vl *Voter) TriggerSnapshot() (err error) {
+	defer observe("TriggerSnapshot", time.Now(), &err)
+
+	err = vl.client.BgSave(vl.context).Err()
+	if err != nil && strings.Contains(err.Error(), "already in progress") {
+		return ErrConflict
+	}
+	return err
+}
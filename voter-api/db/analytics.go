@@ -0,0 +1,126 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// The demographics turnout can be broken down by.  DimensionRegistered
+// groups the voters by the month they registered in, like 2026-10
+const (
+	DimensionAgeBand    = "ageBand"
+	DimensionRegion     = "region"
+	DimensionRegistered = "registered"
+)
+
+// UnknownGroup is the group of the voters that did not give a demographic
+const UnknownGroup = "unknown"
+
+// ValidDimension reports whether turnout can be broken down by dimension
+func ValidDimension(dimension string) bool {
+	switch dimension {
+	case DimensionAgeBand, DimensionRegion, DimensionRegistered:
+		return true
+	}
+	return false
+}
+
+// TurnoutGroup is the turnout of the voters sharing one value of a
+// demographic.  A group with fewer voters than the anonymization minimum
+// is Suppressed, it is listed but its counts are left at zero so no small
+// group of people can be singled out
+type TurnoutGroup struct {
+	Value      string  `json:"value"`
+	Voters     int     `json:"voters"`
+	Voted      int     `json:"voted"`
+	Turnout    float64 `json:"turnout"`
+	Suppressed bool    `json:"suppressed,omitempty"`
+}
+
+// TurnoutBreakdown is the turnout per group of one demographic, in one
+// poll or, without a PollId, in any poll
+type TurnoutBreakdown struct {
+	Dimension string         `json:"dimension"`
+	PollId    int            `json:"pollId,omitempty"`
+	MinGroup  int            `json:"minGroup"`
+	Groups    []TurnoutGroup `json:"groups"`
+}
+
+// demographic is the value of dimension for a voter
+func (v VoterItem) demographic(dimension string) string {
+	value := ""
+	switch dimension {
+	case DimensionAgeBand:
+		value = v.AgeBand
+	case DimensionRegion:
+		value = v.Region
+	case DimensionRegistered:
+		if v.RegisteredAt != nil {
+			value = v.RegisteredAt.UTC().Format("2006-01")
+		}
+	}
+	if value == "" {
+		return UnknownGroup
+	}
+	return value
+}
+
+// GetTurnoutBreakdown counts per group of dimension how many voters there
+// are and how many of them voted in pollId, or at all when pollId is 0.
+// Groups with fewer than minGroup voters are suppressed, 0 reports all
+func (vl *Voter) GetTurnoutBreakdown(dimension string, pollId int, minGroup int) (breakdown TurnoutBreakdown, err error) {
+	defer observe("GetTurnoutBreakdown", time.Now(), &err)
+
+	if !ValidDimension(dimension) {
+		return TurnoutBreakdown{}, fmt.Errorf("%w: unknown dimension %q", ErrInvalidQuery, dimension)
+	}
+
+	voterList, err := vl.GetAllVoters()
+	if err != nil {
+		return TurnoutBreakdown{}, err
+	}
+
+	groups := make(map[string]*TurnoutGroup)
+	for _, voterItem := range voterList {
+		value := voterItem.demographic(dimension)
+		group, found := groups[value]
+		if !found {
+			group = &TurnoutGroup{Value: value}
+			groups[value] = group
+		}
+		group.Voters++
+		if votedIn(voterItem.VoteHistory, pollId) {
+			group.Voted++
+		}
+	}
+
+	breakdown = TurnoutBreakdown{Dimension: dimension, PollId: pollId, MinGroup: minGroup, Groups: []TurnoutGroup{}}
+	for _, group := range groups {
+		if group.Voters < minGroup {
+			breakdown.Groups = append(breakdown.Groups, TurnoutGroup{Value: group.Value, Suppressed: true})
+			continue
+		}
+		group.Turnout = float64(group.Voted) / float64(group.Voters)
+		breakdown.Groups = append(breakdown.Groups, *group)
+	}
+	sort.Slice(breakdown.Groups, func(i, j int) bool {
+		return breakdown.Groups[i].Value < breakdown.Groups[j].Value
+	})
+
+	return breakdown, nil
+}
+
+// votedIn reports whether a history has a vote in pollId, in any poll
+// when pollId is 0
+func votedIn(history []VoterHistory, pollId int) bool {
+	if pollId == 0 {
+		return len(history) > 0
+	}
+	for _, vh := range history {
+		if vh.PollId == pollId {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package db
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+const (
+	// AnomalyKey is the redis list holding detected anomalies, newest first
+	AnomalyKey = "anomalies"
+	// AnomalyMaxLen is how many anomalies are kept in the list
+	AnomalyMaxLen = 10000
+)
+
+// Anomaly types
+const (
+	AnomalyVotedOnlineAndInPerson = "voted_online_and_in_person"
+)
+
+// Anomaly is something suspicious the system noticed that a person needs
+// to look at, for example a voter who voted online and then checked in
+// at a polling place for the same poll
+type Anomaly struct {
+	Id         string    `json:"id"`
+	Type       string    `json:"type"`
+	VoterId    int       `json:"voterId"`
+	PollId     int       `json:"pollId,omitempty"`
+	Source     string    `json:"source"`
+	Detail     string    `json:"detail"`
+	DetectedAt time.Time `json:"detectedAt"`
+}
+
+// AddAnomaly records a new anomaly
+func (vl *Voter) AddAnomaly(anomaly Anomaly) (err error) {
+	defer observe("AddAnomaly", time.Now(), &err)
+
+	if anomaly.Id == "" {
+		anomaly.Id = utils.UUIDv4()
+	}
+	if anomaly.DetectedAt.IsZero() {
+		anomaly.DetectedAt = time.Now()
+	}
+
+	anomalyBytes, err := json.Marshal(anomaly)
+	if err != nil {
+		return err
+	}
+
+	pipe := vl.client.TxPipeline()
+	pipe.LPush(vl.context, vl.key(AnomalyKey), anomalyBytes)
+	pipe.LTrim(vl.context, vl.key(AnomalyKey), 0, AnomalyMaxLen-1)
+	_, err = pipe.Exec(vl.context)
+	return err
+}
+
+// GetAnomalies returns the most recent anomalies, newest first
+func (vl *Voter) GetAnomalies(limit int) (anomalyList []Anomaly, err error) {
+	defer observe("GetAnomalies", time.Now(), &err)
+
+	values, err := vl.client.LRange(vl.context, vl.key(AnomalyKey), 0, int64(limit-1)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, value := range values {
+		var anomaly Anomaly
+		if err := json.Unmarshal([]byte(value), &anomaly); err != nil {
+			return nil, err
+		}
+		anomalyList = append(anomalyList, anomaly)
+	}
+
+	return anomalyList, nil
+}
@@ -0,0 +1,96 @@
+package db
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// APIKeyHashKey is the redis hash of API keys issued at runtime.  The hash
+// field is the SHA-256 of the key, the key itself is never stored
+const APIKeyHashKey = "apikeys"
+
+// APIKey is an API key another service uses to call us.  Scopes limit
+// what it can do, see the auth package for the scope names, and Tenant
+// where, "" being the default tenant.  The keys of every tenant are kept
+// together, a key is looked up before its tenant is known
+type APIKey struct {
+	Id        string    `json:"id"`
+	Name      string    `json:"name"`
+	Hash      string    `json:"hash"`
+	Scopes    []string  `json:"scopes"`
+	Tenant    string    `json:"tenant,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// AddAPIKey stores an issued API key, apiKey.Hash must already be set
+func (vl *Voter) AddAPIKey(apiKey APIKey) (err error) {
+	defer observe("AddAPIKey", time.Now(), &err)
+
+	apiKeyBytes, err := json.Marshal(apiKey)
+	if err != nil {
+		return err
+	}
+
+	created, err := vl.client.HSetNX(vl.context, APIKeyHashKey, apiKey.Hash, apiKeyBytes).Result()
+	if err != nil {
+		return err
+	}
+	if !created {
+		return ErrAlreadyExists
+	}
+	return nil
+}
+
+// GetAPIKey looks up an issued API key by the hash of the key
+func (vl *Voter) GetAPIKey(hash string) (apiKey APIKey, err error) {
+	defer observe("GetAPIKey", time.Now(), &err)
+
+	value, err := vl.client.HGet(vl.context, APIKeyHashKey, hash).Result()
+	if err != nil {
+		if isRedisNilError(err) {
+			return APIKey{}, ErrNotFound
+		}
+		return APIKey{}, err
+	}
+
+	err = json.Unmarshal([]byte(value), &apiKey)
+	return apiKey, err
+}
+
+// GetAllAPIKeys returns every issued API key
+func (vl *Voter) GetAllAPIKeys() (apiKeyList []APIKey, err error) {
+	defer observe("GetAllAPIKeys", time.Now(), &err)
+
+	entries, err := vl.client.HGetAll(vl.context, APIKeyHashKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, value := range entries {
+		var apiKey APIKey
+		if err := json.Unmarshal([]byte(value), &apiKey); err != nil {
+			return nil, err
+		}
+		apiKeyList = append(apiKeyList, apiKey)
+	}
+
+	return apiKeyList, nil
+}
+
+// DeleteAPIKey revokes an issued API key by its id, the key of another
+// tenant is ErrNotFound
+func (vl *Voter) DeleteAPIKey(id string, tenant string) (err error) {
+	defer observe("DeleteAPIKey", time.Now(), &err)
+
+	apiKeyList, err := vl.GetAllAPIKeys()
+	if err != nil {
+		return err
+	}
+
+	for _, apiKey := range apiKeyList {
+		if apiKey.Id == id && apiKey.Tenant == tenant {
+			return vl.client.HDel(vl.context, APIKeyHashKey, apiKey.Hash).Err()
+		}
+	}
+	return ErrNotFound
+}
@@ -0,0 +1,62 @@
+package db
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	// AuditLogKey is the redis list holding the audit log, newest first
+	AuditLogKey = "audit:log"
+	// AuditLogMaxLen is how many audit entries are kept
+	AuditLogMaxLen = 100000
+)
+
+// AuditEntry records an administrative action taken against a voter
+type AuditEntry struct {
+	Time    time.Time `json:"time"`
+	Action  string    `json:"action"`
+	VoterId int       `json:"voterId,omitempty"`
+	Actor   string    `json:"actor"`
+	Detail  string    `json:"detail,omitempty"`
+}
+
+// AppendAudit adds an entry to the audit log
+func (vl *Voter) AppendAudit(entry AuditEntry) (err error) {
+	defer observe("AppendAudit", time.Now(), &err)
+
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	entryBytes, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	pipe := vl.client.TxPipeline()
+	pipe.LPush(vl.context, vl.key(AuditLogKey), entryBytes)
+	pipe.LTrim(vl.context, vl.key(AuditLogKey), 0, AuditLogMaxLen-1)
+	_, err = pipe.Exec(vl.context)
+	return err
+}
+
+// GetAuditLog returns the most recent audit entries, newest first
+func (vl *Voter) GetAuditLog(limit int) (auditLog []AuditEntry, err error) {
+	defer observe("GetAuditLog", time.Now(), &err)
+
+	values, err := vl.client.LRange(vl.context, vl.key(AuditLogKey), 0, int64(limit-1)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, value := range values {
+		var entry AuditEntry
+		if err := json.Unmarshal([]byte(value), &entry); err != nil {
+			return nil, err
+		}
+		auditLog = append(auditLog, entry)
+	}
+
+	return auditLog, nil
+}
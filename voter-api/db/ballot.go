@@ -0,0 +1,94 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// BallotTokensKeyPrefix is the prefix of the sets of voter tokens that cast
+// a secret ballot, ballots:<pollId>
+const BallotTokensKeyPrefix = "ballots:"
+
+func (vl *Voter) ballotTokensKey(pollId int) string {
+	return vl.key(fmt.Sprintf("%s%d", BallotTokensKeyPrefix, pollId))
+}
+
+// AddAnonymousVote records a secret ballot in an Anonymous poll.  The
+// identity and the ballot are stored apart: the voter gets an Anonymous
+// history entry without the vote id or choice, and the vote is stored
+// with voterToken, a blinded token the caller derives from the voter and
+// poll, instead of the voter id.  Both are dated to the day only, so the
+// times can not pair them up either.  The token is also kept per poll, a
+// voter that already voted is ErrConflict even if their history was lost.
+// Once the token is kept the steps run to the end even when the request
+// is cancelled, see detached
+func (vl *Voter) AddAnonymousVote(vote Vote, voterToken string) (err error) {
+	defer observe("AddAnonymousVote", time.Now(), &err)
+
+	if voterToken == "" {
+		return fmt.Errorf("%w: a secret ballot needs a voter token", ErrInvalid)
+	}
+	voterId := vote.VoterId
+	day := time.Now().UTC().Truncate(24 * time.Hour)
+
+	tokensKey := vl.ballotTokensKey(vote.PollId)
+	added, err := vl.client.SAdd(vl.context, tokensKey, voterToken).Result()
+	if err != nil {
+		return err
+	}
+	if added == 0 {
+		return fmt.Errorf("%w: voter %d already voted in poll %d", ErrConflict, voterId, vote.PollId)
+	}
+	rest := vl.detached()
+	//Undoes the token and the history entry when a later step fails
+	rollback := func(marked bool) {
+		if marked {
+			if err := rest.DeleteVoterPoll(voterId, vote.PollId); err != nil {
+				vl.log.Error("Error removing secret ballot marker", "voterId", voterId, "pollId", vote.PollId, "error", err)
+			}
+		}
+		if err := rest.client.SRem(rest.context, tokensKey, voterToken).Err(); err != nil {
+			vl.log.Error("Error removing ballot token", "pollId", vote.PollId, "error", err)
+		}
+	}
+
+	marker := VoterHistory{PollId: vote.PollId, Anonymous: true, VoteDate: day}
+	if err := rest.AddVoterPoll(marker, voterId); err != nil {
+		rollback(false)
+		return err
+	}
+
+	//The weight has to go with the ballot to be counted, in a secret
+	//ballot it is all that is left of the voter
+	if vote.Weight, err = rest.voterWeight(voterId); err != nil {
+		rollback(true)
+		return err
+	}
+	vote.VoterId = 0
+	vote.VoterToken = voterToken
+	vote.VoteDate = day
+	voteBytes, err := json.Marshal(vote)
+	if err != nil {
+		rollback(true)
+		return err
+	}
+	err = rest.client.Do(rest.context, "JSON.SET", rest.voteKey(vote.VoteId), ".", string(voteBytes), "NX").Err()
+	if isRedisNilError(err) {
+		err = ErrAlreadyExists
+	}
+	if err != nil {
+		rollback(true)
+		return err
+	}
+
+	err = rest.client.ZAdd(rest.context, rest.key(VoteIndexKey), redis.Z{Score: float64(vote.VoteId), Member: strconv.Itoa(vote.VoteId)}).Err()
+	if err != nil {
+		return err
+	}
+	rest.clearResults(vote.PollId, false)
+	return nil
+}
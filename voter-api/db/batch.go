@@ -0,0 +1,156 @@
+package db
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// AddVoters adds many voters with a single round trip to redis, for bulk
+// onboarding.  Each voter is written with JSON.SET NX so an existing id,
+// or the same id twice in the batch, is left alone.  The returned slice
+// has one entry per voter: nil when it was created, ErrAlreadyExists when
+// the id was taken, ErrConflict when the history has a poll twice, ErrInvalid for a
+// precinct that does not exist, or the
+// redis error for that voter
+func (vl *Voter) AddVoters(voterItems []VoterItem) (results []error, err error) {
+	defer observe("AddVoters", time.Now(), &err)
+
+	//Not a transaction, one voter failing must not roll back the others
+	pipe := vl.client.Pipeline()
+	cmds := make([]*redis.Cmd, len(voterItems))
+	results = make([]error, len(voterItems))
+	for i := range voterItems {
+		if err := checkHistory(voterItems[i].VoteHistory); err != nil {
+			results[i] = err
+			continue
+		}
+		if err := vl.checkPrecincts(voterItems[i].PrecinctId); err != nil {
+			results[i] = err
+			continue
+		}
+
+		newVoterDefaults(&voterItems[i])
+		keepServerFields(voterItems[i].VoteHistory, nil)
+		chainHistory(&voterItems[i])
+
+		voterBytes, err := json.Marshal(voterItems[i])
+		if err != nil {
+			return nil, err
+		}
+		cmds[i] = pipe.Do(vl.context, "JSON.SET", vl.redisKeyFromId(voterItems[i].VoterId), ".", string(voterBytes), "NX")
+	}
+	//Exec only reports the first failure, every command carries its own
+	//error and those are looked at below
+	_, _ = pipe.Exec(vl.context)
+
+	for i, cmd := range cmds {
+		if cmd == nil {
+			continue
+		}
+		switch err := cmd.Err(); {
+		case isRedisNilError(err):
+			results[i] = ErrAlreadyExists
+		case err != nil:
+			results[i] = err
+		default:
+			vl.indexVoter(voterItems[i])
+			vl.emit(EventVoterCreated, voterItems[i].VoterId, 0)
+		}
+	}
+
+	return results, nil
+}
+
+// BatchDeleteResult lists what DeleteVoters did with each requested id
+type BatchDeleteResult struct {
+	Deleted  []int `json:"deleted"`
+	NotFound []int `json:"notFound"`
+	Frozen   []int `json:"frozen"`
+}
+
+// DeleteVoters deletes the voters with the given ids in a few round trips.
+// Ids that do not exist are reported rather than failing the batch, and
+// frozen voters are kept, like DeleteAll does
+func (vl *Voter) DeleteVoters(ids []int) (result BatchDeleteResult, err error) {
+	defer observe("DeleteVoters", time.Now(), &err)
+
+	result = BatchDeleteResult{Deleted: []int{}, NotFound: []int{}, Frozen: []int{}}
+
+	seen := make(map[int]bool)
+	var uniqueIds []int
+	members := make([]any, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			uniqueIds = append(uniqueIds, id)
+			members = append(members, strconv.Itoa(id))
+		}
+	}
+	if len(uniqueIds) == 0 {
+		return result, nil
+	}
+
+	frozen, err := vl.client.SMIsMember(vl.context, vl.key(FrozenVotersKey), members...).Result()
+	if err != nil {
+		return BatchDeleteResult{}, err
+	}
+
+	//Read the voters first, their emails are needed to update the index
+	pipe := vl.client.Pipeline()
+	cmds := make(map[int]*redis.Cmd)
+	for i, id := range uniqueIds {
+		if frozen[i] {
+			result.Frozen = append(result.Frozen, id)
+			continue
+		}
+		cmds[id] = pipe.Do(vl.context, "JSON.GET", vl.redisKeyFromId(id), ".")
+	}
+	if len(cmds) == 0 {
+		return result, nil
+	}
+	if _, err := pipe.Exec(vl.context); err != nil && !isRedisNilError(err) {
+		return BatchDeleteResult{}, err
+	}
+
+	var voterItems []VoterItem
+	var keys []string
+	for _, id := range uniqueIds {
+		cmd, ok := cmds[id]
+		if !ok {
+			continue
+		}
+		value, err := cmd.Text()
+		if isRedisNilError(err) {
+			result.NotFound = append(result.NotFound, id)
+			continue
+		}
+		if err != nil {
+			return BatchDeleteResult{}, err
+		}
+
+		var voterItem VoterItem
+		if err := json.Unmarshal([]byte(value), &voterItem); err != nil {
+			return BatchDeleteResult{}, err
+		}
+		voterItems = append(voterItems, voterItem)
+		keys = append(keys, vl.redisKeyFromId(id))
+	}
+	if len(keys) == 0 {
+		return result, nil
+	}
+
+	if err := vl.client.Del(vl.context, keys...).Err(); err != nil {
+		return BatchDeleteResult{}, err
+	}
+
+	for _, voterItem := range voterItems {
+		vl.unindexVoter(voterItem)
+		vl.emit(EventVoterDeleted, voterItem.VoterId, 0)
+		result.Deleted = append(result.Deleted, voterItem.VoterId)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,118 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Cardinality series, each one is a RedisTimeSeries key (redis-stack ships
+// with the time series module) holding one sample per day
+const (
+	CardinalityVoters  = "voters"
+	CardinalityHistory = "history"
+	CardinalityIndex   = "index"
+
+	cardinalityKeyPrefix = "ts:cardinality:"
+)
+
+// CardinalitySeries lists every series SampleCardinality records
+var CardinalitySeries = []string{CardinalityVoters, CardinalityHistory, CardinalityIndex}
+
+// CardinalitySample is one point of a cardinality series
+type CardinalitySample struct {
+	Time  time.Time `json:"time"`
+	Value int64     `json:"value"`
+}
+
+// SampleCardinality counts voters, vote history entries and voter index
+// entries and adds one sample of each to its time series
+func (vl *Voter) SampleCardinality() (counts map[string]int64, err error) {
+	defer observe("SampleCardinality", time.Now(), &err)
+
+	keyList, err := vl.getAllKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	//The history length of every voter is read in a single pipeline
+	pipe := vl.client.Pipeline()
+	lengths := make([]*redis.Cmd, len(keyList))
+	for i, key := range keyList {
+		lengths[i] = pipe.Do(vl.context, "JSON.ARRLEN", key, ".voteHistory")
+	}
+	indexCard := pipe.ZCard(vl.context, vl.key(VoterIndexKey))
+	if _, err := pipe.Exec(vl.context); err != nil && !isRedisNilError(err) {
+		return nil, err
+	}
+
+	var historyEntries int64
+	for _, length := range lengths {
+		//voteHistory is null for voters that never voted, ARRLEN
+		//returns an error for those which just means zero
+		if n, err := length.Int64(); err == nil {
+			historyEntries += n
+		}
+	}
+
+	counts = map[string]int64{
+		CardinalityVoters:  int64(len(keyList)),
+		CardinalityHistory: historyEntries,
+		CardinalityIndex:   indexCard.Val(),
+	}
+
+	now := time.Now().UnixMilli()
+	for series, value := range counts {
+		err := vl.client.Do(vl.context, "TS.ADD", cardinalityKeyPrefix+series, now, value,
+			"ON_DUPLICATE", "LAST").Err()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return counts, nil
+}
+
+// GetCardinalitySeries returns the samples of one series recorded since
+// the provided time, oldest first
+func (vl *Voter) GetCardinalitySeries(series string, since time.Time) (samples []CardinalitySample, err error) {
+	defer observe("GetCardinalitySeries", time.Now(), &err)
+
+	result, err := vl.client.Do(vl.context, "TS.RANGE", cardinalityKeyPrefix+series,
+		since.UnixMilli(), "+").Slice()
+	if err != nil {
+		//No samples have been taken yet
+		if isRedisNilError(err) || isMissingKeyError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	for _, point := range result {
+		pair, ok := point.([]interface{})
+		if !ok || len(pair) != 2 {
+			return nil, fmt.Errorf("unexpected TS.RANGE reply %v", point)
+		}
+
+		timestamp, _ := pair[0].(int64)
+		value, err := strconv.ParseFloat(fmt.Sprint(pair[1]), 64)
+		if err != nil {
+			return nil, err
+		}
+
+		samples = append(samples, CardinalitySample{
+			Time:  time.UnixMilli(timestamp),
+			Value: int64(value),
+		})
+	}
+
+	return samples, nil
+}
+
+// isMissingKeyError reports the error the time series module returns for
+// a series that was never created
+func isMissingKeyError(err error) bool {
+	return err != nil && err.Error() == "ERR TSDB: the key does not exist"
+}
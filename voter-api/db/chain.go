@@ -0,0 +1,172 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Reasons a history entry fails VerifyHistory
+const (
+	ChainBrokenLink = "prev_hash_mismatch"
+	ChainBadHash    = "hash_mismatch"
+	ChainBadHead    = "history_hash_mismatch"
+)
+
+// ChainBreak is a place where the hash chain of a vote history does not
+// hold.  Index is the history entry, -1 for the HistoryHash of the voter
+type ChainBreak struct {
+	Index  int    `json:"index"`
+	PollId int    `json:"pollId,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// ChainVerification is the outcome of VerifyHistory, Valid when there are
+// no Breaks
+type ChainVerification struct {
+	VoterId int          `json:"voterId"`
+	Entries int          `json:"entries"`
+	Valid   bool         `json:"valid"`
+	Breaks  []ChainBreak `json:"breaks,omitempty"`
+}
+
+// historyEntryHash is the hex SHA-256 over the hash of the entry before it
+// and the fields of the entry.  The voter id is part of it, so an entry
+// copied over from another voter does not verify either, and so are the
+// amendments, so one taken out of the record does not
+func historyEntryHash(prevHash string, voterId int, history VoterHistory) string {
+	fields := fmt.Sprintf("%s|%d|%d|%d|%d|%v|%s", prevHash, voterId, history.PollId, history.VoteId,
+		history.OptionId, history.Ranking, history.VoteDate.UTC().Format(time.RFC3339Nano))
+	if history.Anonymous {
+		fields += "|anonymous"
+	}
+	if history.Provisional {
+		fields += "|provisional"
+	}
+	for _, amendment := range history.Amendments {
+		fields += fmt.Sprintf("|amended:%d:%d:%v:%s:%s", amendment.Old.VoteId, amendment.Old.OptionId,
+			amendment.Old.Ranking, amendment.AmendedAt.UTC().Format(time.RFC3339Nano), amendment.Actor)
+	}
+	sum := sha256.Sum256([]byte(fields))
+	return hex.EncodeToString(sum[:])
+}
+
+// chainHistory links the history entries of a voter: every entry gets the
+// hash of the one before it and its own, the voter the hash of the last
+// one.  Every write of a history goes through it, so a change made to
+// redis behind our back shows up in VerifyHistory
+func chainHistory(voterItem *VoterItem) {
+	prevHash := ""
+	for i := range voterItem.VoteHistory {
+		voterItem.VoteHistory[i].PrevHash = prevHash
+		voterItem.VoteHistory[i].Hash = historyEntryHash(prevHash, voterItem.VoterId, voterItem.VoteHistory[i])
+		prevHash = voterItem.VoteHistory[i].Hash
+	}
+	voterItem.HistoryHash = prevHash
+}
+
+// verifyChain recomputes the chain of a stored voter and reports every
+// entry that does not match
+func verifyChain(voterItem VoterItem) ChainVerification {
+	result := ChainVerification{VoterId: voterItem.VoterId, Entries: len(voterItem.VoteHistory)}
+	prevHash := ""
+	for i, history := range voterItem.VoteHistory {
+		if history.PrevHash != prevHash {
+			result.Breaks = append(result.Breaks, ChainBreak{Index: i, PollId: history.PollId, Reason: ChainBrokenLink})
+		}
+		if history.Hash != historyEntryHash(history.PrevHash, voterItem.VoterId, history) {
+			result.Breaks = append(result.Breaks, ChainBreak{Index: i, PollId: history.PollId, Reason: ChainBadHash})
+		}
+		prevHash = history.Hash
+	}
+	if voterItem.HistoryHash != prevHash {
+		result.Breaks = append(result.Breaks, ChainBreak{Index: -1, Reason: ChainBadHead})
+	}
+	result.Valid = len(result.Breaks) == 0
+	return result
+}
+
+// VerifyHistory checks the hash chain of the vote history of a voter, an
+// entry edited, removed or reordered in redis without going through this
+// package breaks it
+func (vl *Voter) VerifyHistory(voterId int) (result ChainVerification, err error) {
+	defer observe("VerifyHistory", time.Now(), &err)
+
+	voterItem, err := vl.GetVoter(voterId)
+	if err != nil {
+		return ChainVerification{}, err
+	}
+	return verifyChain(voterItem), nil
+}
+
+// VerifyAllHistories checks the chain of every voter and returns the ones
+// that do not verify, ordered by voter id, and how many were checked
+func (vl *Voter) VerifyAllHistories() (broken []ChainVerification, checked int, err error) {
+	defer observe("VerifyAllHistories", time.Now(), &err)
+
+	voterList, err := vl.GetAllVoters()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	broken = []ChainVerification{}
+	for _, voterItem := range voterList {
+		if result := verifyChain(voterItem); !result.Valid {
+			broken = append(broken, result)
+		}
+	}
+	sort.Slice(broken, func(i, j int) bool { return broken[i].VoterId < broken[j].VoterId })
+	return broken, len(voterList), nil
+}
+
+// migrateHistoryChains chains the histories written before schema version
+// 3, they are trusted as they are
+func (vl *Voter) migrateHistoryChains() (count int, err error) {
+	tenants, err := vl.storedTenants()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, tenant := range tenants {
+		scoped := vl.WithTenant(tenant)
+		voterList, err := scoped.GetAllVoters()
+		if err != nil {
+			return count, err
+		}
+
+		for _, voterItem := range voterList {
+			if verifyChain(voterItem).Valid {
+				continue
+			}
+			chainHistory(&voterItem)
+			pipe := scoped.client.TxPipeline()
+			if err := scoped.setHistory(pipe, voterItem); err != nil {
+				return count, err
+			}
+			if _, err := pipe.Exec(scoped.context); err != nil {
+				return count, err
+			}
+			count++
+		}
+	}
+	return count, nil
+}
+
+// setHistory queues the writes of only the history of a voter and its
+// hash, the rest of the voter is left as it is
+func (vl *Voter) setHistory(pipe redis.Pipeliner, voterItem VoterItem) error {
+	historyBytes, err := json.Marshal(voterItem.VoteHistory)
+	if err != nil {
+		return err
+	}
+	redisKey := vl.redisKeyFromId(voterItem.VoterId)
+	pipe.Do(vl.context, "JSON.SET", redisKey, ".voteHistory", string(historyBytes))
+	pipe.Do(vl.context, "JSON.SET", redisKey, ".historyHash", strconv.Quote(voterItem.HistoryHash))
+	return nil
+}
@@ -0,0 +1,126 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// CheckInKeyPrefix is the prefix of the per poll hash that records which
+// voters checked in, the key looks like checkin:poll:<pollId>
+const CheckInKeyPrefix = "checkin:poll:"
+
+// ErrAlreadyCheckedIn is returned when a voter is checked in twice for
+// the same poll
+var ErrAlreadyCheckedIn = fmt.Errorf("%w: voter already checked in for this poll", ErrConflict)
+
+// CheckIn is the struct that represents a voter checking in to vote in
+// person for a poll.  Source says where the check-in came from, the
+// registration desk or a particular offline kiosk
+type CheckIn struct {
+	VoterId     int       `json:"voterId"`
+	PollId      int       `json:"pollId"`
+	Source      string    `json:"source"`
+	CheckedInAt time.Time `json:"checkedInAt"`
+}
+
+// CheckInBatchResult is the outcome of one record in a kiosk batch
+type CheckInBatchResult struct {
+	Line    int    `json:"line"`
+	VoterId int    `json:"voterId,omitempty"`
+	PollId  int    `json:"pollId,omitempty"`
+	Status  string `json:"status"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+// CheckInBatchReport summarizes an uploaded kiosk batch
+type CheckInBatchReport struct {
+	BatchId    string               `json:"batchId"`
+	KioskId    string               `json:"kioskId"`
+	ReceivedAt time.Time            `json:"receivedAt"`
+	Total      int                  `json:"total"`
+	CheckedIn  int                  `json:"checkedIn"`
+	Duplicates int                  `json:"duplicates"`
+	Conflicts  int                  `json:"conflicts"`
+	Invalid    int                  `json:"invalid"`
+	Results    []CheckInBatchResult `json:"results"`
+}
+
+const (
+	// CheckInBatchKeyPrefix is the prefix of stored kiosk batch reports
+	CheckInBatchKeyPrefix = "checkin:batch:"
+	// CheckInBatchTTL is how long batch reports are kept
+	CheckInBatchTTL = 30 * 24 * time.Hour
+)
+
+func (vl *Voter) checkInKey(pollId int) string {
+	return vl.key(fmt.Sprintf("%s%d", CheckInKeyPrefix, pollId))
+}
+
+// CheckInVoter marks a voter as checked in for a poll at the registration
+// desk, right now
+func (vl *Voter) CheckInVoter(voterId int, pollId int) (CheckIn, error) {
+	return vl.RecordCheckIn(CheckIn{
+		VoterId:     voterId,
+		PollId:      pollId,
+		Source:      "desk",
+		CheckedInAt: time.Now(),
+	})
+}
+
+// RecordCheckIn marks a voter as checked in for a poll.  HSETNX makes this
+// atomic, if two kiosks scan the same card at the same time only one of
+// them wins and the other gets ErrAlreadyCheckedIn
+func (vl *Voter) RecordCheckIn(checkIn CheckIn) (_ CheckIn, err error) {
+	defer observe("RecordCheckIn", time.Now(), &err)
+
+	if _, err := vl.GetVoter(checkIn.VoterId); err != nil {
+		return CheckIn{}, err
+	}
+
+	checkInBytes, err := json.Marshal(checkIn)
+	if err != nil {
+		return CheckIn{}, err
+	}
+
+	created, err := vl.client.HSetNX(vl.context, vl.checkInKey(checkIn.PollId), fmt.Sprint(checkIn.VoterId), checkInBytes).Result()
+	if err != nil {
+		return CheckIn{}, err
+	}
+	if !created {
+		return CheckIn{}, ErrAlreadyCheckedIn
+	}
+
+	vl.emit(EventVoterCheckedIn, checkIn.VoterId, checkIn.PollId)
+
+	return checkIn, nil
+}
+
+// SaveCheckInBatchReport stores the report of a kiosk batch upload so it
+// can be looked up again later with GetCheckInBatchReport
+func (vl *Voter) SaveCheckInBatchReport(report CheckInBatchReport) (err error) {
+	defer observe("SaveCheckInBatchReport", time.Now(), &err)
+
+	reportBytes, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	return vl.client.Set(vl.context, vl.key(CheckInBatchKeyPrefix+report.BatchId), reportBytes, CheckInBatchTTL).Err()
+}
+
+// GetCheckInBatchReport returns a previously stored kiosk batch report
+func (vl *Voter) GetCheckInBatchReport(batchId string) (report CheckInBatchReport, err error) {
+	defer observe("GetCheckInBatchReport", time.Now(), &err)
+
+	value, err := vl.client.Get(vl.context, vl.key(CheckInBatchKeyPrefix+batchId)).Result()
+	if err != nil {
+		if isRedisNilError(err) {
+			return CheckInBatchReport{}, ErrNotFound
+		}
+		return CheckInBatchReport{}, err
+	}
+
+	err = json.Unmarshal([]byte(value), &report)
+	return report, err
+}
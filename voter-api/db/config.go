@@ -0,0 +1,120 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Config holds the settings used to build the redis client.  Zero values
+// fall back to the go-redis defaults, so Config{Addr: "..."} behaves just
+// like the original bare redis.Options{Addr: location}.  The yaml names
+// are the ones used in the config file, see the config package
+type Config struct {
+	Addr         string        `yaml:"url"`
+	DB           int           `yaml:"db"`
+	PoolSize     int           `yaml:"poolSize"`
+	MinIdleConns int           `yaml:"minIdleConns"`
+	DialTimeout  time.Duration `yaml:"dialTimeout"`
+	ReadTimeout  time.Duration `yaml:"readTimeout"`
+	WriteTimeout time.Duration `yaml:"writeTimeout"`
+}
+
+// DefaultConfig returns a Config pointing at RedisDefaultLocation
+func DefaultConfig() Config {
+	return Config{Addr: RedisDefaultLocation}
+}
+
+// ConfigFromEnv builds a Config from the environment so operators can tune
+// the connection pool under load without rebuilding the container:
+//
+//	REDIS_URL             host:port of the redis server
+//	REDIS_DB              database index
+//	REDIS_POOL_SIZE       maximum number of socket connections
+//	REDIS_MIN_IDLE_CONNS  idle connections kept open
+//	REDIS_DIAL_TIMEOUT    e.g. 5s
+//	REDIS_READ_TIMEOUT    e.g. 3s
+//	REDIS_WRITE_TIMEOUT   e.g. 3s
+func ConfigFromEnv() (Config, error) {
+	cfg := DefaultConfig()
+	if err := cfg.ApplyEnv(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// ApplyEnv overrides the settings that are set in the environment, see
+// ConfigFromEnv, and leaves the others as they are
+func (cfg *Config) ApplyEnv() error {
+	if redisURL := os.Getenv("REDIS_URL"); redisURL != "" {
+		cfg.Addr = redisURL
+	}
+
+	if err := envInt("REDIS_DB", &cfg.DB); err != nil {
+		return err
+	}
+	if err := envInt("REDIS_POOL_SIZE", &cfg.PoolSize); err != nil {
+		return err
+	}
+	if err := envInt("REDIS_MIN_IDLE_CONNS", &cfg.MinIdleConns); err != nil {
+		return err
+	}
+	if err := envDuration("REDIS_DIAL_TIMEOUT", &cfg.DialTimeout); err != nil {
+		return err
+	}
+	if err := envDuration("REDIS_READ_TIMEOUT", &cfg.ReadTimeout); err != nil {
+		return err
+	}
+	return envDuration("REDIS_WRITE_TIMEOUT", &cfg.WriteTimeout)
+}
+
+// options converts the Config into the redis.Options go-redis expects.
+// Commands honour the deadline of their context, see Voter.WithContext
+func (cfg Config) options() *redis.Options {
+	return &redis.Options{
+		ContextTimeoutEnabled: true,
+
+		Addr:         cfg.Addr,
+		DB:           cfg.DB,
+		PoolSize:     cfg.PoolSize,
+		MinIdleConns: cfg.MinIdleConns,
+		DialTimeout:  cfg.DialTimeout,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+	}
+}
+
+// envInt reads an integer environment variable into value, leaving it
+// alone if the variable is unset
+func envInt(name string, value *int) error {
+	v := os.Getenv(name)
+	if v == "" {
+		return nil
+	}
+
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %w", name, v, err)
+	}
+	*value = i
+	return nil
+}
+
+// envDuration reads a duration environment variable such as "5s" into
+// value, leaving it alone if the variable is unset
+func envDuration(name string, value *time.Duration) error {
+	v := os.Getenv(name)
+	if v == "" {
+		return nil
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %w", name, v, err)
+	}
+	*value = d
+	return nil
+}
@@ -0,0 +1,185 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// DecisionRules are what a poll needs to pass.  QuorumPercent is the
+// least turnout, in percent of the eligible voters, and MinVotes the least
+// number of votes, for the poll to count at all.  ThresholdPercent is the
+// share of the counted votes PassOptionId, or without one the leading
+// option, needs more than: 50 for a simple majority, 66.66 for two
+// thirds.  In a Weighted poll the share is of the weight of the votes,
+// the quorum still counts voters.  Zero leaves a rule off
+type DecisionRules struct {
+	QuorumPercent    float64 `json:"quorumPercent,omitempty" validate:"gte=0,lte=100"`
+	MinVotes         int     `json:"minVotes,omitempty" validate:"gte=0"`
+	ThresholdPercent float64 `json:"thresholdPercent,omitempty" validate:"gte=0,lt=100"`
+	PassOptionId     int     `json:"passOptionId,omitempty" validate:"gte=0"`
+}
+
+// How a poll with DecisionRules stands, see PollOutcome
+const (
+	OutcomePassed       = "passed"
+	OutcomeFailed       = "failed"
+	OutcomeInconclusive = "inconclusive"
+)
+
+// Why a poll did not pass, or can not be called yet
+const (
+	ReasonQuorumNotMet   = "quorum_not_met"
+	ReasonBelowThreshold = "below_threshold"
+	ReasonTie            = "tie"
+)
+
+// PollOutcome is how a poll stands against its DecisionRules.  While the
+// poll is open it is the outcome the votes so far would have, Final once
+// it closed.  A poll short of its quorum is inconclusive until it closes
+// and fails then.  OptionId is the option the threshold was checked for
+type PollOutcome struct {
+	Status         string  `json:"status"`
+	Reason         string  `json:"reason,omitempty"`
+	Final          bool    `json:"final"`
+	Eligible       int     `json:"eligible"`
+	TurnoutPercent float64 `json:"turnoutPercent"`
+	QuorumMet      bool    `json:"quorumMet"`
+	OptionId       int     `json:"optionId,omitempty"`
+	SharePercent   float64 `json:"sharePercent"`
+}
+
+// checkDecision rejects rules that name an option the poll does not have
+func checkDecision(poll Poll) error {
+	if poll.Decision == nil || poll.Decision.PassOptionId == 0 || poll.HasOption(poll.Decision.PassOptionId) {
+		return nil
+	}
+	return fmt.Errorf("%w: passOptionId %d is not an option of the poll", ErrInvalid, poll.Decision.PassOptionId)
+}
+
+// Evaluate checks the results of a poll against the rules.  eligible is
+// how many voters may vote in it, closed whether it closed already
+func (r DecisionRules) Evaluate(results PollResults, eligible int, closed bool) PollOutcome {
+	outcome := PollOutcome{Final: closed, Eligible: eligible, QuorumMet: true}
+	if eligible > 0 {
+		outcome.TurnoutPercent = float64(results.TotalVotes) * 100 / float64(eligible)
+	}
+	if r.QuorumPercent > 0 && outcome.TurnoutPercent < r.QuorumPercent {
+		outcome.QuorumMet = false
+	}
+	if r.MinVotes > 0 && results.TotalVotes < r.MinVotes {
+		outcome.QuorumMet = false
+	}
+
+	optionId, votes, tied := r.candidate(results)
+	outcome.OptionId = optionId
+	if counted := countedVotes(results); counted > 0 {
+		outcome.SharePercent = votes * 100 / counted
+	}
+
+	switch {
+	case !outcome.QuorumMet && closed:
+		outcome.Status, outcome.Reason = OutcomeFailed, ReasonQuorumNotMet
+	case !outcome.QuorumMet:
+		outcome.Status, outcome.Reason = OutcomeInconclusive, ReasonQuorumNotMet
+	case tied:
+		outcome.Status, outcome.Reason = OutcomeInconclusive, ReasonTie
+	case optionId != 0 && votes > 0 && outcome.SharePercent > r.ThresholdPercent:
+		outcome.Status = OutcomePassed
+	default:
+		outcome.Status, outcome.Reason = OutcomeFailed, ReasonBelowThreshold
+	}
+	return outcome
+}
+
+// candidate is the option the threshold is checked for with its votes.
+// Without a PassOptionId it is the option leading the plurality count or
+// the instant-runoff winner, tied when two options lead with the same
+// votes
+func (r DecisionRules) candidate(results PollResults) (optionId int, votes float64, tied bool) {
+	if results.Method == MethodIRV && len(results.Rounds) > 0 {
+		//The final round of the runoff decides
+		return leading(results.Rounds[len(results.Rounds)-1].Counts, r.PassOptionId, false)
+	}
+	return leading(results.Options, r.PassOptionId, results.Weighted)
+}
+
+// leading returns the votes of passOptionId in counts, or the option with
+// the most votes when it is 0.  weighted goes by the weight of the votes
+func leading(counts []OptionResult, passOptionId int, weighted bool) (optionId int, votes float64, tied bool) {
+	for _, count := range counts {
+		counted := float64(count.Votes)
+		if weighted {
+			counted = count.Weight
+		}
+		switch {
+		case passOptionId != 0:
+			if count.OptionId == passOptionId {
+				return count.OptionId, counted, false
+			}
+		case counted > votes:
+			optionId, votes, tied = count.OptionId, counted, false
+		case counted == votes && votes > 0:
+			tied = true
+		}
+	}
+	return optionId, votes, tied
+}
+
+// countedVotes are the votes the threshold is a share of: every counted
+// vote or their weight, or for an instant-runoff the ballots left in its
+// final round
+func countedVotes(results PollResults) float64 {
+	if results.Method == MethodIRV && len(results.Rounds) > 0 {
+		counted := 0
+		for _, count := range results.Rounds[len(results.Rounds)-1].Counts {
+			counted += count.Votes
+		}
+		return float64(counted)
+	}
+	if results.Weighted {
+		return results.TotalWeight
+	}
+	return float64(results.TotalVotes)
+}
+
+// EvaluatePoll returns how the poll stands against its DecisionRules on
+// the results, nil for a poll without any
+func (vl *Voter) EvaluatePoll(poll Poll, results PollResults, now time.Time) (outcome *PollOutcome, err error) {
+	defer observe("EvaluatePoll", time.Now(), &err)
+
+	if poll.Decision == nil {
+		return nil, nil
+	}
+	eligible, err := vl.countEligible(poll)
+	if err != nil {
+		return nil, err
+	}
+	evaluated := poll.Decision.Evaluate(results, eligible, results.Frozen || poll.Closed(now))
+	return &evaluated, nil
+}
+
+// countEligible is how many voters may vote in the poll.  It is read from
+// the counters like GetPollTurnout, a poll limited to groups has to go
+// through the voters
+func (vl *Voter) countEligible(poll Poll) (int, error) {
+	if len(poll.Groups) == 0 {
+		turnout, err := vl.GetPollTurnout(poll.PollId, poll.Precincts, TurnoutBucket)
+		return turnout.Eligible, err
+	}
+
+	groups, err := vl.GetGroupsById(poll.Groups)
+	if err != nil {
+		return 0, err
+	}
+	voterList, err := vl.GetAllVoters()
+	if err != nil {
+		return 0, err
+	}
+	eligible := 0
+	for _, voterItem := range voterList {
+		if !voterItem.Purged() && poll.Eligible(voterItem, groups) {
+			eligible++
+		}
+	}
+	return eligible, nil
+}
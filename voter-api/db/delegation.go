@@ -0,0 +1,373 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// DelegationKeyPrefix is the prefix of the delegation JSON documents,
+	// delegation:<delegationId>
+	DelegationKeyPrefix = "delegation:"
+	// DelegationIndexKey is a sorted set of every delegation id, scored by
+	// the id, like GroupIndexKey
+	DelegationIndexKey = "idx:delegations"
+)
+
+// Delegation hands the vote of a voter to another voter, their proxy, for
+// one poll or for every poll of a category.  A voter that votes
+// themselves keeps their own vote.  Otherwise the vote of the delegate is
+// counted for them as well, following the delegate's own delegation if
+// they did not vote either, see delegatedVotes.  A delegation for the poll
+// goes before one for its category
+type Delegation struct {
+	DelegationId int       `json:"delegationId" validate:"gt=0"`
+	VoterId      int       `json:"voterId" validate:"gt=0"`
+	DelegateId   int       `json:"delegateId" validate:"gt=0,nefield=VoterId"`
+	PollId       int       `json:"pollId,omitempty" validate:"gte=0"`
+	Category     string    `json:"category,omitempty" validate:"max=100"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// sameScope reports whether two delegations are for the same poll or
+// category
+func (d Delegation) sameScope(other Delegation) bool {
+	if d.PollId != 0 || other.PollId != 0 {
+		return d.PollId == other.PollId
+	}
+	return strings.EqualFold(d.Category, other.Category)
+}
+
+// covers reports whether the delegation is for the poll, directly or
+// through its category
+func (d Delegation) covers(poll Poll) bool {
+	if d.PollId != 0 {
+		return d.PollId == poll.PollId
+	}
+	return poll.Category != "" && poll.InCategory(d.Category)
+}
+
+func (vl *Voter) delegationKey(id int) string {
+	return vl.key(fmt.Sprintf("%s%d", DelegationKeyPrefix, id))
+}
+
+// checkDelegation makes sure a delegation is for either a poll or a
+// category, between voters and for a poll that exist.  A voter has one
+// delegation per poll or category, a second one is ErrConflict.  A
+// delegation that closes a loop is accepted, the tally leaves it out
+func (vl *Voter) checkDelegation(delegation *Delegation) error {
+	delegation.Category = strings.TrimSpace(delegation.Category)
+	if (delegation.PollId == 0) == (delegation.Category == "") {
+		return fmt.Errorf("%w: a delegation is for either a poll or a category", ErrInvalid)
+	}
+	for _, voterId := range []int{delegation.VoterId, delegation.DelegateId} {
+		exists, err := vl.client.Exists(vl.context, vl.redisKeyFromId(voterId)).Result()
+		if err != nil {
+			return err
+		}
+		if exists == 0 {
+			return fmt.Errorf("%w: voter %d does not exist", ErrInvalid, voterId)
+		}
+	}
+	if delegation.PollId != 0 {
+		exists, err := vl.client.Exists(vl.context, vl.pollKey(delegation.PollId)).Result()
+		if err != nil {
+			return err
+		}
+		if exists == 0 {
+			return fmt.Errorf("%w: poll %d does not exist", ErrInvalid, delegation.PollId)
+		}
+	}
+
+	delegations, err := vl.GetAllDelegations()
+	if err != nil {
+		return err
+	}
+	for _, other := range delegations {
+		if other.DelegationId != delegation.DelegationId && other.VoterId == delegation.VoterId && other.sameScope(*delegation) {
+			return fmt.Errorf("%w: voter %d already delegated with delegation %d", ErrConflict, delegation.VoterId, other.DelegationId)
+		}
+	}
+	return nil
+}
+
+// AddDelegation stores a new delegation, ErrAlreadyExists if the id is
+// taken.  A frozen voter can not delegate.  It returns the delegation as
+// stored
+func (vl *Voter) AddDelegation(delegation Delegation) (added Delegation, err error) {
+	defer observe("AddDelegation", time.Now(), &err)
+
+	if err := vl.checkDelegation(&delegation); err != nil {
+		return Delegation{}, err
+	}
+	frozen, err := vl.isFrozen(delegation.VoterId)
+	if err != nil {
+		return Delegation{}, err
+	}
+	if frozen {
+		return Delegation{}, ErrFrozen
+	}
+	if delegation.CreatedAt.IsZero() {
+		delegation.CreatedAt = time.Now().UTC()
+	}
+	delegationBytes, err := json.Marshal(delegation)
+	if err != nil {
+		return Delegation{}, err
+	}
+
+	err = vl.client.Do(vl.context, "JSON.SET", vl.delegationKey(delegation.DelegationId), ".", string(delegationBytes), "NX").Err()
+	if isRedisNilError(err) {
+		return Delegation{}, ErrAlreadyExists
+	}
+	if err != nil {
+		return Delegation{}, err
+	}
+
+	err = vl.client.ZAdd(vl.context, vl.key(DelegationIndexKey), redis.Z{Score: float64(delegation.DelegationId), Member: strconv.Itoa(delegation.DelegationId)}).Err()
+	if err != nil {
+		return Delegation{}, err
+	}
+	vl.clearDelegatedResults(delegation)
+	return delegation, nil
+}
+
+// GetDelegation returns one delegation, ErrNotFound if there is none with
+// the id
+func (vl *Voter) GetDelegation(id int) (delegation Delegation, err error) {
+	defer observe("GetDelegation", time.Now(), &err)
+
+	value, err := vl.client.Do(vl.context, "JSON.GET", vl.delegationKey(id), ".").Text()
+	if err != nil {
+		if isRedisNilError(err) {
+			return Delegation{}, ErrNotFound
+		}
+		return Delegation{}, err
+	}
+
+	err = json.Unmarshal([]byte(value), &delegation)
+	return delegation, err
+}
+
+// GetAllDelegations returns every delegation ordered by id
+func (vl *Voter) GetAllDelegations() (delegationList []Delegation, err error) {
+	defer observe("GetAllDelegations", time.Now(), &err)
+
+	ids, err := vl.client.ZRange(vl.context, vl.key(DelegationIndexKey), 0, -1).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+
+	pipe := vl.client.Pipeline()
+	cmds := make([]*redis.Cmd, len(ids))
+	for i, id := range ids {
+		cmds[i] = pipe.Do(vl.context, "JSON.GET", vl.key(DelegationKeyPrefix+id), ".")
+	}
+	_, _ = pipe.Exec(vl.context)
+
+	for _, cmd := range cmds {
+		value, err := cmd.Text()
+		if isRedisNilError(err) {
+			//Deleted since the index was read
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var delegation Delegation
+		if err := json.Unmarshal([]byte(value), &delegation); err != nil {
+			return nil, err
+		}
+		delegationList = append(delegationList, delegation)
+	}
+
+	return delegationList, nil
+}
+
+// UpdateDelegation replaces a delegation that must already exist, the
+// voter it is from can not change.  It returns the delegation as stored
+func (vl *Voter) UpdateDelegation(delegation Delegation) (updated Delegation, err error) {
+	defer observe("UpdateDelegation", time.Now(), &err)
+
+	existing, err := vl.GetDelegation(delegation.DelegationId)
+	if err != nil {
+		return Delegation{}, err
+	}
+	if existing.VoterId != delegation.VoterId {
+		return Delegation{}, fmt.Errorf("%w: a delegation can not move to another voter", ErrConflict)
+	}
+	if err := vl.checkDelegation(&delegation); err != nil {
+		return Delegation{}, err
+	}
+	frozen, err := vl.isFrozen(delegation.VoterId)
+	if err != nil {
+		return Delegation{}, err
+	}
+	if frozen {
+		return Delegation{}, ErrFrozen
+	}
+	delegation.CreatedAt = existing.CreatedAt
+	delegationBytes, err := json.Marshal(delegation)
+	if err != nil {
+		return Delegation{}, err
+	}
+
+	err = vl.client.Do(vl.context, "JSON.SET", vl.delegationKey(delegation.DelegationId), ".", string(delegationBytes), "XX").Err()
+	if isRedisNilError(err) {
+		return Delegation{}, ErrNotFound
+	}
+	if err != nil {
+		return Delegation{}, err
+	}
+
+	//Both the polls it was for and the ones it is for now are counted again
+	vl.clearDelegatedResults(existing)
+	vl.clearDelegatedResults(delegation)
+	return delegation, nil
+}
+
+// DeleteDelegation takes a delegation back, the voter votes for
+// themselves again.  It returns the delegation that was deleted
+func (vl *Voter) DeleteDelegation(id int) (delegation Delegation, err error) {
+	defer observe("DeleteDelegation", time.Now(), &err)
+
+	delegation, err = vl.GetDelegation(id)
+	if err != nil {
+		return Delegation{}, err
+	}
+	frozen, err := vl.isFrozen(delegation.VoterId)
+	if err != nil {
+		return Delegation{}, err
+	}
+	if frozen {
+		return Delegation{}, ErrFrozen
+	}
+
+	numDeleted, err := vl.client.Del(vl.context, vl.delegationKey(id)).Result()
+	if err != nil {
+		return Delegation{}, err
+	}
+	if numDeleted == 0 {
+		return Delegation{}, ErrNotFound
+	}
+	if err := vl.client.ZRem(vl.context, vl.key(DelegationIndexKey), strconv.Itoa(id)).Err(); err != nil {
+		return Delegation{}, err
+	}
+
+	vl.clearDelegatedResults(delegation)
+	return delegation, nil
+}
+
+// clearDelegatedResults drops the cached tallies of the polls a delegation
+// is for, a delegation for a category goes through the polls in it
+func (vl *Voter) clearDelegatedResults(delegation Delegation) {
+	if delegation.PollId != 0 {
+		vl.clearResults(delegation.PollId, false)
+		return
+	}
+	pollList, err := vl.GetAllPolls()
+	if err != nil {
+		vl.log.Error("Error clearing delegated poll results", "category", delegation.Category, "error", err)
+		return
+	}
+	for _, poll := range pollList {
+		if delegation.covers(poll) {
+			vl.clearResults(poll.PollId, false)
+		}
+	}
+}
+
+// delegatedVotes are the votes counted by proxy in a poll: for every
+// active, eligible voter that delegated and did not vote, the vote of the
+// first voter down their chain of delegates that did.  voted holds the
+// votes of the poll by voter, provisional ones included, since a voter
+// with a provisional vote voted themselves.  A chain that comes back to a
+// voter on it is a cycle and counts for nobody, cycles is how many
+// delegators it left out.  A secret ballot has no votes to follow
+func (vl *Voter) delegatedVotes(poll Poll, voted map[int]Vote) (proxies []Vote, cycles int, err error) {
+	if poll.Anonymous {
+		return nil, 0, nil
+	}
+	delegations, err := vl.GetAllDelegations()
+	if err != nil || len(delegations) == 0 {
+		return nil, 0, err
+	}
+
+	//The delegate of each voter for the poll, one for the poll itself
+	//goes before one for its category
+	delegates := make(map[int]int)
+	for _, delegation := range delegations {
+		if !delegation.covers(poll) {
+			continue
+		}
+		if _, found := delegates[delegation.VoterId]; !found || delegation.PollId != 0 {
+			delegates[delegation.VoterId] = delegation.DelegateId
+		}
+	}
+	if len(delegates) == 0 {
+		return nil, 0, nil
+	}
+	groups, err := vl.GetGroupsById(poll.Groups)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for voterId, delegateId := range delegates {
+		if _, found := voted[voterId]; found {
+			continue
+		}
+		vote, found, cycle := resolveDelegate(voterId, delegateId, delegates, voted)
+		if cycle {
+			cycles++
+		}
+		if !found || vote.Provisional {
+			continue
+		}
+
+		var voterItem VoterItem
+		if err := vl.getVoterFromRedis(vl.redisKeyFromId(voterId), &voterItem); err != nil {
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			return nil, 0, err
+		}
+		if !voterItem.Active() || !poll.Eligible(voterItem, groups) {
+			continue
+		}
+
+		vote.VoteId = 0
+		vote.VoterId = voterId
+		vote.Weight = voterItem.VoteWeight()
+		proxies = append(proxies, vote)
+	}
+	return proxies, cycles, nil
+}
+
+// resolveDelegate follows a chain of delegates from voterId to the first
+// one that voted.  found is false when the chain ends with a delegate
+// that neither voted nor delegated, cycle when it comes back to a voter
+// that is already on it
+func resolveDelegate(voterId int, delegateId int, delegates map[int]int, voted map[int]Vote) (vote Vote, found bool, cycle bool) {
+	seen := map[int]bool{voterId: true}
+	for current := delegateId; ; {
+		if vote, found := voted[current]; found {
+			return vote, true, false
+		}
+		if seen[current] {
+			return Vote{}, false, true
+		}
+		seen[current] = true
+
+		next, delegated := delegates[current]
+		if !delegated {
+			return Vote{}, false, false
+		}
+		current = next
+	}
+}
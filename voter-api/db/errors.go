@@ -0,0 +1,24 @@
+package db
+
+import "errors"
+
+// Sentinel errors returned by the db package.  Callers should compare with
+// errors.Is rather than matching on the message, some of these are
+// wrapped with more detail (see ErrAlreadyCheckedIn)
+var (
+	// ErrNotFound is returned when the requested voter (or other record)
+	// does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrAlreadyExists is returned when adding a record whose id is taken
+	ErrAlreadyExists = errors.New("already exists")
+	// ErrPollNotFound is returned when the voter exists but has no history
+	// entry for the requested poll
+	ErrPollNotFound = errors.New("poll not found for this voter")
+	// ErrConflict is returned when a write conflicts with data that is
+	// already stored, for example recording the same poll twice
+	ErrConflict = errors.New("conflict")
+	// ErrInvalid is returned when a request is well formed but its values
+	// can not be accepted, the more specific errors like ErrInvalidPatch
+	// wrap it
+	ErrInvalid = errors.New("invalid")
+)
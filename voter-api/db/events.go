@@ -0,0 +1,175 @@
+package db
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// EventStreamKey is the redis stream every change event is added to.
+	// There is one stream for all tenants, events carry their tenant
+	EventStreamKey = "events:voters"
+	// EventStreamMaxLen caps the stream so it does not grow forever, redis
+	// trims it approximately to this many entries
+	EventStreamMaxLen = 100000
+)
+
+// Event types published to the stream
+const (
+	EventVoterCreated   = "voter.created"
+	EventVoterUpdated   = "voter.updated"
+	EventVoterDeleted   = "voter.deleted"
+	EventVoteRecorded   = "vote.recorded"
+	EventVoteWithdrawn  = "vote.withdrawn"
+	EventVoterCheckedIn = "voter.checkedin"
+	EventPollReminder   = "poll.reminder"
+)
+
+// Event is a single change event published to the stream
+type Event struct {
+	Type    string    `json:"type"`
+	VoterId int       `json:"voterId"`
+	PollId  int       `json:"pollId,omitempty"`
+	Tenant  string    `json:"tenant,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// emit publishes an event after a successful write.  The write already
+// happened, so a failure to publish is logged rather than returned
+func (vl *Voter) emit(eventType string, voterId int, pollId int) {
+	event := Event{Type: eventType, VoterId: voterId, PollId: pollId, Tenant: vl.tenant}
+	if err := vl.publishEvent(event); err != nil {
+		vl.log.Error("Error publishing event", "type", eventType, "voterId", voterId, "error", err)
+	}
+}
+
+// publishEvent adds an event to the stream.  The whole event is stored as
+// JSON in the "event" field so consumers only need to decode one value
+func (vl *Voter) publishEvent(event Event) error {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	eventBytes, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	return vl.client.XAdd(vl.context, &redis.XAddArgs{
+		Stream: EventStreamKey,
+		MaxLen: EventStreamMaxLen,
+		Approx: true,
+		Values: map[string]interface{}{
+			"type":  event.Type,
+			"event": eventBytes,
+		},
+	}).Err()
+}
+
+// EventEntry is an event with the id the stream gave it.  Ids only grow,
+// so a consumer that remembers the last id it saw can resume from there
+type EventEntry struct {
+	Id string `json:"id"`
+	Event
+}
+
+// LatestEventId is the id of the newest event, or "0-0" while the stream
+// is empty.  Reading after it returns only the events that follow
+func (vl *Voter) LatestEventId() (id string, err error) {
+	defer observe("LatestEventId", time.Now(), &err)
+
+	messages, err := vl.client.XRevRangeN(vl.context, EventStreamKey, "+", "-", 1).Result()
+	if err != nil {
+		return "", err
+	}
+	if len(messages) == 0 {
+		return "0-0", nil
+	}
+	return messages[0].ID, nil
+}
+
+// ReadEvents waits up to block for events newer than lastId and returns
+// them, or nothing when none came in time.  It is not observed like the
+// other operations, its duration is mostly the wait
+func (vl *Voter) ReadEvents(lastId string, block time.Duration) ([]EventEntry, error) {
+	streams, err := vl.client.XRead(vl.context, &redis.XReadArgs{
+		Streams: []string{EventStreamKey, lastId},
+		Count:   100,
+		Block:   block,
+	}).Result()
+	if err != nil {
+		if isRedisNilError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return vl.eventEntries(streams), nil
+}
+
+// EnsureEventGroup creates a consumer group on the event stream if it does
+// not exist yet.  A new group starts with the events that follow
+func (vl *Voter) EnsureEventGroup(group string) (err error) {
+	defer observe("EnsureEventGroup", time.Now(), &err)
+
+	err = vl.client.XGroupCreateMkStream(vl.context, EventStreamKey, group, "$").Err()
+	if err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil
+	}
+	return err
+}
+
+// ReadEventGroup waits up to block for events for one consumer of a group.
+// Every event goes to a single consumer of the group, so replicas share
+// the work instead of repeating it.  With id ">" it returns new events,
+// with "0" the ones this consumer read earlier but never acknowledged,
+// for example because it crashed.  Handled events are acknowledged with
+// AckEvents
+func (vl *Voter) ReadEventGroup(group string, consumer string, id string, block time.Duration) ([]EventEntry, error) {
+	streams, err := vl.client.XReadGroup(vl.context, &redis.XReadGroupArgs{
+		Group:    group,
+		Consumer: consumer,
+		Streams:  []string{EventStreamKey, id},
+		Count:    100,
+		Block:    block,
+	}).Result()
+	if err != nil {
+		if isRedisNilError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return vl.eventEntries(streams), nil
+}
+
+// AckEvents marks events of a group as handled
+func (vl *Voter) AckEvents(group string, ids ...string) (err error) {
+	defer observe("AckEvents", time.Now(), &err)
+
+	if len(ids) == 0 {
+		return nil
+	}
+	return vl.client.XAck(vl.context, EventStreamKey, group, ids...).Err()
+}
+
+// eventEntries decodes the messages read from the stream, a malformed one
+// is logged and skipped
+func (vl *Voter) eventEntries(streams []redis.XStream) []EventEntry {
+	var entries []EventEntry
+	for _, stream := range streams {
+		for _, message := range stream.Messages {
+			entry := EventEntry{Id: message.ID}
+			raw, _ := message.Values["event"].(string)
+			if err := json.Unmarshal([]byte(raw), &entry.Event); err != nil {
+				vl.log.Warn("Skipping malformed event", "id", message.ID, "error", err)
+				continue
+			}
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
@@ -0,0 +1,54 @@
+package db
+
+import (
+	"strconv"
+	"time"
+)
+
+// FeatureFlagsKey is a redis hash of the feature flags an admin switched
+// at runtime, name to "true" or "false".  Flags that are not in it use
+// their configured default
+const FeatureFlagsKey = "features"
+
+// GetFeatureFlags returns the feature flags switched at runtime
+func (vl *Voter) GetFeatureFlags() (flags map[string]bool, err error) {
+	defer observe("GetFeatureFlags", time.Now(), &err)
+
+	values, err := vl.client.HGetAll(vl.context, FeatureFlagsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	flags = make(map[string]bool, len(values))
+	for name, value := range values {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			vl.log.Warn("Ignoring invalid feature flag", "name", name, "value", value)
+			continue
+		}
+		flags[name] = enabled
+	}
+	return flags, nil
+}
+
+// SetFeatureFlag switches a feature on or off for every replica
+func (vl *Voter) SetFeatureFlag(name string, enabled bool) (err error) {
+	defer observe("SetFeatureFlag", time.Now(), &err)
+
+	return vl.client.HSet(vl.context, FeatureFlagsKey, name, strconv.FormatBool(enabled)).Err()
+}
+
+// ClearFeatureFlag puts a feature back to its configured default.  It
+// returns ErrNotFound if the flag was not switched at runtime
+func (vl *Voter) ClearFeatureFlag(name string) (err error) {
+	defer observe("ClearFeatureFlag", time.Now(), &err)
+
+	numDeleted, err := vl.client.HDel(vl.context, FeatureFlagsKey, name).Result()
+	if err != nil {
+		return err
+	}
+	if numDeleted == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// FrozenVotersKey is a redis set of the ids of frozen voters.  The frozen
+// flag is also stored on the voter record so it shows up in reads, the
+// set just makes the check before every write cheap
+const FrozenVotersKey = "frozen:voters"
+
+// ErrFrozen is returned when a write is attempted against a frozen voter
+var ErrFrozen = errors.New("voter is frozen")
+
+// isFrozen reports whether a voter is frozen
+func (vl *Voter) isFrozen(id int) (bool, error) {
+	return vl.client.SIsMember(vl.context, vl.key(FrozenVotersKey), fmt.Sprint(id)).Result()
+}
+
+// SetVoterFrozen freezes or unfreezes a voter.  While a voter is frozen
+// (for example a disputed record under investigation) every write to it
+// returns ErrFrozen, reads continue to work
+func (vl *Voter) SetVoterFrozen(id int, frozen bool) (voterItem VoterItem, err error) {
+	defer observe("SetVoterFrozen", time.Now(), &err)
+
+	voterItem, err = vl.GetVoter(id)
+	if err != nil {
+		return VoterItem{}, err
+	}
+
+	//Update just the frozen flag in place so the rest of the record is
+	//not touched
+	if _, err := vl.jsonHelper.JSONSet(vl.redisKeyFromId(id), ".frozen", frozen); err != nil {
+		return VoterItem{}, err
+	}
+
+	if frozen {
+		err = vl.client.SAdd(vl.context, vl.key(FrozenVotersKey), fmt.Sprint(id)).Err()
+	} else {
+		err = vl.client.SRem(vl.context, vl.key(FrozenVotersKey), fmt.Sprint(id)).Err()
+	}
+	if err != nil {
+		return VoterItem{}, err
+	}
+
+	voterItem.Frozen = frozen
+	vl.emit(EventVoterUpdated, id, 0)
+
+	return voterItem, nil
+}
@@ -0,0 +1,401 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// GroupKeyPrefix is the prefix of the voter group JSON documents,
+	// group:<groupId>
+	GroupKeyPrefix = "group:"
+	// GroupIndexKey is a sorted set of every group id, scored by the id,
+	// like PollIndexKey
+	GroupIndexKey = "idx:groups"
+)
+
+// MaxGroupMembers bounds the members of a manual group, a larger segment
+// is better described by a rule
+const MaxGroupMembers = 10000
+
+// VoterGroup is a named set of voters, a segment polls can be limited to
+// and voter lists filtered by.  A manual group lists its Members, a
+// rule-based one has a Rule instead and holds whichever voters match it
+// at the time
+type VoterGroup struct {
+	GroupId     int        `json:"groupId" validate:"gt=0"`
+	Name        string     `json:"name" validate:"required,max=200"`
+	Description string     `json:"description,omitempty" validate:"max=1000"`
+	Members     []int      `json:"members,omitempty" validate:"max=10000,dive,gt=0"`
+	Rule        *GroupRule `json:"rule,omitempty"`
+}
+
+// GroupRule selects the voters of a rule-based group.  A voter has to
+// match every condition that is set, and for a list one of its values.
+// EmailDomain is matched ignoring case, VotedIn are polls the voter voted
+// in
+type GroupRule struct {
+	Precincts   []int    `json:"precincts,omitempty" validate:"max=1000,dive,gt=0"`
+	Statuses    []string `json:"statuses,omitempty" validate:"max=5,dive,oneof=pending active inactive suspended purged"`
+	Verified    *bool    `json:"verified,omitempty"`
+	EmailDomain string   `json:"emailDomain,omitempty" validate:"max=254"`
+	VotedIn     []int    `json:"votedIn,omitempty" validate:"max=100,dive,gt=0"`
+}
+
+// Contains reports whether the voter is in the group
+func (g VoterGroup) Contains(voterItem VoterItem) bool {
+	if g.Rule == nil {
+		for _, voterId := range g.Members {
+			if voterId == voterItem.VoterId {
+				return true
+			}
+		}
+		return false
+	}
+	return g.Rule.Matches(voterItem)
+}
+
+// Matches reports whether the voter meets every condition of the rule
+func (r GroupRule) Matches(voterItem VoterItem) bool {
+	if len(r.Precincts) > 0 && !containsInt(r.Precincts, voterItem.PrecinctId) {
+		return false
+	}
+	if len(r.Statuses) > 0 {
+		found := false
+		for _, status := range r.Statuses {
+			found = found || status == voterItem.status()
+		}
+		if !found {
+			return false
+		}
+	}
+	if r.Verified != nil && *r.Verified != voterItem.Verified {
+		return false
+	}
+	if r.EmailDomain != "" {
+		domain := strings.ToLower(strings.TrimPrefix(r.EmailDomain, "@"))
+		if !strings.HasSuffix(strings.ToLower(voterItem.Email), "@"+domain) {
+			return false
+		}
+	}
+	if len(r.VotedIn) > 0 {
+		found := false
+		for _, pollId := range r.VotedIn {
+			found = found || votedIn(voterItem.VoteHistory, pollId)
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// containsInt reports whether values holds value
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (vl *Voter) groupKey(id int) string {
+	return vl.key(fmt.Sprintf("%s%d", GroupKeyPrefix, id))
+}
+
+// checkGroup rejects a group that has both members and a rule, and one
+// whose rule refers to precincts that do not exist
+func (vl *Voter) checkGroup(group *VoterGroup) error {
+	if group.Rule != nil && len(group.Members) > 0 {
+		return fmt.Errorf("%w: a group has either members or a rule", ErrInvalid)
+	}
+	if group.Rule != nil {
+		return vl.checkPrecincts(group.Rule.Precincts...)
+	}
+
+	//A voter is a member once
+	seen := make(map[int]bool, len(group.Members))
+	members := group.Members[:0]
+	for _, voterId := range group.Members {
+		if !seen[voterId] {
+			seen[voterId] = true
+			members = append(members, voterId)
+		}
+	}
+	group.Members = members
+	return nil
+}
+
+// checkGroups makes sure every id above zero names a stored group,
+// ErrInvalid otherwise, like checkPrecincts
+func (vl *Voter) checkGroups(ids ...int) error {
+	for _, id := range ids {
+		if id <= 0 {
+			continue
+		}
+		exists, err := vl.client.Exists(vl.context, vl.groupKey(id)).Result()
+		if err != nil {
+			return err
+		}
+		if exists == 0 {
+			return fmt.Errorf("%w: group %d does not exist", ErrInvalid, id)
+		}
+	}
+	return nil
+}
+
+// AddGroup stores a new group, ErrAlreadyExists if the id is taken.  It
+// returns the group as stored
+func (vl *Voter) AddGroup(group VoterGroup) (added VoterGroup, err error) {
+	defer observe("AddGroup", time.Now(), &err)
+
+	if err := vl.checkGroup(&group); err != nil {
+		return VoterGroup{}, err
+	}
+	groupBytes, err := json.Marshal(group)
+	if err != nil {
+		return VoterGroup{}, err
+	}
+
+	err = vl.client.Do(vl.context, "JSON.SET", vl.groupKey(group.GroupId), ".", string(groupBytes), "NX").Err()
+	if isRedisNilError(err) {
+		return VoterGroup{}, ErrAlreadyExists
+	}
+	if err != nil {
+		return VoterGroup{}, err
+	}
+
+	return group, vl.client.ZAdd(vl.context, vl.key(GroupIndexKey), redis.Z{Score: float64(group.GroupId), Member: strconv.Itoa(group.GroupId)}).Err()
+}
+
+// GetGroup returns one group, ErrNotFound if there is none with the id
+func (vl *Voter) GetGroup(id int) (group VoterGroup, err error) {
+	defer observe("GetGroup", time.Now(), &err)
+
+	value, err := vl.client.Do(vl.context, "JSON.GET", vl.groupKey(id), ".").Text()
+	if err != nil {
+		if isRedisNilError(err) {
+			return VoterGroup{}, ErrNotFound
+		}
+		return VoterGroup{}, err
+	}
+
+	err = json.Unmarshal([]byte(value), &group)
+	return group, err
+}
+
+// GetAllGroups returns every group ordered by id
+func (vl *Voter) GetAllGroups() (groupList []VoterGroup, err error) {
+	defer observe("GetAllGroups", time.Now(), &err)
+
+	ids, err := vl.client.ZRange(vl.context, vl.key(GroupIndexKey), 0, -1).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+
+	pipe := vl.client.Pipeline()
+	cmds := make([]*redis.Cmd, len(ids))
+	for i, id := range ids {
+		cmds[i] = pipe.Do(vl.context, "JSON.GET", vl.key(GroupKeyPrefix+id), ".")
+	}
+	_, _ = pipe.Exec(vl.context)
+
+	for _, cmd := range cmds {
+		value, err := cmd.Text()
+		if isRedisNilError(err) {
+			//Deleted since the index was read
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var group VoterGroup
+		if err := json.Unmarshal([]byte(value), &group); err != nil {
+			return nil, err
+		}
+		groupList = append(groupList, group)
+	}
+
+	return groupList, nil
+}
+
+// GetGroupsById returns the groups with the ids, the ones that do not
+// exist are left out
+func (vl *Voter) GetGroupsById(ids []int) (groups map[int]VoterGroup, err error) {
+	groups = make(map[int]VoterGroup, len(ids))
+	for _, id := range ids {
+		group, err := vl.GetGroup(id)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		groups[id] = group
+	}
+	return groups, nil
+}
+
+// UpdateGroup replaces a group that must already exist.  It returns the
+// group as stored
+func (vl *Voter) UpdateGroup(group VoterGroup) (updated VoterGroup, err error) {
+	defer observe("UpdateGroup", time.Now(), &err)
+
+	if err := vl.checkGroup(&group); err != nil {
+		return VoterGroup{}, err
+	}
+	groupBytes, err := json.Marshal(group)
+	if err != nil {
+		return VoterGroup{}, err
+	}
+
+	err = vl.client.Do(vl.context, "JSON.SET", vl.groupKey(group.GroupId), ".", string(groupBytes), "XX").Err()
+	if isRedisNilError(err) {
+		return VoterGroup{}, ErrNotFound
+	}
+	return group, err
+}
+
+// SetGroupMember adds a voter to or removes it from a manual group.  A
+// rule-based group has no members to change, ErrConflict.  It returns
+// the group as stored
+func (vl *Voter) SetGroupMember(id int, voterId int, member bool) (group VoterGroup, err error) {
+	defer observe("SetGroupMember", time.Now(), &err)
+
+	redisKey := vl.groupKey(id)
+	update := func(tx *redis.Tx) error {
+		get := redis.NewCmd(vl.context, "JSON.GET", redisKey, ".")
+		_ = tx.Process(vl.context, get)
+		value, err := get.Text()
+		if isRedisNilError(err) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal([]byte(value), &group); err != nil {
+			return err
+		}
+		if group.Rule != nil {
+			return fmt.Errorf("%w: group %d is rule-based, its members can not be changed", ErrConflict, id)
+		}
+
+		members := []int{}
+		for _, memberId := range group.Members {
+			if memberId != voterId {
+				members = append(members, memberId)
+			}
+		}
+		if member {
+			if len(members) >= MaxGroupMembers {
+				return fmt.Errorf("%w: a group is limited to %d members", ErrInvalid, MaxGroupMembers)
+			}
+			members = append(members, voterId)
+		}
+		group.Members = members
+
+		membersBytes, err := json.Marshal(members)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(vl.context, func(pipe redis.Pipeliner) error {
+			pipe.Do(vl.context, "JSON.SET", redisKey, ".members", string(membersBytes))
+			return nil
+		})
+		return err
+	}
+
+	for attempt := 0; attempt < maxHistoryRetries; attempt++ {
+		err = vl.client.Watch(vl.context, update, redisKey)
+		if !errors.Is(err, redis.TxFailedErr) {
+			break
+		}
+	}
+	if errors.Is(err, redis.TxFailedErr) {
+		return VoterGroup{}, fmt.Errorf("%w: group %d is being changed by another request", ErrConflict, id)
+	}
+	if err != nil {
+		return VoterGroup{}, err
+	}
+	return group, nil
+}
+
+// DeleteGroup removes a group.  One a poll is still limited to is
+// ErrConflict, the poll would be left open to nobody
+func (vl *Voter) DeleteGroup(id int) (err error) {
+	defer observe("DeleteGroup", time.Now(), &err)
+
+	pollList, err := vl.GetAllPolls()
+	if err != nil {
+		return err
+	}
+	for _, poll := range pollList {
+		if containsInt(poll.Groups, id) {
+			return fmt.Errorf("%w: poll %d is limited to group %d", ErrConflict, poll.PollId, id)
+		}
+	}
+
+	numDeleted, err := vl.client.Del(vl.context, vl.groupKey(id)).Result()
+	if err != nil {
+		return err
+	}
+	if numDeleted == 0 {
+		return ErrNotFound
+	}
+
+	return vl.client.ZRem(vl.context, vl.key(GroupIndexKey), strconv.Itoa(id)).Err()
+}
+
+// GetGroupVoters returns the voters in a group ordered by id, ErrNotFound
+// if the group does not exist.  The members of a manual group that were
+// deleted since are left out
+func (vl *Voter) GetGroupVoters(id int) (voterList []VoterItem, err error) {
+	defer observe("GetGroupVoters", time.Now(), &err)
+
+	group, err := vl.GetGroup(id)
+	if err != nil {
+		return nil, err
+	}
+	candidates, err := vl.groupCandidates(group)
+	if err != nil {
+		return nil, err
+	}
+	for _, voterItem := range candidates {
+		if group.Contains(voterItem) {
+			voterList = append(voterList, voterItem)
+		}
+	}
+	sort.Slice(voterList, func(i, j int) bool { return voterList[i].VoterId < voterList[j].VoterId })
+
+	return voterList, nil
+}
+
+// groupCandidates reads the voters that may be in a group: only the
+// members of a manual one, every voter for a rule
+func (vl *Voter) groupCandidates(group VoterGroup) ([]VoterItem, error) {
+	if group.Rule != nil {
+		return vl.GetAllVoters()
+	}
+
+	var voterList []VoterItem
+	for _, voterId := range group.Members {
+		var voterItem VoterItem
+		if err := vl.getVoterFromRedis(vl.redisKeyFromId(voterId), &voterItem); err != nil {
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		voterList = append(voterList, voterItem)
+	}
+	return voterList, nil
+}
@@ -0,0 +1,79 @@
+package db
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	// IdempotencyKeyPrefix is the prefix of the stored responses, one per
+	// client and Idempotency-Key
+	IdempotencyKeyPrefix = "idempotency:"
+	// IdempotencyTTL is how long a response is replayed for retries
+	IdempotencyTTL = 24 * time.Hour
+	// IdempotencyLockTTL bounds how long a request can hold its key while it
+	// runs, so a crashed replica does not block the key for a whole day
+	IdempotencyLockTTL = time.Minute
+)
+
+// IdempotentResponse is the first response sent for an Idempotency-Key.
+// Until the request finishes only RequestHash is set and Complete is false
+type IdempotentResponse struct {
+	RequestHash string `json:"requestHash"`
+	Complete    bool   `json:"complete"`
+	Status      int    `json:"status,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+}
+
+// ReserveIdempotencyKey claims a key for a request about to run.  When the
+// key is new it is locked and reserved is true.  Otherwise the stored
+// response, possibly still in progress, is returned for the caller to
+// replay or refuse
+func (vl *Voter) ReserveIdempotencyKey(key string, requestHash string) (stored IdempotentResponse, reserved bool, err error) {
+	defer observe("ReserveIdempotencyKey", time.Now(), &err)
+
+	lock, err := json.Marshal(IdempotentResponse{RequestHash: requestHash})
+	if err != nil {
+		return IdempotentResponse{}, false, err
+	}
+
+	reserved, err = vl.client.SetNX(vl.context, IdempotencyKeyPrefix+key, lock, IdempotencyLockTTL).Result()
+	if err != nil || reserved {
+		return IdempotentResponse{}, reserved, err
+	}
+
+	value, err := vl.client.Get(vl.context, IdempotencyKeyPrefix+key).Result()
+	if err != nil {
+		//The lock expired between the two calls, the client can retry
+		if isRedisNilError(err) {
+			return IdempotentResponse{RequestHash: requestHash}, false, nil
+		}
+		return IdempotentResponse{}, false, err
+	}
+
+	err = json.Unmarshal([]byte(value), &stored)
+	return stored, false, err
+}
+
+// SaveIdempotentResponse stores the finished response of a reserved key,
+// it is replayed for IdempotencyTTL
+func (vl *Voter) SaveIdempotentResponse(key string, response IdempotentResponse) (err error) {
+	defer observe("SaveIdempotentResponse", time.Now(), &err)
+
+	response.Complete = true
+	responseBytes, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+
+	return vl.client.Set(vl.context, IdempotencyKeyPrefix+key, responseBytes, IdempotencyTTL).Err()
+}
+
+// ReleaseIdempotencyKey forgets a reserved key, used when the request
+// failed in a way a retry could fix
+func (vl *Voter) ReleaseIdempotencyKey(key string) (err error) {
+	defer observe("ReleaseIdempotencyKey", time.Now(), &err)
+
+	return vl.client.Del(vl.context, IdempotencyKeyPrefix+key).Err()
+}
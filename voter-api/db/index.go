@@ -0,0 +1,257 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// VoterIndexKey is a sorted set of every voter id, scored by the id.  It
+// lets us page through voters in a stable order without KEYS or SCAN
+const VoterIndexKey = "idx:voters"
+
+// EmailIndexKeyPrefix is the prefix of the email index, idx:email:<email>
+// is a set of the ids of the voters using that (normalized) email
+const EmailIndexKeyPrefix = "idx:email:"
+
+// IndexVersion is bumped whenever a new index is added, EnsureIndexes
+// rebuilds every index when the stored version is older.  3 added the
+// vote counters, see stats.go, 4 the turnout series and precinct counters
+// and 5 the leaderboard.  Every tenant keeps its own version, the
+// default tenant under the unprefixed key
+const (
+	IndexVersion    = 5
+	IndexVersionKey = "meta:indexVersion"
+)
+
+func (vl *Voter) emailIndexKey(email string) string {
+	return vl.key(EmailIndexKeyPrefix + NormalizeEmail(email))
+}
+
+// indexVoter adds a voter to the indexes and its history to the vote
+// counters after a successful write.  A failure is logged, RebuildIndexes
+// repairs the indexes
+func (vl *Voter) indexVoter(voterItem VoterItem) {
+	id := strconv.Itoa(voterItem.VoterId)
+
+	pipe := vl.client.TxPipeline()
+	pipe.ZAdd(vl.context, vl.key(VoterIndexKey), redis.Z{Score: float64(voterItem.VoterId), Member: id})
+	if voterItem.Email != "" {
+		pipe.SAdd(vl.context, vl.emailIndexKey(voterItem.Email), id)
+	}
+	vl.countVotes(pipe, nil, voterItem.VoteHistory)
+	vl.countPrecinct(pipe, 0, voterItem.PrecinctId)
+	vl.rankVoter(pipe, voterItem)
+	if _, err := pipe.Exec(vl.context); err != nil {
+		vl.log.Error("Error indexing voter", "voterId", voterItem.VoterId, "error", err)
+	}
+}
+
+// reindexVoter moves a voter in the email index when its email changed
+// and counts the polls added to or removed from its history, every vote
+// recorded with AddVoterPoll ends up here
+func (vl *Voter) reindexVoter(oldItem VoterItem, newItem VoterItem) {
+	id := strconv.Itoa(newItem.VoterId)
+	pipe := vl.client.TxPipeline()
+	if NormalizeEmail(oldItem.Email) != NormalizeEmail(newItem.Email) {
+		if oldItem.Email != "" {
+			pipe.SRem(vl.context, vl.emailIndexKey(oldItem.Email), id)
+		}
+		if newItem.Email != "" {
+			pipe.SAdd(vl.context, vl.emailIndexKey(newItem.Email), id)
+		}
+	}
+	vl.countVotes(pipe, oldItem.VoteHistory, newItem.VoteHistory)
+	vl.countPrecinct(pipe, oldItem.PrecinctId, newItem.PrecinctId)
+	if len(oldItem.VoteHistory) != len(newItem.VoteHistory) {
+		vl.rankVoter(pipe, newItem)
+	}
+	if pipe.Len() == 0 {
+		return
+	}
+	if _, err := pipe.Exec(vl.context); err != nil {
+		vl.log.Error("Error reindexing voter", "voterId", newItem.VoterId, "error", err)
+	}
+}
+
+// unindexVoter removes a deleted voter from the indexes
+func (vl *Voter) unindexVoter(voterItem VoterItem) {
+	id := strconv.Itoa(voterItem.VoterId)
+
+	pipe := vl.client.TxPipeline()
+	pipe.ZRem(vl.context, vl.key(VoterIndexKey), id)
+	pipe.ZRem(vl.context, vl.key(LeaderboardKey), id)
+	if voterItem.Email != "" {
+		pipe.SRem(vl.context, vl.emailIndexKey(voterItem.Email), id)
+	}
+	vl.countVotes(pipe, voterItem.VoteHistory, nil)
+	vl.countPrecinct(pipe, voterItem.PrecinctId, 0)
+	if _, err := pipe.Exec(vl.context); err != nil {
+		vl.log.Error("Error removing voter from indexes", "voterId", voterItem.VoterId, "error", err)
+	}
+}
+
+// voterIdFromKey parses the id back out of a voter:<id> key
+func (vl *Voter) voterIdFromKey(key string) (int, error) {
+	return strconv.Atoi(strings.TrimPrefix(key, vl.key(RedisKeyPrefix)))
+}
+
+// scanKeys returns every key matching pattern using SCAN, which unlike
+// KEYS does not block redis while it walks a large keyspace
+func (vl *Voter) scanKeys(pattern string) ([]string, error) {
+	var keyList []string
+	iter := vl.client.Scan(vl.context, 0, pattern, 1000).Iterator()
+	for iter.Next(vl.context) {
+		keyList = append(keyList, iter.Val())
+	}
+	return keyList, iter.Err()
+}
+
+// RebuildIndexes recreates the voter id and email indexes, the vote and
+// precinct counters and the leaderboard from the voters that are actually stored, returning how many
+// voters were indexed
+func (vl *Voter) RebuildIndexes() (count int, err error) {
+	defer observe("RebuildIndexes", time.Now(), &err)
+
+	voterList, err := vl.GetAllVoters()
+	if err != nil {
+		return 0, err
+	}
+
+	emailKeys, err := vl.scanKeys(vl.key(EmailIndexKeyPrefix + "*"))
+	if err != nil {
+		return 0, err
+	}
+	turnoutKeys, err := vl.scanKeys(vl.key(TurnoutKeyPrefix + "*"))
+	if err != nil {
+		return 0, err
+	}
+
+	pipe := vl.client.TxPipeline()
+	pipe.Del(vl.context, vl.key(VoterIndexKey), vl.key(VoteCountsKey), vl.key(LastVoteKey), vl.key(PrecinctCountsKey), vl.key(LeaderboardKey))
+	if len(emailKeys) > 0 {
+		pipe.Del(vl.context, emailKeys...)
+	}
+	if len(turnoutKeys) > 0 {
+		pipe.Del(vl.context, turnoutKeys...)
+	}
+	for _, voterItem := range voterList {
+		id := strconv.Itoa(voterItem.VoterId)
+		pipe.ZAdd(vl.context, vl.key(VoterIndexKey), redis.Z{Score: float64(voterItem.VoterId), Member: id})
+		if voterItem.Email != "" {
+			pipe.SAdd(vl.context, vl.emailIndexKey(voterItem.Email), id)
+		}
+		vl.countVotes(pipe, nil, voterItem.VoteHistory)
+		vl.countPrecinct(pipe, 0, voterItem.PrecinctId)
+		vl.rankVoter(pipe, voterItem)
+	}
+	pipe.Set(vl.context, vl.key(IndexVersionKey), IndexVersion, 0)
+	if _, err := pipe.Exec(vl.context); err != nil {
+		return 0, err
+	}
+
+	return len(voterList), nil
+}
+
+// EnsureIndexes builds the indexes of every tenant if they are missing or
+// were built by an older release, for example on data written before
+// they existed
+func (vl *Voter) EnsureIndexes() error {
+	return vl.ForEachTenant(func(scoped *Voter) error {
+		return scoped.ensureTenantIndexes()
+	})
+}
+
+// ensureTenantIndexes is EnsureIndexes for the tenant of the Voter
+func (vl *Voter) ensureTenantIndexes() error {
+	//Search is optional, plain redis without the search module still
+	//serves everything else
+	if err := vl.ensureSearchIndex(); err != nil {
+		vl.log.Warn("Could not create the search index, GET /voters/search will fail", "tenant", vl.tenant, "error", err)
+	}
+
+	version, err := vl.client.Get(vl.context, vl.key(IndexVersionKey)).Int()
+	if err != nil && !isRedisNilError(err) {
+		return err
+	}
+	if version >= IndexVersion {
+		return nil
+	}
+
+	count, err := vl.RebuildIndexes()
+	if err != nil {
+		return err
+	}
+	vl.log.Info("Built indexes", "tenant", vl.tenant, "voters", count)
+	return nil
+}
+
+// GetVoterIdsByEmail uses the email index to find the voters with an email
+func (vl *Voter) GetVoterIdsByEmail(email string) (ids []int, err error) {
+	defer observe("GetVoterIdsByEmail", time.Now(), &err)
+
+	members, err := vl.client.SMembers(vl.context, vl.emailIndexKey(email)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, member := range members {
+		if id, err := strconv.Atoi(member); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// GetVotersPage returns up to limit voters with an id greater than
+// afterId, in id order.  nextCursor is the id to pass as afterId to get
+// the next page, or 0 when there are no more voters
+func (vl *Voter) GetVotersPage(afterId int, limit int) (voterList []VoterItem, nextCursor int, err error) {
+	defer observe("GetVotersPage", time.Now(), &err)
+
+	//Ask for one extra id so we know if there is another page
+	ids, err := vl.client.ZRangeByScore(vl.context, vl.key(VoterIndexKey), &redis.ZRangeBy{
+		Min:   fmt.Sprintf("(%d", afterId),
+		Max:   "+inf",
+		Count: int64(limit + 1),
+	}).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	hasMore := len(ids) > limit
+	if hasMore {
+		ids = ids[:limit]
+	}
+
+	for _, idString := range ids {
+		var voterItem VoterItem
+		if err := vl.getVoterFromRedis(vl.key(RedisKeyPrefix+idString), &voterItem); err != nil {
+			//The index can briefly point at a voter that was just
+			//deleted, skip it rather than failing the whole page
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			return nil, 0, err
+		}
+		voterList = append(voterList, voterItem)
+	}
+
+	if hasMore {
+		nextCursor, _ = strconv.Atoi(ids[len(ids)-1])
+	}
+
+	return voterList, nextCursor, nil
+}
+
+// VoterExists reports whether a voter exists without reading it
+func (vl *Voter) VoterExists(id int) (exists bool, err error) {
+	defer observe("VoterExists", time.Now(), &err)
+
+	n, err := vl.client.Exists(vl.context, vl.redisKeyFromId(id)).Result()
+	return n > 0, err
+}
@@ -0,0 +1,71 @@
+package db
+
+// RunoffRound is one round of an instant-runoff.  Counts are the votes of
+// the options still in the race, Exhausted the ballots that rank none of
+// them any more and Eliminated the option dropped after the round
+type RunoffRound struct {
+	Round      int            `json:"round"`
+	Counts     []OptionResult `json:"counts"`
+	Exhausted  int            `json:"exhausted"`
+	Eliminated int            `json:"eliminated,omitempty"`
+}
+
+// tallyIRV runs an instant-runoff over ballots, each one a list of option
+// ids from most to least preferred.  Every ballot counts for its highest
+// ranked option still in the race.  An option with more than half of the
+// ballots that are not exhausted wins, otherwise the one with the fewest
+// votes is dropped and the next round starts.  A tie for the fewest votes
+// drops the option listed last in the poll, so the result does not depend
+// on the order the votes were read in.  winner is 0 without any ballots
+func tallyIRV(poll Poll, ballots [][]int) (rounds []RunoffRound, winner int) {
+	continuing := make(map[int]bool, len(poll.Options))
+	for _, option := range poll.Options {
+		continuing[option.OptionId] = true
+	}
+
+	for len(continuing) > 0 {
+		round := RunoffRound{Round: len(rounds) + 1}
+		counts := make(map[int]int)
+		for _, ballot := range ballots {
+			counted := false
+			for _, optionId := range ballot {
+				if continuing[optionId] {
+					counts[optionId]++
+					counted = true
+					break
+				}
+			}
+			if !counted {
+				round.Exhausted++
+			}
+		}
+
+		active := len(ballots) - round.Exhausted
+		lowest, leader := 0, 0
+		for _, option := range poll.Options {
+			if !continuing[option.OptionId] {
+				continue
+			}
+			votes := counts[option.OptionId]
+			round.Counts = append(round.Counts, OptionResult{OptionId: option.OptionId, Text: option.Text, Votes: votes})
+			if lowest == 0 || votes <= counts[lowest] {
+				lowest = option.OptionId
+			}
+			if leader == 0 || votes > counts[leader] {
+				leader = option.OptionId
+			}
+		}
+
+		if active == 0 {
+			return append(rounds, round), 0
+		}
+		if counts[leader]*2 > active {
+			return append(rounds, round), leader
+		}
+
+		round.Eliminated = lowest
+		delete(continuing, lowest)
+		rounds = append(rounds, round)
+	}
+	return rounds, 0
+}
@@ -0,0 +1,194 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Background jobs belong to the deployment, not to a tenant: a job works
+// through every tenant itself.  jobs:lock:<name> is held by the replica
+// running the job, jobs:next is a hash of the next scheduled run of every
+// job in unix millis and jobs:runs:<name> the latest runs, newest first
+const (
+	JobLockKeyPrefix = "jobs:lock:"
+	JobNextKey       = "jobs:next"
+	JobRunsKeyPrefix = "jobs:runs:"
+
+	// JobRunsMaxLen is how many runs are kept per job
+	JobRunsMaxLen = 50
+)
+
+// Outcomes of a job run, a run that is still going has neither
+const (
+	JobRunSucceeded = "succeeded"
+	JobRunFailed    = "failed"
+)
+
+// JobRun is one run of a background job.  Trigger is schedule or manual,
+// Owner the replica that ran it and Result what the job reported, e.g. how
+// many voters it touched
+type JobRun struct {
+	Job      string     `json:"job"`
+	Trigger  string     `json:"trigger"`
+	Owner    string     `json:"owner"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Status   string     `json:"status,omitempty"`
+	Result   string     `json:"result,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// releaseJobLockScript deletes a job lock only if owner still holds it, a
+// replica whose lock expired must not release the one a peer took since
+var releaseJobLockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// refreshJobLockScript extends a job lock if owner still holds it
+var refreshJobLockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// AcquireJobLock takes the lock of a job for owner, so no other replica
+// runs it at the same time.  acquired is false when someone else holds
+// it.  The lock expires after ttl unless it is refreshed, so a replica
+// that dies mid run does not block the job for good
+func (vl *Voter) AcquireJobLock(name string, owner string, ttl time.Duration) (acquired bool, err error) {
+	defer observe("AcquireJobLock", time.Now(), &err)
+
+	return vl.client.SetNX(vl.context, JobLockKeyPrefix+name, owner, ttl).Result()
+}
+
+// RefreshJobLock extends the lock of a job by ttl, held is false when owner
+// lost it in the meantime
+func (vl *Voter) RefreshJobLock(name string, owner string, ttl time.Duration) (held bool, err error) {
+	defer observe("RefreshJobLock", time.Now(), &err)
+
+	n, err := refreshJobLockScript.Run(vl.context, vl.client, []string{JobLockKeyPrefix + name}, owner, ttl.Milliseconds()).Int()
+	return n == 1, err
+}
+
+// ReleaseJobLock gives up the lock of a job if owner holds it
+func (vl *Voter) ReleaseJobLock(name string, owner string) (err error) {
+	defer observe("ReleaseJobLock", time.Now(), &err)
+
+	return releaseJobLockScript.Run(vl.context, vl.client, []string{JobLockKeyPrefix + name}, owner).Err()
+}
+
+// GetJobLock returns the owner holding the lock of a job, "" when the job
+// is not running
+func (vl *Voter) GetJobLock(name string) (owner string, err error) {
+	defer observe("GetJobLock", time.Now(), &err)
+
+	owner, err = vl.client.Get(vl.context, JobLockKeyPrefix+name).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	return owner, err
+}
+
+// NextJobRun returns when a job is scheduled to run next.  A job that was
+// never scheduled is scheduled at first, the first replica to get here
+// wins so they all agree
+func (vl *Voter) NextJobRun(name string, first time.Time) (next time.Time, err error) {
+	defer observe("NextJobRun", time.Now(), &err)
+
+	pipe := vl.client.TxPipeline()
+	pipe.HSetNX(vl.context, JobNextKey, name, first.UnixMilli())
+	get := pipe.HGet(vl.context, JobNextKey, name)
+	if _, err := pipe.Exec(vl.context); err != nil {
+		return time.Time{}, err
+	}
+	millis, err := strconv.ParseInt(get.Val(), 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.UnixMilli(millis).UTC(), nil
+}
+
+// SetNextJobRun schedules the next run of a job
+func (vl *Voter) SetNextJobRun(name string, next time.Time) (err error) {
+	defer observe("SetNextJobRun", time.Now(), &err)
+
+	return vl.client.HSet(vl.context, JobNextKey, name, next.UnixMilli()).Err()
+}
+
+// SaveJobRun records a run of a job, a run is saved when it starts and
+// again when it finished, the second save replaces the first
+func (vl *Voter) SaveJobRun(run JobRun) (err error) {
+	defer observe("SaveJobRun", time.Now(), &err)
+
+	runBytes, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+
+	key := JobRunsKeyPrefix + run.Job
+	if run.Finished != nil {
+		//Replace the entry saved when the run started, if it is still the
+		//latest one
+		latest, err := vl.client.LIndex(vl.context, key, 0).Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+		var started JobRun
+		if latest != "" && json.Unmarshal([]byte(latest), &started) == nil &&
+			started.Finished == nil && started.Owner == run.Owner && started.Started.Equal(run.Started) {
+			return vl.client.LSet(vl.context, key, 0, runBytes).Err()
+		}
+	}
+
+	pipe := vl.client.TxPipeline()
+	pipe.LPush(vl.context, key, runBytes)
+	pipe.LTrim(vl.context, key, 0, JobRunsMaxLen-1)
+	_, err = pipe.Exec(vl.context)
+	return err
+}
+
+// GetJobRuns returns the latest runs of a job, newest first
+func (vl *Voter) GetJobRuns(name string, limit int) (runs []JobRun, err error) {
+	defer observe("GetJobRuns", time.Now(), &err)
+
+	values, err := vl.client.LRange(vl.context, JobRunsKeyPrefix+name, 0, int64(limit)-1).Result()
+	if err != nil {
+		return nil, err
+	}
+	runs = make([]JobRun, 0, len(values))
+	for _, value := range values {
+		var run JobRun
+		if err := json.Unmarshal([]byte(value), &run); err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, nil
+}
+
+// ForEachTenant calls fn with a Voter for every tenant that has voters
+// stored, the default tenant first.  It stops at the first error
+func (vl *Voter) ForEachTenant(fn func(*Voter) error) error {
+	tenants, err := vl.storedTenants()
+	if err != nil {
+		return err
+	}
+	for _, tenant := range tenants {
+		if err := fn(vl.WithTenant(tenant)); err != nil {
+			if tenant != "" {
+				return fmt.Errorf("tenant %q: %w", tenant, err)
+			}
+			return err
+		}
+	}
+	return nil
+}
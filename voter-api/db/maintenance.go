@@ -0,0 +1,52 @@
+package db
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// MaintenanceKey holds the maintenance mode an admin switched on, it is
+// in redis so every replica stops taking writes at once
+const MaintenanceKey = "maintenance"
+
+// Maintenance is the state of maintenance mode, while it is enabled every
+// write is rejected and reads keep working
+type Maintenance struct {
+	Enabled bool      `json:"enabled"`
+	Reason  string    `json:"reason,omitempty"`
+	Since   time.Time `json:"since,omitempty"`
+	Actor   string    `json:"actor,omitempty"`
+}
+
+// GetMaintenance returns the maintenance mode, the zero Maintenance when
+// it is off
+func (vl *Voter) GetMaintenance() (maintenance Maintenance, err error) {
+	defer observe("GetMaintenance", time.Now(), &err)
+
+	value, err := vl.client.Get(vl.context, MaintenanceKey).Bytes()
+	if err != nil {
+		if isRedisNilError(err) {
+			return Maintenance{}, nil
+		}
+		return Maintenance{}, err
+	}
+
+	err = json.Unmarshal(value, &maintenance)
+	return maintenance, err
+}
+
+// SetMaintenance switches maintenance mode on, or off when it is not
+// enabled
+func (vl *Voter) SetMaintenance(maintenance Maintenance) (err error) {
+	defer observe("SetMaintenance", time.Now(), &err)
+
+	if !maintenance.Enabled {
+		return vl.client.Del(vl.context, MaintenanceKey).Err()
+	}
+
+	value, err := json.Marshal(maintenance)
+	if err != nil {
+		return err
+	}
+	return vl.client.Set(vl.context, MaintenanceKey, value, 0).Err()
+}
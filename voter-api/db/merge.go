@@ -0,0 +1,212 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// MergeResult is the voter two records were merged into.  DroppedVotes are
+// the ids of the votes both records had in the same poll, only the earlier
+// one of each pair is kept
+type MergeResult struct {
+	Voter        VoterItem `json:"voter"`
+	MergedId     int       `json:"mergedId"`
+	DroppedVotes []int     `json:"droppedVotes,omitempty"`
+}
+
+// MergeVoters folds the voter sourceId into targetId, which keeps its id,
+// name and email.  The histories are combined, a poll both voted in keeps
+// the earlier vote.  Demographics the target lacks come from the source,
+// it stays verified or active if either was.  The source is deleted and
+// its votes move to the target, the dropped ones are deleted
+func (vl *Voter) MergeVoters(targetId int, sourceId int) (result MergeResult, err error) {
+	defer observe("MergeVoters", time.Now(), &err)
+
+	if targetId == sourceId {
+		return MergeResult{}, fmt.Errorf("%w: a voter can not be merged into itself", ErrInvalid)
+	}
+
+	targetKey, sourceKey := vl.redisKeyFromId(targetId), vl.redisKeyFromId(sourceId)
+	var oldTarget, source, merged VoterItem
+	var movedVotes []int
+	update := func(tx *redis.Tx) error {
+		var err error
+		if oldTarget, err = vl.getWatchedVoter(tx, targetKey); err != nil {
+			return err
+		}
+		if source, err = vl.getWatchedVoter(tx, sourceKey); err != nil {
+			return err
+		}
+
+		merged, movedVotes, result.DroppedVotes = mergeVoterItems(oldTarget, source)
+		chainHistory(&merged)
+		mergedBytes, err := json.Marshal(merged)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(vl.context, func(pipe redis.Pipeliner) error {
+			pipe.Do(vl.context, "JSON.SET", targetKey, ".", string(mergedBytes))
+			pipe.Del(vl.context, sourceKey)
+			return nil
+		})
+		return err
+	}
+
+	err = vl.client.Watch(vl.context, update, targetKey, sourceKey)
+	if errors.Is(err, redis.TxFailedErr) {
+		return MergeResult{}, fmt.Errorf("%w: voter %d or %d is being changed by another request", ErrConflict, targetId, sourceId)
+	}
+	if err != nil {
+		return MergeResult{}, err
+	}
+
+	vl.unindexVoter(source)
+	vl.reindexVoter(oldTarget, merged)
+	vl.moveVotes(movedVotes, sourceId, targetId)
+	vl.dropVotes(result.DroppedVotes)
+	vl.emit(EventVoterDeleted, sourceId, 0)
+	vl.emit(EventVoterUpdated, targetId, 0)
+
+	result.Voter = merged
+	result.MergedId = sourceId
+	return result, nil
+}
+
+// mergeVoterItems combines source into target, returning the ids of the
+// source votes that are kept and of the votes dropped as duplicates
+func mergeVoterItems(target VoterItem, source VoterItem) (merged VoterItem, moved []int, dropped []int) {
+	merged = target
+	merged.VoteHistory = append([]VoterHistory{}, target.VoteHistory...)
+
+	byPoll := make(map[int]int, len(merged.VoteHistory))
+	for i, history := range merged.VoteHistory {
+		byPoll[history.PollId] = i
+	}
+	for _, history := range source.VoteHistory {
+		i, found := byPoll[history.PollId]
+		switch {
+		case !found:
+			byPoll[history.PollId] = len(merged.VoteHistory)
+			merged.VoteHistory = append(merged.VoteHistory, history)
+			moved = append(moved, history.VoteId)
+		case history.VoteDate.Before(merged.VoteHistory[i].VoteDate):
+			dropped = append(dropped, merged.VoteHistory[i].VoteId)
+			merged.VoteHistory[i] = history
+			moved = append(moved, history.VoteId)
+		default:
+			dropped = append(dropped, history.VoteId)
+		}
+	}
+
+	if source.Active() && !merged.Active() {
+		merged.Status = VoterStatusActive
+	}
+	merged.Verified = target.Verified || source.Verified
+	if merged.AgeBand == "" {
+		merged.AgeBand = source.AgeBand
+	}
+	if merged.Region == "" {
+		merged.Region = source.Region
+	}
+	if source.RegisteredAt != nil && (merged.RegisteredAt == nil || source.RegisteredAt.Before(*merged.RegisteredAt)) {
+		merged.RegisteredAt = source.RegisteredAt
+	}
+	return merged, moved, dropped
+}
+
+// moveVotes points the recorded votes voteIds of voter from at voter to
+func (vl *Voter) moveVotes(voteIds []int, from int, to int) {
+	for _, voteId := range voteIds {
+		vote, err := vl.GetVote(voteId)
+		if err != nil || vote.VoterId != from {
+			//Histories added without a vote have no record to move
+			continue
+		}
+		if _, err := vl.jsonHelper.JSONSet(vl.voteKey(voteId), ".voterId", to); err != nil {
+			vl.log.Error("Error moving merged vote", "voteId", voteId, "error", err)
+		}
+	}
+}
+
+// dropVotes deletes the recorded votes voteIds, their history entries are
+// already gone
+func (vl *Voter) dropVotes(voteIds []int) {
+	for _, voteId := range voteIds {
+		vote, err := vl.GetVote(voteId)
+		if err != nil {
+			continue
+		}
+		pipe := vl.client.TxPipeline()
+		pipe.Del(vl.context, vl.voteKey(voteId))
+		pipe.ZRem(vl.context, vl.key(VoteIndexKey), fmt.Sprint(voteId))
+		if _, err := pipe.Exec(vl.context); err != nil {
+			vl.log.Error("Error dropping merged vote", "voteId", voteId, "error", err)
+			continue
+		}
+		vl.clearResults(vote.PollId, false)
+	}
+}
+
+// Why voters ended up in the same DuplicateGroup
+const (
+	DuplicateEmail = "email"
+	DuplicateName  = "name"
+)
+
+// DuplicateGroup is a set of voters that are probably the same person,
+// they share Value after normalizing their email or name
+type DuplicateGroup struct {
+	Match    string `json:"match"`
+	Value    string `json:"value"`
+	VoterIds []int  `json:"voterIds"`
+}
+
+// normalizeName folds case and spacing, "Jane  DOE" matches "jane doe"
+func normalizeName(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// FindDuplicateVoters scans every voter for normalized emails and names
+// that more than one of them uses, candidates for MergeVoters
+func (vl *Voter) FindDuplicateVoters() (groups []DuplicateGroup, err error) {
+	defer observe("FindDuplicateVoters", time.Now(), &err)
+
+	voterList, err := vl.GetAllVoters()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]map[string][]int{DuplicateEmail: {}, DuplicateName: {}}
+	for _, voterItem := range voterList {
+		if email := NormalizeEmail(voterItem.Email); email != "" {
+			seen[DuplicateEmail][email] = append(seen[DuplicateEmail][email], voterItem.VoterId)
+		}
+		if name := normalizeName(voterItem.Name); name != "" {
+			seen[DuplicateName][name] = append(seen[DuplicateName][name], voterItem.VoterId)
+		}
+	}
+
+	groups = []DuplicateGroup{}
+	for match, values := range seen {
+		for value, ids := range values {
+			if len(ids) < 2 {
+				continue
+			}
+			sort.Ints(ids)
+			groups = append(groups, DuplicateGroup{Match: match, Value: value, VoterIds: ids})
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Match != groups[j].Match {
+			return groups[i].Match < groups[j].Match
+		}
+		return groups[i].Value < groups[j].Value
+	})
+	return groups, nil
+}
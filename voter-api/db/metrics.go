@@ -0,0 +1,62 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Outcomes used to label the operation metrics
+const (
+	OutcomeSuccess  = "success"
+	OutcomeNotFound = "not_found"
+	OutcomeError    = "error"
+)
+
+// Every db method is counted and timed, labeled by the operation (the
+// method name) and how it turned out, so a slow JSONGet or a spike of
+// errors on one operation can be spotted on its own
+var (
+	operationsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "voter",
+			Subsystem: "db",
+			Name:      "operations_total",
+			Help:      "Number of db operations, by operation and outcome.",
+		},
+		[]string{"operation", "outcome"},
+	)
+
+	operationDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "voter",
+			Subsystem: "db",
+			Name:      "operation_duration_seconds",
+			Help:      "Latency of db operations, by operation and outcome.",
+			Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+		},
+		[]string{"operation", "outcome"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(operationsTotal, operationDuration)
+}
+
+// observe records one db operation.  It is meant to be deferred at the top
+// of a method with a named error return, for example:
+//
+//	defer observe("GetVoter", time.Now(), &err)
+func observe(operation string, start time.Time, err *error) {
+	outcome := OutcomeSuccess
+	if *err != nil {
+		outcome = OutcomeError
+		if errors.Is(*err, ErrNotFound) || errors.Is(*err, ErrPollNotFound) || isRedisNilError(*err) {
+			outcome = OutcomeNotFound
+		}
+	}
+
+	operationsTotal.WithLabelValues(operation, outcome).Inc()
+	operationDuration.WithLabelValues(operation, outcome).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,142 @@
+package db
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// NotificationRetryKey is a sorted set of failed notifications scored
+	// by the unix time of their next delivery attempt
+	NotificationRetryKey = "notifications:retry"
+	// NotificationDeadLetterKey is a hash of notifications that ran out
+	// of attempts, keyed by the retry id
+	NotificationDeadLetterKey = "notifications:deadletter"
+)
+
+// NotificationRetry is a notification that failed to deliver and is
+// waiting for another attempt.  The payload is the JSON encoded message,
+// the db package does not need to know what is inside of it
+type NotificationRetry struct {
+	Id          string          `json:"id"`
+	Payload     json.RawMessage `json:"payload"`
+	Attempts    int             `json:"attempts"`
+	LastError   string          `json:"lastError"`
+	NextAttempt time.Time       `json:"nextAttempt"`
+}
+
+// ScheduleNotificationRetry puts a failed notification on the retry queue,
+// it will be returned by PopDueNotificationRetries after item.NextAttempt
+func (vl *Voter) ScheduleNotificationRetry(item NotificationRetry) (err error) {
+	defer observe("ScheduleNotificationRetry", time.Now(), &err)
+
+	itemBytes, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+
+	return vl.client.ZAdd(vl.context, NotificationRetryKey, redis.Z{
+		Score:  float64(item.NextAttempt.Unix()),
+		Member: itemBytes,
+	}).Err()
+}
+
+// PopDueNotificationRetries removes and returns every retry whose next
+// attempt time has passed.  ZRem is used to claim each item, so if several
+// replicas are draining the queue only one of them gets a given item
+func (vl *Voter) PopDueNotificationRetries(now time.Time) (dueList []NotificationRetry, err error) {
+	defer observe("PopDueNotificationRetries", time.Now(), &err)
+
+	members, err := vl.client.ZRangeByScore(vl.context, NotificationRetryKey, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(now.Unix(), 10),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, member := range members {
+		claimed, err := vl.client.ZRem(vl.context, NotificationRetryKey, member).Result()
+		if err != nil {
+			return nil, err
+		}
+		if claimed == 0 {
+			continue
+		}
+
+		var item NotificationRetry
+		if err := json.Unmarshal([]byte(member), &item); err != nil {
+			return nil, err
+		}
+		dueList = append(dueList, item)
+	}
+
+	return dueList, nil
+}
+
+// AddNotificationDeadLetter parks a notification that will not be retried
+// again automatically
+func (vl *Voter) AddNotificationDeadLetter(item NotificationRetry) (err error) {
+	defer observe("AddNotificationDeadLetter", time.Now(), &err)
+
+	itemBytes, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+
+	return vl.client.HSet(vl.context, NotificationDeadLetterKey, item.Id, itemBytes).Err()
+}
+
+// GetNotificationDeadLetters returns every notification on the dead-letter list
+func (vl *Voter) GetNotificationDeadLetters() (deadLetterList []NotificationRetry, err error) {
+	defer observe("GetNotificationDeadLetters", time.Now(), &err)
+
+	entries, err := vl.client.HGetAll(vl.context, NotificationDeadLetterKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, value := range entries {
+		var item NotificationRetry
+		if err := json.Unmarshal([]byte(value), &item); err != nil {
+			return nil, err
+		}
+		deadLetterList = append(deadLetterList, item)
+	}
+
+	return deadLetterList, nil
+}
+
+// RequeueNotificationDeadLetter moves a dead-lettered notification back on
+// to the retry queue with a fresh attempt count, due immediately
+func (vl *Voter) RequeueNotificationDeadLetter(id string) (retry NotificationRetry, err error) {
+	defer observe("RequeueNotificationDeadLetter", time.Now(), &err)
+
+	value, err := vl.client.HGet(vl.context, NotificationDeadLetterKey, id).Result()
+	if err != nil {
+		if isRedisNilError(err) {
+			return NotificationRetry{}, ErrNotFound
+		}
+		return NotificationRetry{}, err
+	}
+
+	var item NotificationRetry
+	if err := json.Unmarshal([]byte(value), &item); err != nil {
+		return NotificationRetry{}, err
+	}
+
+	item.Attempts = 0
+	item.NextAttempt = time.Now()
+	if err := vl.ScheduleNotificationRetry(item); err != nil {
+		return NotificationRetry{}, err
+	}
+
+	if err := vl.client.HDel(vl.context, NotificationDeadLetterKey, id).Err(); err != nil {
+		return NotificationRetry{}, err
+	}
+
+	return item, nil
+}
@@ -0,0 +1,167 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+	"unicode/utf8"
+)
+
+// ErrInvalidPatch is returned by PatchVoter when the patch touches a
+// field that can not be patched or has the wrong type
+var ErrInvalidPatch = fmt.Errorf("%w voter patch", ErrInvalid)
+
+// phonePattern is an E.164 phone number, like the e164 validation of a
+// whole voter
+var phonePattern = regexp.MustCompile(`^\+[1-9]?[0-9]{7,14}$`)
+
+// patchableFields maps the JSON fields of a voter that can be patched to
+// an empty value of their type, which is also what a null resets them to
+var patchableFields = map[string]any{
+	"name":        "",
+	"email":       "",
+	"phone":       "",
+	"voteHistory": []VoterHistory{},
+	"precinctId":  0,
+	"emailOptOut": false,
+	"ageBand":     "",
+	"region":      "",
+}
+
+// PatchVoter applies a JSON merge patch (RFC 7396) to a voter.  Only the
+// fields in the patch change, so a client changing the name does not have
+// to send the vote history back.  The patch is merged into the voter read
+// under a WATCH, see watchVoter, so it can not clobber a vote recorded in
+// the meantime.  Arrays are replaced as a whole, as the RFC requires, and
+// null resets a field.  validate gets the patched voter before it is
+// written and holds it to the rules of a whole voter, its error is
+// returned as it is
+func (vl *Voter) PatchVoter(id int, patch map[string]json.RawMessage, validate func(voterItem VoterItem) error) (voterItem VoterItem, err error) {
+	defer observe("PatchVoter", time.Now(), &err)
+
+	updates := make(map[string]json.RawMessage)
+	for field, value := range patch {
+		//The id is allowed in the patch as long as it does not change
+		if field == "voterId" {
+			var patchId int
+			if err := json.Unmarshal(value, &patchId); err != nil || patchId != id {
+				return VoterItem{}, fmt.Errorf("%w: voterId can not be changed", ErrInvalidPatch)
+			}
+			continue
+		}
+
+		empty, ok := patchableFields[field]
+		if !ok {
+			return VoterItem{}, fmt.Errorf("%w: %s can not be patched", ErrInvalidPatch, field)
+		}
+
+		if bytes.Equal(bytes.TrimSpace(value), []byte("null")) {
+			value, _ = json.Marshal(empty)
+		} else if err := validatePatchValue(field, value); err != nil {
+			return VoterItem{}, err
+		}
+		if field == "precinctId" {
+			var precinctId int
+			_ = json.Unmarshal(value, &precinctId)
+			if err := vl.checkPrecincts(precinctId); err != nil {
+				return VoterItem{}, err
+			}
+		}
+		updates[field] = value
+	}
+	if len(updates) == 0 {
+		return vl.GetVoter(id)
+	}
+	merge, err := json.Marshal(updates)
+	if err != nil {
+		return VoterItem{}, err
+	}
+
+	_, voterItem, err = vl.watchVoter(id, func(patched *VoterItem) error {
+		existingHistory, existingEmail := patched.VoteHistory, patched.Email
+		//Arrays are replaced, unmarshalling into the voter would reuse
+		//the backing array of its history
+		if _, found := updates["voteHistory"]; found {
+			patched.VoteHistory = nil
+		}
+		if err := json.Unmarshal(merge, patched); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+		}
+		//A new email is not verified
+		if !patched.HasEmail(existingEmail) {
+			patched.Verified = false
+		}
+
+		//A new history is chained like any other write of it
+		if _, found := updates["voteHistory"]; found {
+			keepServerFields(patched.VoteHistory, existingHistory)
+			chainHistory(patched)
+		}
+		if validate != nil {
+			return validate(*patched)
+		}
+		return nil
+	}, vl.setVoter)
+	if err != nil {
+		return VoterItem{}, err
+	}
+
+	vl.emit(EventVoterUpdated, id, 0)
+
+	return voterItem, nil
+}
+
+// validatePatchValue makes sure a patched value has the type of the field
+// it replaces, so a patch can not store a number as the voters name, and
+// stays within the limits a whole voter is held to
+func validatePatchValue(field string, value json.RawMessage) error {
+	var err error
+	length, limit := 0, 0
+	switch field {
+	case "name", "email":
+		var s string
+		err = json.Unmarshal(value, &s)
+		length, limit = utf8.RuneCountInString(s), MaxNameLength
+		if field == "email" {
+			limit = MaxEmailLength
+		}
+	case "voteHistory":
+		var history []VoterHistory
+		err = json.Unmarshal(value, &history)
+		length, limit = len(history), MaxVoteHistory
+		if err == nil {
+			if err := checkHistory(history); err != nil {
+				return err
+			}
+		}
+	case "precinctId":
+		var precinctId int
+		err = json.Unmarshal(value, &precinctId)
+		if err == nil && precinctId < 0 {
+			return fmt.Errorf("%w: precinctId can not be negative", ErrInvalidPatch)
+		}
+	case "phone":
+		var phone string
+		err = json.Unmarshal(value, &phone)
+		if err == nil && phone != "" && !phonePattern.MatchString(phone) {
+			return fmt.Errorf("%w: phone must be an E.164 number", ErrInvalidPatch)
+		}
+	case "emailOptOut":
+		var optOut bool
+		err = json.Unmarshal(value, &optOut)
+	case "ageBand", "region":
+		//Which age bands there are is left to the check of the whole voter
+		var s string
+		err = json.Unmarshal(value, &s)
+		length, limit = utf8.RuneCountInString(s), MaxRegionLength
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s has the wrong type", ErrInvalidPatch, field)
+	}
+	if length > limit {
+		return fmt.Errorf("%w: %s is limited to %d", ErrInvalidPatch, field, limit)
+	}
+	return nil
+}
@@ -0,0 +1,302 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// PollKeyPrefix is the prefix of the poll JSON documents, poll:<pollId>.
+	// The pollId of a VoterHistory entry refers to one of them
+	PollKeyPrefix = "poll:"
+	// PollIndexKey is a sorted set of every poll id, scored by the id, so
+	// the polls can be listed without scanning the keyspace
+	PollIndexKey = "idx:polls"
+)
+
+// PollOption is one of the answers a voter can pick in a poll
+type PollOption struct {
+	OptionId int    `json:"optionId" validate:"gt=0"`
+	Text     string `json:"text" validate:"required,max=200"`
+}
+
+// Poll is a question put to the voters.  Options need at least two
+// entries and their ids have to be unique within the poll.  Votes are
+// taken from OpensAt until ClosesAt, a poll without them is open from the
+// start and stays open.  With AllowWriteIns a vote can name its own answer
+// instead of picking an option, in a Ranked poll it ranks the options.
+// A poll with Precincts is only open to the voters assigned to one of
+// them, one with Groups to the voters in one of them, see Eligible.  An Anonymous poll takes secret ballots, see
+// AddAnonymousVote
+type Poll struct {
+	PollId        int          `json:"pollId" validate:"gt=0"`
+	Title         string       `json:"title" validate:"required,max=200"`
+	Question      string       `json:"question" validate:"required,max=1000"`
+	Options       []PollOption `json:"options" validate:"min=2,max=100,dive"`
+	OpensAt       *time.Time   `json:"opensAt,omitempty"`
+	ClosesAt      *time.Time   `json:"closesAt,omitempty"`
+	AllowWriteIns bool         `json:"allowWriteIns,omitempty"`
+	Ranked        bool         `json:"ranked,omitempty"`
+	Precincts     []int        `json:"precincts,omitempty" validate:"max=1000,dive,gt=0"`
+	Groups        []int        `json:"groups,omitempty" validate:"max=100,dive,gt=0"`
+	Anonymous     bool         `json:"anonymous,omitempty"`
+
+	//RetentionExempt keeps the votes of the poll in the vote history
+	//whatever the retention policy, see RetentionPolicy
+	RetentionExempt bool `json:"retentionExempt,omitempty"`
+
+	//Category and Tags organize the polls of a deployment with many
+	//topics, see NormalizeTags.  Both are matched ignoring case
+	Category string   `json:"category,omitempty" validate:"max=100"`
+	Tags     []string `json:"tags,omitempty" validate:"max=20,dive,required,max=50"`
+
+	//Weighted counts every vote with the weight of its voter, see
+	//VoterItem.Weight, next to the number of votes
+	Weighted bool `json:"weighted,omitempty"`
+
+	//Decision is the quorum and threshold the poll needs to pass, the
+	//results report how it stands against them, see EvaluatePoll
+	Decision *DecisionRules `json:"decision,omitempty"`
+}
+
+// NormalizeTags trims and lower cases tags and drops the empty and
+// repeated ones, so "Budget " and "budget" are the same tag
+func NormalizeTags(tags []string) []string {
+	var normalized []string
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	return normalized
+}
+
+// HasTag reports whether the poll is tagged with tag
+func (p Poll) HasTag(tag string) bool {
+	tag = strings.TrimSpace(tag)
+	for _, pollTag := range p.Tags {
+		if strings.EqualFold(pollTag, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// InCategory reports whether the poll is in category
+func (p Poll) InCategory(category string) bool {
+	return strings.EqualFold(strings.TrimSpace(p.Category), strings.TrimSpace(category))
+}
+
+// Where now falls in the voting window of a poll, see Poll.Window
+const (
+	PollNotOpen = "not_open"
+	PollOpen    = "open"
+	PollClosed  = "closed"
+)
+
+// Window reports whether the poll is not open yet, open or closed at now
+func (p Poll) Window(now time.Time) string {
+	switch {
+	case p.OpensAt != nil && now.Before(*p.OpensAt):
+		return PollNotOpen
+	case p.Closed(now):
+		return PollClosed
+	}
+	return PollOpen
+}
+
+// Closed reports whether the poll had closed at now
+func (p Poll) Closed(now time.Time) bool {
+	return p.ClosesAt != nil && !now.Before(*p.ClosesAt)
+}
+
+// HasOption reports whether optionId is one of the options of the poll
+func (p Poll) HasOption(optionId int) bool {
+	for _, option := range p.Options {
+		if option.OptionId == optionId {
+			return true
+		}
+	}
+	return false
+}
+
+func (vl *Voter) pollKey(id int) string {
+	return vl.key(fmt.Sprintf("%s%d", PollKeyPrefix, id))
+}
+
+// checkPoll rejects a poll with the same option id twice or one that
+// closes before it opens, the validate tags can not express that
+func checkPoll(poll Poll) error {
+	if poll.OpensAt != nil && poll.ClosesAt != nil && !poll.OpensAt.Before(*poll.ClosesAt) {
+		return fmt.Errorf("%w: the poll has to open before it closes", ErrInvalid)
+	}
+	if poll.Ranked && poll.AllowWriteIns {
+		return fmt.Errorf("%w: a ranked poll can not take write-ins", ErrInvalid)
+	}
+	if poll.Ranked && poll.Weighted {
+		return fmt.Errorf("%w: a ranked poll can not be weighted", ErrInvalid)
+	}
+	if err := checkDecision(poll); err != nil {
+		return err
+	}
+
+	seen := make(map[int]bool)
+	for _, option := range poll.Options {
+		if seen[option.OptionId] {
+			return fmt.Errorf("%w: option %d is listed twice", ErrInvalid, option.OptionId)
+		}
+		seen[option.OptionId] = true
+	}
+	return nil
+}
+
+// AddPoll stores a new poll, ErrAlreadyExists if the id is taken
+func (vl *Voter) AddPoll(poll Poll) (err error) {
+	defer observe("AddPoll", time.Now(), &err)
+
+	if err := checkPoll(poll); err != nil {
+		return err
+	}
+	if err := vl.checkPrecincts(poll.Precincts...); err != nil {
+		return err
+	}
+	if err := vl.checkGroups(poll.Groups...); err != nil {
+		return err
+	}
+	pollBytes, err := json.Marshal(poll)
+	if err != nil {
+		return err
+	}
+
+	//NX makes the check for an existing poll and the write one command
+	err = vl.client.Do(vl.context, "JSON.SET", vl.pollKey(poll.PollId), ".", string(pollBytes), "NX").Err()
+	if isRedisNilError(err) {
+		return ErrAlreadyExists
+	}
+	if err != nil {
+		return err
+	}
+
+	return vl.client.ZAdd(vl.context, vl.key(PollIndexKey), redis.Z{Score: float64(poll.PollId), Member: strconv.Itoa(poll.PollId)}).Err()
+}
+
+// GetPoll returns one poll, ErrNotFound if there is none with the id
+func (vl *Voter) GetPoll(id int) (poll Poll, err error) {
+	defer observe("GetPoll", time.Now(), &err)
+
+	value, err := vl.client.Do(vl.context, "JSON.GET", vl.pollKey(id), ".").Text()
+	if err != nil {
+		if isRedisNilError(err) {
+			return Poll{}, ErrNotFound
+		}
+		return Poll{}, err
+	}
+
+	err = json.Unmarshal([]byte(value), &poll)
+	return poll, err
+}
+
+// GetAllPolls returns every poll ordered by id, read in one pipelined
+// round trip
+func (vl *Voter) GetAllPolls() (pollList []Poll, err error) {
+	defer observe("GetAllPolls", time.Now(), &err)
+
+	ids, err := vl.client.ZRange(vl.context, vl.key(PollIndexKey), 0, -1).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+
+	pipe := vl.client.Pipeline()
+	cmds := make([]*redis.Cmd, len(ids))
+	for i, id := range ids {
+		cmds[i] = pipe.Do(vl.context, "JSON.GET", vl.key(PollKeyPrefix+id), ".")
+	}
+	_, _ = pipe.Exec(vl.context)
+
+	for _, cmd := range cmds {
+		value, err := cmd.Text()
+		if isRedisNilError(err) {
+			//Deleted since the index was read
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var poll Poll
+		if err := json.Unmarshal([]byte(value), &poll); err != nil {
+			return nil, err
+		}
+		pollList = append(pollList, poll)
+	}
+
+	return pollList, nil
+}
+
+// UpdatePoll replaces a poll that must already exist
+func (vl *Voter) UpdatePoll(poll Poll) (err error) {
+	defer observe("UpdatePoll", time.Now(), &err)
+
+	if err := checkPoll(poll); err != nil {
+		return err
+	}
+	if err := vl.checkPrecincts(poll.Precincts...); err != nil {
+		return err
+	}
+	if err := vl.checkGroups(poll.Groups...); err != nil {
+		return err
+	}
+	pollBytes, err := json.Marshal(poll)
+	if err != nil {
+		return err
+	}
+
+	//XX only writes when the poll is there, so a missing one is not
+	//created by accident
+	err = vl.client.Do(vl.context, "JSON.SET", vl.pollKey(poll.PollId), ".", string(pollBytes), "XX").Err()
+	if isRedisNilError(err) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	//The options or the closing time may have changed, so may the results
+	vl.clearResults(poll.PollId, true)
+	return nil
+}
+
+// DeletePoll removes a poll, its result snapshots, ballot tokens and the
+// record of who was reminded of it.
+// Voter histories that refer to it are kept, they are a record of what
+// happened
+func (vl *Voter) DeletePoll(id int) (err error) {
+	defer observe("DeletePoll", time.Now(), &err)
+
+	numDeleted, err := vl.client.Del(vl.context, vl.pollKey(id)).Result()
+	if err != nil {
+		return err
+	}
+	if numDeleted == 0 {
+		return ErrNotFound
+	}
+
+	vl.clearResults(id, true)
+	vl.deleteSnapshots(id)
+	if err := vl.client.Del(vl.context, vl.ballotTokensKey(id)).Err(); err != nil {
+		vl.log.Error("Error deleting ballot tokens", "pollId", id, "error", err)
+	}
+	if err := vl.client.Del(vl.context, vl.remindedKey(id)).Err(); err != nil {
+		vl.log.Error("Error deleting reminded voters", "pollId", id, "error", err)
+	}
+	return vl.client.ZRem(vl.context, vl.key(PollIndexKey), strconv.Itoa(id)).Err()
+}
@@ -0,0 +1,207 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// PrecinctKeyPrefix is the prefix of the precinct JSON documents,
+	// precinct:<precinctId>.  The precinctId of a voter refers to one of them
+	PrecinctKeyPrefix = "precinct:"
+	// PrecinctIndexKey is a sorted set of every precinct id, scored by the
+	// id, like PollIndexKey
+	PrecinctIndexKey = "idx:precincts"
+)
+
+// Precinct is a voting district voters are assigned to.  District names
+// the larger area it is part of, if any
+type Precinct struct {
+	PrecinctId int    `json:"precinctId" xml:"precinctId" validate:"gt=0"`
+	Name       string `json:"name" xml:"name" validate:"required,max=200"`
+	District   string `json:"district,omitempty" xml:"district,omitempty" validate:"omitempty,max=100"`
+}
+
+func (vl *Voter) precinctKey(id int) string {
+	return vl.key(fmt.Sprintf("%s%d", PrecinctKeyPrefix, id))
+}
+
+// Eligible reports whether the voter may vote in the poll.  A poll without
+// precincts or groups is open to every voter.  Otherwise the voter has to
+// be assigned to one of its precincts, if it has any, and be in one of its
+// groups, if it has any.  groups holds the groups of the poll by id, see
+// GetGroupsById, one that is missing holds nobody
+func (p Poll) Eligible(voterItem VoterItem, groups map[int]VoterGroup) bool {
+	if len(p.Precincts) > 0 && !containsInt(p.Precincts, voterItem.PrecinctId) {
+		return false
+	}
+	if len(p.Groups) == 0 {
+		return true
+	}
+	for _, groupId := range p.Groups {
+		if group, found := groups[groupId]; found && group.Contains(voterItem) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPrecincts makes sure every id above zero names a stored precinct,
+// ErrInvalid otherwise, so voters and polls can not refer to one that
+// does not exist
+func (vl *Voter) checkPrecincts(ids ...int) error {
+	for _, id := range ids {
+		if id <= 0 {
+			continue
+		}
+		exists, err := vl.client.Exists(vl.context, vl.precinctKey(id)).Result()
+		if err != nil {
+			return err
+		}
+		if exists == 0 {
+			return fmt.Errorf("%w: precinct %d does not exist", ErrInvalid, id)
+		}
+	}
+	return nil
+}
+
+// AddPrecinct stores a new precinct, ErrAlreadyExists if the id is taken
+func (vl *Voter) AddPrecinct(precinct Precinct) (err error) {
+	defer observe("AddPrecinct", time.Now(), &err)
+
+	precinctBytes, err := json.Marshal(precinct)
+	if err != nil {
+		return err
+	}
+
+	err = vl.client.Do(vl.context, "JSON.SET", vl.precinctKey(precinct.PrecinctId), ".", string(precinctBytes), "NX").Err()
+	if isRedisNilError(err) {
+		return ErrAlreadyExists
+	}
+	if err != nil {
+		return err
+	}
+
+	return vl.client.ZAdd(vl.context, vl.key(PrecinctIndexKey), redis.Z{Score: float64(precinct.PrecinctId), Member: strconv.Itoa(precinct.PrecinctId)}).Err()
+}
+
+// GetPrecinct returns one precinct, ErrNotFound if there is none with the id
+func (vl *Voter) GetPrecinct(id int) (precinct Precinct, err error) {
+	defer observe("GetPrecinct", time.Now(), &err)
+
+	value, err := vl.client.Do(vl.context, "JSON.GET", vl.precinctKey(id), ".").Text()
+	if err != nil {
+		if isRedisNilError(err) {
+			return Precinct{}, ErrNotFound
+		}
+		return Precinct{}, err
+	}
+
+	err = json.Unmarshal([]byte(value), &precinct)
+	return precinct, err
+}
+
+// GetAllPrecincts returns every precinct ordered by id
+func (vl *Voter) GetAllPrecincts() (precinctList []Precinct, err error) {
+	defer observe("GetAllPrecincts", time.Now(), &err)
+
+	ids, err := vl.client.ZRange(vl.context, vl.key(PrecinctIndexKey), 0, -1).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+
+	pipe := vl.client.Pipeline()
+	cmds := make([]*redis.Cmd, len(ids))
+	for i, id := range ids {
+		cmds[i] = pipe.Do(vl.context, "JSON.GET", vl.key(PrecinctKeyPrefix+id), ".")
+	}
+	_, _ = pipe.Exec(vl.context)
+
+	for _, cmd := range cmds {
+		value, err := cmd.Text()
+		if isRedisNilError(err) {
+			//Deleted since the index was read
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var precinct Precinct
+		if err := json.Unmarshal([]byte(value), &precinct); err != nil {
+			return nil, err
+		}
+		precinctList = append(precinctList, precinct)
+	}
+
+	return precinctList, nil
+}
+
+// UpdatePrecinct replaces a precinct that must already exist
+func (vl *Voter) UpdatePrecinct(precinct Precinct) (err error) {
+	defer observe("UpdatePrecinct", time.Now(), &err)
+
+	precinctBytes, err := json.Marshal(precinct)
+	if err != nil {
+		return err
+	}
+
+	err = vl.client.Do(vl.context, "JSON.SET", vl.precinctKey(precinct.PrecinctId), ".", string(precinctBytes), "XX").Err()
+	if isRedisNilError(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// DeletePrecinct removes a precinct.  One that still has voters assigned
+// is ErrConflict, they have to be moved first so no voter is left pointing
+// at nothing.  Polls restricted to it are left alone
+func (vl *Voter) DeletePrecinct(id int) (err error) {
+	defer observe("DeletePrecinct", time.Now(), &err)
+
+	voterList, err := vl.GetPrecinctVoters(id)
+	if err != nil {
+		return err
+	}
+	if len(voterList) > 0 {
+		return fmt.Errorf("%w: precinct %d still has %d voters", ErrConflict, id, len(voterList))
+	}
+
+	numDeleted, err := vl.client.Del(vl.context, vl.precinctKey(id)).Result()
+	if err != nil {
+		return err
+	}
+	if numDeleted == 0 {
+		return ErrNotFound
+	}
+
+	return vl.client.ZRem(vl.context, vl.key(PrecinctIndexKey), strconv.Itoa(id)).Err()
+}
+
+// GetPrecinctVoters returns the voters assigned to a precinct ordered by
+// id, ErrNotFound if the precinct does not exist
+func (vl *Voter) GetPrecinctVoters(id int) (voterList []VoterItem, err error) {
+	defer observe("GetPrecinctVoters", time.Now(), &err)
+
+	if _, err := vl.GetPrecinct(id); err != nil {
+		return nil, err
+	}
+
+	allVoters, err := vl.GetAllVoters()
+	if err != nil {
+		return nil, err
+	}
+	for _, voterItem := range allVoters {
+		if voterItem.PrecinctId == id {
+			voterList = append(voterList, voterItem)
+		}
+	}
+	sort.Slice(voterList, func(i, j int) bool { return voterList[i].VoterId < voterList[j].VoterId })
+
+	return voterList, nil
+}
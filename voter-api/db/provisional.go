@@ -0,0 +1,88 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// GetProvisionalVotes returns the provisional votes waiting for a decision
+// ordered by id, those of one poll when pollId is above zero
+func (vl *Voter) GetProvisionalVotes(pollId int) (voteList []Vote, err error) {
+	defer observe("GetProvisionalVotes", time.Now(), &err)
+
+	allVotes, err := vl.GetAllVotes()
+	if err != nil {
+		return nil, err
+	}
+	voteList = []Vote{}
+	for _, vote := range allVotes {
+		if vote.Provisional && (pollId <= 0 || vote.PollId == pollId) {
+			voteList = append(voteList, vote)
+		}
+	}
+	sort.Slice(voteList, func(i, j int) bool { return voteList[i].VoteId < voteList[j].VoteId })
+	return voteList, nil
+}
+
+// provisionalVote returns a vote that has to be provisional, ErrConflict
+// for one that was counted already
+func (vl *Voter) provisionalVote(voteId int) (Vote, error) {
+	vote, err := vl.GetVote(voteId)
+	if err != nil {
+		return Vote{}, err
+	}
+	if !vote.Provisional {
+		return Vote{}, fmt.Errorf("%w: vote %d is not provisional", ErrConflict, voteId)
+	}
+	return vote, nil
+}
+
+// AcceptProvisionalVote counts a provisional vote from now on.  The results
+// of its poll are tallied again, frozen ones too, adjudication usually
+// happens after the poll closed and the final count has to include it
+func (vl *Voter) AcceptProvisionalVote(voteId int) (vote Vote, err error) {
+	defer observe("AcceptProvisionalVote", time.Now(), &err)
+
+	vote, err = vl.provisionalVote(voteId)
+	if err != nil {
+		return Vote{}, err
+	}
+
+	if _, err := vl.jsonHelper.JSONSet(vl.voteKey(voteId), ".provisional", false); err != nil {
+		return Vote{}, err
+	}
+	_, _, err = vl.updateHistory(vote.VoterId, func(voterItem *VoterItem) error {
+		for i, history := range voterItem.VoteHistory {
+			if history.VoteId == voteId {
+				voterItem.VoteHistory[i].Provisional = false
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return Vote{}, err
+	}
+
+	vote.Provisional = false
+	vl.clearResults(vote.PollId, true)
+	vl.emit(EventVoterUpdated, vote.VoterId, vote.PollId)
+	return vote, nil
+}
+
+// RejectProvisionalVote deletes a provisional vote and its history entry,
+// the voter can vote in the poll again once they are allowed to
+func (vl *Voter) RejectProvisionalVote(voteId int) (vote Vote, err error) {
+	defer observe("RejectProvisionalVote", time.Now(), &err)
+
+	vote, err = vl.provisionalVote(voteId)
+	if err != nil {
+		return Vote{}, err
+	}
+	if err := vl.DeleteVote(voteId); err != nil {
+		return Vote{}, err
+	}
+	vl.emit(EventVoterUpdated, vote.VoterId, vote.PollId)
+	return vote, nil
+}
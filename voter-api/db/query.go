@@ -0,0 +1,125 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Fields the voter list can be sorted by, and the sort orders
+const (
+	SortById    = "id"
+	SortByName  = "name"
+	SortByEmail = "email"
+
+	OrderAsc  = "asc"
+	OrderDesc = "desc"
+)
+
+// ErrInvalidQuery is returned by GetVoters for an unknown sort field or order
+var ErrInvalidQuery = fmt.Errorf("%w voter query", ErrInvalid)
+
+// VoterQuery filters and sorts the voter list.  Empty fields are ignored,
+// so the zero value returns every voter in id order.  GroupId keeps the
+// voters in that group, see VoterGroup
+type VoterQuery struct {
+	Email        string
+	NameContains string
+	GroupId      int
+	Sort         string
+	Order        string
+}
+
+// GetVoters returns the voters matching query.  An email filter is
+// answered from the email index so only the matching voters are read,
+// the name filter is case insensitive
+func (vl *Voter) GetVoters(query VoterQuery) (voterList []VoterItem, err error) {
+	defer observe("GetVoters", time.Now(), &err)
+
+	if query.Sort == "" {
+		query.Sort = SortById
+	}
+	if query.Order == "" {
+		query.Order = OrderAsc
+	}
+	if query.Sort != SortById && query.Sort != SortByName && query.Sort != SortByEmail {
+		return nil, ErrInvalidQuery
+	}
+	if query.Order != OrderAsc && query.Order != OrderDesc {
+		return nil, ErrInvalidQuery
+	}
+
+	var group *VoterGroup
+	if query.GroupId != 0 {
+		found, err := vl.GetGroup(query.GroupId)
+		if errors.Is(err, ErrNotFound) {
+			return nil, fmt.Errorf("%w: group %d does not exist", ErrInvalidQuery, query.GroupId)
+		}
+		if err != nil {
+			return nil, err
+		}
+		group = &found
+	}
+
+	var candidates []VoterItem
+	if query.Email != "" {
+		ids, err := vl.GetVoterIdsByEmail(query.Email)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			var voterItem VoterItem
+			if err := vl.getVoterFromRedis(vl.redisKeyFromId(id), &voterItem); err != nil {
+				if errors.Is(err, ErrNotFound) {
+					continue
+				}
+				return nil, err
+			}
+			candidates = append(candidates, voterItem)
+		}
+	} else if group != nil {
+		//A manual group names its voters, there is no need to read them all
+		candidates, err = vl.groupCandidates(*group)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		candidates, err = vl.GetAllVoters()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	nameContains := strings.ToLower(query.NameContains)
+	for _, voterItem := range candidates {
+		if nameContains != "" && !strings.Contains(strings.ToLower(voterItem.Name), nameContains) {
+			continue
+		}
+		if group != nil && !group.Contains(voterItem) {
+			continue
+		}
+		voterList = append(voterList, voterItem)
+	}
+
+	sort.SliceStable(voterList, func(i, j int) bool {
+		a, b := voterList[i], voterList[j]
+		if query.Order == OrderDesc {
+			a, b = b, a
+		}
+		switch query.Sort {
+		case SortByName:
+			if !strings.EqualFold(a.Name, b.Name) {
+				return strings.ToLower(a.Name) < strings.ToLower(b.Name)
+			}
+		case SortByEmail:
+			if NormalizeEmail(a.Email) != NormalizeEmail(b.Email) {
+				return NormalizeEmail(a.Email) < NormalizeEmail(b.Email)
+			}
+		}
+		return a.VoterId < b.VoterId
+	})
+
+	return voterList, nil
+}
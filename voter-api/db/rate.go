@@ -0,0 +1,100 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// VoteRateKeyPrefix is the prefix of the per poll vote rate series,
+// stats:rate:<pollId> is a hash of the unix time a minute starts at to the
+// number of votes recorded in that minute.  Unlike the turnout series it
+// goes by when the vote was recorded, not by the date it carries, so a
+// burst of backdated votes shows up too
+const VoteRateKeyPrefix = "stats:rate:"
+
+// VoteRateRetention is how long the vote rate of a poll is kept after its
+// last vote
+const VoteRateRetention = 7 * 24 * time.Hour
+
+// VoteRateBucket is the smallest interval the vote rate is kept in
+const VoteRateBucket = time.Minute
+
+func (vl *Voter) voteRateKey(pollId int) string {
+	return vl.key(fmt.Sprintf("%s%d", VoteRateKeyPrefix, pollId))
+}
+
+// countVoteRate counts a vote recorded now in the vote rate of its poll.
+// A failure is logged, the vote is recorded by then
+func (vl *Voter) countVoteRate(pollId int, now time.Time) {
+	key := vl.voteRateKey(pollId)
+	pipe := vl.client.TxPipeline()
+	pipe.HIncrBy(vl.context, key, strconv.FormatInt(now.UTC().Truncate(VoteRateBucket).Unix(), 10), 1)
+	pipe.Expire(vl.context, key, VoteRateRetention)
+	if _, err := pipe.Exec(vl.context); err != nil {
+		vl.log.Error("Error counting vote rate", "pollId", pollId, "error", err)
+	}
+}
+
+// VoteRate is the number of votes recorded in a poll per interval over the
+// buckets intervals up to now, the oldest first.  Intervals without votes
+// are in Points too so a spike stands out against them, Peak is the
+// busiest one
+type VoteRate struct {
+	PollId   int            `json:"pollId"`
+	Interval string         `json:"interval"`
+	Total    int            `json:"total"`
+	Average  float64        `json:"average"`
+	Peak     *TurnoutPoint  `json:"peak,omitempty"`
+	Points   []TurnoutPoint `json:"points"`
+}
+
+// GetVoteRate returns the vote rate of a poll over the last buckets
+// intervals, interval a multiple of VoteRateBucket
+func (vl *Voter) GetVoteRate(pollId int, interval time.Duration, buckets int, now time.Time) (rate VoteRate, err error) {
+	defer observe("GetVoteRate", time.Now(), &err)
+
+	if interval < VoteRateBucket || interval%VoteRateBucket != 0 {
+		return VoteRate{}, fmt.Errorf("%w: interval must be a multiple of %s", ErrInvalidQuery, VoteRateBucket)
+	}
+	if buckets <= 0 {
+		return VoteRate{}, fmt.Errorf("%w: buckets must be positive", ErrInvalidQuery)
+	}
+
+	counts, err := vl.client.HGetAll(vl.context, vl.voteRateKey(pollId)).Result()
+	if err != nil {
+		return VoteRate{}, err
+	}
+
+	last := now.UTC().Truncate(interval)
+	first := last.Add(-time.Duration(buckets-1) * interval)
+	rate = VoteRate{PollId: pollId, Interval: interval.String(), Points: make([]TurnoutPoint, buckets)}
+	for i := range rate.Points {
+		rate.Points[i].Start = first.Add(time.Duration(i) * interval)
+	}
+	for field, value := range counts {
+		start, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			continue
+		}
+		count, err := strconv.Atoi(value)
+		if err != nil {
+			continue
+		}
+		at := time.Unix(start, 0).UTC()
+		if at.Before(first) || at.After(now) {
+			continue
+		}
+		rate.Points[int(at.Sub(first)/interval)].Votes += count
+		rate.Total += count
+	}
+
+	for i, point := range rate.Points {
+		if point.Votes > 0 && (rate.Peak == nil || point.Votes > rate.Peak.Votes) {
+			rate.Peak = &rate.Points[i]
+		}
+	}
+	rate.Average = float64(rate.Total) / float64(buckets)
+
+	return rate, nil
+}
@@ -0,0 +1,55 @@
+package db
+
+import (
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RateLimitKeyPrefix is the prefix of the token bucket hashes, one per
+// client and limit
+const RateLimitKeyPrefix = "ratelimit:"
+
+// tokenBucketScript refills a bucket for the time since it was last used
+// and takes a token if there is one.  It runs inside redis so every
+// replica shares the same buckets and two requests can not both take the
+// last token.  The clock is redis' own, so replicas with skewed clocks
+// still agree.  It returns {allowed, milliseconds until a token is free}
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(bucket[1]) or burst
+local ts = tonumber(bucket[2]) or now
+tokens = math.min(burst, tokens + (now - ts) / 1000 * rate)
+
+local allowed = 0
+local retry = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry = math.ceil((1 - tokens) / rate * 1000)
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+return {allowed, retry}
+`)
+
+// TakeToken takes a token from the bucket named key, which refills at
+// rate tokens a second up to burst.  When the bucket is empty allowed is
+// false and retryAfter is how long until the next token
+func (vl *Voter) TakeToken(key string, rate float64, burst int) (allowed bool, retryAfter time.Duration, err error) {
+	defer observe("TakeToken", time.Now(), &err)
+
+	result, err := tokenBucketScript.Run(vl.context, vl.client, []string{RateLimitKeyPrefix + key}, rate, burst).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+
+	return result[0] == 1, time.Duration(result[1]) * time.Millisecond, nil
+}
@@ -0,0 +1,102 @@
+package db
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ReceiptKeyPrefix is the prefix of the vote receipts,
+// receipt:<voterId>:<pollId>
+const ReceiptKeyPrefix = "receipt:"
+
+// Receipt is what a voter gets back for a recorded vote.  Hash is the hex
+// SHA-256 of "<salt>|<voterId>|<pollId>|<recordedAt>", the time in RFC 3339
+// with nanoseconds, so the voter can recompute it, and Signature the
+// signature of the server over the Hash.  The salt keeps the hash from
+// being guessed from the ids.  Counted is filled in when the receipt is
+// read and tells whether the vote is still in the history of the voter
+type Receipt struct {
+	VoterId    int       `json:"voterId"`
+	PollId     int       `json:"pollId"`
+	RecordedAt time.Time `json:"recordedAt"`
+	Salt       string    `json:"salt"`
+	Hash       string    `json:"hash"`
+	Signature  string    `json:"signature"`
+	Counted    bool      `json:"counted"`
+}
+
+func (vl *Voter) receiptKey(voterId int, pollId int) string {
+	return vl.key(fmt.Sprintf("%s%d:%d", ReceiptKeyPrefix, voterId, pollId))
+}
+
+// NewReceipt returns the unsigned receipt for a vote of voterId in pollId
+// recorded at recordedAt, with a fresh random salt
+func NewReceipt(voterId int, pollId int, recordedAt time.Time) (Receipt, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return Receipt{}, err
+	}
+
+	receipt := Receipt{
+		VoterId:    voterId,
+		PollId:     pollId,
+		RecordedAt: recordedAt.UTC(),
+		Salt:       hex.EncodeToString(salt),
+	}
+	receipt.Hash = receipt.ComputeHash()
+	return receipt, nil
+}
+
+// ComputeHash is the hash of the receipt fields, see Receipt
+func (r Receipt) ComputeHash() string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%d|%s", r.Salt, r.VoterId, r.PollId, r.RecordedAt.UTC().Format(time.RFC3339Nano))))
+	return hex.EncodeToString(sum[:])
+}
+
+// SaveReceipt stores the receipt of a vote, replacing the one of an
+// earlier vote in the same poll that was deleted since
+func (vl *Voter) SaveReceipt(receipt Receipt) (err error) {
+	defer observe("SaveReceipt", time.Now(), &err)
+
+	receipt.Counted = false
+	value, err := json.Marshal(receipt)
+	if err != nil {
+		return err
+	}
+	return vl.client.Set(vl.context, vl.receiptKey(receipt.VoterId, receipt.PollId), value, 0).Err()
+}
+
+// GetReceipt returns the receipt of the vote of a voter in a poll,
+// ErrNotFound if none was issued.  It is kept when the vote is deleted,
+// Counted is false then
+func (vl *Voter) GetReceipt(voterId int, pollId int) (receipt Receipt, err error) {
+	defer observe("GetReceipt", time.Now(), &err)
+
+	value, err := vl.client.Get(vl.context, vl.receiptKey(voterId, pollId)).Bytes()
+	if isRedisNilError(err) {
+		return Receipt{}, ErrNotFound
+	}
+	if err != nil {
+		return Receipt{}, err
+	}
+	if err := json.Unmarshal(value, &receipt); err != nil {
+		return Receipt{}, err
+	}
+
+	voterItem, err := vl.GetVoter(voterId)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return Receipt{}, err
+	}
+	for _, history := range voterItem.VoteHistory {
+		if history.PollId == pollId {
+			receipt.Counted = true
+			break
+		}
+	}
+	return receipt, nil
+}
@@ -0,0 +1,126 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RemindedKeyPrefix is the prefix of the sets of voters that were reminded
+// of a poll, reminded:<pollId>
+const RemindedKeyPrefix = "reminded:"
+
+func (vl *Voter) remindedKey(pollId int) string {
+	return vl.key(fmt.Sprintf("%s%d", RemindedKeyPrefix, pollId))
+}
+
+// Reminder is a voter that has not voted yet in a poll that is about to
+// close.  It carries what a reminder message needs, Tenant is the tenant
+// of the voter and the poll
+type Reminder struct {
+	Tenant   string    `json:"tenant,omitempty"`
+	VoterId  int       `json:"voterId"`
+	Name     string    `json:"name"`
+	Email    string    `json:"email"`
+	Phone    string    `json:"phone,omitempty"`
+	PollId   int       `json:"pollId"`
+	Title    string    `json:"title"`
+	ClosesAt time.Time `json:"closesAt"`
+}
+
+// ClaimReminders finds, in every tenant, the active voters that could vote
+// in an open poll closing within lead of now and have not, leaving out the
+// ones that opted out of email, and claims them: each voter is added to
+// the reminded set of the poll and only the ones that were not in it yet
+// are returned, so nobody is reminded twice, not even by two replicas at
+// once.  A poll.reminder event is published for every claimed voter,
+// sending the email is up to the caller
+func (vl *Voter) ClaimReminders(now time.Time, lead time.Duration) (reminders []Reminder, err error) {
+	defer observe("ClaimReminders", time.Now(), &err)
+
+	tenants, err := vl.storedTenants()
+	if err != nil {
+		return nil, err
+	}
+	for _, tenant := range tenants {
+		claimed, err := vl.WithTenant(tenant).claimTenantReminders(now, lead)
+		if err != nil {
+			return reminders, fmt.Errorf("tenant %q: %w", tenant, err)
+		}
+		reminders = append(reminders, claimed...)
+	}
+	return reminders, nil
+}
+
+// claimTenantReminders is ClaimReminders for the tenant of vl
+func (vl *Voter) claimTenantReminders(now time.Time, lead time.Duration) ([]Reminder, error) {
+	pollList, err := vl.GetAllPolls()
+	if err != nil {
+		return nil, err
+	}
+	var due []Poll
+	for _, poll := range pollList {
+		if poll.ClosesAt != nil && poll.Window(now) == PollOpen && poll.ClosesAt.Sub(now) <= lead {
+			due = append(due, poll)
+		}
+	}
+	if len(due) == 0 {
+		return nil, nil
+	}
+
+	voterList, err := vl.GetAllVoters()
+	if err != nil {
+		return nil, err
+	}
+	groupList, err := vl.GetAllGroups()
+	if err != nil {
+		return nil, err
+	}
+	groups := make(map[int]VoterGroup, len(groupList))
+	for _, group := range groupList {
+		groups[group.GroupId] = group
+	}
+
+	var reminders []Reminder
+	for _, poll := range due {
+		var candidates []VoterItem
+		for _, voterItem := range voterList {
+			if voterItem.Active() && voterItem.Email != "" && !voterItem.EmailOptOut && poll.Eligible(voterItem, groups) && !votedIn(voterItem.VoteHistory, poll.PollId) {
+				candidates = append(candidates, voterItem)
+			}
+		}
+		if len(candidates) == 0 {
+			continue
+		}
+
+		//SADD tells us which voters were not reminded yet
+		pipe := vl.client.Pipeline()
+		added := make([]*redis.IntCmd, len(candidates))
+		for i, voterItem := range candidates {
+			added[i] = pipe.SAdd(vl.context, vl.remindedKey(poll.PollId), strconv.Itoa(voterItem.VoterId))
+		}
+		if _, err := pipe.Exec(vl.context); err != nil {
+			return reminders, err
+		}
+
+		for i, voterItem := range candidates {
+			if added[i].Val() == 0 {
+				continue
+			}
+			reminders = append(reminders, Reminder{
+				Tenant:   vl.tenant,
+				VoterId:  voterItem.VoterId,
+				Name:     voterItem.Name,
+				Email:    voterItem.Email,
+				Phone:    voterItem.Phone,
+				PollId:   poll.PollId,
+				Title:    poll.Title,
+				ClosesAt: *poll.ClosesAt,
+			})
+			vl.emit(EventPollReminder, voterItem.VoterId, poll.PollId)
+		}
+	}
+	return reminders, nil
+}
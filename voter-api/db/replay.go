@@ -0,0 +1,88 @@
+package db
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// ReplayKeyPrefix is the prefix of stored request captures
+	ReplayKeyPrefix = "replay:capture:"
+	// ReplayIndexKey is a sorted set of capture ids scored by capture time
+	ReplayIndexKey = "replay:index"
+	// ReplayTTL is how long captures are kept
+	ReplayTTL = 7 * 24 * time.Hour
+)
+
+// CapturedRequest is the (redacted) request half of a replay capture
+type CapturedRequest struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Query   string            `json:"query,omitempty"`
+	Headers map[string]string `json:"headers"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+// CapturedResponse is the (redacted) response half of a replay capture
+type CapturedResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+// ReplayCapture is a full request/response pair recorded for a bug report
+type ReplayCapture struct {
+	Id         string           `json:"id"`
+	CapturedAt time.Time        `json:"capturedAt"`
+	DurationMs float64          `json:"durationMs"`
+	Request    CapturedRequest  `json:"request"`
+	Response   CapturedResponse `json:"response"`
+}
+
+// SaveReplayCapture stores a capture, it expires after ReplayTTL
+func (vl *Voter) SaveReplayCapture(capture ReplayCapture) (err error) {
+	defer observe("SaveReplayCapture", time.Now(), &err)
+
+	captureBytes, err := json.Marshal(capture)
+	if err != nil {
+		return err
+	}
+
+	pipe := vl.client.TxPipeline()
+	pipe.Set(vl.context, ReplayKeyPrefix+capture.Id, captureBytes, ReplayTTL)
+	pipe.ZAdd(vl.context, ReplayIndexKey, redis.Z{
+		Score:  float64(capture.CapturedAt.Unix()),
+		Member: capture.Id,
+	})
+	//Forget index entries whose capture has already expired
+	pipe.ZRemRangeByScore(vl.context, ReplayIndexKey, "-inf",
+		strconv.FormatInt(time.Now().Add(-ReplayTTL).Unix(), 10))
+	_, err = pipe.Exec(vl.context)
+	return err
+}
+
+// GetReplayCapture returns one stored capture
+func (vl *Voter) GetReplayCapture(id string) (capture ReplayCapture, err error) {
+	defer observe("GetReplayCapture", time.Now(), &err)
+
+	value, err := vl.client.Get(vl.context, ReplayKeyPrefix+id).Result()
+	if err != nil {
+		if isRedisNilError(err) {
+			return ReplayCapture{}, ErrNotFound
+		}
+		return ReplayCapture{}, err
+	}
+
+	err = json.Unmarshal([]byte(value), &capture)
+	return capture, err
+}
+
+// GetReplayCaptureIds returns the ids of the most recent captures, newest first
+func (vl *Voter) GetReplayCaptureIds(limit int) (ids []string, err error) {
+	defer observe("GetReplayCaptureIds", time.Now(), &err)
+
+	return vl.client.ZRevRange(vl.context, ReplayIndexKey, 0, int64(limit-1)).Result()
+}
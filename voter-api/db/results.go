@@ -0,0 +1,298 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// ResultsKeyPrefix is the prefix of the cached poll results,
+	// results:<pollId>, and of the frozen ones, results:<pollId>:frozen.
+	// Instant-runoff results go in results:<pollId>:irv and so on
+	ResultsKeyPrefix = "results:"
+	// ResultsCacheTTL is how long a tally is served from the cache.  Every
+	// vote clears it anyway, the TTL only bounds how stale it can get if
+	// clearing failed
+	ResultsCacheTTL = 30 * time.Second
+)
+
+// How the votes of a poll are counted.  MethodPlurality counts the option
+// each vote picked, MethodIRV runs an instant-runoff over the rankings
+const (
+	MethodPlurality = "plurality"
+	MethodIRV       = "irv"
+)
+
+// ValidMethod reports whether poll results can be counted with method
+func ValidMethod(method string) bool {
+	return method == MethodPlurality || method == MethodIRV
+}
+
+// resultMethods are the methods whose cached results clearResults drops
+var resultMethods = []string{MethodPlurality, MethodIRV}
+
+// OptionResult is the number of votes one option of a poll got, in a
+// Weighted poll also what they weigh together
+type OptionResult struct {
+	OptionId int     `json:"optionId"`
+	Text     string  `json:"text"`
+	Votes    int     `json:"votes"`
+	Weight   float64 `json:"weight,omitempty"`
+}
+
+// WriteInResult is the number of votes for write-ins that only differ in
+// case and spacing, Text is the spelling most of them used
+type WriteInResult struct {
+	Text   string  `json:"text"`
+	Votes  int     `json:"votes"`
+	Weight float64 `json:"weight,omitempty"`
+}
+
+// PollResults is the tally of a poll.  Frozen results were taken when the
+// poll closed and do not change any more.  For MethodIRV Options are the
+// first preferences and Rounds the runoff, see tallyIRV.  A Weighted poll
+// also adds up the weights of the votes, see Poll.Weighted.  Delegated
+// are the votes among them counted by proxy, DelegationCycles the
+// delegators left out because their delegates delegate in a loop, see
+// delegatedVotes.  Outcome is only there for a poll with DecisionRules, it
+// is not cached with the tally
+type PollResults struct {
+	PollId           int             `json:"pollId"`
+	Method           string          `json:"method"`
+	TotalVotes       int             `json:"totalVotes"`
+	Weighted         bool            `json:"weighted,omitempty"`
+	TotalWeight      float64         `json:"totalWeight,omitempty"`
+	Provisional      int             `json:"provisional,omitempty"`
+	Delegated        int             `json:"delegated,omitempty"`
+	DelegationCycles int             `json:"delegationCycles,omitempty"`
+	Options          []OptionResult  `json:"options"`
+	WriteIns         []WriteInResult `json:"writeIns,omitempty"`
+	Rounds           []RunoffRound   `json:"rounds,omitempty"`
+	WinnerId         int             `json:"winnerId,omitempty"`
+	Frozen           bool            `json:"frozen"`
+	TalliedAt        time.Time       `json:"talliedAt"`
+	Outcome          *PollOutcome    `json:"outcome,omitempty"`
+}
+
+func (vl *Voter) resultsKey(pollId int, method string) string {
+	key := vl.key(fmt.Sprintf("%s%d", ResultsKeyPrefix, pollId))
+	if method != MethodPlurality {
+		key += ":" + method
+	}
+	return key
+}
+
+func (vl *Voter) frozenResultsKey(pollId int, method string) string {
+	return vl.resultsKey(pollId, method) + ":frozen"
+}
+
+// GetPollResults returns the tally of a poll counted with method, from the
+// cache when it is fresh.  With freeze the results of a poll that has
+// closed are kept for good, votes changed after ClosesAt no longer move
+// them
+func (vl *Voter) GetPollResults(poll Poll, method string, freeze bool) (results PollResults, err error) {
+	defer observe("GetPollResults", time.Now(), &err)
+
+	if !ValidMethod(method) {
+		return PollResults{}, fmt.Errorf("%w: unknown method %q", ErrInvalid, method)
+	}
+
+	for _, key := range []string{vl.frozenResultsKey(poll.PollId, method), vl.resultsKey(poll.PollId, method)} {
+		value, err := vl.client.Get(vl.context, key).Bytes()
+		if err == nil {
+			err = json.Unmarshal(value, &results)
+			return results, err
+		}
+		if !isRedisNilError(err) {
+			return PollResults{}, err
+		}
+	}
+
+	results, err = vl.tallyPoll(poll, method)
+	if err != nil {
+		return PollResults{}, err
+	}
+
+	key, ttl := vl.resultsKey(poll.PollId, method), ResultsCacheTTL
+	if freeze && poll.Closed(results.TalliedAt) {
+		results.Frozen = true
+		key, ttl = vl.frozenResultsKey(poll.PollId, method), 0
+	}
+	value, err := json.Marshal(results)
+	if err != nil {
+		return PollResults{}, err
+	}
+	if err := vl.client.Set(vl.context, key, value, ttl).Err(); err != nil {
+		//The tally is right, it just has to be counted again next time
+		vl.log.Error("Error caching poll results", "pollId", poll.PollId, "error", err)
+	}
+
+	return results, nil
+}
+
+// tallyPoll counts the votes of a poll by option, with the votes recorded
+// only in a voter history and the votes of voters that delegated theirs.
+// Options appear in the order of the poll,
+// votes for an option that was removed since are only in the total.  Write-ins are grouped by writeInKey, most votes first
+func (vl *Voter) tallyPoll(poll Poll, method string) (PollResults, error) {
+	voteList, err := vl.GetAllVotes()
+	if err != nil {
+		return PollResults{}, err
+	}
+
+	counts := make(map[int]int)
+	weights := make(map[int]float64)
+	writeInWeights := make(map[string]float64)
+	spellings := make(map[string]map[string]int)
+	var ballots [][]int
+	results := PollResults{PollId: poll.PollId, Method: method, Weighted: poll.Weighted, TalliedAt: time.Now().UTC()}
+	count := func(vote Vote) {
+		results.TotalVotes++
+		if poll.Weighted {
+			results.TotalWeight += vote.weight()
+		}
+		ballots = append(ballots, vote.preferences())
+		if vote.WriteIn == "" {
+			counts[vote.VoteValue]++
+			if poll.Weighted {
+				weights[vote.VoteValue] += vote.weight()
+			}
+			return
+		}
+		key := writeInKey(vote.WriteIn)
+		if poll.Weighted {
+			writeInWeights[key] += vote.weight()
+		}
+		if spellings[key] == nil {
+			spellings[key] = make(map[string]int)
+		}
+		spellings[key][NormalizeWriteIn(vote.WriteIn)]++
+	}
+
+	voted := make(map[int]Vote)
+	for _, vote := range voteList {
+		if vote.PollId != poll.PollId {
+			continue
+		}
+		if vote.VoterId != 0 {
+			voted[vote.VoterId] = vote
+		}
+		if vote.Provisional {
+			//Only counted once accepted, see AcceptProvisionalVote
+			results.Provisional++
+			continue
+		}
+		count(vote)
+	}
+
+	recorded, err := vl.historyVotes(poll, voted)
+	if err != nil {
+		return PollResults{}, err
+	}
+	for _, vote := range recorded {
+		voted[vote.VoterId] = vote
+		if vote.Provisional {
+			results.Provisional++
+			continue
+		}
+		count(vote)
+	}
+
+	proxies, cycles, err := vl.delegatedVotes(poll, voted)
+	if err != nil {
+		return PollResults{}, err
+	}
+	for _, vote := range proxies {
+		results.Delegated++
+		count(vote)
+	}
+	results.DelegationCycles = cycles
+
+	for key, group := range spellings {
+		writeIn := WriteInResult{Weight: writeInWeights[key]}
+		top := 0
+		for text, votes := range group {
+			writeIn.Votes += votes
+			if votes > top || (votes == top && text < writeIn.Text) {
+				writeIn.Text, top = text, votes
+			}
+		}
+		results.WriteIns = append(results.WriteIns, writeIn)
+	}
+	sort.Slice(results.WriteIns, func(i, j int) bool {
+		if results.WriteIns[i].Votes != results.WriteIns[j].Votes {
+			return results.WriteIns[i].Votes > results.WriteIns[j].Votes
+		}
+		return results.WriteIns[i].Text < results.WriteIns[j].Text
+	})
+
+	results.Options = make([]OptionResult, 0, len(poll.Options))
+	for _, option := range poll.Options {
+		results.Options = append(results.Options, OptionResult{
+			OptionId: option.OptionId,
+			Text:     option.Text,
+			Votes:    counts[option.OptionId],
+			Weight:   weights[option.OptionId],
+		})
+	}
+
+	if method == MethodIRV {
+		results.Rounds, results.WinnerId = tallyIRV(poll, ballots)
+	}
+	return results, nil
+}
+
+// historyVotes are the votes for a poll recorded only in the history of
+// a voter, with POST /voters/:id/polls/:pollid, so without one in the
+// votes store.  They count for the weight the voter has now, a secret
+// ballot is only ever in the votes store
+func (vl *Voter) historyVotes(poll Poll, voted map[int]Vote) ([]Vote, error) {
+	if poll.Anonymous {
+		return nil, nil
+	}
+	voterList, err := vl.GetAllVoters()
+	if err != nil {
+		return nil, err
+	}
+
+	var votes []Vote
+	for _, voterItem := range voterList {
+		if _, found := voted[voterItem.VoterId]; found {
+			continue
+		}
+		for _, vh := range voterItem.VoteHistory {
+			if vh.PollId != poll.PollId || vh.Anonymous {
+				continue
+			}
+			votes = append(votes, Vote{
+				VoterId:     voterItem.VoterId,
+				PollId:      poll.PollId,
+				VoteValue:   vh.OptionId,
+				Ranking:     vh.Ranking,
+				VoteDate:    vh.VoteDate,
+				Provisional: vh.Provisional,
+				Weight:      voterItem.VoteWeight(),
+			})
+			break
+		}
+	}
+	return votes, nil
+}
+
+// clearResults drops the cached tally of a poll after one of its votes
+// changed.  The frozen results stay unless frozen is set, which is for
+// changes to the poll itself
+func (vl *Voter) clearResults(pollId int, frozen bool) {
+	var keys []string
+	for _, method := range resultMethods {
+		keys = append(keys, vl.resultsKey(pollId, method))
+		if frozen {
+			keys = append(keys, vl.frozenResultsKey(pollId, method))
+		}
+	}
+	if err := vl.client.Del(vl.context, keys...).Err(); err != nil {
+		vl.log.Error("Error clearing poll results", "pollId", pollId, "error", err)
+	}
+}
@@ -0,0 +1,163 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// RetentionPolicy is how much vote history a voter keeps.  Entries older
+// than MaxAge are dropped, and past MaxEntries the oldest ones are.  0
+// leaves that limit off.  Whatever the policy, the entries of polls that
+// are still open or exempt from retention, see Poll.RetentionExempt, are
+// kept and do not count towards MaxEntries: dropping the vote of an open
+// poll would let the voter vote again
+type RetentionPolicy struct {
+	MaxAge     time.Duration
+	MaxEntries int
+}
+
+// Enabled reports whether the policy drops anything at all
+func (p RetentionPolicy) Enabled() bool {
+	return p.MaxAge > 0 || p.MaxEntries > 0
+}
+
+// ArchivedHistory is a vote history entry retention is about to drop,
+// what the archive hook gets to keep
+type ArchivedHistory struct {
+	Tenant     string       `json:"tenant,omitempty"`
+	VoterId    int          `json:"voterId"`
+	Entry      VoterHistory `json:"entry"`
+	ArchivedAt time.Time    `json:"archivedAt"`
+}
+
+// Archiver is called with the entries of a voter before they are dropped.
+// When it fails nothing is dropped, so no entry is lost without being
+// archived.  An entry may be archived twice if dropping it failed after
+type Archiver func(entries []ArchivedHistory) error
+
+// RetentionSummary is what ApplyRetention did in a tenant
+type RetentionSummary struct {
+	Voters  int `json:"voters"`
+	Dropped int `json:"dropped"`
+}
+
+// ApplyRetention drops the vote history of every voter of the tenant that
+// the policy no longer keeps at now, handing it to archive first when
+// archive is not nil.  The history of a voter is chained again after, see
+// chainHistory, the archived entries keep their old hashes
+func (vl *Voter) ApplyRetention(policy RetentionPolicy, now time.Time, archive Archiver) (summary RetentionSummary, err error) {
+	defer observe("ApplyRetention", time.Now(), &err)
+
+	if !policy.Enabled() {
+		return summary, nil
+	}
+
+	pollList, err := vl.GetAllPolls()
+	if err != nil {
+		return summary, err
+	}
+	kept := make(map[int]bool)
+	for _, poll := range pollList {
+		if poll.RetentionExempt || !poll.Closed(now) {
+			kept[poll.PollId] = true
+		}
+	}
+
+	voterList, err := vl.GetAllVoters()
+	if err != nil {
+		return summary, err
+	}
+	for _, voterItem := range voterList {
+		expired := policy.expired(voterItem.VoteHistory, kept, now)
+		if len(expired) == 0 {
+			continue
+		}
+
+		if archive != nil {
+			entries := make([]ArchivedHistory, len(expired))
+			for i, entry := range expired {
+				entries[i] = ArchivedHistory{Tenant: vl.tenant, VoterId: voterItem.VoterId, Entry: entry, ArchivedAt: now}
+			}
+			if err := archive(entries); err != nil {
+				return summary, fmt.Errorf("archiving the history of voter %d: %w", voterItem.VoterId, err)
+			}
+		}
+
+		dropped, err := vl.dropHistory(voterItem.VoterId, expired)
+		if errors.Is(err, ErrNotFound) || errors.Is(err, ErrFrozen) {
+			//Deleted since the list was read, or frozen for an
+			//investigation, which retention must not touch
+			continue
+		}
+		if err != nil {
+			return summary, err
+		}
+		if dropped > 0 {
+			summary.Voters++
+			summary.Dropped += dropped
+			vl.emit(EventVoterUpdated, voterItem.VoterId, 0)
+		}
+	}
+
+	if summary.Dropped > 0 {
+		entry := AuditEntry{Action: "history.pruned", Actor: "retention",
+			Detail: fmt.Sprintf("voters=%d entries=%d", summary.Voters, summary.Dropped)}
+		if err := vl.AppendAudit(entry); err != nil {
+			vl.log.Error("Error writing audit log", "error", err)
+		}
+	}
+	return summary, nil
+}
+
+// expired returns the entries of history the policy drops at now, kept are
+// the polls whose entries always stay
+func (p RetentionPolicy) expired(history []VoterHistory, kept map[int]bool, now time.Time) []VoterHistory {
+	var candidates []VoterHistory
+	for _, entry := range history {
+		if !kept[entry.PollId] {
+			candidates = append(candidates, entry)
+		}
+	}
+	//Newest first, so the ones past MaxEntries are at the end
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].VoteDate.After(candidates[j].VoteDate)
+	})
+
+	var expired []VoterHistory
+	for i, entry := range candidates {
+		tooOld := p.MaxAge > 0 && now.Sub(entry.VoteDate) > p.MaxAge
+		tooMany := p.MaxEntries > 0 && i >= p.MaxEntries
+		if tooOld || tooMany {
+			expired = append(expired, entry)
+		}
+	}
+	return expired
+}
+
+// dropHistory removes entries from the history of a voter, the ones that
+// changed since they were read are left alone.  It returns how many it
+// removed
+func (vl *Voter) dropHistory(voterId int, entries []VoterHistory) (int, error) {
+	drop := make(map[int]string, len(entries))
+	for _, entry := range entries {
+		drop[entry.PollId] = entry.Hash
+	}
+
+	dropped := 0
+	_, _, err := vl.updateHistory(voterId, func(voterItem *VoterItem) error {
+		dropped = 0
+		history := voterItem.VoteHistory[:0]
+		for _, entry := range voterItem.VoteHistory {
+			if hash, found := drop[entry.PollId]; found && hash == entry.Hash {
+				dropped++
+				continue
+			}
+			history = append(history, entry)
+		}
+		voterItem.VoteHistory = history
+		return nil
+	})
+	return dropped, err
+}
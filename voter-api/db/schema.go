@@ -0,0 +1,144 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SchemaVersion is the version of the stored data layout this binary reads
+// and writes.  Bump it whenever the shape of the records changes in a way
+// an older binary would not handle correctly.  2 added the OptionId of
+// history entries, 3 their hash chain, 4 the verified email voting needs
+const SchemaVersion = 4
+
+// schemaMigrations bring the stored records up to a schema version, the
+// one for version n runs on data written by version n-1.  They have to be
+// safe to run twice, a replica that dies halfway runs them again
+var schemaMigrations = map[int]func(vl *Voter) (int, error){
+	2: (*Voter).migrateHistoryChoices,
+	3: (*Voter).migrateHistoryChains,
+	4: (*Voter).migrateVerifiedVoters,
+}
+
+// SchemaVersionKey holds the newest schema version that has written to
+// this redis instance
+const SchemaVersionKey = "meta:schemaVersion"
+
+// ErrSchemaTooNew is returned by CheckSchemaVersion when the data was
+// written by a newer release than this one
+var ErrSchemaTooNew = fmt.Errorf("stored schema version is newer than supported version %d", SchemaVersion)
+
+// CheckSchemaVersion compares the stored schema version with SchemaVersion.
+// An empty database or one written by an older release is migrated, see
+// schemaMigrations, and stamped with our version.  If a newer release has already written to redis, ErrSchemaTooNew
+// is returned so that old replicas in a rolling deploy do not overwrite
+// records they do not understand
+func (vl *Voter) CheckSchemaVersion() (stored int, err error) {
+	defer observe("CheckSchemaVersion", time.Now(), &err)
+
+	value, err := vl.client.Get(vl.context, SchemaVersionKey).Result()
+	if err != nil && !isRedisNilError(err) {
+		return 0, err
+	}
+
+	if value != "" {
+		stored, err = strconv.Atoi(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s %q: %w", SchemaVersionKey, value, err)
+		}
+	}
+
+	if stored > SchemaVersion {
+		return stored, fmt.Errorf("%w (stored %d)", ErrSchemaTooNew, stored)
+	}
+
+	if stored < SchemaVersion {
+		for version := stored + 1; version <= SchemaVersion; version++ {
+			migrate, ok := schemaMigrations[version]
+			if !ok {
+				continue
+			}
+			count, err := migrate(vl)
+			if err != nil {
+				return stored, fmt.Errorf("migrating to schema version %d: %w", version, err)
+			}
+			vl.log.Info("Migrated stored data", "schemaVersion", version, "records", count)
+		}
+		if err := vl.client.Set(vl.context, SchemaVersionKey, SchemaVersion, 0).Err(); err != nil {
+			return stored, err
+		}
+	}
+
+	return stored, nil
+}
+
+// storedTenants returns the tenants that have voters stored, "" for the
+// default tenant is always among them
+func (vl *Voter) storedTenants() ([]string, error) {
+	keys, err := vl.scanKeys(TenantKeyPrefix + "*:" + RedisKeyPrefix + "*")
+	if err != nil {
+		return nil, err
+	}
+
+	tenants := []string{""}
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		tenant, _, _ := strings.Cut(strings.TrimPrefix(key, TenantKeyPrefix), ":")
+		if !seen[tenant] {
+			seen[tenant] = true
+			tenants = append(tenants, tenant)
+		}
+	}
+	return tenants, nil
+}
+
+// migrateHistoryChoices fills in the OptionId of the history entries written
+// before schema version 2 from the votes they were recorded with.  Entries
+// added without a vote, through /voters/:id/polls, stay without a choice
+func (vl *Voter) migrateHistoryChoices() (count int, err error) {
+	tenants, err := vl.storedTenants()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, tenant := range tenants {
+		scoped := vl.WithTenant(tenant)
+		voterList, err := scoped.GetAllVoters()
+		if err != nil {
+			return count, err
+		}
+
+		for _, voterItem := range voterList {
+			changed := false
+			for i, history := range voterItem.VoteHistory {
+				if history.OptionId != 0 {
+					continue
+				}
+				vote, err := scoped.GetVote(history.VoteId)
+				if errors.Is(err, ErrNotFound) {
+					continue
+				}
+				if err != nil {
+					return count, err
+				}
+				if vote.VoterId == voterItem.VoterId && vote.PollId == history.PollId {
+					voterItem.VoteHistory[i].OptionId = vote.VoteValue
+					changed = true
+				}
+			}
+			if !changed {
+				continue
+			}
+
+			//Only the history is written, like updateHistory does
+			if _, err := scoped.jsonHelper.JSONSet(scoped.redisKeyFromId(voterItem.VoterId), ".voteHistory", voterItem.VoteHistory); err != nil {
+				return count, err
+			}
+			count++
+		}
+	}
+	return count, nil
+}
@@ -0,0 +1,182 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// SearchIndexKey is the RediSearch full text index over the voter JSON
+// documents.  Redis keeps it up to date on every write, so unlike the
+// other indexes nothing has to maintain it
+const SearchIndexKey = "idx:search:voters"
+
+// Fields a search can be limited to
+const (
+	SearchFieldName  = "name"
+	SearchFieldEmail = "email"
+)
+
+// VoterSearch is a full text search over the voters.  Field limits it to
+// the name or the email, both are searched when it is empty
+type VoterSearch struct {
+	Text   string
+	Field  string
+	Offset int
+	Limit  int
+}
+
+// ensureSearchIndex creates the search index if it does not exist yet.
+// Redis indexes the voters that are already stored in the background
+func (vl *Voter) ensureSearchIndex() error {
+	err := vl.client.Do(vl.context, "FT.CREATE", vl.key(SearchIndexKey),
+		"ON", "JSON", "PREFIX", "1", vl.key(RedisKeyPrefix),
+		"SCHEMA",
+		"$.name", "AS", SearchFieldName, "TEXT", "WEIGHT", "2.0",
+		"$.email", "AS", SearchFieldEmail, "TEXT",
+	).Err()
+	if err != nil && strings.Contains(err.Error(), "Index already exists") {
+		return nil
+	}
+	return err
+}
+
+// searchQuery builds the RediSearch query for a search.  The text is split
+// into words the way the indexer splits names and emails, so nothing the
+// client sends is ever interpreted as query syntax.  Every word of two or
+// more characters also matches as a prefix, "jan smi" finds Jane Smith
+func searchQuery(search VoterSearch) (string, error) {
+	words := strings.FieldsFunc(strings.ToLower(search.Text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) == 0 {
+		return "", fmt.Errorf("%w: search text has no words", ErrInvalidQuery)
+	}
+	for i, word := range words {
+		if len([]rune(word)) >= 2 {
+			words[i] = word + "*"
+		}
+	}
+
+	var fields string
+	switch search.Field {
+	case "":
+		fields = SearchFieldName + "|" + SearchFieldEmail
+	case SearchFieldName, SearchFieldEmail:
+		fields = search.Field
+	default:
+		return "", fmt.Errorf("%w: unknown search field %s", ErrInvalidQuery, search.Field)
+	}
+
+	return fmt.Sprintf("@%s:(%s)", fields, strings.Join(words, " ")), nil
+}
+
+// SearchVoters returns one page of the voters matching a search, best
+// matches first, and how many voters match in total
+func (vl *Voter) SearchVoters(search VoterSearch) (voterList []VoterItem, total int, err error) {
+	defer observe("SearchVoters", time.Now(), &err)
+
+	query, err := searchQuery(search)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	result, err := vl.client.Do(vl.context, "FT.SEARCH", vl.key(SearchIndexKey), query,
+		"NOCONTENT", "LIMIT", search.Offset, search.Limit).Result()
+	if err != nil && vl.tenant != "" && isUnknownIndexError(err) {
+		//A tenant that came after EnsureIndexes ran gets its index on
+		//its first search
+		if err := vl.ensureSearchIndex(); err != nil {
+			return nil, 0, err
+		}
+		result, err = vl.client.Do(vl.context, "FT.SEARCH", vl.key(SearchIndexKey), query,
+			"NOCONTENT", "LIMIT", search.Offset, search.Limit).Result()
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	keys, total, err := searchResultKeys(result)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	voterList, err = vl.getVotersByKeys(keys)
+	return voterList, total, err
+}
+
+// isUnknownIndexError reports whether err is RediSearch saying the index
+// does not exist, older versions word it differently
+func isUnknownIndexError(err error) bool {
+	message := strings.ToLower(err.Error())
+	return strings.Contains(message, "no such index") || strings.Contains(message, "unknown index name")
+}
+
+// searchResultKeys reads the total and the matching keys out of an
+// FT.SEARCH NOCONTENT reply, which is a flat array with RESP2 and a map
+// with RESP3
+func searchResultKeys(result any) (keys []string, total int, err error) {
+	switch reply := result.(type) {
+	case []any:
+		if len(reply) == 0 {
+			return nil, 0, fmt.Errorf("empty search reply")
+		}
+		count, _ := reply[0].(int64)
+		for _, key := range reply[1:] {
+			if s, ok := key.(string); ok {
+				keys = append(keys, s)
+			}
+		}
+		return keys, int(count), nil
+	case map[any]any:
+		count, _ := reply["total_results"].(int64)
+		results, _ := reply["results"].([]any)
+		for _, item := range results {
+			doc, _ := item.(map[any]any)
+			if s, ok := doc["id"].(string); ok {
+				keys = append(keys, s)
+			}
+		}
+		return keys, int(count), nil
+	}
+	return nil, 0, fmt.Errorf("unexpected search reply %T", result)
+}
+
+// getVotersByKeys reads many voters in one pipelined round trip, in the
+// order of keys.  Voters deleted in the meantime are left out
+func (vl *Voter) getVotersByKeys(keys []string) ([]VoterItem, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	pipe := vl.client.Pipeline()
+	cmds := make([]*redis.Cmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.Do(vl.context, "JSON.GET", key, ".")
+	}
+	if _, err := pipe.Exec(vl.context); err != nil && !isRedisNilError(err) {
+		return nil, err
+	}
+
+	voterList := make([]VoterItem, 0, len(keys))
+	for _, cmd := range cmds {
+		value, err := cmd.Text()
+		if isRedisNilError(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var voterItem VoterItem
+		if err := json.Unmarshal([]byte(value), &voterItem); err != nil {
+			return nil, err
+		}
+		voterList = append(voterList, voterItem)
+	}
+	return voterList, nil
+}
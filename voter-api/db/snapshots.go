@@ -0,0 +1,125 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Result snapshots, results:<pollId>:snapshot:<unix millis> holds the
+// results of a poll as they were at that time and results:<pollId>:snapshots
+// is a sorted set of those keys scored by the time
+const (
+	snapshotKeyInfix  = ":snapshot:"
+	snapshotIndexName = ":snapshots"
+)
+
+func (vl *Voter) snapshotIndexKey(pollId int) string {
+	return vl.key(fmt.Sprintf("%s%d%s", ResultsKeyPrefix, pollId, snapshotIndexName))
+}
+
+func (vl *Voter) snapshotKey(pollId int, at time.Time) string {
+	return vl.key(fmt.Sprintf("%s%d%s%d", ResultsKeyPrefix, pollId, snapshotKeyInfix, at.UnixMilli()))
+}
+
+// SnapshotResults records the results of every open poll of the tenant, and
+// one last time those of a poll that closed since its latest snapshot, so
+// the history ends with the final count.  It returns how many it recorded
+func (vl *Voter) SnapshotResults() (count int, err error) {
+	defer observe("SnapshotResults", time.Now(), &err)
+
+	pollList, err := vl.GetAllPolls()
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now().UTC()
+	for _, poll := range pollList {
+		switch poll.Window(now) {
+		case PollNotOpen:
+			continue
+		case PollClosed:
+			latest, err := vl.client.ZRevRangeWithScores(vl.context, vl.snapshotIndexKey(poll.PollId), 0, 0).Result()
+			if err != nil {
+				return count, err
+			}
+			if len(latest) == 0 || int64(latest[0].Score) >= poll.ClosesAt.UnixMilli() {
+				continue
+			}
+		}
+
+		results, err := vl.tallyPoll(poll, MethodPlurality)
+		if err != nil {
+			return count, err
+		}
+		value, err := json.Marshal(results)
+		if err != nil {
+			return count, err
+		}
+
+		key := vl.snapshotKey(poll.PollId, results.TalliedAt)
+		pipe := vl.client.TxPipeline()
+		pipe.Set(vl.context, key, value, 0)
+		pipe.ZAdd(vl.context, vl.snapshotIndexKey(poll.PollId), redis.Z{Score: float64(results.TalliedAt.UnixMilli()), Member: key})
+		if _, err := pipe.Exec(vl.context); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// GetResultsHistory returns the snapshots of a poll taken between from and
+// to, oldest first.  A zero from or to leaves that end open
+func (vl *Voter) GetResultsHistory(pollId int, from time.Time, to time.Time) (snapshots []PollResults, err error) {
+	defer observe("GetResultsHistory", time.Now(), &err)
+
+	rangeBy := &redis.ZRangeBy{Min: "-inf", Max: "+inf"}
+	if !from.IsZero() {
+		rangeBy.Min = strconv.FormatInt(from.UnixMilli(), 10)
+	}
+	if !to.IsZero() {
+		rangeBy.Max = strconv.FormatInt(to.UnixMilli(), 10)
+	}
+	keys, err := vl.client.ZRangeByScore(vl.context, vl.snapshotIndexKey(pollId), rangeBy).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots = []PollResults{}
+	if len(keys) == 0 {
+		return snapshots, nil
+	}
+	values, err := vl.client.MGet(vl.context, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for _, value := range values {
+		text, ok := value.(string)
+		if !ok {
+			//Deleted since the index was read
+			continue
+		}
+		var results PollResults
+		if err := json.Unmarshal([]byte(text), &results); err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, results)
+	}
+	return snapshots, nil
+}
+
+// deleteSnapshots drops the result history of a deleted poll
+func (vl *Voter) deleteSnapshots(pollId int) {
+	indexKey := vl.snapshotIndexKey(pollId)
+	keys, err := vl.client.ZRange(vl.context, indexKey, 0, -1).Result()
+	if err == nil {
+		err = vl.client.Del(vl.context, append(keys, indexKey)...).Err()
+	}
+	if err != nil {
+		vl.log.Error("Error deleting result snapshots", "pollId", pollId, "error", err)
+	}
+}
@@ -0,0 +1,300 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// VoteCountsKey is a hash of poll id to the number of voters with that
+	// poll in their history.  It is kept up to date with the indexes, so
+	// the stats never have to read every voter
+	VoteCountsKey = "stats:votes"
+	// LastVoteKey is a sorted set with the one member lastVoteMember,
+	// scored by the unix milliseconds of the latest vote date recorded
+	LastVoteKey    = "stats:lastVote"
+	lastVoteMember = "last"
+	// TurnoutKeyPrefix is the prefix of the per poll vote series,
+	// stats:turnout:<pollId> is a hash of the unix time an hour starts at
+	// to the number of votes dated in that hour
+	TurnoutKeyPrefix = "stats:turnout:"
+	// PrecinctCountsKey is a hash of precinct id to the number of voters
+	// assigned to it, the eligible voters of a poll limited to precincts
+	PrecinctCountsKey = "stats:precincts"
+	// LeaderboardKey is a sorted set of every voter id, scored by the
+	// number of polls in the history of the voter
+	LeaderboardKey = "stats:leaderboard"
+)
+
+// TurnoutBucket is the smallest interval the turnout series is kept in,
+// coarser series are added up from it
+const TurnoutBucket = time.Hour
+
+// Stats is a summary of the voters and their votes.  VotesPerPoll counts
+// the history entries, so votes recorded with and without a ballot
+type Stats struct {
+	TotalVoters          int         `json:"totalVoters"`
+	TotalVotes           int         `json:"totalVotes"`
+	VotesPerPoll         map[int]int `json:"votesPerPoll"`
+	AverageVotesPerVoter float64     `json:"averageVotesPerVoter"`
+	LastVoteAt           *time.Time  `json:"lastVoteAt,omitempty"`
+}
+
+// countVotes queues the changes to the vote counters for a voter whose
+// history went from oldHistory to newHistory.  The latest vote date only
+// ever moves forward, deleting a vote does not take it back.  A vote that
+// was moved to another date is moved to its bucket of the turnout series
+func (vl *Voter) countVotes(pipe redis.Pipeliner, oldHistory []VoterHistory, newHistory []VoterHistory) {
+	oldPolls := make(map[int]VoterHistory, len(oldHistory))
+	for _, history := range oldHistory {
+		oldPolls[history.PollId] = history
+	}
+	newPolls := make(map[int]bool, len(newHistory))
+	for _, history := range newHistory {
+		newPolls[history.PollId] = true
+	}
+
+	for pollId, history := range oldPolls {
+		if !newPolls[pollId] {
+			pipe.HIncrBy(vl.context, vl.key(VoteCountsKey), strconv.Itoa(pollId), -1)
+			vl.countTurnout(pipe, history, -1)
+		}
+	}
+	for _, history := range newHistory {
+		old, found := oldPolls[history.PollId]
+		if found {
+			if turnoutBucket(old.VoteDate) != turnoutBucket(history.VoteDate) {
+				vl.countTurnout(pipe, old, -1)
+				vl.countTurnout(pipe, history, 1)
+			}
+			continue
+		}
+		pipe.HIncrBy(vl.context, vl.key(VoteCountsKey), strconv.Itoa(history.PollId), 1)
+		pipe.ZAddGT(vl.context, vl.key(LastVoteKey), redis.Z{Score: float64(history.VoteDate.UnixMilli()), Member: lastVoteMember})
+		vl.countTurnout(pipe, history, 1)
+	}
+}
+
+// turnoutBucket is the field of the turnout series a vote dated at is
+// counted in
+func turnoutBucket(at time.Time) string {
+	return strconv.FormatInt(at.UTC().Truncate(TurnoutBucket).Unix(), 10)
+}
+
+// countTurnout queues adding delta to the bucket of the vote date of a
+// history entry in the turnout series of its poll
+func (vl *Voter) countTurnout(pipe redis.Pipeliner, history VoterHistory, delta int64) {
+	pipe.HIncrBy(vl.context, vl.turnoutKey(history.PollId), turnoutBucket(history.VoteDate), delta)
+}
+
+func (vl *Voter) turnoutKey(pollId int) string {
+	return vl.key(fmt.Sprintf("%s%d", TurnoutKeyPrefix, pollId))
+}
+
+// countPrecinct queues moving a voter from one precinct to another in the
+// precinct counters, 0 is no precinct and not counted
+func (vl *Voter) countPrecinct(pipe redis.Pipeliner, oldPrecinct int, newPrecinct int) {
+	if oldPrecinct == newPrecinct {
+		return
+	}
+	if oldPrecinct > 0 {
+		pipe.HIncrBy(vl.context, vl.key(PrecinctCountsKey), strconv.Itoa(oldPrecinct), -1)
+	}
+	if newPrecinct > 0 {
+		pipe.HIncrBy(vl.context, vl.key(PrecinctCountsKey), strconv.Itoa(newPrecinct), 1)
+	}
+}
+
+// GetStats returns the voter and vote totals from the counters
+func (vl *Voter) GetStats() (stats Stats, err error) {
+	defer observe("GetStats", time.Now(), &err)
+
+	pipe := vl.client.Pipeline()
+	voters := pipe.ZCard(vl.context, vl.key(VoterIndexKey))
+	counts := pipe.HGetAll(vl.context, vl.key(VoteCountsKey))
+	lastVote := pipe.ZScore(vl.context, vl.key(LastVoteKey), lastVoteMember)
+	if _, err := pipe.Exec(vl.context); err != nil && !isRedisNilError(err) {
+		return Stats{}, err
+	}
+
+	stats.TotalVoters = int(voters.Val())
+	stats.VotesPerPoll = make(map[int]int)
+	for field, value := range counts.Val() {
+		pollId, err := strconv.Atoi(field)
+		if err != nil {
+			continue
+		}
+		count, err := strconv.Atoi(value)
+		if err != nil || count <= 0 {
+			//A poll whose last vote was deleted
+			continue
+		}
+		stats.VotesPerPoll[pollId] = count
+		stats.TotalVotes += count
+	}
+	if stats.TotalVoters > 0 {
+		stats.AverageVotesPerVoter = float64(stats.TotalVotes) / float64(stats.TotalVoters)
+	}
+	if lastVote.Err() == nil {
+		last := time.UnixMilli(int64(lastVote.Val())).UTC()
+		stats.LastVoteAt = &last
+	}
+
+	return stats, nil
+}
+
+// rankVoter queues setting the score of a voter on the leaderboard to the
+// number of polls it voted in
+func (vl *Voter) rankVoter(pipe redis.Pipeliner, voterItem VoterItem) {
+	pipe.ZAdd(vl.context, vl.key(LeaderboardKey), redis.Z{Score: float64(len(voterItem.VoteHistory)), Member: strconv.Itoa(voterItem.VoterId)})
+}
+
+// LeaderboardEntry is a voter on the leaderboard and how many polls it
+// voted in
+type LeaderboardEntry struct {
+	Rank    int    `json:"rank"`
+	VoterId int    `json:"voterId"`
+	Name    string `json:"name"`
+	Votes   int    `json:"votes"`
+}
+
+// GetLeaderboard returns up to limit voters that voted in the most polls,
+// most first, from the leaderboard.  Voters that never voted are left out
+func (vl *Voter) GetLeaderboard(limit int) (leaderboard []LeaderboardEntry, err error) {
+	defer observe("GetLeaderboard", time.Now(), &err)
+
+	ranked, err := vl.client.ZRangeArgsWithScores(vl.context, redis.ZRangeArgs{
+		Key:     vl.key(LeaderboardKey),
+		Start:   "+inf",
+		Stop:    "(0",
+		ByScore: true,
+		Rev:     true,
+		Count:   int64(limit),
+	}).Result()
+	if err != nil || len(ranked) == 0 {
+		return []LeaderboardEntry{}, err
+	}
+
+	//Only the names are read, not the whole voters with their histories
+	pipe := vl.client.Pipeline()
+	names := make([]*redis.Cmd, len(ranked))
+	for i, z := range ranked {
+		names[i] = pipe.Do(vl.context, "JSON.GET", vl.key(RedisKeyPrefix+z.Member.(string)), ".name")
+	}
+	_, _ = pipe.Exec(vl.context)
+
+	leaderboard = make([]LeaderboardEntry, 0, len(ranked))
+	for i, z := range ranked {
+		voterId, err := strconv.Atoi(z.Member.(string))
+		if err != nil {
+			continue
+		}
+		value, err := names[i].Text()
+		if isRedisNilError(err) {
+			//Deleted since the leaderboard was read
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		entry := LeaderboardEntry{Rank: len(leaderboard) + 1, VoterId: voterId, Votes: int(z.Score)}
+		if err := json.Unmarshal([]byte(value), &entry.Name); err != nil {
+			return nil, err
+		}
+		leaderboard = append(leaderboard, entry)
+	}
+	return leaderboard, nil
+}
+
+// TurnoutPoint is the number of votes dated in the interval starting at
+// Start
+type TurnoutPoint struct {
+	Start time.Time `json:"start"`
+	Votes int       `json:"votes"`
+}
+
+// PollTurnout is how many of the voters that could vote in a poll did.
+// Eligible are the voters on the roll, in the precincts of the poll when
+// it is limited to some, whatever their status.  Voted counts the history
+// entries, provisional votes included, Series spreads them over time
+type PollTurnout struct {
+	PollId     int            `json:"pollId"`
+	Eligible   int            `json:"eligible"`
+	Voted      int            `json:"voted"`
+	Percentage float64        `json:"percentage"`
+	Interval   string         `json:"interval"`
+	Series     []TurnoutPoint `json:"series"`
+}
+
+// GetPollTurnout returns the turnout of a poll from the counters, with the
+// votes added up per interval, a multiple of TurnoutBucket.  precincts
+// are the precincts of the poll
+func (vl *Voter) GetPollTurnout(pollId int, precincts []int, interval time.Duration) (turnout PollTurnout, err error) {
+	defer observe("GetPollTurnout", time.Now(), &err)
+
+	if interval < TurnoutBucket || interval%TurnoutBucket != 0 {
+		return PollTurnout{}, fmt.Errorf("%w: interval must be a multiple of %s", ErrInvalidQuery, TurnoutBucket)
+	}
+
+	pipe := vl.client.Pipeline()
+	voted := pipe.HGet(vl.context, vl.key(VoteCountsKey), strconv.Itoa(pollId))
+	series := pipe.HGetAll(vl.context, vl.turnoutKey(pollId))
+	var voters *redis.IntCmd
+	var precinctVoters *redis.SliceCmd
+	if len(precincts) == 0 {
+		voters = pipe.ZCard(vl.context, vl.key(VoterIndexKey))
+	} else {
+		fields := make([]string, len(precincts))
+		for i, precinctId := range precincts {
+			fields[i] = strconv.Itoa(precinctId)
+		}
+		precinctVoters = pipe.HMGet(vl.context, vl.key(PrecinctCountsKey), fields...)
+	}
+	if _, err := pipe.Exec(vl.context); err != nil && !isRedisNilError(err) {
+		return PollTurnout{}, err
+	}
+
+	turnout = PollTurnout{PollId: pollId, Interval: interval.String(), Series: []TurnoutPoint{}}
+	turnout.Voted, _ = strconv.Atoi(voted.Val())
+	if voters != nil {
+		turnout.Eligible = int(voters.Val())
+	} else {
+		for _, value := range precinctVoters.Val() {
+			if count, ok := value.(string); ok {
+				n, _ := strconv.Atoi(count)
+				turnout.Eligible += n
+			}
+		}
+	}
+	if turnout.Voted < 0 {
+		turnout.Voted = 0
+	}
+	if turnout.Eligible > 0 {
+		turnout.Percentage = float64(turnout.Voted) * 100 / float64(turnout.Eligible)
+	}
+
+	points := make(map[int64]int)
+	for field, value := range series.Val() {
+		start, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			continue
+		}
+		count, err := strconv.Atoi(value)
+		if err != nil || count <= 0 {
+			//A bucket whose votes were deleted
+			continue
+		}
+		points[time.Unix(start, 0).UTC().Truncate(interval).Unix()] += count
+	}
+	for start, votes := range points {
+		turnout.Series = append(turnout.Series, TurnoutPoint{Start: time.Unix(start, 0).UTC(), Votes: votes})
+	}
+	sort.Slice(turnout.Series, func(i, j int) bool { return turnout.Series[i].Start.Before(turnout.Series[j].Start) })
+
+	return turnout, nil
+}
@@ -0,0 +1,203 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Voter statuses.  A voter who registered with RegisterVoter is pending
+// until an admin activates them, only active voters can vote.  Inactive
+// voters were dropped by the registration system, see ReconcileVoters.
+// Suspended voters are barred from voting until they are reinstated and
+// purged ones are off the roll for good: the record and its history stay
+// for the audit trail, but it is left out of the exports and can not come
+// back.  Voters stored before statuses existed have none and count as active
+const (
+	VoterStatusPending   = "pending"
+	VoterStatusActive    = "active"
+	VoterStatusInactive  = "inactive"
+	VoterStatusSuspended = "suspended"
+	VoterStatusPurged    = "purged"
+)
+
+// ErrNotActive is returned when a voter that is not active tries to vote
+var ErrNotActive = errors.New("voter is not active")
+
+// ErrNotVerified is returned when a voter whose email is not verified
+// tries to vote, see VerifyVoter
+var ErrNotVerified = errors.New("voter email is not verified")
+
+// voterTransitions lists the statuses a voter can move to from each status
+var voterTransitions = map[string][]string{
+	VoterStatusPending:   {VoterStatusActive, VoterStatusInactive, VoterStatusPurged},
+	VoterStatusActive:    {VoterStatusInactive, VoterStatusSuspended, VoterStatusPurged},
+	VoterStatusInactive:  {VoterStatusActive, VoterStatusPurged},
+	VoterStatusSuspended: {VoterStatusActive, VoterStatusPurged},
+	VoterStatusPurged:    {},
+}
+
+// ValidVoterStatus reports whether status is a known voter status
+func ValidVoterStatus(status string) bool {
+	_, known := voterTransitions[status]
+	return known
+}
+
+// status is the status of the voter, active for voters without one
+func (v VoterItem) status() string {
+	if v.Status == "" {
+		return VoterStatusActive
+	}
+	return v.Status
+}
+
+// Active reports whether the voter may vote
+func (v VoterItem) Active() bool {
+	return v.status() == VoterStatusActive
+}
+
+// Purged reports whether the voter was taken off the roll, see
+// VoterStatusPurged
+func (v VoterItem) Purged() bool {
+	return v.status() == VoterStatusPurged
+}
+
+// canTransition reports whether a voter can move from one status to another
+func canTransition(from string, to string) bool {
+	for _, allowed := range voterTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// HasEmail reports whether email is the address of the voter, case and
+// surrounding space do not matter.  A voter whose address changed has to
+// verify the new one
+func (v VoterItem) HasEmail(email string) bool {
+	return NormalizeEmail(v.Email) == NormalizeEmail(email)
+}
+
+// RegisterVoter adds a voter who signed up themselves, they are pending
+// until they verified their email with VerifyVoter or an admin activated
+// them with SetVoterStatus
+func (vl *Voter) RegisterVoter(voterItem VoterItem) (err error) {
+	defer observe("RegisterVoter", time.Now(), &err)
+
+	voterItem.Status = VoterStatusPending
+	voterItem.Verified = false
+	return vl.AddVoter(voterItem)
+}
+
+// VerifyVoter marks the email of a voter as verified and activates the
+// voter if they were pending.  email is the address the link was sent to,
+// a link for an address the voter no longer has is ErrConflict.
+// Verifying twice is not an error, the link in the email may well be
+// clicked again
+func (vl *Voter) VerifyVoter(id int, email string) (voterItem VoterItem, err error) {
+	defer observe("VerifyVoter", time.Now(), &err)
+
+	voterItem, err = vl.GetVoter(id)
+	if err != nil {
+		return VoterItem{}, err
+	}
+	if voterItem.Frozen {
+		return VoterItem{}, ErrFrozen
+	}
+	if !voterItem.HasEmail(email) {
+		return VoterItem{}, fmt.Errorf("%w: voter %d changed their email since the link was sent", ErrConflict, id)
+	}
+	if voterItem.Verified {
+		return voterItem, nil
+	}
+
+	redisKey := vl.redisKeyFromId(id)
+	pipe := vl.client.TxPipeline()
+	pipe.Do(vl.context, "JSON.SET", redisKey, ".verified", "true")
+	if voterItem.Status == VoterStatusPending {
+		pipe.Do(vl.context, "JSON.SET", redisKey, ".status", `"`+VoterStatusActive+`"`)
+		voterItem.Status = VoterStatusActive
+	}
+	if _, err := pipe.Exec(vl.context); err != nil {
+		return VoterItem{}, err
+	}
+
+	voterItem.Verified = true
+	vl.emit(EventVoterUpdated, id, 0)
+
+	return voterItem, nil
+}
+
+// migrateVerifiedVoters verifies the active voters stored before schema
+// version 4, they could vote without a verified email and keep doing so
+func (vl *Voter) migrateVerifiedVoters() (count int, err error) {
+	tenants, err := vl.storedTenants()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, tenant := range tenants {
+		scoped := vl.WithTenant(tenant)
+		voterList, err := scoped.GetAllVoters()
+		if err != nil {
+			return count, err
+		}
+
+		for _, voterItem := range voterList {
+			if voterItem.Verified || !voterItem.Active() {
+				continue
+			}
+			if err := scoped.client.Do(scoped.context, "JSON.SET", scoped.redisKeyFromId(voterItem.VoterId), ".verified", "true").Err(); err != nil {
+				return count, err
+			}
+			count++
+		}
+	}
+	return count, nil
+}
+
+// SetVoterStatus moves a voter to another status.  A move voterTransitions
+// does not allow is ErrConflict, setting the status the voter already has
+// is not an error.  An admin activating a voter vouches for their email,
+// so the voter is verified as well, also when they already were active
+func (vl *Voter) SetVoterStatus(id int, status string) (voterItem VoterItem, err error) {
+	defer observe("SetVoterStatus", time.Now(), &err)
+
+	if !ValidVoterStatus(status) {
+		return VoterItem{}, fmt.Errorf("%w: unknown voter status %q", ErrInvalid, status)
+	}
+
+	voterItem, err = vl.GetVoter(id)
+	if err != nil {
+		return VoterItem{}, err
+	}
+	if voterItem.Frozen {
+		return VoterItem{}, ErrFrozen
+	}
+	verify := status == VoterStatusActive && !voterItem.Verified
+	if voterItem.status() == status && !verify {
+		return voterItem, nil
+	}
+	if voterItem.status() != status && !canTransition(voterItem.status(), status) {
+		return VoterItem{}, fmt.Errorf("%w: a %s voter can not become %s", ErrConflict, voterItem.status(), status)
+	}
+
+	//Like the frozen flag only the status is written, so a vote recorded
+	//in the meantime is not lost
+	redisKey := vl.redisKeyFromId(id)
+	pipe := vl.client.TxPipeline()
+	pipe.Do(vl.context, "JSON.SET", redisKey, ".status", `"`+status+`"`)
+	if verify {
+		pipe.Do(vl.context, "JSON.SET", redisKey, ".verified", "true")
+	}
+	if _, err := pipe.Exec(vl.context); err != nil {
+		return VoterItem{}, err
+	}
+
+	voterItem.Status = status
+	voterItem.Verified = voterItem.Verified || verify
+	vl.emit(EventVoterUpdated, id, 0)
+
+	return voterItem, nil
+}
@@ -0,0 +1,50 @@
+package db
+
+import (
+	"time"
+)
+
+// StreamBatchSize is how many voters are fetched per pipeline while
+// streaming, it bounds the memory a stream needs whatever the roll size
+const StreamBatchSize = 500
+
+// StreamVoters calls fn for every stored voter, in no particular order.
+// The keys are walked with SCAN and each batch of voters is fetched with
+// one pipelined round trip, so neither redis nor we ever hold the whole
+// roll.  A voter created or deleted while the stream runs may or may not
+// be seen.  Streaming stops at the first error, including one from fn
+func (vl *Voter) StreamVoters(fn func(voterItem VoterItem) error) (err error) {
+	defer observe("StreamVoters", time.Now(), &err)
+
+	iter := vl.client.Scan(vl.context, 0, vl.key(RedisKeyPrefix+"*"), StreamBatchSize).Iterator()
+	keys := make([]string, 0, StreamBatchSize)
+	for iter.Next(vl.context) {
+		keys = append(keys, iter.Val())
+		if len(keys) == StreamBatchSize {
+			if err := vl.streamBatch(keys, fn); err != nil {
+				return err
+			}
+			keys = keys[:0]
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	return vl.streamBatch(keys, fn)
+}
+
+// streamBatch fetches the voters of a batch of keys and passes them to fn.
+// Voters deleted since SCAN saw them are skipped
+func (vl *Voter) streamBatch(keys []string, fn func(voterItem VoterItem) error) error {
+	voterList, err := vl.getVotersByKeys(keys)
+	if err != nil {
+		return err
+	}
+
+	for _, voterItem := range voterList {
+		if err := fn(voterItem); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// SuppressionKey is the redis hash that holds every email address we
+// must never send to.  The hash field is the normalized email and the
+// value is the JSON encoded SuppressionEntry.  Note it deliberately does
+// not start with RedisKeyPrefix, otherwise GetAllVoters would pick it up
+const SuppressionKey = "suppression:emails"
+
+// Reasons an address can end up on the suppression list
+const (
+	SuppressionReasonBounce      = "bounce"
+	SuppressionReasonUnsubscribe = "unsubscribe"
+	SuppressionReasonComplaint   = "complaint"
+	SuppressionReasonManual      = "manual"
+)
+
+// SuppressionEntry is the struct that represents a single suppressed email
+type SuppressionEntry struct {
+	Email   string    `json:"email"`
+	Reason  string    `json:"reason"`
+	AddedAt time.Time `json:"addedAt"`
+}
+
+// NormalizeEmail lower cases and trims an email so that "Jane@Example.com "
+// and "jane@example.com" are treated as the same address
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// AddSuppression adds an email to the suppression list.  Adding an address
+// that is already suppressed just overwrites the reason and timestamp
+func (vl *Voter) AddSuppression(email string, reason string) (entry SuppressionEntry, err error) {
+	defer observe("AddSuppression", time.Now(), &err)
+
+	email = NormalizeEmail(email)
+	if email == "" {
+		return SuppressionEntry{}, errors.New("email is required")
+	}
+	if reason == "" {
+		reason = SuppressionReasonManual
+	}
+
+	entry = SuppressionEntry{
+		Email:   email,
+		Reason:  reason,
+		AddedAt: time.Now(),
+	}
+
+	entryBytes, err := json.Marshal(entry)
+	if err != nil {
+		return SuppressionEntry{}, err
+	}
+
+	if err := vl.client.HSet(vl.context, SuppressionKey, email, entryBytes).Err(); err != nil {
+		return SuppressionEntry{}, err
+	}
+
+	return entry, nil
+}
+
+// RemoveSuppression takes an email off of the suppression list
+func (vl *Voter) RemoveSuppression(email string) (err error) {
+	defer observe("RemoveSuppression", time.Now(), &err)
+
+	numDeleted, err := vl.client.HDel(vl.context, SuppressionKey, NormalizeEmail(email)).Result()
+	if err != nil {
+		return err
+	}
+	if numDeleted == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// IsSuppressed reports whether an email is on the suppression list.  The
+// notification subsystem calls this before every send
+func (vl *Voter) IsSuppressed(email string) (suppressed bool, err error) {
+	defer observe("IsSuppressed", time.Now(), &err)
+
+	return vl.client.HExists(vl.context, SuppressionKey, NormalizeEmail(email)).Result()
+}
+
+// GetAllSuppressions returns every entry on the suppression list
+func (vl *Voter) GetAllSuppressions() (suppressionList []SuppressionEntry, err error) {
+	defer observe("GetAllSuppressions", time.Now(), &err)
+
+	entries, err := vl.client.HGetAll(vl.context, SuppressionKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, value := range entries {
+		var entry SuppressionEntry
+		if err := json.Unmarshal([]byte(value), &entry); err != nil {
+			return nil, err
+		}
+		suppressionList = append(suppressionList, entry)
+	}
+
+	return suppressionList, nil
+}
@@ -0,0 +1,149 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// SyncSummaryKey holds the SyncSummary of the latest registration sync
+const SyncSummaryKey = "sync:last"
+
+// SyncSummary is what a registration sync changed.  Voters the upstream
+// registration system no longer lists are Deactivated, ones it lists again
+// Reactivated.  Errors are the records that could not be applied
+type SyncSummary struct {
+	Source      string    `json:"source"`
+	StartedAt   time.Time `json:"startedAt"`
+	FinishedAt  time.Time `json:"finishedAt"`
+	Upstream    int       `json:"upstream"`
+	Created     []int     `json:"created,omitempty"`
+	Updated     []int     `json:"updated,omitempty"`
+	Deactivated []int     `json:"deactivated,omitempty"`
+	Reactivated []int     `json:"reactivated,omitempty"`
+	Unchanged   int       `json:"unchanged"`
+	Errors      []string  `json:"errors,omitempty"`
+}
+
+// ReconcileVoters makes the stored voters match the upstream list: new
+// ones are created, changed names, emails and demographics are updated and
+// active voters missing upstream become inactive.  Vote histories are ours
+// and never touched, neither are frozen or purged voters, and suspended
+// ones stay suspended.  An empty list is refused rather than deactivating
+// everybody because the upstream had a bad day
+func (vl *Voter) ReconcileVoters(upstream []VoterItem) (summary SyncSummary, err error) {
+	defer observe("ReconcileVoters", time.Now(), &err)
+
+	if len(upstream) == 0 {
+		return SyncSummary{}, fmt.Errorf("%w: the upstream list is empty", ErrInvalid)
+	}
+
+	voterList, err := vl.GetAllVoters()
+	if err != nil {
+		return SyncSummary{}, err
+	}
+	local := make(map[int]VoterItem, len(voterList))
+	for _, voterItem := range voterList {
+		local[voterItem.VoterId] = voterItem
+	}
+
+	summary.StartedAt = time.Now().UTC()
+	summary.Upstream = len(upstream)
+	fail := func(voterId int, err error) {
+		summary.Errors = append(summary.Errors, fmt.Sprintf("voter %d: %v", voterId, err))
+	}
+
+	listed := make(map[int]bool, len(upstream))
+	for _, record := range upstream {
+		listed[record.VoterId] = true
+		existing, found := local[record.VoterId]
+		if !found {
+			record.Status = VoterStatusActive
+			record.VoteHistory = nil
+			if err := vl.AddVoter(record); err != nil {
+				fail(record.VoterId, err)
+				continue
+			}
+			summary.Created = append(summary.Created, record.VoterId)
+			continue
+		}
+		if existing.Frozen {
+			fail(record.VoterId, ErrFrozen)
+			continue
+		}
+		if existing.Purged() {
+			fail(record.VoterId, fmt.Errorf("%w: the voter was purged", ErrConflict))
+			continue
+		}
+
+		updated := existing
+		updated.Name, updated.Email = record.Name, record.Email
+		if record.AgeBand != "" {
+			updated.AgeBand = record.AgeBand
+		}
+		if record.Region != "" {
+			updated.Region = record.Region
+		}
+		changed := updated.Name != existing.Name || updated.Email != existing.Email ||
+			updated.AgeBand != existing.AgeBand || updated.Region != existing.Region
+		if changed {
+			if err := vl.UpdateVoter(updated); err != nil {
+				fail(record.VoterId, err)
+				continue
+			}
+			summary.Updated = append(summary.Updated, record.VoterId)
+		}
+
+		if existing.status() == VoterStatusInactive {
+			if _, err := vl.SetVoterStatus(record.VoterId, VoterStatusActive); err != nil {
+				fail(record.VoterId, err)
+				continue
+			}
+			summary.Reactivated = append(summary.Reactivated, record.VoterId)
+		} else if !changed {
+			summary.Unchanged++
+		}
+	}
+
+	//Pending voters registered themselves and are not upstream yet
+	for _, existing := range voterList {
+		if listed[existing.VoterId] || existing.Frozen || !existing.Active() {
+			continue
+		}
+		if _, err := vl.SetVoterStatus(existing.VoterId, VoterStatusInactive); err != nil {
+			fail(existing.VoterId, err)
+			continue
+		}
+		summary.Deactivated = append(summary.Deactivated, existing.VoterId)
+	}
+
+	summary.FinishedAt = time.Now().UTC()
+	return summary, nil
+}
+
+// SaveSyncSummary keeps the summary of a sync for GetSyncSummary
+func (vl *Voter) SaveSyncSummary(summary SyncSummary) (err error) {
+	defer observe("SaveSyncSummary", time.Now(), &err)
+
+	value, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	return vl.client.Set(vl.context, vl.key(SyncSummaryKey), value, 0).Err()
+}
+
+// GetSyncSummary returns the summary of the latest sync, ErrNotFound if
+// there was none yet
+func (vl *Voter) GetSyncSummary() (summary SyncSummary, err error) {
+	defer observe("GetSyncSummary", time.Now(), &err)
+
+	value, err := vl.client.Get(vl.context, vl.key(SyncSummaryKey)).Bytes()
+	if isRedisNilError(err) {
+		return SyncSummary{}, ErrNotFound
+	}
+	if err != nil {
+		return SyncSummary{}, err
+	}
+	err = json.Unmarshal(value, &summary)
+	return summary, err
+}
@@ -0,0 +1,47 @@
+package db
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// TenantKeyPrefix starts the keys of every tenant but the default one,
+// tenant:<id>:voter:1 is voter 1 of tenant <id>.  The default tenant keeps
+// the unprefixed keys, so the data stored before tenants existed is its
+// data.  Only the election data is per tenant: voters and their indexes,
+// frozen voters, check-ins, anomalies and the audit log.  API keys,
+// feature flags, maintenance, rate limits, webhooks and notifications
+// belong to the deployment
+const TenantKeyPrefix = "tenant:"
+
+// tenantIdPattern is what a tenant id may look like, it is also used as a
+// DNS label so the same rules apply
+var tenantIdPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// ValidTenantId reports whether id can be used as a tenant id
+func ValidTenantId(id string) bool {
+	return tenantIdPattern.MatchString(id)
+}
+
+// WithTenant returns a copy of the Voter that reads and writes the data of
+// tenant, "" is the default tenant.  The copy shares the connection pool
+func (vl *Voter) WithTenant(tenant string) *Voter {
+	scoped := *vl
+	scoped.tenant = tenant
+	scoped.prefix = ""
+	if tenant != "" {
+		scoped.prefix = fmt.Sprintf("%s%s:", TenantKeyPrefix, tenant)
+	}
+	return &scoped
+}
+
+// Tenant is the tenant the Voter works on, "" for the default tenant
+func (vl *Voter) Tenant() string {
+	return vl.tenant
+}
+
+// key is the redis key of name for the tenant of the Voter.  Every key of
+// per tenant data goes through it
+func (vl *Voter) key(name string) string {
+	return vl.prefix + name
+}
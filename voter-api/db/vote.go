@@ -0,0 +1,326 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// VoteKeyPrefix is the prefix of the vote JSON documents, vote:<voteId>
+	VoteKeyPrefix = "vote:"
+	// VoteIndexKey is a sorted set of every vote id, scored by the id
+	VoteIndexKey = "idx:votes"
+)
+
+// Vote is a voter's answer in a poll.  VoteValue is the optionId of the
+// poll option that was picked, it is 0 when the voter wrote in an answer
+// of their own.  In a ranked poll Ranking lists the options from most to
+// least preferred and VoteValue is the first of them.  Recording a vote
+// also adds the matching VoterHistory entry to the voter, see AddVote.  A
+// secret ballot is stored with a VoterToken instead of the VoterId, see
+// AddAnonymousVote.  A Provisional vote is left out of the results until
+// it is accepted, see AcceptProvisionalVote
+type Vote struct {
+	VoteId      int       `json:"voteId" validate:"gt=0"`
+	VoterId     int       `json:"voterId" validate:"gt=0"`
+	PollId      int       `json:"pollId" validate:"gt=0"`
+	VoteValue   int       `json:"voteValue" validate:"gte=0"`
+	WriteIn     string    `json:"writeIn,omitempty" validate:"max=200"`
+	Ranking     []int     `json:"ranking,omitempty" validate:"max=100"`
+	VoteDate    time.Time `json:"voteDate"`
+	VoterToken  string    `json:"voterToken,omitempty"`
+	Provisional bool      `json:"provisional,omitempty"`
+
+	//Weight is the weight of the voter when the vote was recorded, see
+	//VoterItem.Weight.  Set by the db package, whatever a client sends is
+	//replaced
+	Weight float64 `json:"weight,omitempty"`
+}
+
+// preferences is the ranking of a vote, a vote for one option ranks just
+// that option
+func (v Vote) preferences() []int {
+	if len(v.Ranking) > 0 {
+		return v.Ranking
+	}
+	if v.VoteValue > 0 {
+		return []int{v.VoteValue}
+	}
+	return nil
+}
+
+// NormalizeWriteIn trims a write-in and collapses the whitespace in it,
+// "  Jane   Doe " is stored as "Jane Doe"
+func NormalizeWriteIn(writeIn string) string {
+	return strings.Join(strings.Fields(writeIn), " ")
+}
+
+// writeInKey is what write-ins are grouped by in the results, so "jane
+// doe" and "Jane Doe" count for the same person
+func writeInKey(writeIn string) string {
+	return strings.ToLower(NormalizeWriteIn(writeIn))
+}
+
+func (vl *Voter) voteKey(id int) string {
+	return vl.key(fmt.Sprintf("%s%d", VoteKeyPrefix, id))
+}
+
+// AddVote records a vote and appends it to the history of the voter.  The
+// vote id is claimed first, then the history is written, and the vote is
+// taken back if that fails, so a vote never exists without its history
+// entry.  Once the id is claimed the steps run to the end even when the
+// request is cancelled, see detached.  A voter that already voted in the
+// poll is ErrConflict
+func (vl *Voter) AddVote(vote Vote) (err error) {
+	defer observe("AddVote", time.Now(), &err)
+
+	if vote.VoteDate.IsZero() {
+		vote.VoteDate = time.Now().UTC()
+	}
+	vote.VoterToken = ""
+	if vote.Weight, err = vl.voterWeight(vote.VoterId); err != nil {
+		return err
+	}
+	voteBytes, err := json.Marshal(vote)
+	if err != nil {
+		return err
+	}
+
+	voteKey := vl.voteKey(vote.VoteId)
+	err = vl.client.Do(vl.context, "JSON.SET", voteKey, ".", string(voteBytes), "NX").Err()
+	if isRedisNilError(err) {
+		return ErrAlreadyExists
+	}
+	if err != nil {
+		return err
+	}
+
+	rest := vl.detached()
+	history := VoterHistory{PollId: vote.PollId, VoteId: vote.VoteId, OptionId: vote.VoteValue, Ranking: vote.Ranking,
+		VoteDate: vote.VoteDate, Provisional: vote.Provisional}
+	if err := rest.AddVoterPoll(history, vote.VoterId); err != nil {
+		if delErr := rest.client.Del(rest.context, voteKey).Err(); delErr != nil {
+			vl.log.Error("Error removing vote without history", "voteId", vote.VoteId, "error", delErr)
+		}
+		return err
+	}
+
+	err = rest.client.ZAdd(rest.context, rest.key(VoteIndexKey), redis.Z{Score: float64(vote.VoteId), Member: strconv.Itoa(vote.VoteId)}).Err()
+	if err != nil {
+		return err
+	}
+
+	//The tally is counted from the index, so it is cleared once the vote
+	//is in there
+	rest.clearResults(vote.PollId, false)
+	return nil
+}
+
+// GetVote returns one vote, ErrNotFound if there is none with the id
+func (vl *Voter) GetVote(id int) (vote Vote, err error) {
+	defer observe("GetVote", time.Now(), &err)
+
+	value, err := vl.client.Do(vl.context, "JSON.GET", vl.voteKey(id), ".").Text()
+	if err != nil {
+		if isRedisNilError(err) {
+			return Vote{}, ErrNotFound
+		}
+		return Vote{}, err
+	}
+
+	err = json.Unmarshal([]byte(value), &vote)
+	return vote, err
+}
+
+// GetAllVotes returns every vote ordered by id, read in one pipelined
+// round trip
+func (vl *Voter) GetAllVotes() (voteList []Vote, err error) {
+	defer observe("GetAllVotes", time.Now(), &err)
+
+	ids, err := vl.client.ZRange(vl.context, vl.key(VoteIndexKey), 0, -1).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+
+	pipe := vl.client.Pipeline()
+	cmds := make([]*redis.Cmd, len(ids))
+	for i, id := range ids {
+		cmds[i] = pipe.Do(vl.context, "JSON.GET", vl.key(VoteKeyPrefix+id), ".")
+	}
+	_, _ = pipe.Exec(vl.context)
+
+	for _, cmd := range cmds {
+		value, err := cmd.Text()
+		if isRedisNilError(err) {
+			//Deleted since the index was read
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var vote Vote
+		if err := json.Unmarshal([]byte(value), &vote); err != nil {
+			return nil, err
+		}
+		voteList = append(voteList, vote)
+	}
+
+	return voteList, nil
+}
+
+// UpdateVote changes the VoteValue, WriteIn or Ranking of a recorded vote.  A vote can not be
+// moved to another voter or poll, that is ErrConflict, delete it and
+// record a new one instead.  The change is an amendment of the history
+// entry of the vote with the actor making it, like with UpdateVoterPoll
+func (vl *Voter) UpdateVote(vote Vote, actor string) (err error) {
+	defer observe("UpdateVote", time.Now(), &err)
+
+	existing, err := vl.GetVote(vote.VoteId)
+	if err != nil {
+		return err
+	}
+	if existing.VoterToken != "" {
+		return fmt.Errorf("%w: a secret ballot can not be changed", ErrConflict)
+	}
+	if existing.VoterId != vote.VoterId || existing.PollId != vote.PollId {
+		return fmt.Errorf("%w: a vote can not move to another voter or poll", ErrConflict)
+	}
+	frozen, err := vl.isFrozen(vote.VoterId)
+	if err != nil {
+		return err
+	}
+	if frozen {
+		return ErrFrozen
+	}
+
+	pipe := vl.client.TxPipeline()
+	pipe.Do(vl.context, "JSON.SET", vl.voteKey(vote.VoteId), ".voteValue", vote.VoteValue)
+	writeIn, err := json.Marshal(vote.WriteIn)
+	if err != nil {
+		return err
+	}
+	pipe.Do(vl.context, "JSON.SET", vl.voteKey(vote.VoteId), ".writeIn", string(writeIn))
+	ranking, err := json.Marshal(vote.Ranking)
+	if err != nil {
+		return err
+	}
+	pipe.Do(vl.context, "JSON.SET", vl.voteKey(vote.VoteId), ".ranking", string(ranking))
+	if _, err := pipe.Exec(vl.context); err != nil {
+		return err
+	}
+	//The history keeps the choice as well
+	_, _, err = vl.updateHistory(vote.VoterId, func(voterItem *VoterItem) error {
+		for i, vh := range voterItem.VoteHistory {
+			if vh.VoteId == vote.VoteId {
+				updated := vh
+				updated.OptionId = vote.VoteValue
+				updated.Ranking = vote.Ranking
+				amend(&updated, vh, actor)
+				voterItem.VoteHistory[i] = updated
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	vl.clearResults(vote.PollId, false)
+	vl.emit(EventVoterUpdated, vote.VoterId, vote.PollId)
+	return nil
+}
+
+// DeleteVote removes a vote and its entry from the history of the voter
+func (vl *Voter) DeleteVote(id int) (err error) {
+	defer observe("DeleteVote", time.Now(), &err)
+
+	vote, err := vl.GetVote(id)
+	if err != nil {
+		return err
+	}
+
+	//The history goes first, a frozen voter keeps both
+	err = vl.DeleteVoterPoll(vote.VoterId, vote.PollId)
+	if err != nil && !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrPollNotFound) {
+		return err
+	}
+
+	if err := vl.client.Del(vl.context, vl.voteKey(id)).Err(); err != nil {
+		return err
+	}
+	if err := vl.client.ZRem(vl.context, vl.key(VoteIndexKey), strconv.Itoa(id)).Err(); err != nil {
+		return err
+	}
+	vl.clearResults(vote.PollId, false)
+	return nil
+}
+
+// ErrUndoExpired is returned when a vote is withdrawn after its undo window
+var ErrUndoExpired = fmt.Errorf("%w: the undo window has passed", ErrConflict)
+
+// recorded is when the entry was recorded, its VoteDate for one recorded
+// before RecordedAt was kept
+func (v VoterHistory) recorded() time.Time {
+	if v.RecordedAt == nil {
+		return v.VoteDate
+	}
+	return *v.RecordedAt
+}
+
+// UndoVote withdraws the vote of a voter in a poll within window of when
+// it was recorded, see RecordedAt: the history entry and the vote it was recorded with are
+// removed, so the tally no longer counts it and the voter can vote again.
+// A vote cast longer ago than window is ErrUndoExpired, a secret ballot
+// can not be withdrawn since nothing ties it to the voter.  It returns the
+// entry that was withdrawn
+func (vl *Voter) UndoVote(voterId int, pollId int, window time.Duration, now time.Time) (withdrawn VoterHistory, err error) {
+	defer observe("UndoVote", time.Now(), &err)
+
+	_, _, err = vl.updateHistory(voterId, func(voterItem *VoterItem) error {
+		for i, history := range voterItem.VoteHistory {
+			if history.PollId != pollId {
+				continue
+			}
+			if history.Anonymous {
+				return fmt.Errorf("%w: a secret ballot can not be withdrawn", ErrConflict)
+			}
+			if now.Sub(history.recorded()) > window {
+				return ErrUndoExpired
+			}
+			withdrawn = history
+			voterItem.VoteHistory = append(voterItem.VoteHistory[:i], voterItem.VoteHistory[i+1:]...)
+			return nil
+		}
+		return ErrPollNotFound
+	})
+	if err != nil {
+		return VoterHistory{}, err
+	}
+
+	//An entry added with /voters/:id/polls has no vote of its own
+	if withdrawn.VoteId > 0 {
+		vote, err := vl.GetVote(withdrawn.VoteId)
+		switch {
+		case errors.Is(err, ErrNotFound):
+		case err != nil:
+			return withdrawn, err
+		case vote.VoterId == voterId && vote.PollId == pollId:
+			pipe := vl.client.TxPipeline()
+			pipe.Del(vl.context, vl.voteKey(vote.VoteId))
+			pipe.ZRem(vl.context, vl.key(VoteIndexKey), strconv.Itoa(vote.VoteId))
+			if _, err := pipe.Exec(vl.context); err != nil {
+				return withdrawn, err
+			}
+		}
+	}
+	vl.clearResults(pollId, false)
+
+	vl.emit(EventVoteWithdrawn, voterId, pollId)
+	return withdrawn, nil
+}
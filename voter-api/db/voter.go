@@ -5,8 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
-	"os"
+	"log/slog"
 	"time"
 
 	"github.com/nitishm/go-rejson/v4"
@@ -20,24 +19,168 @@ const (
 )
 
 type cache struct {
+	log        *slog.Logger
 	client     *redis.Client
 	jsonHelper *rejson.Handler
 	context    context.Context
+
+	//tenant is whose data the keys point at and prefix is prepended to
+	//them, see WithTenant
+	tenant string
+	prefix string
 }
 
 // VoterHistory is the struct that represents a single VoterHistory item
+// The validate tags are checked by the api package before anything is
+// written, notfuture is a custom rule registered there.  The xml tags are
+// for the integrators that ask for application/xml.  OptionId is what was
+// voted, it is 0 for write-ins and entries recorded without a choice.  In
+// a ranked poll Ranking is the full order and OptionId the first choice.
+// The entry of a secret ballot is Anonymous and only says the voter voted
+// in the poll, on that day, see AddAnonymousVote.  A Provisional entry
+// waits for its vote to be accepted or rejected
 type VoterHistory struct {
-	PollId   int       `json:"pollId"`
-	VoteId   int       `json:"voteId"`
-	VoteDate time.Time `json:"voteDate"`
+	PollId      int       `json:"pollId" xml:"pollId" validate:"gt=0"`
+	VoteId      int       `json:"voteId" xml:"voteId" validate:"required_unless=Anonymous true,gte=0"`
+	Anonymous   bool      `json:"anonymous,omitempty" xml:"anonymous,omitempty"`
+	Provisional bool      `json:"provisional,omitempty" xml:"provisional,omitempty"`
+	OptionId    int       `json:"optionId,omitempty" xml:"optionId,omitempty" validate:"gte=0"`
+	Ranking     []int     `json:"ranking,omitempty" xml:"ranking>optionId,omitempty" validate:"max=100"`
+	VoteDate    time.Time `json:"voteDate" xml:"voteDate" validate:"required,notfuture"`
+
+	//The hash chain of the history, see chainHistory.  Set by the db
+	//package on every write, whatever a client sends is replaced
+	PrevHash string `json:"prevHash,omitempty" xml:"prevHash,omitempty"`
+	Hash     string `json:"hash,omitempty" xml:"hash,omitempty"`
+
+	//Amendments are the changes UpdateVoterPoll made to the entry, oldest
+	//first.  Set by the db package, whatever a client sends is replaced
+	Amendments []Amendment `json:"amendments,omitempty" xml:"amendments>amendment,omitempty"`
+
+	//RecordedAt is when the entry was recorded, the undo window of UndoVote
+	//runs from it rather than from the VoteDate a client sent.  Set by the
+	//db package, a secret ballot has none so it can not be timed
+	RecordedAt *time.Time `json:"recordedAt,omitempty" xml:"recordedAt,omitempty"`
+}
+
+// AmendedVote is what a history entry recorded before or after it was
+// amended
+type AmendedVote struct {
+	VoteId      int       `json:"voteId" xml:"voteId"`
+	OptionId    int       `json:"optionId,omitempty" xml:"optionId,omitempty"`
+	Ranking     []int     `json:"ranking,omitempty" xml:"ranking>optionId,omitempty"`
+	Provisional bool      `json:"provisional,omitempty" xml:"provisional,omitempty"`
+	VoteDate    time.Time `json:"voteDate" xml:"voteDate"`
+}
+
+// Amendment is one change to a history entry, who made it and when
+type Amendment struct {
+	Old       AmendedVote `json:"old" xml:"old"`
+	New       AmendedVote `json:"new" xml:"new"`
+	AmendedAt time.Time   `json:"amendedAt" xml:"amendedAt"`
+	Actor     string      `json:"actor,omitempty" xml:"actor,omitempty"`
+}
+
+// amendedVote is the part of a history entry an amendment keeps
+func amendedVote(history VoterHistory) AmendedVote {
+	return AmendedVote{
+		VoteId:      history.VoteId,
+		OptionId:    history.OptionId,
+		Ranking:     history.Ranking,
+		Provisional: history.Provisional,
+		VoteDate:    history.VoteDate,
+	}
+}
+
+// amend gives an updated history entry the amendments of the entry it
+// replaces, plus one for the change with the actor making it.  The entry
+// keeps what it recorded before, an update that changes nothing is not an
+// amendment
+func amend(updated *VoterHistory, previous VoterHistory, actor string) {
+	updated.Amendments = previous.Amendments
+	was, now := amendedVote(previous), amendedVote(*updated)
+	if was.equal(now) {
+		return
+	}
+	updated.Amendments = append(updated.Amendments, Amendment{
+		Old:       was,
+		New:       now,
+		AmendedAt: time.Now().UTC(),
+		Actor:     actor,
+	})
 }
 
+// keepServerFields gives the entries of a history written by a client the
+// amendments and the RecordedAt the entry of the same poll had in
+// previous, so a client can neither drop nor make up amendments nor move
+// the undo window.  A new entry has no amendments and is recorded now
+func keepServerFields(history []VoterHistory, previous []VoterHistory) {
+	recorded := make(map[int]VoterHistory, len(previous))
+	for _, entry := range previous {
+		recorded[entry.PollId] = entry
+	}
+	now := time.Now().UTC()
+	for i := range history {
+		entry, found := recorded[history[i].PollId]
+		history[i].Amendments = entry.Amendments
+		history[i].RecordedAt = entry.RecordedAt
+		if !found && !history[i].Anonymous {
+			history[i].RecordedAt = &now
+		}
+	}
+}
+
+// equal reports whether two amended values recorded the same vote
+func (v AmendedVote) equal(other AmendedVote) bool {
+	if v.VoteId != other.VoteId || v.OptionId != other.OptionId || v.Provisional != other.Provisional ||
+		!v.VoteDate.Equal(other.VoteDate) || len(v.Ranking) != len(other.Ranking) {
+		return false
+	}
+	for i := range v.Ranking {
+		if v.Ranking[i] != other.Ranking[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Limits on what a voter may hold, so a client can not fill redis with
+// one enormous record.  The validate tags of VoterItem repeat them, tags
+// can not refer to constants
+const (
+	MaxNameLength   = 200
+	MaxEmailLength  = 254
+	MaxRegionLength = 100
+	MaxVoteHistory  = 1000
+)
+
 // Voter is the struct that represents a single Voter item
 type VoterItem struct {
-	VoterId     int            `json:"voterId"`
-	Name        string         `json:"name"`
-	Email       string         `json:"email"`
-	VoteHistory []VoterHistory `json:"voteHistory"`
+	VoterId     int            `json:"voterId" xml:"voterId" validate:"gt=0"`
+	Name        string         `json:"name" xml:"name" validate:"required,max=200"`
+	Email       string         `json:"email" xml:"email" validate:"required,email,max=254"`
+	Phone       string         `json:"phone,omitempty" xml:"phone,omitempty" validate:"omitempty,e164"`
+	VoteHistory []VoterHistory `json:"voteHistory" xml:"voteHistory>poll" validate:"max=1000,dive"`
+	Frozen      bool           `json:"frozen,omitempty" xml:"frozen,omitempty"`
+	Status      string         `json:"status,omitempty" xml:"status,omitempty" validate:"omitempty,oneof=pending active inactive suspended purged"`
+	Verified    bool           `json:"verified,omitempty" xml:"verified,omitempty"`
+	PrecinctId  int            `json:"precinctId,omitempty" xml:"precinctId,omitempty" validate:"gte=0"`
+	HistoryHash string         `json:"historyHash,omitempty" xml:"historyHash,omitempty"`
+
+	//EmailOptOut stops the emails that are not needed to take part, the
+	//verification link is still sent
+	EmailOptOut bool `json:"emailOptOut,omitempty" xml:"emailOptOut,omitempty"`
+
+	//Optional demographics, only ever reported in aggregate, see
+	//GetTurnoutBreakdown.  RegisteredAt defaults to when the voter was added
+	AgeBand      string     `json:"ageBand,omitempty" xml:"ageBand,omitempty" validate:"omitempty,oneof=18-24 25-34 35-44 45-54 55-64 65+"`
+	Region       string     `json:"region,omitempty" xml:"region,omitempty" validate:"omitempty,max=100"`
+	RegisteredAt *time.Time `json:"registeredAt,omitempty" xml:"registeredAt,omitempty" validate:"omitempty,notfuture"`
+
+	//Weight is what the votes of the voter count for in a Weighted poll,
+	//the shares of a shareholder say.  Unset counts once.  Only admins set
+	//it, see SetVoterWeight
+	Weight float64 `json:"weight,omitempty" xml:"weight,omitempty" validate:"gte=0,lte=1000000000"`
 }
 
 type Voter struct {
@@ -45,33 +188,37 @@ type Voter struct {
 }
 
 // New is a constructor function that returns a pointer to a new VoterList struct
-// It uses the default Redis URL with NewVoterListWithCacheInstance.
-func New() (*Voter, error) {
-	redisURL := os.Getenv("REDIS_URL")
-	if redisURL == "" {
-		redisURL = RedisDefaultLocation
+// It reads the redis settings from the environment with ConfigFromEnv.
+func New(logger *slog.Logger) (*Voter, error) {
+	cfg, err := ConfigFromEnv()
+	if err != nil {
+		return nil, err
 	}
-	log.Println("DEBUG: USING REDIS URL: ", redisURL)
-	return NewWithCacheInstance(redisURL)
+	logger.Debug("Using redis", "addr", cfg.Addr)
+	return NewWithConfig(cfg, logger)
 }
 
+// NewWithCacheInstance connects to the redis server at location using the
+// default pool and timeout settings
 func NewWithCacheInstance(location string) (*Voter, error) {
-	client := redis.NewClient(&redis.Options{
-		Addr: location,
-	})
+	return NewWithConfig(Config{Addr: location}, slog.Default())
+}
 
-	ctx := context.Background()
+// NewWithConfig connects to redis using the provided Config, logging
+// to logger
+func NewWithConfig(cfg Config, logger *slog.Logger) (*Voter, error) {
+	client := redis.NewClient(cfg.options())
 
-	err := client.Ping(ctx).Err()
-	if err != nil {
-		fmt.Println("Error connecting to redis" + err.Error() + "cache might not be available, continuing...")
-	}
+	//Note we do not ping redis here, go-redis connects lazily on the
+	//first command.  Use WaitForRedis to block until it is reachable
+	ctx := context.Background()
 
 	jsonHelper := rejson.NewReJSONHandler()
 	jsonHelper.SetGoRedisClientWithContext(ctx, client)
 
 	return &Voter{
 		cache: cache{
+			log:        logger,
 			client:     client,
 			jsonHelper: jsonHelper,
 			context:    ctx,
@@ -79,6 +226,63 @@ func NewWithCacheInstance(location string) (*Voter, error) {
 	}, nil
 }
 
+// WithContext returns a copy of the Voter whose commands use ctx, so they
+// are abandoned when ctx is cancelled or its deadline passes.  The copy
+// shares the connection pool
+func (vl *Voter) WithContext(ctx context.Context) *Voter {
+	jsonHelper := rejson.NewReJSONHandler()
+	jsonHelper.SetGoRedisClientWithContext(ctx, vl.client)
+
+	return &Voter{
+		cache: cache{
+			log:        vl.log,
+			client:     vl.client,
+			jsonHelper: jsonHelper,
+			context:    ctx,
+			tenant:     vl.tenant,
+			prefix:     vl.prefix,
+		},
+	}
+}
+
+// detached returns a copy of the Voter whose commands go on when the
+// request is cancelled, for the steps of a write that has to finish once
+// its first step is stored, and for taking that step back
+func (vl *Voter) detached() *Voter {
+	return vl.WithContext(context.WithoutCancel(vl.context))
+}
+
+// WaitForRedis pings redis until it answers or the timeout expires.  The
+// delay between attempts starts small and doubles up to a few seconds, so
+// when docker compose starts the api before redis is ready we connect as
+// soon as it is up without hammering it
+func (vl *Voter) WaitForRedis(ctx context.Context, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	delay := 100 * time.Millisecond
+	const maxDelay = 5 * time.Second
+
+	for attempt := 1; ; attempt++ {
+		err := vl.client.Ping(ctx).Err()
+		if err == nil {
+			return nil
+		}
+		vl.log.Info("Waiting for redis", "attempt", attempt, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("redis not reachable after %s: %w", timeout, err)
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > maxDelay {
+			delay = maxDelay
+		}
+	}
+}
+
 //------------------------------------------------------------
 // REDIS HELPERS
 //------------------------------------------------------------
@@ -91,26 +295,30 @@ func isRedisNilError(err error) bool {
 // In redis, our keys will be strings, they will look like
 // todo:<number>.  This function will take an integer and
 // return a string that can be used as a key in redis
-func redisKeyFromId(id int) string {
-	return fmt.Sprintf("%s%d", RedisKeyPrefix, id)
+func (vl *Voter) redisKeyFromId(id int) string {
+	return vl.key(fmt.Sprintf("%s%d", RedisKeyPrefix, id))
 }
 
 // getAllKeys will return all keys in the database that match the prefix
 // used in this application - RedisKeyPrefix.  It will return a string slice
 // of all keys.  Used by GetAll and DeleteAll
 func (vl *Voter) getAllKeys() ([]string, error) {
-	key := fmt.Sprintf("%s*", RedisKeyPrefix)
+	key := vl.key(RedisKeyPrefix + "*")
 	return vl.cache.client.Keys(vl.context, key).Result()
 }
 
 // Helper to return a ToDoItem from redis provided a key
-func (vl *Voter) getVoterFromRedis(voterID string, voterItem *VoterItem) error {
+func (vl *Voter) getVoterFromRedis(voterID string, voterItem *VoterItem) (err error) {
+	defer observe("JSONGet", time.Now(), &err)
 
 	//Lets query redis for the item, note we can return parts of the
 	//json structure, the second parameter "." means return the entire
 	//json structure
 	itemObject, err := vl.jsonHelper.JSONGet(voterID, ".")
 	if err != nil {
+		if isRedisNilError(err) {
+			return ErrNotFound
+		}
 		return err
 	}
 
@@ -127,82 +335,194 @@ func (vl *Voter) getVoterFromRedis(voterID string, voterItem *VoterItem) error {
 }
 
 // AddVoter adds a new voter to the database
-func (vl *Voter) AddVoter(voterItem VoterItem) error {
+func (vl *Voter) AddVoter(voterItem VoterItem) (err error) {
+	defer observe("AddVoter", time.Now(), &err)
 
 	//Before we add an item to the DB, lets make sure
 	//it does not exist, if it does, return an error
-	redisKey := redisKeyFromId(voterItem.VoterId)
+	redisKey := vl.redisKeyFromId(voterItem.VoterId)
 	var existingItem VoterItem
 	if err := vl.getVoterFromRedis(redisKey, &existingItem); err == nil {
-		return errors.New("voter already exists")
+		return ErrAlreadyExists
 	}
 
+	if err := checkHistory(voterItem.VoteHistory); err != nil {
+		return err
+	}
+	if err := vl.checkPrecincts(voterItem.PrecinctId); err != nil {
+		return err
+	}
+
+	newVoterDefaults(&voterItem)
+	keepServerFields(voterItem.VoteHistory, nil)
+	chainHistory(&voterItem)
+
 	//Add item to database with JSON Set
 	if _, err := vl.jsonHelper.JSONSet(redisKey, ".", voterItem); err != nil {
 		return err
 	}
 
+	vl.indexVoter(voterItem)
+	vl.emit(EventVoterCreated, voterItem.VoterId, 0)
+
 	//If everything is ok, return nil for the error
 	return nil
 }
 
+// newVoterDefaults sets what a new voter starts out with: not frozen, that
+// only happens with SetVoterFrozen, not verified, that takes VerifyVoter
+// or SetVoterStatus, active unless it registered itself and registered
+// now unless the request said when
+func newVoterDefaults(voterItem *VoterItem) {
+	voterItem.Frozen = false
+	voterItem.Verified = false
+	if voterItem.Status == "" {
+		voterItem.Status = VoterStatusActive
+	}
+	if voterItem.RegisteredAt == nil {
+		now := time.Now().UTC()
+		voterItem.RegisteredAt = &now
+	}
+}
+
 // DeleteVoter deletes a voter from the database
-func (vl *Voter) DeleteVoter(id int) error {
+func (vl *Voter) DeleteVoter(id int) (err error) {
+	defer observe("DeleteVoter", time.Now(), &err)
+
+	frozen, err := vl.isFrozen(id)
+	if err != nil {
+		return err
+	}
+	if frozen {
+		return ErrFrozen
+	}
+
+	//Read the voter first, we need its email to update the email index
+	pattern := vl.redisKeyFromId(id)
+	var voterItem VoterItem
+	if err := vl.getVoterFromRedis(pattern, &voterItem); err != nil {
+		return err
+	}
 
-	pattern := redisKeyFromId(id)
 	numDeleted, err := vl.client.Del(vl.context, pattern).Result()
 	if err != nil {
 		return err
 	}
 	if numDeleted == 0 {
-		return errors.New("attempted to delete non-existent voterr")
+		return ErrNotFound
 	}
 
+	vl.unindexVoter(voterItem)
+	vl.emit(EventVoterDeleted, id, 0)
+
 	return nil
 }
 
-// DeleteAll deletes all voters from the database
-func (vl *Voter) DeleteAll() (int, error) {
-	keyList, err := vl.getAllKeys()
+// DeleteAll deletes all voters from the database, except frozen voters
+// which have to be unfrozen first
+func (vl *Voter) DeleteAll() (count int, err error) {
+	defer observe("DeleteAll", time.Now(), &err)
+
+	allKeys, err := vl.getAllKeys()
 	if err != nil {
 		return 0, err
 	}
 
+	frozenIds, err := vl.client.SMembers(vl.context, vl.key(FrozenVotersKey)).Result()
+	if err != nil {
+		return 0, err
+	}
+	frozenKeys := make(map[string]bool)
+	for _, id := range frozenIds {
+		frozenKeys[vl.key(RedisKeyPrefix+id)] = true
+	}
+
+	var keyList []string
+	for _, key := range allKeys {
+		if !frozenKeys[key] {
+			keyList = append(keyList, key)
+		}
+	}
+	if len(keyList) == 0 {
+		return 0, nil
+	}
+
 	//Notice how we can deconstruct the slice into a variadic argument
 	//for the Del function by using the ... operator
 	numDeleted, err := vl.client.Del(vl.context, keyList...).Result()
-	return int(numDeleted), err
+	if err != nil {
+		return int(numDeleted), err
+	}
+
+	//Only frozen voters are left, so rebuilding the indexes is cheaper
+	//than removing every deleted voter from them one at a time
+	if _, err := vl.RebuildIndexes(); err != nil {
+		vl.log.Error("Error rebuilding indexes after delete all", "error", err)
+	}
+	for _, key := range keyList {
+		if id, err := vl.voterIdFromKey(key); err == nil {
+			vl.emit(EventVoterDeleted, id, 0)
+		}
+	}
+
+	return int(numDeleted), nil
 }
 
 // UpdateVoter updates a voter in the database
-func (vl *Voter) UpdateVoter(voterItem VoterItem) error {
+func (vl *Voter) UpdateVoter(voterItem VoterItem) (err error) {
+	defer observe("UpdateVoter", time.Now(), &err)
 
-	//Before we add an item to the DB, lets make sure
-	//it does not exist, if it does, return an error
-	redisKey := redisKeyFromId(voterItem.VoterId)
-	var existingItem VoterItem
-	if err := vl.getVoterFromRedis(redisKey, &existingItem); err != nil {
-		return errors.New("voter does not exist")
+	if err := vl.saveExistingVoter(voterItem); err != nil {
+		return err
 	}
 
-	//Add item to database with JSON Set.  Note there is no update
-	//functionality, so we just overwrite the existing item
-	if _, err := vl.jsonHelper.JSONSet(redisKey, ".", voterItem); err != nil {
+	vl.emit(EventVoterUpdated, voterItem.VoterId, 0)
+
+	return nil
+}
+
+// saveExistingVoter overwrites a voter that must already exist.  It does
+// not publish an event, so the poll methods can publish a more specific one
+func (vl *Voter) saveExistingVoter(voterItem VoterItem) error {
+	if err := checkHistory(voterItem.VoteHistory); err != nil {
+		return err
+	}
+	if err := vl.checkPrecincts(voterItem.PrecinctId); err != nil {
 		return err
 	}
 
-	//If everything is ok, return nil for the error
-	return nil
+	//The voter is read and overwritten under a WATCH, a vote recorded by
+	//AddVoterPoll in between makes us read it again.  A voter that does not
+	//exist is ErrNotFound, a frozen one ErrFrozen
+	_, _, err := vl.watchVoter(voterItem.VoterId, func(existingItem *VoterItem) error {
+		//The status only changes with SetVoterStatus and VerifyVoter, the
+		//weight with SetVoterWeight.  A new email is not verified
+		voterItem.Frozen = false
+		voterItem.Status = existingItem.Status
+		voterItem.Verified = existingItem.Verified && existingItem.HasEmail(voterItem.Email)
+		voterItem.Weight = existingItem.Weight
+		if voterItem.RegisteredAt == nil {
+			voterItem.RegisteredAt = existingItem.RegisteredAt
+		}
+		keepServerFields(voterItem.VoteHistory, existingItem.VoteHistory)
+		chainHistory(&voterItem)
+
+		//There is no update functionality, so we just overwrite the
+		//existing item
+		*existingItem = voterItem
+		return nil
+	}, vl.setVoter)
+	return err
 }
 
-func (vl *Voter) GetVoter(id int) (VoterItem, error) {
+func (vl *Voter) GetVoter(id int) (voterItem VoterItem, err error) {
+	defer observe("GetVoter", time.Now(), &err)
 
 	// Check if item exists before trying to get it
 	// this is a good practice, return an error if the
 	// item does not exist
-	var voterItem VoterItem
-	pattern := redisKeyFromId(id)
-	err := vl.getVoterFromRedis(pattern, &voterItem)
+	pattern := vl.redisKeyFromId(id)
+	err = vl.getVoterFromRedis(pattern, &voterItem)
 	if err != nil {
 		return VoterItem{}, err
 	}
@@ -210,6 +530,31 @@ func (vl *Voter) GetVoter(id int) (VoterItem, error) {
 	return voterItem, nil
 }
 
+// Ping checks that redis is reachable and returns how long the round trip took
+func (vl *Voter) Ping() (latency time.Duration, err error) {
+	defer observe("Ping", time.Now(), &err)
+
+	start := time.Now()
+	if err := vl.client.Ping(vl.context).Err(); err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
+}
+
+// PoolStats returns the connection pool statistics of the redis client
+func (vl *Voter) PoolStats() *redis.PoolStats {
+	return vl.client.PoolStats()
+}
+
+// CountVoters returns the number of voters in the database.  It counts
+// the id index rather than the keys, so it is cheap enough for HEAD /voters
+func (vl *Voter) CountVoters() (count int, err error) {
+	defer observe("CountVoters", time.Now(), &err)
+
+	n, err := vl.client.ZCard(vl.context, vl.key(VoterIndexKey)).Result()
+	return int(n), err
+}
+
 // GetAllItems returns all items from the DB.  If successful it
 // returns a slice of all of the items to the caller
 // Preconditions:   (1) The database file must exist and be a valid
@@ -220,16 +565,16 @@ func (vl *Voter) GetVoter(id int) (VoterItem, error) {
 //		(2) If there is an error, it will be returned
 //			along with an empty slice
 //		(3) The database file will not be modified
-func (vl *Voter) GetAllVoters() ([]VoterItem, error) {
-
-	//Now that we have the DB loaded, lets crate a slice
-	var voterList []VoterItem
-	var voterItem VoterItem
+func (vl *Voter) GetAllVoters() (voterList []VoterItem, err error) {
+	defer observe("GetAllVoters", time.Now(), &err)
 
 	//Lets query redis for all of the items
-	pattern := RedisKeyPrefix + "*"
+	pattern := vl.key(RedisKeyPrefix + "*")
 	ks, _ := vl.client.Keys(vl.context, pattern).Result()
 	for _, key := range ks {
+		//A fresh item per voter, unmarshalling into the previous one would
+		//reuse the backing array of its vote history
+		var voterItem VoterItem
 		err := vl.getVoterFromRedis(key, &voterItem)
 		if err != nil {
 			return nil, err
@@ -242,7 +587,9 @@ func (vl *Voter) GetAllVoters() ([]VoterItem, error) {
 
 // GetVoterPolls retrieves the voting history for a specific voter.
 // It takes voter ID as input and returns their voting history as a slice of VoterHistory.
-func (vl *Voter) GetVoterPolls(voterID int) ([]VoterHistory, error) {
+func (vl *Voter) GetVoterPolls(voterID int) (historyList []VoterHistory, err error) {
+	defer observe("GetVoterPolls", time.Now(), &err)
+
 	voterItem, err := vl.GetVoter(voterID)
 	if err != nil {
 		return nil, err
@@ -253,7 +600,9 @@ func (vl *Voter) GetVoterPolls(voterID int) ([]VoterHistory, error) {
 
 // GetVoterPoll retrieves a specific voting record for a voter.
 // It takes voter ID and poll ID as input and returns the corresponding VoterHistory if found.
-func (vl *Voter) GetVoterPoll(voterID, pollID int) (VoterHistory, error) {
+func (vl *Voter) GetVoterPoll(voterID, pollID int) (history VoterHistory, err error) {
+	defer observe("GetVoterPoll", time.Now(), &err)
+
 	voterItem, err := vl.GetVoter(voterID)
 	if err != nil {
 		return VoterHistory{}, err
@@ -265,71 +614,227 @@ func (vl *Voter) GetVoterPoll(voterID, pollID int) (VoterHistory, error) {
 		}
 	}
 
-	return VoterHistory{}, errors.New("poll not found for this voter")
+	return VoterHistory{}, ErrPollNotFound
 }
 
-// AddVoterPoll adds a new voting record for a voter.
-func (vl *Voter) AddVoterPoll(voterPoll VoterHistory, voterId int) error {
-	voterItem, err := vl.GetVoter(voterId)
+// AddVoterPoll adds a new voting record for a voter.  Only active voters
+// with a verified email can vote, see ErrNotActive and ErrNotVerified,
+// pending ones provisionally, and only once per poll: a second record for
+// the same poll is ErrConflict, also when both are sent at the same time
+func (vl *Voter) AddVoterPoll(voterPoll VoterHistory, voterId int) (err error) {
+	defer observe("AddVoterPoll", time.Now(), &err)
+
+	_, _, err = vl.updateHistory(voterId, func(voterItem *VoterItem) error {
+		pendingProvisional := voterPoll.Provisional && voterItem.status() == VoterStatusPending
+		if !voterItem.Active() && !pendingProvisional {
+			return fmt.Errorf("%w: voter %d is %s", ErrNotActive, voterId, voterItem.status())
+		}
+		if !voterItem.Verified && !pendingProvisional {
+			return fmt.Errorf("%w: voter %d", ErrNotVerified, voterId)
+		}
+		for _, vh := range voterItem.VoteHistory {
+			if vh.PollId == voterPoll.PollId {
+				return fmt.Errorf("%w: voter %d already voted in poll %d", ErrConflict, voterId, voterPoll.PollId)
+			}
+		}
+		if len(voterItem.VoteHistory) >= MaxVoteHistory {
+			return fmt.Errorf("%w: vote history is limited to %d polls", ErrInvalid, MaxVoteHistory)
+		}
+
+		voterPoll.Amendments = nil
+		voterPoll.RecordedAt = nil
+		if !voterPoll.Anonymous {
+			now := time.Now().UTC()
+			voterPoll.RecordedAt = &now
+		}
+		voterItem.VoteHistory = append(voterItem.VoteHistory, voterPoll)
+		return nil
+	})
 	if err != nil {
 		return err
 	}
 
-	for _, vh := range voterItem.VoteHistory {
-		if vh.PollId == voterPoll.PollId {
-			return errors.New("poll already exists")
+	vl.countVoteRate(voterPoll.PollId, time.Now())
+	vl.clearResults(voterPoll.PollId, false)
+	vl.emit(EventVoteRecorded, voterId, voterPoll.PollId)
+
+	return nil
+}
+
+// UpdateVoterPoll updates a voting record for a voter.  A change to what
+// it recorded is kept as an amendment of the record with the actor making
+// it, so a vote is never silently overwritten.  When it was recorded and
+// whether it is provisional or secret are not for the update to change.
+// It returns the updated record
+func (vl *Voter) UpdateVoterPoll(voterPoll VoterHistory, voterId int, pollId int, actor string) (updated VoterHistory, err error) {
+	defer observe("UpdateVoterPoll", time.Now(), &err)
+
+	_, newItem, err := vl.updateHistory(voterId, func(voterItem *VoterItem) error {
+		for i, vh := range voterItem.VoteHistory {
+			if vh.PollId == pollId {
+				voterPoll.PollId = pollId
+				voterPoll.VoteDate = vh.VoteDate
+				voterPoll.Provisional = vh.Provisional
+				voterPoll.Anonymous = vh.Anonymous
+				voterPoll.RecordedAt = vh.RecordedAt
+
+				amend(&voterPoll, vh, actor)
+				voterItem.VoteHistory[i] = voterPoll
+				return nil
+			}
+		}
+		return ErrPollNotFound
+	})
+	if err != nil {
+		return VoterHistory{}, err
+	}
+	for _, history := range newItem.VoteHistory {
+		if history.PollId == pollId {
+			updated = history
 		}
 	}
 
-	voterItem.VoteHistory = append(voterItem.VoteHistory, voterPoll)
+	vl.clearResults(pollId, false)
+	vl.emit(EventVoterUpdated, voterId, pollId)
+
+	return updated, nil
+}
 
-	err = vl.UpdateVoter(voterItem)
+// DeleteVoterPoll deletes a voting record for a voter.
+func (vl *Voter) DeleteVoterPoll(voterID, pollID int) (err error) {
+	defer observe("DeleteVoterPoll", time.Now(), &err)
+
+	_, _, err = vl.updateHistory(voterID, func(voterItem *VoterItem) error {
+		for i, history := range voterItem.VoteHistory {
+			if history.PollId == pollID {
+				voterItem.VoteHistory = append(voterItem.VoteHistory[:i], voterItem.VoteHistory[i+1:]...)
+				return nil
+			}
+		}
+		return ErrPollNotFound
+	})
 	if err != nil {
 		return err
 	}
 
+	vl.clearResults(pollID, false)
+	vl.emit(EventVoterUpdated, voterID, pollID)
+
 	return nil
 }
 
-// UpdateVoterPoll updates a voting record for a voter.
-func (vl *Voter) UpdateVoterPoll(voterPoll VoterHistory, voterId int, pollId int) error {
-	voterItem, err := vl.GetVoter(voterId)
-	if err != nil {
+// maxHistoryRetries is how often updateHistory tries again when another
+// write to the same voter got in between its read and its write
+const maxHistoryRetries = 5
+
+// updateHistory changes the vote history of a voter in a WATCH/MULTI
+// transaction.  Two requests for the same voter can not both read the
+// old history, so a vote is never lost or recorded twice: the second one
+// sees the first and change can reject it.  change edits the stored voter
+// in place, an error from it aborts.  Only the history is written, chained
+// again, see chainHistory
+func (vl *Voter) updateHistory(voterId int, change func(voterItem *VoterItem) error) (oldItem VoterItem, newItem VoterItem, err error) {
+	return vl.watchVoter(voterId, func(voterItem *VoterItem) error {
+		if err := change(voterItem); err != nil {
+			return err
+		}
+		if err := checkHistory(voterItem.VoteHistory); err != nil {
+			return err
+		}
+		chainHistory(voterItem)
+		return nil
+	}, vl.setHistory)
+}
+
+// watchVoter reads a voter, lets change edit it and queues write for it,
+// all under a WATCH of the voter so nothing written to it in between is
+// lost.  It tries again maxHistoryRetries times before it gives up with
+// ErrConflict
+func (vl *Voter) watchVoter(voterId int, change func(voterItem *VoterItem) error,
+	write func(pipe redis.Pipeliner, voterItem VoterItem) error) (oldItem VoterItem, newItem VoterItem, err error) {
+	redisKey := vl.redisKeyFromId(voterId)
+
+	update := func(tx *redis.Tx) error {
+		var err error
+		if oldItem, err = vl.getWatchedVoter(tx, redisKey); err != nil {
+			return err
+		}
+
+		newItem = oldItem
+		newItem.VoteHistory = append([]VoterHistory{}, oldItem.VoteHistory...)
+		if err := change(&newItem); err != nil {
+			return err
+		}
+
+		//The write only happens if nobody touched the voter since the
+		//read, otherwise Exec fails with TxFailedErr and we go again
+		_, err = tx.TxPipelined(vl.context, func(pipe redis.Pipeliner) error {
+			return write(pipe, newItem)
+		})
 		return err
 	}
 
-	for i, vh := range voterItem.VoteHistory {
-		if vh.PollId == pollId {
-			voterItem.VoteHistory[i] = voterPoll
-			if err := vl.UpdateVoter(voterItem); err != nil {
-				return err
-			}
-			return nil
+	for attempt := 0; attempt < maxHistoryRetries; attempt++ {
+		err = vl.client.Watch(vl.context, update, redisKey)
+		if !errors.Is(err, redis.TxFailedErr) {
+			break
 		}
 	}
+	if errors.Is(err, redis.TxFailedErr) {
+		return VoterItem{}, VoterItem{}, fmt.Errorf("%w: voter %d is being changed by another request", ErrConflict, voterId)
+	}
+	if err != nil {
+		return VoterItem{}, VoterItem{}, err
+	}
 
-	return errors.New("poll not found for this voter")
+	vl.reindexVoter(oldItem, newItem)
+	return oldItem, newItem, nil
 }
 
-// DeleteVoterPoll deletes a voting record for a voter.
-func (vl *Voter) DeleteVoterPoll(voterID, pollID int) error {
-	voterItem, err := vl.GetVoter(voterID)
+// setVoter queues the write of a whole voter
+func (vl *Voter) setVoter(pipe redis.Pipeliner, voterItem VoterItem) error {
+	voterBytes, err := json.Marshal(voterItem)
 	if err != nil {
 		return err
 	}
+	pipe.Do(vl.context, "JSON.SET", vl.redisKeyFromId(voterItem.VoterId), ".", string(voterBytes))
+	return nil
+}
 
-	for i, history := range voterItem.VoteHistory {
-		if history.PollId == pollID {
-			voterItem.VoteHistory = append(voterItem.VoteHistory[:i], voterItem.VoteHistory[i+1:]...)
-			err := vl.UpdateVoter(voterItem)
-			if err != nil {
-				return err
-			}
-			return nil
-		}
+// getWatchedVoter reads a voter inside a WATCH, ErrFrozen for a frozen one
+// since every caller is about to change it
+func (vl *Voter) getWatchedVoter(tx *redis.Tx, redisKey string) (VoterItem, error) {
+	get := redis.NewCmd(vl.context, "JSON.GET", redisKey, ".")
+	_ = tx.Process(vl.context, get)
+	value, err := get.Text()
+	if isRedisNilError(err) {
+		return VoterItem{}, ErrNotFound
+	}
+	if err != nil {
+		return VoterItem{}, err
 	}
 
-	return errors.New("poll not found for this voter")
+	var voterItem VoterItem
+	if err := json.Unmarshal([]byte(value), &voterItem); err != nil {
+		return VoterItem{}, err
+	}
+	if voterItem.Frozen {
+		return VoterItem{}, ErrFrozen
+	}
+	return voterItem, nil
+}
+
+// checkHistory rejects a vote history with the same poll twice, a voter
+// votes once per poll
+func checkHistory(history []VoterHistory) error {
+	seen := make(map[int]bool, len(history))
+	for _, vh := range history {
+		if seen[vh.PollId] {
+			return fmt.Errorf("%w: poll %d is in the vote history twice", ErrConflict, vh.PollId)
+		}
+		seen[vh.PollId] = true
+	}
+	return nil
 }
 
 // PrintItem accepts a ToDoItem and prints it to the console
@@ -0,0 +1,222 @@
+package db
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// WebhookHashKey is the redis hash of webhook subscriptions by id
+	WebhookHashKey = "webhooks"
+	// WebhookRetryKey is a sorted set of failed deliveries scored by the
+	// unix time of their next attempt
+	WebhookRetryKey = "webhooks:retry"
+	// WebhookDeadLetterKey is a hash of deliveries that ran out of
+	// attempts, keyed by the delivery id
+	WebhookDeadLetterKey = "webhooks:deadletter"
+)
+
+// Webhook is a URL another system registered to be told about changes.
+// Events lists the event types it wants, all of them when empty.  The
+// secret signs every delivery so the receiver can check it came from us.
+// Tenant is the tenant of the credentials that registered it, it is only
+// told about the events of that tenant, every tenant for "*"
+type Webhook struct {
+	Id        string    `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret"`
+	Tenant    string    `json:"tenant,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// WebhookDelivery is one payload on its way to a webhook.  The payload is
+// the JSON body that is sent, the db package does not need to know what is
+// inside of it
+type WebhookDelivery struct {
+	Id          string          `json:"id"`
+	WebhookId   string          `json:"webhookId"`
+	Event       string          `json:"event"`
+	Payload     json.RawMessage `json:"payload"`
+	Attempts    int             `json:"attempts"`
+	LastError   string          `json:"lastError,omitempty"`
+	NextAttempt time.Time       `json:"nextAttempt"`
+}
+
+// AddWebhook stores a webhook subscription
+func (vl *Voter) AddWebhook(webhook Webhook) (err error) {
+	defer observe("AddWebhook", time.Now(), &err)
+
+	webhookBytes, err := json.Marshal(webhook)
+	if err != nil {
+		return err
+	}
+
+	return vl.client.HSet(vl.context, WebhookHashKey, webhook.Id, webhookBytes).Err()
+}
+
+// GetWebhook returns one webhook subscription
+func (vl *Voter) GetWebhook(id string) (webhook Webhook, err error) {
+	defer observe("GetWebhook", time.Now(), &err)
+
+	value, err := vl.client.HGet(vl.context, WebhookHashKey, id).Result()
+	if err != nil {
+		if isRedisNilError(err) {
+			return Webhook{}, ErrNotFound
+		}
+		return Webhook{}, err
+	}
+
+	err = json.Unmarshal([]byte(value), &webhook)
+	return webhook, err
+}
+
+// GetAllWebhooks returns every webhook subscription
+func (vl *Voter) GetAllWebhooks() (webhookList []Webhook, err error) {
+	defer observe("GetAllWebhooks", time.Now(), &err)
+
+	entries, err := vl.client.HGetAll(vl.context, WebhookHashKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, value := range entries {
+		var webhook Webhook
+		if err := json.Unmarshal([]byte(value), &webhook); err != nil {
+			return nil, err
+		}
+		webhookList = append(webhookList, webhook)
+	}
+
+	return webhookList, nil
+}
+
+// DeleteWebhook removes a webhook subscription, deliveries already queued
+// for it are dropped when they come up
+func (vl *Voter) DeleteWebhook(id string) (err error) {
+	defer observe("DeleteWebhook", time.Now(), &err)
+
+	numDeleted, err := vl.client.HDel(vl.context, WebhookHashKey, id).Result()
+	if err != nil {
+		return err
+	}
+	if numDeleted == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ScheduleWebhookRetry puts a failed delivery on the retry queue, it will
+// be returned by PopDueWebhookRetries after delivery.NextAttempt
+func (vl *Voter) ScheduleWebhookRetry(delivery WebhookDelivery) (err error) {
+	defer observe("ScheduleWebhookRetry", time.Now(), &err)
+
+	deliveryBytes, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+
+	return vl.client.ZAdd(vl.context, WebhookRetryKey, redis.Z{
+		Score:  float64(delivery.NextAttempt.Unix()),
+		Member: deliveryBytes,
+	}).Err()
+}
+
+// PopDueWebhookRetries removes and returns every delivery whose next
+// attempt time has passed.  ZRem claims each one, so when several
+// replicas drain the queue only one of them gets a given delivery
+func (vl *Voter) PopDueWebhookRetries(now time.Time) (dueList []WebhookDelivery, err error) {
+	defer observe("PopDueWebhookRetries", time.Now(), &err)
+
+	members, err := vl.client.ZRangeByScore(vl.context, WebhookRetryKey, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(now.Unix(), 10),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, member := range members {
+		claimed, err := vl.client.ZRem(vl.context, WebhookRetryKey, member).Result()
+		if err != nil {
+			return nil, err
+		}
+		if claimed == 0 {
+			continue
+		}
+
+		var delivery WebhookDelivery
+		if err := json.Unmarshal([]byte(member), &delivery); err != nil {
+			return nil, err
+		}
+		dueList = append(dueList, delivery)
+	}
+
+	return dueList, nil
+}
+
+// AddWebhookDeadLetter parks a delivery that will not be retried again
+// automatically
+func (vl *Voter) AddWebhookDeadLetter(delivery WebhookDelivery) (err error) {
+	defer observe("AddWebhookDeadLetter", time.Now(), &err)
+
+	deliveryBytes, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+
+	return vl.client.HSet(vl.context, WebhookDeadLetterKey, delivery.Id, deliveryBytes).Err()
+}
+
+// GetWebhookDeadLetters returns every delivery on the dead-letter list
+func (vl *Voter) GetWebhookDeadLetters() (deadLetterList []WebhookDelivery, err error) {
+	defer observe("GetWebhookDeadLetters", time.Now(), &err)
+
+	entries, err := vl.client.HGetAll(vl.context, WebhookDeadLetterKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, value := range entries {
+		var delivery WebhookDelivery
+		if err := json.Unmarshal([]byte(value), &delivery); err != nil {
+			return nil, err
+		}
+		deadLetterList = append(deadLetterList, delivery)
+	}
+
+	return deadLetterList, nil
+}
+
+// RequeueWebhookDeadLetter moves a dead-lettered delivery back on to the
+// retry queue with a fresh attempt count, due immediately
+func (vl *Voter) RequeueWebhookDeadLetter(id string) (delivery WebhookDelivery, err error) {
+	defer observe("RequeueWebhookDeadLetter", time.Now(), &err)
+
+	value, err := vl.client.HGet(vl.context, WebhookDeadLetterKey, id).Result()
+	if err != nil {
+		if isRedisNilError(err) {
+			return WebhookDelivery{}, ErrNotFound
+		}
+		return WebhookDelivery{}, err
+	}
+
+	if err := json.Unmarshal([]byte(value), &delivery); err != nil {
+		return WebhookDelivery{}, err
+	}
+
+	delivery.Attempts = 0
+	delivery.NextAttempt = time.Now()
+	if err := vl.ScheduleWebhookRetry(delivery); err != nil {
+		return WebhookDelivery{}, err
+	}
+
+	if err := vl.client.HDel(vl.context, WebhookDeadLetterKey, id).Err(); err != nil {
+		return WebhookDelivery{}, err
+	}
+
+	return delivery, nil
+}
@@ -0,0 +1,76 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// MaxVoterWeight is the largest weight a voter can have, the validate tag
+// of VoterItem repeats it
+const MaxVoterWeight = 1000000000
+
+// VoteWeight is what a vote of the voter counts for in a Weighted poll
+func (v VoterItem) VoteWeight() float64 {
+	if v.Weight <= 0 {
+		return 1
+	}
+	return v.Weight
+}
+
+// weight is what the vote counts for in a Weighted poll, a vote recorded
+// without a weight counts once
+func (v Vote) weight() float64 {
+	if v.Weight <= 0 {
+		return 1
+	}
+	return v.Weight
+}
+
+// voterWeight reads the weight of a voter for a vote about to be recorded,
+// 0 for a voter without one.  A voter that does not exist is left for the
+// history write to report
+func (vl *Voter) voterWeight(voterId int) (float64, error) {
+	//A JSONPath answers [] rather than an error for a voter without one
+	value, err := vl.client.Do(vl.context, "JSON.GET", vl.redisKeyFromId(voterId), "$.weight").Text()
+	if isRedisNilError(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var weights []float64
+	if err := json.Unmarshal([]byte(value), &weights); err != nil || len(weights) == 0 {
+		return 0, err
+	}
+	return weights[0], nil
+}
+
+// SetVoterWeight sets the weight of a voter, 0 to take it away.  Votes
+// recorded before keep the weight they were recorded with
+func (vl *Voter) SetVoterWeight(id int, weight float64) (voterItem VoterItem, err error) {
+	defer observe("SetVoterWeight", time.Now(), &err)
+
+	if weight < 0 || weight > MaxVoterWeight {
+		return VoterItem{}, fmt.Errorf("%w: a weight is between 0 and %d", ErrInvalid, MaxVoterWeight)
+	}
+
+	voterItem, err = vl.GetVoter(id)
+	if err != nil {
+		return VoterItem{}, err
+	}
+	if voterItem.Frozen {
+		return VoterItem{}, ErrFrozen
+	}
+
+	//Like the status only the weight is written, so a vote recorded in
+	//the meantime is not lost
+	if _, err := vl.jsonHelper.JSONSet(vl.redisKeyFromId(id), ".weight", weight); err != nil {
+		return VoterItem{}, err
+	}
+
+	voterItem.Weight = weight
+	vl.emit(EventVoterUpdated, id, 0)
+
+	return voterItem, nil
+}
@@ -0,0 +1,188 @@
+package jobs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is when a job runs, either every so often or at the times a
+// cron expression matches.  The cron times are UTC
+type Schedule struct {
+	spec  string
+	every time.Duration
+
+	//The cron fields, bit n is set when the value n matches
+	minute, hour, dom, month, dow uint64
+	//Like cron, when both the day of month and the day of week are
+	//restricted a day matching either one matches
+	domStar, dowStar bool
+}
+
+// shorthands are the cron macros ParseSchedule accepts
+var shorthands = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// ParseSchedule parses the schedule of a job:
+//
+//	@every 15m        every 15 minutes, the first run right away
+//	30 2 * * *        a five field cron expression, every day at 02:30 UTC
+//	@hourly, @daily   and @weekly, @monthly are cron shorthands
+//
+// An empty spec or off is nil, a job that only runs when triggered
+func ParseSchedule(spec string) (*Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" || spec == "off" {
+		return nil, nil
+	}
+
+	if value, found := strings.CutPrefix(spec, "@every "); found {
+		every, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || every <= 0 {
+			return nil, fmt.Errorf("invalid schedule %q, @every needs a positive duration", spec)
+		}
+		return &Schedule{spec: spec, every: every}, nil
+	}
+
+	expression := spec
+	if macro, found := shorthands[spec]; found {
+		expression = macro
+	}
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q, use @every <duration> or minute hour day month weekday", spec)
+	}
+
+	schedule := &Schedule{spec: spec, domStar: fields[2] == "*", dowStar: fields[4] == "*"}
+	bounds := []struct {
+		field    *uint64
+		min, max int
+	}{
+		{&schedule.minute, 0, 59},
+		{&schedule.hour, 0, 23},
+		{&schedule.dom, 1, 31},
+		{&schedule.month, 1, 12},
+		{&schedule.dow, 0, 7},
+	}
+	for i, bound := range bounds {
+		bits, err := parseField(fields[i], bound.min, bound.max)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %w", spec, err)
+		}
+		*bound.field = bits
+	}
+	//Sunday is both 0 and 7
+	if schedule.dow&(1<<7) != 0 {
+		schedule.dow |= 1
+	}
+	if schedule.Next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("invalid schedule %q, it never matches", spec)
+	}
+	return schedule, nil
+}
+
+// parseField parses one cron field: *, a value, a range a-b, any of them
+// with a /step, or a comma separated list of those
+func parseField(field string, min int, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepPart); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+		}
+
+		low, high := min, max
+		if rangePart != "*" {
+			lowPart, highPart, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if low, err = strconv.Atoi(lowPart); err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			high = low
+			if isRange {
+				if high, err = strconv.Atoi(highPart); err != nil {
+					return 0, fmt.Errorf("invalid value %q", part)
+				}
+			} else if hasStep {
+				high = max
+			}
+		}
+		if low < min || high > max || low > high {
+			return 0, fmt.Errorf("%q is not within %d-%d", part, min, max)
+		}
+
+		for value := low; value <= high; value += step {
+			bits |= 1 << value
+		}
+	}
+	return bits, nil
+}
+
+// String is the schedule as it was written
+func (s *Schedule) String() string {
+	return s.spec
+}
+
+// First is when a job that never ran runs the first time: right away for
+// an @every schedule, at the next matching time for a cron one
+func (s *Schedule) First(now time.Time) time.Time {
+	if s.every > 0 {
+		return now
+	}
+	return s.Next(now)
+}
+
+// Next is the first time the schedule matches after t
+func (s *Schedule) Next(t time.Time) time.Time {
+	if s.every > 0 {
+		return t.Add(s.every)
+	}
+
+	next := t.UTC().Truncate(time.Minute).Add(time.Minute)
+	//Every match is found within a few years, the limit only guards
+	//against an expression like Feb 31 that never matches
+	for limit := next.AddDate(5, 0, 0); next.Before(limit); {
+		if s.month&(1<<uint(next.Month())) == 0 {
+			next = time.Date(next.Year(), next.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !s.dayMatches(next) {
+			next = time.Date(next.Year(), next.Month(), next.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if s.hour&(1<<uint(next.Hour())) == 0 {
+			next = next.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+		if s.minute&(1<<uint(next.Minute())) == 0 {
+			next = next.Add(time.Minute)
+			continue
+		}
+		return next
+	}
+	return time.Time{}
+}
+
+// dayMatches checks the day of month and day of week fields
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	switch {
+	case s.domStar && s.dowStar:
+		return true
+	case s.domStar:
+		return dow
+	case s.dowStar:
+		return dom
+	}
+	return dom || dow
+}
@@ -0,0 +1,361 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/adllev/Voter-Container/voter-api/db"
+)
+
+// The jobs the API runs in the background, see api.NewWithConfig
+const (
+	JobSnapshots = "snapshots"
+	JobCapacity  = "capacity"
+	JobSync      = "sync"
+	JobReminders = "reminders"
+	JobReindex   = "reindex"
+	JobCleanup   = "cleanup"
+	JobRetention = "retention"
+)
+
+// Names lists every job the API runs
+var Names = []string{JobSnapshots, JobCapacity, JobSync, JobReminders, JobReindex, JobCleanup, JobRetention}
+
+// ValidName reports whether name is the name of a job
+func ValidName(name string) bool {
+	for _, known := range Names {
+		if name == known {
+			return true
+		}
+	}
+	return false
+}
+
+// How a run was started
+const (
+	TriggerSchedule = "schedule"
+	TriggerManual   = "manual"
+)
+
+// LockTTL is how long a replica holds the lock of a job it runs.  It is
+// refreshed while the job runs, so it only bounds how long the job stays
+// blocked after a replica died running it
+const LockTTL = time.Minute
+
+// RecentRuns is how many runs of a job its status shows
+const RecentRuns = 10
+
+var (
+	// ErrUnknownJob is returned for a job name that was not added
+	ErrUnknownJob = errors.New("unknown job")
+	// ErrRunning is returned when a job is triggered while some replica is
+	// running it
+	ErrRunning = errors.New("job is already running")
+)
+
+// Store is where the scheduler keeps its locks, schedule and runs, shared
+// by every replica.  The db package implements it
+type Store interface {
+	AcquireJobLock(name string, owner string, ttl time.Duration) (bool, error)
+	RefreshJobLock(name string, owner string, ttl time.Duration) (bool, error)
+	ReleaseJobLock(name string, owner string) error
+	GetJobLock(name string) (string, error)
+	NextJobRun(name string, first time.Time) (time.Time, error)
+	SetNextJobRun(name string, next time.Time) error
+	SaveJobRun(run db.JobRun) error
+	GetJobRuns(name string, limit int) ([]db.JobRun, error)
+}
+
+// Func is the work of a job.  The string it returns sums up what it did
+// for the run history, e.g. voters=120
+type Func func(ctx context.Context) (string, error)
+
+// Job is a named piece of background work.  A job without a Schedule
+// only runs when it is triggered
+type Job struct {
+	Name     string
+	Schedule *Schedule
+	Run      Func
+}
+
+// Status is what GET /admin/jobs reports about a job.  RunningOn is the
+// replica running it right now, Next is nil for a job without a schedule
+type Status struct {
+	Name      string      `json:"name"`
+	Schedule  string      `json:"schedule,omitempty"`
+	Next      *time.Time  `json:"next,omitempty"`
+	RunningOn string      `json:"runningOn,omitempty"`
+	LastRun   *db.JobRun  `json:"lastRun,omitempty"`
+	Runs      []db.JobRun `json:"runs,omitempty"`
+}
+
+// Scheduler runs the background jobs on a schedule shared by every
+// replica.  Each replica checks which jobs are due, the one that gets the
+// lock of a job runs it and moves its next run along, so a job runs once
+// per scheduled time no matter how many replicas there are
+type Scheduler struct {
+	store Store
+	owner string
+	jobs  map[string]*Job
+
+	//ctx is the context of Run, runs triggered by a request outlive it
+	ctx context.Context
+	mu  sync.Mutex
+}
+
+// NewScheduler is a constructor function that returns a pointer to a new
+// Scheduler.  The replica is told apart from its peers by host name and
+// process id in the locks and the run history
+func NewScheduler(store Store) *Scheduler {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return &Scheduler{
+		store: store,
+		owner: fmt.Sprintf("%s-%d", host, os.Getpid()),
+		jobs:  make(map[string]*Job),
+		ctx:   context.Background(),
+	}
+}
+
+// Add registers a job, schedule nil to only run it when triggered
+func (s *Scheduler) Add(name string, schedule *Schedule, run Func) {
+	s.jobs[name] = &Job{Name: name, Schedule: schedule, Run: run}
+}
+
+// Jobs returns the names of the registered jobs, sorted
+func (s *Scheduler) Jobs() []string {
+	names := make([]string, 0, len(s.jobs))
+	for name := range s.jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Run checks for due jobs every interval until the context is cancelled.
+// It is meant to be started in its own go routine
+func (s *Scheduler) Run(ctx context.Context, interval time.Duration) {
+	s.mu.Lock()
+	s.ctx = ctx
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.RunDue(ctx, time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunDue starts every job whose next run is at or before now, each in its
+// own go routine so a long reindex does not hold up the others.  It does
+// not wait for them
+func (s *Scheduler) RunDue(ctx context.Context, now time.Time) {
+	for _, name := range s.Jobs() {
+		job := s.jobs[name]
+		if job.Schedule == nil {
+			continue
+		}
+		due, _, err := s.due(job, now)
+		if err != nil {
+			slog.Error("Error reading job schedule", "job", name, "error", err)
+			continue
+		}
+		if !due {
+			continue
+		}
+
+		acquired, err := s.store.AcquireJobLock(name, s.owner, LockTTL)
+		if err != nil {
+			slog.Error("Error locking job", "job", name, "error", err)
+			continue
+		}
+		if !acquired {
+			continue
+		}
+
+		//A peer may have run the job and released the lock between the
+		//check and the lock, it is not due anymore then
+		due, next, err := s.due(job, now)
+		if err != nil || !due {
+			_ = s.store.ReleaseJobLock(name, s.owner)
+			continue
+		}
+
+		//Move the schedule along before running, a peer checking in the
+		//meantime must not find the job due again
+		following := job.Schedule.Next(next)
+		if following.Before(now) {
+			following = job.Schedule.Next(now)
+		}
+		if err := s.store.SetNextJobRun(name, following); err != nil {
+			slog.Error("Error scheduling job", "job", name, "error", err)
+			_ = s.store.ReleaseJobLock(name, s.owner)
+			continue
+		}
+		go s.run(ctx, job, TriggerSchedule)
+	}
+}
+
+// due reports whether a job is due at now and when it was due
+func (s *Scheduler) due(job *Job, now time.Time) (bool, time.Time, error) {
+	next, err := s.store.NextJobRun(job.Name, job.Schedule.First(now))
+	if err != nil {
+		return false, next, err
+	}
+	//A schedule changed to run sooner than the stored next run takes
+	//effect right away
+	if sooner := job.Schedule.Next(now); !sooner.IsZero() && sooner.Before(next) {
+		next = sooner
+	}
+	return !next.IsZero() && !next.After(now), next, nil
+}
+
+// Trigger runs a job right away, outside of its schedule.  The run goes
+// on in the background, the returned run is how it started.  It is
+// ErrRunning when a replica is running the job already
+func (s *Scheduler) Trigger(name string) (db.JobRun, error) {
+	job, found := s.jobs[name]
+	if !found {
+		return db.JobRun{}, ErrUnknownJob
+	}
+
+	acquired, err := s.store.AcquireJobLock(name, s.owner, LockTTL)
+	if err != nil {
+		return db.JobRun{}, err
+	}
+	if !acquired {
+		return db.JobRun{}, ErrRunning
+	}
+
+	s.mu.Lock()
+	ctx := s.ctx
+	s.mu.Unlock()
+
+	run := db.JobRun{Job: name, Trigger: TriggerManual, Owner: s.owner, Started: time.Now().UTC()}
+	if err := s.store.SaveJobRun(run); err != nil {
+		_ = s.store.ReleaseJobLock(name, s.owner)
+		return db.JobRun{}, err
+	}
+	go s.finish(ctx, job, run)
+	return run, nil
+}
+
+// Status reports on one job with its latest runs
+func (s *Scheduler) Status(name string) (Status, error) {
+	job, found := s.jobs[name]
+	if !found {
+		return Status{}, ErrUnknownJob
+	}
+
+	status := Status{Name: name}
+	if job.Schedule != nil {
+		status.Schedule = job.Schedule.String()
+		next, err := s.store.NextJobRun(name, job.Schedule.First(time.Now()))
+		if err != nil {
+			return Status{}, err
+		}
+		status.Next = &next
+	}
+
+	owner, err := s.store.GetJobLock(name)
+	if err != nil {
+		return Status{}, err
+	}
+	status.RunningOn = owner
+
+	status.Runs, err = s.store.GetJobRuns(name, RecentRuns)
+	if err != nil {
+		return Status{}, err
+	}
+	if len(status.Runs) > 0 {
+		status.LastRun = &status.Runs[0]
+	}
+	return status, nil
+}
+
+// run is a scheduled run of a job, the caller holds its lock
+func (s *Scheduler) run(ctx context.Context, job *Job, trigger string) {
+	run := db.JobRun{Job: job.Name, Trigger: trigger, Owner: s.owner, Started: time.Now().UTC()}
+	if err := s.store.SaveJobRun(run); err != nil {
+		slog.Error("Error saving job run", "job", job.Name, "error", err)
+	}
+	s.finish(ctx, job, run)
+}
+
+// finish runs the job and records how it went, keeping its lock fresh
+// while it runs and releasing it after
+func (s *Scheduler) finish(ctx context.Context, job *Job, run db.JobRun) {
+	defer func() {
+		if err := s.store.ReleaseJobLock(job.Name, s.owner); err != nil {
+			slog.Error("Error unlocking job", "job", job.Name, "error", err)
+		}
+	}()
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go s.keepLock(runCtx, job.Name)
+
+	result, err := s.call(runCtx, job)
+	finished := time.Now().UTC()
+	run.Finished = &finished
+	run.Result = result
+	run.Status = db.JobRunSucceeded
+	if err != nil {
+		run.Status = db.JobRunFailed
+		run.Error = err.Error()
+		slog.Error("Job failed", "job", job.Name, "trigger", run.Trigger, "error", err)
+	} else {
+		slog.Info("Job finished", "job", job.Name, "trigger", run.Trigger, "result", result,
+			"duration", finished.Sub(run.Started).String())
+	}
+	if err := s.store.SaveJobRun(run); err != nil {
+		slog.Error("Error saving job run", "job", job.Name, "error", err)
+	}
+}
+
+// call runs the job, a job that panics fails instead of taking the
+// replica down with it
+func (s *Scheduler) call(ctx context.Context, job *Job) (result string, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("panic: %v", recovered)
+		}
+	}()
+	return job.Run(ctx)
+}
+
+// keepLock refreshes the lock of a running job until ctx is done
+func (s *Scheduler) keepLock(ctx context.Context, name string) {
+	ticker := time.NewTicker(LockTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		held, err := s.store.RefreshJobLock(name, s.owner, LockTTL)
+		if err != nil {
+			slog.Error("Error refreshing job lock", "job", name, "error", err)
+		} else if !held {
+			slog.Warn("Lost the lock of a running job", "job", name)
+			return
+		}
+	}
+}
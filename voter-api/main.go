@@ -1,96 +1,156 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 
 	"github.com/adllev/Voter-Container/voter-api/api"
+	"github.com/adllev/Voter-Container/voter-api/config"
+	"github.com/adllev/Voter-Container/voter-api/db"
+	"github.com/adllev/Voter-Container/voter-api/logging"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
-	"github.com/gofiber/fiber/v2/middleware/recover"
 )
 
-// Global variables to hold the command line flags to drive the todo CLI
-// application
-var (
-	hostFlag string
-	portFlag uint
-)
-
-// processCmdLineFlags parses the command line flags for our CLI
-//
-// TODO: This function uses the flag package to parse the command line
-//		 flags.  The flag package is not very flexible and can lead to
-//		 some confusing code.
-
-//			 REQUIRED:     Study the code below, and make sure you understand
-//						   how it works.  Go online and readup on how the
-//						   flag package works.  Then, write a nice comment
-//				  		   block to document this function that highights that
-//						   you understand how it works.
-//
-//			 EXTRA CREDIT: The best CLI and command line processor for
-//						   go is called Cobra.  Refactor this function to
-//						   use it.  See github.com/spf13/cobra for information
-//						   on how to use it.
-//
-//	 YOUR ANSWER: <GOES HERE>
-func processCmdLineFlags() {
-
-	//Note some networking lingo, some frameworks start the server on localhost
-	//this is a local-only interface and is fine for testing but its not accessible
-	//from other machines.  To make the server accessible from other machines, we
-	//need to listen on an interface, that could be an IP address, but modern
-	//cloud servers may have multiple network interfaces for scale.  With TCP/IP
-	//the address 0.0.0.0 instructs the network stack to listen on all interfaces
-	//We set this up as a flag so that we can overwrite it on the command line if
-	//needed
-	flag.StringVar(&hostFlag, "h", "0.0.0.0", "Listen on all interfaces")
-	flag.UintVar(&portFlag, "p", 1080, "Default Port")
-
-	flag.Parse()
-}
-
 // main is the entry point for our todo API application.  It processes
 // the command line flags and then uses the db package to perform the
 // requested operation
 func main() {
-	processCmdLineFlags()
+	//Settings come from the config file, the environment and the command
+	//line, see config.Load for every one of them
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 
-	app := fiber.New()
-	app.Use(cors.New())
-	app.Use(recover.New())
+	logger, err := logging.New(os.Stdout, cfg.LogFormat, cfg.LogLevel)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
+	logger.Debug("Loaded configuration", "config", cfg)
+
+	app := fiber.New(fiber.Config{
+		ErrorHandler:   api.ErrorHandler,
+		Prefork:        cfg.Prefork,
+		Concurrency:    cfg.Concurrency,
+		ReadBufferSize: cfg.ReadBufferSize,
+		BodyLimit:      cfg.BodyLimit,
+	})
+	app.Use(api.RequestID)
+	app.Use(api.Recover(api.LogReporter{Logger: logger}))
+	app.Use(api.Timeout(cfg.RequestTimeout))
+	app.Use(api.Compress(cfg.CompressMinBytes))
+	app.Use(cors.New(corsConfig(cfg.CORS)))
 
-	apiHandler, err := api.New()
+	apiHandler, err := api.NewWithConfig(cfg, logger)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
+	app.Use(apiHandler.RequestLogger)
+	app.Use(apiHandler.Tenant)
+
+	if err := apiHandler.WaitForRedis(context.Background(), cfg.RedisWait); err != nil {
+		if cfg.FailFast {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		logger.Warn("Starting degraded", "error", err)
+	}
+
+	if err := apiHandler.CheckSchemaVersion(); err != nil {
+		switch {
+		case !errors.Is(err, db.ErrSchemaTooNew):
+			logger.Error("Could not check schema version", "error", err)
+		case cfg.SchemaGuard == config.SchemaGuardReadOnly:
+			logger.Warn("Starting read-only", "error", err)
+			apiHandler.SetReadOnly(true, "stored data is newer than this release")
+		default:
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	app.Use(apiHandler.RateLimiter)
+	app.Use(apiHandler.Authenticate)
+	app.Use(apiHandler.Authorize)
+	app.Use(apiHandler.ReadOnlyGuard)
+	app.Use(apiHandler.ReplayCapture)
+
+	if err := apiHandler.EnsureIndexes(); err != nil {
+		logger.Error("Could not build indexes", "error", err)
+	}
+
+	//Every route is served under /api/v1.  The original unversioned paths
+	//are still mounted while legacy-routes is on, so current clients keep
+	//working while they move over.  They answer with Deprecation and
+	//Sunset headers and every use is logged, see api.Deprecated
+	registerRoutes(api.Instrument(app.Group(api.APIPrefix)), apiHandler)
+	if cfg.LegacyRoutes {
+		logger.Warn("Legacy unversioned routes are enabled, they are deprecated in favor of " + api.APIPrefix)
+		registerRoutes(api.Deprecate(api.Instrument(app), api.Deprecation{
+			Sunset:    cfg.LegacySunset,
+			Successor: api.APIPrefix,
+		}), apiHandler)
+	}
+
+	//Not versioned, scrapers are configured with a plain /metrics
+	app.Get("/metrics", api.MetricsHandlers()...)
+
+	//With prefork every child process runs main too, only the parent runs
+	//the background workers so they are not started once per CPU
+	if !fiber.IsChild() {
+		apiHandler.StartBackground(context.Background())
+	}
+
+	if err := listen(app, cfg); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// listen serves the app on the configured address, over TLS when a
+// certificate is configured
+func listen(app *fiber.App, cfg config.Config) error {
+	serverPath := cfg.Addr()
+	if cfg.TLS.CertFile == "" {
+		slog.Info("Starting server", "addr", serverPath)
+		return app.Listen(serverPath)
+	}
+
+	//fiber can only prefork listeners it creates itself, so in prefork
+	//mode the certificate is loaded once by each process
+	if cfg.Prefork {
+		if cfg.TLS.Reload > 0 {
+			slog.Warn("Certificate reload is not supported with prefork, restart to pick up a new certificate")
+		}
+		slog.Info("Starting server with TLS", "addr", serverPath, "cert", cfg.TLS.CertFile, "prefork", true)
+		return app.ListenTLS(serverPath, cfg.TLS.CertFile, cfg.TLS.KeyFile)
+	}
+
+	reloader, err := newCertReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+	if err != nil {
+		return err
+	}
+	if cfg.TLS.Reload > 0 {
+		go reloader.watch(context.Background(), cfg.TLS.Reload)
+	}
+
+	listener, err := tls.Listen("tcp", serverPath, reloader.tlsConfig())
+	if err != nil {
+		return err
+	}
 
-	//HTTP Standards for "REST" APIS
-	//GET - Read/Query
-	//POST - Create
-	//PUT - Update
-	//DELETE - Delete
-
-	app.Get("/voters", apiHandler.ListAllVoters)
-	app.Get("/voters/:id<int>", apiHandler.GetVoter)
-	app.Post("/voters", apiHandler.PostVoter)
-	app.Get("/voters/:id<int>/polls", apiHandler.GetVoterPolls)
-	app.Get("/voters/:id<int>/polls/:pollid<int>", apiHandler.GetVoterPoll)
-	app.Post("/voters/:id<int>/polls/:pollid<int>", apiHandler.PostVoterPoll)
-
-	app.Put("/voters/:id<int>", apiHandler.UpdateVoter)
-	app.Delete("/voters", apiHandler.DeleteAllVoters)
-	app.Delete("/voters/:id<int>", apiHandler.DeleteVoter)
-	app.Put("/voters/:id<int>/polls/:pollid<int>", apiHandler.UpdateVoterPoll)
-	app.Delete("/voters/:id<int>/polls/:pollid<int>", apiHandler.DeleteVoterPoll)
-
-	app.Get("voters/health", apiHandler.HealthCheck)
-
-	serverPath := fmt.Sprintf("%s:%d", hostFlag, portFlag)
-	log.Println("Starting server on ", serverPath)
-	app.Listen(serverPath)
+	slog.Info("Starting server with TLS", "addr", serverPath, "cert", cfg.TLS.CertFile)
+	return app.Listener(listener)
 }
@@ -0,0 +1,214 @@
+package notifications
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/adllev/Voter-Container/voter-api/webhooks"
+)
+
+// The channels a notification can be routed to, see Router
+const (
+	ChannelEmail   = "email"
+	ChannelWebhook = "webhook"
+	ChannelSMS     = "sms"
+	ChannelSlack   = "slack"
+)
+
+// Channels lists every channel name
+var Channels = []string{ChannelEmail, ChannelWebhook, ChannelSMS, ChannelSlack}
+
+// ValidChannel reports whether channel is the name of a channel
+func ValidChannel(channel string) bool {
+	for _, known := range Channels {
+		if channel == known {
+			return true
+		}
+	}
+	return false
+}
+
+// SendTimeout bounds how long a channel may take to accept a message
+const SendTimeout = 10 * time.Second
+
+// ErrNoAddress is returned by a channel that has no address for the
+// recipient of a message, an SMS to a voter without a phone number.  The
+// Router skips the channel rather than failing the message
+var ErrNoAddress = errors.New("recipient has no address for the channel")
+
+// ChannelsConfig is how to reach the channels messages go out on, see
+// NewChannels.  The yaml names are the ones used in the config file, see
+// the config package.  Passwords, tokens and the Slack webhook url, which
+// is its own credential, are only read from the environment
+type ChannelsConfig struct {
+	SMTP            SMTPConfig    `yaml:"smtp"`
+	Twilio          TwilioConfig  `yaml:"twilio"`
+	Webhook         WebhookConfig `yaml:"webhook"`
+	SlackWebhookURL string        `yaml:"-"`
+}
+
+// NewChannels returns every channel configured in cfg by name.  Email is
+// always there, through SMTP when its host is set and to the log
+// otherwise.  The others are only there when they are configured
+func NewChannels(cfg ChannelsConfig) (map[string]Notifier, error) {
+	channels := map[string]Notifier{ChannelEmail: LogNotifier{}}
+	smtpNotifier, err := cfg.SMTP.notifier()
+	if err != nil {
+		return nil, err
+	}
+	if smtpNotifier != nil {
+		channels[ChannelEmail] = smtpNotifier
+	}
+	if cfg.Webhook.URL != "" {
+		channels[ChannelWebhook] = NewWebhookNotifier(cfg.Webhook.URL, cfg.Webhook.Secret)
+	}
+	twilio, err := cfg.Twilio.notifier()
+	if err != nil {
+		return nil, err
+	}
+	if twilio != nil {
+		channels[ChannelSMS] = twilio
+	}
+	if cfg.SlackWebhookURL != "" {
+		channels[ChannelSlack] = NewSlackNotifier(cfg.SlackWebhookURL)
+	}
+	return channels, nil
+}
+
+// Router is a Notifier that hands every message to the channels its event
+// is routed to, and the ones without a route to the default channels.
+// When one channel fails the message fails, the dispatcher then retries it
+// on every channel of the route
+type Router struct {
+	channels map[string]Notifier
+	routes   map[string][]string
+	defaults []string
+}
+
+// NewRouter is a constructor function that returns a pointer to a new
+// Router.  channels are the configured channels by name, routes the
+// channels per event.  An event routed to a channel that is not
+// configured is an error, so a typo does not silently drop messages
+func NewRouter(channels map[string]Notifier, routes map[string][]string, defaults []string) (*Router, error) {
+	check := func(event string, names []string) error {
+		for _, name := range names {
+			if _, ok := channels[name]; !ok {
+				return fmt.Errorf("event %q is routed to channel %q, which is not configured", event, name)
+			}
+		}
+		return nil
+	}
+	if err := check("*", defaults); err != nil {
+		return nil, err
+	}
+	for event, names := range routes {
+		if err := check(event, names); err != nil {
+			return nil, err
+		}
+	}
+	return &Router{channels: channels, routes: routes, defaults: defaults}, nil
+}
+
+// Send delivers the message on every channel of its event
+func (r *Router) Send(msg Message) error {
+	names, routed := r.routes[msg.Event]
+	if !routed {
+		names = r.defaults
+	}
+
+	var errs []error
+	for _, name := range names {
+		err := r.channels[name].Send(msg)
+		if err != nil && !errors.Is(err, ErrNoAddress) {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// postJSON POSTs body as JSON to url, any status but 2xx is an error
+func postJSON(client *http.Client, url string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s answered %d", req.URL.Host, resp.StatusCode)
+	}
+	return nil
+}
+
+// WebhookConfig is where the webhook channel POSTs messages, the secret
+// they are signed with is only read from the environment
+type WebhookConfig struct {
+	URL    string `yaml:"url"`
+	Secret string `yaml:"-"`
+}
+
+// WebhookNotifier is a Notifier that POSTs every message as JSON to a
+// URL.  With a secret the body is signed like the deliveries of the
+// webhooks package, see webhooks.Sign
+type WebhookNotifier struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+// NewWebhookNotifier is a constructor function that returns a pointer to
+// a new WebhookNotifier
+func NewWebhookNotifier(url string, secret string) *WebhookNotifier {
+	return &WebhookNotifier{url: url, secret: secret, client: &http.Client{Timeout: SendTimeout}}
+}
+
+func (n *WebhookNotifier) Send(msg Message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	headers := map[string]string{webhooks.EventHeader: msg.Event}
+	if n.secret != "" {
+		timestamp := time.Now().Unix()
+		headers[webhooks.TimestampHeader] = strconv.FormatInt(timestamp, 10)
+		headers[webhooks.SignatureHeader] = webhooks.Sign(n.secret, timestamp, body)
+	}
+	return postJSON(n.client, n.url, body, headers)
+}
+
+// SlackNotifier is a Notifier that posts every message to a Slack channel
+// through an incoming webhook.  It is meant for the people running the
+// election, not for voters
+type SlackNotifier struct {
+	url    string
+	client *http.Client
+}
+
+// NewSlackNotifier is a constructor function that returns a pointer to a
+// new SlackNotifier posting to the incoming webhook url
+func NewSlackNotifier(url string) *SlackNotifier {
+	return &SlackNotifier{url: url, client: &http.Client{Timeout: SendTimeout}}
+}
+
+func (n *SlackNotifier) Send(msg Message) error {
+	body, err := json.Marshal(map[string]string{
+		"text": fmt.Sprintf("*%s* (%s)\n%s", msg.Subject, msg.Event, msg.Body),
+	})
+	if err != nil {
+		return err
+	}
+	return postJSON(n.client, n.url, body, nil)
+}
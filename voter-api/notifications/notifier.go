@@ -0,0 +1,194 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"time"
+
+	"github.com/adllev/Voter-Container/voter-api/db"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+const (
+	// DefaultMaxAttempts is how many times a notification is retried before
+	// it is moved to the dead-letter list
+	DefaultMaxAttempts = 5
+	// DefaultRetryBaseDelay is the delay before the first retry, every
+	// retry after that doubles it
+	DefaultRetryBaseDelay = 30 * time.Second
+)
+
+// ErrSuppressed is returned by Dispatcher.Send when the recipient is on
+// the suppression list and the message was dropped on purpose
+var ErrSuppressed = errors.New("recipient is suppressed")
+
+// Message is a single notification to deliver to a voter.  To is the
+// email address, Phone the number for the SMS channel if the voter has one
+type Message struct {
+	Event   string `json:"event"`
+	To      string `json:"to"`
+	Phone   string `json:"phone,omitempty"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// Notifier is implemented by anything that can actually deliver a
+// Message, for example an email or SMS provider
+type Notifier interface {
+	Send(msg Message) error
+}
+
+// SuppressionChecker is used by the Dispatcher to find out if an address
+// bounced or unsubscribed.  The db package implements it
+type SuppressionChecker interface {
+	IsSuppressed(email string) (bool, error)
+}
+
+// RetryStore is where failed notifications wait for their next attempt.
+// The db package implements it on top of a redis sorted set
+type RetryStore interface {
+	ScheduleNotificationRetry(item db.NotificationRetry) error
+	PopDueNotificationRetries(now time.Time) ([]db.NotificationRetry, error)
+	AddNotificationDeadLetter(item db.NotificationRetry) error
+}
+
+// Dispatcher is the single place every notification goes through, so we
+// can guarantee the suppression list is consulted before any send, and
+// that failed deliveries end up on the retry queue
+type Dispatcher struct {
+	notifier     Notifier
+	suppressions SuppressionChecker
+	retries      RetryStore
+	maxAttempts  int
+	baseDelay    time.Duration
+}
+
+// NewDispatcher is a constructor function that returns a pointer to a new
+// Dispatcher that delivers messages with the provided notifier
+func NewDispatcher(notifier Notifier, suppressions SuppressionChecker, retries RetryStore) *Dispatcher {
+	return &Dispatcher{
+		notifier:     notifier,
+		suppressions: suppressions,
+		retries:      retries,
+		maxAttempts:  DefaultMaxAttempts,
+		baseDelay:    DefaultRetryBaseDelay,
+	}
+}
+
+// Send checks the suppression list and then hands the message to the
+// notifier.  Suppressed recipients are skipped and ErrSuppressed is returned.
+// If delivery fails the message is queued for a retry and the original
+// delivery error is returned
+func (d *Dispatcher) Send(msg Message) error {
+	suppressed, err := d.suppressions.IsSuppressed(msg.To)
+	if err != nil {
+		return err
+	}
+	if suppressed {
+		slog.Info("Skipping notification to suppressed recipient", "event", msg.Event)
+		return ErrSuppressed
+	}
+
+	sendErr := d.notifier.Send(msg)
+	if sendErr == nil {
+		return nil
+	}
+
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	item := db.NotificationRetry{
+		Id:      utils.UUIDv4(),
+		Payload: payload,
+	}
+	if err := d.scheduleRetry(item, sendErr); err != nil {
+		slog.Error("Error queueing notification retry", "error", err)
+	}
+
+	return sendErr
+}
+
+// backoff returns how long to wait before the given attempt, doubling
+// the base delay every time (30s, 1m, 2m, 4m, ...)
+func (d *Dispatcher) backoff(attempt int) time.Duration {
+	return d.baseDelay * time.Duration(math.Pow(2, float64(attempt-1)))
+}
+
+// scheduleRetry records a failed attempt and either puts the item back on
+// the retry queue or, once it is out of attempts, on the dead-letter list
+func (d *Dispatcher) scheduleRetry(item db.NotificationRetry, sendErr error) error {
+	item.Attempts++
+	item.LastError = sendErr.Error()
+
+	if item.Attempts >= d.maxAttempts {
+		slog.Warn("Notification failed too many times, dead-lettering", "id", item.Id, "attempts", item.Attempts)
+		return d.retries.AddNotificationDeadLetter(item)
+	}
+
+	item.NextAttempt = time.Now().Add(d.backoff(item.Attempts))
+	return d.retries.ScheduleNotificationRetry(item)
+}
+
+// ProcessRetries makes one pass over the retry queue, redelivering every
+// notification that is due.  Suppression is checked again, an address may
+// have bounced since the message was first queued
+func (d *Dispatcher) ProcessRetries() error {
+	dueList, err := d.retries.PopDueNotificationRetries(time.Now())
+	if err != nil {
+		return err
+	}
+
+	for _, item := range dueList {
+		var msg Message
+		if err := json.Unmarshal(item.Payload, &msg); err != nil {
+			slog.Error("Dropping unreadable notification retry", "id", item.Id, "error", err)
+			continue
+		}
+
+		suppressed, err := d.suppressions.IsSuppressed(msg.To)
+		if err == nil && suppressed {
+			slog.Info("Dropping retry to suppressed recipient", "id", item.Id)
+			continue
+		}
+
+		if sendErr := d.notifier.Send(msg); sendErr != nil {
+			if err := d.scheduleRetry(item, sendErr); err != nil {
+				slog.Error("Error queueing notification retry", "error", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// RunRetries processes the retry queue every interval until the context
+// is cancelled.  It is meant to be started in its own go routine
+func (d *Dispatcher) RunRetries(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := d.ProcessRetries(); err != nil {
+				slog.Error("Error processing notification retries", "error", err)
+			}
+		}
+	}
+}
+
+// LogNotifier is a Notifier that just writes the message to the log.  It
+// is the default until a real delivery channel is configured
+type LogNotifier struct{}
+
+func (LogNotifier) Send(msg Message) error {
+	slog.Info("Notification", "event", msg.Event, "to", msg.To, "subject", msg.Subject)
+	return nil
+}
@@ -0,0 +1,103 @@
+package notifications
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultSMTPPort is the submission port, where servers expect STARTTLS
+const DefaultSMTPPort = 587
+
+// SMTPNotifier is a Notifier that emails messages through an SMTP server.
+// The connection is upgraded with STARTTLS when the server offers it
+type SMTPNotifier struct {
+	addr string
+	from mail.Address
+	auth smtp.Auth
+}
+
+// SMTPConfig is the server emails go out through, none without a host.
+// The port defaults to DefaultSMTPPort, without a username there is no
+// PLAIN auth.  From is the sender, e.g. Elections <no-reply@example.com>.
+// The password is only read from the environment
+type SMTPConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"-"`
+	From     string `yaml:"from"`
+}
+
+// notifier returns the SMTPNotifier of cfg, nil when the host is not set
+func (cfg SMTPConfig) notifier() (*SMTPNotifier, error) {
+	if cfg.Host == "" {
+		return nil, nil
+	}
+
+	port := cfg.Port
+	if port == 0 {
+		port = DefaultSMTPPort
+	}
+	if port < 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid SMTP port %d", cfg.Port)
+	}
+	from, err := mail.ParseAddress(cfg.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP from: %w", err)
+	}
+
+	return NewSMTPNotifier(net.JoinHostPort(cfg.Host, strconv.Itoa(port)), *from, cfg.Username, cfg.Password), nil
+}
+
+// NewSMTPNotifier is a constructor function that returns a pointer to a
+// new SMTPNotifier sending through the server at addr, host:port, as from.
+// Without a username it does not authenticate
+func NewSMTPNotifier(addr string, from mail.Address, username string, password string) *SMTPNotifier {
+	notifier := &SMTPNotifier{addr: addr, from: from}
+	if username != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		notifier.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return notifier
+}
+
+// Send emails the message to msg.To
+func (n *SMTPNotifier) Send(msg Message) error {
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return fmt.Errorf("invalid recipient: %w", err)
+	}
+	body, err := n.compose(*to, msg)
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(n.addr, n.auth, n.from.Address, []string{to.Address}, body)
+}
+
+// compose builds the email, a plain text UTF-8 message
+func (n *SMTPNotifier) compose(to mail.Address, msg Message) ([]byte, error) {
+	//A subject is one header line, a line break in it would start headers
+	//of its own
+	if strings.ContainsAny(msg.Subject, "\r\n") {
+		return nil, errors.New("the subject must be a single line")
+	}
+
+	var out strings.Builder
+	out.WriteString("From: " + n.from.String() + "\r\n")
+	out.WriteString("To: " + to.String() + "\r\n")
+	out.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", msg.Subject) + "\r\n")
+	out.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	out.WriteString("MIME-Version: 1.0\r\n")
+	out.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	out.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	out.WriteString("\r\n")
+	out.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return []byte(out.String()), nil
+}
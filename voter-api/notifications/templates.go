@@ -0,0 +1,89 @@
+package notifications
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Events of the emails sent to voters, each has a template below
+const (
+	EventVerification = "voter.verification"
+	EventVerified     = "voter.verified"
+	EventReceipt      = "vote.receipt"
+	EventReminder     = "poll.reminder"
+)
+
+// TemplateData is what the templates can refer to, each event uses the
+// fields that make sense for it
+type TemplateData struct {
+	Name      string
+	Link      string
+	ValidFor  time.Duration
+	PollId    int
+	PollTitle string
+	ClosesAt  time.Time
+	Receipt   string
+}
+
+// templates are the emails per event.  Every one defines a subject and a
+// body, plain text since not every voter reads HTML mail
+var templates = template.Must(template.New("").Funcs(template.FuncMap{
+	"date": func(t time.Time) string { return t.UTC().Format(time.RFC1123) },
+}).Parse(`
+{{define "voter.verification.subject"}}Confirm your email address{{end}}
+{{define "voter.verification.body"}}Hello {{.Name}},
+
+follow this link within {{.ValidFor}} to confirm your registration:
+
+{{.Link}}
+{{end}}
+
+{{define "voter.verified.subject"}}Your registration is confirmed{{end}}
+{{define "voter.verified.body"}}Hello {{.Name}},
+
+your email address is confirmed, you can now vote.
+{{end}}
+
+{{define "vote.receipt.subject"}}Your vote in {{.PollTitle}} was recorded{{end}}
+{{define "vote.receipt.body"}}Hello {{.Name}},
+
+your vote in {{.PollTitle}} was recorded.  Keep this receipt, it lets you
+check later that your vote is still counted:
+
+{{.Receipt}}
+{{end}}
+
+{{define "poll.reminder.subject"}}Reminder: {{.PollTitle}} closes soon{{end}}
+{{define "poll.reminder.body"}}Hello {{.Name}},
+
+you have not voted in {{.PollTitle}} yet.  Voting closes at {{date .ClosesAt}}.
+{{end}}
+`))
+
+// Render is the message for event to the address to, filled in from data
+func Render(event string, to string, data TemplateData) (Message, error) {
+	subject, err := execute(event+".subject", data)
+	if err != nil {
+		return Message{}, err
+	}
+	body, err := execute(event+".body", data)
+	if err != nil {
+		return Message{}, err
+	}
+	return Message{Event: event, To: to, Subject: subject, Body: body}, nil
+}
+
+// execute runs one of the templates
+func execute(name string, data TemplateData) (string, error) {
+	tmpl := templates.Lookup(name)
+	if tmpl == nil {
+		return "", fmt.Errorf("no email template %q", name)
+	}
+	var out strings.Builder
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
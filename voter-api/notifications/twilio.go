@@ -0,0 +1,80 @@
+package notifications
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// TwilioAPI is the base URL of the Twilio REST API
+const TwilioAPI = "https://api.twilio.com/2010-04-01"
+
+// TwilioNotifier is a Notifier that texts every message to the phone
+// number of the voter through Twilio.  A message without a
+// phone number is ErrNoAddress
+type TwilioNotifier struct {
+	baseURL    string
+	accountSid string
+	authToken  string
+	from       string
+	client     *http.Client
+}
+
+// NewTwilioNotifier is a constructor function that returns a pointer to a
+// new TwilioNotifier sending from the number from.  baseURL is TwilioAPI
+// unless the API is stubbed
+func NewTwilioNotifier(baseURL string, accountSid string, authToken string, from string) *TwilioNotifier {
+	return &TwilioNotifier{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		accountSid: accountSid,
+		authToken:  authToken,
+		from:       from,
+		client:     &http.Client{Timeout: SendTimeout},
+	}
+}
+
+// TwilioConfig is the Twilio account texts are sent from, none without
+// the account.  The auth token is only read from the environment
+type TwilioConfig struct {
+	AccountSid string `yaml:"accountSid"`
+	AuthToken  string `yaml:"-"`
+	From       string `yaml:"from"`
+}
+
+// notifier returns the TwilioNotifier of cfg, nil without the account
+func (cfg TwilioConfig) notifier() (*TwilioNotifier, error) {
+	if cfg.AccountSid == "" {
+		return nil, nil
+	}
+	if cfg.AuthToken == "" || cfg.From == "" {
+		return nil, errors.New("the Twilio auth token and from number are required with the account")
+	}
+	return NewTwilioNotifier(TwilioAPI, cfg.AccountSid, cfg.AuthToken, cfg.From), nil
+}
+
+func (n *TwilioNotifier) Send(msg Message) error {
+	if msg.Phone == "" {
+		return ErrNoAddress
+	}
+
+	form := url.Values{"From": {n.from}, "To": {msg.Phone}, "Body": {msg.Subject + "\n\n" + msg.Body}}
+	req, err := http.NewRequest(http.MethodPost, n.baseURL+"/Accounts/"+url.PathEscape(n.accountSid)+"/Messages.json",
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(n.accountSid, n.authToken)
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("twilio answered %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package reminders
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/adllev/Voter-Container/voter-api/db"
+	"github.com/adllev/Voter-Container/voter-api/notifications"
+)
+
+// Store is where the scheduler claims the voters to remind.  The db
+// package implements it
+type Store interface {
+	ClaimReminders(now time.Time, lead time.Duration) ([]db.Reminder, error)
+}
+
+// Sender delivers the reminder emails, notifications.Dispatcher implements
+// it so the suppression list and the retries apply
+type Sender interface {
+	Send(msg notifications.Message) error
+}
+
+// Scheduler reminds the voters that have not voted yet in an open poll
+// lead before it closes.  Claiming a voter publishes a poll.reminder event
+// for the webhooks, see db.ClaimReminders, the scheduler emails them
+type Scheduler struct {
+	store  Store
+	sender Sender
+	lead   time.Duration
+}
+
+// NewScheduler is a constructor function that returns a pointer to a new
+// Scheduler, nil when lead is 0 and nobody is to be reminded
+func NewScheduler(store Store, sender Sender, lead time.Duration) *Scheduler {
+	if lead <= 0 {
+		return nil
+	}
+	return &Scheduler{
+		store:  store,
+		sender: sender,
+		lead:   lead,
+	}
+}
+
+// Remind claims the voters that are due a reminder at now and emails
+// them, returning how many emails went out.  A voter is claimed before the
+// email goes out, one whose email failed is retried by the dispatcher but
+// never claimed again, a suppressed one is not emailed at all
+func (s *Scheduler) Remind(now time.Time) (int, error) {
+	reminders, err := s.store.ClaimReminders(now, s.lead)
+	sent := 0
+	for _, reminder := range reminders {
+		msg, err := message(reminder)
+		if err == nil {
+			err = s.sender.Send(msg)
+		}
+		if errors.Is(err, notifications.ErrSuppressed) {
+			continue
+		}
+		if err != nil {
+			slog.Error("Error sending reminder", "tenant", reminder.Tenant, "voterId", reminder.VoterId, "pollId", reminder.PollId, "error", err)
+			continue
+		}
+		sent++
+	}
+	return sent, err
+}
+
+// message is the email reminding a voter
+func message(reminder db.Reminder) (notifications.Message, error) {
+	msg, err := notifications.Render(notifications.EventReminder, reminder.Email, notifications.TemplateData{
+		Name:      reminder.Name,
+		PollId:    reminder.PollId,
+		PollTitle: reminder.Title,
+		ClosesAt:  reminder.ClosesAt,
+	})
+	msg.Phone = reminder.Phone
+	return msg, err
+}
@@ -0,0 +1,51 @@
+package retention
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/adllev/Voter-Container/voter-api/db"
+)
+
+// FileArchiver keeps the vote history retention drops in a file, one JSON
+// object per line, see db.ArchivedHistory.  The file is only ever
+// appended to, ship or rotate it like a log
+type FileArchiver struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileArchiver is a constructor function that returns a pointer to a
+// new FileArchiver appending to path, nil without a path
+func NewFileArchiver(path string) *FileArchiver {
+	if path == "" {
+		return nil
+	}
+	return &FileArchiver{path: path}
+}
+
+// Archive appends the entries to the file and syncs it, so they are on
+// disk before retention drops them from redis.  It is a db.Archiver
+func (a *FileArchiver) Archive(entries []db.ArchivedHistory) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	file, err := os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(file)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
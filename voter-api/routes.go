@@ -0,0 +1,155 @@
+package main
+
+import (
+	"github.com/adllev/Voter-Container/voter-api/api"
+	"github.com/adllev/Voter-Container/voter-api/config"
+	"github.com/gofiber/fiber/v2"
+)
+
+// registerRoutes mounts every API route on the provided router.  It is
+// called once for the /api/v1 group and once more for the app itself when
+// the legacy unversioned routes are enabled
+func registerRoutes(router fiber.Router, apiHandler *api.VoterAPI) {
+	//HTTP Standards for "REST" APIS
+	//GET - Read/Query
+	//POST - Create
+	//PUT - Update
+	//DELETE - Delete
+
+	router.Get("/", apiHandler.GetRoot)
+
+	//HEAD first, Get registers HEAD too and the first route wins
+	router.Head("/voters", apiHandler.HeadVoters)
+	router.Head("/voters/:id<int>", apiHandler.HeadVoter)
+	router.Get("/voters", api.ETag, apiHandler.ListAllVoters)
+	router.Get("/voters/:id<int>", api.ETag, apiHandler.GetVoter)
+	router.Get("/voters/search", api.ETag, apiHandler.SearchVoters)
+	router.Get("/voters/leaderboard", apiHandler.GetLeaderboard)
+	router.Get("/voters/verify", apiHandler.VerifyVoter)
+	router.Get("/voters/stream", apiHandler.StreamVoters)
+	router.Get("/voters/events", apiHandler.StreamVoterEvents)
+	router.Get("/ws", api.WebSocketUpgrade, apiHandler.VoteUpdates())
+	router.Post("/voters", apiHandler.Idempotency, apiHandler.PostVoter)
+	router.Post("/voters/register", apiHandler.Idempotency, apiHandler.RegisterVoter)
+	router.Post("/voters/batch", apiHandler.Feature(config.FeatureBulkImport), apiHandler.Idempotency, apiHandler.PostVoterBatch)
+	router.Get("/voters/:id<int>/polls", apiHandler.GetVoterPolls)
+	router.Get("/voters/:id<int>/polls/:pollid<int>", apiHandler.GetVoterPoll)
+	router.Get("/voters/:id<int>/polls/:pollid<int>/receipt", apiHandler.GetVoterPollReceipt)
+	router.Post("/voters/:id<int>/polls/:pollid<int>", apiHandler.Idempotency, apiHandler.PostVoterPoll)
+
+	router.Put("/voters/:id<int>", apiHandler.UpdateVoter)
+	router.Patch("/voters/:id<int>", apiHandler.PatchVoter)
+	router.Delete("/voters", apiHandler.Feature(config.FeatureDeleteAll), apiHandler.DeleteAllVoters)
+	router.Delete("/voters/batch", apiHandler.Feature(config.FeatureBulkImport), apiHandler.DeleteVoterBatch)
+	router.Delete("/voters/:id<int>", apiHandler.DeleteVoter)
+	router.Put("/voters/:id<int>/polls/:pollid<int>", apiHandler.UpdateVoterPoll)
+	router.Delete("/voters/:id<int>/polls/:pollid<int>", apiHandler.DeleteVoterPoll)
+	router.Get("/voters/:id<int>/card.pdf", apiHandler.GetVoterCard)
+
+	router.Get("/voters/health", apiHandler.HealthCheck)
+
+	router.Get("/polls", apiHandler.ListPolls)
+	router.Post("/polls", apiHandler.Idempotency, apiHandler.PostPoll)
+	router.Get("/polls/:pollid<int>", apiHandler.GetPoll)
+	router.Get("/polls/:pollid<int>/results", apiHandler.GetPollResults)
+	router.Get("/polls/:pollid<int>/results/history", apiHandler.GetResultsHistory)
+	router.Get("/polls/:pollid<int>/turnout", apiHandler.GetPollTurnout)
+	router.Get("/polls/:pollid<int>/rate", apiHandler.GetVoteRate)
+	router.Post("/polls/:pollid<int>/votes/batch", apiHandler.Idempotency, apiHandler.PostVoteBatch)
+	router.Put("/polls/:pollid<int>", apiHandler.UpdatePoll)
+	router.Delete("/polls/:pollid<int>", apiHandler.DeletePoll)
+
+	router.Get("/precincts", apiHandler.ListPrecincts)
+	router.Post("/precincts", apiHandler.Idempotency, apiHandler.PostPrecinct)
+	router.Get("/precincts/:precinctid<int>", apiHandler.GetPrecinct)
+	router.Get("/precincts/:precinctid<int>/voters", apiHandler.ListPrecinctVoters)
+	router.Put("/precincts/:precinctid<int>", apiHandler.UpdatePrecinct)
+	router.Delete("/precincts/:precinctid<int>", apiHandler.DeletePrecinct)
+
+	router.Get("/groups", apiHandler.ListGroups)
+	router.Post("/groups", apiHandler.Idempotency, apiHandler.PostGroup)
+	router.Get("/groups/:groupid<int>", apiHandler.GetGroup)
+	router.Get("/groups/:groupid<int>/voters", apiHandler.ListGroupVoters)
+	router.Put("/groups/:groupid<int>", apiHandler.UpdateGroup)
+	router.Put("/groups/:groupid<int>/members/:id<int>", apiHandler.PutGroupMember)
+	router.Delete("/groups/:groupid<int>/members/:id<int>", apiHandler.DeleteGroupMember)
+	router.Delete("/groups/:groupid<int>", apiHandler.DeleteGroup)
+
+	router.Get("/delegations", apiHandler.ListDelegations)
+	router.Post("/delegations", apiHandler.Idempotency, apiHandler.PostDelegation)
+	router.Get("/delegations/:delegationid<int>", apiHandler.GetDelegation)
+	router.Put("/delegations/:delegationid<int>", apiHandler.UpdateDelegation)
+	router.Delete("/delegations/:delegationid<int>", apiHandler.DeleteDelegation)
+
+	router.Get("/votes", apiHandler.ListVotes)
+	router.Post("/votes", apiHandler.Idempotency, apiHandler.PostVote)
+	router.Get("/votes/:voteid<int>", apiHandler.GetVote)
+	router.Put("/votes/:voteid<int>", apiHandler.UpdateVote)
+	router.Delete("/votes/:voteid<int>", apiHandler.DeleteVote)
+
+	router.Get("/stats", apiHandler.GetStats)
+	router.Get("/analytics/turnout", apiHandler.GetTurnoutBreakdown)
+
+	router.Post("/checkin", apiHandler.PostCheckIn)
+	router.Post("/checkin/batch", apiHandler.PostCheckInBatch)
+	router.Get("/checkin/batch/:batchid", apiHandler.GetCheckInBatch)
+
+	router.Get("/notifications/suppressions", apiHandler.ListSuppressions)
+	router.Post("/notifications/suppressions", apiHandler.PostSuppression)
+	router.Delete("/notifications/suppressions/:email", apiHandler.DeleteSuppression)
+	router.Post("/notifications/bounces", apiHandler.PostBounce)
+
+	router.Get("/webhooks", apiHandler.Feature(config.FeatureWebhooks), apiHandler.ListWebhooks)
+	router.Post("/webhooks", apiHandler.Feature(config.FeatureWebhooks), apiHandler.PostWebhook)
+	router.Delete("/webhooks/:id", apiHandler.Feature(config.FeatureWebhooks), apiHandler.DeleteWebhook)
+
+	router.Get("/auth/login", apiHandler.Login)
+	router.Get("/auth/callback", apiHandler.LoginCallback)
+	router.Post("/auth/logout", apiHandler.Logout)
+
+	//Operational endpoints live in their own route group, every one of
+	//them needs the admin role (see requiredRole), reads included
+	admin := router.Group("/admin")
+	admin.Get("/notifications/dead-letter", apiHandler.ListNotificationDeadLetters)
+	admin.Post("/notifications/dead-letter/:id/requeue", apiHandler.RequeueNotificationDeadLetter)
+	admin.Get("/webhooks/dead-letter", apiHandler.ListWebhookDeadLetters)
+	admin.Post("/webhooks/dead-letter/:id/requeue", apiHandler.RequeueWebhookDeadLetter)
+	admin.Get("/anomalies", apiHandler.ListAnomalies)
+	admin.Get("/audit", apiHandler.ListAuditLog)
+	admin.Post("/voters/:id<int>/freeze", apiHandler.FreezeVoter)
+	admin.Post("/voters/:id<int>/unfreeze", apiHandler.UnfreezeVoter)
+	admin.Put("/voters/:id<int>/status", apiHandler.PutVoterStatus)
+	admin.Put("/voters/:id<int>/weight", apiHandler.PutVoterWeight)
+	admin.Post("/voters/:id<int>/suspend", apiHandler.SuspendVoter)
+	admin.Post("/voters/:id<int>/reinstate", apiHandler.ReinstateVoter)
+	admin.Post("/voters/:id<int>/purge", apiHandler.PurgeVoter)
+	admin.Post("/voters/merge", apiHandler.MergeVoters)
+	admin.Get("/voters/duplicates", apiHandler.ListDuplicateVoters)
+	admin.Get("/voters/:id<int>/history/verify", apiHandler.VerifyVoterHistory)
+	admin.Get("/history/verify", apiHandler.VerifyAllHistories)
+	admin.Get("/votes/provisional", apiHandler.ListProvisionalVotes)
+	admin.Post("/votes/:voteid<int>/accept", apiHandler.AcceptProvisionalVote)
+	admin.Post("/votes/:voteid<int>/reject", apiHandler.RejectProvisionalVote)
+	admin.Get("/sync", apiHandler.GetSyncSummary)
+	admin.Post("/sync", apiHandler.PostSync)
+	admin.Get("/capacity", apiHandler.GetCapacity)
+	admin.Post("/capacity/sample", apiHandler.PostCapacitySample)
+	admin.Get("/replays", apiHandler.ListReplayCaptures)
+	admin.Get("/replays/:id", apiHandler.GetReplayCapture)
+	admin.Get("/apikeys", apiHandler.ListAPIKeys)
+	admin.Post("/apikeys", apiHandler.PostAPIKey)
+	admin.Delete("/apikeys/:id", apiHandler.DeleteAPIKey)
+	admin.Get("/stats", apiHandler.GetStoreStats)
+	admin.Get("/keys", apiHandler.GetKeyCounts)
+	admin.Post("/indexes/cleanup", apiHandler.CleanupIndexes)
+	admin.Post("/reindex", apiHandler.Reindex)
+	admin.Post("/snapshot", apiHandler.PostSnapshot)
+	admin.Get("/jobs", apiHandler.ListJobs)
+	admin.Get("/jobs/:name", apiHandler.GetJob)
+	admin.Post("/jobs/:name/run", apiHandler.PostJobRun)
+	admin.Get("/maintenance", apiHandler.GetMaintenance)
+	admin.Put("/maintenance", apiHandler.PutMaintenance)
+	admin.Get("/features", apiHandler.ListFeatures)
+	admin.Put("/features/:name", apiHandler.PutFeature)
+	admin.Delete("/features/:name", apiHandler.DeleteFeature)
+}
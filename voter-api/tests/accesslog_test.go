@@ -0,0 +1,66 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/adllev/Voter-Container/voter-api/api"
+	"github.com/adllev/Voter-Container/voter-api/config"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_AccessLogSampling(t *testing.T) {
+	var logs bytes.Buffer
+	cfg := config.Default()
+	cfg.AccessLogSample = 0
+
+	apiHandler, err := api.NewWithConfig(cfg, slog.New(slog.NewJSONHandler(&logs, nil)))
+	assert.Nil(t, err)
+
+	app := fiber.New(fiber.Config{ErrorHandler: api.ErrorHandler})
+	app.Use(api.RequestID)
+	app.Use(apiHandler.RequestLogger)
+	app.Get("/voters", func(c *fiber.Ctx) error {
+		return c.SendString("[]")
+	})
+	app.Post("/voters", func(c *fiber.Ctx) error {
+		return c.SendString("{}")
+	})
+
+	//With no sampling a successful read is not logged, a write and a
+	//failed read are
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/voters", nil),
+		httptest.NewRequest(http.MethodPost, "/voters", nil),
+		httptest.NewRequest(http.MethodGet, "/voters/missing", nil),
+	} {
+		_, err := app.Test(req)
+		assert.Nil(t, err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	assert.Len(t, lines, 2)
+
+	var entry struct {
+		Method    string `json:"method"`
+		Status    int    `json:"status"`
+		Bytes     int    `json:"bytes"`
+		IP        string `json:"ip"`
+		RequestId string `json:"requestId"`
+	}
+	assert.Nil(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, http.MethodPost, entry.Method)
+	assert.Equal(t, http.StatusOK, entry.Status)
+	assert.Equal(t, 2, entry.Bytes)
+	assert.NotEmpty(t, entry.IP)
+	assert.NotEmpty(t, entry.RequestId)
+
+	assert.Nil(t, json.Unmarshal([]byte(lines[1]), &entry))
+	assert.Equal(t, http.StatusNotFound, entry.Status)
+}
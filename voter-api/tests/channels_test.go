@@ -0,0 +1,136 @@
+package tests
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/adllev/Voter-Container/voter-api/notifications"
+	"github.com/adllev/Voter-Container/voter-api/webhooks"
+	"github.com/stretchr/testify/assert"
+)
+
+// recorder is a notification channel that keeps what it was sent
+type recorder struct {
+	sent []notifications.Message
+}
+
+func (r *recorder) Send(msg notifications.Message) error {
+	r.sent = append(r.sent, msg)
+	return nil
+}
+
+func Test_NotificationChannels(t *testing.T) {
+	var webhookBody []byte
+	var webhookHeader http.Header
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		webhookBody, _ = io.ReadAll(r.Body)
+		webhookHeader = r.Header
+	}))
+	defer webhook.Close()
+
+	var slackText string
+	slack := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		slackText = body["text"]
+	}))
+	defer slack.Close()
+
+	var sms url.Values
+	twilio := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _, _ := r.BasicAuth()
+		assert.Equal(t, "AC123", user)
+		assert.Equal(t, "/Accounts/AC123/Messages.json", r.URL.Path)
+		_ = r.ParseForm()
+		sms = r.PostForm
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer twilio.Close()
+
+	email := &recorder{}
+	router, err := notifications.NewRouter(map[string]notifications.Notifier{
+		notifications.ChannelEmail:   email,
+		notifications.ChannelWebhook: notifications.NewWebhookNotifier(webhook.URL, "s3cret"),
+		notifications.ChannelSlack:   notifications.NewSlackNotifier(slack.URL),
+		notifications.ChannelSMS:     notifications.NewTwilioNotifier(twilio.URL, "AC123", "token", "+15550000000"),
+	}, map[string][]string{
+		notifications.EventReminder: {notifications.ChannelEmail, notifications.ChannelSMS},
+		notifications.EventVerified: {notifications.ChannelWebhook, notifications.ChannelSlack},
+		notifications.EventReceipt:  {},
+	}, []string{notifications.ChannelEmail})
+	assert.Nil(t, err)
+
+	//An unrouted event goes to the default channels
+	assert.Nil(t, router.Send(notifications.Message{Event: notifications.EventVerification, To: "ada@example.com"}))
+	assert.Equal(t, 1, len(email.sent))
+
+	//The webhook gets the message signed, Slack a line of text
+	msg := notifications.Message{Event: notifications.EventVerified, To: "ada@example.com", Subject: "Confirmed", Body: "Welcome"}
+	assert.Nil(t, router.Send(msg))
+	assert.Equal(t, 1, len(email.sent))
+	assert.Equal(t, notifications.EventVerified, webhookHeader.Get(webhooks.EventHeader))
+	timestamp, err := strconv.ParseInt(webhookHeader.Get(webhooks.TimestampHeader), 10, 64)
+	assert.Nil(t, err)
+	assert.Equal(t, webhooks.Sign("s3cret", timestamp, webhookBody), webhookHeader.Get(webhooks.SignatureHeader))
+	var delivered notifications.Message
+	assert.Nil(t, json.Unmarshal(webhookBody, &delivered))
+	assert.Equal(t, msg, delivered)
+	assert.Equal(t, "*Confirmed* (voter.verified)\nWelcome", slackText)
+
+	//A voter without a phone number is only emailed
+	reminder := notifications.Message{Event: notifications.EventReminder, To: "ada@example.com", Subject: "Reminder", Body: "Vote"}
+	assert.Nil(t, router.Send(reminder))
+	assert.Equal(t, 2, len(email.sent))
+	assert.Nil(t, sms)
+
+	reminder.Phone = "+15551234567"
+	assert.Nil(t, router.Send(reminder))
+	assert.Equal(t, 3, len(email.sent))
+	assert.Equal(t, "+15551234567", sms.Get("To"))
+	assert.Equal(t, "+15550000000", sms.Get("From"))
+	assert.Equal(t, "Reminder\n\nVote", sms.Get("Body"))
+
+	//An event routed to no channel is not sent
+	assert.Nil(t, router.Send(notifications.Message{Event: notifications.EventReceipt, To: "ada@example.com"}))
+	assert.Equal(t, 3, len(email.sent))
+
+	//A failing channel fails the message
+	slack.Close()
+	assert.NotNil(t, router.Send(msg))
+}
+
+func Test_NotificationRoutesUnconfigured(t *testing.T) {
+	_, err := notifications.NewRouter(map[string]notifications.Notifier{
+		notifications.ChannelEmail: &recorder{},
+	}, map[string][]string{
+		notifications.EventReminder: {notifications.ChannelSMS},
+	}, []string{notifications.ChannelEmail})
+	assert.NotNil(t, err)
+}
+
+func Test_NotificationChannelsFromConfig(t *testing.T) {
+	channels, err := notifications.NewChannels(notifications.ChannelsConfig{
+		SMTP:            notifications.SMTPConfig{Host: "mail.example.com", From: "no-reply@example.com"},
+		SlackWebhookURL: "https://hooks.slack.example.com/services/T0/B0/x",
+	})
+	assert.Nil(t, err)
+	assert.IsType(t, &notifications.SMTPNotifier{}, channels[notifications.ChannelEmail])
+	assert.IsType(t, &notifications.SlackNotifier{}, channels[notifications.ChannelSlack])
+	assert.NotContains(t, channels, notifications.ChannelSMS)
+	assert.NotContains(t, channels, notifications.ChannelWebhook)
+
+	//Without SMTP emails go to the log
+	channels, err = notifications.NewChannels(notifications.ChannelsConfig{})
+	assert.Nil(t, err)
+	assert.IsType(t, notifications.LogNotifier{}, channels[notifications.ChannelEmail])
+
+	_, err = notifications.NewChannels(notifications.ChannelsConfig{Twilio: notifications.TwilioConfig{AccountSid: "AC123"}})
+	assert.NotNil(t, err)
+	_, err = notifications.NewChannels(notifications.ChannelsConfig{SMTP: notifications.SMTPConfig{Host: "mail.example.com", From: "not an address"}})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,49 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/adllev/Voter-Container/voter-api/api"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func newCompressedApp() *fiber.App {
+	app := fiber.New()
+	app.Use(api.Compress(api.DefaultCompressMinBytes))
+	app.Get("/small", func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"ok": true})
+	})
+	app.Get("/large", func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"data": strings.Repeat("voter ", 1000)})
+	})
+	return app
+}
+
+func encodingFor(t *testing.T, app *fiber.App, path string, acceptEncoding string) string {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+
+	rsp, err := app.Test(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	return rsp.Header.Get("Content-Encoding")
+}
+
+func Test_CompressLargeResponses(t *testing.T) {
+	app := newCompressedApp()
+
+	assert.Equal(t, "gzip", encodingFor(t, app, "/large", "gzip"))
+	assert.Equal(t, "br", encodingFor(t, app, "/large", "gzip, br"))
+	assert.Equal(t, "gzip", encodingFor(t, app, "/large", "br;q=0.5, gzip"))
+	assert.Equal(t, "", encodingFor(t, app, "/large", "identity"))
+}
+
+func Test_CompressSkipsSmallResponses(t *testing.T) {
+	app := newCompressedApp()
+
+	assert.Equal(t, "", encodingFor(t, app, "/small", "gzip, br"))
+}
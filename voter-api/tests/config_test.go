@@ -0,0 +1,136 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adllev/Voter-Container/voter-api/config"
+	"github.com/adllev/Voter-Container/voter-api/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ConfigPrecedence(t *testing.T) {
+	t.Setenv("LISTEN_PORT", "2080")
+	t.Setenv("REDIS_URL", "redis:6379")
+	t.Setenv("REDIS_WAIT", "5s")
+	t.Setenv("LEGACY_SUNSET", "2027-01-31")
+
+	cfg, err := config.Load([]string{"-p", "3080"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, uint(3080), cfg.Port)
+	assert.Equal(t, "redis:6379", cfg.Redis.Addr)
+	assert.Equal(t, 5*time.Second, cfg.RedisWait)
+	assert.Equal(t, config.AuthModeAuto, cfg.Auth.Mode)
+	assert.True(t, cfg.Auth.PublicReads)
+	assert.Equal(t, time.Date(2027, time.January, 31, 0, 0, 0, 0, time.UTC), cfg.LegacySunset)
+}
+
+func Test_ConfigInvalid(t *testing.T) {
+	t.Setenv("LISTEN_PORT", "eighty")
+	_, err := config.Load(nil)
+	assert.ErrorContains(t, err, "LISTEN_PORT")
+
+	t.Setenv("LISTEN_PORT", "")
+	_, err = config.Load([]string{"-schema-guard", "ignore", "-tls-cert", "cert.pem", "-body-limit", "0", "-access-log-sample", "2"})
+	assert.ErrorContains(t, err, "schema guard")
+	assert.ErrorContains(t, err, "TLS")
+	assert.ErrorContains(t, err, "body limit")
+	assert.ErrorContains(t, err, "access log sample")
+}
+
+func Test_ConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "voter-api.yaml")
+	content := "port: 2080\nlogLevel: debug\nredis:\n  url: redis:6379\n  poolSize: 20\nauth:\n  mode: required\n"
+	assert.Nil(t, os.WriteFile(path, []byte(content), 0o600))
+
+	//The environment overrides the file and the flags override both
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("REDIS_POOL_SIZE", "40")
+	cfg, err := config.Load([]string{"-log-level", "warn"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, uint(2080), cfg.Port)
+	assert.Equal(t, "warn", cfg.LogLevel)
+	assert.Equal(t, "redis:6379", cfg.Redis.Addr)
+	assert.Equal(t, 40, cfg.Redis.PoolSize)
+	assert.Equal(t, config.AuthModeRequired, cfg.Auth.Mode)
+
+	//A typo in the file is an error, not a setting that is ignored
+	assert.Nil(t, os.WriteFile(path, []byte("prot: 2080\n"), 0o600))
+	_, err = config.Load([]string{"-config", path})
+	assert.ErrorContains(t, err, "prot")
+}
+
+func Test_ConfigExampleFile(t *testing.T) {
+	cfg, err := config.Load([]string{"-config", "../config.example.yaml"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "redis:6379", cfg.Redis.Addr)
+}
+
+func Test_ConfigFeatures(t *testing.T) {
+	t.Setenv("FEATURE_DELETE_ALL", "false")
+
+	cfg, err := config.Load(nil)
+
+	assert.Nil(t, err)
+	assert.False(t, cfg.Features[config.FeatureDeleteAll])
+	assert.True(t, cfg.Features[config.FeatureWebhooks])
+}
+
+func Test_ConfigAuthAndLimits(t *testing.T) {
+	t.Setenv("JWT_SIGNING_KEY", "config-test-signing-key")
+	t.Setenv("API_KEYS", "tally:5e884898da28047151d0e56f8dc6292773603d0d6aabbdd62a11ef721d1542d8:voters:read")
+	t.Setenv("RATE_LIMIT_VOTES_RPS", "2")
+	t.Setenv("CORS_ALLOW_ORIGINS", "https://polls.example.com, https://admin.example.com")
+
+	cfg, err := config.Load([]string{"-rate-limit-burst", "80"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "config-test-signing-key", cfg.Auth.JWTSigningKey)
+	assert.Equal(t, 1, len(cfg.Auth.APIKeys))
+	assert.Equal(t, config.RateLimitConfig{RPS: 20, Burst: 80}, cfg.RateLimits.Requests)
+	assert.Equal(t, config.RateLimitConfig{RPS: 2, Burst: 5}, cfg.RateLimits.Votes)
+	assert.Equal(t, []string{"https://polls.example.com", "https://admin.example.com"}, cfg.CORS.AllowOrigins)
+
+	t.Setenv("API_KEYS", "tally:not-a-hash")
+	t.Setenv("CORS_ALLOW_ORIGINS", "*")
+	t.Setenv("CORS_ALLOW_CREDENTIALS", "true")
+	_, err = config.Load([]string{"-rate-limit-rps", "-1"})
+	assert.ErrorContains(t, err, "sha256")
+	assert.ErrorContains(t, err, "cors")
+	assert.ErrorContains(t, err, "rate limit")
+}
+
+func Test_ConfigSecretsAndChannels(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "voter-api.yaml")
+	content := "notifications:\n  channels:\n    smtp:\n      host: mail.example.com\n      from: no-reply@example.com\ncapacity:\n  maxVoters: 1000\n"
+	assert.Nil(t, os.WriteFile(path, []byte(content), 0o600))
+
+	t.Setenv("ADMIN_TOKEN", "config-test-admin-token")
+	t.Setenv("CARD_SIGNING_KEY", "config-test-card-key")
+	t.Setenv("SMTP_PASSWORD", "config-test-smtp-password")
+	t.Setenv("SMTP_PORT", "2525")
+	t.Setenv("CAPACITY_MAX_HISTORY", "5000")
+	cfg, err := config.Load([]string{"-config", path})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "config-test-admin-token", cfg.Auth.AdminToken)
+	assert.Equal(t, "config-test-card-key", cfg.SigningKeys.Card)
+	assert.Equal(t, "mail.example.com", cfg.Notifications.Channels.SMTP.Host)
+	assert.Equal(t, 2525, cfg.Notifications.Channels.SMTP.Port)
+	assert.Equal(t, "config-test-smtp-password", cfg.Notifications.Channels.SMTP.Password)
+	assert.Equal(t, map[string]int64{db.CardinalityVoters: 1000, db.CardinalityHistory: 5000}, cfg.Capacity.Limits())
+
+	//Secrets are not read from the file
+	assert.Nil(t, os.WriteFile(path, []byte("notifications:\n  channels:\n    smtp:\n      password: secret\n"), 0o600))
+	_, err = config.Load([]string{"-config", path})
+	assert.ErrorContains(t, err, "password")
+
+	t.Setenv("CAPACITY_MAX_INDEX", "lots")
+	_, err = config.Load(nil)
+	assert.ErrorContains(t, err, "CAPACITY_MAX_INDEX")
+}
@@ -0,0 +1,65 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/adllev/Voter-Container/voter-api/db"
+	"github.com/stretchr/testify/assert"
+)
+
+// tally is plurality results with the votes of options 1, 2, ...
+func tally(votes ...int) db.PollResults {
+	results := db.PollResults{Method: db.MethodPlurality}
+	for i, count := range votes {
+		results.Options = append(results.Options, db.OptionResult{OptionId: i + 1, Votes: count})
+		results.TotalVotes += count
+	}
+	return results
+}
+
+func Test_DecisionRules(t *testing.T) {
+	//Two thirds of the votes for Yes, with a third of the roll voting
+	rules := db.DecisionRules{QuorumPercent: 30, ThresholdPercent: 66.66, PassOptionId: 1}
+
+	outcome := rules.Evaluate(tally(20, 5), 100, false)
+	assert.Equal(t, db.OutcomeInconclusive, outcome.Status)
+	assert.Equal(t, db.ReasonQuorumNotMet, outcome.Reason)
+	assert.False(t, outcome.Final)
+
+	outcome = rules.Evaluate(tally(20, 5), 100, true)
+	assert.Equal(t, db.OutcomeFailed, outcome.Status)
+	assert.Equal(t, db.ReasonQuorumNotMet, outcome.Reason)
+
+	outcome = rules.Evaluate(tally(30, 15), 100, true)
+	assert.Equal(t, db.OutcomePassed, outcome.Status)
+	assert.True(t, outcome.QuorumMet)
+	assert.Equal(t, 1, outcome.OptionId)
+	assert.InDelta(t, 45, outcome.TurnoutPercent, 0.01)
+
+	outcome = rules.Evaluate(tally(29, 16), 100, false)
+	assert.Equal(t, db.OutcomeFailed, outcome.Status)
+	assert.Equal(t, db.ReasonBelowThreshold, outcome.Reason)
+
+	//A simple majority for whichever option leads, a tie can not be called
+	rules = db.DecisionRules{MinVotes: 4, ThresholdPercent: 50}
+	outcome = rules.Evaluate(tally(1, 3, 1), 0, false)
+	assert.Equal(t, db.OutcomePassed, outcome.Status)
+	assert.Equal(t, 2, outcome.OptionId)
+	outcome = rules.Evaluate(tally(2, 2), 0, false)
+	assert.Equal(t, db.OutcomeInconclusive, outcome.Status)
+	assert.Equal(t, db.ReasonTie, outcome.Reason)
+	outcome = rules.Evaluate(tally(2, 1), 0, false)
+	assert.Equal(t, db.ReasonQuorumNotMet, outcome.Reason)
+
+	//An instant-runoff is decided by its final round
+	results := tally(4, 3, 2)
+	results.Method = db.MethodIRV
+	results.Rounds = []db.RunoffRound{
+		{Round: 1, Counts: results.Options},
+		{Round: 2, Counts: []db.OptionResult{{OptionId: 1, Votes: 4}, {OptionId: 2, Votes: 5}}},
+	}
+	outcome = rules.Evaluate(results, 0, true)
+	assert.Equal(t, db.OutcomePassed, outcome.Status)
+	assert.Equal(t, 2, outcome.OptionId)
+	assert.True(t, outcome.Final)
+}
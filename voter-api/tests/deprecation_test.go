@@ -0,0 +1,38 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/adllev/Voter-Container/voter-api/api"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_DeprecatedRoutes(t *testing.T) {
+	sunset := time.Date(2027, time.January, 31, 0, 0, 0, 0, time.UTC)
+
+	app := fiber.New(fiber.Config{ErrorHandler: api.ErrorHandler})
+	legacy := api.Deprecate(app, api.Deprecation{Sunset: sunset, Successor: api.APIPrefix})
+	legacy.Get("/voters", func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderLink, `</voters?after=1>; rel="next"`)
+		return c.SendString("[]")
+	})
+	app.Get(api.APIPrefix+"/voters", func(c *fiber.Ctx) error {
+		return c.SendString("[]")
+	})
+
+	rsp, err := app.Test(httptest.NewRequest(http.MethodGet, "/voters", nil))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.Equal(t, "true", rsp.Header.Get("Deprecation"))
+	assert.Equal(t, "Sun, 31 Jan 2027 00:00:00 GMT", rsp.Header.Get("Sunset"))
+	assert.Equal(t, []string{`</voters?after=1>; rel="next"`, `</api/v1/voters>; rel="successor-version"`}, rsp.Header.Values(fiber.HeaderLink))
+
+	rsp, err = app.Test(httptest.NewRequest(http.MethodGet, api.APIPrefix+"/voters", nil))
+	assert.Nil(t, err)
+	assert.Empty(t, rsp.Header.Get("Deprecation"))
+	assert.Empty(t, rsp.Header.Get("Sunset"))
+}
@@ -0,0 +1,72 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/adllev/Voter-Container/voter-api/api"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ErrorEnvelope(t *testing.T) {
+	app := fiber.New(fiber.Config{ErrorHandler: api.ErrorHandler})
+	app.Use(api.RequestID)
+	app.Get("/voters/:id", func(c *fiber.Ctx) error {
+		return fiber.NewError(http.StatusNotFound, "voter not found")
+	})
+
+	rsp, err := app.Test(httptest.NewRequest(http.MethodGet, "/voters/1", nil))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, rsp.StatusCode)
+
+	var envelope struct {
+		Code      string `json:"code"`
+		Message   string `json:"message"`
+		RequestId string `json:"requestId"`
+	}
+	assert.Nil(t, json.NewDecoder(rsp.Body).Decode(&envelope))
+	assert.Equal(t, "not_found", envelope.Code)
+	assert.Equal(t, "voter not found", envelope.Message)
+	assert.Equal(t, rsp.Header.Get("X-Request-Id"), envelope.RequestId)
+	assert.NotEmpty(t, envelope.RequestId)
+}
+
+type recordingReporter struct {
+	reports []api.PanicReport
+}
+
+func (rr *recordingReporter) Report(report api.PanicReport) {
+	rr.reports = append(rr.reports, report)
+}
+
+func Test_RecoverReportsPanic(t *testing.T) {
+	reporter := &recordingReporter{}
+	app := fiber.New(fiber.Config{ErrorHandler: api.ErrorHandler})
+	app.Use(api.RequestID)
+	app.Use(api.Recover(reporter))
+	app.Get("/voters/:id", func(c *fiber.Ctx) error {
+		panic("voter without a history")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/voters/1", nil)
+	req.Header.Set("X-Request-Id", "panic-1")
+	rsp, err := app.Test(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusInternalServerError, rsp.StatusCode)
+
+	var envelope struct {
+		Code      string `json:"code"`
+		RequestId string `json:"requestId"`
+	}
+	assert.Nil(t, json.NewDecoder(rsp.Body).Decode(&envelope))
+	assert.Equal(t, "internal_server_error", envelope.Code)
+	assert.Equal(t, "panic-1", envelope.RequestId)
+
+	assert.Len(t, reporter.reports, 1)
+	assert.Equal(t, "panic-1", reporter.reports[0].RequestId)
+	assert.EqualError(t, reporter.reports[0].Err, "voter without a history")
+	assert.Contains(t, string(reporter.reports[0].Stack), "Test_RecoverReportsPanic")
+}
@@ -0,0 +1,40 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/adllev/Voter-Container/voter-api/api"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ETagNotModified(t *testing.T) {
+	name := "John Doe"
+	app := fiber.New()
+	app.Get("/voters/1", api.ETag, func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"voterId": 1, "name": name})
+	})
+
+	rsp, err := app.Test(httptest.NewRequest(http.MethodGet, "/voters/1", nil))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	tag := rsp.Header.Get("ETag")
+	assert.NotEmpty(t, tag)
+
+	req := httptest.NewRequest(http.MethodGet, "/voters/1", nil)
+	req.Header.Set("If-None-Match", tag)
+	rsp, err = app.Test(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNotModified, rsp.StatusCode)
+
+	//Once the voter changes the old tag no longer matches
+	name = "Jane Doe"
+	req = httptest.NewRequest(http.MethodGet, "/voters/1", nil)
+	req.Header.Set("If-None-Match", tag)
+	rsp, err = app.Test(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.NotEqual(t, tag, rsp.Header.Get("ETag"))
+}
@@ -0,0 +1,204 @@
+package tests
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adllev/Voter-Container/voter-api/db"
+	"github.com/adllev/Voter-Container/voter-api/jobs"
+	"github.com/stretchr/testify/assert"
+)
+
+// jobStore is an in memory jobs.Store shared by several schedulers, like
+// the replicas sharing redis
+type jobStore struct {
+	mu    sync.Mutex
+	locks map[string]string
+	next  map[string]time.Time
+	runs  map[string][]db.JobRun
+}
+
+func newJobStore() *jobStore {
+	return &jobStore{locks: map[string]string{}, next: map[string]time.Time{}, runs: map[string][]db.JobRun{}}
+}
+
+func (s *jobStore) AcquireJobLock(name string, owner string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, held := s.locks[name]; held {
+		return false, nil
+	}
+	s.locks[name] = owner
+	return true, nil
+}
+
+func (s *jobStore) RefreshJobLock(name string, owner string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.locks[name] == owner, nil
+}
+
+func (s *jobStore) ReleaseJobLock(name string, owner string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.locks[name] == owner {
+		delete(s.locks, name)
+	}
+	return nil
+}
+
+func (s *jobStore) GetJobLock(name string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.locks[name], nil
+}
+
+func (s *jobStore) NextJobRun(name string, first time.Time) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, found := s.next[name]; !found {
+		s.next[name] = first
+	}
+	return s.next[name], nil
+}
+
+func (s *jobStore) SetNextJobRun(name string, next time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.next[name] = next
+	return nil
+}
+
+func (s *jobStore) SaveJobRun(run db.JobRun) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	runs := s.runs[run.Job]
+	if run.Finished != nil && len(runs) > 0 && runs[0].Finished == nil && runs[0].Started.Equal(run.Started) {
+		runs[0] = run
+		return nil
+	}
+	s.runs[run.Job] = append([]db.JobRun{run}, runs...)
+	return nil
+}
+
+func (s *jobStore) GetJobRuns(name string, limit int) ([]db.JobRun, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	runs := s.runs[name]
+	if len(runs) > limit {
+		runs = runs[:limit]
+	}
+	return append([]db.JobRun{}, runs...), nil
+}
+
+// waitForRun waits until the latest run of a job finished
+func waitForRun(t *testing.T, scheduler *jobs.Scheduler, name string) jobs.Status {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status, err := scheduler.Status(name)
+		assert.Nil(t, err)
+		if status.LastRun != nil && status.LastRun.Finished != nil && status.RunningOn == "" {
+			return status
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s did not finish", name)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func Test_JobSchedules(t *testing.T) {
+	at := time.Date(2026, time.March, 14, 10, 17, 30, 0, time.UTC)
+
+	schedule, err := jobs.ParseSchedule("30 2 * * *")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2026, time.March, 15, 2, 30, 0, 0, time.UTC), schedule.Next(at))
+
+	schedule, err = jobs.ParseSchedule("*/15 9-17 * * 1-5")
+	assert.Nil(t, err)
+	//Saturday, so on to Monday morning
+	assert.Equal(t, time.Date(2026, time.March, 16, 9, 0, 0, 0, time.UTC), schedule.Next(at))
+
+	schedule, err = jobs.ParseSchedule("@weekly")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2026, time.March, 15, 0, 0, 0, 0, time.UTC), schedule.Next(at))
+
+	schedule, err = jobs.ParseSchedule("@every 15m")
+	assert.Nil(t, err)
+	assert.Equal(t, at, schedule.First(at))
+	assert.Equal(t, at.Add(15*time.Minute), schedule.Next(at))
+
+	schedule, err = jobs.ParseSchedule("off")
+	assert.Nil(t, err)
+	assert.Nil(t, schedule)
+
+	for _, spec := range []string{"61 * * * *", "* * *", "@every soon", "0 0 31 2 *", "5-1 * * * *"} {
+		_, err := jobs.ParseSchedule(spec)
+		assert.NotNil(t, err, spec)
+	}
+}
+
+func Test_JobScheduler(t *testing.T) {
+	store := newJobStore()
+	var mu sync.Mutex
+	runs := 0
+	job := func(ctx context.Context) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		runs++
+		return "done", nil
+	}
+	schedule, _ := jobs.ParseSchedule("@every 1h")
+
+	//Two replicas find the job due at the same time, only one runs it
+	replicas := []*jobs.Scheduler{jobs.NewScheduler(store), jobs.NewScheduler(store)}
+	for _, replica := range replicas {
+		replica.Add("count", schedule, job)
+	}
+	now := time.Now()
+	for _, replica := range replicas {
+		replica.RunDue(context.Background(), now)
+	}
+	status := waitForRun(t, replicas[0], "count")
+	for _, replica := range replicas {
+		replica.RunDue(context.Background(), now)
+	}
+	status = waitForRun(t, replicas[0], "count")
+	assert.Equal(t, 1, runs)
+	assert.Equal(t, jobs.TriggerSchedule, status.LastRun.Trigger)
+	assert.Equal(t, db.JobRunSucceeded, status.LastRun.Status)
+	assert.Equal(t, "done", status.LastRun.Result)
+	assert.Equal(t, now.Add(time.Hour).Unix(), status.Next.Unix())
+
+	//An hour later it is due again
+	replicas[1].RunDue(context.Background(), now.Add(time.Hour))
+	waitForRun(t, replicas[1], "count")
+	assert.Equal(t, 2, runs)
+
+	//A triggered run does not wait for the schedule, but not while a
+	//replica is running the job
+	_, err := replicas[0].Trigger("count")
+	assert.Nil(t, err)
+	status = waitForRun(t, replicas[0], "count")
+	assert.Equal(t, 3, runs)
+	assert.Equal(t, jobs.TriggerManual, status.LastRun.Trigger)
+	assert.Equal(t, 3, len(status.Runs))
+
+	_, _ = store.AcquireJobLock("count", "another-replica", time.Minute)
+	_, err = replicas[0].Trigger("count")
+	assert.ErrorIs(t, err, jobs.ErrRunning)
+	_, err = replicas[0].Trigger("nope")
+	assert.ErrorIs(t, err, jobs.ErrUnknownJob)
+
+	//A job that panics fails its run
+	replicas[0].Add("broken", nil, func(ctx context.Context) (string, error) {
+		panic("oops")
+	})
+	_, err = replicas[0].Trigger("broken")
+	assert.Nil(t, err)
+	status = waitForRun(t, replicas[0], "broken")
+	assert.Equal(t, db.JobRunFailed, status.LastRun.Status)
+	assert.Nil(t, status.Next)
+}
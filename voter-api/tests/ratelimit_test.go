@@ -0,0 +1,116 @@
+package tests
+
+import (
+	"bufio"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adllev/Voter-Container/voter-api/api"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// redisServer answers redis commands on a local port, every script run
+// takes a token and sends the bucket key to the returned channel.  Other
+// commands get an OK, HELLO an error so the client stays on RESP2
+func redisServer(t *testing.T) (string, chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	t.Cleanup(func() { listener.Close() })
+	buckets := make(chan string, 16)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveRedis(conn, buckets)
+		}
+	}()
+
+	return listener.Addr().String(), buckets
+}
+
+func serveRedis(conn net.Conn, buckets chan string) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	reader := bufio.NewReader(conn)
+	readLine := func() (string, error) {
+		line, err := reader.ReadString('\n')
+		return strings.TrimSuffix(line, "\r\n"), err
+	}
+	for {
+		//Every command is an array of bulk strings
+		line, err := readLine()
+		if err != nil || !strings.HasPrefix(line, "*") {
+			return
+		}
+		count, _ := strconv.Atoi(line[1:])
+		args := make([]string, 0, count)
+		for i := 0; i < count; i++ {
+			if _, err := readLine(); err != nil {
+				return
+			}
+			arg, err := readLine()
+			if err != nil {
+				return
+			}
+			args = append(args, arg)
+		}
+
+		switch strings.ToUpper(args[0]) {
+		case "HELLO":
+			_, _ = conn.Write([]byte("-ERR unknown command\r\n"))
+		case "EVALSHA", "EVAL":
+			buckets <- args[3]
+			_, _ = conn.Write([]byte("*2\r\n:1\r\n:0\r\n"))
+		default:
+			_, _ = conn.Write([]byte("+OK\r\n"))
+		}
+	}
+}
+
+// bucketOf returns the rate limit bucket a request is counted against
+func bucketOf(t *testing.T, app *fiber.App, buckets chan string, method string, path string) string {
+	rsp, err := app.Test(httptest.NewRequest(method, path, nil))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+
+	select {
+	case bucket := <-buckets:
+		return bucket
+	case <-time.After(time.Second):
+		t.Fatalf("%s %s took no token", method, path)
+		return ""
+	}
+}
+
+func Test_RateLimitVoteRecording(t *testing.T) {
+	addr, buckets := redisServer(t)
+	t.Setenv("REDIS_URL", addr)
+	t.Setenv("RATE_LIMIT_RPS", "20")
+	t.Setenv("RATE_LIMIT_VOTES_RPS", "1")
+
+	apiHandler, err := api.New(slog.Default())
+	assert.Nil(t, err)
+
+	app := fiber.New()
+	app.Use(apiHandler.RateLimiter)
+	app.All("/*", func(c *fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+
+	assert.True(t, strings.HasPrefix(bucketOf(t, app, buckets, http.MethodPost, "/api/v1/voters/1/polls/1"), "ratelimit:votes:"))
+	assert.True(t, strings.HasPrefix(bucketOf(t, app, buckets, http.MethodPost, "/api/v1/polls/1/votes/batch"), "ratelimit:votes:"))
+	assert.True(t, strings.HasPrefix(bucketOf(t, app, buckets, http.MethodPost, "/api/v1/votes"), "ratelimit:votes:"))
+	assert.True(t, strings.HasPrefix(bucketOf(t, app, buckets, http.MethodPost, "/api/v1/polls"), "ratelimit:all:"))
+	assert.True(t, strings.HasPrefix(bucketOf(t, app, buckets, http.MethodGet, "/api/v1/polls/1/votes/batch"), "ratelimit:all:"))
+}
@@ -0,0 +1,156 @@
+package tests
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/adllev/Voter-Container/voter-api/api"
+	"github.com/adllev/Voter-Container/voter-api/auth"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// The role checks happen in middleware before any handler runs, so these
+// tests mount the middleware in front of a stub handler and do not need
+// the server or redis to be running
+func newAuthorizedApp(t *testing.T) (*fiber.App, *auth.JWTVerifier) {
+	return newAuthApp(t, "false")
+}
+
+// newAuthApp is newAuthorizedApp with AUTH_PUBLIC_READS set to publicReads
+func newAuthApp(t *testing.T, publicReads string) (*fiber.App, *auth.JWTVerifier) {
+	t.Setenv("JWT_SIGNING_KEY", "rbac-test-signing-key")
+	t.Setenv("AUTH_PUBLIC_READS", publicReads)
+
+	apiHandler, err := api.New(slog.Default())
+	assert.Nil(t, err)
+
+	app := fiber.New()
+	app.Use(apiHandler.Authenticate)
+	app.Use(apiHandler.Authorize)
+	app.All("/*", func(c *fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+
+	return app, auth.NewJWTVerifier("rbac-test-signing-key", "")
+}
+
+func statusAs(t *testing.T, app *fiber.App, signer *auth.JWTVerifier, role string, method string, path string) int {
+	req := httptest.NewRequest(method, path, nil)
+	if role != "" {
+		token, err := signer.Sign("rbac-test", []string{role}, "", time.Minute)
+		assert.Nil(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rsp, err := app.Test(req)
+	assert.Nil(t, err)
+	return rsp.StatusCode
+}
+
+func Test_RBACAnonymous(t *testing.T) {
+	app, signer := newAuthorizedApp(t)
+
+	assert.Equal(t, http.StatusUnauthorized, statusAs(t, app, signer, "", http.MethodGet, "/api/v1/voters"))
+	assert.Equal(t, http.StatusOK, statusAs(t, app, signer, "", http.MethodGet, "/api/v1/voters/health"))
+	assert.Equal(t, http.StatusOK, statusAs(t, app, signer, "", http.MethodPost, "/api/v1/voters/register"))
+}
+
+func Test_RBACAnonymousMixedCase(t *testing.T) {
+	app, signer := newAuthApp(t, "true")
+
+	//Reads are public, but the router matches the admin paths in any case
+	assert.Equal(t, http.StatusOK, statusAs(t, app, signer, "", http.MethodGet, "/api/v1/voters"))
+	assert.Equal(t, http.StatusUnauthorized, statusAs(t, app, signer, "", http.MethodGet, "/api/v1/admin/apikeys"))
+	assert.Equal(t, http.StatusUnauthorized, statusAs(t, app, signer, "", http.MethodGet, "/api/v1/ADMIN/apikeys"))
+	assert.Equal(t, http.StatusUnauthorized, statusAs(t, app, signer, "", http.MethodGet, "/API/V1/admin/replays"))
+	assert.Equal(t, http.StatusUnauthorized, statusAs(t, app, signer, "", http.MethodGet, "/Admin/audit"))
+}
+
+func Test_RBACReader(t *testing.T) {
+	app, signer := newAuthorizedApp(t)
+
+	assert.Equal(t, http.StatusOK, statusAs(t, app, signer, auth.RoleReader, http.MethodGet, "/api/v1/voters"))
+	assert.Equal(t, http.StatusForbidden, statusAs(t, app, signer, auth.RoleReader, http.MethodPost, "/api/v1/voters/1/polls/1"))
+	assert.Equal(t, http.StatusForbidden, statusAs(t, app, signer, auth.RoleReader, http.MethodDelete, "/api/v1/voters"))
+	assert.Equal(t, http.StatusForbidden, statusAs(t, app, signer, auth.RoleReader, http.MethodGet, "/api/v1/admin/audit"))
+	assert.Equal(t, http.StatusForbidden, statusAs(t, app, signer, auth.RoleReader, http.MethodGet, "/api/v1/webhooks"))
+}
+
+func Test_RBACOperator(t *testing.T) {
+	app, signer := newAuthorizedApp(t)
+
+	assert.Equal(t, http.StatusOK, statusAs(t, app, signer, auth.RoleOperator, http.MethodGet, "/api/v1/voters"))
+	assert.Equal(t, http.StatusOK, statusAs(t, app, signer, auth.RoleOperator, http.MethodPost, "/api/v1/voters/1/polls/1"))
+	assert.Equal(t, http.StatusOK, statusAs(t, app, signer, auth.RoleOperator, http.MethodPost, "/api/v1/voters"))
+	assert.Equal(t, http.StatusForbidden, statusAs(t, app, signer, auth.RoleOperator, http.MethodDelete, "/api/v1/voters/1"))
+	assert.Equal(t, http.StatusForbidden, statusAs(t, app, signer, auth.RoleOperator, http.MethodDelete, "/api/v1/voters"))
+	assert.Equal(t, http.StatusForbidden, statusAs(t, app, signer, auth.RoleOperator, http.MethodDelete, "/api/v1/voters/batch"))
+	assert.Equal(t, http.StatusForbidden, statusAs(t, app, signer, auth.RoleOperator, http.MethodPost, "/api/v1/admin/reindex"))
+	assert.Equal(t, http.StatusOK, statusAs(t, app, signer, auth.RoleOperator, http.MethodPost, "/api/v1/polls"))
+	assert.Equal(t, http.StatusForbidden, statusAs(t, app, signer, auth.RoleOperator, http.MethodDelete, "/api/v1/polls/1"))
+
+	//The router ignores case, so do the role checks
+	assert.Equal(t, http.StatusForbidden, statusAs(t, app, signer, auth.RoleOperator, http.MethodDelete, "/api/v1/VOTERS"))
+	assert.Equal(t, http.StatusForbidden, statusAs(t, app, signer, auth.RoleOperator, http.MethodDelete, "/api/v1/Polls/1"))
+	assert.Equal(t, http.StatusForbidden, statusAs(t, app, signer, auth.RoleOperator, http.MethodDelete, "/API/V1/Groups/1"))
+	assert.Equal(t, http.StatusForbidden, statusAs(t, app, signer, auth.RoleOperator, http.MethodPost, "/api/v1/ADMIN/reindex"))
+	assert.Equal(t, http.StatusForbidden, statusAs(t, app, signer, auth.RoleOperator, http.MethodPost, "/api/v1/webhooks"))
+	assert.Equal(t, http.StatusForbidden, statusAs(t, app, signer, auth.RoleOperator, http.MethodPost, "/api/v1/WEBHOOKS"))
+	assert.Equal(t, http.StatusForbidden, statusAs(t, app, signer, auth.RoleOperator, http.MethodGet, "/Webhooks"))
+}
+
+func Test_RBACAdmin(t *testing.T) {
+	app, signer := newAuthorizedApp(t)
+
+	assert.Equal(t, http.StatusOK, statusAs(t, app, signer, auth.RoleAdmin, http.MethodGet, "/api/v1/voters"))
+	assert.Equal(t, http.StatusOK, statusAs(t, app, signer, auth.RoleAdmin, http.MethodDelete, "/api/v1/voters/1"))
+	assert.Equal(t, http.StatusOK, statusAs(t, app, signer, auth.RoleAdmin, http.MethodDelete, "/api/v1/voters"))
+	assert.Equal(t, http.StatusOK, statusAs(t, app, signer, auth.RoleAdmin, http.MethodGet, "/api/v1/admin/audit"))
+}
+
+func Test_RBACDeploymentAdmin(t *testing.T) {
+	app, signer := newAuthorizedApp(t)
+	statusFor := func(tenantID string, method string, path string) int {
+		token, err := signer.Sign("rbac-test", []string{auth.RoleAdmin}, tenantID, time.Minute)
+		assert.Nil(t, err)
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rsp, err := app.Test(req)
+		assert.Nil(t, err)
+		return rsp.StatusCode
+	}
+
+	//The admin of one tenant administers that tenant, not the deployment
+	assert.Equal(t, http.StatusOK, statusFor("", http.MethodGet, "/api/v1/admin/audit"))
+	for _, path := range []string{"/api/v1/webhooks", "/api/v1/admin/maintenance", "/api/v1/admin/features", "/api/v1/admin/jobs", "/API/V1/Admin/Jobs/reindex/run"} {
+		assert.Equal(t, http.StatusForbidden, statusFor("", http.MethodPost, path), path)
+		assert.Equal(t, http.StatusOK, statusFor(auth.AnyTenant, http.MethodPost, path), path)
+	}
+}
+
+func Test_APIKeyScopes(t *testing.T) {
+	t.Setenv("API_KEYS", "votes:"+auth.HashAPIKey("votes-key")+":votes:write,polls:"+auth.HashAPIKey("polls-key")+":polls:write")
+	app, _ := newAuthApp(t, "true")
+
+	statusWith := func(key string, method string, path string) int {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set(api.APIKeyHeader, key)
+		rsp, err := app.Test(req)
+		assert.Nil(t, err)
+		return rsp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusOK, statusWith("votes-key", http.MethodPost, "/api/v1/votes"))
+	assert.Equal(t, http.StatusOK, statusWith("votes-key", http.MethodPost, "/api/v1/voters/1/polls/1"))
+	assert.Equal(t, http.StatusOK, statusWith("votes-key", http.MethodPost, "/api/v1/delegations"))
+	assert.Equal(t, http.StatusForbidden, statusWith("votes-key", http.MethodPut, "/api/v1/polls/1"))
+	assert.Equal(t, http.StatusForbidden, statusWith("votes-key", http.MethodPost, "/api/v1/voters"))
+
+	assert.Equal(t, http.StatusOK, statusWith("polls-key", http.MethodPut, "/api/v1/polls/1"))
+	assert.Equal(t, http.StatusOK, statusWith("polls-key", http.MethodPost, "/api/v1/Groups"))
+	assert.Equal(t, http.StatusForbidden, statusWith("polls-key", http.MethodPost, "/api/v1/votes"))
+}
@@ -0,0 +1,69 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adllev/Voter-Container/voter-api/db"
+	"github.com/adllev/Voter-Container/voter-api/notifications"
+	"github.com/adllev/Voter-Container/voter-api/reminders"
+	"github.com/stretchr/testify/assert"
+)
+
+// reminderStore is an in memory reminders.Store that hands out its
+// reminders once, like the reminded sets of the db package
+type reminderStore struct {
+	due  []db.Reminder
+	lead time.Duration
+}
+
+func (s *reminderStore) ClaimReminders(now time.Time, lead time.Duration) ([]db.Reminder, error) {
+	s.lead = lead
+	claimed := s.due
+	s.due = nil
+	return claimed, nil
+}
+
+// reminderSender records the messages it was asked to send, suppressing
+// the ones to suppressed
+type reminderSender struct {
+	sent       []notifications.Message
+	suppressed string
+}
+
+func (s *reminderSender) Send(msg notifications.Message) error {
+	if msg.To == s.suppressed {
+		return notifications.ErrSuppressed
+	}
+	s.sent = append(s.sent, msg)
+	return nil
+}
+
+func Test_Reminders(t *testing.T) {
+	closesAt := time.Now().Add(2 * time.Hour)
+	store := &reminderStore{due: []db.Reminder{
+		{VoterId: 1, Name: "Ada Park", Email: "ada@example.com", PollId: 3, Title: "Budget", ClosesAt: closesAt},
+		{VoterId: 2, Name: "Bo Reed", Email: "bo@example.com", PollId: 3, Title: "Budget", ClosesAt: closesAt},
+	}}
+	sender := &reminderSender{suppressed: "bo@example.com"}
+	scheduler := reminders.NewScheduler(store, sender, 24*time.Hour)
+
+	sent, err := scheduler.Remind(time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, sent)
+	assert.Equal(t, 24*time.Hour, store.lead)
+	assert.Len(t, sender.sent, 1)
+	assert.Equal(t, "ada@example.com", sender.sent[0].To)
+	assert.Equal(t, db.EventPollReminder, sender.sent[0].Event)
+	assert.Contains(t, sender.sent[0].Subject, "Budget")
+
+	//Claimed voters are not reminded again
+	sent, err = scheduler.Remind(time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, sent)
+	assert.Len(t, sender.sent, 1)
+}
+
+func Test_RemindersOff(t *testing.T) {
+	assert.Nil(t, reminders.NewScheduler(&reminderStore{}, &reminderSender{}, 0))
+}
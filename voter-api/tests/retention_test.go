@@ -0,0 +1,53 @@
+package tests
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adllev/Voter-Container/voter-api/db"
+	"github.com/adllev/Voter-Container/voter-api/retention"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_RetentionArchive(t *testing.T) {
+	assert.Nil(t, retention.NewFileArchiver(""))
+
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	archiver := retention.NewFileArchiver(path)
+	votedAt := time.Date(2024, time.May, 2, 9, 30, 0, 0, time.UTC)
+	archivedAt := time.Date(2026, time.May, 2, 0, 0, 0, 0, time.UTC)
+
+	//Every call appends, nothing archived before is lost
+	assert.Nil(t, archiver.Archive([]db.ArchivedHistory{
+		{VoterId: 1, Entry: db.VoterHistory{PollId: 3, VoteId: 30, VoteDate: votedAt}, ArchivedAt: archivedAt},
+	}))
+	assert.Nil(t, archiver.Archive([]db.ArchivedHistory{
+		{Tenant: "acme", VoterId: 2, Entry: db.VoterHistory{PollId: 3, VoteId: 31, VoteDate: votedAt}, ArchivedAt: archivedAt},
+		{Tenant: "acme", VoterId: 2, Entry: db.VoterHistory{PollId: 4, VoteId: 40, VoteDate: votedAt}, ArchivedAt: archivedAt},
+	}))
+
+	file, err := os.Open(path)
+	assert.Nil(t, err)
+	defer file.Close()
+	var archived []db.ArchivedHistory
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry db.ArchivedHistory
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &entry))
+		archived = append(archived, entry)
+	}
+	assert.Equal(t, 3, len(archived))
+	assert.Equal(t, 1, archived[0].VoterId)
+	assert.Equal(t, "acme", archived[2].Tenant)
+	assert.Equal(t, 40, archived[2].Entry.VoteId)
+	assert.True(t, votedAt.Equal(archived[2].Entry.VoteDate))
+
+	//A file that can not be written fails the archive, so retention does
+	//not drop anything
+	broken := retention.NewFileArchiver(filepath.Join(t.TempDir(), "missing", "history.jsonl"))
+	assert.NotNil(t, broken.Archive(archived))
+}
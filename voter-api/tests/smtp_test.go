@@ -0,0 +1,88 @@
+package tests
+
+import (
+	"bufio"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adllev/Voter-Container/voter-api/notifications"
+	"github.com/stretchr/testify/assert"
+)
+
+// smtpServer accepts one SMTP session on a local port and sends what was
+// after DATA to the returned channel
+func smtpServer(t *testing.T) (string, chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	received := make(chan string, 1)
+
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { _, _ = conn.Write([]byte(line + "\r\n")) }
+		reply("220 localhost ESMTP")
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch command := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+				reply("250 localhost")
+			case command == "DATA":
+				reply("354 go ahead")
+				var data strings.Builder
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				received <- data.String()
+				reply("250 queued")
+			case command == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+
+	return listener.Addr().String(), received
+}
+
+func Test_SMTPNotifier(t *testing.T) {
+	addr, received := smtpServer(t)
+	notifier := notifications.NewSMTPNotifier(addr, mail.Address{Name: "Elections", Address: "no-reply@example.com"}, "", "")
+
+	msg, err := notifications.Render(notifications.EventReceipt, "ada@example.com", notifications.TemplateData{
+		Name:      "Ada Park",
+		PollTitle: "Budget",
+		Receipt:   "abc.def",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "Your vote in Budget was recorded", msg.Subject)
+	assert.Nil(t, notifier.Send(msg))
+
+	data := <-received
+	assert.Contains(t, data, "To: <ada@example.com>\r\n")
+	assert.Contains(t, data, "Subject: Your vote in Budget was recorded\r\n")
+	assert.Contains(t, data, "Hello Ada Park,")
+	assert.Contains(t, data, "abc.def")
+
+	//A subject can not smuggle in headers of its own
+	msg.Subject = "Hi\r\nBcc: someone@example.com"
+	assert.NotNil(t, notifier.Send(msg))
+}
@@ -0,0 +1,66 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/adllev/Voter-Container/voter-api/db"
+	"github.com/adllev/Voter-Container/voter-api/upstream"
+	"github.com/stretchr/testify/assert"
+)
+
+// syncStore is an in memory upstream.Store that records what it was asked
+// to reconcile
+type syncStore struct {
+	upstream []db.VoterItem
+	saved    db.SyncSummary
+	audit    []db.AuditEntry
+}
+
+func (s *syncStore) ReconcileVoters(voterList []db.VoterItem) (db.SyncSummary, error) {
+	s.upstream = voterList
+	summary := db.SyncSummary{Upstream: len(voterList)}
+	for _, voterItem := range voterList {
+		summary.Created = append(summary.Created, voterItem.VoterId)
+	}
+	return summary, nil
+}
+
+func (s *syncStore) SaveSyncSummary(summary db.SyncSummary) error {
+	s.saved = summary
+	return nil
+}
+
+func (s *syncStore) AppendAudit(entry db.AuditEntry) error {
+	s.audit = append(s.audit, entry)
+	return nil
+}
+
+func Test_SyncCSV(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		_, _ = w.Write([]byte("voterId,name,email,voteCount,region\n1,Ada Park,ada@example.com,3,North\nseven,Bad Row,bad@example.com,0,\n2,No Email,,0,South\n"))
+	}))
+	defer server.Close()
+
+	store := &syncStore{}
+	summary, err := upstream.NewSyncer(store, server.URL).Sync(context.Background())
+
+	assert.Nil(t, err)
+	assert.Equal(t, []db.VoterItem{{VoterId: 1, Name: "Ada Park", Email: "ada@example.com", Region: "North"}}, store.upstream)
+	assert.Equal(t, server.URL, summary.Source)
+	assert.Len(t, summary.Errors, 2)
+	assert.Equal(t, summary, store.saved)
+	assert.Equal(t, "voters.synced", store.audit[0].Action)
+}
+
+func Test_SyncNotConfigured(t *testing.T) {
+	assert.Nil(t, upstream.NewSyncer(&syncStore{}, ""))
+
+	//The test server runs without SYNC_URL
+	rsp, err := cli.R().Post(BASE_API + "/admin/sync")
+	assert.Nil(t, err)
+	assert.Equal(t, 409, rsp.StatusCode())
+}
@@ -0,0 +1,91 @@
+package tests
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/adllev/Voter-Container/voter-api/api"
+	"github.com/adllev/Voter-Container/voter-api/auth"
+	"github.com/adllev/Voter-Container/voter-api/config"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_TenantSelection(t *testing.T) {
+	cfg := config.Default()
+	cfg.Tenants.Domain = "elections.example.com"
+	cfg.Tenants.Allowed = []string{"springfield", "shelbyville"}
+
+	apiHandler, err := api.NewWithConfig(cfg, slog.Default())
+	assert.Nil(t, err)
+
+	app := fiber.New(fiber.Config{ErrorHandler: api.ErrorHandler})
+	app.Use(apiHandler.Tenant)
+	app.Get("/voters", func(c *fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+
+	status := func(host string, tenantID string) int {
+		req := httptest.NewRequest(http.MethodGet, "http://"+host+"/voters", nil)
+		if tenantID != "" {
+			req.Header.Set(api.TenantIDHeader, tenantID)
+		}
+		rsp, err := app.Test(req)
+		assert.Nil(t, err)
+		return rsp.StatusCode
+	}
+
+	//No tenant is the default tenant
+	assert.Equal(t, http.StatusOK, status("localhost:1080", ""))
+	assert.Equal(t, http.StatusOK, status("localhost:1080", "springfield"))
+	assert.Equal(t, http.StatusOK, status("shelbyville.elections.example.com", ""))
+
+	assert.Equal(t, http.StatusNotFound, status("localhost:1080", "ogdenville"))
+	assert.Equal(t, http.StatusNotFound, status("ogdenville.elections.example.com:1080", ""))
+	assert.Equal(t, http.StatusBadRequest, status("localhost:1080", "../springfield"))
+}
+
+func Test_TenantCredentials(t *testing.T) {
+	t.Setenv("JWT_SIGNING_KEY", "tenant-test-signing-key")
+	t.Setenv("API_KEYS", "tally@springfield:"+auth.HashAPIKey("springfield-key")+":voters:read")
+	cfg, err := config.FromEnv()
+	assert.Nil(t, err)
+	cfg.Auth.PublicReads = false
+
+	apiHandler, err := api.NewWithConfig(cfg, slog.Default())
+	assert.Nil(t, err)
+
+	app := fiber.New(fiber.Config{ErrorHandler: api.ErrorHandler})
+	app.Use(apiHandler.Tenant)
+	app.Use(apiHandler.Authenticate)
+	app.Get("/voters", func(c *fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+
+	status := func(tenantID string, header string, value string) int {
+		req := httptest.NewRequest(http.MethodGet, "/voters", nil)
+		if tenantID != "" {
+			req.Header.Set(api.TenantIDHeader, tenantID)
+		}
+		req.Header.Set(header, value)
+		rsp, err := app.Test(req)
+		assert.Nil(t, err)
+		return rsp.StatusCode
+	}
+	bearer := func(tenantID string) string {
+		token, err := auth.NewJWTVerifier("tenant-test-signing-key", "").Sign("tenant-test", []string{auth.RoleReader}, tenantID, time.Minute)
+		assert.Nil(t, err)
+		return "Bearer " + token
+	}
+
+	//Credentials only work in the tenant they were issued for
+	assert.Equal(t, http.StatusOK, status("springfield", api.APIKeyHeader, "springfield-key"))
+	assert.Equal(t, http.StatusForbidden, status("shelbyville", api.APIKeyHeader, "springfield-key"))
+	assert.Equal(t, http.StatusForbidden, status("", api.APIKeyHeader, "springfield-key"))
+	assert.Equal(t, http.StatusOK, status("", fiber.HeaderAuthorization, bearer("")))
+	assert.Equal(t, http.StatusForbidden, status("shelbyville", fiber.HeaderAuthorization, bearer("")))
+	assert.Equal(t, http.StatusOK, status("shelbyville", fiber.HeaderAuthorization, bearer(auth.AnyTenant)))
+}
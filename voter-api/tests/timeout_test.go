@@ -0,0 +1,33 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/adllev/Voter-Container/voter-api/api"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_TimeoutCancelsRequest(t *testing.T) {
+	app := fiber.New(fiber.Config{ErrorHandler: api.ErrorHandler})
+	app.Use(api.Timeout(50 * time.Millisecond))
+	app.Get("/slow", func(c *fiber.Ctx) error {
+		//Stands in for a redis call that never answers
+		<-c.UserContext().Done()
+		return c.UserContext().Err()
+	})
+	app.Get("/fast", func(c *fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+
+	rsp, err := app.Test(httptest.NewRequest(http.MethodGet, "/slow", nil), 1000)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusGatewayTimeout, rsp.StatusCode)
+
+	rsp, err = app.Test(httptest.NewRequest(http.MethodGet, "/fast", nil))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+}
@@ -1,10 +1,17 @@
 package tests
 
 import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/adllev/Voter-Container/voter-api/db"
+	"github.com/adllev/Voter-Container/voter-api/jobs"
 	"github.com/go-resty/resty/v2"
 	"github.com/stretchr/testify/assert"
 )
@@ -30,16 +37,27 @@ func Test_AddSingleVoter(t *testing.T) {
 
 	assert.Nil(t, err)
 	assert.Equal(t, 200, rsp.StatusCode())
+	verifyVoter(t, 1)
 }
 
 func Test_AddSingleVoterPoll(t *testing.T) {
+	//Votes can only be recorded in polls that exist
+	rsp, err := cli.R().SetBody(db.Poll{
+		PollId:   1,
+		Title:    "Budget",
+		Question: "Should the library budget go up?",
+		Options:  []db.PollOption{{OptionId: 1, Text: "Yes"}, {OptionId: 2, Text: "No"}},
+	}).Post(BASE_API + "/polls")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
 	newVoterPoll := db.VoterHistory{
 		PollId:   1,
 		VoteId:   1,
 		VoteDate: time.Now(),
 	}
 
-	rsp, err := cli.R().
+	rsp, err = cli.R().
 		SetBody(newVoterPoll).
 		SetResult(&newVoterPoll).
 		Post(BASE_API + "/voters/1/polls/1")
@@ -47,6 +65,21 @@ func Test_AddSingleVoterPoll(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, 200, rsp.StatusCode())
 
+	//Voting twice in the same poll is a conflict
+	rsp, err = cli.R().SetBody(newVoterPoll).Post(BASE_API + "/voters/1/polls/1")
+	assert.Nil(t, err)
+	assert.Equal(t, 409, rsp.StatusCode())
+
+	//So is a body for a different poll than the path
+	rsp, err = cli.R().SetBody(newVoterPoll).Post(BASE_API + "/voters/1/polls/2")
+	assert.Nil(t, err)
+	assert.Equal(t, 409, rsp.StatusCode())
+
+	//A poll that does not exist can not end up in a history
+	rsp, err = cli.R().SetBody(db.VoterHistory{VoteId: 2, VoteDate: time.Now()}).Post(BASE_API + "/voters/1/polls/99")
+	assert.Nil(t, err)
+	assert.Equal(t, 404, rsp.StatusCode())
+	assert.Contains(t, rsp.String(), "poll_not_found")
 }
 
 func Test_GetAllVoters(t *testing.T) {
@@ -60,6 +93,39 @@ func Test_GetAllVoters(t *testing.T) {
 	assert.Equal(t, 1, len(items))
 }
 
+func Test_GetVotersPage(t *testing.T) {
+	var page struct {
+		Voters     []db.VoterItem `json:"voters"`
+		NextCursor string         `json:"nextCursor"`
+	}
+
+	rsp, err := cli.R().SetResult(&page).Get(BASE_API + "/voters?limit=1")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	assert.Equal(t, 1, len(page.Voters))
+	assert.Equal(t, "", page.NextCursor)
+}
+
+func Test_FilterVoters(t *testing.T) {
+	var voterList []db.VoterItem
+
+	rsp, err := cli.R().SetResult(&voterList).Get(BASE_API + "/voters?email=JANE@example.com&name_contains=smith&sort=name&order=desc")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	if assert.Len(t, voterList, 1) {
+		assert.Equal(t, 1, voterList[0].VoterId)
+	}
+
+	rsp, err = cli.R().Get(BASE_API + "/voters?sort=age")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 400, rsp.StatusCode())
+}
+
 func Test_GetSingleVoter(t *testing.T) {
 	var voterItem db.VoterItem
 
@@ -100,3 +166,2319 @@ func Test_GetVotersHealth(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, 200, rsp.StatusCode())
 }
+
+func Test_AddSuppression(t *testing.T) {
+	rsp, err := cli.R().
+		SetBody(map[string]string{"email": "Bounced@Example.com", "reason": "bounce"}).
+		Post(BASE_API + "/notifications/suppressions")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+}
+
+func Test_DeleteSuppression(t *testing.T) {
+	rsp, err := cli.R().Delete(BASE_API + "/notifications/suppressions/bounced@example.com")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+}
+
+func Test_GetVoterPollsMissingVoter(t *testing.T) {
+	rsp, err := cli.R().Get(BASE_API + "/voters/999/polls")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 404, rsp.StatusCode())
+
+	rsp, err = cli.R().Get(BASE_API + "/voters/999/polls/1")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 404, rsp.StatusCode())
+}
+
+func Test_GetVoterPollMissingPoll(t *testing.T) {
+	rsp, err := cli.R().Get(BASE_API + "/voters/1/polls/999")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 404, rsp.StatusCode())
+}
+
+func Test_GetVoterPollsEmptyHistory(t *testing.T) {
+	newVoterItem := db.VoterItem{
+		VoterId: 2,
+		Name:    "John Doe",
+		Email:   "john@example.com",
+	}
+
+	rsp, err := cli.R().SetBody(newVoterItem).Post(BASE_API + "/voters")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	rsp, err = cli.R().Get(BASE_API + "/voters/2/polls")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, "[]", string(rsp.Body()))
+
+	rsp, err = cli.R().Delete(BASE_API + "/voters/2")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+}
+
+func Test_PatchVoter(t *testing.T) {
+	var voterItem db.VoterItem
+
+	rsp, err := cli.R().SetResult(&voterItem).
+		SetHeader("Content-Type", "application/merge-patch+json").
+		SetBody(`{"name": "Jane Doe"}`).
+		Patch(BASE_API + "/voters/1")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, "Jane Doe", voterItem.Name)
+	assert.Equal(t, "jane@example.com", voterItem.Email)
+	assert.Equal(t, 1, len(voterItem.VoteHistory))
+
+	rsp, err = cli.R().
+		SetHeader("Content-Type", "application/merge-patch+json").
+		SetBody(`{"voterId": 7}`).
+		Patch(BASE_API + "/voters/1")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 422, rsp.StatusCode())
+
+	//The patched voter is held to the rules of a PUT
+	for _, body := range []string{`{"email": "garbage"}`, `{"name": null}`, `{"email": null}`} {
+		rsp, err = cli.R().
+			SetHeader("Content-Type", "application/merge-patch+json").
+			SetBody(body).
+			Patch(BASE_API + "/voters/1")
+
+		assert.Nil(t, err)
+		assert.Equal(t, 422, rsp.StatusCode(), body)
+	}
+
+	rsp, err = cli.R().
+		SetHeader("Content-Type", "application/merge-patch+json").
+		SetBody(`{"name": "Jane Smith"}`).
+		Patch(BASE_API + "/voters/1")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+}
+
+func Test_AddDuplicateVoter(t *testing.T) {
+	duplicateVoterItem := db.VoterItem{
+		VoterId: 1,
+		Name:    "Jane Smith",
+		Email:   "jane@example.com",
+	}
+
+	rsp, err := cli.R().SetBody(duplicateVoterItem).Post(BASE_API + "/voters")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 409, rsp.StatusCode())
+}
+
+func Test_UpdateMissingVoter(t *testing.T) {
+	missingVoterItem := db.VoterItem{
+		VoterId: 99,
+		Name:    "Nobody",
+		Email:   "nobody@example.com",
+	}
+
+	rsp, err := cli.R().SetBody(missingVoterItem).Put(BASE_API + "/voters/99")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 404, rsp.StatusCode())
+}
+
+func Test_UpdateVoterIdMismatch(t *testing.T) {
+	otherVoterItem := db.VoterItem{
+		VoterId: 2,
+		Name:    "Jane Smith",
+		Email:   "jane@example.com",
+	}
+
+	rsp, err := cli.R().SetBody(otherVoterItem).Put(BASE_API + "/voters/1")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 409, rsp.StatusCode())
+}
+
+func Test_AddInvalidVoter(t *testing.T) {
+	var validation struct {
+		Code   string `json:"code"`
+		Fields []struct {
+			Field  string `json:"field"`
+			Reason string `json:"reason"`
+		} `json:"fields"`
+	}
+
+	rsp, err := cli.R().
+		SetBody(`{"voterId": 0, "name": "", "email": "not-an-email"}`).
+		SetHeader("Content-Type", "application/json").
+		SetError(&validation).
+		Post(BASE_API + "/voters")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 422, rsp.StatusCode())
+	assert.Equal(t, "validation_failed", validation.Code)
+	if assert.Len(t, validation.Fields, 3) {
+		assert.Equal(t, "email", validation.Fields[0].Field)
+		assert.Equal(t, "name", validation.Fields[1].Field)
+		assert.Equal(t, "voterId", validation.Fields[2].Field)
+	}
+
+	rsp, err = cli.R().
+		SetBody(`{"voterId": "seven", "name": "Seven", "email": "seven@example.com"}`).
+		SetHeader("Content-Type", "application/json").
+		SetError(&validation).
+		Post(BASE_API + "/voters")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 422, rsp.StatusCode())
+	if assert.Len(t, validation.Fields, 1) {
+		assert.Equal(t, "voterId", validation.Fields[0].Field)
+		assert.Equal(t, "must be an integer", validation.Fields[0].Reason)
+	}
+}
+
+func Test_AddOversizedVoter(t *testing.T) {
+	var validation struct {
+		Code   string `json:"code"`
+		Fields []struct {
+			Field  string `json:"field"`
+			Reason string `json:"reason"`
+		} `json:"fields"`
+	}
+
+	rsp, err := cli.R().
+		SetBody(db.VoterItem{VoterId: 43, Name: strings.Repeat("x", db.MaxNameLength+1), Email: "long@example.com"}).
+		SetError(&validation).
+		Post(BASE_API + "/voters")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 422, rsp.StatusCode())
+	assert.Equal(t, "validation_failed", validation.Code)
+	if assert.Len(t, validation.Fields, 1) {
+		assert.Equal(t, "name", validation.Fields[0].Field)
+		assert.Equal(t, "must be at most 200 characters", validation.Fields[0].Reason)
+	}
+}
+
+func Test_IdempotentAddVoter(t *testing.T) {
+	retriedVoterItem := db.VoterItem{
+		VoterId: 42,
+		Name:    "Retry Smith",
+		Email:   "retry@example.com",
+	}
+	key := "idempotent-add-" + time.Now().Format(time.RFC3339Nano)
+
+	first, err := cli.R().SetHeader("Idempotency-Key", key).SetBody(retriedVoterItem).Post(BASE_API + "/voters")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, first.StatusCode())
+
+	//Without the key the retry would be a 409 duplicate
+	retry, err := cli.R().SetHeader("Idempotency-Key", key).SetBody(retriedVoterItem).Post(BASE_API + "/voters")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, retry.StatusCode())
+	assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, first.Body(), retry.Body())
+
+	retriedVoterItem.Name = "Someone Else"
+	reused, err := cli.R().SetHeader("Idempotency-Key", key).SetBody(retriedVoterItem).Post(BASE_API + "/voters")
+	assert.Nil(t, err)
+	assert.Equal(t, 422, reused.StatusCode())
+
+	rsp, err := cli.R().Delete(BASE_API + "/voters/42")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+}
+
+func Test_AddVoterBatch(t *testing.T) {
+	var results []struct {
+		Index   int    `json:"index"`
+		VoterId int    `json:"voterId"`
+		Status  string `json:"status"`
+	}
+
+	rsp, err := cli.R().
+		SetBody(`[
+			{"voterId": 50, "name": "Batch Smith", "email": "batch@example.com"},
+			{"voterId": 1, "name": "Jane Smith", "email": "jane@example.com"},
+			{"voterId": 51, "name": "", "email": "not-an-email"}
+		]`).
+		SetHeader("Content-Type", "application/json").
+		SetResult(&results).
+		Post(BASE_API + "/voters/batch")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	if assert.Len(t, results, 3) {
+		assert.Equal(t, "created", results[0].Status)
+		assert.Equal(t, "conflict", results[1].Status)
+		assert.Equal(t, "invalid", results[2].Status)
+	}
+
+	rsp, err = cli.R().Delete(BASE_API + "/voters/50")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+}
+
+func Test_DeleteVoterBatch(t *testing.T) {
+	var result struct {
+		Deleted     int   `json:"deleted"`
+		NotFound    int   `json:"notFound"`
+		NotFoundIds []int `json:"notFoundIds"`
+	}
+
+	rsp, err := cli.R().
+		SetBody(db.VoterItem{VoterId: 60, Name: "Delete Smith", Email: "delete@example.com"}).
+		Post(BASE_API + "/voters")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	rsp, err = cli.R().
+		SetBody([]int{60, 61}).
+		SetResult(&result).
+		Delete(BASE_API + "/voters/batch")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 1, result.Deleted)
+	assert.Equal(t, 1, result.NotFound)
+	assert.Equal(t, []int{61}, result.NotFoundIds)
+}
+
+func Test_ExportVotersCSV(t *testing.T) {
+	rsp, err := cli.R().SetHeader("Accept", "text/csv").Get(BASE_API + "/voters")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Contains(t, rsp.Header().Get("Content-Type"), "text/csv")
+
+	lines := strings.Split(strings.TrimSpace(rsp.String()), "\n")
+	if assert.NotEmpty(t, lines) {
+		assert.Equal(t, "voterId,name,email,voteCount", lines[0])
+	}
+	assert.Contains(t, lines, "1,Jane Smith,jane@example.com,1")
+}
+
+func Test_GetSingleVoterXML(t *testing.T) {
+	var voter struct {
+		XMLName     xml.Name `xml:"voter"`
+		VoterId     int      `xml:"voterId"`
+		Name        string   `xml:"name"`
+		VoteHistory []struct {
+			PollId int `xml:"pollId"`
+		} `xml:"voteHistory>poll"`
+	}
+
+	rsp, err := cli.R().SetHeader("Accept", "application/xml").Get(BASE_API + "/voters/1")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Contains(t, rsp.Header().Get("Content-Type"), "application/xml")
+	assert.Nil(t, xml.Unmarshal(rsp.Body(), &voter))
+	assert.Equal(t, 1, voter.VoterId)
+	assert.Equal(t, "Jane Smith", voter.Name)
+	assert.Equal(t, 1, len(voter.VoteHistory))
+}
+
+func Test_StreamVoters(t *testing.T) {
+	rsp, err := cli.R().SetDoNotParseResponse(true).Get(BASE_API + "/voters/stream")
+	assert.Nil(t, err)
+	defer rsp.RawBody().Close()
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, "application/x-ndjson", rsp.Header().Get("Content-Type"))
+
+	var voters []db.VoterItem
+	decoder := json.NewDecoder(rsp.RawBody())
+	for decoder.More() {
+		var voterItem db.VoterItem
+		assert.Nil(t, decoder.Decode(&voterItem))
+		voters = append(voters, voterItem)
+	}
+	if assert.Len(t, voters, 1) {
+		assert.Equal(t, 1, voters[0].VoterId)
+	}
+}
+
+func Test_StreamVoterEvents(t *testing.T) {
+	rsp, err := cli.R().
+		SetDoNotParseResponse(true).
+		SetQueryParam("types", "voter.updated").
+		Get(BASE_API + "/voters/events")
+	assert.Nil(t, err)
+	defer rsp.RawBody().Close()
+	assert.Equal(t, "text/event-stream", rsp.Header().Get("Content-Type"))
+
+	//Change a voter once the stream is listening
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		cli.R().
+			SetHeader("Content-Type", "application/merge-patch+json").
+			SetBody(`{"name": "Jane Smith"}`).
+			Patch(BASE_API + "/voters/1")
+	}()
+
+	scanner := bufio.NewScanner(rsp.RawBody())
+	var eventLine, dataLine string
+	for scanner.Scan() && dataLine == "" {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "event: ") {
+			eventLine = line
+		}
+		if strings.HasPrefix(line, "data: ") {
+			dataLine = line
+		}
+	}
+
+	assert.Equal(t, "event: voter.updated", eventLine)
+	assert.Contains(t, dataLine, `"voterId":1`)
+}
+
+func Test_SearchVoters(t *testing.T) {
+	var result struct {
+		Total  int            `json:"total"`
+		Voters []db.VoterItem `json:"voters"`
+	}
+
+	rsp, err := cli.R().SetResult(&result).Get(BASE_API + "/voters/search?q=jan&field=name")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 1, result.Total)
+	if assert.Len(t, result.Voters, 1) {
+		assert.Equal(t, 1, result.Voters[0].VoterId)
+	}
+
+	rsp, err = cli.R().Get(BASE_API + "/voters/search?q=jane&field=phone")
+	assert.Nil(t, err)
+	assert.Equal(t, 400, rsp.StatusCode())
+}
+
+func Test_HeadVoters(t *testing.T) {
+	var items []db.VoterItem
+	rsp, err := cli.R().SetResult(&items).Get(BASE_API + "/voters")
+	assert.Nil(t, err)
+	assert.Equal(t, strconv.Itoa(len(items)), rsp.Header().Get("X-Total-Count"))
+
+	rsp, err = cli.R().Head(BASE_API + "/voters")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, strconv.Itoa(len(items)), rsp.Header().Get("X-Total-Count"))
+	assert.Empty(t, rsp.Body())
+
+	rsp, err = cli.R().Head(BASE_API + "/voters/1")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	rsp, err = cli.R().Head(BASE_API + "/voters/999")
+	assert.Nil(t, err)
+	assert.Equal(t, 404, rsp.StatusCode())
+}
+
+func Test_VoterLinks(t *testing.T) {
+	var voter struct {
+		Links map[string]struct {
+			Href string `json:"href"`
+		} `json:"_links"`
+		VoteHistory []struct {
+			Links map[string]struct {
+				Href string `json:"href"`
+			} `json:"_links"`
+		} `json:"voteHistory"`
+	}
+
+	rsp, err := cli.R().SetResult(&voter).Get(BASE_API + "/api/v1/voters/1")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, "/api/v1/voters/1", voter.Links["self"].Href)
+	assert.Equal(t, "/api/v1/voters/1/polls", voter.Links["polls"].Href)
+	assert.Equal(t, "/api/v1/voters", voter.Links["collection"].Href)
+	if assert.Len(t, voter.VoteHistory, 1) {
+		assert.Equal(t, "/api/v1/voters/1/polls/1", voter.VoteHistory[0].Links["self"].Href)
+	}
+}
+
+func Test_GetRoot(t *testing.T) {
+	var root struct {
+		Links map[string]struct {
+			Href      string `json:"href"`
+			Templated bool   `json:"templated"`
+		} `json:"_links"`
+	}
+
+	rsp, err := cli.R().SetResult(&root).Get(BASE_API + "/api/v1/")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, "/api/v1/voters", root.Links["voters"].Href)
+	assert.True(t, root.Links["voter"].Templated)
+}
+
+func Test_AdminKeyCounts(t *testing.T) {
+	var counts map[string]int
+	rsp, err := cli.R().SetResult(&counts).Get(BASE_API + "/admin/keys")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Greater(t, counts["voter"], 0)
+}
+
+func Test_FeatureFlags(t *testing.T) {
+	rsp, err := cli.R().SetBody(`{"enabled": false}`).
+		SetHeader("Content-Type", "application/json").
+		Put(BASE_API + "/admin/features/webhooks")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	rsp, err = cli.R().Get(BASE_API + "/webhooks")
+	assert.Nil(t, err)
+	assert.Equal(t, 404, rsp.StatusCode())
+
+	rsp, err = cli.R().Delete(BASE_API + "/admin/features/webhooks")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	rsp, err = cli.R().Get(BASE_API + "/webhooks")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+}
+
+func Test_MaintenanceMode(t *testing.T) {
+	rsp, err := cli.R().SetBody(`{"enabled": true, "reason": "redis migration"}`).
+		SetHeader("Content-Type", "application/json").
+		Put(BASE_API + "/admin/maintenance")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	rsp, err = cli.R().
+		SetBody(db.VoterItem{VoterId: 70, Name: "Late Voter", Email: "late@example.com"}).
+		Post(BASE_API + "/voters")
+	assert.Nil(t, err)
+	assert.Equal(t, 503, rsp.StatusCode())
+
+	rsp, err = cli.R().Get(BASE_API + "/voters/1")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	rsp, err = cli.R().SetBody(`{"enabled": false}`).
+		SetHeader("Content-Type", "application/json").
+		Put(BASE_API + "/admin/maintenance")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+}
+
+func Test_TenantIsolation(t *testing.T) {
+	rsp, err := cli.R().
+		SetHeader("X-Tenant-ID", "springfield").
+		SetBody(db.VoterItem{VoterId: 1, Name: "Homer Simpson", Email: "homer@example.com"}).
+		Post(BASE_API + "/voters")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	var voterItem db.VoterItem
+	rsp, err = cli.R().SetHeader("X-Tenant-ID", "springfield").SetResult(&voterItem).Get(BASE_API + "/voters/1")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, "Homer Simpson", voterItem.Name)
+
+	//The default tenant still has its own voter 1
+	rsp, err = cli.R().SetResult(&voterItem).Get(BASE_API + "/voters/1")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, "Jane Smith", voterItem.Name)
+
+	rsp, err = cli.R().SetHeader("X-Tenant-ID", "Not A Tenant").Get(BASE_API + "/voters")
+	assert.Nil(t, err)
+	assert.Equal(t, 400, rsp.StatusCode())
+
+	rsp, err = cli.R().SetHeader("X-Tenant-ID", "springfield").Delete(BASE_API + "/voters/1")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+}
+
+func Test_PollCRUD(t *testing.T) {
+	newPoll := db.Poll{
+		PollId:   2,
+		Title:    "Mascot",
+		Question: "Which mascot should the school pick?",
+		Options: []db.PollOption{
+			{OptionId: 1, Text: "Owl"},
+			{OptionId: 2, Text: "Fox"},
+		},
+	}
+
+	rsp, err := cli.R().SetBody(newPoll).Post(BASE_API + "/polls")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	rsp, err = cli.R().SetBody(newPoll).Post(BASE_API + "/polls")
+	assert.Nil(t, err)
+	assert.Equal(t, 409, rsp.StatusCode())
+
+	var poll db.Poll
+	rsp, err = cli.R().SetResult(&poll).Get(BASE_API + "/polls/2")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, "Mascot", poll.Title)
+	assert.Len(t, poll.Options, 2)
+
+	newPoll.Options = append(newPoll.Options, db.PollOption{OptionId: 2, Text: "Bear"})
+	rsp, err = cli.R().SetBody(newPoll).Put(BASE_API + "/polls/2")
+	assert.Nil(t, err)
+	assert.Equal(t, 422, rsp.StatusCode())
+
+	newPoll.Options[2].OptionId = 3
+	rsp, err = cli.R().SetBody(newPoll).Put(BASE_API + "/polls/2")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	var pollList []db.Poll
+	rsp, err = cli.R().SetResult(&pollList).Get(BASE_API + "/polls")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	//Poll 1 is the one Test_AddSingleVoterPoll voted in
+	if assert.Len(t, pollList, 2) {
+		assert.Len(t, pollList[1].Options, 3)
+	}
+
+	rsp, err = cli.R().Delete(BASE_API + "/polls/2")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	rsp, err = cli.R().Get(BASE_API + "/polls/2")
+	assert.Nil(t, err)
+	assert.Equal(t, 404, rsp.StatusCode())
+}
+
+func Test_VoteRecording(t *testing.T) {
+	rsp, err := cli.R().SetBody(db.Poll{
+		PollId:   5,
+		Title:    "Park",
+		Question: "Where should the new park go?",
+		Options:  []db.PollOption{{OptionId: 1, Text: "Riverside"}, {OptionId: 2, Text: "Downtown"}},
+	}).Post(BASE_API + "/polls")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	//A vote has to name an existing poll and one of its options
+	rsp, err = cli.R().SetBody(db.Vote{VoteId: 50, VoterId: 1, PollId: 99, VoteValue: 1}).Post(BASE_API + "/votes")
+	assert.Nil(t, err)
+	assert.Equal(t, 422, rsp.StatusCode())
+	rsp, err = cli.R().SetBody(db.Vote{VoteId: 50, VoterId: 1, PollId: 5, VoteValue: 3}).Post(BASE_API + "/votes")
+	assert.Nil(t, err)
+	assert.Equal(t, 422, rsp.StatusCode())
+
+	newVote := db.Vote{VoteId: 50, VoterId: 1, PollId: 5, VoteValue: 2}
+
+	var vote db.Vote
+	rsp, err = cli.R().SetBody(newVote).SetResult(&vote).Post(BASE_API + "/votes")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.False(t, vote.VoteDate.IsZero())
+
+	//The vote shows up in the history of the voter
+	var voterPoll db.VoterHistory
+	rsp, err = cli.R().SetResult(&voterPoll).Get(BASE_API + "/voters/1/polls/5")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 50, voterPoll.VoteId)
+
+	//A second vote in the same poll is a conflict and is not stored
+	rsp, err = cli.R().SetBody(db.Vote{VoteId: 51, VoterId: 1, PollId: 5, VoteValue: 1}).Post(BASE_API + "/votes")
+	assert.Nil(t, err)
+	assert.Equal(t, 409, rsp.StatusCode())
+	rsp, err = cli.R().Get(BASE_API + "/votes/51")
+	assert.Nil(t, err)
+	assert.Equal(t, 404, rsp.StatusCode())
+
+	newVote.VoteValue = 1
+	rsp, err = cli.R().SetBody(newVote).SetResult(&vote).Put(BASE_API + "/votes/50")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 1, vote.VoteValue)
+
+	//The history entry lists the change as an amendment
+	rsp, err = cli.R().SetResult(&voterPoll).Get(BASE_API + "/voters/1/polls/5")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 1, voterPoll.OptionId)
+	if assert.Len(t, voterPoll.Amendments, 1) {
+		assert.Equal(t, 1, voterPoll.Amendments[0].New.OptionId)
+	}
+
+	rsp, err = cli.R().Delete(BASE_API + "/votes/50")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	rsp, err = cli.R().Get(BASE_API + "/voters/1/polls/5")
+	assert.Nil(t, err)
+	assert.Equal(t, 404, rsp.StatusCode())
+
+	rsp, err = cli.R().Delete(BASE_API + "/polls/5")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+}
+
+func Test_PollResults(t *testing.T) {
+	rsp, err := cli.R().SetBody(db.Poll{
+		PollId:   6,
+		Title:    "Bridge",
+		Question: "Should the old bridge be rebuilt?",
+		Options:  []db.PollOption{{OptionId: 1, Text: "Yes"}, {OptionId: 2, Text: "No"}},
+	}).Post(BASE_API + "/polls")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	var results db.PollResults
+	rsp, err = cli.R().SetResult(&results).Get(BASE_API + "/polls/6/results")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 0, results.TotalVotes)
+
+	//A vote clears the cached tally
+	rsp, err = cli.R().SetBody(db.Vote{VoteId: 60, VoterId: 1, PollId: 6, VoteValue: 2}).Post(BASE_API + "/votes")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	rsp, err = cli.R().SetResult(&results).Get(BASE_API + "/polls/6/results")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 1, results.TotalVotes)
+	if assert.Len(t, results.Options, 2) {
+		assert.Equal(t, 0, results.Options[0].Votes)
+		assert.Equal(t, 1, results.Options[1].Votes)
+	}
+	assert.False(t, results.Frozen)
+
+	//A vote recorded only in the history of a voter is counted too
+	rsp, err = cli.R().SetBody(db.VoterItem{VoterId: 61, Name: "History Smith", Email: "history@example.com"}).Post(BASE_API + "/voters")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	//Not before their email is verified
+	rsp, err = cli.R().SetBody(db.VoterHistory{VoteId: 61, OptionId: 1, VoteDate: time.Now()}).Post(BASE_API + "/voters/61/polls/6")
+	assert.Nil(t, err)
+	assert.Equal(t, 403, rsp.StatusCode())
+	assert.Contains(t, rsp.String(), "voter_not_verified")
+	verifyVoter(t, 61)
+	rsp, err = cli.R().SetBody(db.VoterHistory{VoteId: 61, OptionId: 1, VoteDate: time.Now()}).Post(BASE_API + "/voters/61/polls/6")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	rsp, err = cli.R().SetResult(&results).Get(BASE_API + "/polls/6/results")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 2, results.TotalVotes)
+	if assert.Len(t, results.Options, 2) {
+		assert.Equal(t, 1, results.Options[0].Votes)
+		assert.Equal(t, 1, results.Options[1].Votes)
+	}
+
+	rsp, err = cli.R().Delete(BASE_API + "/voters/61")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	rsp, err = cli.R().Get(BASE_API + "/polls/99/results")
+	assert.Nil(t, err)
+	assert.Equal(t, 404, rsp.StatusCode())
+
+	rsp, err = cli.R().Delete(BASE_API + "/votes/60")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	rsp, err = cli.R().Delete(BASE_API + "/polls/6")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+}
+
+func Test_Stats(t *testing.T) {
+	var before db.Stats
+	rsp, err := cli.R().SetResult(&before).Get(BASE_API + "/stats")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Greater(t, before.TotalVoters, 0)
+
+	rsp, err = cli.R().SetBody(db.Poll{
+		PollId:   7,
+		Title:    "Library hours",
+		Question: "Should the library open on Sundays?",
+		Options:  []db.PollOption{{OptionId: 1, Text: "Yes"}, {OptionId: 2, Text: "No"}},
+	}).Post(BASE_API + "/polls")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	rsp, err = cli.R().SetBody(db.Vote{VoteId: 70, VoterId: 1, PollId: 7, VoteValue: 1}).Post(BASE_API + "/votes")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	var after db.Stats
+	rsp, err = cli.R().SetResult(&after).Get(BASE_API + "/stats")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, before.TotalVotes+1, after.TotalVotes)
+	assert.Equal(t, 1, after.VotesPerPoll[7])
+	assert.NotNil(t, after.LastVoteAt)
+
+	rsp, err = cli.R().Delete(BASE_API + "/votes/70")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	rsp, err = cli.R().SetResult(&after).Get(BASE_API + "/stats")
+	assert.Nil(t, err)
+	assert.Equal(t, before.TotalVotes, after.TotalVotes)
+
+	rsp, err = cli.R().Delete(BASE_API + "/polls/7")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+}
+
+func Test_RegisterVoter(t *testing.T) {
+	var voter db.VoterItem
+	rsp, err := cli.R().
+		SetBody(db.VoterItem{
+			VoterId: 80, Name: "Sam Lee", Email: "sam@example.com", Status: db.VoterStatusActive,
+			Verified: true, Frozen: true, VoteHistory: []db.VoterHistory{{PollId: 1, VoteId: 800, VoteDate: time.Now()}},
+		}).
+		SetResult(&voter).
+		Post(BASE_API + "/voters/register")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, db.VoterStatusPending, voter.Status)
+	assert.False(t, voter.Verified)
+	assert.False(t, voter.Frozen)
+	assert.Empty(t, voter.VoteHistory)
+
+	//Pending voters can not vote yet
+	rsp, err = cli.R().SetBody(db.VoterHistory{VoteId: 80, VoteDate: time.Now()}).Post(BASE_API + "/voters/80/polls/1")
+	assert.Nil(t, err)
+	assert.Equal(t, 403, rsp.StatusCode())
+	assert.Contains(t, rsp.String(), "voter_not_active")
+
+	rsp, err = cli.R().SetBody(map[string]string{"status": "active"}).SetResult(&voter).Put(BASE_API + "/admin/voters/80/status")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, db.VoterStatusActive, voter.Status)
+
+	//Active voters do not go back to pending
+	rsp, err = cli.R().SetBody(map[string]string{"status": "pending"}).Put(BASE_API + "/admin/voters/80/status")
+	assert.Nil(t, err)
+	assert.Equal(t, 409, rsp.StatusCode())
+
+	rsp, err = cli.R().SetBody(db.VoterHistory{VoteId: 80, VoteDate: time.Now()}).Post(BASE_API + "/voters/80/polls/1")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	rsp, err = cli.R().Delete(BASE_API + "/voters/80")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+}
+
+func Test_VerifyVoter(t *testing.T) {
+	rsp, err := cli.R().Get(BASE_API + "/voters/verify?token=not.signed")
+	assert.Nil(t, err)
+	assert.Equal(t, 400, rsp.StatusCode())
+	assert.Contains(t, rsp.String(), "invalid_token")
+
+	//The token is only emailed, the registration response does not leak it
+	rsp, err = cli.R().
+		SetBody(db.VoterItem{VoterId: 81, Name: "Ana Ruiz", Email: "ana@example.com"}).
+		Post(BASE_API + "/voters/register")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.NotContains(t, rsp.String(), "token")
+
+	rsp, err = cli.R().Delete(BASE_API + "/voters/81")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+}
+
+func Test_ConcurrentVotesInOnePoll(t *testing.T) {
+	rsp, err := cli.R().SetBody(db.Poll{
+		PollId:   8,
+		Title:    "Crosswalk",
+		Question: "Should Main Street get a crosswalk?",
+		Options:  []db.PollOption{{OptionId: 1, Text: "Yes"}, {OptionId: 2, Text: "No"}},
+	}).Post(BASE_API + "/polls")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	//Only one of many simultaneous votes of the same voter gets in
+	const attempts = 10
+	statuses := make(chan int, attempts)
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(voteId int) {
+			defer wg.Done()
+			rsp, err := cli.R().SetBody(db.VoterHistory{VoteId: voteId, VoteDate: time.Now()}).Post(BASE_API + "/voters/1/polls/8")
+			assert.Nil(t, err)
+			statuses <- rsp.StatusCode()
+		}(800 + i)
+	}
+	wg.Wait()
+	close(statuses)
+
+	recorded := 0
+	for status := range statuses {
+		if status == 200 {
+			recorded++
+		} else {
+			assert.Equal(t, 409, status)
+		}
+	}
+	assert.Equal(t, 1, recorded)
+
+	//Replacing the voter with the poll in the history twice is rejected too
+	var voter db.VoterItem
+	rsp, err = cli.R().SetResult(&voter).Get(BASE_API + "/voters/1")
+	assert.Nil(t, err)
+	voter.VoteHistory = append(voter.VoteHistory, db.VoterHistory{PollId: 8, VoteId: 899, VoteDate: time.Now()})
+	rsp, err = cli.R().SetBody(voter).Put(BASE_API + "/voters/1")
+	assert.Nil(t, err)
+	assert.Equal(t, 409, rsp.StatusCode())
+
+	//So is a history naming a poll that does not exist, however it is sent
+	voter.VoteHistory[len(voter.VoteHistory)-1].PollId = 999
+	rsp, err = cli.R().SetBody(voter).Put(BASE_API + "/voters/1")
+	assert.Nil(t, err)
+	assert.Equal(t, 422, rsp.StatusCode())
+	rsp, err = cli.R().SetHeader("Content-Type", "application/merge-patch+json").
+		SetBody(map[string]any{"voteHistory": voter.VoteHistory}).Patch(BASE_API + "/voters/1")
+	assert.Nil(t, err)
+	assert.Equal(t, 422, rsp.StatusCode())
+
+	rsp, err = cli.R().Delete(BASE_API + "/voters/1/polls/8")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	rsp, err = cli.R().Delete(BASE_API + "/polls/8")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+}
+
+func Test_PollWindow(t *testing.T) {
+	closedAt := time.Now().Add(-time.Hour).UTC()
+	rsp, err := cli.R().SetBody(db.Poll{
+		PollId:   9,
+		Title:    "Parade",
+		Question: "Should the parade go through the old town?",
+		Options:  []db.PollOption{{OptionId: 1, Text: "Yes"}, {OptionId: 2, Text: "No"}},
+		ClosesAt: &closedAt,
+	}).Post(BASE_API + "/polls")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	var envelope struct {
+		Code string `json:"code"`
+	}
+	rsp, err = cli.R().SetError(&envelope).SetBody(db.Vote{VoteId: 90, VoterId: 1, PollId: 9, VoteValue: 1}).Post(BASE_API + "/votes")
+	assert.Nil(t, err)
+	assert.Equal(t, 403, rsp.StatusCode())
+	assert.Equal(t, "poll_closed", envelope.Code)
+
+	//An admin can still record a ballot that arrived late
+	rsp, err = cli.R().SetQueryParam("overrideWindow", "true").SetBody(db.Vote{VoteId: 90, VoterId: 1, PollId: 9, VoteValue: 1}).Post(BASE_API + "/votes")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	//A poll can not close before it opens
+	opensAt := closedAt.Add(time.Hour)
+	rsp, err = cli.R().SetBody(db.Poll{
+		PollId:   9,
+		Title:    "Parade",
+		Question: "Should the parade go through the old town?",
+		Options:  []db.PollOption{{OptionId: 1, Text: "Yes"}, {OptionId: 2, Text: "No"}},
+		OpensAt:  &opensAt,
+		ClosesAt: &closedAt,
+	}).Put(BASE_API + "/polls/9")
+	assert.Nil(t, err)
+	assert.Equal(t, 422, rsp.StatusCode())
+
+	rsp, err = cli.R().Delete(BASE_API + "/votes/90")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	rsp, err = cli.R().Delete(BASE_API + "/polls/9")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+}
+
+func Test_HistoryChoice(t *testing.T) {
+	rsp, err := cli.R().SetBody(db.Poll{
+		PollId:   10,
+		Title:    "Playground",
+		Question: "Where should the new playground go?",
+		Options:  []db.PollOption{{OptionId: 1, Text: "North park"}, {OptionId: 2, Text: "Riverside"}},
+	}).Post(BASE_API + "/polls")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	rsp, err = cli.R().SetBody(db.Vote{VoteId: 100, VoterId: 1, PollId: 10, VoteValue: 2}).Post(BASE_API + "/votes")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	//The history records what was voted and follows a changed vote
+	var history []db.VoterHistory
+	rsp, err = cli.R().SetResult(&history).Get(BASE_API + "/voters/1/polls")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 2, choiceIn(history, 10))
+
+	rsp, err = cli.R().SetBody(db.Vote{VoteId: 100, VoterId: 1, PollId: 10, VoteValue: 1}).Put(BASE_API + "/votes/100")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	rsp, err = cli.R().SetResult(&history).Get(BASE_API + "/voters/1/polls")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, choiceIn(history, 10))
+
+	rsp, err = cli.R().Delete(BASE_API + "/votes/100")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	//A choice that is not an option of the poll
+	rsp, err = cli.R().SetBody(db.VoterHistory{VoteId: 100, OptionId: 3, VoteDate: time.Now().Add(-time.Minute)}).Post(BASE_API + "/voters/1/polls/10")
+	assert.Nil(t, err)
+	assert.Equal(t, 422, rsp.StatusCode())
+
+	rsp, err = cli.R().Delete(BASE_API + "/polls/10")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+}
+
+// verifyVoter activates a voter the way an admin does, which verifies
+// their email so they can vote
+func verifyVoter(t *testing.T, voterId int) {
+	rsp, err := cli.R().SetBody(map[string]string{"status": "active"}).Put(BASE_API + "/admin/voters/" + strconv.Itoa(voterId) + "/status")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+}
+
+func choiceIn(history []db.VoterHistory, pollId int) int {
+	for _, vh := range history {
+		if vh.PollId == pollId {
+			return vh.OptionId
+		}
+	}
+	return -1
+}
+
+func Test_TurnoutBreakdown(t *testing.T) {
+	rsp, err := cli.R().SetBody(db.VoterItem{VoterId: 82, Name: "Ana Ruiz", Email: "ana@example.com", AgeBand: "120+"}).Post(BASE_API + "/voters")
+	assert.Nil(t, err)
+	assert.Equal(t, 422, rsp.StatusCode())
+
+	rsp, err = cli.R().SetBody(db.VoterItem{VoterId: 82, Name: "Ana Ruiz", Email: "ana@example.com", AgeBand: "25-34", Region: "Harbor Ward"}).Post(BASE_API + "/voters")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	var voter db.VoterItem
+	rsp, err = cli.R().SetResult(&voter).Get(BASE_API + "/voters/82")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.NotNil(t, voter.RegisteredAt)
+
+	//One voter is far below the anonymization minimum
+	var breakdown db.TurnoutBreakdown
+	rsp, err = cli.R().SetResult(&breakdown).Get(BASE_API + "/analytics/turnout?by=region")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Contains(t, breakdown.Groups, db.TurnoutGroup{Value: "Harbor Ward", Suppressed: true})
+
+	rsp, err = cli.R().Get(BASE_API + "/analytics/turnout?by=shoeSize")
+	assert.Nil(t, err)
+	assert.Equal(t, 400, rsp.StatusCode())
+
+	//The demographics can be patched, within the same limits
+	rsp, err = cli.R().SetHeader("Content-Type", "application/merge-patch+json").
+		SetBody(`{"ageBand": "35-44", "region": "Old Town"}`).SetResult(&voter).Patch(BASE_API + "/voters/82")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, "35-44", voter.AgeBand)
+	assert.Equal(t, "Old Town", voter.Region)
+	rsp, err = cli.R().SetHeader("Content-Type", "application/merge-patch+json").SetBody(`{"ageBand": "120+"}`).Patch(BASE_API + "/voters/82")
+	assert.Nil(t, err)
+	assert.Equal(t, 422, rsp.StatusCode())
+	rsp, err = cli.R().SetHeader("Content-Type", "application/merge-patch+json").SetBody(`{"region": 7}`).Patch(BASE_API + "/voters/82")
+	assert.Nil(t, err)
+	assert.Equal(t, 422, rsp.StatusCode())
+
+	rsp, err = cli.R().Delete(BASE_API + "/voters/82")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+}
+
+func Test_WriteIns(t *testing.T) {
+	rsp, err := cli.R().SetBody(db.Poll{
+		PollId:        11,
+		Title:         "Harbor master",
+		Question:      "Who should be the next harbor master?",
+		Options:       []db.PollOption{{OptionId: 1, Text: "Pat Kim"}, {OptionId: 2, Text: "Lou Berg"}},
+		AllowWriteIns: true,
+	}).Post(BASE_API + "/polls")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	rsp, err = cli.R().SetBody(db.VoterItem{VoterId: 83, Name: "Max Field", Email: "max@example.com"}).Post(BASE_API + "/voters")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	verifyVoter(t, 83)
+
+	//Poll 1 does not take write-ins
+	rsp, err = cli.R().SetBody(db.Vote{VoteId: 110, VoterId: 1, PollId: 1, WriteIn: "Jane Doe"}).Post(BASE_API + "/votes")
+	assert.Nil(t, err)
+	assert.Equal(t, 422, rsp.StatusCode())
+
+	var vote db.Vote
+	rsp, err = cli.R().SetBody(db.Vote{VoteId: 110, VoterId: 1, PollId: 11, WriteIn: "  jane   DOE "}).SetResult(&vote).Post(BASE_API + "/votes")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, "jane DOE", vote.WriteIn)
+	rsp, err = cli.R().SetBody(db.Vote{VoteId: 111, VoterId: 83, PollId: 11, WriteIn: "Jane Doe"}).Post(BASE_API + "/votes")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	//Both spellings count for the same write-in
+	var results db.PollResults
+	rsp, err = cli.R().SetResult(&results).Get(BASE_API + "/polls/11/results")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 2, results.TotalVotes)
+	assert.Equal(t, []db.WriteInResult{{Text: "Jane Doe", Votes: 2}}, results.WriteIns)
+
+	for _, path := range []string{"/votes/110", "/votes/111", "/voters/83", "/polls/11"} {
+		rsp, err = cli.R().Delete(BASE_API + path)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, rsp.StatusCode())
+	}
+}
+
+func Test_RankedChoice(t *testing.T) {
+	rsp, err := cli.R().SetBody(db.Poll{
+		PollId:   12,
+		Title:    "Town mascot",
+		Question: "Rank the mascots from best to worst",
+		Options:  []db.PollOption{{OptionId: 1, Text: "Heron"}, {OptionId: 2, Text: "Otter"}, {OptionId: 3, Text: "Badger"}},
+		Ranked:   true,
+	}).Post(BASE_API + "/polls")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	for _, id := range []int{84, 85} {
+		rsp, err = cli.R().SetBody(db.VoterItem{VoterId: id, Name: "Ranked Voter", Email: "ranked" + strconv.Itoa(id) + "@example.com"}).Post(BASE_API + "/voters")
+		assert.Nil(t, err)
+		assert.Equal(t, 200, rsp.StatusCode())
+		verifyVoter(t, id)
+	}
+
+	rsp, err = cli.R().SetBody(db.Vote{VoteId: 120, VoterId: 1, PollId: 12, Ranking: []int{1, 1}}).Post(BASE_API + "/votes")
+	assert.Nil(t, err)
+	assert.Equal(t, 422, rsp.StatusCode())
+
+	var vote db.Vote
+	rsp, err = cli.R().SetBody(db.Vote{VoteId: 120, VoterId: 1, PollId: 12, Ranking: []int{1, 3}}).SetResult(&vote).Post(BASE_API + "/votes")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 1, vote.VoteValue)
+	rsp, err = cli.R().SetBody(db.Vote{VoteId: 121, VoterId: 84, PollId: 12, Ranking: []int{2, 3}}).Post(BASE_API + "/votes")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	rsp, err = cli.R().SetBody(db.Vote{VoteId: 122, VoterId: 85, PollId: 12, Ranking: []int{3, 2}}).Post(BASE_API + "/votes")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	//A three way tie drops the Badger, whose voter prefers the Otter next
+	var results db.PollResults
+	rsp, err = cli.R().SetResult(&results).Get(BASE_API + "/polls/12/results?method=irv")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, db.MethodIRV, results.Method)
+	if assert.Len(t, results.Rounds, 2) {
+		assert.Equal(t, 3, results.Rounds[0].Eliminated)
+	}
+	assert.Equal(t, 2, results.WinnerId)
+
+	rsp, err = cli.R().Get(BASE_API + "/polls/12/results?method=borda")
+	assert.Nil(t, err)
+	assert.Equal(t, 400, rsp.StatusCode())
+
+	for _, path := range []string{"/votes/120", "/votes/121", "/votes/122", "/voters/84", "/voters/85", "/polls/12"} {
+		rsp, err = cli.R().Delete(BASE_API + path)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, rsp.StatusCode())
+	}
+}
+
+func Test_ResultsHistory(t *testing.T) {
+	rsp, err := cli.R().SetBody(db.Poll{
+		PollId:   13,
+		Title:    "Street lights",
+		Question: "Should the street lights switch to LED?",
+		Options:  []db.PollOption{{OptionId: 1, Text: "Yes"}, {OptionId: 2, Text: "No"}},
+	}).Post(BASE_API + "/polls")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	//Snapshots are taken in the background, a new poll has none yet
+	var history struct {
+		PollId    int              `json:"pollId"`
+		Snapshots []db.PollResults `json:"snapshots"`
+	}
+	rsp, err = cli.R().SetResult(&history).Get(BASE_API + "/polls/13/results/history?from=2020-01-01T00:00:00Z")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 13, history.PollId)
+	assert.NotNil(t, history.Snapshots)
+
+	rsp, err = cli.R().Get(BASE_API + "/polls/13/results/history?from=yesterday")
+	assert.Nil(t, err)
+	assert.Equal(t, 400, rsp.StatusCode())
+
+	rsp, err = cli.R().Get(BASE_API + "/polls/99/results/history")
+	assert.Nil(t, err)
+	assert.Equal(t, 404, rsp.StatusCode())
+
+	rsp, err = cli.R().Delete(BASE_API + "/polls/13")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+}
+
+func Test_MergeVoters(t *testing.T) {
+	for i, email := range []string{"Dup@Example.com", "dup@example.com"} {
+		id := 86 + i
+		rsp, err := cli.R().SetBody(db.VoterItem{VoterId: id, Name: "Dee Upton", Email: email}).Post(BASE_API + "/voters")
+		assert.Nil(t, err)
+		assert.Equal(t, 200, rsp.StatusCode())
+		verifyVoter(t, id)
+		rsp, err = cli.R().SetBody(db.VoterHistory{VoteId: id * 10, VoteDate: time.Now().Add(time.Duration(i-2) * time.Hour)}).Post(BASE_API + "/voters/" + strconv.Itoa(id) + "/polls/1")
+		assert.Nil(t, err)
+		assert.Equal(t, 200, rsp.StatusCode())
+	}
+
+	var groups []db.DuplicateGroup
+	rsp, err := cli.R().SetResult(&groups).Get(BASE_API + "/admin/voters/duplicates")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Contains(t, groups, db.DuplicateGroup{Match: db.DuplicateEmail, Value: "dup@example.com", VoterIds: []int{86, 87}})
+
+	rsp, err = cli.R().SetBody(map[string]int{"targetId": 86, "sourceId": 86}).Post(BASE_API + "/admin/voters/merge")
+	assert.Nil(t, err)
+	assert.Equal(t, 422, rsp.StatusCode())
+
+	//Both voted in poll 1, the earlier vote of voter 86 is kept
+	var result db.MergeResult
+	rsp, err = cli.R().SetBody(map[string]int{"targetId": 86, "sourceId": 87}).SetResult(&result).Post(BASE_API + "/admin/voters/merge")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, []int{870}, result.DroppedVotes)
+	if assert.Len(t, result.Voter.VoteHistory, 1) {
+		assert.Equal(t, 860, result.Voter.VoteHistory[0].VoteId)
+	}
+
+	rsp, err = cli.R().Get(BASE_API + "/voters/87")
+	assert.Nil(t, err)
+	assert.Equal(t, 404, rsp.StatusCode())
+
+	rsp, err = cli.R().Delete(BASE_API + "/voters/86")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+}
+
+func Test_Precincts(t *testing.T) {
+	rsp, err := cli.R().SetBody(db.Precinct{PrecinctId: 1, Name: "Riverside", District: "East"}).Post(BASE_API + "/precincts")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	rsp, err = cli.R().SetBody(db.VoterItem{VoterId: 88, Name: "Rita Banks", Email: "rita@example.com", PrecinctId: 1}).Post(BASE_API + "/voters")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	verifyVoter(t, 88)
+	rsp, err = cli.R().SetBody(db.VoterItem{VoterId: 89, Name: "Hal Stone", Email: "hal@example.com"}).Post(BASE_API + "/voters")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	verifyVoter(t, 89)
+
+	//Voters can only be assigned to precincts that exist
+	rsp, err = cli.R().SetBody(db.VoterItem{VoterId: 89, Name: "Hal Stone", Email: "hal@example.com", PrecinctId: 999}).Put(BASE_API + "/voters/89")
+	assert.Nil(t, err)
+	assert.Equal(t, 422, rsp.StatusCode())
+
+	var voterList []db.VoterItem
+	rsp, err = cli.R().SetResult(&voterList).Get(BASE_API + "/precincts/1/voters")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	if assert.Len(t, voterList, 1) {
+		assert.Equal(t, 88, voterList[0].VoterId)
+	}
+
+	rsp, err = cli.R().SetBody(db.Poll{
+		PollId:    14,
+		Title:     "Bridge",
+		Question:  "Should the river bridge be rebuilt?",
+		Options:   []db.PollOption{{OptionId: 1, Text: "Yes"}, {OptionId: 2, Text: "No"}},
+		Precincts: []int{1},
+	}).Post(BASE_API + "/polls")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	rsp, err = cli.R().SetBody(db.VoterHistory{VoteId: 140, VoteDate: time.Now()}).Post(BASE_API + "/voters/88/polls/14")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	var envelope struct {
+		Code string `json:"code"`
+	}
+	rsp, err = cli.R().SetError(&envelope).SetBody(db.VoterHistory{VoteId: 141, VoteDate: time.Now()}).Post(BASE_API + "/voters/89/polls/14")
+	assert.Nil(t, err)
+	assert.Equal(t, 403, rsp.StatusCode())
+	assert.Equal(t, "voter_not_eligible", envelope.Code)
+
+	//A precinct with voters can not be deleted
+	rsp, err = cli.R().Delete(BASE_API + "/precincts/1")
+	assert.Nil(t, err)
+	assert.Equal(t, 409, rsp.StatusCode())
+
+	for _, path := range []string{"/polls/14", "/voters/88", "/voters/89", "/precincts/1"} {
+		rsp, err = cli.R().Delete(BASE_API + path)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, rsp.StatusCode())
+	}
+}
+
+func Test_VoteReceipt(t *testing.T) {
+	rsp, err := cli.R().SetBody(db.VoterItem{VoterId: 90, Name: "Rae Cole", Email: "rae@example.com"}).Post(BASE_API + "/voters")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	verifyVoter(t, 90)
+
+	rsp, err = cli.R().SetBody(db.VoterHistory{VoteId: 900, VoteDate: time.Now()}).Post(BASE_API + "/voters/90/polls/1")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	hash, signature, found := strings.Cut(rsp.Header().Get("X-Vote-Receipt"), ".")
+	assert.True(t, found)
+
+	var receipt db.Receipt
+	rsp, err = cli.R().SetResult(&receipt).Get(BASE_API + "/voters/90/polls/1/receipt")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, hash, receipt.Hash)
+	assert.Equal(t, signature, receipt.Signature)
+	assert.Equal(t, receipt.Hash, receipt.ComputeHash())
+	assert.True(t, receipt.Counted)
+
+	//The receipt outlives the vote and shows it is no longer counted
+	rsp, err = cli.R().Delete(BASE_API + "/voters/90/polls/1")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	rsp, err = cli.R().SetResult(&receipt).Get(BASE_API + "/voters/90/polls/1/receipt")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.False(t, receipt.Counted)
+
+	rsp, err = cli.R().Get(BASE_API + "/voters/90/polls/2/receipt")
+	assert.Nil(t, err)
+	assert.Equal(t, 404, rsp.StatusCode())
+
+	rsp, err = cli.R().Delete(BASE_API + "/voters/90")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+}
+
+func Test_HistoryChain(t *testing.T) {
+	rsp, err := cli.R().SetBody(db.Poll{
+		PollId:   15,
+		Title:    "Library",
+		Question: "Should the library open on Sundays?",
+		Options:  []db.PollOption{{OptionId: 1, Text: "Yes"}, {OptionId: 2, Text: "No"}},
+	}).Post(BASE_API + "/polls")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	rsp, err = cli.R().SetBody(db.VoterItem{VoterId: 91, Name: "Ivy Marsh", Email: "ivy@example.com"}).Post(BASE_API + "/voters")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	verifyVoter(t, 91)
+	for i, pollId := range []string{"1", "15"} {
+		rsp, err = cli.R().SetBody(db.VoterHistory{VoteId: 910 + i, VoteDate: time.Now()}).Post(BASE_API + "/voters/91/polls/" + pollId)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, rsp.StatusCode())
+	}
+
+	var voterItem db.VoterItem
+	rsp, err = cli.R().SetResult(&voterItem).Get(BASE_API + "/voters/91")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	if assert.Len(t, voterItem.VoteHistory, 2) {
+		assert.Equal(t, "", voterItem.VoteHistory[0].PrevHash)
+		assert.Equal(t, voterItem.VoteHistory[0].Hash, voterItem.VoteHistory[1].PrevHash)
+		assert.Equal(t, voterItem.VoteHistory[1].Hash, voterItem.HistoryHash)
+	}
+
+	var result db.ChainVerification
+	rsp, err = cli.R().SetResult(&result).Get(BASE_API + "/admin/voters/91/history/verify")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.True(t, result.Valid)
+	assert.Equal(t, 2, result.Entries)
+
+	//Hashes sent by a client are replaced, the chain still verifies
+	if len(voterItem.VoteHistory) > 0 {
+		voterItem.VoteHistory[0].Hash = "forged"
+	}
+	rsp, err = cli.R().SetBody(voterItem).Put(BASE_API + "/voters/91")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	rsp, err = cli.R().SetResult(&result).Get(BASE_API + "/admin/voters/91/history/verify")
+	assert.Nil(t, err)
+	assert.True(t, result.Valid)
+
+	for _, path := range []string{"/voters/91", "/polls/15"} {
+		rsp, err = cli.R().Delete(BASE_API + path)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, rsp.StatusCode())
+	}
+}
+
+func Test_SecretBallot(t *testing.T) {
+	rsp, err := cli.R().SetBody(db.Poll{
+		PollId:    16,
+		Title:     "Council",
+		Question:  "Who should chair the council?",
+		Options:   []db.PollOption{{OptionId: 1, Text: "Ann"}, {OptionId: 2, Text: "Ben"}},
+		Anonymous: true,
+	}).Post(BASE_API + "/polls")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	rsp, err = cli.R().SetBody(db.VoterItem{VoterId: 92, Name: "Sam Reed", Email: "sam@example.com"}).Post(BASE_API + "/voters")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	verifyVoter(t, 92)
+
+	//The stored ballot does not say whose it is
+	var vote db.Vote
+	rsp, err = cli.R().SetResult(&vote).SetBody(db.Vote{VoteId: 920, VoterId: 92, PollId: 16, VoteValue: 2}).Post(BASE_API + "/votes")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 0, vote.VoterId)
+	assert.NotEmpty(t, vote.VoterToken)
+
+	//The voter only knows they voted
+	var history db.VoterHistory
+	rsp, err = cli.R().SetResult(&history).Get(BASE_API + "/voters/92/polls/16")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.True(t, history.Anonymous)
+	assert.Equal(t, 0, history.VoteId)
+	assert.Equal(t, 0, history.OptionId)
+
+	rsp, err = cli.R().SetBody(db.Vote{VoteId: 921, VoterId: 92, PollId: 16, VoteValue: 1}).Post(BASE_API + "/votes")
+	assert.Nil(t, err)
+	assert.Equal(t, 409, rsp.StatusCode())
+	rsp, err = cli.R().SetBody(db.VoterHistory{VoteId: 921, VoteDate: time.Now()}).Post(BASE_API + "/voters/92/polls/16")
+	assert.Nil(t, err)
+	assert.Equal(t, 409, rsp.StatusCode())
+	rsp, err = cli.R().SetBody(db.Vote{VoterId: 92, PollId: 16, VoteValue: 1}).Put(BASE_API + "/votes/920")
+	assert.Nil(t, err)
+	assert.Equal(t, 409, rsp.StatusCode())
+
+	var results db.PollResults
+	rsp, err = cli.R().SetResult(&results).Get(BASE_API + "/polls/16/results")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 1, results.TotalVotes)
+
+	for _, path := range []string{"/votes/920", "/voters/92", "/polls/16"} {
+		rsp, err = cli.R().Delete(BASE_API + path)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, rsp.StatusCode())
+	}
+}
+
+func Test_ProvisionalVotes(t *testing.T) {
+	rsp, err := cli.R().SetBody(db.Poll{
+		PollId:   17,
+		Title:    "Park",
+		Question: "Should the park get a playground?",
+		Options:  []db.PollOption{{OptionId: 1, Text: "Yes"}, {OptionId: 2, Text: "No"}},
+	}).Post(BASE_API + "/polls")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	rsp, err = cli.R().SetBody(db.VoterItem{VoterId: 93, Name: "Pat Vale", Email: "pat@example.com"}).Post(BASE_API + "/voters/register")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	rsp, err = cli.R().SetBody(db.VoterItem{VoterId: 94, Name: "Lou Hart", Email: "lou@example.com"}).Post(BASE_API + "/voters")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	verifyVoter(t, 94)
+
+	//A pending voter can only vote provisionally
+	rsp, err = cli.R().SetBody(db.Vote{VoteId: 930, VoterId: 93, PollId: 17, VoteValue: 1}).Post(BASE_API + "/votes")
+	assert.Nil(t, err)
+	assert.Equal(t, 403, rsp.StatusCode())
+	for _, vote := range []db.Vote{{VoteId: 930, VoterId: 93, PollId: 17, VoteValue: 1}, {VoteId: 940, VoterId: 94, PollId: 17, VoteValue: 2}} {
+		vote.Provisional = true
+		rsp, err = cli.R().SetBody(vote).Post(BASE_API + "/votes")
+		assert.Nil(t, err)
+		assert.Equal(t, 200, rsp.StatusCode())
+	}
+
+	var results db.PollResults
+	rsp, err = cli.R().SetResult(&results).Get(BASE_API + "/polls/17/results")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, results.TotalVotes)
+	assert.Equal(t, 2, results.Provisional)
+
+	var voteList []db.Vote
+	rsp, err = cli.R().SetResult(&voteList).Get(BASE_API + "/admin/votes/provisional?pollId=17")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Len(t, voteList, 2)
+
+	rsp, err = cli.R().Post(BASE_API + "/admin/votes/930/accept")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	rsp, err = cli.R().Post(BASE_API + "/admin/votes/930/accept")
+	assert.Nil(t, err)
+	assert.Equal(t, 409, rsp.StatusCode())
+	rsp, err = cli.R().Post(BASE_API + "/admin/votes/940/reject")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	rsp, err = cli.R().Get(BASE_API + "/votes/940")
+	assert.Nil(t, err)
+	assert.Equal(t, 404, rsp.StatusCode())
+
+	rsp, err = cli.R().SetResult(&results).Get(BASE_API + "/polls/17/results")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, results.TotalVotes)
+	assert.Equal(t, 0, results.Provisional)
+	if assert.NotEmpty(t, results.Options) {
+		assert.Equal(t, 1, results.Options[0].Votes)
+	}
+
+	for _, path := range []string{"/votes/930", "/voters/93", "/voters/94", "/polls/17"} {
+		rsp, err = cli.R().Delete(BASE_API + path)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, rsp.StatusCode())
+	}
+}
+
+func Test_VoterLifecycle(t *testing.T) {
+	rsp, err := cli.R().SetBody(db.VoterItem{VoterId: 95, Name: "Nell Shaw", Email: "nell@example.com"}).Post(BASE_API + "/voters")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	verifyVoter(t, 95)
+
+	var voter db.VoterItem
+	rsp, err = cli.R().SetResult(&voter).Post(BASE_API + "/admin/voters/95/suspend?reason=challenged")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, db.VoterStatusSuspended, voter.Status)
+
+	//Suspended voters can not vote
+	rsp, err = cli.R().SetBody(db.VoterHistory{VoteId: 950, VoteDate: time.Now()}).Post(BASE_API + "/voters/95/polls/1")
+	assert.Nil(t, err)
+	assert.Equal(t, 403, rsp.StatusCode())
+
+	rsp, err = cli.R().SetResult(&voter).Post(BASE_API + "/admin/voters/95/reinstate")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, db.VoterStatusActive, voter.Status)
+	rsp, err = cli.R().Post(BASE_API + "/admin/voters/95/reinstate")
+	assert.Nil(t, err)
+	assert.Equal(t, 409, rsp.StatusCode())
+
+	rsp, err = cli.R().SetResult(&voter).Post(BASE_API + "/admin/voters/95/purge")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, db.VoterStatusPurged, voter.Status)
+
+	//Purged voters are off the roll for good
+	rsp, err = cli.R().SetHeader("Accept", "text/csv").Get(BASE_API + "/voters")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.NotContains(t, rsp.String(), "nell@example.com")
+	rsp, err = cli.R().SetBody(map[string]string{"status": "active"}).Put(BASE_API + "/admin/voters/95/status")
+	assert.Nil(t, err)
+	assert.Equal(t, 409, rsp.StatusCode())
+
+	rsp, err = cli.R().Delete(BASE_API + "/voters/95")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+}
+
+func Test_VoteBatch(t *testing.T) {
+	rsp, err := cli.R().SetBody(db.Poll{
+		PollId:   18,
+		Title:    "Budget",
+		Question: "Should the budget pass?",
+		Options:  []db.PollOption{{OptionId: 1, Text: "Yes"}, {OptionId: 2, Text: "No"}},
+	}).Post(BASE_API + "/polls")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	for _, voterItem := range []db.VoterItem{{VoterId: 96, Name: "Ida Lowe", Email: "ida@example.com"}, {VoterId: 97, Name: "Max Rowe", Email: "max@example.com"}} {
+		rsp, err = cli.R().SetBody(voterItem).Post(BASE_API + "/voters")
+		assert.Nil(t, err)
+		assert.Equal(t, 200, rsp.StatusCode())
+		verifyVoter(t, voterItem.VoterId)
+	}
+
+	var batch struct {
+		Recorded int `json:"recorded"`
+		Conflict int `json:"conflict"`
+		Invalid  int `json:"invalid"`
+		Results  []struct {
+			Status  string            `json:"status"`
+			Receipt string            `json:"receipt"`
+			Errors  map[string]string `json:"errors"`
+		} `json:"results"`
+	}
+	rsp, err = cli.R().
+		SetBody(`[
+			{"voteId": 960, "voterId": 96, "voteValue": 1},
+			{"voteId": 970, "voterId": 97, "pollId": 18, "voteValue": 2},
+			{"voteId": 961, "voterId": 96, "voteValue": 2},
+			{"voteId": 971, "voterId": 97, "voteValue": 3}
+		]`).
+		SetHeader("Content-Type", "application/json").
+		SetResult(&batch).
+		Post(BASE_API + "/polls/18/votes/batch")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 2, batch.Recorded)
+	assert.Equal(t, 1, batch.Conflict)
+	assert.Equal(t, 1, batch.Invalid)
+	if assert.Len(t, batch.Results, 4) {
+		assert.Equal(t, "recorded", batch.Results[0].Status)
+		assert.NotEmpty(t, batch.Results[0].Receipt)
+		assert.Equal(t, "conflict", batch.Results[2].Status)
+		assert.Contains(t, batch.Results[3].Errors, "voteValue")
+	}
+
+	var results db.PollResults
+	rsp, err = cli.R().SetResult(&results).Get(BASE_API + "/polls/18/results")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, results.TotalVotes)
+
+	rsp, err = cli.R().SetBody(`[]`).SetHeader("Content-Type", "application/json").Post(BASE_API + "/polls/999/votes/batch")
+	assert.Nil(t, err)
+	assert.Equal(t, 404, rsp.StatusCode())
+
+	for _, path := range []string{"/votes/960", "/votes/970", "/voters/96", "/voters/97", "/polls/18"} {
+		rsp, err = cli.R().Delete(BASE_API + path)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, rsp.StatusCode())
+	}
+}
+
+func Test_PollTurnout(t *testing.T) {
+	rsp, err := cli.R().SetBody(db.Precinct{PrecinctId: 2, Name: "Hillside", District: "West"}).Post(BASE_API + "/precincts")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	rsp, err = cli.R().SetBody(db.Poll{
+		PollId:    19,
+		Title:     "Library",
+		Question:  "Should the library open on Sundays?",
+		Options:   []db.PollOption{{OptionId: 1, Text: "Yes"}, {OptionId: 2, Text: "No"}},
+		Precincts: []int{2},
+	}).Post(BASE_API + "/polls")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	for _, voterItem := range []db.VoterItem{{VoterId: 98, Name: "Cal Frey", Email: "cal@example.com", PrecinctId: 2}, {VoterId: 99, Name: "Ada Moss", Email: "ada@example.com", PrecinctId: 2}} {
+		rsp, err = cli.R().SetBody(voterItem).Post(BASE_API + "/voters")
+		assert.Nil(t, err)
+		assert.Equal(t, 200, rsp.StatusCode())
+		verifyVoter(t, voterItem.VoterId)
+	}
+	rsp, err = cli.R().SetBody(db.Vote{VoteId: 980, VoterId: 98, PollId: 19, VoteValue: 1}).Post(BASE_API + "/votes")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	var turnout db.PollTurnout
+	rsp, err = cli.R().SetResult(&turnout).Get(BASE_API + "/polls/19/turnout?interval=day")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 2, turnout.Eligible)
+	assert.Equal(t, 1, turnout.Voted)
+	assert.Equal(t, 50.0, turnout.Percentage)
+	if assert.Len(t, turnout.Series, 1) {
+		assert.Equal(t, 1, turnout.Series[0].Votes)
+	}
+
+	rsp, err = cli.R().Get(BASE_API + "/polls/19/turnout?interval=week")
+	assert.Nil(t, err)
+	assert.Equal(t, 400, rsp.StatusCode())
+	rsp, err = cli.R().Get(BASE_API + "/polls/999/turnout")
+	assert.Nil(t, err)
+	assert.Equal(t, 404, rsp.StatusCode())
+
+	for _, path := range []string{"/votes/980", "/voters/98", "/voters/99", "/polls/19", "/precincts/2"} {
+		rsp, err = cli.R().Delete(BASE_API + path)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, rsp.StatusCode())
+	}
+}
+
+func Test_Leaderboard(t *testing.T) {
+	rsp, err := cli.R().SetBody(db.Poll{
+		PollId:   20,
+		Title:    "Parking",
+		Question: "Should parking downtown be free?",
+		Options:  []db.PollOption{{OptionId: 1, Text: "Yes"}, {OptionId: 2, Text: "No"}},
+	}).Post(BASE_API + "/polls")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	rsp, err = cli.R().SetBody(db.VoterItem{VoterId: 100, Name: "Eve Park", Email: "eve@example.com"}).Post(BASE_API + "/voters")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	verifyVoter(t, 100)
+	rsp, err = cli.R().SetBody(db.Vote{VoteId: 1000, VoterId: 100, PollId: 20, VoteValue: 1}).Post(BASE_API + "/votes")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	var leaderboard []db.LeaderboardEntry
+	rsp, err = cli.R().SetResult(&leaderboard).Get(BASE_API + "/voters/leaderboard?limit=100")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	found := false
+	for i, entry := range leaderboard {
+		assert.Equal(t, i+1, entry.Rank)
+		if i > 0 {
+			assert.LessOrEqual(t, entry.Votes, leaderboard[i-1].Votes)
+		}
+		if entry.VoterId == 100 {
+			found = true
+			assert.Equal(t, "Eve Park", entry.Name)
+			assert.Equal(t, 1, entry.Votes)
+		}
+	}
+	assert.True(t, found)
+
+	rsp, err = cli.R().SetResult(&leaderboard).Get(BASE_API + "/voters/leaderboard?limit=1")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Len(t, leaderboard, 1)
+	rsp, err = cli.R().Get(BASE_API + "/voters/leaderboard?limit=0")
+	assert.Nil(t, err)
+	assert.Equal(t, 400, rsp.StatusCode())
+
+	for _, path := range []string{"/votes/1000", "/voters/100", "/polls/20"} {
+		rsp, err = cli.R().Delete(BASE_API + path)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, rsp.StatusCode())
+	}
+}
+
+func Test_VoteRate(t *testing.T) {
+	rsp, err := cli.R().SetBody(db.Poll{
+		PollId:   21,
+		Title:    "Bike lanes",
+		Question: "Should Main Street get bike lanes?",
+		Options:  []db.PollOption{{OptionId: 1, Text: "Yes"}, {OptionId: 2, Text: "No"}},
+	}).Post(BASE_API + "/polls")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	for _, voterItem := range []db.VoterItem{{VoterId: 101, Name: "Ned Cole", Email: "ned@example.com"}, {VoterId: 102, Name: "Una Bell", Email: "una@example.com"}} {
+		rsp, err = cli.R().SetBody(voterItem).Post(BASE_API + "/voters")
+		assert.Nil(t, err)
+		assert.Equal(t, 200, rsp.StatusCode())
+		verifyVoter(t, voterItem.VoterId)
+		rsp, err = cli.R().SetBody(db.Vote{VoteId: voterItem.VoterId * 10, VoterId: voterItem.VoterId, PollId: 21, VoteValue: 1}).Post(BASE_API + "/votes")
+		assert.Nil(t, err)
+		assert.Equal(t, 200, rsp.StatusCode())
+	}
+
+	var rate db.VoteRate
+	rsp, err = cli.R().SetResult(&rate).Get(BASE_API + "/polls/21/rate?interval=minute&buckets=5")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Len(t, rate.Points, 5)
+	assert.Equal(t, 2, rate.Total)
+	assert.NotNil(t, rate.Peak)
+
+	rsp, err = cli.R().Get(BASE_API + "/polls/21/rate?buckets=0")
+	assert.Nil(t, err)
+	assert.Equal(t, 400, rsp.StatusCode())
+	rsp, err = cli.R().Get(BASE_API + "/polls/21/rate?interval=day")
+	assert.Nil(t, err)
+	assert.Equal(t, 400, rsp.StatusCode())
+
+	for _, path := range []string{"/votes/1010", "/votes/1020", "/voters/101", "/voters/102", "/polls/21"} {
+		rsp, err = cli.R().Delete(BASE_API + path)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, rsp.StatusCode())
+	}
+}
+
+func Test_EmailOptOut(t *testing.T) {
+	rsp, err := cli.R().SetBody(db.VoterItem{VoterId: 103, Name: "Opal Hunt", Email: "opal@example.com"}).Post(BASE_API + "/voters")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	var voter db.VoterItem
+	rsp, err = cli.R().SetHeader("Content-Type", "application/merge-patch+json").SetBody(`{"emailOptOut": true}`).SetResult(&voter).Patch(BASE_API + "/voters/103")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.True(t, voter.EmailOptOut)
+	rsp, err = cli.R().SetHeader("Content-Type", "application/merge-patch+json").SetBody(`{"emailOptOut": "yes"}`).Patch(BASE_API + "/voters/103")
+	assert.Nil(t, err)
+	assert.Equal(t, 422, rsp.StatusCode())
+
+	rsp, err = cli.R().Delete(BASE_API + "/voters/103")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+}
+
+func Test_Jobs(t *testing.T) {
+	var statuses []jobs.Status
+	rsp, err := cli.R().SetResult(&statuses).Get(BASE_API + "/admin/jobs")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	names := []string{}
+	for _, status := range statuses {
+		names = append(names, status.Name)
+	}
+	assert.Contains(t, names, jobs.JobReindex)
+	assert.Contains(t, names, jobs.JobCleanup)
+
+	var run db.JobRun
+	rsp, err = cli.R().SetResult(&run).Post(BASE_API + "/admin/jobs/cleanup/run")
+	assert.Nil(t, err)
+	assert.Equal(t, 202, rsp.StatusCode())
+	assert.Equal(t, jobs.TriggerManual, run.Trigger)
+
+	var status jobs.Status
+	for i := 0; i < 50; i++ {
+		rsp, err = cli.R().SetResult(&status).Get(BASE_API + "/admin/jobs/cleanup")
+		assert.Nil(t, err)
+		assert.Equal(t, 200, rsp.StatusCode())
+		if status.LastRun != nil && status.LastRun.Finished != nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if assert.NotNil(t, status.LastRun) {
+		assert.Equal(t, db.JobRunSucceeded, status.LastRun.Status)
+	}
+	assert.Equal(t, "@daily", status.Schedule)
+
+	rsp, err = cli.R().Post(BASE_API + "/admin/jobs/nope/run")
+	assert.Nil(t, err)
+	assert.Equal(t, 404, rsp.StatusCode())
+}
+
+func Test_RetentionExemptPoll(t *testing.T) {
+	var poll db.Poll
+	rsp, err := cli.R().SetBody(db.Poll{
+		PollId:          22,
+		Title:           "Charter",
+		Question:        "Adopt the new charter?",
+		Options:         []db.PollOption{{OptionId: 1, Text: "Yes"}, {OptionId: 2, Text: "No"}},
+		RetentionExempt: true,
+	}).Post(BASE_API + "/polls")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	rsp, err = cli.R().SetResult(&poll).Get(BASE_API + "/polls/22")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.True(t, poll.RetentionExempt)
+
+	rsp, err = cli.R().Delete(BASE_API + "/polls/22")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+}
+
+func Test_UndoVote(t *testing.T) {
+	rsp, err := cli.R().SetBody(db.Poll{
+		PollId:   23,
+		Title:    "Mascot",
+		Question: "Which mascot should the team have?",
+		Options:  []db.PollOption{{OptionId: 1, Text: "Owl"}, {OptionId: 2, Text: "Fox"}},
+	}).Post(BASE_API + "/polls")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	//The window runs from when the vote was recorded, not from the date
+	//the client sent
+	rsp, err = cli.R().SetBody(db.Vote{VoteId: 1030, VoterId: 1, PollId: 23, VoteValue: 1, VoteDate: time.Now().Add(-time.Hour)}).Post(BASE_API + "/votes")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	//The vote was cast by mistake, the voter takes it back
+	var withdrawn db.VoterHistory
+	rsp, err = cli.R().SetResult(&withdrawn).Delete(BASE_API + "/voters/1/polls/23?undo=true")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 1030, withdrawn.VoteId)
+
+	rsp, err = cli.R().Get(BASE_API + "/votes/1030")
+	assert.Nil(t, err)
+	assert.Equal(t, 404, rsp.StatusCode())
+
+	var results db.PollResults
+	rsp, err = cli.R().SetResult(&results).Get(BASE_API + "/polls/23/results")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 0, results.TotalVotes)
+
+	//Nothing left to withdraw
+	rsp, err = cli.R().Delete(BASE_API + "/voters/1/polls/23?undo=true")
+	assert.Nil(t, err)
+	assert.Equal(t, 404, rsp.StatusCode())
+
+	//And the voter can vote again
+	rsp, err = cli.R().SetBody(db.Vote{VoteId: 1031, VoterId: 1, PollId: 23, VoteValue: 2}).Post(BASE_API + "/votes")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	rsp, err = cli.R().SetResult(&results).Get(BASE_API + "/polls/23/results")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 1, results.TotalVotes)
+	if assert.Len(t, results.Options, 2) {
+		assert.Equal(t, 1, results.Options[1].Votes)
+	}
+
+	rsp, err = cli.R().Delete(BASE_API + "/votes/1031")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	rsp, err = cli.R().Delete(BASE_API + "/polls/23")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+}
+
+func Test_AmendVoterPoll(t *testing.T) {
+	rsp, err := cli.R().SetBody(db.Poll{
+		PollId:   24,
+		Title:    "Recycling",
+		Question: "Should glass be collected weekly?",
+		Options:  []db.PollOption{{OptionId: 1, Text: "Yes"}, {OptionId: 2, Text: "No"}},
+	}).Post(BASE_API + "/polls")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	voteDate := time.Now().Add(-time.Minute)
+	rsp, err = cli.R().SetBody(db.VoterHistory{PollId: 24, VoteId: 1040, OptionId: 1, VoteDate: voteDate}).
+		Post(BASE_API + "/voters/1/polls/24")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	//Changing the vote keeps what it was, and who changed it
+	var history db.VoterHistory
+	rsp, err = cli.R().SetHeader("X-Actor", "clerk-7").SetResult(&history).
+		SetBody(db.VoterHistory{PollId: 24, VoteId: 1040, OptionId: 2, VoteDate: voteDate}).
+		Put(BASE_API + "/voters/1/polls/24")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 2, history.OptionId)
+	assert.Equal(t, 1, len(history.Amendments))
+	if len(history.Amendments) == 1 {
+		assert.Equal(t, 1, history.Amendments[0].Old.OptionId)
+		assert.Equal(t, 2, history.Amendments[0].New.OptionId)
+		assert.Equal(t, "clerk-7", history.Amendments[0].Actor)
+	}
+
+	//An update that changes nothing is no amendment, and the client can
+	//not rewrite the amendments
+	rsp, err = cli.R().SetResult(&history).
+		SetBody(db.VoterHistory{PollId: 24, VoteId: 1040, OptionId: 2, VoteDate: voteDate, Amendments: []db.Amendment{}}).
+		Put(BASE_API + "/voters/1/polls/24")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 1, len(history.Amendments))
+
+	rsp, err = cli.R().SetResult(&history).Get(BASE_API + "/voters/1/polls/24")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 1, len(history.Amendments))
+
+	//The body can not name another poll or pick an option the poll lacks
+	rsp, err = cli.R().SetBody(db.VoterHistory{PollId: 1, VoteId: 1040, OptionId: 2, VoteDate: voteDate}).Put(BASE_API + "/voters/1/polls/24")
+	assert.Nil(t, err)
+	assert.Equal(t, 400, rsp.StatusCode())
+	rsp, err = cli.R().SetBody(db.VoterHistory{PollId: 24, VoteId: 1040, OptionId: 3, VoteDate: voteDate}).Put(BASE_API + "/voters/1/polls/24")
+	assert.Nil(t, err)
+	assert.Equal(t, 422, rsp.StatusCode())
+
+	//Nor change when the vote was recorded or make it provisional
+	rsp, err = cli.R().SetResult(&history).
+		SetBody(db.VoterHistory{PollId: 24, VoteId: 1040, OptionId: 2, VoteDate: voteDate.Add(-time.Hour), Provisional: true}).
+		Put(BASE_API + "/voters/1/polls/24")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.WithinDuration(t, voteDate, history.VoteDate, time.Second)
+	assert.False(t, history.Provisional)
+	assert.Equal(t, 1, len(history.Amendments))
+
+	rsp, err = cli.R().Delete(BASE_API + "/voters/1/polls/24")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	rsp, err = cli.R().Delete(BASE_API + "/polls/24")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+}
+
+func Test_PollTags(t *testing.T) {
+	rsp, err := cli.R().SetBody(db.Poll{
+		PollId:   25,
+		Title:    "Parks",
+		Question: "Should the parks budget grow?",
+		Options:  []db.PollOption{{OptionId: 1, Text: "Yes"}, {OptionId: 2, Text: "No"}},
+		Category: "Finance",
+		Tags:     []string{" Budget", "parks", "budget"},
+	}).Post(BASE_API + "/polls")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	rsp, err = cli.R().SetBody(db.Poll{
+		PollId:   26,
+		Title:    "Library hours",
+		Question: "Should the library open on Sundays?",
+		Options:  []db.PollOption{{OptionId: 1, Text: "Yes"}, {OptionId: 2, Text: "No"}},
+		Category: "Services",
+	}).Post(BASE_API + "/polls")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	//Tags are matched ignoring case and stored once
+	var polls []db.Poll
+	rsp, err = cli.R().SetResult(&polls).Get(BASE_API + "/polls?tag=BUDGET")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 1, len(polls))
+	if len(polls) == 1 {
+		assert.Equal(t, 25, polls[0].PollId)
+		assert.Equal(t, []string{"budget", "parks"}, polls[0].Tags)
+	}
+
+	rsp, err = cli.R().SetResult(&polls).Get(BASE_API + "/polls?category=services")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 1, len(polls))
+
+	rsp, err = cli.R().SetResult(&polls).Get(BASE_API + "/polls?category=finance&tag=roads")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 0, len(polls))
+
+	//The history of a voter by category
+	for _, pollId := range []int{25, 26} {
+		rsp, err = cli.R().SetBody(db.VoterHistory{VoteId: 1040 + pollId, VoteDate: time.Now()}).
+			Post(BASE_API + "/voters/1/polls/" + strconv.Itoa(pollId))
+		assert.Nil(t, err)
+		assert.Equal(t, 200, rsp.StatusCode())
+	}
+
+	var history []db.VoterHistory
+	rsp, err = cli.R().SetResult(&history).Get(BASE_API + "/voters/1/polls?category=Finance")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 1, len(history))
+	if len(history) == 1 {
+		assert.Equal(t, 25, history[0].PollId)
+	}
+
+	for _, pollId := range []int{25, 26} {
+		rsp, err = cli.R().Delete(BASE_API + "/voters/1/polls/" + strconv.Itoa(pollId))
+		assert.Nil(t, err)
+		assert.Equal(t, 200, rsp.StatusCode())
+		rsp, err = cli.R().Delete(BASE_API + "/polls/" + strconv.Itoa(pollId))
+		assert.Nil(t, err)
+		assert.Equal(t, 200, rsp.StatusCode())
+	}
+}
+
+func Test_VoterGroups(t *testing.T) {
+	for _, voterItem := range []db.VoterItem{
+		{VoterId: 104, Name: "Rene Park", Email: "rene@city.gov"},
+		{VoterId: 105, Name: "Tess Lowe", Email: "tess@example.com"},
+	} {
+		rsp, err := cli.R().SetBody(voterItem).Post(BASE_API + "/voters")
+		assert.Nil(t, err)
+		assert.Equal(t, 200, rsp.StatusCode())
+		verifyVoter(t, voterItem.VoterId)
+	}
+
+	//A rule-based group and a manual one
+	rsp, err := cli.R().SetBody(db.VoterGroup{GroupId: 1, Name: "City staff", Rule: &db.GroupRule{EmailDomain: "City.gov"}}).
+		Post(BASE_API + "/groups")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	rsp, err = cli.R().SetBody(db.VoterGroup{GroupId: 2, Name: "Volunteers"}).Post(BASE_API + "/groups")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	rsp, err = cli.R().SetBody(db.VoterGroup{GroupId: 3, Name: "Both", Members: []int{104}, Rule: &db.GroupRule{EmailDomain: "city.gov"}}).
+		Post(BASE_API + "/groups")
+	assert.Nil(t, err)
+	assert.Equal(t, 422, rsp.StatusCode())
+
+	var group db.VoterGroup
+	rsp, err = cli.R().SetResult(&group).Put(BASE_API + "/groups/2/members/105")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, []int{105}, group.Members)
+	rsp, err = cli.R().Put(BASE_API + "/groups/1/members/105")
+	assert.Nil(t, err)
+	assert.Equal(t, 409, rsp.StatusCode())
+
+	var voters []db.VoterItem
+	rsp, err = cli.R().SetResult(&voters).Get(BASE_API + "/groups/1/voters")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 1, len(voters))
+	if len(voters) == 1 {
+		assert.Equal(t, 104, voters[0].VoterId)
+	}
+
+	//Groups filter the voter list
+	rsp, err = cli.R().SetResult(&voters).Get(BASE_API + "/voters?group=2")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 1, len(voters))
+	if len(voters) == 1 {
+		assert.Equal(t, 105, voters[0].VoterId)
+	}
+	rsp, err = cli.R().Get(BASE_API + "/voters?group=99")
+	assert.Nil(t, err)
+	assert.Equal(t, 400, rsp.StatusCode())
+
+	//And who may vote in a poll
+	rsp, err = cli.R().SetBody(db.Poll{
+		PollId:   27,
+		Title:    "Staff parking",
+		Question: "Should the staff lot be paved?",
+		Options:  []db.PollOption{{OptionId: 1, Text: "Yes"}, {OptionId: 2, Text: "No"}},
+		Groups:   []int{1},
+	}).Post(BASE_API + "/polls")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	rsp, err = cli.R().SetBody(db.Vote{VoteId: 1050, VoterId: 105, PollId: 27, VoteValue: 1}).Post(BASE_API + "/votes")
+	assert.Nil(t, err)
+	assert.Equal(t, 403, rsp.StatusCode())
+	rsp, err = cli.R().SetBody(db.Vote{VoteId: 1051, VoterId: 104, PollId: 27, VoteValue: 1}).Post(BASE_API + "/votes")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	//A group a poll is limited to stays
+	rsp, err = cli.R().Delete(BASE_API + "/groups/1")
+	assert.Nil(t, err)
+	assert.Equal(t, 409, rsp.StatusCode())
+
+	rsp, err = cli.R().Delete(BASE_API + "/votes/1051")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	rsp, err = cli.R().Delete(BASE_API + "/polls/27")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	for _, path := range []string{"/groups/1", "/groups/2", "/voters/104", "/voters/105"} {
+		rsp, err = cli.R().Delete(BASE_API + path)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, rsp.StatusCode())
+	}
+}
+
+func Test_PollOutcome(t *testing.T) {
+	rsp, err := cli.R().SetBody(db.Poll{
+		PollId:   28,
+		Title:    "Bylaws",
+		Question: "Amend the bylaws?",
+		Options:  []db.PollOption{{OptionId: 1, Text: "Yes"}, {OptionId: 2, Text: "No"}},
+		Decision: &db.DecisionRules{MinVotes: 1, ThresholdPercent: 66.66, PassOptionId: 1},
+	}).Post(BASE_API + "/polls")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	//No votes yet, the poll is still open
+	var results db.PollResults
+	rsp, err = cli.R().SetResult(&results).Get(BASE_API + "/polls/28/results")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	if assert.NotNil(t, results.Outcome) {
+		assert.Equal(t, db.OutcomeInconclusive, results.Outcome.Status)
+		assert.False(t, results.Outcome.Final)
+	}
+
+	rsp, err = cli.R().SetBody(db.Vote{VoteId: 1060, VoterId: 1, PollId: 28, VoteValue: 1}).Post(BASE_API + "/votes")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	results = db.PollResults{}
+	rsp, err = cli.R().SetResult(&results).Get(BASE_API + "/polls/28/results")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	if assert.NotNil(t, results.Outcome) {
+		assert.Equal(t, db.OutcomePassed, results.Outcome.Status)
+		assert.Equal(t, 1, results.Outcome.OptionId)
+	}
+
+	//The option that carries the poll has to be one of its options
+	rsp, err = cli.R().SetBody(db.Poll{
+		PollId:   29,
+		Title:    "Bylaws",
+		Question: "Amend the bylaws again?",
+		Options:  []db.PollOption{{OptionId: 1, Text: "Yes"}, {OptionId: 2, Text: "No"}},
+		Decision: &db.DecisionRules{PassOptionId: 3},
+	}).Post(BASE_API + "/polls")
+	assert.Nil(t, err)
+	assert.Equal(t, 422, rsp.StatusCode())
+
+	rsp, err = cli.R().Delete(BASE_API + "/votes/1060")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	rsp, err = cli.R().Delete(BASE_API + "/polls/28")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+}
+
+func Test_WeightedVoting(t *testing.T) {
+	rsp, err := cli.R().SetBody(db.VoterItem{VoterId: 106, Name: "Ida Marsh", Email: "ida@example.com"}).Post(BASE_API + "/voters")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	verifyVoter(t, 106)
+
+	var voter db.VoterItem
+	rsp, err = cli.R().SetBody(map[string]float64{"weight": 250}).SetResult(&voter).Put(BASE_API + "/admin/voters/106/weight")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 250.0, voter.Weight)
+	rsp, err = cli.R().SetBody(map[string]float64{"weight": -1}).Put(BASE_API + "/admin/voters/106/weight")
+	assert.Nil(t, err)
+	assert.Equal(t, 422, rsp.StatusCode())
+
+	//Weighted polls can not be ranked
+	rsp, err = cli.R().SetBody(db.Poll{
+		PollId:   30,
+		Title:    "Merger",
+		Question: "Approve the merger?",
+		Options:  []db.PollOption{{OptionId: 1, Text: "Yes"}, {OptionId: 2, Text: "No"}},
+		Ranked:   true,
+		Weighted: true,
+	}).Post(BASE_API + "/polls")
+	assert.Nil(t, err)
+	assert.Equal(t, 422, rsp.StatusCode())
+	rsp, err = cli.R().SetBody(db.Poll{
+		PollId:   30,
+		Title:    "Merger",
+		Question: "Approve the merger?",
+		Options:  []db.PollOption{{OptionId: 1, Text: "Yes"}, {OptionId: 2, Text: "No"}},
+		Weighted: true,
+	}).Post(BASE_API + "/polls")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	var vote db.Vote
+	rsp, err = cli.R().SetBody(db.Vote{VoteId: 1070, VoterId: 106, PollId: 30, VoteValue: 1}).SetResult(&vote).Post(BASE_API + "/votes")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 250.0, vote.Weight)
+
+	var results db.PollResults
+	rsp, err = cli.R().SetResult(&results).Get(BASE_API + "/polls/30/results")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.True(t, results.Weighted)
+	assert.Equal(t, 250.0, results.TotalWeight)
+	for _, option := range results.Options {
+		if option.OptionId == 1 {
+			assert.Equal(t, 1, option.Votes)
+			assert.Equal(t, 250.0, option.Weight)
+		}
+	}
+
+	rsp, err = cli.R().Delete(BASE_API + "/votes/1070")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	rsp, err = cli.R().Delete(BASE_API + "/polls/30")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	rsp, err = cli.R().Delete(BASE_API + "/voters/106")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+}
+
+func Test_DelegatedVoting(t *testing.T) {
+	for _, voterItem := range []db.VoterItem{
+		{VoterId: 107, Name: "Abe Stone", Email: "abe@example.com"},
+		{VoterId: 108, Name: "Bo Hart", Email: "bo@example.com"},
+		{VoterId: 109, Name: "Cy Lund", Email: "cy@example.com"},
+	} {
+		rsp, err := cli.R().SetBody(voterItem).Post(BASE_API + "/voters")
+		assert.Nil(t, err)
+		assert.Equal(t, 200, rsp.StatusCode())
+		verifyVoter(t, voterItem.VoterId)
+	}
+	rsp, err := cli.R().SetBody(db.Poll{
+		PollId:   31,
+		Title:    "Board seat",
+		Question: "Who takes the open seat?",
+		Options:  []db.PollOption{{OptionId: 1, Text: "Ana"}, {OptionId: 2, Text: "Ben"}},
+		Category: "Board",
+	}).Post(BASE_API + "/polls")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	//107 hands the poll to 108, who hands every board poll to 109
+	rsp, err = cli.R().SetBody(db.Delegation{DelegationId: 1, VoterId: 107, DelegateId: 108, PollId: 31}).Post(BASE_API + "/delegations")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	rsp, err = cli.R().SetBody(db.Delegation{DelegationId: 2, VoterId: 108, DelegateId: 109, Category: "board"}).Post(BASE_API + "/delegations")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	rsp, err = cli.R().SetBody(db.Delegation{DelegationId: 3, VoterId: 107, DelegateId: 109, PollId: 31}).Post(BASE_API + "/delegations")
+	assert.Nil(t, err)
+	assert.Equal(t, 409, rsp.StatusCode())
+	rsp, err = cli.R().SetBody(db.Delegation{DelegationId: 3, VoterId: 107, DelegateId: 107, PollId: 31}).Post(BASE_API + "/delegations")
+	assert.Nil(t, err)
+	assert.Equal(t, 422, rsp.StatusCode())
+
+	var delegations []db.Delegation
+	rsp, err = cli.R().SetResult(&delegations).Get(BASE_API + "/delegations?delegateId=109")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 1, len(delegations))
+
+	rsp, err = cli.R().SetBody(db.Vote{VoteId: 1080, VoterId: 109, PollId: 31, VoteValue: 1}).Post(BASE_API + "/votes")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	var results db.PollResults
+	rsp, err = cli.R().SetResult(&results).Get(BASE_API + "/polls/31/results")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 3, results.TotalVotes)
+	assert.Equal(t, 2, results.Delegated)
+	if len(results.Options) == 2 {
+		assert.Equal(t, 3, results.Options[0].Votes)
+	}
+
+	//108 handing the poll back to 107 closes a loop, neither is counted
+	rsp, err = cli.R().SetBody(db.Delegation{VoterId: 108, DelegateId: 107, Category: "board"}).Put(BASE_API + "/delegations/2")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	results = db.PollResults{}
+	rsp, err = cli.R().SetResult(&results).Get(BASE_API + "/polls/31/results")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 1, results.TotalVotes)
+	assert.Equal(t, 0, results.Delegated)
+	assert.Equal(t, 2, results.DelegationCycles)
+
+	for _, path := range []string{"/delegations/1", "/delegations/2", "/votes/1080", "/polls/31", "/voters/107", "/voters/108", "/voters/109"} {
+		rsp, err = cli.R().Delete(BASE_API + path)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, rsp.StatusCode())
+	}
+}
+
+func Test_UnsignedBounce(t *testing.T) {
+	//Without the provider signature nobody can suppress an address
+	rsp, err := cli.R().SetBody(map[string]string{"email": "alice@example.com", "type": "hard_bounce"}).
+		Post(BASE_API + "/notifications/bounces")
+	assert.Nil(t, err)
+	assert.Equal(t, 401, rsp.StatusCode())
+}
+
+func Test_APIKeyNeedsAuth(t *testing.T) {
+	//The test server runs without authentication, a key issued now would
+	//never be checked
+	rsp, err := cli.R().SetBody(map[string]any{"name": "tally", "scopes": []string{"votes:write"}}).
+		Post(BASE_API + "/admin/apikeys")
+	assert.Nil(t, err)
+	assert.Equal(t, 409, rsp.StatusCode())
+}
@@ -0,0 +1,176 @@
+package tests
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adllev/Voter-Container/voter-api/auth"
+	"github.com/adllev/Voter-Container/voter-api/db"
+	"github.com/adllev/Voter-Container/voter-api/webhooks"
+	"github.com/stretchr/testify/assert"
+)
+
+// webhookStore is an in memory webhooks.Store, so deliveries can be tested
+// without redis.  The workers of the dispatcher write to it concurrently
+type webhookStore struct {
+	mu          sync.Mutex
+	webhooks    []db.Webhook
+	retries     []db.WebhookDelivery
+	deadLetters []db.WebhookDelivery
+}
+
+func (s *webhookStore) GetWebhook(id string) (db.Webhook, error) {
+	for _, webhook := range s.webhooks {
+		if webhook.Id == id {
+			return webhook, nil
+		}
+	}
+	return db.Webhook{}, db.ErrNotFound
+}
+
+func (s *webhookStore) GetAllWebhooks() ([]db.Webhook, error) { return s.webhooks, nil }
+func (s *webhookStore) EnsureEventGroup(string) error         { return nil }
+func (s *webhookStore) AckEvents(string, ...string) error     { return nil }
+
+func (s *webhookStore) ReadEventGroup(string, string, string, time.Duration) ([]db.EventEntry, error) {
+	return nil, nil
+}
+
+func (s *webhookStore) ScheduleWebhookRetry(delivery db.WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.retries = append(s.retries, delivery)
+	return nil
+}
+
+func (s *webhookStore) PopDueWebhookRetries(time.Time) ([]db.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	due := s.retries
+	s.retries = nil
+	return due, nil
+}
+
+func (s *webhookStore) AddWebhookDeadLetter(delivery db.WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deadLetters = append(s.deadLetters, delivery)
+	return nil
+}
+
+func Test_WebhookDeliveryIsSigned(t *testing.T) {
+	var event, signature string
+	var body []byte
+	var timestamp int64
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event = r.Header.Get(webhooks.EventHeader)
+		signature = r.Header.Get(webhooks.SignatureHeader)
+		timestamp, _ = strconv.ParseInt(r.Header.Get(webhooks.TimestampHeader), 10, 64)
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer receiver.Close()
+
+	store := &webhookStore{webhooks: []db.Webhook{
+		{Id: "votes", URL: receiver.URL, Events: []string{db.EventVoteRecorded}, Secret: "whsec_test"},
+	}}
+	dispatcher := webhooks.NewDispatcher(store)
+	defer dispatcher.Close()
+
+	//Not subscribed, nothing is sent
+	assert.Nil(t, dispatcher.Dispatch(db.Event{Type: db.EventVoterCreated, VoterId: 1}))
+	dispatcher.Wait()
+	assert.Empty(t, event)
+
+	assert.Nil(t, dispatcher.Dispatch(db.Event{Type: db.EventVoteRecorded, VoterId: 1, PollId: 2}))
+	dispatcher.Wait()
+	assert.Equal(t, db.EventVoteRecorded, event)
+	assert.Contains(t, string(body), `"pollId":2`)
+	assert.Equal(t, webhooks.Sign("whsec_test", timestamp, body), signature)
+	assert.Empty(t, store.retries)
+}
+
+func Test_WebhookDeliveryRetries(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+
+	store := &webhookStore{webhooks: []db.Webhook{{Id: "all", URL: receiver.URL, Secret: "whsec_test"}}}
+	dispatcher := webhooks.NewDispatcher(store)
+	defer dispatcher.Close()
+
+	assert.Nil(t, dispatcher.Dispatch(db.Event{Type: db.EventVoterCreated, VoterId: 1}))
+	dispatcher.Wait()
+	assert.Equal(t, 1, len(store.retries))
+	assert.Equal(t, 1, store.retries[0].Attempts)
+
+	//Every retry fails too, until the delivery is dead-lettered
+	for i := 1; i < webhooks.DefaultMaxAttempts; i++ {
+		assert.Nil(t, dispatcher.ProcessRetries())
+		dispatcher.Wait()
+	}
+	assert.Empty(t, store.retries)
+	assert.Equal(t, 1, len(store.deadLetters))
+	assert.Equal(t, webhooks.DefaultMaxAttempts, store.deadLetters[0].Attempts)
+}
+
+func Test_WebhookSlowReceiver(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	delivered := make(chan struct{}, 1)
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delivered <- struct{}{}
+	}))
+	defer fast.Close()
+
+	store := &webhookStore{webhooks: []db.Webhook{
+		{Id: "slow", URL: slow.URL, Secret: "whsec_test"},
+		{Id: "fast", URL: fast.URL, Secret: "whsec_test"},
+	}}
+	dispatcher := webhooks.NewDispatcher(store)
+	defer dispatcher.Close()
+
+	//The slow receiver does not hold up Dispatch or the other webhook
+	assert.Nil(t, dispatcher.Dispatch(db.Event{Type: db.EventVoterCreated, VoterId: 1}))
+	select {
+	case <-delivered:
+	case <-time.After(time.Second):
+		t.Fatal("the fast webhook waited for the slow one")
+	}
+
+	close(release)
+	dispatcher.Wait()
+	assert.Empty(t, store.retries)
+}
+
+func Test_WebhookTenant(t *testing.T) {
+	var events []string
+	var mu sync.Mutex
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, r.URL.Path)
+	}))
+	defer receiver.Close()
+
+	store := &webhookStore{webhooks: []db.Webhook{
+		{Id: "acme", URL: receiver.URL + "/acme", Secret: "whsec_test", Tenant: "acme"},
+		{Id: "default", URL: receiver.URL + "/default", Secret: "whsec_test"},
+		{Id: "all", URL: receiver.URL + "/all", Secret: "whsec_test", Tenant: auth.AnyTenant},
+	}}
+	dispatcher := webhooks.NewDispatcher(store)
+	defer dispatcher.Close()
+
+	//A webhook only hears about the tenant it was registered for
+	assert.Nil(t, dispatcher.Dispatch(db.Event{Type: db.EventVoterCreated, VoterId: 1, Tenant: "globex"}))
+	dispatcher.Wait()
+	assert.Equal(t, []string{"/all"}, events)
+}
@@ -0,0 +1,29 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/adllev/Voter-Container/voter-api/api"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_WebSocketRequiresUpgrade(t *testing.T) {
+	app := fiber.New()
+	app.Get("/ws", api.WebSocketUpgrade, func(c *fiber.Ctx) error {
+		return c.SendString("upgraded")
+	})
+
+	rsp, err := app.Test(httptest.NewRequest(http.MethodGet, "/ws", nil))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusUpgradeRequired, rsp.StatusCode)
+
+	req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	rsp, err = app.Test(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+}
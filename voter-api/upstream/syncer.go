@@ -0,0 +1,182 @@
+package upstream
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adllev/Voter-Container/voter-api/db"
+)
+
+// FetchTimeout bounds how long the registration system may take to send
+// its voter list
+const FetchTimeout = time.Minute
+
+// MIMETextCSV is the content type of a CSV voter list
+const MIMETextCSV = "text/csv"
+
+// Store is where the syncer reconciles the voters and keeps its summary.
+// The db package implements it
+type Store interface {
+	ReconcileVoters(upstream []db.VoterItem) (db.SyncSummary, error)
+	SaveSyncSummary(summary db.SyncSummary) error
+	AppendAudit(entry db.AuditEntry) error
+}
+
+// Syncer pulls the voter roll from an upstream registration system and
+// reconciles the stored voters with it, see db.ReconcileVoters.  The
+// upstream answers GET url with either a JSON array of voters or a CSV
+// file with a header row naming the voterId, name, email and optionally
+// ageBand and region columns, so the voter export of another deployment
+// works as a source too
+type Syncer struct {
+	store  Store
+	url    string
+	client *http.Client
+}
+
+// NewSyncer is a constructor function that returns a pointer to a new
+// Syncer, nil when there is no url to sync from
+func NewSyncer(store Store, url string) *Syncer {
+	if url == "" {
+		return nil
+	}
+	return &Syncer{
+		store:  store,
+		url:    url,
+		client: &http.Client{Timeout: FetchTimeout},
+	}
+}
+
+// Sync fetches the upstream list once, reconciles it and saves the summary.
+// Records that could not be parsed are in the Errors of the summary
+func (s *Syncer) Sync(ctx context.Context) (db.SyncSummary, error) {
+	voterList, parseErrors, err := s.fetch(ctx)
+	if err != nil {
+		return db.SyncSummary{}, err
+	}
+
+	summary, err := s.store.ReconcileVoters(voterList)
+	if err != nil {
+		return db.SyncSummary{}, err
+	}
+	summary.Source = s.url
+	summary.Errors = append(parseErrors, summary.Errors...)
+
+	if err := s.store.SaveSyncSummary(summary); err != nil {
+		return summary, err
+	}
+	detail := fmt.Sprintf("created=%d updated=%d deactivated=%d reactivated=%d errors=%d",
+		len(summary.Created), len(summary.Updated), len(summary.Deactivated), len(summary.Reactivated), len(summary.Errors))
+	if err := s.store.AppendAudit(db.AuditEntry{Action: "voters.synced", Actor: "sync", Detail: detail}); err != nil {
+		slog.Error("Error writing audit log", "error", err)
+	}
+	return summary, nil
+}
+
+// fetch downloads and parses the upstream list
+func (s *Syncer) fetch(ctx context.Context) ([]db.VoterItem, []string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Accept", "application/json, "+MIMETextCSV)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("upstream answered %d", resp.StatusCode)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == MIMETextCSV {
+		return parseCSV(resp.Body)
+	}
+
+	var voterList []db.VoterItem
+	if err := json.NewDecoder(resp.Body).Decode(&voterList); err != nil {
+		return nil, nil, fmt.Errorf("decoding upstream voters: %w", err)
+	}
+	return checkRecords(voterList)
+}
+
+// parseCSV reads a CSV voter list by the names in its header row, columns
+// it does not know are ignored
+func parseCSV(r io.Reader) ([]db.VoterItem, []string, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading upstream header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, required := range []string{"voterId", "name", "email"} {
+		if _, found := columns[required]; !found {
+			return nil, nil, fmt.Errorf("upstream CSV has no %s column", required)
+		}
+	}
+
+	var voterList []db.VoterItem
+	var parseErrors []string
+	for line := 2; ; line++ {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading upstream line %d: %w", line, err)
+		}
+		field := func(name string) string {
+			i, found := columns[name]
+			if !found || i >= len(row) {
+				return ""
+			}
+			return strings.TrimSpace(row[i])
+		}
+
+		id, err := strconv.Atoi(field("voterId"))
+		if err != nil {
+			parseErrors = append(parseErrors, fmt.Sprintf("line %d: invalid voterId %q", line, field("voterId")))
+			continue
+		}
+		voterList = append(voterList, db.VoterItem{
+			VoterId: id,
+			Name:    field("name"),
+			Email:   field("email"),
+			AgeBand: field("ageBand"),
+			Region:  field("region"),
+		})
+	}
+
+	valid, invalid, err := checkRecords(voterList)
+	return valid, append(parseErrors, invalid...), err
+}
+
+// checkRecords drops the records without the fields every voter needs
+func checkRecords(voterList []db.VoterItem) ([]db.VoterItem, []string, error) {
+	var valid []db.VoterItem
+	var parseErrors []string
+	for _, voterItem := range voterList {
+		if voterItem.VoterId <= 0 || voterItem.Name == "" || voterItem.Email == "" {
+			parseErrors = append(parseErrors, fmt.Sprintf("voter %d: voterId, name and email are required", voterItem.VoterId))
+			continue
+		}
+		valid = append(valid, voterItem)
+	}
+	return valid, parseErrors, nil
+}
@@ -0,0 +1,443 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/adllev/Voter-Container/voter-api/auth"
+	"github.com/adllev/Voter-Container/voter-api/db"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+const (
+	// DefaultMaxAttempts is how many times a delivery is tried before it
+	// is moved to the dead-letter list
+	DefaultMaxAttempts = 6
+	// DefaultRetryBaseDelay is the delay before the first retry, every
+	// retry after that doubles it
+	DefaultRetryBaseDelay = 30 * time.Second
+	// DeliveryTimeout bounds how long a receiver may take to answer
+	DeliveryTimeout = 10 * time.Second
+	// WebhookWorkers is how many deliveries to one webhook are sent at
+	// the same time
+	WebhookWorkers = 4
+	// WebhookQueueSize is how many deliveries wait for the workers of a
+	// webhook, the ones after that go on the retry queue
+	WebhookQueueSize = 100
+
+	// EventGroup is the consumer group the dispatchers read the event
+	// stream with, so each event is delivered by one replica only
+	EventGroup = "webhooks"
+
+	// SignatureHeader carries the hex HMAC-SHA256 of "timestamp.body"
+	// with the webhook secret, prefixed with sha256=
+	SignatureHeader = "X-Webhook-Signature"
+	// TimestampHeader is the unix time the delivery was signed, receivers
+	// should reject old ones so a captured delivery can not be replayed
+	TimestampHeader = "X-Webhook-Timestamp"
+	// EventHeader is the event type of the delivery
+	EventHeader = "X-Webhook-Event"
+	// DeliveryHeader is the delivery id, it stays the same across retries
+	// so receivers can ignore duplicates
+	DeliveryHeader = "X-Webhook-Delivery"
+)
+
+// Events are the event types a webhook can subscribe to
+var Events = []string{
+	db.EventVoterCreated,
+	db.EventVoterUpdated,
+	db.EventVoterDeleted,
+	db.EventVoteRecorded,
+	db.EventVoteWithdrawn,
+	db.EventVoterCheckedIn,
+	db.EventPollReminder,
+}
+
+// ValidEvent reports whether an event type can be subscribed to
+func ValidEvent(event string) bool {
+	for _, known := range Events {
+		if event == known {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrQueueFull is the failure recorded for a delivery that found the
+// queue of its webhook full, the receiver is not keeping up
+var ErrQueueFull = errors.New("webhook delivery queue is full")
+
+// Payload is the JSON body POSTed to a webhook
+type Payload struct {
+	Id    string    `json:"id"`
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+	Data  db.Event  `json:"data"`
+}
+
+// Store is where the dispatcher finds the webhooks, reads the change feed
+// and parks failed deliveries.  The db package implements it
+type Store interface {
+	GetWebhook(id string) (db.Webhook, error)
+	GetAllWebhooks() ([]db.Webhook, error)
+	EnsureEventGroup(group string) error
+	ReadEventGroup(group string, consumer string, id string, block time.Duration) ([]db.EventEntry, error)
+	AckEvents(group string, ids ...string) error
+	ScheduleWebhookRetry(delivery db.WebhookDelivery) error
+	PopDueWebhookRetries(now time.Time) ([]db.WebhookDelivery, error)
+	AddWebhookDeadLetter(delivery db.WebhookDelivery) error
+}
+
+// Dispatcher reads the change feed and delivers every event to the
+// webhooks subscribed to it.  Failed deliveries are retried with a
+// backoff and dead-lettered when they run out of attempts, like the
+// notifications Dispatcher does for messages.  Every webhook has its own
+// WebhookWorkers sending its deliveries, so a slow receiver only holds up
+// itself and never the event stream
+type Dispatcher struct {
+	store       Store
+	client      *http.Client
+	consumer    string
+	maxAttempts int
+	baseDelay   time.Duration
+
+	mu      sync.Mutex
+	queues  map[string]chan job
+	pending sync.WaitGroup
+	workers sync.WaitGroup
+}
+
+// job is a delivery waiting for a worker of its webhook, batch is done
+// when it was sent or is on the retry queue
+type job struct {
+	webhook  db.Webhook
+	delivery db.WebhookDelivery
+	batch    *sync.WaitGroup
+}
+
+// NewDispatcher is a constructor function that returns a pointer to a new
+// Dispatcher.  The consumer name identifies this replica in the event
+// group, it is the host name and process id
+func NewDispatcher(store Store) *Dispatcher {
+	host, _ := os.Hostname()
+	return &Dispatcher{
+		store:       store,
+		client:      &http.Client{Timeout: DeliveryTimeout},
+		consumer:    fmt.Sprintf("%s-%d", host, os.Getpid()),
+		maxAttempts: DefaultMaxAttempts,
+		baseDelay:   DefaultRetryBaseDelay,
+		queues:      make(map[string]chan job),
+	}
+}
+
+// Sign returns the signature header value for a body, receivers compute
+// the same with their copy of the secret and compare
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// subscribed reports whether a webhook wants an event, only the events of
+// its own tenant are sent to it
+func subscribed(webhook db.Webhook, event db.Event) bool {
+	if webhook.Tenant != auth.AnyTenant && webhook.Tenant != event.Tenant {
+		return false
+	}
+	if len(webhook.Events) == 0 {
+		return true
+	}
+	for _, wanted := range webhook.Events {
+		if wanted == event.Type {
+			return true
+		}
+	}
+	return false
+}
+
+// Dispatch hands one event to the workers of every webhook subscribed to
+// it, see Wait for when they are done
+func (d *Dispatcher) Dispatch(event db.Event) error {
+	return d.dispatch(event, nil)
+}
+
+// dispatch is Dispatch, batch is waiting for the deliveries it queues
+func (d *Dispatcher) dispatch(event db.Event, batch *sync.WaitGroup) error {
+	webhookList, err := d.store.GetAllWebhooks()
+	if err != nil {
+		return err
+	}
+	d.prune(webhookList)
+
+	for _, webhook := range webhookList {
+		if !subscribed(webhook, event) {
+			continue
+		}
+
+		payload := Payload{Id: utils.UUIDv4(), Event: event.Type, Time: event.Time, Data: event}
+		payloadBytes, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+
+		delivery := db.WebhookDelivery{
+			Id:        payload.Id,
+			WebhookId: webhook.Id,
+			Event:     event.Type,
+			Payload:   payloadBytes,
+		}
+		d.enqueue(webhook, delivery, batch)
+	}
+
+	return nil
+}
+
+// enqueue hands a delivery to the workers of its webhook, starting them
+// for the first delivery.  It never waits, a delivery the queue has no
+// room for fails with ErrQueueFull and is retried later
+func (d *Dispatcher) enqueue(webhook db.Webhook, delivery db.WebhookDelivery, batch *sync.WaitGroup) {
+	d.mu.Lock()
+	jobs, found := d.queues[webhook.Id]
+	if !found {
+		jobs = make(chan job, WebhookQueueSize)
+		d.queues[webhook.Id] = jobs
+		for i := 0; i < WebhookWorkers; i++ {
+			d.workers.Add(1)
+			go d.work(jobs)
+		}
+	}
+
+	d.pending.Add(1)
+	if batch != nil {
+		batch.Add(1)
+	}
+	select {
+	case jobs <- job{webhook: webhook, delivery: delivery, batch: batch}:
+		d.mu.Unlock()
+		return
+	default:
+	}
+	d.mu.Unlock()
+	d.pending.Done()
+	if batch != nil {
+		batch.Done()
+	}
+
+	slog.Warn("Webhook queue is full", "id", delivery.Id, "webhookId", webhook.Id)
+	d.retry(delivery, ErrQueueFull)
+}
+
+// work sends the deliveries of one webhook until its queue is closed
+func (d *Dispatcher) work(jobs <-chan job) {
+	defer d.workers.Done()
+	for j := range jobs {
+		if err := d.send(j.webhook, j.delivery); err != nil {
+			d.retry(j.delivery, err)
+		}
+		if j.batch != nil {
+			j.batch.Done()
+		}
+		d.pending.Done()
+	}
+}
+
+// prune stops the workers of webhooks that were deleted, what is still in
+// their queue is sent first
+func (d *Dispatcher) prune(webhookList []db.Webhook) {
+	known := make(map[string]bool, len(webhookList))
+	for _, webhook := range webhookList {
+		known[webhook.Id] = true
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for id, jobs := range d.queues {
+		if !known[id] {
+			close(jobs)
+			delete(d.queues, id)
+		}
+	}
+}
+
+// Wait blocks until every delivery handed to the workers so far was sent
+// or is on the retry queue
+func (d *Dispatcher) Wait() {
+	d.pending.Wait()
+}
+
+// Close stops the workers once they sent what is in their queues
+func (d *Dispatcher) Close() {
+	d.mu.Lock()
+	for id, jobs := range d.queues {
+		close(jobs)
+		delete(d.queues, id)
+	}
+	d.mu.Unlock()
+	d.workers.Wait()
+}
+
+// send POSTs a signed delivery, anything but a 2xx answer is a failure
+func (d *Dispatcher) send(webhook db.Webhook, delivery db.WebhookDelivery) error {
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return err
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "voter-api-webhooks")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, delivery.Id)
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(webhook.Secret, timestamp, delivery.Payload))
+
+	rsp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	rsp.Body.Close()
+
+	if rsp.StatusCode < 200 || rsp.StatusCode > 299 {
+		return fmt.Errorf("webhook answered %s", rsp.Status)
+	}
+	return nil
+}
+
+// backoff returns how long to wait before the given attempt, doubling
+// the base delay every time (30s, 1m, 2m, 4m, ...)
+func (d *Dispatcher) backoff(attempt int) time.Duration {
+	return d.baseDelay * time.Duration(math.Pow(2, float64(attempt-1)))
+}
+
+// retry is scheduleRetry for the workers, which have no one to return
+// the error to
+func (d *Dispatcher) retry(delivery db.WebhookDelivery, sendErr error) {
+	if err := d.scheduleRetry(delivery, sendErr); err != nil {
+		slog.Error("Error queueing webhook retry", "error", err)
+	}
+}
+
+// scheduleRetry records a failed attempt and either puts the delivery back
+// on the retry queue or, once it is out of attempts, on the dead-letter list
+func (d *Dispatcher) scheduleRetry(delivery db.WebhookDelivery, sendErr error) error {
+	delivery.Attempts++
+	delivery.LastError = sendErr.Error()
+
+	if delivery.Attempts >= d.maxAttempts {
+		slog.Warn("Webhook delivery failed too many times, dead-lettering",
+			"id", delivery.Id, "webhookId", delivery.WebhookId, "attempts", delivery.Attempts)
+		return d.store.AddWebhookDeadLetter(delivery)
+	}
+
+	delivery.NextAttempt = time.Now().Add(d.backoff(delivery.Attempts))
+	return d.store.ScheduleWebhookRetry(delivery)
+}
+
+// ProcessRetries makes one pass over the retry queue, handing every
+// delivery that is due to the workers of its webhook with its current URL
+// and secret.  Deliveries for webhooks deleted in the meantime are dropped
+func (d *Dispatcher) ProcessRetries() error {
+	dueList, err := d.store.PopDueWebhookRetries(time.Now())
+	if err != nil {
+		return err
+	}
+
+	for _, delivery := range dueList {
+		webhook, err := d.store.GetWebhook(delivery.WebhookId)
+		if errors.Is(err, db.ErrNotFound) {
+			slog.Info("Dropping retry for deleted webhook", "id", delivery.Id, "webhookId", delivery.WebhookId)
+			continue
+		}
+		if err != nil {
+			d.retry(delivery, err)
+			continue
+		}
+		d.enqueue(webhook, delivery, nil)
+	}
+
+	return nil
+}
+
+// handle dispatches a batch of events and acknowledges them once their
+// deliveries were sent or are on the retry queue.  It does not wait for
+// that, the receivers can not hold up the next batch.  Events of a
+// replica that dies first stay pending and are delivered again
+func (d *Dispatcher) handle(entries []db.EventEntry) {
+	var batch sync.WaitGroup
+	ids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if err := d.dispatch(entry.Event, &batch); err != nil {
+			slog.Error("Error dispatching webhooks", "event", entry.Id, "error", err)
+			continue
+		}
+		ids = append(ids, entry.Id)
+	}
+
+	go func() {
+		batch.Wait()
+		if err := d.store.AckEvents(EventGroup, ids...); err != nil {
+			slog.Error("Error acknowledging webhook events", "error", err)
+		}
+	}()
+}
+
+// Run delivers events and processes the retry queue every retryInterval
+// until the context is cancelled.  Events this replica read before a
+// crash but never acknowledged are delivered first.  It is meant to be
+// started in its own go routine, the workers finish their queues before
+// it returns
+func (d *Dispatcher) Run(ctx context.Context, retryInterval time.Duration) {
+	defer d.Close()
+
+	if err := d.store.EnsureEventGroup(EventGroup); err != nil {
+		slog.Error("Error creating webhook event group, webhooks are not delivered", "error", err)
+		return
+	}
+
+	readFrom := "0"
+	lastRetry := time.Now()
+	for ctx.Err() == nil {
+		entries, err := d.store.ReadEventGroup(EventGroup, d.consumer, readFrom, retryInterval)
+		if err != nil {
+			slog.Error("Error reading webhook events", "error", err)
+			time.Sleep(retryInterval)
+			continue
+		}
+		//Pending events are only picked up once, an event that keeps
+		//failing must not keep us from the new ones
+		readFrom = ">"
+		d.handle(entries)
+
+		if time.Since(lastRetry) >= retryInterval {
+			if err := d.ProcessRetries(); err != nil {
+				slog.Error("Error processing webhook retries", "error", err)
+			}
+			lastRetry = time.Now()
+		}
+	}
+}
+
+// GenerateSecret returns a new random signing secret for a webhook
+func GenerateSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(secret), nil
+}